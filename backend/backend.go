@@ -2,8 +2,35 @@ package backend
 
 import (
 	"context"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+
+	"github.com/kballard/go-shellquote"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
 )
 
+// SeccompProfile is silo's seccomp profile, in the format of Docker's. It's
+// moby's default profile, an allowlist, with the syscalls an agent has no
+// need for and that reach into the kernel or other processes, such as
+// ptrace, mounting, namespaces, and BPF, removed from every rule allowing
+// them, even those that do only with a capability.
+//
+//go:embed seccomp.json
+var SeccompProfile []byte
+
 // Backend defines the interface for container/VM backends
 type Backend interface {
 	// Build prepares an environment for running tools (builds an image or creates a VM)
@@ -12,6 +39,10 @@ type Backend interface {
 	// ImageExists returns true if an image with the given name exists locally.
 	ImageExists(ctx context.Context, name string) (bool, error)
 
+	// ImageArch returns the CPU architecture of a local image, in GOARCH
+	// form (e.g. amd64), or "" if the backend can't tell.
+	ImageArch(ctx context.Context, name string) (string, error)
+
 	// NextContainerName returns the next sequential container name for the given
 	// base name. It lists existing containers with the same prefix and returns
 	// baseName-N where N is one more than the highest existing suffix.
@@ -25,23 +56,130 @@ type Backend interface {
 	// container is not found or not running.
 	Exec(ctx context.Context, name string, command []string) error
 
+	// Stop stops a running container without removing it.
+	Stop(ctx context.Context, name string) error
+
+	// Kill kills a running container straight away, without giving the
+	// tool time to exit.
+	Kill(ctx context.Context, name string) error
+
+	// Start starts a stopped container. If attach is true the terminal is
+	// attached to the container until it exits.
+	Start(ctx context.Context, name string, attach bool) error
+
+	// Attach attaches the terminal to a running container's main process.
+	Attach(ctx context.Context, name string) error
+
+	// Logs writes the output of a container to w. If follow is true it keeps
+	// streaming until the container exits or ctx is cancelled.
+	Logs(ctx context.Context, name string, follow bool, w io.Writer) error
+
 	// List returns all silo-created containers
 	List(ctx context.Context) ([]ContainerInfo, error)
 
 	// Remove removes specific containers by name
 	Remove(ctx context.Context, names []string) ([]string, error)
 
+	// ListImages returns all silo-built images
+	ListImages(ctx context.Context) ([]ImageInfo, error)
+
+	// RemoveImages removes images by name and returns the names removed
+	RemoveImages(ctx context.Context, names []string) ([]string, error)
+
+	// PushImage tags the local image name as ref and pushes it to ref's
+	// registry, returning the digest of the pushed manifest
+	PushImage(ctx context.Context, name, ref string) (digest string, err error)
+
+	// PullImage pulls ref from its registry and tags it as the local image
+	// name, returning the digest of the pulled manifest
+	PullImage(ctx context.Context, ref, name string) (digest string, err error)
+
+	// SaveImage writes the local image name, with its layers, to a tar
+	// file at path
+	SaveImage(ctx context.Context, name, path string) error
+
+	// LoadImage loads the images in a tar file written by SaveImage
+	LoadImage(ctx context.Context, path string) error
+
+	// ListVolumes returns all silo-created volumes
+	ListVolumes(ctx context.Context) ([]VolumeInfo, error)
+
+	// RemoveVolumes removes volumes by name and returns the names removed
+	RemoveVolumes(ctx context.Context, names []string) ([]string, error)
+
+	// PruneSidecars removes the containers and networks started alongside
+	// sessions whose container no longer runs, left behind when silo didn't
+	// exit cleanly, and returns their names. With dryRun nothing is
+	// removed.
+	PruneSidecars(ctx context.Context, dryRun bool) ([]string, error)
+
 	// Close releases any resources held by the backend
 	Close() error
 }
 
+// StopPolicy is how an attached session is stopped: the tool is sent
+// Signal, then killed if it hasn't exited after GracePeriod. Pressing Ctrl-C
+// three more times while it stops kills it.
+type StopPolicy struct {
+	Signal      string        // e.g. "SIGTERM"
+	GracePeriod time.Duration // 0 kills the tool straight away
+	Confirm     bool          // ask before stopping when Ctrl-C is pressed
+}
+
 // ContainerInfo holds information about a container
 type ContainerInfo struct {
 	Name        string
 	Image       string
 	Status      string
-	MemoryUsage uint64 // Memory usage in bytes (0 if not running/unavailable)
-	IsRunning   bool   // Whether container is currently running
+	MemoryUsage uint64    // Memory usage in bytes (0 if not running/unavailable)
+	IsRunning   bool      // Whether container is currently running
+	Created     time.Time // When the container was created (zero if unknown)
+
+	// Labels are the labels set on the container with RunOptions.Labels
+	Labels map[string]string
+}
+
+// Labels describing what a container is bound to.
+const (
+	LabelBranch   = "dev.silo.branch"   // git branch of the worktree the container runs in
+	LabelWorktree = "dev.silo.worktree" // path of the worktree the container runs in
+)
+
+// Labels describing the silo that created a container. The scripts run
+// inside the container, such as the mount wait script, are fixed when the
+// container is created, so a restarted container keeps the old behavior.
+const (
+	LabelVersion       = "dev.silo.version"        // silo version that created the container
+	LabelScriptVersion = "dev.silo.script-version" // version of the scripts run in the container
+)
+
+// LabelSession identifies the session that created a container, which its
+// run history entry records, as container names are reused once removed.
+const LabelSession = "dev.silo.session"
+
+// LabelSidecarOf is set on the containers and networks started alongside a
+// session's container, such as those of services, to its name, so those a
+// session leaves behind can be found.
+const LabelSidecarOf = "dev.silo.sidecar-of"
+
+// ImageInfo holds information about a silo-built image
+type ImageInfo struct {
+	Name    string    // Image name without tag (e.g., silo-claude-0123456789abcdef)
+	Size    int64     // Size in bytes (0 if unknown)
+	Created time.Time // When the image was built (zero if unknown)
+}
+
+// CacheVolumePrefix starts the names of volumes created for cache_volumes.
+const CacheVolumePrefix = "silo-cache-"
+
+// LabelCacheVolume is the volume label holding the cache_volumes name a
+// volume was created for.
+const LabelCacheVolume = "dev.silo.cache"
+
+// VolumeInfo holds information about a silo-created volume
+type VolumeInfo struct {
+	Name    string    // Volume name (starts with silo-)
+	Created time.Time // When the volume was created (zero if unknown)
 }
 
 // BuildOptions contains options for building/preparing an environment
@@ -67,8 +205,21 @@ type BuildOptions struct {
 	// OnProgress is called with build progress messages
 	OnProgress func(string)
 
+	// Secrets are the values of the build secrets, by id, mounted at
+	// /run/secrets/<id> for the RUN instructions that mount them. They are
+	// never stored in the image.
+	Secrets map[string]string
+
 	// NoCache disables build layer caching, forcing a complete rebuild
 	NoCache bool
+
+	// CacheFrom are images to pull and reuse layers from. Images that can't
+	// be pulled are skipped.
+	CacheFrom []string
+
+	// Platform is the platform to build for, e.g. linux/amd64, or "" for
+	// the backend's native platform
+	Platform string
 }
 
 // RunOptions contains options for running a command
@@ -88,6 +239,10 @@ type RunOptions struct {
 	// MountsRW are read-write mount paths
 	MountsRW []string
 
+	// MountsMapped are read-write mounts of a host path at a different path
+	// in the container, e.g. a copy of a tool's config directory
+	MountsMapped []Mount
+
 	// Env are environment variables in KEY=VALUE format
 	Env []string
 
@@ -99,4 +254,457 @@ type RunOptions struct {
 
 	// PreRunHooks are shell commands to run before the main command
 	PreRunHooks []string
+
+	// Resources limits the resources available to the container
+	Resources Resources
+
+	// Network controls the container's network access
+	Network Network
+
+	// Keep leaves the container in place when it exits so it can be
+	// restarted and reattached, instead of removing it.
+	Keep bool
+
+	// Detach starts the container in the background and returns without
+	// attaching the terminal. Detached containers are always kept.
+	Detach bool
+
+	// Labels are set on the container and reported by List
+	Labels map[string]string
+
+	// Ports are container ports published on the host
+	Ports []Port
+
+	// Tmpfs are container paths where an empty in-memory filesystem is
+	// mounted, writable by any user
+	Tmpfs []string
+
+	// ReadOnlyRootfs mounts the container's root filesystem read-only, so
+	// only mounts, tmpfs and volumes are writable
+	ReadOnlyRootfs bool
+
+	// Seccomp is the path of the container's seccomp profile, "unconfined",
+	// or "" for the runtime's default. Only the docker backend uses it.
+	Seccomp string
+
+	// AppArmor is the name of the container's AppArmor profile, loaded on
+	// the host, "unconfined", or "" for the runtime's default. Only the
+	// docker backend uses it.
+	AppArmor string
+
+	// Volumes are cache volumes that persist across sessions. Volumes that
+	// don't exist are created writable by any user.
+	Volumes []Mount
+
+	// MenuKey is the control character that opens the quick actions menu
+	// while attached, or 0 to disable the menu
+	MenuKey byte
+
+	// Output receives a copy of the session's output while the terminal is
+	// attached, e.g. to detect errors. Optional.
+	Output io.Writer
+
+	// OnStart is called once the container has started, before the
+	// pre-run hooks and the tool run. The container backend calls it as the
+	// container CLI starts to run the container. Optional.
+	OnStart func()
+
+	// Stop is how the session is stopped when Ctrl-C is pressed three times
+	// in a row, or silo is sent SIGINT or SIGTERM
+	Stop StopPolicy
+
+	// NoTTY runs an attached session without a TTY, for CI and pipes: silo's
+	// stdin is piped to the tool, and the tool's stdout and stderr to silo's
+	// separately. Pre-run hooks don't read stdin and write to stderr. It
+	// can't be combined with Keep or Detach.
+	NoTTY bool
+
+	// DockerInDocker starts a Docker daemon in the container, one of the
+	// DockerInDocker modes, or "" for none. The image must have Docker
+	// installed.
+	DockerInDocker string
+
+	// SELinuxLabel is the SELinux relabeling of bind mounts, one of the
+	// SELinuxLabel values, or "" for none. Only the docker backend uses it.
+	SELinuxLabel string
+
+	// Userns is the container's user namespace, "host" to run it outside
+	// the daemon's userns-remap, or "" for the daemon's setting. Only the
+	// docker backend uses it.
+	Userns string
+
+	// UID is the uid of the image's user when it isn't the host user's, or
+	// 0. The docker backend shares the working directory with it on Linux
+	// hosts, see docker.shareMounts.
+	UID int
+
+	// ConfirmShare asks the user whether to share the host directory dir
+	// with UID, as sharing it changes its ACLs. nil declines.
+	ConfirmShare func(dir string) bool
+
+	// SSHAgent is the path of the host's SSH agent socket to forward into
+	// the container, or "" for none. SSH_AUTH_SOCK in the container points
+	// at the forwarded socket.
+	SSHAgent string
+
+	// GPGAgent is the path of the host's gpg-agent extra socket to forward
+	// into the container, or "" for none. The extra socket signs with the
+	// agent's keys but can't export them. GPGKeyring are the host's public
+	// keyring files, mounted read-only alongside it.
+	GPGAgent   string
+	GPGKeyring []string
+
+	// Platform is the platform to run the image as, e.g. linux/amd64, or ""
+	// for the backend's native platform
+	Platform string
+
+	// Services are sidecar containers started before the container, on a
+	// network shared with it, and removed when it exits
+	Services []Service
+}
+
+// Service is a sidecar container of a session, reachable from the session's
+// container at the hostname Name.
+type Service struct {
+	Name        string
+	Image       string
+	Env         []string // KEY=VALUE
+	Healthcheck string   // shell command that succeeds once the service is ready, or ""
+}
+
+// SplitPlatform splits a platform such as linux/amd64 into its OS and
+// architecture.
+func SplitPlatform(platform string) (os, arch string) {
+	os, arch, _ = strings.Cut(platform, "/")
+	arch, _, _ = strings.Cut(arch, "/")
+	return os, arch
+}
+
+// Mount mounts a host path, or a volume, at a path in the container.
+type Mount struct {
+	Source string // path on the host, or volume name
+	Target string // path in the container
+}
+
+// MountLines describes mounts for display, one per line.
+func MountLines(mountsRO, mountsRW []string, mountsMapped []Mount, tmpfs []string, volumes []Mount) []string {
+	var lines []string
+	for _, m := range mountsRW {
+		lines = append(lines, "rw  "+m)
+	}
+	for _, m := range mountsMapped {
+		lines = append(lines, "rw  "+m.Target+" (copy)")
+	}
+	for _, m := range tmpfs {
+		lines = append(lines, "rw  "+m+" (tmpfs)")
+	}
+	for _, v := range volumes {
+		lines = append(lines, "rw  "+v.Target+" (volume "+v.Source+")")
+	}
+	for _, m := range mountsRO {
+		lines = append(lines, "ro  "+m)
+	}
+	return lines
+}
+
+// StartScript returns the bash script that runs hooks and then command, as
+// two phases. Each hook runs in turn in the script's shell, so what it
+// exports reaches command, and the first to fail stops the script with its
+// status after naming it, so a failed hook isn't taken for the tool exiting.
+// Command is only exec'd once it is found, and the script exits with 127
+// saying so otherwise. With noTTY, for a session without a TTY, hooks read
+// nothing from the session's stdin and write their output to stderr, so
+// piped input reaches the tool and the tool's stdout is only its own.
+func StartScript(hooks, command []string, noTTY bool) string {
+	var lines []string
+	if len(hooks) > 0 {
+		// The hook running is named on exit, as a hook may exit itself
+		lines = append(lines,
+			`__silo_hook_exit() { local s=$?; [ $s -eq 0 ] || [ -z "$__silo_hook" ] || printf '\nsilo: pre-run hook failed with status %d: %s\n' $s "$__silo_hook" >&2; }`,
+			"trap __silo_hook_exit EXIT")
+	}
+	redirect := ""
+	if noTTY {
+		redirect = " </dev/null >&2"
+	}
+	for _, hook := range hooks {
+		lines = append(lines, fmt.Sprintf("__silo_hook=%s; { %s\n}%s || exit", shellquote.Join(hookSummary(hook)), hook, redirect))
+	}
+	if len(hooks) > 0 {
+		lines = append(lines, "__silo_hook=; trap - EXIT")
+	}
+	if len(command) > 0 {
+		name := shellquote.Join(command[0])
+		lines = append(lines,
+			fmt.Sprintf(`command -v %s >/dev/null || { printf 'silo: cannot run %%s: command not found\n' %s >&2; exit 127; }`, name, name),
+			"exec "+shellquote.Join(command...))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// hookSummary returns the first line of hook, shortened, to name it in an
+// error.
+func hookSummary(hook string) string {
+	summary, _, multiline := strings.Cut(strings.TrimSpace(hook), "\n")
+	if r := []rune(summary); len(r) > 60 {
+		summary, multiline = string(r[:60]), true
+	}
+	if multiline {
+		summary += "..."
+	}
+	return summary
+}
+
+// ExitError is returned when a container's main process, or a command run
+// in a container, exits with a nonzero status. silo exits with Code.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("container exited with status %d", e.Code)
+}
+
+// NewExitError returns the ExitError of a host process, such as the
+// container CLI, that exited with err. A process killed by a signal has the
+// code a shell would give it, 128 plus the signal number.
+func NewExitError(err *exec.ExitError) *ExitError {
+	if status, ok := err.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return &ExitError{Code: 128 + int(status.Signal())}
+	}
+	return &ExitError{Code: err.ExitCode()}
+}
+
+// Port publishes a container port on the host.
+type Port struct {
+	HostIP        string // host address to listen on
+	HostPort      int    // port on the host
+	ContainerPort int    // port in the container
+	Protocol      string // "tcp" or "udp"
+}
+
+// String returns the port in docker's -p format, e.g. 127.0.0.1:8080:80/tcp.
+func (p Port) String() string {
+	ip := p.HostIP
+	if strings.Contains(ip, ":") {
+		ip = "[" + ip + "]"
+	}
+	return fmt.Sprintf("%s:%d:%d/%s", ip, p.HostPort, p.ContainerPort, p.Protocol)
+}
+
+// SELinuxLabel values, the bind mount options that relabel them.
+const (
+	SELinuxShared  = "z" // relabeled so any container can use the content
+	SELinuxPrivate = "Z" // relabeled so only this container can use the content
+)
+
+// DockerInDocker modes.
+const (
+	DockerInDockerRootful  = "rootful"  // dockerd run as root with sudo
+	DockerInDockerRootless = "rootless" // dockerd run as the user
+)
+
+// DockerStartHook returns a pre-run hook that starts a Docker daemon in the
+// container for a DockerInDocker mode, unless one is already running, and
+// points the Docker CLI at it. The daemon is backgrounded so it doesn't block
+// the hooks after it.
+func DockerStartHook(mode string) string {
+	switch mode {
+	case DockerInDockerRootful:
+		return `if [ ! -S /var/run/docker.sock ]; then sudo dockerd --iptables=false > /tmp/dockerd.log 2>&1 & fi`
+	case DockerInDockerRootless:
+		return `export XDG_RUNTIME_DIR="/tmp/docker-$(id -u)" && export DOCKER_HOST="unix://$XDG_RUNTIME_DIR/docker.sock" && mkdir -p "$XDG_RUNTIME_DIR" && if [ ! -S "$XDG_RUNTIME_DIR/docker.sock" ]; then dockerd-rootless.sh --iptables=false > /tmp/dockerd.log 2>&1 & fi`
+	}
+	return ""
+}
+
+// Network modes.
+const (
+	NetworkFull      = "full"
+	NetworkAllowlist = "allowlist"
+	NetworkNone      = "none"
+)
+
+// Network controls the network access of a container.
+type Network struct {
+	// Mode is NetworkFull, NetworkAllowlist, or NetworkNone. Empty means NetworkFull.
+	Mode string
+
+	// Allow are the hosts reachable in NetworkAllowlist mode. A leading "*."
+	// matches any subdomain.
+	Allow []string
+
+	// Join is a network the container joins in NetworkFull mode, reachable
+	// from other containers on it by DNSName(Name). SharedNetwork is created
+	// on demand; any other network must already exist.
+	Join string
+}
+
+// SharedNetwork is the network silo creates for sessions that need to reach
+// each other.
+const SharedNetwork = "silo"
+
+// DNSName returns the DNS alias for a container name: lowercased, with
+// characters not allowed in a hostname label replaced by '-', and truncated to
+// 63 characters.
+func DNSName(name string) string {
+	b := []byte(strings.ToLower(name))
+	for i, c := range b {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			b[i] = '-'
+		}
+	}
+	return strings.Trim(string(b[:min(len(b), 63)]), "-")
+}
+
+// maxBaseNameLen caps container base names so that with a "-N" suffix they
+// fit in a DNS label (see DNSName) and are valid on every backend.
+const maxBaseNameLen = 48
+
+// ContainerBaseName converts a directory name into the base of a container
+// name that is valid on every backend: lowercase ASCII letters, digits, and
+// single hyphens. Accents are removed from letters that have them. Names
+// that are shortened, or that have no ASCII letters or digits left, get a
+// short hash of the directory name so different directories stay distinct.
+func ContainerBaseName(dir string) string {
+	stripped, _, err := transform.String(transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), dir)
+	if err != nil {
+		stripped = dir
+	}
+	var b strings.Builder
+	prevHyphen := true // treat start as hyphen to strip leading hyphens
+	for _, r := range strings.ToLower(stripped) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			prevHyphen = false
+		} else if !prevHyphen {
+			b.WriteByte('-')
+			prevHyphen = true
+		}
+	}
+	s := strings.TrimRight(b.String(), "-")
+
+	sum := sha256.Sum256([]byte(dir))
+	hash := hex.EncodeToString(sum[:3])
+	switch {
+	case s == "" && strings.ContainsFunc(dir, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }):
+		return "silo-" + hash
+	case s == "":
+		return "silo"
+	case len(s) > maxBaseNameLen:
+		return strings.TrimRight(s[:maxBaseNameLen-len(hash)-1], "-") + "-" + hash
+	}
+	return s
+}
+
+// NextName returns baseName-N where N is one more than the highest suffix
+// of the existing container names with that base.
+func NextName(baseName string, existing []string) string {
+	maxNum := 0
+	for _, name := range existing {
+		suffix, ok := strings.CutPrefix(name, baseName+"-")
+		if !ok {
+			continue
+		}
+		if num, err := strconv.Atoi(suffix); err == nil && num > maxNum {
+			maxNum = num
+		}
+	}
+	return fmt.Sprintf("%s-%d", baseName, maxNum+1)
+}
+
+// IsPattern reports whether name is a glob pattern, such as myrepo-*,
+// rather than a container name.
+func IsPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[")
+}
+
+// MatchNames returns the names of the containers that are named by one of
+// args or match one of its glob patterns, in the order of containers. Only
+// the containers given can match, so callers pass the silo containers
+// listed by a backend and nothing else is ever selected.
+func MatchNames(args []string, containers []ContainerInfo) ([]string, error) {
+	for _, arg := range args {
+		if _, err := path.Match(arg, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", arg, err)
+		}
+	}
+	var names []string
+	for _, ctr := range containers {
+		if slices.ContainsFunc(args, func(arg string) bool {
+			ok, _ := path.Match(arg, ctr.Name)
+			return ok
+		}) {
+			names = append(names, ctr.Name)
+		}
+	}
+	return names, nil
+}
+
+// Resources limits the resources available to a container. Zero values use
+// the backend's defaults.
+type Resources struct {
+	// CPUs is the number of CPUs
+	CPUs float64
+
+	// MemoryBytes is the memory limit in bytes
+	MemoryBytes int64
+
+	// SwapBytes is the swap available in addition to MemoryBytes
+	SwapBytes int64
+
+	// PidsLimit is the maximum number of processes
+	PidsLimit int64
+
+	// Nofile is the limit on open files, soft and hard
+	Nofile int64
+
+	// InotifyWatches is the kernel's fs.inotify.max_user_watches. It can
+	// only be set where each container has its own kernel.
+	InotifyWatches int64
+}
+
+// LimitsHook returns a pre-run hook raising the open files limit to
+// r.Nofile, or as far as the hard limit allows, or if r.Nofile isn't set
+// raising the soft limit to the hard limit, and setting
+// fs.inotify.max_user_watches to r.InotifyWatches, if set, with the image's
+// silo-inotify-watches helper, for backends that can't set them when the
+// container is created. Failures are ignored.
+func LimitsHook(r Resources) string {
+	cmds := []string{`ulimit -Sn "$(ulimit -Hn)" 2>/dev/null`}
+	if r.Nofile > 0 {
+		cmds[0] = fmt.Sprintf(`{ ulimit -n %d || ulimit -Sn "$(ulimit -Hn)"; } 2>/dev/null`, r.Nofile)
+	}
+	if r.InotifyWatches > 0 {
+		cmds = append(cmds, fmt.Sprintf(`sudo -n /usr/local/sbin/silo-inotify-watches %d >/dev/null 2>&1`, r.InotifyWatches))
+	}
+	return "{ " + strings.Join(cmds, "; ") + "; true; }"
+}
+
+// Features are the silo features a backend supports.
+type Features struct {
+	FileMounts       bool // single files can be bind-mounted directly
+	Ports            bool // container ports can be published on the host
+	Stats            bool // memory usage is reported by silo ls
+	Exec             bool // commands can be run in running containers (silo exec, silo shell)
+	Persist          bool // containers can be kept and restarted (--keep, silo start)
+	Attach           bool // the terminal can be reattached to a running container
+	NetworkIsolation bool // network modes other than "full" are enforced
+	Services         bool // sidecar service containers can be started with a session
+}
+
+// Info describes whether a backend can be used on this machine.
+type Info struct {
+	// Available is true if the backend is installed and reachable
+	Available bool
+
+	// Version is the backend's version, empty if unknown
+	Version string
+
+	// Detail explains why the backend is unavailable
+	Detail string
+
+	// Retry is true if the backend is installed but not reachable yet, such
+	// as a daemon that is starting, so probing it again may find it available
+	Retry bool
 }
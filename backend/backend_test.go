@@ -0,0 +1,206 @@
+package backend
+
+import (
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestContainerBaseName(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"my-project", "my-project"},
+		{"My Project", "my-project"},
+		{"hello.world", "hello-world"},
+		{"foo  bar", "foo-bar"},
+		{"  leading", "leading"},
+		{"trailing  ", "trailing"},
+		{"a/b/c", "a-b-c"},
+		{"café", "cafe"},
+		{"Ångström Ünïcode", "angstrom-unicode"},
+		{"", "silo"},
+		{"...", "silo"},
+		{"my_project", "my-project"},
+		{"123", "123"},
+		{"MyProject", "myproject"},
+		{"日本語", "silo-77710a"},
+		{strings.Repeat("long-name", 10), "long-namelong-namelong-namelong-namelong-ac11c0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got := ContainerBaseName(tt.input)
+			if got != tt.want {
+				t.Errorf("ContainerBaseName(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+			if len(got) > maxBaseNameLen {
+				t.Errorf("ContainerBaseName(%q) is %d characters, want at most %d", tt.input, len(got), maxBaseNameLen)
+			}
+		})
+	}
+
+	// Directories that only differ in characters that are dropped get
+	// different names
+	if a, b := ContainerBaseName("日本語"), ContainerBaseName("中文"); a == b {
+		t.Errorf("expected different names, both are %q", a)
+	}
+}
+
+func TestNextName(t *testing.T) {
+	tests := []struct {
+		existing []string
+		want     string
+	}{
+		{nil, "proj-1"},
+		{[]string{"proj-1", "proj-3", "other-9"}, "proj-4"},
+		// Names with the base as a prefix but another suffix don't count
+		{[]string{"proj-2-feature", "proj-x-5", "proj"}, "proj-1"},
+	}
+	for _, tt := range tests {
+		if got := NextName("proj", tt.existing); got != tt.want {
+			t.Errorf("NextName(%q) = %q, want %q", tt.existing, got, tt.want)
+		}
+	}
+}
+
+func TestMatchNames(t *testing.T) {
+	containers := []ContainerInfo{{Name: "myrepo-1"}, {Name: "myrepo-12"}, {Name: "myrepo-feature-1"}, {Name: "other-1"}}
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"myrepo-1"}, []string{"myrepo-1"}},
+		{[]string{"myrepo-*"}, []string{"myrepo-1", "myrepo-12", "myrepo-feature-1"}},
+		{[]string{"myrepo-?"}, []string{"myrepo-1"}},
+		{[]string{"myrepo-[0-9]*", "other-1"}, []string{"myrepo-1", "myrepo-12", "other-1"}},
+		// Names that aren't silo containers never match
+		{[]string{"unknown-1", "x*"}, nil},
+	}
+	for _, tt := range tests {
+		got, err := MatchNames(tt.args, containers)
+		if err != nil {
+			t.Fatalf("MatchNames(%q): %v", tt.args, err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("MatchNames(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	if _, err := MatchNames([]string{"myrepo-["}, containers); err == nil {
+		t.Error("MatchNames() with a malformed pattern: expected an error")
+	}
+	if !IsPattern("myrepo-*") || IsPattern("myrepo-1") {
+		t.Error("IsPattern() misclassified a name")
+	}
+}
+
+func TestLimitsHook(t *testing.T) {
+	if got, want := LimitsHook(Resources{}), `{ ulimit -Sn "$(ulimit -Hn)" 2>/dev/null; true; }`; got != want {
+		t.Errorf("LimitsHook() = %q, want %q without limits", got, want)
+	}
+	got := LimitsHook(Resources{Nofile: 4096, InotifyWatches: 65536})
+	for _, want := range []string{"ulimit -n 4096", "silo-inotify-watches 65536", "; true; }"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("LimitsHook() = %q, missing %q", got, want)
+		}
+	}
+}
+
+func TestNewExitError(t *testing.T) {
+	tests := []struct {
+		script string
+		want   int
+	}{
+		{"exit 3", 3},
+		{"kill -KILL $$", 137},
+	}
+	for _, tt := range tests {
+		var exitErr *exec.ExitError
+		if err := exec.Command("/bin/sh", "-c", tt.script).Run(); !errors.As(err, &exitErr) {
+			t.Fatalf("%q: expected an exit error, got %v", tt.script, err)
+		}
+		if got := NewExitError(exitErr).Code; got != tt.want {
+			t.Errorf("%q: got code %d, want %d", tt.script, got, tt.want)
+		}
+	}
+}
+
+// runScript runs script with bash and stdin, returning its outputs and
+// exit code.
+func runScript(t *testing.T, script, stdin string) (stdout, stderr string, code int) {
+	t.Helper()
+	cmd := exec.Command("/bin/bash", "-c", script)
+	cmd.Stdin = strings.NewReader(stdin)
+	var out, errOut strings.Builder
+	cmd.Stdout = &out
+	cmd.Stderr = &errOut
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			t.Fatal(err)
+		}
+		code = exitErr.ExitCode()
+	}
+	return out.String(), errOut.String(), code
+}
+
+func TestStartScript(t *testing.T) {
+	// What a hook exports reaches the command
+	stdout, _, code := runScript(t, StartScript([]string{"export GREETING=hi # comment", "echo hook"}, []string{"printenv", "GREETING"}, false), "")
+	if stdout != "hook\nhi\n" || code != 0 {
+		t.Errorf("got stdout %q and code %d, want the hook's output then the exported variable", stdout, code)
+	}
+
+	// Without a TTY, hooks read nothing of the input and write to stderr,
+	// leaving both to the tool
+	stdout, stderr, _ := runScript(t, StartScript([]string{"echo hook", "cat"}, []string{"cat"}, true), "input")
+	if stdout != "input" || stderr != "hook\n" {
+		t.Errorf("got stdout %q and stderr %q, want the input on stdout and the hooks' output on stderr", stdout, stderr)
+	}
+
+	// A failing hook stops the script with its status, naming the hook
+	stdout, stderr, code = runScript(t, StartScript([]string{"true", "echo failing\nexit 3", "echo after"}, []string{"echo", "tool"}, false), "")
+	if stdout != "failing\n" || code != 3 || !strings.Contains(stderr, "pre-run hook failed with status 3: echo failing...") {
+		t.Errorf("got stdout %q, stderr %q, and code %d, want the script stopped at the failing hook", stdout, stderr, code)
+	}
+
+	// A command that isn't installed isn't run
+	_, stderr, code = runScript(t, StartScript([]string{"true"}, []string{"silo-no-such-tool", "--flag"}, false), "")
+	if code != 127 || !strings.Contains(stderr, "cannot run silo-no-such-tool: command not found") {
+		t.Errorf("got stderr %q and code %d, want the missing command reported", stderr, code)
+	}
+
+	// Hooks alone run without a command
+	if stdout, _, code := runScript(t, StartScript([]string{"echo hook"}, nil, false), ""); stdout != "hook\n" || code != 0 {
+		t.Errorf("got stdout %q and code %d, want the hook run", stdout, code)
+	}
+}
+
+func TestSeccompProfile(t *testing.T) {
+	var profile struct {
+		DefaultAction string            `json:"defaultAction"`
+		ArchMap       []json.RawMessage `json:"archMap"`
+		Syscalls      []struct {
+			Names  []string `json:"names"`
+			Action string   `json:"action"`
+		} `json:"syscalls"`
+	}
+	if err := json.Unmarshal(SeccompProfile, &profile); err != nil {
+		t.Fatal(err)
+	}
+	if profile.DefaultAction != "SCMP_ACT_ERRNO" || len(profile.ArchMap) == 0 {
+		t.Errorf("defaultAction = %q with %d architectures, want an allowlist for every architecture", profile.DefaultAction, len(profile.ArchMap))
+	}
+	for _, s := range profile.Syscalls {
+		for _, name := range []string{"ptrace", "mount", "unshare", "setns", "bpf", "name_to_handle_at", "process_vm_readv"} {
+			if s.Action == "SCMP_ACT_ALLOW" && slices.Contains(s.Names, name) {
+				t.Errorf("%s is allowed", name)
+			}
+		}
+	}
+}
@@ -10,11 +10,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
+	"maps"
+	"math"
 	"os"
 	"os/exec"
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -22,16 +27,14 @@ import (
 
 	"golang.org/x/sys/unix"
 
-	"github.com/adrg/xdg"
 	"github.com/creack/pty"
 	"github.com/kballard/go-shellquote"
 	"github.com/leighmcculloch/silo/backend" // parent package
+	"github.com/leighmcculloch/silo/backend/termproxy"
+	"github.com/leighmcculloch/silo/statedir"
+	"github.com/leighmcculloch/silo/termstate"
 )
 
-// dockerStartHook is a pre-run hook that starts the Docker daemon in the VM.
-// It checks if Docker is already running and starts it if not.
-const dockerStartHook = `if [ ! -S /var/run/docker.sock ]; then sudo dockerd --iptables=false > /tmp/dockerd.log 2>&1 & fi`
-
 // Client implements backend.Backend using the Apple container CLI.
 type Client struct{}
 
@@ -43,6 +46,44 @@ func NewClient() (*Client, error) {
 	return &Client{}, nil
 }
 
+// Features are the silo features supported by the container backend. Files
+// can't be bind-mounted directly so they are staged into directories.
+var Features = backend.Features{
+	Ports:   true,
+	Stats:   true,
+	Exec:    true,
+	Persist: true,
+}
+
+// Probe reports whether the container CLI is installed and its system
+// service is running, and the CLI's version.
+func Probe(ctx context.Context) backend.Info {
+	if _, err := exec.LookPath("container"); err != nil {
+		return backend.Info{Detail: "container command not found (install with: brew install container)"}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var info backend.Info
+	if out, err := exec.CommandContext(ctx, "container", "--version").Output(); err == nil {
+		// e.g. "container CLI version 0.5.0 (build: release, commit: abc)"
+		fields := strings.Fields(string(out))
+		for i, f := range fields {
+			if f == "version" && i+1 < len(fields) {
+				info.Version = fields[i+1]
+			}
+		}
+	}
+	if err := exec.CommandContext(ctx, "container", "system", "status").Run(); err != nil {
+		info.Detail = "container system service not running (start with: container system start)"
+		info.Retry = true
+		return info
+	}
+	info.Available = true
+	return info
+}
+
 // Close is a no-op for the CLI backend.
 func (c *Client) Close() error {
 	return nil
@@ -66,8 +107,54 @@ func (c *Client) ImageExists(ctx context.Context, name string) (bool, error) {
 	return true, nil
 }
 
+// ImageArch returns the CPU architecture of a local image, or "" if it
+// has variants for several architectures.
+func (c *Client) ImageArch(ctx context.Context, name string) (string, error) {
+	output, err := exec.CommandContext(ctx, "container", "image", "inspect", name).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", name, err)
+	}
+	var images []struct {
+		Variants []struct {
+			Platform struct {
+				OS           string `json:"os"`
+				Architecture string `json:"architecture"`
+			} `json:"platform"`
+		} `json:"variants"`
+	}
+	if err := json.Unmarshal(output, &images); err != nil {
+		return "", fmt.Errorf("failed to parse image %s: %w", name, err)
+	}
+	var arch string
+	for _, img := range images {
+		for _, v := range img.Variants {
+			if v.Platform.OS != "linux" {
+				continue
+			}
+			if arch != "" && arch != v.Platform.Architecture {
+				return "", nil
+			}
+			arch = v.Platform.Architecture
+		}
+	}
+	return arch, nil
+}
+
+// platformArgs returns the container CLI flags selecting platform.
+func platformArgs(platform string) []string {
+	if platform == "" {
+		return nil
+	}
+	goos, arch := backend.SplitPlatform(platform)
+	return []string{"--os", goos, "--arch", arch}
+}
+
 // Build builds a container image using the container CLI.
 func (c *Client) Build(ctx context.Context, opts backend.BuildOptions) (string, error) {
+	if len(opts.Secrets) > 0 {
+		return "", fmt.Errorf("build_secrets are not supported by the container backend, use the docker backend")
+	}
+
 	// Write Dockerfile to a temp dir as the build context
 	tmpDir, err := os.MkdirTemp("", "silo-build-*")
 	if err != nil {
@@ -98,6 +185,7 @@ func (c *Client) Build(ctx context.Context, opts backend.BuildOptions) (string,
 	if opts.Target != "" {
 		args = append(args, "--target", opts.Target)
 	}
+	args = append(args, platformArgs(opts.Platform)...)
 
 	for k, v := range opts.BuildArgs {
 		args = append(args, "--build-arg", k+"="+v)
@@ -105,6 +193,7 @@ func (c *Client) Build(ctx context.Context, opts backend.BuildOptions) (string,
 
 	args = append(args, tmpDir)
 
+	slog.Debug("Running the container CLI", "args", args)
 	cmd := exec.Command("container", args...)
 
 	// Use a pty to make the container CLI think it's connected to a terminal,
@@ -170,11 +259,142 @@ func (c *Client) Build(ctx context.Context, opts backend.BuildOptions) (string,
 	return tag, nil
 }
 
+// stopArgs returns the container arguments stopping the container name with
+// policy.
+func stopArgs(name string, policy backend.StopPolicy) []string {
+	args := []string{"stop"}
+	if policy.Signal != "" {
+		args = append(args, "--signal", policy.Signal)
+	}
+	args = append(args, "--time", strconv.Itoa(int(policy.GracePeriod/time.Second)), name)
+	return args
+}
+
+// runFlags returns the container run flags for the options of opts that map
+// directly onto one.
+func runFlags(opts backend.RunOptions) []string {
+	args := []string{"run", "-i"}
+	if !opts.NoTTY {
+		args = append(args, "-t")
+	}
+	if opts.Detach {
+		args = append(args, "--detach")
+	} else if !opts.Keep {
+		args = append(args, "--rm")
+	}
+	args = append(args, runResourceArgs(opts.Resources)...)
+
+	if opts.Name != "" {
+		args = append(args, "--name", opts.Name)
+	}
+
+	if opts.WorkDir != "" {
+		args = append(args, "-w", opts.WorkDir)
+	}
+
+	for _, k := range slices.Sorted(maps.Keys(opts.Labels)) {
+		args = append(args, "--label", k+"="+opts.Labels[k])
+	}
+
+	for _, p := range opts.Ports {
+		args = append(args, "--publish", p.String())
+	}
+
+	// The CLI forwards the agent of SSH_AUTH_SOCK and sets it in the
+	// container
+	if opts.SSHAgent != "" {
+		args = append(args, "--ssh")
+	}
+	return append(args, platformArgs(opts.Platform)...)
+}
+
+// preRunHooks returns the pre-run hooks followed by those raising the
+// resource limits, which the container CLI can't set, and starting the
+// Docker daemon. They come after the mount-wait and other hooks, and dockerd
+// is backgrounded (& in the hook) so it doesn't block.
+func preRunHooks(opts backend.RunOptions) []string {
+	hooks := slices.Clip(opts.PreRunHooks)
+	if hook := backend.LimitsHook(opts.Resources); hook != "" {
+		hooks = append(hooks, hook)
+	}
+	if opts.DockerInDocker != "" {
+		hooks = append(hooks, backend.DockerStartHook(opts.DockerInDocker))
+	}
+	return hooks
+}
+
+// RunCommand returns a container CLI command equivalent to Run with opts,
+// for showing what a run would do. Environment variables are passed by name
+// only, so their values, which may be secrets, aren't shown. File mounts are
+// shown as bind mounts of the file, where Run stages each in a directory and
+// symlinks it into place.
+func RunCommand(opts backend.RunOptions) []string {
+	opts.PreRunHooks = preRunHooks(opts)
+
+	args := append([]string{"container"}, runFlags(opts)...)
+	for _, e := range opts.Env {
+		name, _, _ := strings.Cut(e, "=")
+		args = append(args, "-e", name)
+	}
+	for _, m := range opts.MountsRO {
+		if _, err := os.Lstat(m); err == nil {
+			args = append(args, "--mount", fmt.Sprintf("type=bind,source=%s,target=%s,readonly", m, m))
+		}
+	}
+	for _, m := range opts.MountsRW {
+		if _, err := os.Lstat(m); err == nil {
+			args = append(args, "--mount", fmt.Sprintf("type=bind,source=%s,target=%s", m, m))
+		}
+	}
+	for _, m := range opts.MountsMapped {
+		if _, err := os.Stat(m.Source); err == nil {
+			args = append(args, "--mount", fmt.Sprintf("type=bind,source=%s,target=%s", m.Source, m.Target))
+		}
+	}
+	for _, p := range opts.Tmpfs {
+		args = append(args, "--tmpfs", p)
+	}
+	for _, v := range opts.Volumes {
+		args = append(args, "--volume", v.Source+":"+v.Target)
+	}
+
+	fullCmd := append(slices.Clip(opts.Command), opts.Args...)
+	switch {
+	case len(opts.PreRunHooks) > 0:
+		args = append(args, "--entrypoint", "/bin/bash", opts.Image, "-c", backend.StartScript(opts.PreRunHooks, fullCmd, opts.NoTTY))
+	case len(fullCmd) > 0:
+		args = append(args, "--entrypoint", fullCmd[0], opts.Image)
+		args = append(args, fullCmd[1:]...)
+	default:
+		args = append(args, opts.Image)
+	}
+	return args
+}
+
 // Run runs a container using the container CLI.
 func (c *Client) Run(ctx context.Context, opts backend.RunOptions) error {
-	// Append Docker daemon startup hook so mount-wait and other hooks run first.
-	// dockerd is already backgrounded (& in the hook) so it doesn't block.
-	opts.PreRunHooks = append(opts.PreRunHooks, dockerStartHook)
+	// The container CLI has no internal networks or way to disable
+	// networking, so isolation can't be enforced.
+	if opts.Network.Mode != "" && opts.Network.Mode != backend.NetworkFull {
+		return fmt.Errorf("network %q is not supported by the container backend, use the docker backend", opts.Network.Mode)
+	}
+	if opts.Network.Join != "" {
+		return fmt.Errorf("network_join is not supported by the container backend, use the docker backend")
+	}
+	if len(opts.Services) > 0 {
+		return fmt.Errorf("services are not supported by the container backend, use the docker backend")
+	}
+	// Only the SSH agent can be forwarded, with --ssh
+	if opts.GPGAgent != "" {
+		return fmt.Errorf("commit_signing gpg is not supported by the container backend, use commit_signing ssh")
+	}
+	// File mounts are linked into place in the root filesystem when the
+	// container starts
+	if opts.ReadOnlyRootfs {
+		return fmt.Errorf("read_only_rootfs is not supported by the container backend, use the docker backend")
+	}
+
+	opts.PreRunHooks = preRunHooks(opts)
 
 	// Build full command: Command + Args
 	fullCmd := append(opts.Command, opts.Args...)
@@ -184,48 +404,30 @@ func (c *Client) Run(ctx context.Context, opts backend.RunOptions) error {
 	var runArgs []string
 
 	if len(fullCmd) > 0 {
-		if len(opts.PreRunHooks) > 0 {
-			// Create a bash script that runs pre-run hooks then execs the command
-			var script strings.Builder
-			for _, hook := range opts.PreRunHooks {
-				script.WriteString(hook)
-				script.WriteString(" && ")
-			}
-			script.WriteString("exec ")
-			script.WriteString(shellquote.Join(fullCmd...))
-			entrypoint = "/bin/bash"
-			runArgs = []string{"-c", script.String()}
-		} else {
-			entrypoint = fullCmd[0]
-			if len(fullCmd) > 1 {
-				runArgs = fullCmd[1:]
-			}
+		entrypoint = fullCmd[0]
+		if len(fullCmd) > 1 {
+			runArgs = fullCmd[1:]
 		}
 	}
 
-	args := []string{"run",
-		"--rm",
-		"-i",
-		"-t",
-	}
-	args = append(args, resourceArgs()...)
-
-	if opts.Name != "" {
-		args = append(args, "--name", opts.Name)
-	}
-
-	if opts.WorkDir != "" {
-		args = append(args, "-w", opts.WorkDir)
-	}
+	args := runFlags(opts)
 
-	for _, e := range opts.Env {
-		args = append(args, "-e", e)
+	// Pass the environment in a private file rather than on the command line,
+	// where secret values would be visible to other processes.
+	if len(opts.Env) > 0 {
+		envFile, err := writeEnvFile(opts.Env)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(envFile)
+		args = append(args, "--env-file", envFile)
 	}
 
 	// Mounts — Apple's container CLI only supports directories, so file
 	// mounts are staged into a directory and symlinked inside the container.
 	type fileMount struct {
-		path         string
+		path         string // path on the host
+		target       string // path in the container
 		readOnly     bool
 		hostDir      string
 		containerDir string
@@ -246,7 +448,7 @@ func (c *Client) Run(ctx context.Context, opts backend.RunOptions) error {
 		if info.IsDir() {
 			args = append(args, "--mount", fmt.Sprintf("type=bind,source=%s,target=%s,readonly", m, m))
 		} else {
-			fileMounts = append(fileMounts, &fileMount{path: m, readOnly: true})
+			fileMounts = append(fileMounts, &fileMount{path: m, target: m, readOnly: true})
 		}
 	}
 	for _, m := range opts.MountsRW {
@@ -260,10 +462,31 @@ func (c *Client) Run(ctx context.Context, opts backend.RunOptions) error {
 		if info.IsDir() {
 			args = append(args, "--mount", fmt.Sprintf("type=bind,source=%s,target=%s", m, m))
 		} else {
-			fileMounts = append(fileMounts, &fileMount{path: m, readOnly: false})
+			fileMounts = append(fileMounts, &fileMount{path: m, target: m, readOnly: false})
+		}
+	}
+	for _, m := range opts.MountsMapped {
+		info, err := os.Stat(m.Source)
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			args = append(args, "--mount", fmt.Sprintf("type=bind,source=%s,target=%s", m.Source, m.Target))
+		} else {
+			fileMounts = append(fileMounts, &fileMount{path: m.Source, target: m.Target})
 		}
 	}
 
+	for _, p := range opts.Tmpfs {
+		args = append(args, "--tmpfs", p)
+	}
+	if err := ensureVolumes(ctx, opts.Image, opts.Volumes); err != nil {
+		return err
+	}
+	for _, v := range opts.Volumes {
+		args = append(args, "--volume", v.Source+":"+v.Target)
+	}
+
 	// Stage file mounts concurrently.
 	var fmWg sync.WaitGroup
 	for _, fm := range fileMounts {
@@ -285,9 +508,9 @@ func (c *Client) Run(ctx context.Context, opts backend.RunOptions) error {
 		}
 		args = append(args, "--mount", mountOpt)
 		symlinkCmds = append(symlinkCmds, fmt.Sprintf("mkdir -p %s && ln -sf %s %s",
-			shellquote.Join(filepath.Dir(fm.path)),
+			shellquote.Join(filepath.Dir(fm.target)),
 			shellquote.Join(filepath.Join(fm.containerDir, filepath.Base(fm.path))),
-			shellquote.Join(fm.path),
+			shellquote.Join(fm.target),
 		))
 	}
 
@@ -298,28 +521,11 @@ func (c *Client) Run(ctx context.Context, opts backend.RunOptions) error {
 	allPreRunHooks = append(allPreRunHooks, symlinkCmds...)
 	allPreRunHooks = append(allPreRunHooks, opts.PreRunHooks...)
 
-	// Rebuild entrypoint to include all hooks if we have any
-	if len(allPreRunHooks) > 0 && len(fullCmd) > 0 {
-		var script strings.Builder
-		for _, hook := range allPreRunHooks {
-			script.WriteString(hook)
-			script.WriteString(" && ")
-		}
-		script.WriteString("exec ")
-		script.WriteString(shellquote.Join(fullCmd...))
-		entrypoint = "/bin/bash"
-		runArgs = []string{"-c", script.String()}
-	} else if len(allPreRunHooks) > 0 {
-		// No command — just run the hooks.
-		var script strings.Builder
-		for i, hook := range allPreRunHooks {
-			if i > 0 {
-				script.WriteString(" && ")
-			}
-			script.WriteString(hook)
-		}
+	// Rebuild entrypoint to include all hooks if we have any, the tool
+	// started only once they have run
+	if len(allPreRunHooks) > 0 {
 		entrypoint = "/bin/bash"
-		runArgs = []string{"-c", script.String()}
+		runArgs = []string{"-c", backend.StartScript(allPreRunHooks, fullCmd, opts.NoTTY)}
 	}
 
 	if entrypoint != "" {
@@ -332,107 +538,61 @@ func (c *Client) Run(ctx context.Context, opts backend.RunOptions) error {
 	// Command arguments
 	args = append(args, runArgs...)
 
-	cmd := exec.Command("container", args...)
-
-	// Save terminal state and ensure it's restored on exit
-	fd := int(os.Stdin.Fd())
-	oldState, _ := unix.IoctlGetTermios(fd, unix.TIOCGETA)
-	defer func() {
-		// Restore termios state
-		if oldState != nil {
-			unix.IoctlSetTermios(fd, unix.TIOCSETA, oldState)
+	if opts.Detach {
+		if out, err := exec.CommandContext(ctx, "container", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to start container: %s", strings.TrimSpace(string(out)))
 		}
-		// Reset terminal modes (mouse tracking, alternate screen, etc.)
-		// These are escape sequences not covered by termios
-		os.Stdout.WriteString("\x1b[?1000l") // Disable mouse click tracking
-		os.Stdout.WriteString("\x1b[?1002l") // Disable mouse button tracking
-		os.Stdout.WriteString("\x1b[?1003l") // Disable all mouse tracking
-		os.Stdout.WriteString("\x1b[?1006l") // Disable SGR mouse mode
-		os.Stdout.WriteString("\x1b[?25h")   // Show cursor
-		os.Stdout.WriteString("\x1b[?1049l") // Exit alternate screen buffer
-	}()
-
-	// Start command with PTY so container gets a real terminal
-	ptmx, err := pty.Start(cmd)
-	if err != nil {
-		return fmt.Errorf("failed to start container: %w", err)
-	}
-	defer ptmx.Close()
-
-	// Handle terminal resize
-	ch := make(chan os.Signal, 1)
-	signal.Notify(ch, syscall.SIGWINCH)
-	go func() {
-		for range ch {
-			pty.InheritSize(os.Stdin, ptmx)
+		if opts.OnStart != nil {
+			opts.OnStart()
 		}
-	}()
-	ch <- syscall.SIGWINCH // Initial resize
-	defer signal.Stop(ch)
-
-	// Put our terminal in raw mode
-	if oldState != nil {
-		newState := *oldState
-		newState.Lflag &^= unix.ICANON | unix.ECHO | unix.ISIG | unix.IEXTEN
-		newState.Iflag &^= unix.IXON | unix.ICRNL
-		unix.IoctlSetTermios(fd, unix.TIOCSETA, &newState)
+		return nil
 	}
 
-	// On signal or context cancellation, force-remove the container
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	defer signal.Stop(sigCh)
+	cmd := exec.Command("container", args...)
+	if opts.OnStart != nil {
+		opts.OnStart()
+	}
 
-	go func() {
-		select {
-		case <-sigCh:
-		case <-ctx.Done():
+	// On signal, Ctrl-C pressed three times, or context cancellation, stop
+	// the container with the stop policy. Containers that aren't kept are
+	// removed as they stop.
+	stop := func() {
+		if opts.Name != "" {
+			exec.Command("container", stopArgs(opts.Name, opts.Stop)...).Run()
 		}
+	}
+	kill := func() {
 		if opts.Name != "" {
-			exec.Command("container", "rm", "-f", opts.Name).Run()
+			exec.Command("container", "kill", opts.Name).Run()
 		}
-	}()
-
-	// Copy container output to stdout
-	go func() {
-		io.Copy(os.Stdout, ptmx)
-	}()
+	}
 
-	// Copy stdin to container, intercepting double Ctrl-C to kill
-	go func() {
-		var lastCtrlC time.Time
-		buf := make([]byte, 256)
-		for {
-			n, err := os.Stdin.Read(buf)
-			if n > 0 {
-				// Check for Ctrl-C (0x03)
-				for i := 0; i < n; i++ {
-					if buf[i] == 0x03 {
-						now := time.Now()
-						if now.Sub(lastCtrlC) < time.Second {
-							// Double Ctrl-C - kill container
-							if opts.Name != "" {
-								exec.Command("container", "rm", "-f", opts.Name).Run()
-							}
-							return
-						}
-						lastCtrlC = now
-					}
-				}
-				ptmx.Write(buf[:n])
-			}
-			if err != nil {
-				break
+	if opts.NoTTY {
+		if err := runPiped(ctx, cmd, stop, opts.Output); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				return backend.NewExitError(exitErr)
 			}
+			return fmt.Errorf("container error: %w", err)
 		}
-	}()
+		return nil
+	}
 
-	waitErr := cmd.Wait()
-	if waitErr != nil {
-		if exitErr, ok := waitErr.(*exec.ExitError); ok {
-			return fmt.Errorf("container exited with status %d", exitErr.ExitCode())
+	var menu *termproxy.Menu
+	if opts.MenuKey != 0 {
+		menu = &termproxy.Menu{
+			Key:    opts.MenuKey,
+			Name:   opts.Name,
+			Mounts: backend.MountLines(opts.MountsRO, opts.MountsRW, opts.MountsMapped, opts.Tmpfs, opts.Volumes),
+			Dir:    opts.WorkDir,
+			Stop:   stop,
 		}
-		return fmt.Errorf("container error: %w", waitErr)
+	}
+
+	if err := runTTY(ctx, cmd, stop, kill, opts.Stop.Confirm, menu, opts.Output); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return backend.NewExitError(exitErr)
+		}
+		return fmt.Errorf("container error: %w", err)
 	}
 
 	return nil
@@ -458,20 +618,11 @@ func (c *Client) NextContainerName(ctx context.Context, baseName string) string
 		return fmt.Sprintf("%s-1", baseName)
 	}
 
-	maxNum := 0
-	prefix := baseName + "-"
+	var names []string
 	for _, ctr := range containers {
-		if suffix, ok := strings.CutPrefix(ctr.Configuration.ID, prefix); ok {
-			var num int
-			if _, err := fmt.Sscanf(suffix, "%d", &num); err == nil {
-				if num > maxNum {
-					maxNum = num
-				}
-			}
-		}
+		names = append(names, ctr.Configuration.ID)
 	}
-
-	return fmt.Sprintf("%s-%d", baseName, maxNum+1)
+	return backend.NextName(baseName, names)
 }
 
 // List returns all silo-created containers (those with silo- image prefix)
@@ -488,6 +639,7 @@ func (c *Client) List(ctx context.Context) ([]backend.ContainerInfo, error) {
 			Image struct {
 				Reference string `json:"reference"`
 			} `json:"image"`
+			Labels map[string]string `json:"labels"`
 		} `json:"configuration"`
 		Status string `json:"status"`
 	}
@@ -513,6 +665,7 @@ func (c *Client) List(ctx context.Context) ([]backend.ContainerInfo, error) {
 					Image:     ctr.Configuration.Image.Reference,
 					Status:    ctr.Status,
 					IsRunning: isRunning,
+					Labels:    ctr.Configuration.Labels,
 				},
 				id:        ctr.Configuration.ID,
 				isRunning: isRunning,
@@ -627,26 +780,136 @@ func (c *Client) Exec(ctx context.Context, name string, command []string) error
 	args = append(args, command...)
 	cmd := exec.Command("container", args...)
 
+	// On signal, Ctrl-C pressed three times, or context cancellation, kill
+	// the exec process
+	kill := func() {
+		if cmd.Process != nil {
+			cmd.Process.Kill()
+		}
+	}
+
+	if err := runTTY(ctx, cmd, kill, kill, false, nil, nil); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			// Killed by signal (e.g. Ctrl-C) is not an error
+			if exitErr.ExitCode() == -1 {
+				return nil
+			}
+			return backend.NewExitError(exitErr)
+		}
+		return fmt.Errorf("exec error: %w", err)
+	}
+
+	return nil
+}
+
+// Logs writes the output of a container to w, optionally following it.
+func (c *Client) Logs(ctx context.Context, name string, follow bool, w io.Writer) error {
+	if _, err := c.status(ctx, name); err != nil {
+		return err
+	}
+	args := []string{"logs"}
+	if follow {
+		args = append(args, "--follow")
+	}
+	args = append(args, name)
+	cmd := exec.CommandContext(ctx, "container", args...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	if err := cmd.Run(); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to get logs for %s: %w", name, err)
+	}
+	return nil
+}
+
+// Stop stops a running container without removing it.
+func (c *Client) Stop(ctx context.Context, name string) error {
+	if err := c.verifyRunning(ctx, name); err != nil {
+		return err
+	}
+	if out, err := exec.CommandContext(ctx, "container", "stop", name).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to stop container %s: %s", name, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Kill kills a running container straight away.
+func (c *Client) Kill(ctx context.Context, name string) error {
+	if err := c.verifyRunning(ctx, name); err != nil {
+		return err
+	}
+	if out, err := exec.CommandContext(ctx, "container", "kill", name).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to kill container %s: %s", name, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Start starts a stopped container. With attach, the terminal is attached
+// to the container until it exits.
+func (c *Client) Start(ctx context.Context, name string, attach bool) error {
+	status, err := c.status(ctx, name)
+	if err != nil {
+		return err
+	}
+	if status == "running" {
+		return fmt.Errorf("container %s is already running", name)
+	}
+
+	if !attach {
+		if out, err := exec.CommandContext(ctx, "container", "start", name).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to start container %s: %s", name, strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	cmd := exec.Command("container", "start", "--attach", "--interactive", name)
+	stop := func() {
+		exec.Command("container", "stop", name).Run()
+	}
+	kill := func() {
+		exec.Command("container", "kill", name).Run()
+	}
+	if err := runTTY(ctx, cmd, stop, kill, false, nil, nil); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return backend.NewExitError(exitErr)
+		}
+		return fmt.Errorf("container error: %w", err)
+	}
+	return nil
+}
+
+// Attach is not supported: the container CLI can only attach when starting a
+// container.
+func (c *Client) Attach(ctx context.Context, name string) error {
+	if err := c.verifyRunning(ctx, name); err != nil {
+		return err
+	}
+	return fmt.Errorf("the container backend can't attach to a running container: stop it with 'silo stop %s' and reattach with 'silo start --attach %s', or open a shell with 'silo shell %s'", name, name, name)
+}
+
+// runTTY runs cmd attached to the terminal through a PTY. stop is called on
+// SIGINT/SIGTERM, context cancellation, or Ctrl-C pressed three times in a
+// row, asking first if confirm is set, and kill if Ctrl-C is pressed three
+// times again. The error from cmd.Wait is returned as is so callers can
+// inspect the exit code. menu may be nil; detaching isn't supported as the
+// CLI can't reattach. output, if not nil, receives a copy of the output.
+func runTTY(ctx context.Context, cmd *exec.Cmd, stop, kill func(), confirm bool, menu *termproxy.Menu, output io.Writer) error {
 	// Save terminal state and ensure it's restored on exit
 	fd := int(os.Stdin.Fd())
 	oldState, _ := unix.IoctlGetTermios(fd, unix.TIOCGETA)
-	defer func() {
+	restore := termstate.Register(func() {
+		// Restore termios state
 		if oldState != nil {
 			unix.IoctlSetTermios(fd, unix.TIOCSETA, oldState)
 		}
-		// Reset terminal modes (mouse tracking, alternate screen, etc.)
-		os.Stdout.WriteString("\x1b[?1000l") // Disable mouse click tracking
-		os.Stdout.WriteString("\x1b[?1002l") // Disable mouse button tracking
-		os.Stdout.WriteString("\x1b[?1003l") // Disable all mouse tracking
-		os.Stdout.WriteString("\x1b[?1006l") // Disable SGR mouse mode
-		os.Stdout.WriteString("\x1b[?25h")   // Show cursor
-		os.Stdout.WriteString("\x1b[?1049l") // Exit alternate screen buffer
-	}()
+		// Reset the modes the tool may have set that termios doesn't cover
+		os.Stdout.WriteString(termstate.DisableMouse + termstate.ShowCursor + termstate.LeaveAltScreen)
+	})
+	defer restore()
 
 	// Start command with PTY so container gets a real terminal
 	ptmx, err := pty.Start(cmd)
 	if err != nil {
-		return fmt.Errorf("failed to exec in container: %w", err)
+		return fmt.Errorf("failed to start: %w", err)
 	}
 	defer ptmx.Close()
 
@@ -669,71 +932,124 @@ func (c *Client) Exec(ctx context.Context, name string, command []string) error
 		unix.IoctlSetTermios(fd, unix.TIOCSETA, &newState)
 	}
 
-	// Copy container output to stdout
-	go func() {
-		io.Copy(os.Stdout, ptmx)
-	}()
-
-	// On signal or context cancellation, kill the exec process
+	// On signal or context cancellation, stop
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	defer signal.Stop(sigCh)
 
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
 		select {
 		case <-sigCh:
 		case <-ctx.Done():
+		case <-done:
+			return
 		}
-		cmd.Process.Kill()
+		stop()
 	}()
 
-	// Copy stdin to container, intercepting double Ctrl-C to kill exec
-	go func() {
-		var lastCtrlC time.Time
-		buf := make([]byte, 256)
-		for {
-			n, err := os.Stdin.Read(buf)
-			if n > 0 {
-				for i := 0; i < n; i++ {
-					if buf[i] == 0x03 {
-						now := time.Now()
-						if now.Sub(lastCtrlC) < time.Second {
-							// Double Ctrl-C - kill exec process
-							cmd.Process.Kill()
-							return
-						}
-						lastCtrlC = now
-					}
-				}
-				ptmx.Write(buf[:n])
-			}
-			if err != nil {
-				break
+	if menu != nil {
+		m := *menu
+		m.Detach = false
+		// Shrinking the PTY briefly delivers SIGWINCH so the program redraws
+		m.Redraw = func() {
+			if rows, cols, err := pty.Getsize(os.Stdin); err == nil && rows > 1 {
+				pty.Setsize(ptmx, &pty.Winsize{Rows: uint16(rows - 1), Cols: uint16(cols)})
+				time.Sleep(50 * time.Millisecond)
+				pty.InheritSize(os.Stdin, ptmx)
 			}
 		}
+		menu = &m
+	}
+	proxy := termproxy.New(os.Stdin, os.Stdout, ptmx, menu)
+	proxy.ConfirmInterrupt = confirm
+	var out io.Writer = proxy
+	if output != nil {
+		out = io.MultiWriter(proxy, output)
+	}
+
+	// Copy output to stdout
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		io.Copy(out, ptmx)
 	}()
 
-	waitErr := cmd.Wait()
-	if waitErr != nil {
-		if exitErr, ok := waitErr.(*exec.ExitError); ok {
-			// Killed by signal (e.g. double Ctrl-C) is not an error
-			if exitErr.ExitCode() == -1 {
-				return nil
-			}
-			return fmt.Errorf("command exited with status %d", exitErr.ExitCode())
+	// Copy stdin, intercepting Ctrl-C pressed three times to stop, and
+	// three times again to kill
+	inputCtx, inputCancel := context.WithCancel(context.Background())
+	inputDone := make(chan struct{})
+	go func() {
+		defer close(inputDone)
+		if proxy.CopyInput(inputCtx) != termproxy.ErrInterrupted {
+			return
 		}
-		return fmt.Errorf("exec error: %w", waitErr)
+		proxy.ConfirmInterrupt = false
+		proxy.Write([]byte(termproxy.StopNotice))
+		go stop()
+		if proxy.CopyInput(inputCtx) == termproxy.ErrInterrupted {
+			kill()
+		}
+	}()
+
+	err = cmd.Wait()
+
+	// Write the last of the output before the terminal is restored. The
+	// PTY stays open if the command left processes behind, so don't wait
+	// on it for long.
+	select {
+	case <-outputDone:
+	case <-time.After(time.Second):
 	}
 
-	return nil
+	// Stop copying stdin so no keys typed afterwards are consumed
+	inputCancel()
+	<-inputDone
+	return err
 }
 
-// verifyRunning checks that a container exists and is running.
-func (c *Client) verifyRunning(ctx context.Context, name string) error {
+// runPiped runs cmd with silo's stdin, stdout, and stderr, for a session
+// without a TTY. stop is called on SIGINT/SIGTERM or context cancellation.
+// The error from cmd.Wait is returned as is. output, if not nil, receives a
+// copy of the output.
+func runPiped(ctx context.Context, cmd *exec.Cmd, stop func(), output io.Writer) error {
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if output != nil {
+		cmd.Stdout = io.MultiWriter(os.Stdout, output)
+		cmd.Stderr = io.MultiWriter(os.Stderr, output)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start: %w", err)
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-sigCh:
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		stop()
+	}()
+
+	return cmd.Wait()
+}
+
+// status returns the lowercased status of a silo container, or an error if
+// it doesn't exist.
+func (c *Client) status(ctx context.Context, name string) (string, error) {
 	cmd := exec.CommandContext(ctx, "container", "ls", "-a", "--format", "json")
 	output, err := cmd.Output()
 	if err != nil {
-		return fmt.Errorf("failed to list containers: %w", err)
+		return "", fmt.Errorf("failed to list containers: %w", err)
 	}
 
 	var containers []struct {
@@ -746,29 +1062,48 @@ func (c *Client) verifyRunning(ctx context.Context, name string) error {
 		Status string `json:"status"`
 	}
 	if err := json.Unmarshal(output, &containers); err != nil {
-		return fmt.Errorf("failed to parse container list: %w", err)
+		return "", fmt.Errorf("failed to parse container list: %w", err)
 	}
 
 	for _, ctr := range containers {
-		if !strings.HasPrefix(ctr.Configuration.Image.Reference, "silo-") {
-			continue
-		}
-		if ctr.Configuration.ID == name {
-			if strings.ToLower(ctr.Status) != "running" {
-				return fmt.Errorf("container %s is not running (status: %s)", name, ctr.Status)
-			}
-			return nil
+		if strings.HasPrefix(ctr.Configuration.Image.Reference, "silo-") && ctr.Configuration.ID == name {
+			return strings.ToLower(ctr.Status), nil
 		}
 	}
-	return fmt.Errorf("container %s not found", name)
+	return "", fmt.Errorf("container %s not found", name)
+}
+
+// verifyRunning checks that a container exists and is running.
+func (c *Client) verifyRunning(ctx context.Context, name string) error {
+	status, err := c.status(ctx, name)
+	if err != nil {
+		return err
+	}
+	if status != "running" {
+		return fmt.Errorf("container %s is not running (status: %s)", name, status)
+	}
+	return nil
 }
 
 // resourceArgs returns CLI flags for --cpus (all CPUs) and --memory (40% system RAM).
 func resourceArgs() []string {
+	return runResourceArgs(backend.Resources{})
+}
+
+// runResourceArgs returns CLI flags for the configured CPU and memory limits,
+// defaulting to all CPUs and 40% of system RAM for unset values. Each
+// container is its own VM, so swap and pids limits have no equivalent and are
+// ignored.
+func runResourceArgs(r backend.Resources) []string {
 	cpus := runtime.NumCPU()
-	var memMB uint64
-	if memBytes, err := unix.SysctlUint64("hw.memsize"); err == nil {
-		memMB = memBytes * 2 / 5 / (1024 * 1024) // 40%, in MiB
+	if r.CPUs > 0 {
+		cpus = int(math.Ceil(r.CPUs))
+	}
+	memMB := uint64(r.MemoryBytes) / (1024 * 1024)
+	if memMB == 0 {
+		if memBytes, err := unix.SysctlUint64("hw.memsize"); err == nil {
+			memMB = memBytes * 2 / 5 / (1024 * 1024) // 40%, in MiB
+		}
 	}
 	args := []string{"-c", fmt.Sprintf("%d", cpus)}
 	if memMB > 0 {
@@ -783,7 +1118,7 @@ func resourceArgs() []string {
 func stageFileMount(filePath string) (hostDir, containerDir string, err error) {
 	h := sha256.Sum256([]byte(filePath))
 	hash := hex.EncodeToString(h[:])
-	hostDir = filepath.Join(xdg.StateHome, "silo", "mounts", hash)
+	hostDir = statedir.Path("mounts", hash)
 	containerDir = filepath.Join("/silo/mounts", hash)
 	if err := os.MkdirAll(hostDir, 0755); err != nil {
 		return "", "", err
@@ -826,3 +1161,24 @@ func scanLinesOrCR(data []byte, atEOF bool) (advance int, token []byte, err erro
 	// Request more data
 	return 0, nil, nil
 }
+
+// writeEnvFile writes env (KEY=VALUE entries) to a file readable only by the
+// current user and returns its path. The caller removes the file.
+func writeEnvFile(env []string) (string, error) {
+	f, err := os.CreateTemp("", "silo-env-*")
+	if err != nil {
+		return "", fmt.Errorf("creating env file: %w", err)
+	}
+	defer f.Close()
+	for _, e := range env {
+		if strings.ContainsAny(e, "\r\n") {
+			os.Remove(f.Name())
+			return "", fmt.Errorf("environment variable %s contains a newline", strings.SplitN(e, "=", 2)[0])
+		}
+		if _, err := fmt.Fprintln(f, e); err != nil {
+			os.Remove(f.Name())
+			return "", fmt.Errorf("writing env file: %w", err)
+		}
+	}
+	return f.Name(), nil
+}
@@ -5,6 +5,7 @@ package container
 import (
 	"context"
 	"fmt"
+	"io"
 
 	"github.com/leighmcculloch/silo/backend" // parent package
 )
@@ -18,6 +19,19 @@ func NewClient() (*Client, error) {
 	return nil, fmt.Errorf("container backend is only available on macOS")
 }
 
+// Features are the silo features supported by the container backend.
+var Features = backend.Features{
+	Ports:   true,
+	Stats:   true,
+	Exec:    true,
+	Persist: true,
+}
+
+// Probe reports the container backend as unavailable on non-Darwin platforms.
+func Probe(ctx context.Context) backend.Info {
+	return backend.Info{Detail: "container backend is only available on macOS"}
+}
+
 // Close is a no-op stub.
 func (c *Client) Close() error {
 	return nil
@@ -28,6 +42,11 @@ func (c *Client) ImageExists(ctx context.Context, name string) (bool, error) {
 	return false, fmt.Errorf("container backend is only available on macOS")
 }
 
+// ImageArch is a stub that always returns an error.
+func (c *Client) ImageArch(ctx context.Context, name string) (string, error) {
+	return "", fmt.Errorf("container backend is only available on macOS")
+}
+
 // Build is a stub that always returns an error.
 func (c *Client) Build(ctx context.Context, opts backend.BuildOptions) (string, error) {
 	return "", fmt.Errorf("container backend is only available on macOS")
@@ -38,11 +57,41 @@ func (c *Client) Run(ctx context.Context, opts backend.RunOptions) error {
 	return fmt.Errorf("container backend is only available on macOS")
 }
 
+// RunCommand is a stub that returns nil.
+func RunCommand(opts backend.RunOptions) []string {
+	return nil
+}
+
 // Exec is a stub that always returns an error.
 func (c *Client) Exec(ctx context.Context, name string, command []string) error {
 	return fmt.Errorf("container backend is only available on macOS")
 }
 
+// Stop is a stub that always returns an error.
+func (c *Client) Stop(ctx context.Context, name string) error {
+	return fmt.Errorf("container backend is only available on macOS")
+}
+
+// Kill is a stub that always returns an error.
+func (c *Client) Kill(ctx context.Context, name string) error {
+	return fmt.Errorf("container backend is only available on macOS")
+}
+
+// Start is a stub that always returns an error.
+func (c *Client) Start(ctx context.Context, name string, attach bool) error {
+	return fmt.Errorf("container backend is only available on macOS")
+}
+
+// Attach is a stub that always returns an error.
+func (c *Client) Attach(ctx context.Context, name string) error {
+	return fmt.Errorf("container backend is only available on macOS")
+}
+
+// Logs is a stub that always returns an error.
+func (c *Client) Logs(ctx context.Context, name string, follow bool, w io.Writer) error {
+	return fmt.Errorf("container backend is only available on macOS")
+}
+
 // List is a stub that always returns an error.
 func (c *Client) List(ctx context.Context) ([]backend.ContainerInfo, error) {
 	return nil, fmt.Errorf("container backend is only available on macOS")
@@ -53,6 +102,51 @@ func (c *Client) Remove(ctx context.Context, names []string) ([]string, error) {
 	return nil, fmt.Errorf("container backend is only available on macOS")
 }
 
+// ListImages is a stub that always returns an error.
+func (c *Client) ListImages(ctx context.Context) ([]backend.ImageInfo, error) {
+	return nil, fmt.Errorf("container backend is only available on macOS")
+}
+
+// RemoveImages is a stub that always returns an error.
+func (c *Client) RemoveImages(ctx context.Context, names []string) ([]string, error) {
+	return nil, fmt.Errorf("container backend is only available on macOS")
+}
+
+// PushImage is a stub that always returns an error.
+func (c *Client) PushImage(ctx context.Context, name, ref string) (string, error) {
+	return "", fmt.Errorf("container backend is only available on macOS")
+}
+
+// PullImage is a stub that always returns an error.
+func (c *Client) PullImage(ctx context.Context, ref, name string) (string, error) {
+	return "", fmt.Errorf("container backend is only available on macOS")
+}
+
+// SaveImage is a stub that always returns an error.
+func (c *Client) SaveImage(ctx context.Context, name, path string) error {
+	return fmt.Errorf("container backend is only available on macOS")
+}
+
+// LoadImage is a stub that always returns an error.
+func (c *Client) LoadImage(ctx context.Context, path string) error {
+	return fmt.Errorf("container backend is only available on macOS")
+}
+
+// ListVolumes is a stub that always returns an error.
+func (c *Client) ListVolumes(ctx context.Context) ([]backend.VolumeInfo, error) {
+	return nil, fmt.Errorf("container backend is only available on macOS")
+}
+
+// RemoveVolumes is a stub that always returns an error.
+func (c *Client) RemoveVolumes(ctx context.Context, names []string) ([]string, error) {
+	return nil, fmt.Errorf("container backend is only available on macOS")
+}
+
+// PruneSidecars is a stub that always returns an error.
+func (c *Client) PruneSidecars(ctx context.Context, dryRun bool) ([]string, error) {
+	return nil, fmt.Errorf("container backend is only available on macOS")
+}
+
 // NextContainerName is a stub that returns an empty string.
 func (c *Client) NextContainerName(ctx context.Context, baseName string) string {
 	return ""
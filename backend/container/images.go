@@ -0,0 +1,158 @@
+//go:build darwin
+
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/leighmcculloch/silo/backend" // parent package
+)
+
+// ListImages returns all silo-built images (those named silo-*). The
+// container CLI doesn't report image creation times or unpacked sizes, so
+// they are left unset.
+func (c *Client) ListImages(ctx context.Context) ([]backend.ImageInfo, error) {
+	output, err := exec.CommandContext(ctx, "container", "image", "list", "--format", "json").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	var images []struct {
+		Reference string `json:"reference"`
+	}
+	if err := json.Unmarshal(output, &images); err != nil {
+		return nil, fmt.Errorf("failed to parse image list: %w", err)
+	}
+
+	var result []backend.ImageInfo
+	for _, img := range images {
+		name := strings.TrimSuffix(img.Reference, ":latest")
+		if strings.HasPrefix(name, "silo-") {
+			result = append(result, backend.ImageInfo{Name: name})
+		}
+	}
+	return result, nil
+}
+
+// RemoveImages removes silo images by name.
+func (c *Client) RemoveImages(ctx context.Context, names []string) ([]string, error) {
+	var removed []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, "silo-") {
+			continue
+		}
+		if out, err := exec.CommandContext(ctx, "container", "image", "delete", name).CombinedOutput(); err != nil {
+			return removed, fmt.Errorf("failed to remove image %s: %s", name, strings.TrimSpace(string(out)))
+		}
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
+
+// PushImage tags the local image name as ref and pushes it. Registry
+// credentials are those of container registry login.
+func (c *Client) PushImage(ctx context.Context, name, ref string) (string, error) {
+	if out, err := exec.CommandContext(ctx, "container", "image", "tag", name, ref).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to tag image %s: %s", name, strings.TrimSpace(string(out)))
+	}
+	if out, err := exec.CommandContext(ctx, "container", "image", "push", ref).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to push image %s: %s", ref, strings.TrimSpace(string(out)))
+	}
+	return imageDigest(ctx, ref)
+}
+
+// SaveImage writes the image name to a tar file at path.
+func (c *Client) SaveImage(ctx context.Context, name, path string) error {
+	if out, err := exec.CommandContext(ctx, "container", "image", "save", "--output", path, name).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to save image %s: %s", name, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// LoadImage loads the images in the tar file at path.
+func (c *Client) LoadImage(ctx context.Context, path string) error {
+	if out, err := exec.CommandContext(ctx, "container", "image", "load", "--input", path).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to load images from %s: %s", path, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// PullImage pulls ref and tags it as the local image name.
+func (c *Client) PullImage(ctx context.Context, ref, name string) (string, error) {
+	if out, err := exec.CommandContext(ctx, "container", "image", "pull", ref).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to pull image %s: %s", ref, strings.TrimSpace(string(out)))
+	}
+	if out, err := exec.CommandContext(ctx, "container", "image", "tag", ref, name).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to tag image %s: %s", ref, strings.TrimSpace(string(out)))
+	}
+	return imageDigest(ctx, ref)
+}
+
+// imageDigest returns the digest of the manifest, or index, of the local
+// image ref.
+func imageDigest(ctx context.Context, ref string) (string, error) {
+	output, err := exec.CommandContext(ctx, "container", "image", "inspect", ref).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", ref, err)
+	}
+	var images []struct {
+		Index struct {
+			Digest string `json:"digest"`
+		} `json:"index"`
+	}
+	if err := json.Unmarshal(output, &images); err != nil {
+		return "", fmt.Errorf("failed to parse image %s: %w", ref, err)
+	}
+	if len(images) == 0 || images[0].Index.Digest == "" {
+		return "", fmt.Errorf("no digest recorded for image %s", ref)
+	}
+	return images[0].Index.Digest, nil
+}
+
+// ListVolumes returns all silo-created volumes (those named silo-*).
+func (c *Client) ListVolumes(ctx context.Context) ([]backend.VolumeInfo, error) {
+	output, err := exec.CommandContext(ctx, "container", "volume", "list", "--format", "json").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+
+	var volumes []struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(output, &volumes); err != nil {
+		return nil, fmt.Errorf("failed to parse volume list: %w", err)
+	}
+
+	var result []backend.VolumeInfo
+	for _, v := range volumes {
+		if strings.HasPrefix(v.Name, "silo-") {
+			result = append(result, backend.VolumeInfo{Name: v.Name})
+		}
+	}
+	return result, nil
+}
+
+// RemoveVolumes removes silo volumes by name.
+func (c *Client) RemoveVolumes(ctx context.Context, names []string) ([]string, error) {
+	var removed []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, "silo-") {
+			continue
+		}
+		if out, err := exec.CommandContext(ctx, "container", "volume", "delete", name).CombinedOutput(); err != nil {
+			return removed, fmt.Errorf("failed to remove volume %s: %s", name, strings.TrimSpace(string(out)))
+		}
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
+
+// PruneSidecars removes nothing: the container backend starts nothing
+// alongside a session's container.
+func (c *Client) PruneSidecars(ctx context.Context, dryRun bool) ([]string, error) {
+	return nil, nil
+}
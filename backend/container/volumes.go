@@ -0,0 +1,38 @@
+//go:build darwin
+
+package container
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/leighmcculloch/silo/backend"
+)
+
+// volumeMountPoint is where ensureVolumes mounts a new volume to prepare it.
+const volumeMountPoint = "/silo-volume"
+
+// ensureVolumes creates the volumes that don't exist yet, and makes each new
+// volume writable by any user, like /tmp, with a short-lived container from
+// image, as its root is owned by root.
+func ensureVolumes(ctx context.Context, image string, volumes []backend.Mount) error {
+	for _, v := range volumes {
+		if exec.CommandContext(ctx, "container", "volume", "inspect", v.Source).Run() == nil {
+			continue
+		}
+		label := backend.LabelCacheVolume + "=" + strings.TrimPrefix(v.Source, backend.CacheVolumePrefix)
+		if out, err := exec.CommandContext(ctx, "container", "volume", "create", "--label", label, v.Source).CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to create volume %s: %s", v.Source, strings.TrimSpace(string(out)))
+		}
+		cmd := exec.CommandContext(ctx, "container", "run", "--rm", "--user", "root",
+			"--volume", v.Source+":"+volumeMountPoint,
+			"--entrypoint", "chmod", image, "1777", volumeMountPoint)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			exec.Command("container", "volume", "delete", v.Source).Run()
+			return fmt.Errorf("failed to prepare volume %s: %s", v.Source, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types/build"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/auth"
+	"github.com/moby/buildkit/session/secrets/secretsprovider"
+	"google.golang.org/grpc"
+)
+
+// buildKit reports whether builds use BuildKit: unless DOCKER_BUILDKIT=0 is
+// set, as the docker CLI honors it, whenever the daemon supports it. Daemons
+// that don't, such as those of other engines implementing the docker API,
+// get the classic builder.
+func (c *Client) buildKit(ctx context.Context) bool {
+	if v := os.Getenv("DOCKER_BUILDKIT"); v == "0" || v == "false" {
+		return false
+	}
+	ping, err := c.cli.Ping(ctx)
+	return err == nil && ping.BuilderVersion == build.BuilderBuildKit
+}
+
+// buildSession starts the session BuildKit calls back into during a build,
+// for the credentials of the registries it pulls from and the values of
+// the build's secrets. It runs until ctx is done or it is closed.
+func (c *Client) buildSession(ctx context.Context, secrets map[string]string) (*session.Session, error) {
+	s, err := session.NewSession(ctx, "silo")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create build session: %w", err)
+	}
+	s.Allow(authProvider{})
+	values := make(map[string][]byte, len(secrets))
+	for id, v := range secrets {
+		values[id] = []byte(v)
+	}
+	s.Allow(secretsprovider.FromMap(values))
+	go s.Run(ctx, func(ctx context.Context, proto string, meta map[string][]string) (net.Conn, error) {
+		return c.cli.DialHijack(ctx, "/session", proto, meta)
+	})
+	return s, nil
+}
+
+// authProvider answers BuildKit's requests for registry credentials from
+// the docker CLI's config, as registryAuth does for pulls.
+type authProvider struct {
+	auth.UnimplementedAuthServer
+}
+
+func (p authProvider) Register(server *grpc.Server) {
+	auth.RegisterAuthServer(server, p)
+}
+
+func (authProvider) Credentials(_ context.Context, req *auth.CredentialsRequest) (*auth.CredentialsResponse, error) {
+	slog.Debug("Build requested registry credentials", "host", req.Host)
+	server := req.Host
+	if server == "registry-1.docker.io" {
+		server = dockerHubServer
+	}
+	a, _ := lookupAuth(cliConfigDir(), server, runCredentialHelper)
+	if a.IdentityToken != "" {
+		return &auth.CredentialsResponse{Secret: a.IdentityToken}, nil
+	}
+	return &auth.CredentialsResponse{Username: a.Username, Secret: a.Password}, nil
+}
+
+// readBuildOutput reads the JSON messages of a build's output until it
+// ends, passing its progress to onProgress, which may be nil. The classic
+// builder's progress is in "stream" messages; BuildKit's is in
+// moby.buildkit.trace messages, rendered by a traceWriter.
+func readBuildOutput(r io.Reader, onProgress func(string)) error {
+	if onProgress == nil {
+		onProgress = func(string) {}
+	}
+	trace := &traceWriter{onProgress: onProgress, steps: map[string]int{}, done: map[string]bool{}}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var msg struct {
+			Stream      string `json:"stream"`
+			Error       string `json:"error"`
+			ErrorDetail struct {
+				Message string `json:"message"`
+			} `json:"errorDetail"`
+			ID  string          `json:"id"`
+			Aux json.RawMessage `json:"aux"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+		switch {
+		case msg.Error != "":
+			errMsg := msg.Error
+			if msg.ErrorDetail.Message != "" {
+				errMsg = msg.ErrorDetail.Message
+			}
+			return fmt.Errorf("build error: %s", errMsg)
+		case msg.ID == "moby.buildkit.trace":
+			// The status is a protobuf message, in the JSON as base64
+			var data []byte
+			var status controlapi.StatusResponse
+			if json.Unmarshal(msg.Aux, &data) == nil && status.UnmarshalVT(data) == nil {
+				trace.write(&status)
+			}
+		case msg.Stream != "":
+			onProgress(msg.Stream)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read build output: %w", err)
+	}
+	return nil
+}
+
+// traceWriter renders the status of a BuildKit build as docker build
+// --progress=plain does: "#N [stage 2/9] RUN ..." when step N starts,
+// followed by its output and outcome, each line prefixed with #N.
+type traceWriter struct {
+	onProgress func(string)
+	steps      map[string]int  // the number of each step started, by digest
+	done       map[string]bool // the steps that have finished, by digest
+}
+
+func (w *traceWriter) write(status *controlapi.StatusResponse) {
+	for _, v := range status.Vertexes {
+		if w.done[v.Digest] {
+			continue
+		}
+		n, ok := w.steps[v.Digest]
+		if !ok {
+			if v.Started == nil && !v.Cached {
+				continue
+			}
+			n = len(w.steps) + 1
+			w.steps[v.Digest] = n
+			w.onProgress(fmt.Sprintf("#%d %s\n", n, v.Name))
+		}
+		outcome := ""
+		switch {
+		case v.Error != "":
+			outcome = "ERROR: " + v.Error
+		case v.Cached:
+			outcome = "CACHED"
+		case v.Completed != nil:
+			outcome = "DONE"
+		default:
+			continue
+		}
+		w.done[v.Digest] = true
+		w.onProgress(fmt.Sprintf("#%d %s\n", n, outcome))
+	}
+	for _, l := range status.Logs {
+		n, ok := w.steps[l.Vertex]
+		if !ok {
+			continue
+		}
+		for line := range strings.Lines(string(l.Msg)) {
+			if line = strings.TrimRight(line, "\r\n"); line != "" {
+				w.onProgress(fmt.Sprintf("#%d %s\n", n, line))
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package docker
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// traceMessage returns status as the build API sends it.
+func traceMessage(t *testing.T, status *controlapi.StatusResponse) string {
+	t.Helper()
+	data, err := status.MarshalVT()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := json.Marshal(map[string]any{"id": "moby.buildkit.trace", "aux": data})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(msg) + "\n"
+}
+
+func TestReadBuildOutput(t *testing.T) {
+	now := timestamppb.Now()
+	run := &controlapi.Vertex{Digest: "sha256:b", Name: "[base 2/9] RUN apt-get update", Started: now}
+	output := traceMessage(t, &controlapi.StatusResponse{Vertexes: []*controlapi.Vertex{
+		{Digest: "sha256:a", Name: "[base 1/9] FROM ubuntu:24.04", Cached: true, Started: now, Completed: now},
+		run,
+		{Digest: "sha256:c", Name: "[base 3/9] RUN true"},
+	}})
+	output += traceMessage(t, &controlapi.StatusResponse{
+		Vertexes: []*controlapi.Vertex{run},
+		Logs:     []*controlapi.VertexLog{{Vertex: "sha256:b", Msg: []byte("Get:1 noble InRelease\nGet:2 noble-updates\n")}},
+	})
+	output += traceMessage(t, &controlapi.StatusResponse{Vertexes: []*controlapi.Vertex{
+		{Digest: "sha256:b", Name: run.Name, Started: now, Completed: now},
+	}})
+	output += `{"stream":"Successfully tagged silo-claude\n"}` + "\n"
+
+	var got strings.Builder
+	if err := readBuildOutput(strings.NewReader(output), func(s string) { got.WriteString(s) }); err != nil {
+		t.Fatal(err)
+	}
+	want := `#1 [base 1/9] FROM ubuntu:24.04
+#1 CACHED
+#2 [base 2/9] RUN apt-get update
+#2 Get:1 noble InRelease
+#2 Get:2 noble-updates
+#2 DONE
+Successfully tagged silo-claude
+`
+	if got.String() != want {
+		t.Errorf("progress =\n%s\nwant\n%s", got.String(), want)
+	}
+
+	err := readBuildOutput(strings.NewReader(`{"error":"failed","errorDetail":{"message":"process did not complete successfully"}}`), nil)
+	if err == nil || !strings.Contains(err.Error(), "did not complete") {
+		t.Errorf("err = %v, want the build's error", err)
+	}
+}
@@ -2,26 +2,39 @@ package docker
 
 import (
 	"archive/tar"
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
+	"maps"
 	"os"
+	"os/exec"
 	"os/signal"
+	"path"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/build"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
-	"github.com/kballard/go-shellquote"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
 	"github.com/leighmcculloch/silo/backend" // parent package
+	"github.com/leighmcculloch/silo/backend/termproxy"
+	"github.com/leighmcculloch/silo/termstate"
 	"github.com/moby/term"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 // Client wraps the Docker client with silo-specific functionality
@@ -38,6 +51,35 @@ func NewClient() (*Client, error) {
 	return &Client{cli: cli}, nil
 }
 
+// Features are the silo features supported by the docker backend.
+var Features = backend.Features{
+	FileMounts:       true,
+	Ports:            true,
+	Stats:            true,
+	Exec:             true,
+	Persist:          true,
+	Attach:           true,
+	NetworkIsolation: true,
+	Services:         true,
+}
+
+// Probe reports whether the Docker daemon is reachable and its version.
+func Probe(ctx context.Context) backend.Info {
+	c, err := NewClient()
+	if err != nil {
+		return backend.Info{Detail: err.Error()}
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	v, err := c.cli.ServerVersion(ctx)
+	if err != nil {
+		return backend.Info{Detail: fmt.Sprintf("daemon not reachable: %v", err), Retry: true}
+	}
+	return backend.Info{Available: true, Version: v.Version}
+}
+
 // Close closes the Docker client
 func (c *Client) Close() error {
 	return c.cli.Close()
@@ -57,6 +99,24 @@ func (c *Client) ImageExists(ctx context.Context, name string) (bool, error) {
 	return true, nil
 }
 
+// ImageArch returns the CPU architecture of a local image.
+func (c *Client) ImageArch(ctx context.Context, name string) (string, error) {
+	info, _, err := c.cli.ImageInspectWithRaw(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", name, err)
+	}
+	return info.Architecture, nil
+}
+
+// ociPlatform returns platform as an OCI platform, or nil if it is empty.
+func ociPlatform(platform string) *ocispec.Platform {
+	if platform == "" {
+		return nil
+	}
+	goos, arch := backend.SplitPlatform(platform)
+	return &ocispec.Platform{OS: goos, Architecture: arch}
+}
+
 // Build builds a Docker image and returns the image ID
 func (c *Client) Build(ctx context.Context, opts backend.BuildOptions) (string, error) {
 	// Create a tar archive with the Dockerfile
@@ -93,57 +153,230 @@ func (c *Client) Build(ctx context.Context, opts backend.BuildOptions) (string,
 		tag = opts.Target
 	}
 
-	// Build the image
-	resp, err := c.cli.ImageBuild(ctx, &buf, types.ImageBuildOptions{
+	// Pull prebuilt images to reuse their layers
+	var cacheFrom []string
+	if !opts.NoCache {
+		cacheFrom = c.pullCacheImages(ctx, opts.CacheFrom, opts.OnProgress)
+	}
+
+	buildOpts := types.ImageBuildOptions{
 		Dockerfile: "Dockerfile",
 		Target:     opts.Target,
 		BuildArgs:  buildArgs,
 		Tags:       []string{tag},
 		Remove:     true,
 		NoCache:    opts.NoCache,
-	})
+		CacheFrom:  cacheFrom,
+		Platform:   opts.Platform,
+	}
+
+	// Build with BuildKit where the daemon has it, for RUN --mount in
+	// hooks. Its session lives as long as the build.
+	buildKit := c.buildKit(ctx)
+	slog.Debug("Building image", "tag", opts.Tag, "buildkit", buildKit)
+	switch {
+	case buildKit:
+		sessionCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		s, err := c.buildSession(sessionCtx, opts.Secrets)
+		if err != nil {
+			return "", err
+		}
+		defer s.Close()
+		buildOpts.Version = build.BuilderBuildKit
+		buildOpts.SessionID = s.ID()
+		// Record the cache metadata in the image, so it can be a cache for
+		// later builds, as --cache-from takes it, in other jobs
+		inline := "1"
+		buildOpts.BuildArgs["BUILDKIT_INLINE_CACHE"] = &inline
+	case len(opts.Secrets) > 0:
+		return "", fmt.Errorf("build_secrets need BuildKit, which the Docker daemon doesn't support or DOCKER_BUILDKIT=0 disables")
+	}
+
+	// Build the image
+	resp, err := c.cli.ImageBuild(ctx, &buf, buildOpts)
 	if err != nil {
 		return "", fmt.Errorf("failed to build image: %w", err)
 	}
 	defer resp.Body.Close()
 
-	// Read and parse the build output line by line
-	// Docker's build API returns JSON messages with "stream" for output and "error" for errors
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Text()
+	// Docker's build API returns JSON messages of the build's progress,
+	// and one with "error" if it fails
+	if err := readBuildOutput(resp.Body, opts.OnProgress); err != nil {
+		return "", err
+	}
+	return tag, nil
+}
 
-		var msg struct {
-			Stream      string `json:"stream"`
-			Error       string `json:"error"`
-			ErrorDetail struct {
-				Message string `json:"message"`
-			} `json:"errorDetail"`
+// pullCacheImages pulls the images in refs that aren't already present and
+// returns those available locally. Pull failures are not errors: the image
+// may not be published for this template, and the build works without it.
+func (c *Client) pullCacheImages(ctx context.Context, refs []string, onProgress func(string)) []string {
+	var available []string
+	for _, ref := range refs {
+		if exists, err := c.ImageExists(ctx, ref); err == nil && exists {
+			available = append(available, ref)
+			continue
 		}
-		if err := json.Unmarshal([]byte(line), &msg); err == nil {
-			if msg.Error != "" {
-				errMsg := msg.Error
-				if msg.ErrorDetail.Message != "" {
-					errMsg = msg.ErrorDetail.Message
-				}
-				return "", fmt.Errorf("build error: %s", errMsg)
+		if onProgress != nil {
+			onProgress(fmt.Sprintf("Pulling prebuilt image %s\n", ref))
+		}
+		rc, err := c.cli.ImagePull(ctx, ref, image.PullOptions{})
+		if err != nil {
+			continue
+		}
+		failed := false
+		dec := json.NewDecoder(rc)
+		for {
+			var msg struct {
+				Error string `json:"error"`
 			}
-			if msg.Stream != "" && opts.OnProgress != nil {
-				opts.OnProgress(msg.Stream)
+			if err := dec.Decode(&msg); err != nil {
+				failed = err != io.EOF
+				break
+			}
+			if msg.Error != "" {
+				failed = true
 			}
 		}
+		rc.Close()
+		if !failed {
+			available = append(available, ref)
+		}
+	}
+	return available
+}
+
+// entrypointCmd returns the entrypoint and command of a container running
+// opts.Command with opts.Args, after the pre-run hooks if there are any,
+// including the one starting Docker for opts.DockerInDocker.
+func entrypointCmd(opts backend.RunOptions) (entrypoint, cmd []string) {
+	if opts.DockerInDocker != "" {
+		opts.PreRunHooks = append(slices.Clip(opts.PreRunHooks), backend.DockerStartHook(opts.DockerInDocker))
+	}
+	if len(opts.Command) == 0 {
+		// No command specified, use image's default entrypoint
+		// Pass args as Cmd (will be appended to entrypoint)
+		return nil, opts.Args
 	}
 
-	if err := scanner.Err(); err != nil {
-		return "", fmt.Errorf("failed to read build output: %w", err)
+	// Build full command: Command + Args
+	fullCmd := append(slices.Clip(opts.Command), opts.Args...)
+
+	if len(opts.PreRunHooks) > 0 {
+		return []string{"/bin/bash", "-c", backend.StartScript(opts.PreRunHooks, fullCmd, opts.NoTTY)}, nil
 	}
 
-	return tag, nil
+	// No pre-run hooks, just run the command directly
+	return fullCmd[:1], fullCmd[1:]
 }
 
-// Run runs a container with the given options
-func (c *Client) Run(ctx context.Context, opts backend.RunOptions) error {
-	// Convert mounts
+// RunCommand returns a docker CLI command equivalent to Run with opts, for
+// showing what a run would do. Environment variables are passed by name
+// only, so their values, which may be secrets, aren't shown. The egress
+// proxy and internal network of an allowlist network are left out.
+func RunCommand(opts backend.RunOptions) []string {
+	keep := opts.Keep || opts.Detach
+	args := []string{"docker", "run", "-i"}
+	if !opts.NoTTY {
+		args = append(args, "-t")
+	}
+	args = append(args, "--init")
+	if opts.DockerInDocker != "" {
+		args = append(args, "--privileged")
+	} else {
+		args = append(args, "--security-opt", "no-new-privileges:true", "--cap-drop", "ALL")
+		if opts.Seccomp != "" {
+			args = append(args, "--security-opt", "seccomp="+opts.Seccomp)
+		}
+		if opts.AppArmor != "" {
+			args = append(args, "--security-opt", "apparmor="+opts.AppArmor)
+		}
+	}
+	if opts.Userns != "" {
+		args = append(args, "--userns", opts.Userns)
+	}
+	args = append(args, "--ipc", "private")
+	if opts.Detach {
+		args = append(args, "--detach")
+	}
+	if !keep {
+		args = append(args, "--rm")
+	}
+	if opts.Name != "" {
+		args = append(args, "--name", opts.Name)
+	}
+	if opts.WorkDir != "" {
+		args = append(args, "-w", opts.WorkDir)
+	}
+	for _, k := range slices.Sorted(maps.Keys(opts.Labels)) {
+		args = append(args, "--label", k+"="+opts.Labels[k])
+	}
+	if opts.Platform != "" {
+		args = append(args, "--platform", opts.Platform)
+	}
+	r := opts.Resources
+	if r.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(r.CPUs, 'f', -1, 64))
+	}
+	if r.MemoryBytes > 0 {
+		args = append(args, "--memory", strconv.FormatInt(r.MemoryBytes, 10))
+		if r.SwapBytes > 0 {
+			args = append(args, "--memory-swap", strconv.FormatInt(r.MemoryBytes+r.SwapBytes, 10))
+		}
+	}
+	if r.PidsLimit > 0 {
+		args = append(args, "--pids-limit", strconv.FormatInt(r.PidsLimit, 10))
+	}
+	if r.Nofile > 0 {
+		args = append(args, "--ulimit", fmt.Sprintf("nofile=%d:%d", r.Nofile, r.Nofile))
+	}
+	switch {
+	case opts.Network.Mode == backend.NetworkNone:
+		args = append(args, "--network", "none")
+	case opts.Network.Join != "":
+		args = append(args, "--network", opts.Network.Join, "--network-alias", backend.DNSName(opts.Name))
+	}
+	for _, p := range opts.Ports {
+		args = append(args, "--publish", p.String())
+	}
+	for _, e := range opts.Env {
+		name, _, _ := strings.Cut(e, "=")
+		args = append(args, "-e", name)
+	}
+	for _, e := range slices.Concat(sshAgentEnv(opts), gpgAgentEnv(opts)) {
+		args = append(args, "-e", e)
+	}
+	binds, mounts := selinuxBinds(runMounts(opts), opts.SELinuxLabel)
+	for _, b := range binds {
+		args = append(args, "-v", b)
+	}
+	for _, m := range mounts {
+		spec := fmt.Sprintf("type=%s,source=%s,target=%s", m.Type, m.Source, m.Target)
+		if m.ReadOnly {
+			spec += ",readonly"
+		}
+		args = append(args, "--mount", spec)
+	}
+	for _, p := range opts.Tmpfs {
+		args = append(args, "--tmpfs", p+":"+tmpfsOptions)
+	}
+	if opts.ReadOnlyRootfs {
+		args = append(args, "--read-only")
+	}
+
+	entrypoint, cmd := entrypointCmd(opts)
+	if len(entrypoint) > 0 {
+		args = append(args, "--entrypoint", entrypoint[0])
+		cmd = append(entrypoint[1:], cmd...)
+	}
+	args = append(args, opts.Image)
+	return append(args, cmd...)
+}
+
+// runMounts returns the mounts of a container run with opts. Host paths that
+// don't exist are skipped.
+func runMounts(opts backend.RunOptions) []mount.Mount {
 	var mounts []mount.Mount
 	for _, m := range opts.MountsRO {
 		// Check if path exists before mounting (use Lstat to not follow symlinks)
@@ -168,69 +401,289 @@ func (c *Client) Run(ctx context.Context, opts backend.RunOptions) error {
 			Target: m,
 		})
 	}
+	for _, m := range opts.MountsMapped {
+		if _, err := os.Lstat(m.Source); err != nil {
+			continue
+		}
+		mounts = append(mounts, mount.Mount{
+			Type:   mount.TypeBind,
+			Source: m.Source,
+			Target: m.Target,
+		})
+	}
 
-	// Build the entrypoint script if we have pre-run hooks or a command
-	var entrypoint []string
-	var cmd []string
+	if opts.SSHAgent != "" {
+		mounts = append(mounts, mount.Mount{
+			Type:   mount.TypeBind,
+			Source: sshAgentSource(opts.SSHAgent),
+			Target: sshAgentSocket,
+		})
+	}
+	if opts.GPGAgent != "" {
+		mounts = append(mounts, mount.Mount{
+			Type:   mount.TypeBind,
+			Source: opts.GPGAgent,
+			Target: path.Join(gpgHome, "S.gpg-agent"),
+		})
+		for _, f := range opts.GPGKeyring {
+			mounts = append(mounts, mount.Mount{
+				Type:     mount.TypeBind,
+				Source:   f,
+				Target:   path.Join(gpgHome, path.Base(f)),
+				ReadOnly: true,
+			})
+		}
+	}
 
-	if len(opts.Command) > 0 {
-		// Build full command: Command + Args
-		fullCmd := append(opts.Command, opts.Args...)
+	return append(mounts, volumeMounts(opts.Volumes)...)
+}
 
-		if len(opts.PreRunHooks) > 0 {
-			// Create a bash script that runs pre-run hooks then execs the command
-			var script strings.Builder
-			for _, hook := range opts.PreRunHooks {
-				script.WriteString(hook)
-				script.WriteString(" && ")
-			}
-			script.WriteString("exec ")
-			script.WriteString(shellquote.Join(fullCmd...))
-			entrypoint = []string{"/bin/bash", "-c", script.String()}
-			cmd = nil
-		} else {
-			// No pre-run hooks, just run the command directly
-			entrypoint = []string{fullCmd[0]}
-			if len(fullCmd) > 1 {
-				cmd = fullCmd[1:]
-			}
+// sshAgentSocket is where the host's SSH agent socket is mounted in the
+// container.
+const sshAgentSocket = "/run/silo/ssh-agent.sock"
+
+// sshAgentSource returns the path to mount for the host's SSH agent socket
+// sock. On macOS the daemon runs in a VM that can't reach sockets on the
+// host, and Docker Desktop and OrbStack forward the agent to a socket in
+// the VM instead.
+func sshAgentSource(sock string) string {
+	if runtime.GOOS == "darwin" {
+		return "/run/host-services/ssh-auth.sock"
+	}
+	return sock
+}
+
+// sshAgentEnv returns the environment pointing SSH_AUTH_SOCK at the
+// forwarded agent, if there is one.
+func sshAgentEnv(opts backend.RunOptions) []string {
+	if opts.SSHAgent == "" {
+		return nil
+	}
+	return []string{"SSH_AUTH_SOCK=" + sshAgentSocket}
+}
+
+// gpgHome is the gnupg home in the container, holding the forwarded
+// gpg-agent socket and the host's public keyring.
+const gpgHome = "/run/silo/gnupg"
+
+// gpgAgentEnv returns the environment pointing GNUPGHOME at the forwarded
+// gpg-agent, if there is one.
+func gpgAgentEnv(opts backend.RunOptions) []string {
+	if opts.GPGAgent == "" {
+		return nil
+	}
+	return []string{"GNUPGHOME=" + gpgHome}
+}
+
+// selinuxBinds returns the bind mounts in mounts as binds relabeled with the
+// SELinux label, which the mounts API can't do, and the other mounts. With
+// no label, mounts are returned as they are.
+func selinuxBinds(mounts []mount.Mount, label string) ([]string, []mount.Mount) {
+	if label == "" {
+		return nil, mounts
+	}
+	var binds []string
+	var rest []mount.Mount
+	for _, m := range mounts {
+		if m.Type != mount.TypeBind {
+			rest = append(rest, m)
+			continue
 		}
-	} else {
-		// No command specified, use image's default entrypoint
-		// Pass args as Cmd (will be appended to entrypoint)
-		cmd = opts.Args
+		options := label
+		if m.ReadOnly {
+			options = "ro," + label
+		}
+		binds = append(binds, m.Source+":"+m.Target+":"+options)
+	}
+	return binds, rest
+}
+
+// rootlessCaps are the capabilities root in the container keeps on rootless
+// Docker, so it can use the files of the image's user as that user would.
+var rootlessCaps = []string{"CHOWN", "DAC_OVERRIDE", "FOWNER"}
+
+// rootless reports whether the daemon runs rootless. Its containers' root is
+// the host user, and their other users are subordinate IDs that can't write
+// the host files mounted into them.
+func (c *Client) rootless(ctx context.Context) bool {
+	return c.securityOption(ctx, "name=rootless")
+}
+
+// remapped reports whether the daemon runs containers in a user namespace
+// (userns-remap), where their uids are subordinate IDs on the host.
+func (c *Client) remapped(ctx context.Context) bool {
+	return c.securityOption(ctx, "name=userns")
+}
+
+// securityOption reports whether the daemon has the security option name.
+func (c *Client) securityOption(ctx context.Context, name string) bool {
+	info, err := c.cli.Info(ctx)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(info.SecurityOptions, func(o string) bool {
+		return strings.Contains(o, name)
+	})
+}
+
+// shareMounts gives uid, the image's user when it isn't the host user,
+// read-write access to the working directory dir with ACLs, so it can write
+// it without its ownership changing as it would with chown. Default ACLs
+// give the host user the same access to the files uid creates. The ACLs
+// stay, so confirm is asked first, unless dir is already shared. Other
+// mounts, such as the tool's credentials, aren't shared, as uid may be
+// another user's on the host. It is only needed on Linux, as Docker
+// Desktop maps the ownership of mounted files to the container user, and
+// not on rootless Docker, whose containers run as the host user, or under
+// userns-remap, where uid isn't the container user's uid on the host.
+func shareMounts(uid int, dir string, confirm func(string) bool) error {
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() || shared(dir, uid) {
+		return nil
+	}
+	if _, err := exec.LookPath("setfacl"); err != nil {
+		return fmt.Errorf("the container user (uid %d) isn't the host user, and setfacl, which shares the working directory with it, isn't installed: install the acl package, or remove container_user.uid", uid)
+	}
+	if confirm == nil || !confirm(dir) {
+		return fmt.Errorf("the container user (uid %d) isn't the host user, and %s isn't shared with it: confirm sharing it in a terminal, or remove container_user.uid", uid, dir)
+	}
+	entry := fmt.Sprintf("u:%d:rwX", uid)
+	args := []string{"-R", "-m", fmt.Sprintf("%s,d:%s,d:u:%d:rwX", entry, entry, os.Getuid()), dir}
+	if out, err := exec.Command("setfacl", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to share %s with the container user (uid %d): %w: %s", dir, uid, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// shared reports whether the directory dir has a default ACL giving uid
+// access, as shareMounts sets, so its files don't need to be walked again.
+func shared(dir string, uid int) bool {
+	out, err := exec.Command("getfacl", "--omit-header", "--numeric", dir).Output()
+	return err == nil && strings.Contains(string(out), fmt.Sprintf("default:user:%d:rwx", uid))
+}
+
+// Run runs a container with the given options
+func (c *Client) Run(ctx context.Context, opts backend.RunOptions) error {
+	binds, mounts := selinuxBinds(runMounts(opts), opts.SELinuxLabel)
+	entrypoint, cmd := entrypointCmd(opts)
+
+	// The egress proxy and its network are removed when the run ends, so a
+	// kept container would have no way out when restarted.
+	keep := opts.Keep || opts.Detach
+	if keep && opts.Network.Mode == backend.NetworkAllowlist {
+		return fmt.Errorf("keeping containers is not supported with network allowlist")
+	}
+	// Likewise the services
+	if keep && len(opts.Services) > 0 {
+		return fmt.Errorf("keeping containers is not supported with services")
+	}
+
+	// Docker Desktop and OrbStack forward the SSH agent into their VM, but
+	// not gpg-agent, whose socket on the host the daemon can't reach.
+	if opts.GPGAgent != "" && runtime.GOOS == "darwin" {
+		return fmt.Errorf("commit_signing gpg is not supported by Docker on macOS, use commit_signing ssh")
+	}
+
+	if err := c.ensureVolumes(ctx, opts.Image, opts.Volumes); err != nil {
+		return err
+	}
+
+	// Set up network isolation (may start an egress proxy sidecar)
+	networkMode, networkEnv, networkCleanup, err := c.setupNetwork(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer networkCleanup()
+
+	// Start the services on a network the container is connected to
+	servicesNet, servicesCleanup, err := c.startServices(ctx, opts, networkMode)
+	if err != nil {
+		return err
+	}
+	defer servicesCleanup()
+
+	securityOpt, err := securityOpts(opts)
+	if err != nil {
+		return err
 	}
 
 	// Create container configuration
 	config := &container.Config{
 		Image:        opts.Image,
 		WorkingDir:   opts.WorkDir,
-		Env:          opts.Env,
+		Env:          slices.Concat(opts.Env, networkEnv, sshAgentEnv(opts), gpgAgentEnv(opts)),
 		Entrypoint:   entrypoint,
 		Cmd:          cmd,
-		Tty:          true,
+		Tty:          !opts.NoTTY,
 		OpenStdin:    true,
-		StdinOnce:    true,
+		StdinOnce:    !keep, // kept containers accept stdin from later attaches
 		AttachStdin:  true,
 		AttachStdout: true,
 		AttachStderr: true,
+		Labels:       opts.Labels,
+		ExposedPorts: exposedPorts(opts.Ports),
+		// Every stop, from silo stop, the menu, or a signal, gives the tool
+		// the same chance to exit
+		StopSignal:  opts.Stop.Signal,
+		StopTimeout: intPtr(int(opts.Stop.GracePeriod / time.Second)),
 	}
 
 	hostConfig := &container.HostConfig{
-		Mounts:      mounts,
-		Init:        boolPtr(true),
-		AutoRemove:  true,
-		Privileged:  false,
-		SecurityOpt: []string{"no-new-privileges:true"},
-		CapDrop:     []string{"ALL"},
-		IpcMode:     "private",
+		Binds:          binds,
+		Mounts:         mounts,
+		Init:           boolPtr(true),
+		AutoRemove:     !keep,
+		Privileged:     false,
+		SecurityOpt:    securityOpt,
+		CapDrop:        []string{"ALL"},
+		IpcMode:        "private",
+		Resources:      resources(opts.Resources),
+		NetworkMode:    networkMode,
+		PortBindings:   portBindings(opts.Ports),
+		Tmpfs:          tmpfs(opts.Tmpfs),
+		ReadonlyRootfs: opts.ReadOnlyRootfs,
+	}
+	if opts.DockerInDocker != "" {
+		// A Docker daemon needs the privileges the container otherwise
+		// drops, and runs as root with sudo or with setuid helpers
+		hostConfig.Privileged = true
+		hostConfig.SecurityOpt = nil
+		hostConfig.CapDrop = nil
+	}
+	if opts.Userns != "" {
+		hostConfig.UsernsMode = container.UsernsMode(opts.Userns)
+	}
+	if c.rootless(ctx) {
+		// Run as the container's root, which is the host user, so the
+		// mounted files are writable
+		config.User = "0:0"
+		hostConfig.CapAdd = rootlessCaps
+	} else if opts.UID != 0 && runtime.GOOS == "linux" && (opts.Userns == "host" || !c.remapped(ctx)) && slices.Contains(opts.MountsRW, opts.WorkDir) {
+		if err := shareMounts(opts.UID, opts.WorkDir, opts.ConfirmShare); err != nil {
+			return err
+		}
 	}
 
 	// Create the container
-	resp, err := c.cli.ContainerCreate(ctx, config, hostConfig, nil, nil, opts.Name)
+	resp, err := c.cli.ContainerCreate(ctx, config, hostConfig, networkingConfig(opts), ociPlatform(opts.Platform), opts.Name)
 	if err != nil {
 		return fmt.Errorf("failed to create container: %w", err)
 	}
+	if servicesNet != "" && servicesNet != string(networkMode) {
+		if err := c.cli.NetworkConnect(ctx, servicesNet, resp.ID, nil); err != nil {
+			c.cli.ContainerRemove(context.Background(), resp.ID, container.RemoveOptions{Force: true})
+			return fmt.Errorf("failed to connect container to services network: %w", err)
+		}
+	}
+
+	if opts.Detach {
+		if err := c.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+			return fmt.Errorf("failed to start container: %w", err)
+		}
+		if opts.OnStart != nil {
+			opts.OnStart()
+		}
+		return nil
+	}
 
 	// Attach to the container
 	attachResp, err := c.cli.ContainerAttach(ctx, resp.ID, container.AttachOptions{
@@ -244,87 +697,176 @@ func (c *Client) Run(ctx context.Context, opts backend.RunOptions) error {
 	}
 	defer attachResp.Close()
 
-	// Start waiting for container BEFORE starting it to avoid race with AutoRemove
-	statusCh, errCh := c.cli.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	// Start waiting for container BEFORE starting it to avoid race with
+	// AutoRemove. The wait outlasts ctx, as a cancelled run stops the
+	// container and waits for the tool to exit.
+	statusCh, errCh := c.cli.ContainerWait(context.WithoutCancel(ctx), resp.ID, container.WaitConditionNotRunning)
 
 	// Start the container
 	if err := c.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
 		return fmt.Errorf("failed to start container: %w", err)
 	}
+	if opts.OnStart != nil {
+		opts.OnStart()
+	}
+
+	if opts.NoTTY {
+		return c.pipe(ctx, resp.ID, attachResp, statusCh, errCh, opts.Output)
+	}
 
+	var menu *termproxy.Menu
+	if opts.MenuKey != 0 {
+		id := resp.ID
+		menu = &termproxy.Menu{
+			Key:    opts.MenuKey,
+			Name:   opts.Name,
+			Mounts: backend.MountLines(opts.MountsRO, opts.MountsRW, opts.MountsMapped, opts.Tmpfs, opts.Volumes),
+			Dir:    opts.WorkDir,
+			Stop: func() {
+				c.cli.ContainerStop(context.Background(), id, container.StopOptions{})
+			},
+			Detach: len(opts.Services) == 0, // detaching would remove the services
+			Redraw: func() { c.redrawContainerTTY(ctx, id) },
+		}
+	}
+	return c.stream(ctx, resp.ID, attachResp, statusCh, errCh, menu, opts.Output, opts.Stop.Confirm)
+}
+
+// stream connects the terminal to an attached, running container and blocks
+// until the container exits or the session is detached from the menu.
+// SIGINT/SIGTERM, cancellation, and Ctrl-C pressed three times in a row stop
+// the container with its stop signal and grace period, asking first if
+// confirm is set, and three more Ctrl-Cs kill it. menu may be nil. output,
+// if not nil, receives a copy of the container's output.
+func (c *Client) stream(ctx context.Context, id string, attachResp types.HijackedResponse, statusCh <-chan container.WaitResponse, errCh <-chan error, menu *termproxy.Menu, output io.Writer, confirm bool) error {
 	// Set terminal to raw mode and handle resizing
 	fd := os.Stdin.Fd()
 	if term.IsTerminal(fd) {
-		oldState, err := term.MakeRaw(fd)
+		restore, err := termstate.MakeRaw(fd)
 		if err != nil {
 			return fmt.Errorf("failed to set raw terminal: %w", err)
 		}
-		defer term.RestoreTerminal(fd, oldState)
+		defer restore()
 
 		// Set initial terminal size
-		c.resizeContainerTTY(ctx, resp.ID, fd)
+		c.resizeContainerTTY(ctx, id, fd)
 
 		// Handle terminal resize signals
-		go c.monitorTTYSize(ctx, resp.ID, fd)
+		go c.monitorTTYSize(ctx, id, fd)
 	}
 
-	// Forward SIGINT/SIGTERM to stop the container
+	stop := func() {
+		c.cli.ContainerStop(context.Background(), id, container.StopOptions{})
+	}
+
+	// Stop the container on SIGINT/SIGTERM or cancellation, but not once
+	// the session has ended or been detached
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	defer signal.Stop(sigCh)
+	done := make(chan struct{})
+	defer close(done)
 	go func() {
 		select {
 		case <-sigCh:
-			c.cli.ContainerKill(ctx, resp.ID, "SIGTERM")
 		case <-ctx.Done():
+		case <-done:
+			return
 		}
+		stop()
 	}()
 
-	// Copy stdin to container, intercepting double Ctrl-C to kill
-	// Use a context to stop the goroutine when the container exits
+	// Copy stdin to container, intercepting Ctrl-C pressed three times to
+	// stop it. Use a context to stop the goroutine when the container exits.
+	proxy := termproxy.New(os.Stdin, os.Stdout, attachResp.Conn, menu)
+	proxy.ConfirmInterrupt = confirm
 	stdinCtx, stdinCancel := context.WithCancel(ctx)
 	defer stdinCancel()
+	var detached atomic.Bool
+	inputDone := make(chan struct{})
 	go func() {
-		var lastCtrlC time.Time
-		buf := make([]byte, 256)
-		for {
-			// Check if we should stop
-			select {
-			case <-stdinCtx.Done():
+		defer close(inputDone)
+		err := proxy.CopyInput(stdinCtx)
+		if err == termproxy.ErrInterrupted {
+			// Stop the container, and kill it if Ctrl-C is pressed three
+			// times again before it has stopped
+			proxy.ConfirmInterrupt = false
+			proxy.Write([]byte(termproxy.StopNotice))
+			go stop()
+			if err = proxy.CopyInput(stdinCtx); err == termproxy.ErrInterrupted {
+				c.cli.ContainerKill(context.Background(), id, "SIGKILL")
 				return
-			default:
-			}
-
-			n, err := os.Stdin.Read(buf)
-			if n > 0 {
-				// Check for Ctrl-C (0x03)
-				for i := 0; i < n; i++ {
-					if buf[i] == 0x03 {
-						now := time.Now()
-						if now.Sub(lastCtrlC) < time.Second {
-							// Double Ctrl-C - kill container
-							c.cli.ContainerKill(ctx, resp.ID, "SIGKILL")
-							return
-						}
-						lastCtrlC = now
-					}
-				}
-				attachResp.Conn.Write(buf[:n])
-			}
-			if err != nil {
-				break
 			}
 		}
-		attachResp.CloseWrite()
+		switch err {
+		case termproxy.ErrDetached:
+			// Closing the connection ends the output copy below and
+			// leaves the container running
+			detached.Store(true)
+			attachResp.Close()
+		default:
+			attachResp.CloseWrite()
+		}
 	}()
 
 	// Copy container output to stdout
-	io.Copy(os.Stdout, attachResp.Reader)
+	var out io.Writer = proxy
+	if output != nil {
+		out = io.MultiWriter(proxy, output)
+	}
+	io.Copy(out, attachResp.Reader)
 
-	// Container output is done, cancel stdin copying
+	// Container output is done, stop copying stdin before the terminal is
+	// restored so no keys typed afterwards are consumed
 	stdinCancel()
+	<-inputDone
+
+	if detached.Load() {
+		return nil
+	}
+	return waitExit(statusCh, errCh)
+}
 
-	// Wait for the container to finish
+// pipe connects silo's stdin, stdout, and stderr to an attached container
+// running without a TTY, and blocks until the container exits. SIGINT,
+// SIGTERM, and cancellation stop the container. output, if not nil,
+// receives a copy of the container's stdout and stderr.
+func (c *Client) pipe(ctx context.Context, id string, attachResp types.HijackedResponse, statusCh <-chan container.WaitResponse, errCh <-chan error, output io.Writer) error {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigCh)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-sigCh:
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		c.cli.ContainerStop(context.Background(), id, container.StopOptions{})
+	}()
+
+	// Copy stdin until it ends, then close the container's, so the tool
+	// sees the end of the input
+	go func() {
+		io.Copy(attachResp.Conn, os.Stdin)
+		attachResp.CloseWrite()
+	}()
+
+	// Without a TTY the output is multiplexed, with stdout and stderr apart
+	var stdout, stderr io.Writer = os.Stdout, os.Stderr
+	if output != nil {
+		stdout = io.MultiWriter(stdout, output)
+		stderr = io.MultiWriter(stderr, output)
+	}
+	stdcopy.StdCopy(stdout, stderr, attachResp.Reader)
+	return waitExit(statusCh, errCh)
+}
+
+// waitExit waits for a container to exit, returning an ExitError if it exited
+// with a nonzero status.
+func waitExit(statusCh <-chan container.WaitResponse, errCh <-chan error) error {
 	select {
 	case err := <-errCh:
 		if err != nil {
@@ -332,13 +874,118 @@ func (c *Client) Run(ctx context.Context, opts backend.RunOptions) error {
 		}
 	case status := <-statusCh:
 		if status.StatusCode != 0 {
-			return fmt.Errorf("container exited with status %d", status.StatusCode)
+			return &backend.ExitError{Code: int(status.StatusCode)}
 		}
 	}
+	return nil
+}
+
+// Logs writes the output of a container to w, optionally following it.
+func (c *Client) Logs(ctx context.Context, name string, follow bool, w io.Writer) error {
+	id, _, err := c.resolveContainer(ctx, name)
+	if err != nil {
+		return err
+	}
 
+	// Containers run with a TTY, so the log stream is raw rather than
+	// multiplexed and can be copied directly.
+	logs, err := c.cli.ContainerLogs(ctx, id, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     follow,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get logs for %s: %w", name, err)
+	}
+	defer logs.Close()
+
+	if _, err := io.Copy(w, logs); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to read logs for %s: %w", name, err)
+	}
+	return nil
+}
+
+// Stop stops a running container without removing it.
+func (c *Client) Stop(ctx context.Context, name string) error {
+	id, err := c.resolveRunningContainer(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := c.cli.ContainerStop(ctx, id, container.StopOptions{}); err != nil {
+		return fmt.Errorf("failed to stop container %s: %w", name, err)
+	}
+	return nil
+}
+
+// Kill kills a running container straight away.
+func (c *Client) Kill(ctx context.Context, name string) error {
+	id, err := c.resolveRunningContainer(ctx, name)
+	if err != nil {
+		return err
+	}
+	if err := c.cli.ContainerKill(ctx, id, "SIGKILL"); err != nil {
+		return fmt.Errorf("failed to kill container %s: %w", name, err)
+	}
 	return nil
 }
 
+// Start starts a stopped container, optionally attaching to it.
+func (c *Client) Start(ctx context.Context, name string, attach bool) error {
+	id, state, err := c.resolveContainer(ctx, name)
+	if err != nil {
+		return err
+	}
+	if state == "running" {
+		return fmt.Errorf("container %s is already running", name)
+	}
+
+	if !attach {
+		if err := c.cli.ContainerStart(ctx, id, container.StartOptions{}); err != nil {
+			return fmt.Errorf("failed to start container %s: %w", name, err)
+		}
+		return nil
+	}
+
+	attachResp, err := c.cli.ContainerAttach(ctx, id, container.AttachOptions{
+		Stream: true,
+		Stdin:  true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach to container: %w", err)
+	}
+	defer attachResp.Close()
+
+	statusCh, errCh := c.cli.ContainerWait(ctx, id, container.WaitConditionNotRunning)
+	if err := c.cli.ContainerStart(ctx, id, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start container %s: %w", name, err)
+	}
+	return c.stream(ctx, id, attachResp, statusCh, errCh, nil, nil, false)
+}
+
+// Attach attaches the terminal to a running container's main process.
+func (c *Client) Attach(ctx context.Context, name string) error {
+	id, err := c.resolveRunningContainer(ctx, name)
+	if err != nil {
+		return err
+	}
+
+	attachResp, err := c.cli.ContainerAttach(ctx, id, container.AttachOptions{
+		Stream: true,
+		Stdin:  true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach to container: %w", err)
+	}
+	defer attachResp.Close()
+
+	statusCh, errCh := c.cli.ContainerWait(ctx, id, container.WaitConditionNotRunning)
+	return c.stream(ctx, id, attachResp, statusCh, errCh, nil, nil, false)
+}
+
 // List returns all silo-created containers (those with silo- image prefix)
 func (c *Client) List(ctx context.Context) ([]backend.ContainerInfo, error) {
 	containers, err := c.cli.ContainerList(ctx, container.ListOptions{All: true})
@@ -370,6 +1017,8 @@ func (c *Client) List(ctx context.Context) ([]backend.ContainerInfo, error) {
 					Image:     ctr.Image,
 					Status:    ctr.Status,
 					IsRunning: isRunning,
+					Created:   time.Unix(ctr.Created, 0),
+					Labels:    ctr.Labels,
 				},
 				id:        ctr.ID,
 				isRunning: isRunning,
@@ -454,6 +1103,17 @@ func (c *Client) Remove(ctx context.Context, names []string) ([]string, error) {
 			return removed, fmt.Errorf("failed to remove container %s: %w", name, err)
 		}
 		removed = append(removed, name)
+
+		// Remove what the session left behind if it didn't exit cleanly
+		sidecars, err := c.sidecars(ctx, name)
+		if err != nil {
+			return removed, err
+		}
+		for _, s := range sidecars {
+			if err := c.removeSidecar(ctx, s); err != nil {
+				return removed, err
+			}
+		}
 	}
 
 	return removed, nil
@@ -468,22 +1128,13 @@ func (c *Client) NextContainerName(ctx context.Context, baseName string) string
 		return fmt.Sprintf("%s-1", baseName)
 	}
 
-	maxNum := 0
-	prefix := "/" + baseName + "-"
+	var names []string
 	for _, ctr := range containers {
 		for _, name := range ctr.Names {
-			if suffix, ok := strings.CutPrefix(name, prefix); ok {
-				var num int
-				if _, err := fmt.Sscanf(suffix, "%d", &num); err == nil {
-					if num > maxNum {
-						maxNum = num
-					}
-				}
-			}
+			names = append(names, strings.TrimPrefix(name, "/"))
 		}
 	}
-
-	return fmt.Sprintf("%s-%d", baseName, maxNum+1)
+	return backend.NextName(baseName, names)
 }
 
 // Exec runs a command inside a running container with interactive TTY.
@@ -518,11 +1169,11 @@ func (c *Client) Exec(ctx context.Context, name string, command []string) error
 	// Set terminal to raw mode and handle resizing
 	fd := os.Stdin.Fd()
 	if term.IsTerminal(fd) {
-		oldState, err := term.MakeRaw(fd)
+		restore, err := termstate.MakeRaw(fd)
 		if err != nil {
 			return fmt.Errorf("failed to set raw terminal: %w", err)
 		}
-		defer term.RestoreTerminal(fd, oldState)
+		defer restore()
 
 		// Set initial terminal size
 		c.resizeExecTTY(ctx, execResp.ID, fd)
@@ -531,44 +1182,24 @@ func (c *Client) Exec(ctx context.Context, name string, command []string) error
 		go c.monitorExecTTYSize(ctx, execResp.ID, fd)
 	}
 
-	// Copy stdin to exec, intercepting double Ctrl-C to exit
+	// Copy stdin to exec, intercepting Ctrl-C pressed three times to exit
+	proxy := termproxy.New(os.Stdin, os.Stdout, attachResp.Conn, nil)
 	stdinCtx, stdinCancel := context.WithCancel(ctx)
 	defer stdinCancel()
+	inputDone := make(chan struct{})
 	go func() {
-		var lastCtrlC time.Time
-		buf := make([]byte, 256)
-		for {
-			select {
-			case <-stdinCtx.Done():
-				return
-			default:
-			}
-
-			n, err := os.Stdin.Read(buf)
-			if n > 0 {
-				for i := 0; i < n; i++ {
-					if buf[i] == 0x03 {
-						now := time.Now()
-						if now.Sub(lastCtrlC) < time.Second {
-							return
-						}
-						lastCtrlC = now
-					}
-				}
-				attachResp.Conn.Write(buf[:n])
-			}
-			if err != nil {
-				break
-			}
+		defer close(inputDone)
+		if proxy.CopyInput(stdinCtx) == nil {
+			attachResp.CloseWrite()
 		}
-		attachResp.CloseWrite()
 	}()
 
 	// Copy exec output to stdout
-	io.Copy(os.Stdout, attachResp.Reader)
+	io.Copy(proxy, attachResp.Reader)
 
-	// Exec output is done, cancel stdin copying
+	// Exec output is done, stop copying stdin
 	stdinCancel()
+	<-inputDone
 
 	// Get exit code
 	inspectResp, err := c.cli.ContainerExecInspect(ctx, execResp.ID)
@@ -576,7 +1207,7 @@ func (c *Client) Exec(ctx context.Context, name string, command []string) error
 		return fmt.Errorf("failed to inspect exec: %w", err)
 	}
 	if inspectResp.ExitCode != 0 {
-		return fmt.Errorf("command exited with status %d", inspectResp.ExitCode)
+		return &backend.ExitError{Code: inspectResp.ExitCode}
 	}
 
 	return nil
@@ -585,9 +1216,22 @@ func (c *Client) Exec(ctx context.Context, name string, command []string) error
 // resolveRunningContainer finds a silo container by name and returns its ID.
 // Returns an error if the container is not found or not running.
 func (c *Client) resolveRunningContainer(ctx context.Context, name string) (string, error) {
+	id, state, err := c.resolveContainer(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if state != "running" {
+		return "", fmt.Errorf("container %s is not running (status: %s)", name, state)
+	}
+	return id, nil
+}
+
+// resolveContainer finds a silo container by name and returns its ID and
+// state (e.g. "running", "exited").
+func (c *Client) resolveContainer(ctx context.Context, name string) (string, string, error) {
 	containers, err := c.cli.ContainerList(ctx, container.ListOptions{All: true})
 	if err != nil {
-		return "", fmt.Errorf("failed to list containers: %w", err)
+		return "", "", fmt.Errorf("failed to list containers: %w", err)
 	}
 
 	for _, ctr := range containers {
@@ -599,13 +1243,10 @@ func (c *Client) resolveRunningContainer(ctx context.Context, name string) (stri
 			ctrName = strings.TrimPrefix(ctr.Names[0], "/")
 		}
 		if ctrName == name {
-			if ctr.State != "running" {
-				return "", fmt.Errorf("container %s is not running (status: %s)", name, ctr.Status)
-			}
-			return ctr.ID, nil
+			return ctr.ID, ctr.State, nil
 		}
 	}
-	return "", fmt.Errorf("container %s not found", name)
+	return "", "", fmt.Errorf("container %s not found", name)
 }
 
 // resizeExecTTY resizes the exec session's TTY to match the terminal size
@@ -623,16 +1264,31 @@ func (c *Client) resizeExecTTY(ctx context.Context, execID string, fd uintptr) {
 
 // monitorExecTTYSize monitors for terminal resize signals and updates the exec session
 func (c *Client) monitorExecTTYSize(ctx context.Context, execID string, fd uintptr) {
+	onResize(ctx, func() { c.resizeExecTTY(ctx, execID, fd) })
+}
+
+// resizeSettle is how long the terminal size must stay the same before a
+// resize is sent, so dragging a window sends one resize instead of dozens.
+const resizeSettle = 50 * time.Millisecond
+
+// onResize calls resize after the terminal is resized, until ctx is done.
+// Resizes in quick succession are coalesced into one call.
+func onResize(ctx context.Context, resize func()) {
 	sigchan := make(chan os.Signal, 1)
 	signal.Notify(sigchan, syscall.SIGWINCH)
 	defer signal.Stop(sigchan)
 
+	timer := time.NewTimer(resizeSettle)
+	timer.Stop()
+	defer timer.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-sigchan:
-			c.resizeExecTTY(ctx, execID, fd)
+			timer.Reset(resizeSettle)
+		case <-timer.C:
+			resize()
 		}
 	}
 }
@@ -650,20 +1306,114 @@ func (c *Client) resizeContainerTTY(ctx context.Context, containerID string, fd
 	})
 }
 
+// redrawContainerTTY makes the program in the container redraw its screen by
+// briefly shrinking its TTY, which delivers SIGWINCH.
+func (c *Client) redrawContainerTTY(ctx context.Context, containerID string) {
+	winsize, err := term.GetWinsize(os.Stdin.Fd())
+	if err != nil || winsize.Height < 2 {
+		return
+	}
+	c.cli.ContainerResize(ctx, containerID, container.ResizeOptions{
+		Height: uint(winsize.Height - 1),
+		Width:  uint(winsize.Width),
+	})
+	time.Sleep(50 * time.Millisecond)
+	c.resizeContainerTTY(ctx, containerID, os.Stdin.Fd())
+}
+
 // monitorTTYSize monitors for terminal resize signals and updates the container
 func (c *Client) monitorTTYSize(ctx context.Context, containerID string, fd uintptr) {
-	sigchan := make(chan os.Signal, 1)
-	signal.Notify(sigchan, syscall.SIGWINCH)
-	defer signal.Stop(sigchan)
+	onResize(ctx, func() { c.resizeContainerTTY(ctx, containerID, fd) })
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-sigchan:
-			c.resizeContainerTTY(ctx, containerID, fd)
-		}
+// resources converts backend resource limits to Docker's host config
+// resources. Docker's MemorySwap is the combined memory and swap limit.
+// fs.inotify.max_user_watches isn't namespaced, so Docker can't set
+// InotifyWatches for a container and it is ignored.
+func resources(r backend.Resources) container.Resources {
+	res := container.Resources{
+		NanoCPUs: int64(r.CPUs * 1e9),
+		Memory:   r.MemoryBytes,
+	}
+	if r.MemoryBytes > 0 && r.SwapBytes > 0 {
+		res.MemorySwap = r.MemoryBytes + r.SwapBytes
+	}
+	if r.PidsLimit > 0 {
+		res.PidsLimit = &r.PidsLimit
 	}
+	if r.Nofile > 0 {
+		res.Ulimits = []*container.Ulimit{{Name: "nofile", Soft: r.Nofile, Hard: r.Nofile}}
+	}
+	return res
 }
 
 func boolPtr(b bool) *bool { return &b }
+
+func intPtr(i int) *int { return &i }
+
+// exposedPorts returns the container ports to expose for ports.
+func exposedPorts(ports []backend.Port) nat.PortSet {
+	if len(ports) == 0 {
+		return nil
+	}
+	set := make(nat.PortSet, len(ports))
+	for _, p := range ports {
+		set[containerPort(p)] = struct{}{}
+	}
+	return set
+}
+
+// tmpfsOptions are the mount options of each tmpfs.
+const tmpfsOptions = "rw,exec,mode=1777"
+
+// tmpfs returns the tmpfs mounts for paths. They allow executables, e.g. in
+// build caches, and are writable by the container user.
+func tmpfs(paths []string) map[string]string {
+	if len(paths) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(paths))
+	for _, p := range paths {
+		m[p] = tmpfsOptions
+	}
+	return m
+}
+
+// securityOpts returns the security options of the container. The API takes
+// the contents of a seccomp profile, where the CLI takes its path.
+func securityOpts(opts backend.RunOptions) ([]string, error) {
+	securityOpt := []string{"no-new-privileges:true"}
+	switch opts.Seccomp {
+	case "":
+	case "unconfined":
+		securityOpt = append(securityOpt, "seccomp=unconfined")
+	default:
+		profile, err := os.ReadFile(opts.Seccomp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read seccomp profile: %w", err)
+		}
+		securityOpt = append(securityOpt, "seccomp="+string(profile))
+	}
+	if opts.AppArmor != "" {
+		securityOpt = append(securityOpt, "apparmor="+opts.AppArmor)
+	}
+	return securityOpt, nil
+}
+
+// portBindings returns the host bindings for ports.
+func portBindings(ports []backend.Port) nat.PortMap {
+	if len(ports) == 0 {
+		return nil
+	}
+	m := make(nat.PortMap, len(ports))
+	for _, p := range ports {
+		cp := containerPort(p)
+		m[cp] = append(m[cp], nat.PortBinding{HostIP: p.HostIP, HostPort: strconv.Itoa(p.HostPort)})
+	}
+	return m
+}
+
+// containerPort returns the docker port key for p, e.g. 80/tcp.
+func containerPort(p backend.Port) nat.Port {
+	return nat.Port(fmt.Sprintf("%d/%s", p.ContainerPort, p.Protocol))
+}
@@ -1,8 +1,11 @@
 package docker
 
 import (
+	"slices"
+	"strings"
 	"testing"
 
+	"github.com/docker/docker/api/types/mount"
 	"github.com/leighmcculloch/silo/backend" // parent package
 )
 
@@ -59,3 +62,160 @@ func TestRunOptions(t *testing.T) {
 		t.Error("unexpected args")
 	}
 }
+
+func TestTinyproxyFilter(t *testing.T) {
+	got := tinyproxyFilter([]string{"api.anthropic.com", "*.github.com"})
+	want := "^api\\.anthropic\\.com$\n\\.github\\.com$\n"
+	if got != want {
+		t.Errorf("tinyproxyFilter() = %q, want %q", got, want)
+	}
+}
+
+func TestNetworkingConfig(t *testing.T) {
+	if got := networkingConfig(backend.RunOptions{Name: "myproject-1"}); got != nil {
+		t.Errorf("expected no networking config without a joined network, got %+v", got)
+	}
+	got := networkingConfig(backend.RunOptions{
+		Name:    "My_Project.v2-1",
+		Network: backend.Network{Mode: backend.NetworkFull, Join: backend.SharedNetwork},
+	})
+	ep := got.EndpointsConfig[backend.SharedNetwork]
+	if ep == nil || len(ep.Aliases) != 1 || ep.Aliases[0] != "my-project-v2-1" {
+		t.Errorf("unexpected endpoint settings: %+v", ep)
+	}
+}
+
+func TestPortBindings(t *testing.T) {
+	ports := []backend.Port{
+		{HostIP: "127.0.0.1", HostPort: 8080, ContainerPort: 80, Protocol: "tcp"},
+		{HostIP: "0.0.0.0", HostPort: 8443, ContainerPort: 80, Protocol: "tcp"},
+	}
+	if _, ok := exposedPorts(ports)["80/tcp"]; !ok {
+		t.Error("expected 80/tcp to be exposed")
+	}
+	bindings := portBindings(ports)["80/tcp"]
+	if len(bindings) != 2 || bindings[0].HostIP != "127.0.0.1" || bindings[0].HostPort != "8080" || bindings[1].HostPort != "8443" {
+		t.Errorf("unexpected bindings: %+v", bindings)
+	}
+	if exposedPorts(nil) != nil || portBindings(nil) != nil {
+		t.Error("expected no ports to produce nil")
+	}
+}
+
+func TestRunCommand(t *testing.T) {
+	dir := t.TempDir()
+	got := strings.Join(RunCommand(backend.RunOptions{
+		Image:       "silo-claude-abc",
+		Name:        "project-1",
+		WorkDir:     dir,
+		MountsRO:    []string{dir + "/missing"},
+		MountsRW:    []string{dir},
+		Env:         []string{"TOKEN=secret", "TERM"},
+		Command:     []string{"claude"},
+		Args:        []string{"--resume", "a b"},
+		PreRunHooks: []string{"echo hi"},
+		Resources:   backend.Resources{CPUs: 1.5, MemoryBytes: 1024, SwapBytes: 1024, Nofile: 4096},
+		Network:     backend.Network{Mode: backend.NetworkNone},
+		Tmpfs:       []string{"/tmp/cache"},
+	}), " ")
+
+	for _, want := range []string{
+		"docker run -i -t --init",
+		"--cap-drop ALL",
+		" --rm ",
+		"--name project-1",
+		"--cpus 1.5 --memory 1024 --memory-swap 2048",
+		"--ulimit nofile=4096:4096",
+		"--network none",
+		"-e TOKEN -e TERM",
+		"--mount type=bind,source=" + dir + ",target=" + dir + " ",
+		"--tmpfs /tmp/cache:rw,exec,mode=1777",
+		"--entrypoint /bin/bash silo-claude-abc -c ",
+		"{ echo hi\n} || exit\n",
+		"\nexec claude --resume 'a b'",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("command %q is missing %q", got, want)
+		}
+	}
+	for _, unwanted := range []string{"secret", "missing"} {
+		if strings.Contains(got, unwanted) {
+			t.Errorf("command %q contains %q", got, unwanted)
+		}
+	}
+
+	got = strings.Join(RunCommand(backend.RunOptions{Image: "img", Command: []string{"bash", "-l"}, Keep: true}), " ")
+	if !strings.HasSuffix(got, "--entrypoint bash img -l") || strings.Contains(got, "--rm") {
+		t.Errorf("command = %q, want bash run directly and kept", got)
+	}
+
+	got = strings.Join(RunCommand(backend.RunOptions{Image: "img", Command: []string{"claude"}, PreRunHooks: []string{"echo hi"}, NoTTY: true}), " ")
+	if !strings.HasPrefix(got, "docker run -i --init") || !strings.Contains(got, "{ echo hi\n} </dev/null >&2 || exit\n") || !strings.HasSuffix(got, "\nexec claude") {
+		t.Errorf("command = %q, want no TTY and the hooks kept off stdin and stdout", got)
+	}
+
+	got = strings.Join(RunCommand(backend.RunOptions{Image: "img", Command: []string{"claude"}, Tmpfs: []string{"/tmp"}, ReadOnlyRootfs: true}), " ")
+	if !strings.Contains(got, "--tmpfs /tmp:"+tmpfsOptions+" --read-only") {
+		t.Errorf("command = %q, want a read-only root filesystem", got)
+	}
+
+	got = strings.Join(RunCommand(backend.RunOptions{Image: "img", Command: []string{"claude"}, Userns: "host"}), " ")
+	if !strings.Contains(got, "--userns host --ipc private") {
+		t.Errorf("command = %q, want the host user namespace", got)
+	}
+
+	got = strings.Join(RunCommand(backend.RunOptions{Image: "img", Command: []string{"claude"}, Seccomp: "/p.json", AppArmor: "agent"}), " ")
+	if !strings.Contains(got, "--cap-drop ALL --security-opt seccomp=/p.json --security-opt apparmor=agent") {
+		t.Errorf("command = %q, want the seccomp and AppArmor profiles", got)
+	}
+
+	got = strings.Join(RunCommand(backend.RunOptions{Image: "img", Command: []string{"claude"}, DockerInDocker: backend.DockerInDockerRootless}), " ")
+	if !strings.Contains(got, "--privileged") || strings.Contains(got, "--cap-drop") {
+		t.Errorf("command = %q, want a privileged container for Docker in Docker", got)
+	}
+	if !strings.Contains(got, "dockerd-rootless.sh") || !strings.HasSuffix(got, "exec claude") {
+		t.Errorf("command = %q, want the daemon started before the tool", got)
+	}
+
+	got = strings.Join(RunCommand(backend.RunOptions{Image: "img", MountsRO: []string{dir}, MountsRW: []string{dir}, SELinuxLabel: backend.SELinuxShared}), " ")
+	if !strings.Contains(got, "-v "+dir+":"+dir+":ro,z -v "+dir+":"+dir+":z ") || strings.Contains(got, "--mount") {
+		t.Errorf("command = %q, want bind mounts relabeled", got)
+	}
+
+	got = strings.Join(RunCommand(backend.RunOptions{Image: "img", SSHAgent: "/tmp/agent.sock"}), " ")
+	if want := "-e SSH_AUTH_SOCK=" + sshAgentSocket + " --mount type=bind,source=" + sshAgentSource("/tmp/agent.sock") + ",target=" + sshAgentSocket; !strings.Contains(got, want) {
+		t.Errorf("command = %q, want the agent socket mounted with %q", got, want)
+	}
+	if got := strings.Join(RunCommand(backend.RunOptions{Image: "img"}), " "); strings.Contains(got, "SSH_AUTH_SOCK") {
+		t.Errorf("command = %q, want no agent forwarded by default", got)
+	}
+
+	got = strings.Join(RunCommand(backend.RunOptions{Image: "img", GPGAgent: "/run/user/1000/gnupg/S.gpg-agent.extra", GPGKeyring: []string{"/home/u/.gnupg/pubring.kbx"}}), " ")
+	for _, want := range []string{
+		"-e GNUPGHOME=" + gpgHome,
+		"--mount type=bind,source=/run/user/1000/gnupg/S.gpg-agent.extra,target=" + gpgHome + "/S.gpg-agent",
+		"--mount type=bind,source=/home/u/.gnupg/pubring.kbx,target=" + gpgHome + "/pubring.kbx,readonly",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("command = %q, want gpg-agent forwarded with %q", got, want)
+		}
+	}
+}
+
+func TestSELinuxBinds(t *testing.T) {
+	mounts := []mount.Mount{
+		{Type: mount.TypeBind, Source: "/src", Target: "/src", ReadOnly: true},
+		{Type: mount.TypeVolume, Source: "cache", Target: "/cache"},
+		{Type: mount.TypeBind, Source: "/a", Target: "/b"},
+	}
+	binds, rest := selinuxBinds(mounts, backend.SELinuxPrivate)
+	if want := []string{"/src:/src:ro,Z", "/a:/b:Z"}; !slices.Equal(binds, want) {
+		t.Errorf("binds = %v, want %v", binds, want)
+	}
+	if len(rest) != 1 || rest[0].Source != "cache" {
+		t.Errorf("mounts = %v, want only the volume left", rest)
+	}
+	if binds, rest := selinuxBinds(mounts, ""); binds != nil || len(rest) != len(mounts) {
+		t.Errorf("expected no binds without a label, got %v and %v", binds, rest)
+	}
+}
@@ -0,0 +1,214 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/leighmcculloch/silo/backend" // parent package
+)
+
+// ListImages returns all silo-built images (those tagged silo-*).
+func (c *Client) ListImages(ctx context.Context) ([]backend.ImageInfo, error) {
+	images, err := c.cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	var result []backend.ImageInfo
+	for _, img := range images {
+		for _, tag := range img.RepoTags {
+			name := strings.TrimSuffix(tag, ":latest")
+			if !strings.HasPrefix(name, "silo-") {
+				continue
+			}
+			result = append(result, backend.ImageInfo{
+				Name:    name,
+				Size:    img.Size,
+				Created: time.Unix(img.Created, 0),
+			})
+		}
+	}
+	return result, nil
+}
+
+// RemoveImages removes silo images by name.
+func (c *Client) RemoveImages(ctx context.Context, names []string) ([]string, error) {
+	var removed []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, "silo-") {
+			continue
+		}
+		if _, err := c.cli.ImageRemove(ctx, name, image.RemoveOptions{PruneChildren: true}); err != nil {
+			return removed, fmt.Errorf("failed to remove image %s: %w", name, err)
+		}
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
+
+// PushImage tags the local image name as ref and pushes it, with the
+// docker CLI's credentials for the registry.
+func (c *Client) PushImage(ctx context.Context, name, ref string) (string, error) {
+	if err := c.cli.ImageTag(ctx, name, ref); err != nil {
+		return "", fmt.Errorf("failed to tag image %s: %w", name, err)
+	}
+	rc, err := c.cli.ImagePush(ctx, ref, image.PushOptions{RegistryAuth: registryAuth(ref)})
+	if err != nil {
+		return "", fmt.Errorf("failed to push image %s: %w", ref, err)
+	}
+	defer rc.Close()
+	if err := streamError(rc); err != nil {
+		return "", fmt.Errorf("failed to push image %s: %w", ref, err)
+	}
+	return c.repoDigest(ctx, ref)
+}
+
+// PullImage pulls ref, with the docker CLI's credentials for the registry,
+// and tags it as the local image name.
+func (c *Client) PullImage(ctx context.Context, ref, name string) (string, error) {
+	rc, err := c.cli.ImagePull(ctx, ref, image.PullOptions{RegistryAuth: registryAuth(ref)})
+	if err != nil {
+		return "", fmt.Errorf("failed to pull image %s: %w", ref, err)
+	}
+	defer rc.Close()
+	if err := streamError(rc); err != nil {
+		return "", fmt.Errorf("failed to pull image %s: %w", ref, err)
+	}
+	if err := c.cli.ImageTag(ctx, ref, name); err != nil {
+		return "", fmt.Errorf("failed to tag image %s: %w", ref, err)
+	}
+	return c.repoDigest(ctx, ref)
+}
+
+// repoDigest returns the digest of the manifest of the local image ref in
+// ref's repository, which the daemon records when it's pushed or pulled.
+func (c *Client) repoDigest(ctx context.Context, ref string) (string, error) {
+	info, _, err := c.cli.ImageInspectWithRaw(ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", ref, err)
+	}
+	repo := repository(ref)
+	for _, d := range info.RepoDigests {
+		if r, digest, ok := strings.Cut(d, "@"); ok && r == repo {
+			return digest, nil
+		}
+	}
+	return "", fmt.Errorf("no digest recorded for image %s", ref)
+}
+
+// repository returns ref without its tag or digest.
+func repository(ref string) string {
+	ref, _, _ = strings.Cut(ref, "@")
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+	return ref
+}
+
+// SaveImage writes the image name to a tar file at path, replacing it only
+// once the image is written in full.
+func (c *Client) SaveImage(ctx context.Context, name, path string) error {
+	rc, err := c.cli.ImageSave(ctx, []string{name})
+	if err != nil {
+		return fmt.Errorf("failed to save image %s: %w", name, err)
+	}
+	defer rc.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".silo-save-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, rc)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save image %s: %w", name, err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadImage loads the images in the tar file at path.
+func (c *Client) LoadImage(ctx context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	resp, err := c.cli.ImageLoad(ctx, f, client.ImageLoadWithQuiet(true))
+	if err != nil {
+		return fmt.Errorf("failed to load images from %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if !resp.JSON {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	if err := streamError(resp.Body); err != nil {
+		return fmt.Errorf("failed to load images from %s: %w", path, err)
+	}
+	return nil
+}
+
+// streamError reads a JSON message stream from the Docker API to the end
+// and returns the first error it reports.
+func streamError(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for {
+		var msg struct {
+			Error string `json:"error"`
+		}
+		if err := dec.Decode(&msg); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if msg.Error != "" {
+			return errors.New(msg.Error)
+		}
+	}
+}
+
+// ListVolumes returns all silo-created volumes (those named silo-*).
+func (c *Client) ListVolumes(ctx context.Context) ([]backend.VolumeInfo, error) {
+	resp, err := c.cli.VolumeList(ctx, volume.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+
+	var result []backend.VolumeInfo
+	for _, v := range resp.Volumes {
+		if !strings.HasPrefix(v.Name, "silo-") {
+			continue
+		}
+		created, _ := time.Parse(time.RFC3339, v.CreatedAt)
+		result = append(result, backend.VolumeInfo{Name: v.Name, Created: created})
+	}
+	return result, nil
+}
+
+// RemoveVolumes removes silo volumes by name.
+func (c *Client) RemoveVolumes(ctx context.Context, names []string) ([]string, error) {
+	var removed []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, "silo-") {
+			continue
+		}
+		if err := c.cli.VolumeRemove(ctx, name, false); err != nil {
+			return removed, fmt.Errorf("failed to remove volume %s: %w", name, err)
+		}
+		removed = append(removed, name)
+	}
+	return removed, nil
+}
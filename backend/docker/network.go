@@ -0,0 +1,174 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/kballard/go-shellquote"
+	"github.com/leighmcculloch/silo/backend" // parent package
+)
+
+const (
+	// proxyAlias is the hostname of the egress proxy on the internal network
+	proxyAlias = "silo-proxy"
+
+	// proxyPort is the port the egress proxy listens on
+	proxyPort = 3128
+)
+
+// setupNetwork prepares the network for a container. It returns the network
+// mode for the container's host config, environment variables to add, and a
+// cleanup function to call after the container exits.
+//
+// In full mode with Network.Join set the container is attached to that
+// network instead of the default bridge.
+//
+// In allowlist mode the container is attached to an internal network with no
+// route out, and a tinyproxy sidecar (from the same image) attached to both
+// the internal and default networks is the only way out. The proxy only
+// permits CONNECT to the allowed hosts.
+func (c *Client) setupNetwork(ctx context.Context, opts backend.RunOptions) (container.NetworkMode, []string, func(), error) {
+	switch opts.Network.Mode {
+	case "", backend.NetworkFull:
+		if opts.Network.Join == "" {
+			return "", nil, func() {}, nil
+		}
+		if err := c.ensureNetwork(ctx, opts.Network.Join); err != nil {
+			return "", nil, nil, err
+		}
+		return container.NetworkMode(opts.Network.Join), nil, func() {}, nil
+	case backend.NetworkNone:
+		return network.NetworkNone, nil, func() {}, nil
+	case backend.NetworkAllowlist:
+	default:
+		return "", nil, nil, fmt.Errorf("unsupported network mode: %s", opts.Network.Mode)
+	}
+
+	netName := opts.Name + "-net"
+	if _, err := c.cli.NetworkCreate(ctx, netName, network.CreateOptions{
+		Driver:   "bridge",
+		Internal: true,
+		Labels:   sidecarLabels(opts),
+	}); err != nil {
+		return "", nil, nil, fmt.Errorf("failed to create network: %w", err)
+	}
+	cleanup := func() {
+		c.cli.NetworkRemove(context.Background(), netName)
+	}
+
+	script := fmt.Sprintf("printf '%%s' %s > /tmp/tinyproxy.conf && printf '%%s' %s > /tmp/tinyproxy.filter && exec tinyproxy -d -c /tmp/tinyproxy.conf",
+		shellquote.Join(tinyproxyConfig("/tmp/tinyproxy.filter")),
+		shellquote.Join(tinyproxyFilter(opts.Network.Allow)),
+	)
+	resp, err := c.cli.ContainerCreate(ctx, &container.Config{
+		Image:      opts.Image,
+		Entrypoint: []string{"/bin/bash", "-c", script},
+		Labels:     sidecarLabels(opts),
+	}, &container.HostConfig{
+		AutoRemove:  true,
+		SecurityOpt: []string{"no-new-privileges:true"},
+		CapDrop:     []string{"ALL"},
+	}, nil, nil, opts.Name+"-proxy")
+	if err != nil {
+		cleanup()
+		return "", nil, nil, fmt.Errorf("failed to create proxy container: %w", err)
+	}
+	cleanup = func() {
+		c.cli.ContainerRemove(context.Background(), resp.ID, container.RemoveOptions{Force: true})
+		c.cli.NetworkRemove(context.Background(), netName)
+	}
+
+	if err := c.cli.NetworkConnect(ctx, netName, resp.ID, &network.EndpointSettings{
+		Aliases: []string{proxyAlias},
+	}); err != nil {
+		cleanup()
+		return "", nil, nil, fmt.Errorf("failed to connect proxy to network: %w", err)
+	}
+	if err := c.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		cleanup()
+		return "", nil, nil, fmt.Errorf("failed to start proxy container: %w", err)
+	}
+
+	// The services are on the internal network, reached directly
+	proxyURL := fmt.Sprintf("http://%s:%d", proxyAlias, proxyPort)
+	noProxy := []string{"localhost", "127.0.0.1"}
+	for _, svc := range opts.Services {
+		noProxy = append(noProxy, svc.Name)
+	}
+	env := []string{
+		"HTTP_PROXY=" + proxyURL,
+		"HTTPS_PROXY=" + proxyURL,
+		"http_proxy=" + proxyURL,
+		"https_proxy=" + proxyURL,
+		"NO_PROXY=" + strings.Join(noProxy, ","),
+		"no_proxy=" + strings.Join(noProxy, ","),
+	}
+	return container.NetworkMode(netName), env, cleanup, nil
+}
+
+// ensureNetwork checks that the named network exists, creating it if it is
+// the shared silo network. Concurrent sessions may race to create the shared
+// network, so a conflict is treated as success.
+func (c *Client) ensureNetwork(ctx context.Context, name string) error {
+	_, err := c.cli.NetworkInspect(ctx, name, network.InspectOptions{})
+	if err == nil {
+		return nil
+	}
+	if !cerrdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to inspect network %s: %w", name, err)
+	}
+	if name != backend.SharedNetwork {
+		return fmt.Errorf("network %s not found (create it with: docker network create %s)", name, name)
+	}
+	_, err = c.cli.NetworkCreate(ctx, name, network.CreateOptions{Driver: "bridge"})
+	if err != nil && !cerrdefs.IsConflict(err) {
+		return fmt.Errorf("failed to create network %s: %w", name, err)
+	}
+	return nil
+}
+
+// networkingConfig returns the endpoint settings that give the container a
+// DNS alias on the joined network, or nil if it doesn't join one.
+func networkingConfig(opts backend.RunOptions) *network.NetworkingConfig {
+	if opts.Network.Join == "" {
+		return nil
+	}
+	return &network.NetworkingConfig{
+		EndpointsConfig: map[string]*network.EndpointSettings{
+			opts.Network.Join: {Aliases: []string{backend.DNSName(opts.Name)}},
+		},
+	}
+}
+
+// tinyproxyConfig returns a tinyproxy config that denies every host not
+// matched by the filter file and only tunnels HTTPS.
+func tinyproxyConfig(filterPath string) string {
+	return fmt.Sprintf(`Port %d
+Listen 0.0.0.0
+Timeout 600
+LogLevel Warning
+FilterDefaultDeny Yes
+FilterURLs Off
+FilterCaseSensitive Off
+Filter "%s"
+ConnectPort 443
+`, proxyPort, filterPath)
+}
+
+// tinyproxyFilter returns tinyproxy filter rules (one regular expression per
+// line) matching the allowed hosts. A leading "*." matches any subdomain.
+func tinyproxyFilter(allow []string) string {
+	var b strings.Builder
+	for _, host := range allow {
+		if sub, ok := strings.CutPrefix(host, "*."); ok {
+			fmt.Fprintf(&b, `\.%s$`+"\n", strings.ReplaceAll(sub, ".", `\.`))
+		} else {
+			fmt.Fprintf(&b, `^%s$`+"\n", strings.ReplaceAll(host, ".", `\.`))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,117 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/docker/docker/api/types/registry"
+)
+
+// dockerHubServer is the key Docker Hub credentials are stored under in the
+// docker CLI's config.
+const dockerHubServer = "https://index.docker.io/v1/"
+
+// cliConfig is the part of the docker CLI's config.json that holds registry
+// credentials.
+type cliConfig struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// registryAuth returns the encoded credentials for the registry of ref from
+// the docker CLI's config, running its credential helpers the way the CLI
+// does. Without credentials it returns empty credentials, which is enough
+// for public images.
+func registryAuth(ref string) string {
+	auth, _ := lookupAuth(cliConfigDir(), registryServer(ref), runCredentialHelper)
+	encoded, _ := registry.EncodeAuthConfig(auth)
+	return encoded
+}
+
+// cliConfigDir returns the docker CLI's config directory.
+func cliConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".docker")
+}
+
+// registryServer returns the server credentials for ref are stored under.
+func registryServer(ref string) string {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return ""
+	}
+	domain := reference.Domain(named)
+	if domain == "docker.io" {
+		return dockerHubServer
+	}
+	return domain
+}
+
+// lookupAuth returns the credentials for server from the docker CLI config
+// in dir, and false if there are none.
+func lookupAuth(dir, server string, helper func(name, server string) (registry.AuthConfig, error)) (registry.AuthConfig, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil || server == "" {
+		return registry.AuthConfig{}, false
+	}
+	var cfg cliConfig
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return registry.AuthConfig{}, false
+	}
+
+	if name := cfg.CredHelpers[server]; name != "" {
+		auth, err := helper(name, server)
+		return auth, err == nil
+	}
+	if cfg.CredsStore != "" {
+		if auth, err := helper(cfg.CredsStore, server); err == nil {
+			return auth, true
+		}
+	}
+	entry, ok := cfg.Auths[server]
+	if !ok {
+		return registry.AuthConfig{}, false
+	}
+	auth := registry.AuthConfig{ServerAddress: server, IdentityToken: entry.IdentityToken}
+	if decoded, err := base64.StdEncoding.DecodeString(entry.Auth); err == nil {
+		auth.Username, auth.Password, _ = strings.Cut(string(decoded), ":")
+	}
+	return auth, true
+}
+
+// runCredentialHelper gets the credentials for server from the credential
+// helper docker-credential-<name>.
+func runCredentialHelper(name, server string) (registry.AuthConfig, error) {
+	cmd := exec.Command("docker-credential-"+name, "get")
+	cmd.Stdin = strings.NewReader(server)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return registry.AuthConfig{}, err
+	}
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(out.Bytes(), &creds); err != nil {
+		return registry.AuthConfig{}, err
+	}
+	// Helpers return identity tokens with this placeholder user name
+	if creds.Username == "<token>" {
+		return registry.AuthConfig{ServerAddress: server, IdentityToken: creds.Secret}, nil
+	}
+	return registry.AuthConfig{ServerAddress: server, Username: creds.Username, Password: creds.Secret}, nil
+}
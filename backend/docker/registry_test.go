@@ -0,0 +1,71 @@
+package docker
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+)
+
+func TestRegistryServer(t *testing.T) {
+	tests := map[string]string{
+		"ghcr.io/myorg/silo:silo-claude-abc": "ghcr.io",
+		"myorg/silo:tag":                     dockerHubServer,
+		"localhost:5000/silo:tag":            "localhost:5000",
+		"Invalid Ref":                        "",
+	}
+	for ref, want := range tests {
+		if got := registryServer(ref); got != want {
+			t.Errorf("registryServer(%q) = %q, want %q", ref, got, want)
+		}
+	}
+}
+
+func TestRepository(t *testing.T) {
+	tests := map[string]string{
+		"ghcr.io/myorg/silo:silo-claude-abc":  "ghcr.io/myorg/silo",
+		"ghcr.io/myorg/silo@sha256:1111":      "ghcr.io/myorg/silo",
+		"localhost:5000/silo:tag":             "localhost:5000/silo",
+		"localhost:5000/silo":                 "localhost:5000/silo",
+		"localhost:5000/silo:tag@sha256:1111": "localhost:5000/silo",
+	}
+	for ref, want := range tests {
+		if got := repository(ref); got != want {
+			t.Errorf("repository(%q) = %q, want %q", ref, got, want)
+		}
+	}
+}
+
+func TestLookupAuth(t *testing.T) {
+	dir := t.TempDir()
+	config := `{
+		"auths": {"ghcr.io": {"auth": "dXNlcjpzZWNyZXQ="}, "quay.io": {}},
+		"credHelpers": {"example.com": "test"}
+	}`
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	helper := func(name, server string) (registry.AuthConfig, error) {
+		if name != "test" {
+			return registry.AuthConfig{}, errors.New("unknown helper")
+		}
+		return registry.AuthConfig{Username: "helper", Password: "pw", ServerAddress: server}, nil
+	}
+
+	auth, ok := lookupAuth(dir, "ghcr.io", helper)
+	if !ok || auth.Username != "user" || auth.Password != "secret" {
+		t.Errorf("ghcr.io: got %+v, %v", auth, ok)
+	}
+	auth, ok = lookupAuth(dir, "example.com", helper)
+	if !ok || auth.Username != "helper" {
+		t.Errorf("example.com: got %+v, %v", auth, ok)
+	}
+	if _, ok := lookupAuth(dir, "registry.example.org", helper); ok {
+		t.Error("expected no credentials for an unknown registry")
+	}
+	if _, ok := lookupAuth(t.TempDir(), "ghcr.io", helper); ok {
+		t.Error("expected no credentials without a config")
+	}
+}
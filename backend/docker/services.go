@@ -0,0 +1,245 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"strings"
+	"time"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/leighmcculloch/silo/backend" // parent package
+)
+
+// serviceTimeout is how long services have to pass their healthchecks
+// before the run fails.
+const serviceTimeout = 2 * time.Minute
+
+// startServices starts the sidecar services of opts and returns the network
+// they are on, which the container must be connected to, and a cleanup
+// function to call after the container exits. Each service is reachable at
+// its name on that network, and is started only once the previous ones are
+// healthy.
+//
+// In allowlist mode the services join the internal network of networkMode,
+// so they are as isolated as the container. Otherwise they get a network of
+// their own, so sessions running services of the same name never resolve
+// each other's.
+func (c *Client) startServices(ctx context.Context, opts backend.RunOptions, networkMode container.NetworkMode) (string, func(), error) {
+	if len(opts.Services) == 0 {
+		return "", func() {}, nil
+	}
+	if opts.Network.Mode == backend.NetworkNone {
+		return "", nil, fmt.Errorf("services are not supported with network none")
+	}
+
+	var removeNetwork func()
+	netName := string(networkMode)
+	if opts.Network.Mode != backend.NetworkAllowlist {
+		netName = opts.Name + "-services"
+		if _, err := c.cli.NetworkCreate(ctx, netName, network.CreateOptions{Driver: "bridge", Labels: sidecarLabels(opts)}); err != nil {
+			return "", nil, fmt.Errorf("failed to create services network: %w", err)
+		}
+		removeNetwork = func() { c.cli.NetworkRemove(context.Background(), netName) }
+	}
+	var ids []string
+	cleanup := func() {
+		for _, id := range ids {
+			c.cli.ContainerRemove(context.Background(), id, container.RemoveOptions{Force: true})
+		}
+		if removeNetwork != nil {
+			removeNetwork()
+		}
+	}
+
+	for _, svc := range opts.Services {
+		if err := c.ensureImage(ctx, svc.Image); err != nil {
+			cleanup()
+			return "", nil, fmt.Errorf("service %s: %w", svc.Name, err)
+		}
+		config := &container.Config{
+			Image:  svc.Image,
+			Env:    svc.Env,
+			Labels: sidecarLabels(opts),
+		}
+		if svc.Healthcheck != "" {
+			config.Healthcheck = &container.HealthConfig{
+				Test:     []string{"CMD-SHELL", svc.Healthcheck},
+				Interval: time.Second,
+				Timeout:  5 * time.Second,
+			}
+		}
+		resp, err := c.cli.ContainerCreate(ctx, config, &container.HostConfig{
+			AutoRemove:  true,
+			SecurityOpt: []string{"no-new-privileges:true"},
+		}, &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				netName: {Aliases: []string{svc.Name}},
+			},
+		}, nil, opts.Name+"-"+svc.Name)
+		if err != nil {
+			cleanup()
+			return "", nil, fmt.Errorf("failed to create service %s: %w", svc.Name, err)
+		}
+		ids = append(ids, resp.ID)
+		if err := c.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+			cleanup()
+			return "", nil, fmt.Errorf("failed to start service %s: %w", svc.Name, err)
+		}
+		if svc.Healthcheck != "" {
+			if err := c.waitHealthy(ctx, resp.ID, svc.Name); err != nil {
+				cleanup()
+				return "", nil, err
+			}
+		}
+	}
+	return netName, cleanup, nil
+}
+
+// sidecarLabels returns the labels of the containers and networks started
+// alongside the container of opts: its own, and backend.LabelSidecarOf.
+func sidecarLabels(opts backend.RunOptions) map[string]string {
+	labels := maps.Clone(opts.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[backend.LabelSidecarOf] = opts.Name
+	return labels
+}
+
+// sidecarGrace is how long sidecars are left alone before their session's
+// container is created, as services must be healthy first.
+const sidecarGrace = serviceTimeout + time.Minute
+
+// sidecar is a container or network started alongside a session's
+// container.
+type sidecar struct {
+	id, name string
+	owner    string // name of the session's container
+	network  bool
+	created  time.Time
+}
+
+// PruneSidecars removes the service and proxy containers, and networks, of
+// sessions whose container no longer runs.
+func (c *Client) PruneSidecars(ctx context.Context, dryRun bool) ([]string, error) {
+	sidecars, err := c.sidecars(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, s := range sidecars {
+		if time.Since(s.created) < sidecarGrace {
+			continue
+		}
+		// Those of a running session, or one that can't be checked, stay
+		info, err := c.cli.ContainerInspect(ctx, s.owner)
+		switch {
+		case cerrdefs.IsNotFound(err):
+		case err != nil, info.State.Running:
+			continue
+		}
+		if !dryRun {
+			if err := c.removeSidecar(ctx, s); err != nil {
+				return removed, err
+			}
+		}
+		removed = append(removed, s.name)
+	}
+	return removed, nil
+}
+
+// sidecars returns the containers and networks started alongside the
+// container owner, or alongside any if owner is "", containers first, as
+// networks can't be removed while they're in use.
+func (c *Client) sidecars(ctx context.Context, owner string) ([]sidecar, error) {
+	label := backend.LabelSidecarOf
+	if owner != "" {
+		label += "=" + owner
+	}
+	f := filters.NewArgs(filters.Arg("label", label))
+	containers, err := c.cli.ContainerList(ctx, container.ListOptions{All: true, Filters: f})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	networks, err := c.cli.NetworkList(ctx, network.ListOptions{Filters: f})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+
+	var result []sidecar
+	for _, ctr := range containers {
+		name := ctr.ID[:12]
+		if len(ctr.Names) > 0 {
+			name = strings.TrimPrefix(ctr.Names[0], "/")
+		}
+		result = append(result, sidecar{id: ctr.ID, name: name, owner: ctr.Labels[backend.LabelSidecarOf], created: time.Unix(ctr.Created, 0)})
+	}
+	for _, n := range networks {
+		result = append(result, sidecar{id: n.ID, name: n.Name, owner: n.Labels[backend.LabelSidecarOf], network: true, created: n.Created})
+	}
+	return result, nil
+}
+
+// removeSidecar removes the container or network s, if it still exists.
+func (c *Client) removeSidecar(ctx context.Context, s sidecar) error {
+	if s.network {
+		if err := c.cli.NetworkRemove(ctx, s.id); err != nil && !cerrdefs.IsNotFound(err) {
+			return fmt.Errorf("failed to remove network %s: %w", s.name, err)
+		}
+		return nil
+	}
+	if err := c.cli.ContainerRemove(ctx, s.id, container.RemoveOptions{Force: true}); err != nil && !cerrdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to remove container %s: %w", s.name, err)
+	}
+	return nil
+}
+
+// waitHealthy waits for the container id of the service name to pass its
+// healthcheck.
+func (c *Client) waitHealthy(ctx context.Context, id, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, serviceTimeout)
+	defer cancel()
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		// Exited services are removed, so are usually not found
+		info, err := c.cli.ContainerInspect(ctx, id)
+		switch {
+		case cerrdefs.IsNotFound(err):
+			return fmt.Errorf("service %s exited before it was healthy", name)
+		case err != nil && ctx.Err() == nil:
+			return fmt.Errorf("failed to inspect service %s: %w", name, err)
+		case err == nil && !info.State.Running:
+			return fmt.Errorf("service %s exited before it was healthy (exit code %d)", name, info.State.ExitCode)
+		case err == nil && info.State.Health != nil && info.State.Health.Status == container.Healthy:
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("service %s wasn't healthy after %s", name, serviceTimeout)
+		case <-ticker.C:
+		}
+	}
+}
+
+// ensureImage pulls the image ref, with the docker CLI's credentials for the
+// registry, if it isn't present.
+func (c *Client) ensureImage(ctx context.Context, ref string) error {
+	if exists, err := c.ImageExists(ctx, ref); err != nil || exists {
+		return err
+	}
+	rc, err := c.cli.ImagePull(ctx, ref, image.PullOptions{RegistryAuth: registryAuth(ref)})
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", ref, err)
+	}
+	defer rc.Close()
+	if err := streamError(rc); err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", ref, err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	cerrdefs "github.com/containerd/errdefs"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/leighmcculloch/silo/backend"
+)
+
+// volumeMountPoint is where ensureVolumes mounts a new volume to prepare it.
+const volumeMountPoint = "/silo-volume"
+
+// ensureVolumes creates the volumes that don't exist yet. Docker creates a
+// new volume's root owned by root when the image has nothing at the mount
+// point, so each new volume is made writable by any user, like /tmp, with a
+// short-lived container from image.
+func (c *Client) ensureVolumes(ctx context.Context, image string, volumes []backend.Mount) error {
+	for _, v := range volumes {
+		_, err := c.cli.VolumeInspect(ctx, v.Source)
+		if err == nil {
+			continue
+		}
+		if !cerrdefs.IsNotFound(err) {
+			return fmt.Errorf("failed to inspect volume %s: %w", v.Source, err)
+		}
+		_, err = c.cli.VolumeCreate(ctx, volume.CreateOptions{
+			Name:   v.Source,
+			Labels: map[string]string{backend.LabelCacheVolume: strings.TrimPrefix(v.Source, backend.CacheVolumePrefix)},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create volume %s: %w", v.Source, err)
+		}
+		if err := c.runRoot(ctx, image, v.Source, "chmod", "1777", volumeMountPoint); err != nil {
+			c.cli.VolumeRemove(ctx, v.Source, false)
+			return fmt.Errorf("failed to prepare volume %s: %w", v.Source, err)
+		}
+	}
+	return nil
+}
+
+// runRoot runs command as root in a container from image, with the volume
+// mounted at volumeMountPoint, and removes the container.
+func (c *Client) runRoot(ctx context.Context, image, vol string, command ...string) error {
+	resp, err := c.cli.ContainerCreate(ctx, &container.Config{
+		Image:      image,
+		User:       "0",
+		Entrypoint: command[:1],
+		Cmd:        command[1:],
+	}, &container.HostConfig{
+		Mounts:      []mount.Mount{{Type: mount.TypeVolume, Source: vol, Target: volumeMountPoint}},
+		NetworkMode: "none",
+	}, nil, nil, "")
+	if err != nil {
+		return err
+	}
+	defer c.cli.ContainerRemove(context.Background(), resp.ID, container.RemoveOptions{Force: true})
+
+	statusCh, errCh := c.cli.ContainerWait(ctx, resp.ID, container.WaitConditionNextExit)
+	if err := c.cli.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+		return err
+	}
+	select {
+	case err := <-errCh:
+		return err
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			return fmt.Errorf("%s exited with status %d", command[0], status.StatusCode)
+		}
+	}
+	return nil
+}
+
+// volumeMounts returns the Docker mounts for volumes.
+func volumeMounts(volumes []backend.Mount) []mount.Mount {
+	var mounts []mount.Mount
+	for _, v := range volumes {
+		mounts = append(mounts, mount.Mount{
+			Type:   mount.TypeVolume,
+			Source: v.Source,
+			Target: v.Target,
+		})
+	}
+	return mounts
+}
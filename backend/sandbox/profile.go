@@ -0,0 +1,107 @@
+package sandbox
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ProfileOptions are the paths and network access a seatbelt profile grants.
+type ProfileOptions struct {
+	// Home is the user's home directory. Files in it can't be read unless
+	// they are in ReadOnly, ReadWrite, or Executable.
+	Home string
+
+	// ReadOnly are paths that can be read
+	ReadOnly []string
+
+	// ReadWrite are paths that can be read and written
+	ReadWrite []string
+
+	// Executable are paths of the tool's executable and its installation,
+	// which can be read even if they are in Home
+	Executable []string
+
+	// NoNetwork denies all network access
+	NoNetwork bool
+}
+
+// launchServices are the mach services that open apps and documents, which
+// would run outside the sandbox.
+var launchServices = []string{
+	"com.apple.coreservices.launchservicesd",
+	"com.apple.coreservices.quarantine-resolver",
+}
+
+// escapeCommands run code outside the sandbox, through Apple Events or
+// launchd. Denying them is defense in depth: the services they use, other
+// than Apple Events and launchServices, can't be denied without breaking
+// every process, so a tool that talks to launchd itself can still escape.
+var escapeCommands = []string{
+	"/bin/launchctl",
+	"/usr/bin/osascript",
+	"/usr/bin/open",
+}
+
+// tempPaths are writable in every profile: the system's temporary
+// directories and the devices tools write to.
+var tempPaths = []string{
+	"/private/tmp",
+	"/private/var/folders",
+	"/dev",
+}
+
+// Profile returns a seatbelt profile for sandbox-exec. Everything is allowed
+// except writing outside the read-write mounts and temporary directories,
+// reading files in the home directory other than the mounts, sending Apple
+// Events, opening apps, running escapeCommands, and, with NoNetwork,
+// network access. Later rules take precedence over earlier ones.
+func Profile(opts ProfileOptions) string {
+	var b strings.Builder
+	b.WriteString("(version 1)\n")
+	b.WriteString("(allow default)\n")
+
+	b.WriteString("(deny appleevent-send)\n")
+	b.WriteString("(deny mach-lookup")
+	for _, name := range launchServices {
+		fmt.Fprintf(&b, "\n  (global-name %s)", quote(name))
+	}
+	b.WriteString(")\n")
+	b.WriteString("(deny process-exec")
+	for _, p := range escapeCommands {
+		fmt.Fprintf(&b, "\n  (literal %s)", quote(p))
+	}
+	b.WriteString(")\n")
+
+	b.WriteString("(deny file-write*)\n")
+	writeRule(&b, "allow file-write*", slices.Concat(tempPaths, opts.ReadWrite))
+
+	if opts.Home != "" {
+		writeRule(&b, "deny file-read-data", []string{opts.Home})
+		writeRule(&b, "allow file-read-data", slices.Concat(opts.ReadOnly, opts.ReadWrite, opts.Executable))
+	}
+
+	if opts.NoNetwork {
+		b.WriteString("(deny network*)\n")
+		b.WriteString("(allow network* (local unix))\n")
+	}
+	return b.String()
+}
+
+// writeRule writes a rule applying action to each path and everything
+// under it, if there are any paths.
+func writeRule(b *strings.Builder, action string, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	fmt.Fprintf(b, "(%s", action)
+	for _, p := range paths {
+		fmt.Fprintf(b, "\n  (subpath %s)", quote(p))
+	}
+	b.WriteString(")\n")
+}
+
+// quote returns s as a profile string literal.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
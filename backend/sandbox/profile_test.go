@@ -0,0 +1,60 @@
+package sandbox
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	got := Profile(ProfileOptions{
+		Home:       "/Users/me",
+		ReadOnly:   []string{"/Users/me/.gitconfig"},
+		ReadWrite:  []string{"/Users/me/src/repo", "/Users/me/.claude"},
+		Executable: []string{"/Users/me/.local/bin"},
+	})
+	want := `(version 1)
+(allow default)
+(deny appleevent-send)
+(deny mach-lookup
+  (global-name "com.apple.coreservices.launchservicesd")
+  (global-name "com.apple.coreservices.quarantine-resolver"))
+(deny process-exec
+  (literal "/bin/launchctl")
+  (literal "/usr/bin/osascript")
+  (literal "/usr/bin/open"))
+(deny file-write*)
+(allow file-write*
+  (subpath "/private/tmp")
+  (subpath "/private/var/folders")
+  (subpath "/dev")
+  (subpath "/Users/me/src/repo")
+  (subpath "/Users/me/.claude"))
+(deny file-read-data
+  (subpath "/Users/me"))
+(allow file-read-data
+  (subpath "/Users/me/.gitconfig")
+  (subpath "/Users/me/src/repo")
+  (subpath "/Users/me/.claude")
+  (subpath "/Users/me/.local/bin"))
+`
+	if got != want {
+		t.Errorf("Profile() =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestProfileNoNetwork(t *testing.T) {
+	got := Profile(ProfileOptions{NoNetwork: true})
+	if !strings.Contains(got, "(deny network*)\n(allow network* (local unix))\n") {
+		t.Errorf("expected network denied, got:\n%s", got)
+	}
+	if strings.Contains(got, "file-read-data") {
+		t.Errorf("expected no read rules without a home directory, got:\n%s", got)
+	}
+}
+
+func TestProfileQuotes(t *testing.T) {
+	got := Profile(ProfileOptions{ReadWrite: []string{`/tmp/a "b"\c`}})
+	if !strings.Contains(got, `(subpath "/tmp/a \"b\"\\c")`) {
+		t.Errorf("expected the path quoted, got:\n%s", got)
+	}
+}
@@ -0,0 +1,430 @@
+//go:build darwin
+
+// Package sandbox runs tools directly on the macOS host inside a
+// sandbox-exec (seatbelt) profile generated from the mounts, instead of in a
+// container or VM. It starts instantly but isolates far less: the tool sees
+// the host's processes, binaries and environment, and only file access and
+// optionally the network are restricted.
+package sandbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/leighmcculloch/silo/backend" // parent package
+	"github.com/leighmcculloch/silo/statedir"
+)
+
+// Client implements backend.Backend using sandbox-exec.
+type Client struct{}
+
+// sandboxExec is the path of the sandbox-exec command, part of macOS.
+const sandboxExec = "/usr/bin/sandbox-exec"
+
+// NewClient creates a new sandbox-exec client.
+func NewClient() (*Client, error) {
+	if _, err := os.Stat(sandboxExec); err != nil {
+		return nil, fmt.Errorf("sandbox-exec not found: %w", err)
+	}
+	return &Client{}, nil
+}
+
+// Features are the silo features supported by the sandbox backend. Tools
+// run as host processes, so there is nothing to exec into, keep, or attach
+// to, and ports are the host's.
+var Features = backend.Features{
+	FileMounts: true,
+}
+
+// Probe reports whether sandbox-exec is available.
+func Probe(ctx context.Context) backend.Info {
+	if _, err := os.Stat(sandboxExec); err != nil {
+		return backend.Info{Detail: "sandbox-exec not found"}
+	}
+	return backend.Info{Available: true}
+}
+
+// Close is a no-op for the sandbox backend.
+func (c *Client) Close() error {
+	return nil
+}
+
+// ImageExists always returns true: the tool runs from the host, so there is
+// no image to build.
+func (c *Client) ImageExists(ctx context.Context, name string) (bool, error) {
+	return true, nil
+}
+
+// ImageArch returns the host's architecture.
+func (c *Client) ImageArch(ctx context.Context, name string) (string, error) {
+	return runtime.GOARCH, nil
+}
+
+// Build is a no-op: the tool must be installed on the host.
+func (c *Client) Build(ctx context.Context, opts backend.BuildOptions) (string, error) {
+	if opts.Tag != "" {
+		return opts.Tag, nil
+	}
+	return opts.Target, nil
+}
+
+// session is the record of a running session, kept in the state directory
+// so List, Stop and Remove can find it.
+type session struct {
+	Name    string            `json:"name"`
+	PID     int               `json:"pid"`
+	WorkDir string            `json:"workdir"`
+	Created time.Time         `json:"created"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// sessionDir returns the directory holding the session records.
+func sessionDir() string {
+	return statedir.Path("sandbox")
+}
+
+// unsupported returns an error naming the first option the sandbox backend
+// can't provide, or nil.
+func unsupported(opts backend.RunOptions) error {
+	switch {
+	case opts.Detach:
+		return errors.New("the sandbox backend can't run detached")
+	case opts.Keep:
+		return errors.New("the sandbox backend can't keep sessions")
+	case len(opts.MountsMapped) > 0:
+		return errors.New("the sandbox backend can't mount copies of directories (ephemeral tools, isolate, profile strict)")
+	case len(opts.Volumes) > 0:
+		return errors.New("the sandbox backend doesn't support volumes")
+	case len(opts.Tmpfs) > 0:
+		return errors.New("the sandbox backend doesn't support tmpfs")
+	case opts.DockerInDocker != "":
+		return errors.New("the sandbox backend doesn't support docker_in_docker")
+	case opts.Network.Mode == backend.NetworkAllowlist:
+		return errors.New("the sandbox backend only supports network full or none")
+	case len(opts.Services) > 0:
+		return errors.New("the sandbox backend doesn't support services")
+	}
+	return nil
+}
+
+// agentPaths returns the read-only paths with the agent sockets of opts
+// added, so the tool can reach agents whose socket is in the home directory,
+// such as 1Password's SSH agent and gpg-agent on macOS. The tool runs the
+// host's gpg, which connects to the standard gpg-agent socket next to the
+// extra one and reads the public keyring.
+func agentPaths(mountsRO []string, opts backend.RunOptions) []string {
+	paths := slices.Clip(mountsRO)
+	if opts.SSHAgent != "" {
+		paths = append(paths, opts.SSHAgent)
+	}
+	if opts.GPGAgent != "" {
+		paths = append(paths, filepath.Join(filepath.Dir(opts.GPGAgent), "S.gpg-agent"))
+		paths = append(paths, opts.GPGKeyring...)
+	}
+	return paths
+}
+
+// hostEnv are the host environment variables passed to the tool in addition
+// to the configured ones, which it needs to run as a host process.
+var hostEnv = []string{"PATH", "HOME", "USER", "LOGNAME", "SHELL", "TERM", "COLORTERM", "LANG", "LC_ALL", "TMPDIR"}
+
+// Run runs the tool on the host inside a seatbelt profile allowing writes
+// only to the read-write mounts, and attaches the terminal until it exits.
+func (c *Client) Run(ctx context.Context, opts backend.RunOptions) error {
+	if err := unsupported(opts); err != nil {
+		return err
+	}
+	if len(opts.Command) == 0 {
+		return errors.New("no command to run")
+	}
+	executable, err := exec.LookPath(opts.Command[0])
+	if err != nil {
+		return fmt.Errorf("%s is not installed on the host, which the sandbox backend requires: %w", opts.Command[0], err)
+	}
+
+	home, _ := os.UserHomeDir()
+	profile := Profile(ProfileOptions{
+		Home:       realPath(home),
+		ReadOnly:   realPaths(agentPaths(opts.MountsRO, opts)),
+		ReadWrite:  realPaths(opts.MountsRW),
+		Executable: realPaths([]string{executable, filepath.Dir(executable)}),
+		NoNetwork:  opts.Network.Mode == backend.NetworkNone,
+	})
+
+	// Hooks run in the same shell as the tool, inside the sandbox. The tool
+	// is attached to silo's stdin, stdout, and stderr, whether they are a
+	// terminal or not.
+	script := backend.StartScript(opts.PreRunHooks, append(slices.Clone(opts.Command), opts.Args...), opts.NoTTY)
+	args := []string{"-p", profile, "/bin/bash", "-c", script}
+
+	cmd := exec.Command(sandboxExec, args...)
+	cmd.Dir = opts.WorkDir
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if opts.NoTTY && opts.Output != nil {
+		cmd.Stdout = io.MultiWriter(os.Stdout, opts.Output)
+		cmd.Stderr = io.MultiWriter(os.Stderr, opts.Output)
+	}
+	for _, name := range hostEnv {
+		if v, ok := os.LookupEnv(name); ok {
+			cmd.Env = append(cmd.Env, name+"="+v)
+		}
+	}
+	cmd.Env = append(cmd.Env, opts.Env...)
+	if opts.SSHAgent != "" {
+		cmd.Env = append(cmd.Env, "SSH_AUTH_SOCK="+opts.SSHAgent)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start sandbox-exec: %w", err)
+	}
+	if opts.OnStart != nil {
+		opts.OnStart()
+	}
+	record := filepath.Join(sessionDir(), opts.Name+".json")
+	if data, err := json.Marshal(session{
+		Name:    opts.Name,
+		PID:     cmd.Process.Pid,
+		WorkDir: opts.WorkDir,
+		Created: time.Now(),
+		Labels:  opts.Labels,
+	}); err == nil {
+		if os.MkdirAll(sessionDir(), 0o700) == nil {
+			_ = os.WriteFile(record, data, 0o600)
+		}
+	}
+	defer os.Remove(record)
+
+	// Ctrl-C reaches the tool from the terminal; silo waits for it to exit.
+	// Without a terminal, such as when a batch session times out, silo
+	// stops the tool itself.
+	if opts.NoTTY {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			select {
+			case <-ctx.Done():
+				cmd.Process.Signal(syscall.SIGTERM)
+			case <-done:
+			}
+		}()
+	}
+	err = cmd.Wait()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return backend.NewExitError(exitErr)
+	}
+	return err
+}
+
+// realPath returns path with symlinks resolved, as seatbelt matches paths
+// after resolving them (e.g. /tmp is /private/tmp), or path if it doesn't
+// exist.
+func realPath(path string) string {
+	if p, err := filepath.EvalSymlinks(path); err == nil {
+		return p
+	}
+	return path
+}
+
+func realPaths(paths []string) []string {
+	var real []string
+	for _, p := range paths {
+		real = append(real, realPath(p))
+	}
+	return real
+}
+
+// RunCommand returns the sandbox-exec command line Run uses, with the
+// profile elided, for silo plan.
+func RunCommand(opts backend.RunOptions) []string {
+	args := []string{sandboxExec, "-p", "<profile>", "/bin/bash", "-c", "<pre-run hooks>", "bash"}
+	args = append(args, opts.Command...)
+	return append(args, opts.Args...)
+}
+
+// sessions returns the recorded sessions, removing records of sessions
+// whose process is gone.
+func sessions() []session {
+	files, _ := filepath.Glob(filepath.Join(sessionDir(), "*.json"))
+	var all []session
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		var s session
+		if json.Unmarshal(data, &s) != nil || syscall.Kill(s.PID, 0) != nil {
+			os.Remove(f)
+			continue
+		}
+		all = append(all, s)
+	}
+	return all
+}
+
+// find returns the running session with the name.
+func find(name string) (session, error) {
+	for _, s := range sessions() {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return session{}, fmt.Errorf("container %s not found", name)
+}
+
+// NextContainerName returns the next available name for a session.
+func (c *Client) NextContainerName(ctx context.Context, baseName string) string {
+	var names []string
+	for _, s := range sessions() {
+		names = append(names, s.Name)
+	}
+	return backend.NextName(baseName, names)
+}
+
+// List returns the running sessions. Their status says they run on the host
+// with weaker isolation than a container.
+func (c *Client) List(ctx context.Context) ([]backend.ContainerInfo, error) {
+	var infos []backend.ContainerInfo
+	for _, s := range sessions() {
+		infos = append(infos, backend.ContainerInfo{
+			Name:      s.Name,
+			Image:     "(host)",
+			Status:    "running on host (weaker isolation)",
+			IsRunning: true,
+			Created:   s.Created,
+			Labels:    s.Labels,
+		})
+	}
+	return infos, nil
+}
+
+// Remove stops the named sessions.
+func (c *Client) Remove(ctx context.Context, names []string) ([]string, error) {
+	var removed []string
+	for _, s := range sessions() {
+		if !slices.Contains(names, s.Name) {
+			continue
+		}
+		if err := syscall.Kill(s.PID, syscall.SIGTERM); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return removed, fmt.Errorf("failed to stop %s: %w", s.Name, err)
+		}
+		removed = append(removed, s.Name)
+	}
+	return removed, nil
+}
+
+// Stop stops a running session. Sessions can't be restarted, so this is
+// the same as removing it.
+func (c *Client) Stop(ctx context.Context, name string) error {
+	if _, err := find(name); err != nil {
+		return err
+	}
+	_, err := c.Remove(ctx, []string{name})
+	return err
+}
+
+// Kill kills a running session straight away.
+func (c *Client) Kill(ctx context.Context, name string) error {
+	s, err := find(name)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Kill(s.PID, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("failed to kill %s: %w", name, err)
+	}
+	return nil
+}
+
+// Exec is not supported: the session is a host process, run commands on
+// the host instead.
+func (c *Client) Exec(ctx context.Context, name string, command []string) error {
+	if _, err := find(name); err != nil {
+		return err
+	}
+	return errors.New("the sandbox backend doesn't support exec")
+}
+
+// Start is not supported as sessions can't be kept.
+func (c *Client) Start(ctx context.Context, name string, attach bool) error {
+	if _, err := find(name); err != nil {
+		return err
+	}
+	return fmt.Errorf("container %s is already running", name)
+}
+
+// Attach is not supported as sessions always run attached.
+func (c *Client) Attach(ctx context.Context, name string) error {
+	if _, err := find(name); err != nil {
+		return err
+	}
+	return errors.New("the sandbox backend doesn't support attach")
+}
+
+// Logs is not supported as sessions write to the terminal they run in.
+func (c *Client) Logs(ctx context.Context, name string, follow bool, w io.Writer) error {
+	if _, err := find(name); err != nil {
+		return err
+	}
+	return errors.New("the sandbox backend doesn't keep logs")
+}
+
+// ListImages returns nothing: the sandbox backend has no images.
+func (c *Client) ListImages(ctx context.Context) ([]backend.ImageInfo, error) {
+	return nil, nil
+}
+
+// RemoveImages removes nothing: the sandbox backend has no images.
+func (c *Client) RemoveImages(ctx context.Context, names []string) ([]string, error) {
+	return nil, nil
+}
+
+// PushImage is not supported: the sandbox backend has no images.
+func (c *Client) PushImage(ctx context.Context, name, ref string) (string, error) {
+	return "", errors.New("the sandbox backend has no images")
+}
+
+// PullImage is not supported: the sandbox backend has no images.
+func (c *Client) PullImage(ctx context.Context, ref, name string) (string, error) {
+	return "", errors.New("the sandbox backend has no images")
+}
+
+// SaveImage is not supported: the sandbox backend has no images.
+func (c *Client) SaveImage(ctx context.Context, name, path string) error {
+	return errors.New("the sandbox backend has no images")
+}
+
+// LoadImage is not supported: the sandbox backend has no images.
+func (c *Client) LoadImage(ctx context.Context, path string) error {
+	return errors.New("the sandbox backend has no images")
+}
+
+// ListVolumes returns nothing: the sandbox backend has no volumes.
+func (c *Client) ListVolumes(ctx context.Context) ([]backend.VolumeInfo, error) {
+	return nil, nil
+}
+
+// RemoveVolumes removes nothing: the sandbox backend has no volumes.
+func (c *Client) RemoveVolumes(ctx context.Context, names []string) ([]string, error) {
+	return nil, nil
+}
+
+// PruneSidecars removes nothing: the sandbox backend starts nothing
+// alongside the tool.
+func (c *Client) PruneSidecars(ctx context.Context, dryRun bool) ([]string, error) {
+	return nil, nil
+}
+
+// Ensure Client implements backend.Backend at compile time.
+var _ backend.Backend = (*Client)(nil)
@@ -0,0 +1,153 @@
+//go:build !darwin
+
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/leighmcculloch/silo/backend" // parent package
+)
+
+// Client is a stub for non-Darwin platforms.
+type Client struct{}
+
+// NewClient returns an error on non-Darwin platforms as the sandbox backend
+// requires macOS's sandbox-exec.
+func NewClient() (*Client, error) {
+	return nil, fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// Features are the silo features supported by the sandbox backend.
+var Features = backend.Features{
+	FileMounts: true,
+}
+
+// Probe reports the sandbox backend as unavailable on non-Darwin platforms.
+func Probe(ctx context.Context) backend.Info {
+	return backend.Info{Detail: "sandbox backend is only available on macOS"}
+}
+
+// Close is a no-op stub.
+func (c *Client) Close() error {
+	return nil
+}
+
+// ImageExists is a stub that always returns an error.
+func (c *Client) ImageExists(ctx context.Context, name string) (bool, error) {
+	return false, fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// ImageArch is a stub that always returns an error.
+func (c *Client) ImageArch(ctx context.Context, name string) (string, error) {
+	return "", fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// Build is a stub that always returns an error.
+func (c *Client) Build(ctx context.Context, opts backend.BuildOptions) (string, error) {
+	return "", fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// Run is a stub that always returns an error.
+func (c *Client) Run(ctx context.Context, opts backend.RunOptions) error {
+	return fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// RunCommand is a stub that returns nil.
+func RunCommand(opts backend.RunOptions) []string {
+	return nil
+}
+
+// Exec is a stub that always returns an error.
+func (c *Client) Exec(ctx context.Context, name string, command []string) error {
+	return fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// Stop is a stub that always returns an error.
+func (c *Client) Stop(ctx context.Context, name string) error {
+	return fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// Kill is a stub that always returns an error.
+func (c *Client) Kill(ctx context.Context, name string) error {
+	return fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// Start is a stub that always returns an error.
+func (c *Client) Start(ctx context.Context, name string, attach bool) error {
+	return fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// Attach is a stub that always returns an error.
+func (c *Client) Attach(ctx context.Context, name string) error {
+	return fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// Logs is a stub that always returns an error.
+func (c *Client) Logs(ctx context.Context, name string, follow bool, w io.Writer) error {
+	return fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// List is a stub that always returns an error.
+func (c *Client) List(ctx context.Context) ([]backend.ContainerInfo, error) {
+	return nil, fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// Remove is a stub that always returns an error.
+func (c *Client) Remove(ctx context.Context, names []string) ([]string, error) {
+	return nil, fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// ListImages is a stub that always returns an error.
+func (c *Client) ListImages(ctx context.Context) ([]backend.ImageInfo, error) {
+	return nil, fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// RemoveImages is a stub that always returns an error.
+func (c *Client) RemoveImages(ctx context.Context, names []string) ([]string, error) {
+	return nil, fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// PushImage is a stub that always returns an error.
+func (c *Client) PushImage(ctx context.Context, name, ref string) (string, error) {
+	return "", fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// PullImage is a stub that always returns an error.
+func (c *Client) PullImage(ctx context.Context, ref, name string) (string, error) {
+	return "", fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// SaveImage is a stub that always returns an error.
+func (c *Client) SaveImage(ctx context.Context, name, path string) error {
+	return fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// LoadImage is a stub that always returns an error.
+func (c *Client) LoadImage(ctx context.Context, path string) error {
+	return fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// ListVolumes is a stub that always returns an error.
+func (c *Client) ListVolumes(ctx context.Context) ([]backend.VolumeInfo, error) {
+	return nil, fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// RemoveVolumes is a stub that always returns an error.
+func (c *Client) RemoveVolumes(ctx context.Context, names []string) ([]string, error) {
+	return nil, fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// PruneSidecars is a stub that always returns an error.
+func (c *Client) PruneSidecars(ctx context.Context, dryRun bool) ([]string, error) {
+	return nil, fmt.Errorf("sandbox backend is only available on macOS")
+}
+
+// NextContainerName is a stub that returns an empty string.
+func (c *Client) NextContainerName(ctx context.Context, baseName string) string {
+	return ""
+}
+
+// Ensure Client implements backend.Backend at compile time.
+var _ backend.Backend = (*Client)(nil)
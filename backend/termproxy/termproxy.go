@@ -0,0 +1,432 @@
+// Package termproxy connects the local terminal to a session's TTY. It
+// forwards input, intercepting Ctrl-C pressed three times in a row and the
+// key that opens the quick actions menu, and holds back session output while
+// the menu is open.
+package termproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/leighmcculloch/silo/termstate"
+	"golang.org/x/sys/unix"
+)
+
+// bufSize is the size of input reads, large enough that a paste is
+// forwarded in one write.
+const bufSize = 32 * 1024
+
+// pollInterval is how often a read waiting for terminal input checks
+// whether it has been cancelled.
+const pollInterval = 100 * time.Millisecond
+
+// DefaultMenuKey opens the quick actions menu unless configured otherwise.
+const DefaultMenuKey = 0x1c // Ctrl-\
+
+// interruptPresses is how many times Ctrl-C is pressed in a row, each within
+// a second of the last, to interrupt a session. Fewer go to the tool, which
+// may use a double Ctrl-C itself.
+const interruptPresses = 3
+
+// StopNotice is written to the terminal when a session is being stopped
+// after an interrupt.
+const StopNotice = "\r\nsilo: stopping the session, press Ctrl-C three times again to kill it\r\n"
+
+var (
+	// ErrInterrupted is returned by CopyInput when Ctrl-C is pressed three
+	// times in a row, and the stop is confirmed if ConfirmInterrupt is set.
+	ErrInterrupted = errors.New("interrupted")
+
+	// ErrDetached is returned by CopyInput when detach is chosen in the menu.
+	ErrDetached = errors.New("detached")
+)
+
+// Menu configures the quick actions menu.
+type Menu struct {
+	// Key is the control character that opens the menu
+	Key byte
+
+	// Name is the session's container name
+	Name string
+
+	// Mounts are shown by the show mounts action, one per line
+	Mounts []string
+
+	// Dir is the host directory the diff action runs git diff in
+	Dir string
+
+	// Stop stops the session. The action is hidden if nil.
+	Stop func()
+
+	// Detach offers leaving the session running in the background
+	Detach bool
+
+	// Redraw asks the tool to redraw its screen after the menu closes,
+	// e.g. by resizing its TTY. Optional.
+	Redraw func()
+}
+
+// Proxy copies terminal input to a session and session output to the
+// terminal.
+type Proxy struct {
+	in      io.Reader
+	term    io.Writer
+	session io.Writer
+	menu    *Menu
+
+	// ConfirmInterrupt asks before CopyInput returns ErrInterrupted, and
+	// carries on copying input if the stop isn't confirmed
+	ConfirmInterrupt bool
+
+	mu     sync.Mutex
+	paused bool
+	held   bytes.Buffer
+
+	// pending is input read but not yet handled, such as keys typed after
+	// the menu key in the same read
+	pending []byte
+}
+
+// New returns a Proxy that copies input from in to session and, through
+// Write, output to term. menu may be nil to disable the menu.
+func New(in io.Reader, term, session io.Writer, menu *Menu) *Proxy {
+	if menu != nil && menu.Key == 0 {
+		menu = nil
+	}
+	return &Proxy{in: in, term: term, session: session, menu: menu}
+}
+
+// Write writes session output to the terminal. Output is held back while
+// the menu is open and written when it closes.
+func (p *Proxy) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused {
+		return p.held.Write(b)
+	}
+	return p.term.Write(b)
+}
+
+// CopyInput copies input to the session until the input ends, the session
+// stops accepting it, or ctx is done, returning nil. It returns
+// ErrInterrupted when Ctrl-C is pressed three times in a row and ErrDetached
+// if detach is chosen in the menu. Input after the interrupt is kept for the
+// next call.
+//
+// Writes to the session block while it isn't reading, leaving further input
+// buffered by the terminal rather than dropped. When the input is a
+// terminal, CopyInput stops without consuming any more of it once ctx is
+// done, so keys typed after the session ends reach whatever reads next.
+func (p *Proxy) CopyInput(ctx context.Context) error {
+	var lastCtrlC time.Time
+	presses := 0
+	buf := make([]byte, bufSize)
+	for {
+		n, err := p.read(ctx, buf)
+		data := buf[:n]
+		for i := 0; i < len(data); i++ {
+			switch {
+			case data[i] == 0x03:
+				now := time.Now()
+				if now.Sub(lastCtrlC) >= time.Second {
+					presses = 0
+				}
+				presses++
+				lastCtrlC = now
+				if presses < interruptPresses {
+					continue
+				}
+				presses = 0
+				// Forward what came before the last Ctrl-C, and keep what
+				// came after it
+				if _, err := p.session.Write(data[:i]); err != nil {
+					return nil
+				}
+				p.unread(data[i+1:])
+				data = nil
+				if !p.ConfirmInterrupt || p.confirmInterrupt(ctx) {
+					return ErrInterrupted
+				}
+			case p.menu != nil && data[i] == p.menu.Key:
+				// Forward what came before the key, and leave what came
+				// after it for the menu and then the session
+				if _, err := p.session.Write(data[:i]); err != nil {
+					return nil
+				}
+				p.unread(data[i+1:])
+				data = nil
+				if err := p.openMenu(ctx); err != nil {
+					return err
+				}
+			}
+		}
+		if len(data) > 0 {
+			if _, err := p.session.Write(data); err != nil {
+				return nil
+			}
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+// read reads pending input, or waits for input until ctx is done. It
+// returns io.EOF once ctx is done.
+func (p *Proxy) read(ctx context.Context, b []byte) (int, error) {
+	if len(p.pending) > 0 {
+		n := copy(b, p.pending)
+		p.pending = p.pending[n:]
+		return n, nil
+	}
+	if f, ok := p.in.(*os.File); ok {
+		if err := waitReadable(ctx, int(f.Fd())); err != nil {
+			return 0, err
+		}
+	} else if ctx.Err() != nil {
+		return 0, io.EOF
+	}
+	return p.in.Read(b)
+}
+
+// unread returns b to the front of the input.
+func (p *Proxy) unread(b []byte) {
+	p.pending = append(append([]byte(nil), b...), p.pending...)
+}
+
+// waitReadable waits until fd has input or ctx is done, in which case it
+// returns io.EOF. Waiting instead of blocking in read means an abandoned
+// read doesn't swallow the next key typed.
+func waitReadable(ctx context.Context, fd int) error {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		if ctx.Err() != nil {
+			return io.EOF
+		}
+		n, err := unix.Poll(fds, int(pollInterval/time.Millisecond))
+		switch {
+		case err == unix.EINTR:
+			continue
+		case err != nil:
+			// Not pollable, fall back to a blocking read
+			return nil
+		case n > 0:
+			return nil
+		}
+	}
+}
+
+// pause holds back session output and switches to the alternate screen,
+// until the returned function is called.
+func (p *Proxy) pause() func() {
+	p.mu.Lock()
+	p.paused = true
+	p.mu.Unlock()
+	leaveAltScreen := termstate.AltScreen(p.term)
+	return func() {
+		// Leave the alternate screen, then write output the tool produced
+		// in the meantime
+		p.mu.Lock()
+		leaveAltScreen()
+		p.term.Write(p.held.Bytes())
+		p.held.Reset()
+		p.paused = false
+		p.mu.Unlock()
+	}
+}
+
+// confirmInterrupt asks on the alternate screen whether to stop the
+// session, and reports whether to.
+func (p *Proxy) confirmInterrupt(ctx context.Context) bool {
+	resume := p.pause()
+	title := "Stop the session?"
+	if p.menu != nil && p.menu.Name != "" {
+		title = "Stop " + p.menu.Name + "?"
+	}
+	p.screen(title, []string{
+		"y  Stop it",
+		"n  Back to the session",
+	})
+	key := make([]byte, 16)
+	n, err := p.read(ctx, key)
+	stop := err == nil && n > 0 && (key[0] == 'y' || key[0] == 'Y')
+	resume()
+	if !stop && p.menu != nil && p.menu.Redraw != nil {
+		p.menu.Redraw()
+	}
+	return stop
+}
+
+// openMenu shows the menu on the alternate screen until it is closed.
+func (p *Proxy) openMenu(ctx context.Context) error {
+	resume := p.pause()
+	err := p.runMenu(ctx)
+	resume()
+	switch {
+	case errors.Is(err, ErrDetached):
+		fmt.Fprintf(p.term, "\r\nDetached from %s. Reattach with: silo attach %s\r\n", p.menu.Name, p.menu.Name)
+	case err == nil && p.menu.Redraw != nil:
+		p.menu.Redraw()
+	}
+	return err
+}
+
+// runMenu handles key presses in the menu until it is closed.
+func (p *Proxy) runMenu(ctx context.Context) error {
+	m := p.menu
+	key := make([]byte, 16)
+	for {
+		p.drawMenu()
+		n, err := p.read(ctx, key)
+		if err != nil {
+			return nil
+		}
+		if n == 0 {
+			continue
+		}
+		// Keep keys typed ahead, but drop the rest of escape sequences
+		// such as arrow keys
+		if key[0] != 0x1b {
+			p.unread(key[1:n])
+		}
+		switch key[0] {
+		case 'm':
+			p.screen("Mounts", m.Mounts)
+			if !p.waitKey(ctx) {
+				return nil
+			}
+		case 'd':
+			p.showDiff(ctx)
+		case 's':
+			if m.Stop != nil {
+				p.screen("Stopping "+m.Name+"...", nil)
+				go m.Stop()
+				return nil
+			}
+		case 'x':
+			if m.Detach {
+				return ErrDetached
+			}
+		case m.Key:
+			// Pressing the key again sends it to the tool
+			p.session.Write([]byte{m.Key})
+			return nil
+		case 0x1b, 'q':
+			return nil
+		}
+	}
+}
+
+// drawMenu clears the screen and draws the menu.
+func (p *Proxy) drawMenu() {
+	m := p.menu
+	items := []string{
+		"m  Show mounts",
+		"d  Show git diff",
+	}
+	if m.Stop != nil {
+		items = append(items, "s  Stop session")
+	}
+	if m.Detach {
+		items = append(items, "x  Detach (session keeps running)")
+	}
+	items = append(items,
+		KeyName(m.Key)+"  Send "+KeyName(m.Key)+" to the tool",
+		"q  Back to the session",
+	)
+	p.screen("silo: "+m.Name, items)
+}
+
+// screen clears the screen and writes a title and lines.
+func (p *Proxy) screen(title string, lines []string) {
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	b.WriteString(title + "\r\n\r\n")
+	for _, l := range lines {
+		b.WriteString("  " + l + "\r\n")
+	}
+	fmt.Fprint(p.term, b.String())
+}
+
+// waitKey waits for a key press and reports whether input is still open.
+func (p *Proxy) waitKey(ctx context.Context) bool {
+	fmt.Fprint(p.term, "\r\nPress any key to return")
+	key := make([]byte, 16)
+	n, err := p.read(ctx, key)
+	if n > 0 && key[0] != 0x1b {
+		p.unread(key[1:n])
+	}
+	return err == nil
+}
+
+// showDiff shows uncommitted changes in the session's directory through
+// git's pager.
+func (p *Proxy) showDiff(ctx context.Context) {
+	m := p.menu
+	if err := exec.Command("git", "-C", m.Dir, "rev-parse", "--git-dir").Run(); err != nil {
+		p.screen("Diff", []string{m.Dir + " is not a git repository"})
+		p.waitKey(ctx)
+		return
+	}
+	if exec.Command("git", "-C", m.Dir, "diff", "--quiet", "HEAD").Run() == nil {
+		p.screen("Diff", []string{"No uncommitted changes"})
+		p.waitKey(ctx)
+		return
+	}
+
+	fmt.Fprint(p.term, "\x1b[H\x1b[2J")
+	cmd := exec.Command("git", "-C", m.Dir, "-c", "color.ui=always", "diff", "HEAD")
+	// Page even short diffs so they aren't cleared immediately
+	cmd.Env = append(os.Environ(), "LESS=R")
+	cmd.Stdout = p.term
+	cmd.Stderr = p.term
+	if f, ok := p.in.(*os.File); ok {
+		cmd.Stdin = f
+	}
+	cmd.Run()
+}
+
+// ParseKey parses a menu key such as "ctrl-\" or "ctrl-g". "none" disables
+// the menu and returns 0; an empty string returns DefaultMenuKey.
+func ParseKey(s string) (byte, error) {
+	switch s {
+	case "":
+		return DefaultMenuKey, nil
+	case "none":
+		return 0, nil
+	}
+	rest, ok := strings.CutPrefix(strings.ToLower(s), "ctrl-")
+	if !ok || len(rest) != 1 {
+		return 0, fmt.Errorf("invalid key %q (must be ctrl-<key> or none)", s)
+	}
+	c := rest[0]
+	switch {
+	case c >= 'a' && c <= 'z':
+		c = c - 'a' + 1
+	case c == '\\' || c == ']' || c == '^' || c == '_':
+		c = c - '@'
+	default:
+		return 0, fmt.Errorf("invalid key %q (must be ctrl-a to ctrl-z, ctrl-\\, ctrl-], ctrl-^, or ctrl-_)", s)
+	}
+	switch c {
+	case 0x03, 0x08, 0x09, 0x0a, 0x0d:
+		return 0, fmt.Errorf("invalid key %q: it is needed by the tool", s)
+	}
+	return c, nil
+}
+
+// KeyName returns the name of a control key, e.g. "Ctrl-\".
+func KeyName(key byte) string {
+	if key >= 1 && key <= 26 {
+		return "Ctrl-" + string(rune('A'+key-1))
+	}
+	return "Ctrl-" + string(rune(key+'@'))
+}
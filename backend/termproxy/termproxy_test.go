@@ -0,0 +1,215 @@
+package termproxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// chunkReader returns one chunk per Read, like keys typed at a terminal.
+type chunkReader struct {
+	chunks []string
+}
+
+func (r *chunkReader) Read(b []byte) (int, error) {
+	if len(r.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, r.chunks[0])
+	r.chunks = r.chunks[1:]
+	return n, nil
+}
+
+func TestCopyInput(t *testing.T) {
+	var term, session bytes.Buffer
+	p := New(&chunkReader{chunks: []string{"ab", "c"}}, &term, &session, nil)
+	if err := p.CopyInput(context.Background()); err != nil {
+		t.Fatalf("expected nil at end of input, got %v", err)
+	}
+	if session.String() != "abc" {
+		t.Errorf("expected input forwarded, got %q", session.String())
+	}
+}
+
+func TestCopyInputTripleCtrlC(t *testing.T) {
+	var term, session bytes.Buffer
+	p := New(&chunkReader{chunks: []string{"\x03", "a\x03", "\x03b", "after"}}, &term, &session, nil)
+	if err := p.CopyInput(context.Background()); err != ErrInterrupted {
+		t.Fatalf("expected ErrInterrupted, got %v", err)
+	}
+	if session.String() != "\x03a\x03" {
+		t.Errorf("expected only the first two Ctrl-Cs forwarded, got %q", session.String())
+	}
+
+	// Input after the interrupt goes to the session once copying resumes
+	if err := p.CopyInput(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if session.String() != "\x03a\x03bafter" {
+		t.Errorf("expected input after the interrupt forwarded, got %q", session.String())
+	}
+}
+
+func TestCopyInputConfirmInterrupt(t *testing.T) {
+	var term, session bytes.Buffer
+	p := New(&chunkReader{chunks: []string{"\x03\x03\x03", "n", "x", "\x03\x03\x03", "y"}}, &term, &session, nil)
+	p.ConfirmInterrupt = true
+	if err := p.CopyInput(context.Background()); err != ErrInterrupted {
+		t.Fatalf("expected ErrInterrupted once confirmed, got %v", err)
+	}
+	if session.String() != "\x03\x03x\x03\x03" {
+		t.Errorf("expected input around the declined stop forwarded, got %q", session.String())
+	}
+	if !strings.Contains(term.String(), "Stop the session?") {
+		t.Errorf("expected a prompt, got %q", term.String())
+	}
+}
+
+func TestMenuDetach(t *testing.T) {
+	var term, session bytes.Buffer
+	menu := &Menu{Key: DefaultMenuKey, Name: "proj-1", Detach: true}
+	p := New(&chunkReader{chunks: []string{"ab\x1cx", "after"}}, &term, &session, menu)
+	if err := p.CopyInput(context.Background()); err != ErrDetached {
+		t.Fatalf("expected ErrDetached, got %v", err)
+	}
+	if session.String() != "ab" {
+		t.Errorf("expected input before the key forwarded, got %q", session.String())
+	}
+	if !strings.Contains(term.String(), "Detached from proj-1") {
+		t.Errorf("expected detach message, got %q", term.String())
+	}
+}
+
+func TestMenuTypeAhead(t *testing.T) {
+	// Keys typed after the menu key in the same read go to the menu, and
+	// the rest to the session once the menu closes
+	var term, session bytes.Buffer
+	menu := &Menu{Key: DefaultMenuKey, Mounts: []string{"rw  /src"}}
+	p := New(&chunkReader{chunks: []string{"ab\x1cmkqcd", "ef"}}, &term, &session, menu)
+	if err := p.CopyInput(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(term.String(), "rw  /src") {
+		t.Errorf("expected mounts to be shown, got %q", term.String())
+	}
+	if session.String() != "abcdef" {
+		t.Errorf("expected input around the menu forwarded, got %q", session.String())
+	}
+}
+
+func TestCopyInputLargeRead(t *testing.T) {
+	var term, session bytes.Buffer
+	paste := strings.Repeat("x", 10000)
+	p := New(&chunkReader{chunks: []string{paste}}, &term, &session, nil)
+	if err := p.CopyInput(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if session.String() != paste {
+		t.Errorf("expected %d bytes forwarded, got %d", len(paste), session.Len())
+	}
+}
+
+func TestCopyInputCancelled(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	var term, session bytes.Buffer
+	p := New(r, &term, &session, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- p.CopyInput(ctx) }()
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// Input written after the copy stopped is left for the next reader
+	w.Write([]byte("next"))
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(r, b); err != nil || string(b) != "next" {
+		t.Errorf("expected input left unread, got %q, %v", b, err)
+	}
+}
+
+func TestMenuShowMounts(t *testing.T) {
+	var term, session bytes.Buffer
+	redrawn := false
+	menu := &Menu{Key: DefaultMenuKey, Mounts: []string{"rw  /src"}, Redraw: func() { redrawn = true }}
+	p := New(&chunkReader{chunks: []string{"\x1c", "m", "k", "q"}}, &term, &session, menu)
+	if err := p.CopyInput(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(term.String(), "rw  /src") {
+		t.Errorf("expected mounts to be shown, got %q", term.String())
+	}
+	if !redrawn {
+		t.Error("expected redraw after the menu closed")
+	}
+	if session.Len() != 0 {
+		t.Errorf("expected no input forwarded, got %q", session.String())
+	}
+}
+
+func TestWriteHeldWhileMenuOpen(t *testing.T) {
+	var term, session bytes.Buffer
+	p := New(strings.NewReader(""), &term, &session, nil)
+	p.paused = true
+	p.Write([]byte("held"))
+	if term.Len() != 0 {
+		t.Error("expected output to be held while the menu is open")
+	}
+	p.paused = false
+	p.Write([]byte("shown"))
+	if term.String() != "shown" {
+		t.Errorf("expected output written, got %q", term.String())
+	}
+}
+
+func TestMenuSendKey(t *testing.T) {
+	var term, session bytes.Buffer
+	menu := &Menu{Key: DefaultMenuKey}
+	p := New(&chunkReader{chunks: []string{"\x1c", "\x1c", "z"}}, &term, &session, menu)
+	if err := p.CopyInput(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if session.String() != "\x1cz" {
+		t.Errorf("expected key sent to the tool, got %q", session.String())
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	for s, want := range map[string]byte{
+		"":       DefaultMenuKey,
+		"none":   0,
+		`ctrl-\`: 0x1c,
+		"ctrl-]": 0x1d,
+		"Ctrl-G": 0x07,
+		"ctrl-_": 0x1f,
+	} {
+		got, err := ParseKey(s)
+		if err != nil || got != want {
+			t.Errorf("ParseKey(%q) = %#x, %v, want %#x", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"ctrl-c", "ctrl-m", "alt-x", "ctrl-1", "x"} {
+		if _, err := ParseKey(s); err == nil {
+			t.Errorf("ParseKey(%q): expected error", s)
+		}
+	}
+}
+
+func TestKeyName(t *testing.T) {
+	if got := KeyName(0x1c); got != `Ctrl-\` {
+		t.Errorf("KeyName(0x1c) = %q", got)
+	}
+	if got := KeyName(0x07); got != "Ctrl-G" {
+		t.Errorf("KeyName(0x07) = %q", got)
+	}
+}
@@ -3,8 +3,12 @@ package cli
 import (
 	"fmt"
 	"io"
+	"log/slog"
+	"os"
 
+	"github.com/charmbracelet/huh"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/mattn/go-isatty"
 )
 
 // Styles for the CLI output
@@ -34,49 +38,133 @@ var (
 			Foreground(lipgloss.Color("241"))
 )
 
+// accessible is set when output should suit screen readers and simple
+// terminals: no animation, no color, and words instead of symbols.
+var accessible bool
+
+// SetAccessible enables or disables accessible output.
+func SetAccessible(on bool) {
+	accessible = on
+}
+
+// Accessible reports whether accessible output is enabled.
+func Accessible() bool {
+	return accessible
+}
+
 // LogTo prints an informational message with a prefix to the given writer
 func LogTo(w io.Writer, format string, args ...any) {
-	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintln(w, infoStyle.Render("==> "+msg))
+	log(w, slog.LevelInfo, fmt.Sprintf(format, args...), renderInfo)
 }
 
 // LogSuccessTo prints a success message to the given writer
 func LogSuccessTo(w io.Writer, format string, args ...any) {
-	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintln(w, successStyle.Render("✓ "+msg))
+	log(w, slog.LevelInfo, fmt.Sprintf(format, args...), func(msg string) string {
+		if accessible {
+			return "Done: " + msg
+		}
+		return successStyle.Render("✓ " + msg)
+	})
 }
 
 // LogSuccessBulletTo prints an indented success message to the given writer
 func LogSuccessBulletTo(w io.Writer, format string, args ...any) {
-	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintln(w, "  "+successStyle.Render("✓ "+msg))
+	log(w, slog.LevelInfo, fmt.Sprintf(format, args...), func(msg string) string {
+		if accessible {
+			return "  Done: " + msg
+		}
+		return "  " + successStyle.Render("✓ "+msg)
+	})
 }
 
 // LogWarningTo prints a warning message to the given writer
 func LogWarningTo(w io.Writer, format string, args ...any) {
-	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintln(w, warningStyle.Render("! "+msg))
+	log(w, slog.LevelWarn, fmt.Sprintf(format, args...), renderWarning)
 }
 
 // LogErrorTo prints an error message to the given writer
 func LogErrorTo(w io.Writer, format string, args ...any) {
-	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintln(w, errorStyle.Render("✗ "+msg))
+	log(w, slog.LevelError, fmt.Sprintf(format, args...), renderError)
 }
 
 // LogBulletTo prints a bulleted list item to the given writer
 func LogBulletTo(w io.Writer, format string, args ...any) {
-	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintln(w, "  "+bulletStyle.Render()+" "+msg)
+	log(w, slog.LevelInfo, fmt.Sprintf(format, args...), func(msg string) string {
+		if accessible {
+			return "  - " + msg
+		}
+		return "  " + bulletStyle.Render() + " " + msg
+	})
 }
 
 // LogDimTo prints a dimmed message to the given writer
 func LogDimTo(w io.Writer, format string, args ...any) {
-	msg := fmt.Sprintf(format, args...)
-	fmt.Fprintln(w, dimStyle.Render("  "+msg))
+	log(w, slog.LevelInfo, fmt.Sprintf(format, args...), func(msg string) string {
+		if accessible {
+			return "  " + msg
+		}
+		return dimStyle.Render("  " + msg)
+	})
+}
+
+// LogDebugTo prints a debug message to the given writer, shown only with
+// the debug log level
+func LogDebugTo(w io.Writer, format string, args ...any) {
+	log(w, slog.LevelDebug, fmt.Sprintf(format, args...), renderDebug)
+}
+
+func renderInfo(msg string) string {
+	if accessible {
+		return msg
+	}
+	return infoStyle.Render("==> " + msg)
+}
+
+func renderWarning(msg string) string {
+	if accessible {
+		return "Warning: " + msg
+	}
+	return warningStyle.Render("! " + msg)
+}
+
+func renderError(msg string) string {
+	if accessible {
+		return "Error: " + msg
+	}
+	return errorStyle.Render("✗ " + msg)
+}
+
+func renderDebug(msg string) string {
+	if accessible {
+		return "Debug: " + msg
+	}
+	return dimStyle.Render("debug: " + msg)
 }
 
 // Title returns a styled title
 func Title(s string) string {
+	if accessible {
+		return s
+	}
 	return titleStyle.Render(s)
 }
+
+// Confirm asks the user a yes or no question, returning false without
+// asking when stdin isn't a terminal.
+func Confirm(title, description, affirmative, negative string) bool {
+	if !isatty.IsTerminal(os.Stdin.Fd()) {
+		return false
+	}
+	confirmed := false
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title(title).
+				Description(description).
+				Affirmative(affirmative).
+				Negative(negative).
+				Value(&confirmed),
+		),
+	)
+	return form.WithAccessible(accessible).Run() == nil && confirmed
+}
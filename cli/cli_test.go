@@ -97,3 +97,20 @@ func TestTitle(t *testing.T) {
 		t.Errorf("expected title to contain text, got: %s", title)
 	}
 }
+
+func TestAccessibleLogs(t *testing.T) {
+	SetAccessible(true)
+	t.Cleanup(func() { SetAccessible(false) })
+
+	var buf bytes.Buffer
+	LogTo(&buf, "info")
+	LogSuccessTo(&buf, "created")
+	LogWarningTo(&buf, "careful")
+	LogErrorTo(&buf, "failed")
+	LogBulletTo(&buf, "item")
+
+	want := "info\nDone: created\nWarning: careful\nError: failed\n  - item\n"
+	if got := buf.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
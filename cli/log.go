@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// logging is how messages are written: those below level aren't shown,
+// shown messages are JSON lines when json is set, and every message, debug
+// ones too, is also written to file when it isn't nil.
+var logging struct {
+	mu    sync.Mutex
+	level slog.Level
+	json  bool
+	file  io.WriteCloser
+}
+
+// ParseLogLevel returns the level named s: debug, info, warn, or error.
+func ParseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	switch strings.ToLower(s) {
+	case "debug", "info", "warn", "error":
+		return level, level.UnmarshalText([]byte(s))
+	}
+	return level, fmt.Errorf("invalid log level %q: must be debug, info, warn, or error", s)
+}
+
+// SetLogLevel sets the level of the least severe messages shown. Info by
+// default, so debug messages aren't shown.
+func SetLogLevel(level slog.Level) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logging.level = level
+}
+
+// SetLogJSON enables or disables writing messages as JSON lines, with the
+// time, level, and message of each, instead of styled text.
+func SetLogJSON(on bool) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	logging.json = on
+}
+
+// SetLogFile sets a file every message is also written to as a JSON line,
+// whatever the log level, closing the one set before. nil stops writing to
+// a file.
+func SetLogFile(f io.WriteCloser) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	if logging.file != nil {
+		logging.file.Close()
+	}
+	logging.file = f
+}
+
+// DefaultLogger returns a logger for packages that have no writer to log
+// to, such as the backends, that writes to w as the Log functions do. Set
+// it as slog's default so their slog.Debug calls reach the log file, and
+// stderr with --log-level debug.
+func DefaultLogger(w io.Writer) *slog.Logger {
+	return slog.New(&handler{w: w})
+}
+
+// log writes msg at level to w, rendered by render unless messages are
+// written as JSON, and to the log file.
+func log(w io.Writer, level slog.Level, msg string, render func(string) string) {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	if logging.file != nil {
+		writeJSON(logging.file, level, msg)
+	}
+	switch {
+	case level < logging.level:
+	case logging.json:
+		writeJSON(w, level, msg)
+	default:
+		fmt.Fprintln(w, render(msg))
+	}
+}
+
+// writeJSON writes msg at level to w as a JSON line.
+func writeJSON(w io.Writer, level slog.Level, msg string) {
+	slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})).Log(context.Background(), level, msg)
+}
+
+// handler is the slog.Handler of DefaultLogger. Attributes are appended to
+// the message as key=value.
+type handler struct {
+	w     io.Writer
+	attrs []slog.Attr
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	logging.mu.Lock()
+	defer logging.mu.Unlock()
+	return level >= logging.level || logging.file != nil
+}
+
+func (h *handler) Handle(_ context.Context, r slog.Record) error {
+	msg := r.Message
+	appendAttr := func(a slog.Attr) bool {
+		msg += fmt.Sprintf(" %s=%v", a.Key, a.Value)
+		return true
+	}
+	for _, a := range h.attrs {
+		appendAttr(a)
+	}
+	r.Attrs(appendAttr)
+	log(h.w, r.Level, msg, func(msg string) string {
+		switch {
+		case r.Level >= slog.LevelError:
+			return renderError(msg)
+		case r.Level >= slog.LevelWarn:
+			return renderWarning(msg)
+		case r.Level >= slog.LevelInfo:
+			return renderInfo(msg)
+		default:
+			return renderDebug(msg)
+		}
+	})
+	return nil
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &handler{w: h.w, attrs: append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)}
+}
+
+func (h *handler) WithGroup(string) slog.Handler {
+	return h
+}
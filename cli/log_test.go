@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// nopCloser is a log file that is a buffer.
+type nopCloser struct{ bytes.Buffer }
+
+func (*nopCloser) Close() error { return nil }
+
+func TestLogLevels(t *testing.T) {
+	t.Cleanup(func() {
+		SetLogLevel(slog.LevelInfo)
+		SetLogJSON(false)
+		SetLogFile(nil)
+	})
+
+	level, err := ParseLogLevel("warn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetLogLevel(level)
+	file := &nopCloser{}
+	SetLogFile(file)
+
+	var buf bytes.Buffer
+	LogTo(&buf, "info message")
+	LogWarningTo(&buf, "warning message")
+	DefaultLogger(&buf).Debug("debug message", "key", "value")
+	if strings.Contains(buf.String(), "info message") || !strings.Contains(buf.String(), "warning message") {
+		t.Errorf("expected only the warning shown, got: %s", buf.String())
+	}
+	// The file has every message, debug ones too
+	var levels []string
+	for line := range strings.Lines(file.String()) {
+		var entry struct{ Level, Msg string }
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		levels = append(levels, entry.Level+" "+entry.Msg)
+	}
+	want := []string{"INFO info message", "WARN warning message", "DEBUG debug message key=value"}
+	if strings.Join(levels, "\n") != strings.Join(want, "\n") {
+		t.Errorf("log file has %q, want %q", levels, want)
+	}
+
+	SetLogJSON(true)
+	buf.Reset()
+	LogErrorTo(&buf, "error message")
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil || entry["level"] != "ERROR" || entry["msg"] != "error message" || entry["time"] == nil {
+		t.Errorf("expected a JSON line, got: %s", buf.String())
+	}
+
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
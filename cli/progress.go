@@ -3,31 +3,74 @@ package cli
 import (
 	"fmt"
 	"io"
+	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/leighmcculloch/silo/termstate"
 	"github.com/mattn/go-isatty"
-	"golang.org/x/sys/unix"
 )
 
 // ansiRegex matches ANSI escape sequences
 var ansiRegex = regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
 
-// Progress represents a slim progress bar that displays sections
+// DefaultRefreshInterval is the minimum time between redraws caused by
+// detail updates. It can be overridden with SILO_PROGRESS_INTERVAL (a Go
+// duration such as "250ms"; "0" redraws as fast as the terminal allows).
+const DefaultRefreshInterval = 100 * time.Millisecond
+
+const (
+	// paneLines is how many of the latest output lines the view shows.
+	paneLines = 6
+	// maxLogLines is how many output lines of the current section are kept
+	// for Fail to print.
+	maxLogLines = 1000
+	// failLines is how many of the kept lines Fail prints.
+	failLines = 50
+	// maxFPS is the highest frame rate bubbletea supports.
+	maxFPS = 120
+)
+
+// Progress shows the sections of a long operation. On a terminal it draws a
+// live view: a bar with the current section and build step, the steps of
+// each build stage, and a pane with the latest lines of output. Elsewhere,
+// and in accessible mode, each section is printed as a line instead.
 type Progress struct {
 	mu       sync.Mutex
 	w        io.Writer
 	sections []string
 	current  int
-	detail   string
-	width    int
+	lines    []string // output of the current section, at most maxLogLines
+	stages   []stage  // build stages seen in the output, in order
 	isTTY    bool
-	rendered bool
+	announce bool // print each section as a plain line instead of drawing a view
+
+	interval  time.Duration // minimum time between redraws
+	program   *tea.Program  // draws the view while running
+	done      chan struct{} // closed when program has exited
+	restore   func()        // clears the view if program can't, see clearDrawn
+	drawn     atomic.Int32  // lines of the view last drawn
+	paused    bool          // set by Interrupt until the next update draws the view again
+	completed bool
+}
+
+// stage is the progress of one stage of a build, from step lines in its
+// output such as "Step 2/9 : RUN ..." or "#7 [base 2/9] RUN ...".
+type stage struct {
+	name        string
+	step, total int
 }
 
-// NewProgress creates a new progress bar with the given sections
+// stepRegex matches the step lines of the classic builder and BuildKit.
+var stepRegex = regexp.MustCompile(`^(?:Step (\d+)/(\d+) :|#\d+ \[(?:(\S+) )?(\d+)/(\d+)\])`)
+
+// NewProgress creates a new progress view with the given sections
 func NewProgress(w io.Writer, sections []string) *Progress {
 	// Check if writer is a TTY
 	isTTY := false
@@ -35,10 +78,13 @@ func NewProgress(w io.Writer, sections []string) *Progress {
 		isTTY = isatty.IsTerminal(f.Fd())
 	}
 
-	width := 80 // default width
-	if isTTY {
-		if ws, err := unix.IoctlGetWinsize(int(w.(interface{ Fd() uintptr }).Fd()), unix.TIOCGWINSZ); err == nil && ws.Col > 0 {
-			width = int(ws.Col)
+	// In accessible mode, and when the output isn't a terminal, nothing is
+	// redrawn; section changes are announced as sentences instead.
+	if accessible || !isTTY {
+		return &Progress{
+			w:        w,
+			sections: sections,
+			announce: true,
 		}
 	}
 
@@ -46,169 +92,344 @@ func NewProgress(w io.Writer, sections []string) *Progress {
 		w:        w,
 		sections: sections,
 		current:  0,
-		width:    width,
 		isTTY:    isTTY,
+		interval: refreshInterval(),
 	}
 }
 
-// Start begins the progress display
-func (p *Progress) Start() {
-	if !p.isTTY || len(p.sections) == 0 {
-		return
+// refreshInterval returns the redraw interval from SILO_PROGRESS_INTERVAL,
+// or DefaultRefreshInterval if it is unset or invalid.
+func refreshInterval() time.Duration {
+	if v := os.Getenv("SILO_PROGRESS_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+			return d
+		}
 	}
-	p.render()
+	return DefaultRefreshInterval
 }
 
-// SetSection updates the current section by name
-func (p *Progress) SetSection(name string) {
+// SetRefreshInterval sets the minimum time between redraws. Zero redraws as
+// fast as the terminal allows. It takes effect the next time the view is
+// drawn after Start or Interrupt.
+func (p *Progress) SetRefreshInterval(d time.Duration) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.interval = d
+}
 
-	for i, s := range p.sections {
-		if s == name {
-			p.current = i
-			break
-		}
+// fps returns the frame rate for a redraw interval.
+func fps(interval time.Duration) int {
+	if interval <= 0 {
+		return maxFPS
 	}
-	p.detail = ""
+	return max(1, min(maxFPS, int(time.Second/interval)))
+}
 
+// Start begins the progress display
+func (p *Progress) Start() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.sections) == 0 {
+		return
+	}
+	if p.announce {
+		p.announceSection()
+		return
+	}
 	if p.isTTY {
-		p.render()
+		p.startProgram()
 	}
 }
 
-// SetDetail updates the detail text shown after the section name
-func (p *Progress) SetDetail(detail string) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+// announceSection prints the current section as a sentence. Must be called
+// with p.mu held.
+func (p *Progress) announceSection() {
+	fmt.Fprintf(p.w, "Step %d of %d: %s\n", p.current+1, len(p.sections), p.sections[p.current])
+}
+
+// SetSection updates the current section by name
+func (p *Progress) SetSection(name string) {
+	p.update(func() {
+		prev := p.current
+		for i, s := range p.sections {
+			if s == name {
+				p.current = i
+				break
+			}
+		}
+		if p.current != prev {
+			p.resetOutput()
+			if p.announce {
+				p.announceSection()
+			}
+		}
+	})
+}
 
+// SetDetail adds output of the current section, such as build output. Each
+// line is kept, the latest are shown in the view, and step lines update the
+// build stages.
+func (p *Progress) SetDetail(detail string) {
 	// Strip ANSI escape codes
 	detail = ansiRegex.ReplaceAllString(detail, "")
 
-	// Clean up the detail - take last non-empty line, strip whitespace
-	detail = strings.TrimSpace(detail)
-	if idx := strings.LastIndex(detail, "\n"); idx >= 0 {
-		detail = strings.TrimSpace(detail[idx+1:])
+	var lines []string
+	for line := range strings.SplitSeq(detail, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
 	}
-
 	// Only update if we have actual content (don't clear with empty strings)
-	if detail == "" {
+	if len(lines) == 0 {
 		return
 	}
-	p.detail = detail
 
-	if p.isTTY {
-		p.render()
+	p.update(func() {
+		for _, line := range lines {
+			p.addLine(line)
+		}
+	})
+}
+
+// addLine keeps a line of output and records any build step it starts. Must
+// be called with p.mu held.
+func (p *Progress) addLine(line string) {
+	p.lines = append(p.lines, line)
+	if len(p.lines) > maxLogLines {
+		p.lines = p.lines[len(p.lines)-maxLogLines:]
 	}
+
+	m := stepRegex.FindStringSubmatch(line)
+	if m == nil {
+		return
+	}
+	name, step, total := m[3], m[4], m[5]
+	if m[1] != "" {
+		step, total = m[1], m[2]
+	}
+	s := stage{name: name}
+	s.step, _ = strconv.Atoi(step)
+	s.total, _ = strconv.Atoi(total)
+	for i := range p.stages {
+		if p.stages[i].name == s.name {
+			p.stages[i] = s
+			return
+		}
+	}
+	p.stages = append(p.stages, s)
+}
+
+// resetOutput forgets the output of the previous section. Must be called
+// with p.mu held.
+func (p *Progress) resetOutput() {
+	p.lines = nil
+	p.stages = nil
 }
 
 // Advance moves to the next section
 func (p *Progress) Advance() {
+	p.update(func() {
+		if p.current < len(p.sections)-1 {
+			p.current++
+			p.resetOutput()
+			if p.announce {
+				p.announceSection()
+			}
+		}
+	})
+}
+
+// update changes the state with fn and redraws the view, drawing it again
+// if it was interrupted.
+func (p *Progress) update(fn func()) {
 	p.mu.Lock()
-	defer p.mu.Unlock()
+	fn()
+	if p.paused && !p.completed {
+		p.paused = false
+		p.startProgram()
+	}
+	program := p.program
+	p.mu.Unlock()
 
-	if p.current < len(p.sections)-1 {
-		p.current++
+	// Sent without the lock, as the program takes it to draw the view
+	if program != nil {
+		program.Send(refreshMsg{})
 	}
-	p.detail = ""
+}
 
-	if p.isTTY {
-		p.render()
+// Interrupt clears the progress view so a message can be printed below it.
+// The view is drawn again on the next update.
+func (p *Progress) Interrupt() {
+	if p.stopProgram() {
+		p.mu.Lock()
+		p.paused = !p.completed
+		p.mu.Unlock()
 	}
 }
 
-// Complete finishes the progress bar
+// Complete finishes the progress view and clears it
 func (p *Progress) Complete() {
 	p.mu.Lock()
-	defer p.mu.Unlock()
-
 	p.current = len(p.sections)
+	p.completed = true
+	p.paused = false
+	p.mu.Unlock()
+	p.stopProgram()
+}
 
-	if p.isTTY && p.rendered {
-		p.clear()
+// Fail finishes the progress view like Complete, then prints the latest
+// output of the section that was running, so the lines leading up to a
+// failure stay in the terminal's scrollback.
+func (p *Progress) Fail() {
+	p.mu.Lock()
+	lines := p.lines[max(0, len(p.lines)-failLines):]
+	p.lines = nil
+	p.mu.Unlock()
+	p.Complete()
+
+	for _, line := range lines {
+		if accessible {
+			fmt.Fprintln(p.w, line)
+		} else {
+			fmt.Fprintln(p.w, dimStyle.Render(line))
+		}
 	}
 }
 
-// render draws the progress bar
-func (p *Progress) render() {
-	if len(p.sections) == 0 {
-		return
+// startProgram starts drawing the view. Must be called with p.mu held.
+func (p *Progress) startProgram() {
+	program := tea.NewProgram(progressModel{p: p},
+		tea.WithOutput(p.w),
+		tea.WithInput(nil),
+		tea.WithoutSignalHandler(),
+		tea.WithoutBracketedPaste(),
+		tea.WithFPS(fps(p.interval)),
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = program.Run()
+	}()
+	p.program, p.done = program, done
+	p.restore = termstate.Register(p.clearDrawn)
+}
+
+// clearDrawn clears the lines of the view last drawn and shows the cursor,
+// for when silo panics or is killed while the view is drawn and the program
+// drawing it can't clean up. The renderer leaves the cursor on the last line.
+func (p *Progress) clearDrawn() {
+	s := "\r"
+	if n := p.drawn.Swap(0); n > 1 {
+		s += fmt.Sprintf("\x1b[%dA", n-1)
 	}
+	fmt.Fprint(p.w, s+"\x1b[J"+termstate.ShowCursor)
+}
 
-	// Clear previous line if we've rendered before
-	if p.rendered {
-		p.clear()
+// stopProgram clears the view and waits for the program drawing it to exit.
+// It reports whether the view was being drawn.
+func (p *Progress) stopProgram() bool {
+	p.mu.Lock()
+	program, done, restore := p.program, p.done, p.restore
+	p.program, p.done, p.restore = nil, nil, nil
+	p.mu.Unlock()
+	if program == nil {
+		return false
 	}
-	p.rendered = true
+	program.Send(clearMsg{})
+	<-done
+	restore()
+	return true
+}
 
-	// Calculate progress
-	progress := float64(p.current) / float64(len(p.sections))
+// refreshMsg asks the program to draw the view again after a change.
+type refreshMsg struct{}
 
-	// Build the progress bar
-	// Format: [████████░░░░░░░░] Section name: detail
-	barWidth := 20
-	filled := int(progress * float64(barWidth))
-	if filled > barWidth {
-		filled = barWidth
-	}
+// clearMsg asks the program to clear the view and exit.
+type clearMsg struct{}
 
-	// Build bar characters
-	filledStr := strings.Repeat("█", filled)
-	emptyStr := strings.Repeat("░", barWidth-filled)
+// progressModel is the bubbletea model drawing a Progress.
+type progressModel struct {
+	p       *Progress
+	cleared bool
+}
 
-	// Style the bar
-	barStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("86"))
-	emptyStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
-	sectionStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
-	detailStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+func (m progressModel) Init() tea.Cmd {
+	return nil
+}
 
-	// Get current section name
-	sectionName := ""
-	if p.current < len(p.sections) {
-		sectionName = p.sections[p.current]
+func (m progressModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if _, ok := msg.(clearMsg); ok {
+		m.cleared = true
+		return m, tea.Quit
 	}
+	return m, nil
+}
 
-	// Build the status text (section + detail)
-	statusText := sectionName
-	if p.detail != "" {
-		statusText = sectionName + ": " + p.detail
+func (m progressModel) View() string {
+	if m.cleared {
+		m.p.drawn.Store(0)
+		return ""
 	}
+	m.p.mu.Lock()
+	defer m.p.mu.Unlock()
+	v := m.p.view()
+	m.p.drawn.Store(int32(strings.Count(v, "\n") + 1))
+	return v
+}
 
-	// Calculate available width for status text
-	// Format: [bar] status...
-	prefixLen := barWidth + 3 // "[" + bar + "] "
-	maxStatusLen := p.width - prefixLen - 1
-	if maxStatusLen < 10 {
-		maxStatusLen = 10
-	}
+// Styles of the progress view
+var (
+	barStyle     = lipgloss.NewStyle().Foreground(lipgloss.Color("86"))
+	emptyStyle   = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	sectionStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("205"))
+	detailStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+)
 
-	// Truncate status text if needed
-	displayStatus := statusText
-	if len(displayStatus) > maxStatusLen {
-		displayStatus = displayStatus[:maxStatusLen-3] + "..."
+// view renders the progress view. Lines wider than the terminal are cut
+// off by the renderer. Must be called with p.mu held.
+//
+//	[████████░░░░░░░░░░░░] Building environment (step 5/12)
+//	  base 9/9 · claude 5/12
+//	    Step 5/12 : RUN apt-get install -y ...
+//	    Get:1 http://deb.debian.org/debian ...
+func (p *Progress) view() string {
+	if len(p.sections) == 0 {
+		return ""
 	}
 
-	// Style the display - section name in pink, detail in dim
-	var styledStatus string
-	if p.detail != "" && len(displayStatus) > len(sectionName)+2 {
-		// We have room for at least part of the detail
-		styledStatus = sectionStyle.Render(sectionName+": ") + detailStyle.Render(displayStatus[len(sectionName)+2:])
-	} else {
-		styledStatus = sectionStyle.Render(displayStatus)
+	// Build the progress bar
+	barWidth := 20
+	filled := min(barWidth, p.current*barWidth/len(p.sections))
+
+	// Get current section name
+	sectionName := ""
+	if p.current < len(p.sections) {
+		sectionName = p.sections[p.current]
+	}
+	status := sectionStyle.Render(sectionName)
+	if n := len(p.stages); n > 0 {
+		s := p.stages[n-1]
+		status += detailStyle.Render(fmt.Sprintf(" (step %d/%d)", s.step, s.total))
 	}
 
-	// Build and print the line
-	line := fmt.Sprintf("[%s%s] %s",
-		barStyle.Render(filledStr),
-		emptyStyle.Render(emptyStr),
-		styledStatus,
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s%s] %s",
+		barStyle.Render(strings.Repeat("█", filled)),
+		emptyStyle.Render(strings.Repeat("░", barWidth-filled)),
+		status,
 	)
 
-	fmt.Fprint(p.w, line)
-}
+	// Only name stages when there is more than one, or it has a name
+	if len(p.stages) > 1 || len(p.stages) == 1 && p.stages[0].name != "" {
+		stages := make([]string, len(p.stages))
+		for i, s := range p.stages {
+			stages[i] = fmt.Sprintf("%s %d/%d", s.name, s.step, s.total)
+		}
+		b.WriteString("\n  " + detailStyle.Render(strings.Join(stages, " · ")))
+	}
 
-// clear removes the current progress line
-func (p *Progress) clear() {
-	// Move to beginning of line and clear it
-	fmt.Fprint(p.w, "\r\033[K")
+	for _, line := range p.lines[max(0, len(p.lines)-paneLines):] {
+		b.WriteString("\n    " + detailStyle.Render(line))
+	}
+	return b.String()
 }
@@ -0,0 +1,187 @@
+package cli
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer safe for use from the program drawing the view.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func newTestProgress(w *syncBuffer, interval time.Duration) *Progress {
+	return &Progress{
+		w:        w,
+		sections: []string{"Build", "Run"},
+		isTTY:    true,
+		interval: interval,
+	}
+}
+
+func TestProgressViewPane(t *testing.T) {
+	var buf syncBuffer
+	p := newTestProgress(&buf, 0)
+
+	p.SetDetail("Step 1/3 : FROM debian\n\n")
+	for i := range 10 {
+		p.SetDetail("line " + strings.Repeat("x", i))
+	}
+	p.SetDetail("\x1b[1mStep 2/3 : RUN make\x1b[0m\nmake: done\n")
+
+	view := p.view()
+	lines := strings.Split(view, "\n")
+	if !strings.Contains(lines[0], "Build") || !strings.Contains(lines[0], "(step 2/3)") {
+		t.Errorf("header = %q, want section and step", lines[0])
+	}
+	if len(lines) != 1+paneLines {
+		t.Fatalf("got %d lines, want header and %d output lines: %q", len(lines), paneLines, view)
+	}
+	if !strings.HasSuffix(lines[len(lines)-2], "Step 2/3 : RUN make") || !strings.HasSuffix(lines[len(lines)-1], "make: done") {
+		t.Errorf("expected the latest output last, got %q", lines[len(lines)-2:])
+	}
+	if len(p.lines) != 13 {
+		t.Errorf("kept %d lines, want every non-empty line", len(p.lines))
+	}
+}
+
+func TestProgressViewStages(t *testing.T) {
+	var buf syncBuffer
+	p := newTestProgress(&buf, 0)
+
+	p.SetDetail("#5 [internal] load build definition")
+	p.SetDetail("#6 [base 1/4] FROM debian")
+	p.SetDetail("#7 [base 4/4] RUN apt-get update")
+	p.SetDetail("#9 [claude 2/5] RUN npm install")
+
+	lines := strings.Split(p.view(), "\n")
+	if !strings.Contains(lines[0], "(step 2/5)") {
+		t.Errorf("header = %q, want the latest step", lines[0])
+	}
+	if !strings.Contains(lines[1], "base 4/4 · claude 2/5") {
+		t.Errorf("stages = %q", lines[1])
+	}
+
+	p.SetSection("Run")
+	if view := p.view(); strings.Contains(view, "\n") {
+		t.Errorf("expected a new section to drop the previous output, got %q", view)
+	}
+}
+
+func TestProgressDrawsAndClears(t *testing.T) {
+	var buf syncBuffer
+	p := newTestProgress(&buf, 0)
+	p.Start()
+	p.SetDetail("compiling")
+	for deadline := time.Now().Add(time.Second); !strings.Contains(buf.String(), "compiling"); {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the view to be drawn, got %q", buf.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	p.Interrupt()
+	if p.program != nil || !p.paused {
+		t.Fatal("expected the view to be cleared until the next update")
+	}
+	if out := buf.String(); !strings.Contains(out[strings.LastIndex(out, "compiling"):], "\x1b[2K") {
+		t.Errorf("expected the view to be erased, got %q", out)
+	}
+
+	p.SetSection("Run")
+	if p.program == nil {
+		t.Fatal("expected the view to be drawn again after an update")
+	}
+	p.Complete()
+	if p.program != nil {
+		t.Error("expected the view to be cleared on complete")
+	}
+	p.SetDetail("late")
+	if p.program != nil {
+		t.Error("expected no view after complete")
+	}
+}
+
+func TestProgressFail(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProgress(&buf, []string{"Build"})
+	p.Start()
+	for i := range failLines + 5 {
+		p.SetDetail(strings.Repeat("x", i+1))
+	}
+	p.SetDetail("error: exit code 1")
+	p.Fail()
+
+	out := buf.String()
+	if !strings.HasSuffix(out, "error: exit code 1\n") {
+		t.Errorf("expected the failing output to be printed, got %q", out)
+	}
+	if strings.Contains(out, "\nx\n") {
+		t.Errorf("expected at most %d lines of output, got %q", failLines, out)
+	}
+}
+
+func TestProgressNotTTY(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewProgress(&buf, []string{"Config", "Build"})
+	p.Start()
+	p.SetDetail("Step 1/2 : FROM debian")
+	p.SetSection("Build")
+	p.Complete()
+
+	want := "Step 1 of 2: Config\nStep 2 of 2: Build\n"
+	if got := buf.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestProgressAccessible(t *testing.T) {
+	SetAccessible(true)
+	t.Cleanup(func() { SetAccessible(false) })
+
+	var buf bytes.Buffer
+	p := NewProgress(&buf, []string{"Config", "Build", "Run"})
+	p.Start()
+	p.SetDetail("layer 1/3")
+	p.SetSection("Build")
+	p.SetSection("Build")
+	p.Advance()
+	p.Complete()
+
+	want := "Step 1 of 3: Config\nStep 2 of 3: Build\nStep 3 of 3: Run\n"
+	if got := buf.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestFPS(t *testing.T) {
+	for _, tt := range []struct {
+		interval time.Duration
+		want     int
+	}{
+		{0, maxFPS},
+		{time.Millisecond, maxFPS},
+		{DefaultRefreshInterval, 10},
+		{time.Minute, 1},
+	} {
+		if got := fps(tt.interval); got != tt.want {
+			t.Errorf("fps(%s) = %d, want %d", tt.interval, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package completion
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/leighmcculloch/silo/cli"
+	"github.com/leighmcculloch/silo/config"
+	"github.com/leighmcculloch/silo/fileutil"
+	"github.com/leighmcculloch/silo/tilde"
+	"github.com/spf13/cobra"
+)
+
+// bashrcMarker identifies the snippet silo appends to ~/.bashrc so repeated
+// installs don't append it twice.
+const bashrcMarker = "# silo shell completion"
+
+// Shells lists the shells that Install supports.
+var Shells = []string{"bash", "zsh", "fish"}
+
+// DetectShell returns the name of the user's login shell from $SHELL, or ""
+// if it can't be determined.
+func DetectShell() string {
+	return filepath.Base(os.Getenv("SHELL"))
+}
+
+// ScriptPath returns the file the completion script for shell is written to.
+// For zsh the Homebrew site-functions directory is preferred when brew is
+// installed, since it is already on the default fpath.
+func ScriptPath(shell, brewPrefix string) (string, error) {
+	home := os.Getenv("HOME")
+	switch shell {
+	case "bash":
+		return filepath.Join(config.XDGDataHomeDir(), "bash-completion", "completions", "silo"), nil
+	case "zsh":
+		if brewPrefix != "" {
+			return filepath.Join(brewPrefix, "share", "zsh", "site-functions", "_silo"), nil
+		}
+		return filepath.Join(home, ".zsh", "completions", "_silo"), nil
+	case "fish":
+		return filepath.Join(config.XDGConfigHomeDir(), "fish", "completions", "silo.fish"), nil
+	default:
+		return "", fmt.Errorf("unsupported shell: %s (supported: %s)", shell, strings.Join(Shells, ", "))
+	}
+}
+
+// Install writes the completion script for shell to its conventional
+// location and verifies that the shell can load it. If shell is empty it is
+// detected from $SHELL.
+func Install(root *cobra.Command, shell string, stderr io.Writer) error {
+	if shell == "" {
+		shell = DetectShell()
+		if shell == "" || shell == "." {
+			return fmt.Errorf("could not detect shell from $SHELL, use --shell")
+		}
+	}
+
+	prefix := brewPrefix()
+	path, err := ScriptPath(shell, prefix)
+	if err != nil {
+		return err
+	}
+
+	var script bytes.Buffer
+	switch shell {
+	case "bash":
+		err = root.GenBashCompletionV2(&script, true)
+	case "zsh":
+		err = root.GenZshCompletion(&script)
+	case "fish":
+		err = root.GenFishCompletion(&script, true)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to generate %s completion: %w", shell, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create completion directory: %w", err)
+	}
+	if err := fileutil.WriteFile(path, script.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write completion script: %w", err)
+	}
+	cli.LogSuccessTo(stderr, "Wrote %s completion to %s", shell, tilde.Path(path))
+
+	switch shell {
+	case "bash":
+		// bash-completion lazily loads from the XDG directory, but only when
+		// bash-completion itself is installed, so source it from .bashrc too.
+		rc := filepath.Join(os.Getenv("HOME"), ".bashrc")
+		added, err := appendSnippet(rc, fmt.Sprintf("%s\n[ -f %q ] && source %q\n", bashrcMarker, path, path))
+		if err != nil {
+			return fmt.Errorf("failed to update %s: %w", rc, err)
+		}
+		if added {
+			cli.LogSuccessTo(stderr, "Added completion snippet to %s", tilde.Path(rc))
+		}
+	case "zsh":
+		if prefix == "" {
+			cli.LogDimTo(stderr, "Add this to ~/.zshrc before compinit if it isn't already there:")
+			cli.LogDimTo(stderr, "fpath=(%s $fpath)", tilde.Path(filepath.Dir(path)))
+		}
+	}
+
+	if err := verify(shell, path); err != nil {
+		cli.LogWarningTo(stderr, "Completion installed but could not be verified: %v", err)
+		return nil
+	}
+	cli.LogSuccessTo(stderr, "Verified completion loads in %s (restart your shell to use it)", shell)
+	return nil
+}
+
+// appendSnippet appends snippet to the file at path unless the file already
+// contains bashrcMarker. It returns true if the file was modified.
+func appendSnippet(path, snippet string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if bytes.Contains(data, []byte(bashrcMarker)) {
+		return false, nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		snippet = "\n" + snippet
+	}
+	if _, err := f.WriteString("\n" + snippet); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// verify sources the installed script in a non-interactive instance of shell
+// and checks that a completion for silo was registered.
+func verify(shell, path string) error {
+	if _, err := exec.LookPath(shell); err != nil {
+		return fmt.Errorf("%s not found in PATH", shell)
+	}
+
+	var script string
+	switch shell {
+	case "bash":
+		script = fmt.Sprintf("source %q && complete -p silo >/dev/null", path)
+	case "zsh":
+		script = fmt.Sprintf("autoload -Uz compinit && compinit -u -D && source %q && (( $+functions[_silo] ))", path)
+	case "fish":
+		script = fmt.Sprintf("source %q; and complete -c silo >/dev/null", path)
+	}
+
+	out, err := exec.Command(shell, "-c", script).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// brewPrefix returns the Homebrew prefix, or "" if brew is not installed.
+func brewPrefix() string {
+	if _, err := exec.LookPath("brew"); err != nil {
+		return ""
+	}
+	out, err := exec.Command("brew", "--prefix").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
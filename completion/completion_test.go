@@ -0,0 +1,64 @@
+package completion
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScriptPath(t *testing.T) {
+	t.Setenv("HOME", "/home/test")
+	t.Setenv("XDG_CONFIG_HOME", "/home/test/.config")
+	t.Setenv("XDG_DATA_HOME", "/home/test/.local/share")
+
+	tests := []struct {
+		shell      string
+		brewPrefix string
+		want       string
+	}{
+		{"bash", "", "/home/test/.local/share/bash-completion/completions/silo"},
+		{"zsh", "/opt/homebrew", "/opt/homebrew/share/zsh/site-functions/_silo"},
+		{"zsh", "", "/home/test/.zsh/completions/_silo"},
+		{"fish", "", "/home/test/.config/fish/completions/silo.fish"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.shell+tt.brewPrefix, func(t *testing.T) {
+			got, err := ScriptPath(tt.shell, tt.brewPrefix)
+			if err != nil {
+				t.Fatalf("ScriptPath(%q) error: %v", tt.shell, err)
+			}
+			if got != tt.want {
+				t.Errorf("ScriptPath(%q, %q) = %q, want %q", tt.shell, tt.brewPrefix, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ScriptPath("powershell", ""); err == nil {
+		t.Error("expected error for unsupported shell")
+	}
+}
+
+func TestAppendSnippetIdempotent(t *testing.T) {
+	rc := filepath.Join(t.TempDir(), ".bashrc")
+	os.WriteFile(rc, []byte("export FOO=1"), 0644)
+
+	snippet := bashrcMarker + "\nsource x\n"
+	added, err := appendSnippet(rc, snippet)
+	if err != nil || !added {
+		t.Fatalf("first appendSnippet = %v, %v; want true, nil", added, err)
+	}
+	added, err = appendSnippet(rc, snippet)
+	if err != nil || added {
+		t.Fatalf("second appendSnippet = %v, %v; want false, nil", added, err)
+	}
+
+	data, _ := os.ReadFile(rc)
+	if strings.Count(string(data), bashrcMarker) != 1 {
+		t.Errorf("expected snippet once, got:\n%s", data)
+	}
+	if !strings.HasPrefix(string(data), "export FOO=1\n") {
+		t.Errorf("expected existing content preserved, got:\n%s", data)
+	}
+}
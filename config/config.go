@@ -2,10 +2,16 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/adrg/xdg"
+	"github.com/leighmcculloch/silo/preset"
 	"github.com/tidwall/jsonc"
 )
 
@@ -14,32 +20,283 @@ type Config struct {
 	// Backend specifies which backend to use: "docker" (default)
 	Backend string `json:"backend,omitempty"`
 
+	// Presets are installed presets (see silo preset add) merged before the
+	// config file that lists them
+	Presets []string `json:"presets,omitempty"`
+
+	// BackendFallback are backends tried in order when the selected backend
+	// is not available (e.g., the Docker daemon is not running)
+	BackendFallback []string `json:"backend_fallback,omitempty"`
+
 	// Tool specifies the default tool to run: "claude", "opencode", or "copilot"
 	// If not set, an interactive prompt is shown
 	Tool string `json:"tool,omitempty"`
 
+	// A11y enables screen-reader friendly output: no animation or color, and
+	// status reported as plain sentences (same as --a11y)
+	A11y bool `json:"a11y,omitempty"`
+
+	// QuickActionsKey opens the quick actions menu during a session, e.g.
+	// "ctrl-\\" (default) or "ctrl-g". "none" disables the menu.
+	QuickActionsKey string `json:"quick_actions_key,omitempty"`
+
+	// Notify raises a notification on the host when an attached session
+	// needs attention. Off by default.
+	Notify Notify `json:"notify,omitempty"`
+
+	// Stop is how an attached session is stopped when Ctrl-C is pressed
+	// three times in a row, or silo is sent SIGINT or SIGTERM.
+	Stop StopPolicy `json:"stop,omitempty"`
+
+	// Timeouts bound how long silo waits for image builds, mounts to
+	// appear in the container, and backends to become reachable.
+	Timeouts Timeouts `json:"timeouts,omitempty"`
+
+	// ContainerName is a template for the base of container names, e.g.
+	// "{{.Repo}}-{{slug .Branch}}". The default is the directory name. See
+	// silo template vars for the variables and functions.
+	ContainerName string `json:"container_name,omitempty"`
+
+	// BranchSandbox runs each session on a new branch, silo/<tool>-<date>-N,
+	// checked out in its own worktree, so the session's commits never land
+	// on the branch checked out in the current directory (same as
+	// --branch-sandbox). A config setting false turns it off again.
+	BranchSandbox *bool `json:"branch_sandbox,omitempty"`
+
+	// Dockerfile is the path of a Dockerfile that replaces the embedded one.
+	// Relative paths are resolved against the directory of the config file.
+	Dockerfile string `json:"dockerfile,omitempty"`
+
+	// BaseImage replaces the image the embedded Dockerfile's base stage is
+	// built FROM. It must be Ubuntu or Debian based.
+	BaseImage string `json:"base_image,omitempty"`
+
+	// BuildStages maps stage names to files of Dockerfile instructions that
+	// are built as stages between the base stage and the tool's stage, in
+	// order of name. Relative paths are resolved against the directory of
+	// the config file.
+	BuildStages map[string]string `json:"build_stages,omitempty"`
+
+	// Registry is where prebuilt silo base images are pulled from to seed the
+	// build cache. Unset, or "none" to override a registry set elsewhere,
+	// always builds from scratch.
+	Registry string `json:"registry,omitempty"`
+
+	// ImageRegistry is the registry repository silo images are shared
+	// through with silo image push and pull, e.g. "ghcr.io/myorg/silo".
+	// When set, images are built for a common user, to be shared, with a
+	// layer on top that makes them the host user's.
+	ImageRegistry string `json:"image_registry,omitempty"`
+
+	// PullPolicy is "if-not-present" to pull a missing image from
+	// ImageRegistry before building it locally, or "never" (the default).
+	PullPolicy string `json:"pull_policy,omitempty"`
+
+	// Reproducible makes images built from the same config on different
+	// machines as alike as possible: apt installs from a snapshot of the
+	// Ubuntu archive and SOURCE_DATE_EPOCH is set to the snapshot's time
+	Reproducible bool `json:"reproducible,omitempty"`
+
+	// AptSnapshot is the Ubuntu archive snapshot ("20261001T000000Z") apt
+	// installs from when Reproducible is set. Defaults to the start of the
+	// current month (UTC).
+	AptSnapshot string `json:"apt_snapshot,omitempty"`
+
+	// EncryptRecipients are age X25519 public keys ("age1...") that the run
+	// history, recordings, build logs, batch output, and job queue silo
+	// writes under XDG state are encrypted to.
+	EncryptRecipients []string `json:"encrypt_recipients,omitempty"`
+
+	// EncryptIdentity is the path of an age identity file used to read
+	// encrypted files. Relative paths are resolved against the directory of
+	// the config file.
+	EncryptIdentity string `json:"encrypt_identity,omitempty"`
+
+	// StateDir is the directory silo writes its state to (run history,
+	// copies of ephemeral mounts) instead of silo's directory under
+	// XDG_STATE_HOME, where lock files stay. Relative paths are resolved
+	// against the directory of the config file.
+	StateDir string `json:"state_dir,omitempty"`
+
+	// ConfigSearch is how far up from the current directory silo looks for
+	// silo.jsonc files: "repo" (default) stops at the root of the git
+	// worktree, "filesystem" goes up to the filesystem root. Only read from
+	// the global config, as it decides which other files are loaded.
+	ConfigSearch string `json:"config_search,omitempty"`
+
 	// MountsRO are read-only directories or files to mount into the container
 	MountsRO []string `json:"mounts_ro,omitempty"`
 
 	// MountsRW are read-write directories or files to mount into the container
 	MountsRW []string `json:"mounts_rw,omitempty"`
 
-	// Env are environment variables. Values without '=' are passed through from host.
-	// Values with '=' are set explicitly (KEY=VALUE format).
+	// ExtraWorkspaces are other repositories mounted read-write next to the
+	// current directory, with their git worktree roots, for sessions that
+	// work across sibling repositories
+	ExtraWorkspaces []string `json:"extra_workspaces,omitempty"`
+
+	// Env are environment variables. Values without '=' are passed through from host,
+	// with '*' matching any characters, e.g. "NPM_*". Values with '=' are set
+	// explicitly (KEY=VALUE format).
 	Env []string `json:"env,omitempty"`
 
+	// EnvBlocklist are patterns (e.g. "AWS_*") of host environment variables
+	// that a pattern in env doesn't pass through. One listed in env by name
+	// is passed, with a warning. A pattern starting with '!' exempts the
+	// variables it matches from earlier patterns, e.g. "!GH_TOKEN".
+	EnvBlocklist []string `json:"env_blocklist,omitempty"`
+
+	// EnvRedact are patterns of host environment variables whose values are
+	// redacted from verbose output and the run journal, in addition to the
+	// values passed to the container.
+	EnvRedact []string `json:"env_redact,omitempty"`
+
 	// PreRunHooks is a list of shell commands to run inside the container before the tool.
+	// ${env:VAR} placeholders are resolved from secrets and the host environment at run time.
 	PreRunHooks []string `json:"pre_run_hooks,omitempty"`
 
 	// PostBuildHooks is a list of shell commands to run inside the container after building the image.
 	PostBuildHooks []string `json:"post_build_hooks,omitempty"`
 
+	// PostRunHooks are shell commands to run on the host after the container
+	// exits, with SILO_EXIT_CODE, SILO_CONTAINER, and SILO_TOOL set.
+	PostRunHooks []string `json:"post_run_hooks,omitempty"`
+
+	// OnFailureHooks are shell commands to run on the host, before
+	// PostRunHooks, when the tool exits with a non-zero code or the run fails.
+	OnFailureHooks []string `json:"on_failure_hooks,omitempty"`
+
+	// HookDefinitions are named shell commands that hooks anywhere in the
+	// config reference as "@name". They replace the built-in hooks of the
+	// same name.
+	HookDefinitions map[string]string `json:"hook_definitions,omitempty"`
+
+	// Resources limits the CPU, memory, and processes available to the container
+	Resources Resources `json:"resources,omitempty"`
+
+	// Network controls the container's network access: "full" (default),
+	// "allowlist" (only hosts in NetworkAllow), or "none"
+	Network string `json:"network,omitempty"`
+
+	// NetworkAllow are hosts the container can reach when Network is "allowlist".
+	// A leading "*." matches any subdomain (e.g., "*.github.com").
+	NetworkAllow []string `json:"network_allow,omitempty"`
+
+	// NetworkJoin is a Docker network the container joins so other containers
+	// on it can reach it by name. "silo" is a shared network created on
+	// demand; any other name must be an existing network. Requires Network
+	// to be "full".
+	NetworkJoin string `json:"network_join,omitempty"`
+
+	// Profile is the sandbox profile: "strict" (no network, read-only
+	// repository, scratch directory), "standard" (default, the configured
+	// settings), or "permissive" (full network)
+	Profile string `json:"profile,omitempty"`
+
+	// DockerInDocker installs Docker in the image and starts a daemon in
+	// the container: true, "rootless" for a daemon run as the user, or false
+	DockerInDocker DockerInDocker `json:"docker_in_docker,omitempty"`
+
+	// SSHAgent forwards the host's SSH agent (SSH_AUTH_SOCK) into the
+	// container, so tools can use its keys, e.g. to git push over SSH,
+	// without the keys being mounted. Off by default.
+	SSHAgent *bool `json:"ssh_agent,omitempty"`
+
+	// ReadOnlyRootfs mounts the container's root filesystem read-only, with
+	// tmpfs where programs expect to write, so nothing persists outside the
+	// mounts. Off by default.
+	ReadOnlyRootfs *bool `json:"read_only_rootfs,omitempty"`
+
+	// SeccompProfile is the seccomp profile of the container: "silo" for
+	// silo's, which blocks syscalls such as ptrace and mount, "default" for
+	// the runtime's, "unconfined", or the path of a JSON profile. Default
+	// "silo".
+	SeccompProfile string `json:"seccomp_profile,omitempty"`
+
+	// AppArmorProfile is the AppArmor profile of the container, loaded on
+	// the host, or "unconfined". Default is the runtime's.
+	AppArmorProfile string `json:"apparmor_profile,omitempty"`
+
+	// CommitSigning signs commits made in the container with the host's
+	// signing key, without the private key entering it: "ssh" forwards the
+	// SSH agent and signs with the key of user.signingkey, "gpg" forwards
+	// gpg-agent, and "off" leaves commits unsigned. Off by default.
+	CommitSigning string `json:"commit_signing,omitempty"`
+
+	// SELinuxLabel is how bind mounts are relabeled for SELinux on the docker
+	// backend: "shared" (:z), "private" (:Z), or "none". Unset, mounts
+	// aren't relabeled, with a warning if SELinux is enforcing.
+	SELinuxLabel string `json:"selinux_label,omitempty"`
+
+	// ContainerUser replaces the host user the image's user is created to
+	// match, e.g. to give it the same uid on every machine. Unset fields
+	// are the host user's.
+	ContainerUser ContainerUser `json:"container_user,omitempty"`
+
+	// Userns is the user namespace of containers on the docker backend:
+	// "host" runs them outside the daemon's userns-remap, so files they
+	// write to mounts are owned by the container user's uid rather than a
+	// subordinate one. Defaults to the daemon's setting.
+	Userns string `json:"userns,omitempty"`
+
+	// Platform is the platform images are built and run for, e.g.
+	// "linux/amd64". Defaults to the backend's native platform. Other
+	// platforms run under emulation.
+	Platform string `json:"platform,omitempty"`
+
+	// Ports are container ports published on the host: "3000" (same port on
+	// the host), "8080:80" (host:container), or "0.0.0.0:8080:80". Ports
+	// listen on 127.0.0.1 unless an address is given; append "/udp" for UDP.
+	Ports []string `json:"ports,omitempty"`
+
+	// Tmpfs are container paths where an empty in-memory filesystem is
+	// mounted for each session, so tools can write caches and scratch files
+	// without touching host directories. "~" is the home directory.
+	Tmpfs []string `json:"tmpfs,omitempty"`
+
+	// CacheVolumes maps volume names to container paths where they are
+	// mounted. Volumes persist across sessions and are shared by every
+	// session that mounts them, e.g. {"gomod": "~/go/pkg/mod"}.
+	CacheVolumes map[string]string `json:"cache_volumes,omitempty"`
+
+	// Secrets are environment variables whose values are fetched on the host
+	// at run time (from the macOS Keychain, 1Password, pass, or a command)
+	// instead of being stored in config or the host environment.
+	Secrets map[string]Secret `json:"secrets,omitempty"`
+
+	// BuildSecrets are secrets fetched like Secrets, but for the post-build
+	// hooks that reference them as ${secret:name}, which read them from a
+	// BuildKit secret mount so they aren't stored in the image.
+	BuildSecrets map[string]Secret `json:"build_secrets,omitempty"`
+
+	// Services are sidecar containers, such as a database, started for each
+	// session on a network shared with the tool's container and removed when
+	// it ends, e.g. {"postgres": {"image": "postgres:16"}}.
+	Services map[string]Service `json:"services,omitempty"`
+
+	// MCPServers are MCP servers declared once and given to every tool in
+	// its own MCP config format, e.g. {"github": {"url": "https://api.githubcopilot.com/mcp/"}}.
+	MCPServers map[string]MCPServer `json:"mcp_servers,omitempty"`
+
 	// Tools defines available AI tools with their configurations
 	Tools map[string]ToolConfig `json:"tools,omitempty"`
 
 	// Repos defines repository-specific configurations that are applied when
 	// a git remote URL contains the specified key as a substring.
 	Repos map[string]RepoConfig `json:"repos,omitempty"`
+
+	// Profiles defines named configurations that are applied, after
+	// everything else, when selected with --profile (e.g. "offline").
+	Profiles map[string]RepoConfig `json:"profiles,omitempty"`
+
+	// Ignored are the settings only read from the global config that were
+	// found, and ignored, in other config files, each as "setting (path)".
+	Ignored []string `json:"-"`
+
+	// hostHookDefinitions are the hook_definitions of the global config and
+	// those before it, which are all the hooks run on the host can use, or
+	// nil if the config wasn't loaded from files.
+	hostHookDefinitions map[string]string
 }
 
 // ToolConfig represents configuration for a specific AI tool
@@ -50,6 +307,28 @@ type ToolConfig struct {
 	// MountsRW are read-write mounts specific to this tool
 	MountsRW []string `json:"mounts_rw,omitempty"`
 
+	// Ephemeral mounts copies of this tool's MountsRW, made when each session
+	// starts, instead of the originals, so the tool can't modify them
+	Ephemeral bool `json:"ephemeral,omitempty"`
+
+	// SyncBack are paths under this tool's MountsRW that are copied back
+	// from an ephemeral session's copies when it ends (e.g. "~/.claude/projects")
+	SyncBack []string `json:"sync_back,omitempty"`
+
+	// Isolate are paths under this tool's MountsRW kept apart per
+	// repository, replaced in each session by a directory of the
+	// repository's own (e.g. "~/.claude/projects")
+	Isolate []string `json:"isolate,omitempty"`
+
+	// Args are arguments added to the tool's command line, before any given
+	// after -- on the silo command line
+	Args []string `json:"args,omitempty"`
+
+	// DefaultFlags set to false drops the flags silo runs the tool with by
+	// default to skip its permission prompts (e.g.
+	// --dangerously-skip-permissions for claude). Unset is true.
+	DefaultFlags *bool `json:"default_flags,omitempty"`
+
 	// Env specific to this tool (same format as Config.Env)
 	Env []string `json:"env,omitempty"`
 
@@ -58,6 +337,43 @@ type ToolConfig struct {
 
 	// PostBuildHooks are shell commands to run in the Dockerfile for this tool's stage
 	PostBuildHooks []string `json:"post_build_hooks,omitempty"`
+
+	// BuildStages are additional build stages for this tool's image
+	BuildStages map[string]string `json:"build_stages,omitempty"`
+
+	// PostRunHooks are shell commands to run on the host after this tool exits
+	PostRunHooks []string `json:"post_run_hooks,omitempty"`
+
+	// OnFailureHooks are shell commands to run on the host when this tool fails
+	OnFailureHooks []string `json:"on_failure_hooks,omitempty"`
+
+	// Resources overrides resource limits when running this tool
+	Resources Resources `json:"resources,omitempty"`
+
+	// Network overrides the network mode when running this tool
+	Network string `json:"network,omitempty"`
+
+	// NetworkAllow are additional hosts this tool can reach in allowlist mode
+	NetworkAllow []string `json:"network_allow,omitempty"`
+
+	// NetworkJoin overrides the network joined when running this tool
+	NetworkJoin string `json:"network_join,omitempty"`
+
+	// Platform overrides the platform for this tool, e.g. one whose
+	// binaries are only published for linux/amd64
+	Platform string `json:"platform,omitempty"`
+
+	// Ports are additional ports published when running this tool
+	Ports []string `json:"ports,omitempty"`
+
+	// Tmpfs are additional tmpfs mounts when running this tool
+	Tmpfs []string `json:"tmpfs,omitempty"`
+
+	// CacheVolumes are additional cache volumes when running this tool
+	CacheVolumes map[string]string `json:"cache_volumes,omitempty"`
+
+	// Secrets are additional secrets injected when running this tool
+	Secrets map[string]Secret `json:"secrets,omitempty"`
 }
 
 // RepoConfig represents configuration for a specific git repository.
@@ -69,12 +385,25 @@ type RepoConfig struct {
 	// Tool specifies which tool to use for this repository
 	Tool string `json:"tool,omitempty"`
 
+	// Dockerfile overrides the Dockerfile for this repository
+	Dockerfile string `json:"dockerfile,omitempty"`
+
+	// BaseImage overrides the base image for this repository
+	BaseImage string `json:"base_image,omitempty"`
+
+	// BuildStages are additional build stages for this repository
+	BuildStages map[string]string `json:"build_stages,omitempty"`
+
 	// MountsRO are read-only mounts specific to this repository
 	MountsRO []string `json:"mounts_ro,omitempty"`
 
 	// MountsRW are read-write mounts specific to this repository
 	MountsRW []string `json:"mounts_rw,omitempty"`
 
+	// ExtraWorkspaces are other repositories mounted read-write alongside
+	// this one
+	ExtraWorkspaces []string `json:"extra_workspaces,omitempty"`
+
 	// Env specific to this repository (same format as Config.Env)
 	Env []string `json:"env,omitempty"`
 
@@ -83,28 +412,570 @@ type RepoConfig struct {
 
 	// PostBuildHooks are shell commands to run in the Dockerfile
 	PostBuildHooks []string `json:"post_build_hooks,omitempty"`
+
+	// PostRunHooks are shell commands to run on the host after the tool exits
+	PostRunHooks []string `json:"post_run_hooks,omitempty"`
+
+	// OnFailureHooks are shell commands to run on the host when the tool fails
+	OnFailureHooks []string `json:"on_failure_hooks,omitempty"`
+
+	// Resources overrides resource limits for this repository
+	Resources Resources `json:"resources,omitempty"`
+
+	// Network overrides the network mode for this repository
+	Network string `json:"network,omitempty"`
+
+	// NetworkAllow are additional hosts reachable in allowlist mode for this repository
+	NetworkAllow []string `json:"network_allow,omitempty"`
+
+	// NetworkJoin overrides the network joined for this repository
+	NetworkJoin string `json:"network_join,omitempty"`
+
+	// Profile overrides the sandbox profile for this repository
+	Profile string `json:"profile,omitempty"`
+
+	// DockerInDocker overrides whether Docker runs in the container for
+	// this repository
+	DockerInDocker DockerInDocker `json:"docker_in_docker,omitempty"`
+
+	// SSHAgent overrides whether the host's SSH agent is forwarded for this
+	// repository
+	SSHAgent *bool `json:"ssh_agent,omitempty"`
+
+	// ReadOnlyRootfs overrides whether the root filesystem is read-only for
+	// this repository
+	ReadOnlyRootfs *bool `json:"read_only_rootfs,omitempty"`
+
+	// SeccompProfile overrides the seccomp profile for this repository
+	SeccompProfile string `json:"seccomp_profile,omitempty"`
+
+	// AppArmorProfile overrides the AppArmor profile for this repository
+	AppArmorProfile string `json:"apparmor_profile,omitempty"`
+
+	// CommitSigning overrides how commits are signed for this repository
+	CommitSigning string `json:"commit_signing,omitempty"`
+
+	// GitIdentity replaces the host's git identity for commits made in the
+	// container, e.g. with a bot identity
+	GitIdentity GitIdentity `json:"git_identity,omitempty"`
+
+	// Ports are additional ports published for this repository
+	Ports []string `json:"ports,omitempty"`
+
+	// Tmpfs are additional tmpfs mounts for this repository
+	Tmpfs []string `json:"tmpfs,omitempty"`
+
+	// CacheVolumes are additional cache volumes for this repository
+	CacheVolumes map[string]string `json:"cache_volumes,omitempty"`
+
+	// Secrets are additional secrets injected for this repository
+	Secrets map[string]Secret `json:"secrets,omitempty"`
+
+	// BuildSecrets are additional build secrets for this repository
+	BuildSecrets map[string]Secret `json:"build_secrets,omitempty"`
+
+	// Services are additional services started for this repository
+	Services map[string]Service `json:"services,omitempty"`
+
+	// MCPServers are additional MCP servers for this repository
+	MCPServers map[string]MCPServer `json:"mcp_servers,omitempty"`
+}
+
+// Resources limits the resources available to a container. Unset (zero)
+// fields fall back to the backend's defaults.
+type Resources struct {
+	// CPUs is the number of CPUs (fractional values are allowed on docker)
+	CPUs float64 `json:"cpus,omitempty"`
+
+	// Memory is the memory limit, e.g. "8g" or "512m"
+	Memory string `json:"memory,omitempty"`
+
+	// Swap is the swap available in addition to Memory, e.g. "1g"
+	Swap string `json:"swap,omitempty"`
+
+	// PidsLimit is the maximum number of processes in the container
+	PidsLimit int64 `json:"pids_limit,omitempty"`
+
+	// Nofile is the limit on open files in the container
+	Nofile int64 `json:"nofile,omitempty"`
+
+	// InotifyWatches is the limit on inotify watches used to watch files,
+	// fs.inotify.max_user_watches
+	InotifyWatches int64 `json:"inotify_watches,omitempty"`
+}
+
+// Notify configures the notifications raised when a session needs
+// attention: when the tool has been quiet for Idle after writing output,
+// usually because it is waiting for input, or when its output contains one
+// of Patterns.
+type Notify struct {
+	// Enabled turns notifications on (same as --notify)
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Idle is how long the tool must be quiet, e.g. "30s" (default), before
+	// it is assumed to be waiting. "0" notifies only on Patterns.
+	Idle string `json:"idle,omitempty"`
+
+	// Patterns are text, matched case-insensitively, that the tool writes
+	// when it needs attention, e.g. "Do you want to proceed?"
+	Patterns []string `json:"patterns,omitempty"`
+
+	// Command is a shell command run on the host to notify, with
+	// SILO_NOTIFY_MESSAGE, SILO_CONTAINER, and SILO_TOOL set. The default is
+	// a macOS notification, notify-send on Linux, or else the terminal bell.
+	// Only read from the global config.
+	Command string `json:"command,omitempty"`
+}
+
+// ContainerUser configures the user created in the image, which the tool
+// runs as.
+type ContainerUser struct {
+	// Name is the user name, e.g. "agent"
+	Name string `json:"name,omitempty"`
+
+	// UID is the user's uid, e.g. 1000. The working directory is shared
+	// with it using ACLs on Linux hosts when it isn't the host user's, so
+	// it's only read from the global config.
+	UID int `json:"uid,omitempty"`
+
+	// GID is the user's primary gid, e.g. 1000
+	GID int `json:"gid,omitempty"`
+}
+
+// MergeContainerUser returns base with any fields set in overlay replacing
+// it.
+func MergeContainerUser(base, overlay ContainerUser) ContainerUser {
+	if overlay.Name != "" {
+		base.Name = overlay.Name
+	}
+	if overlay.UID != 0 {
+		base.UID = overlay.UID
+	}
+	if overlay.GID != 0 {
+		base.GID = overlay.GID
+	}
+	return base
+}
+
+// StopPolicy configures how an attached session is stopped: the tool is
+// sent Signal, then killed if it hasn't exited after GracePeriod.
+type StopPolicy struct {
+	// Signal is sent to the tool first, e.g. "SIGTERM" (default) or "SIGINT"
+	Signal string `json:"signal,omitempty"`
+
+	// GracePeriod is how long the tool has to exit after Signal before it
+	// is killed, e.g. "10s" (default). "0" kills it straight away.
+	GracePeriod string `json:"grace_period,omitempty"`
+
+	// Confirm asks before stopping the session when Ctrl-C is pressed three
+	// times in a row
+	Confirm bool `json:"confirm,omitempty"`
+}
+
+// Timeouts configures how long silo waits for slow operations. Each is a
+// duration, e.g. "30s".
+type Timeouts struct {
+	// Build is how long an image build may take before it is cancelled. No
+	// limit by default.
+	Build string `json:"build,omitempty"`
+
+	// MountWait is how long the container waits for its mounts to appear
+	// before the tool starts, "10s" by default. "0" doesn't wait.
+	MountWait string `json:"mount_wait,omitempty"`
+
+	// Connect is how long silo retries, with backoff, to reach a backend
+	// that is installed but not reachable yet, such as a Docker daemon that
+	// is starting, "10s" by default. "0" doesn't retry.
+	Connect string `json:"connect,omitempty"`
+}
+
+// MergeTimeouts returns base with any fields set in overlay replacing it.
+func MergeTimeouts(base, overlay Timeouts) Timeouts {
+	if overlay.Build != "" {
+		base.Build = overlay.Build
+	}
+	if overlay.MountWait != "" {
+		base.MountWait = overlay.MountWait
+	}
+	if overlay.Connect != "" {
+		base.Connect = overlay.Connect
+	}
+	return base
+}
+
+// MergeStopPolicy returns base with any fields set in overlay replacing it.
+func MergeStopPolicy(base, overlay StopPolicy) StopPolicy {
+	if overlay.Signal != "" {
+		base.Signal = overlay.Signal
+	}
+	if overlay.GracePeriod != "" {
+		base.GracePeriod = overlay.GracePeriod
+	}
+	if overlay.Confirm {
+		base.Confirm = true
+	}
+	return base
+}
+
+// MergeNotify returns base with any fields set in overlay replacing it, and
+// the patterns of both.
+func MergeNotify(base, overlay Notify) Notify {
+	if overlay.Enabled {
+		base.Enabled = true
+	}
+	if overlay.Idle != "" {
+		base.Idle = overlay.Idle
+	}
+	base.Patterns = append(slices.Clip(base.Patterns), overlay.Patterns...)
+	if overlay.Command != "" {
+		base.Command = overlay.Command
+	}
+	return base
+}
+
+// MergeResources returns base with any fields set in overlay replacing it.
+func MergeResources(base, overlay Resources) Resources {
+	if overlay.CPUs != 0 {
+		base.CPUs = overlay.CPUs
+	}
+	if overlay.Memory != "" {
+		base.Memory = overlay.Memory
+	}
+	if overlay.Swap != "" {
+		base.Swap = overlay.Swap
+	}
+	if overlay.PidsLimit != 0 {
+		base.PidsLimit = overlay.PidsLimit
+	}
+	if overlay.Nofile != 0 {
+		base.Nofile = overlay.Nofile
+	}
+	if overlay.InotifyWatches != 0 {
+		base.InotifyWatches = overlay.InotifyWatches
+	}
+	return base
+}
+
+// GitIdentity is the author and committer of commits made in the container.
+type GitIdentity struct {
+	// Name is the author and committer name
+	Name string `json:"name,omitempty"`
+
+	// Email is the author and committer email
+	Email string `json:"email,omitempty"`
+
+	// Anonymous uses a generic identity instead of the host's. Name and
+	// Email, if set, take precedence.
+	Anonymous bool `json:"anonymous,omitempty"`
+}
+
+// MergeGitIdentity returns base with any fields set in overlay replacing it.
+func MergeGitIdentity(base, overlay GitIdentity) GitIdentity {
+	if overlay.Name != "" {
+		base.Name = overlay.Name
+	}
+	if overlay.Email != "" {
+		base.Email = overlay.Email
+	}
+	if overlay.Anonymous {
+		base.Anonymous = true
+	}
+	return base
+}
+
+// Secret is where the value of a secret environment variable comes from.
+// Exactly one field should be set.
+type Secret struct {
+	// Keychain is the service name of a macOS Keychain generic password, or of a
+	// libsecret item on other systems
+	Keychain string `json:"keychain,omitempty"`
+
+	// Op is a 1Password secret reference, e.g. "op://Private/Anthropic/credential"
+	Op string `json:"op,omitempty"`
+
+	// Pass is the name of a pass (password-store) entry; its first line is used
+	Pass string `json:"pass,omitempty"`
+
+	// Command is a shell command whose output is the value
+	Command string `json:"command,omitempty"`
+}
+
+// Service is a sidecar container started for each session. The tool reaches
+// it at the service's name.
+type Service struct {
+	// Image is the image the service runs, e.g. "postgres:16"
+	Image string `json:"image"`
+
+	// Ports are the ports the service listens on. The first is passed to the
+	// tool as <NAME>_PORT, next to <NAME>_HOST.
+	Ports []int `json:"ports,omitempty"`
+
+	// Env are environment variables of the service's container (KEY=VALUE)
+	Env []string `json:"env,omitempty"`
+
+	// Healthcheck is a shell command run in the service's container until it
+	// succeeds before the tool starts, e.g. "pg_isready -U postgres"
+	Healthcheck string `json:"healthcheck,omitempty"`
+
+	// ToolEnv are environment variables set in the tool's container
+	// (KEY=VALUE), e.g. a DATABASE_URL pointing at the service
+	ToolEnv []string `json:"tool_env,omitempty"`
+}
+
+// MCPServer is an MCP server: a command the tool runs in the container, or
+// a remote server. Exactly one of Command and URL should be set.
+type MCPServer struct {
+	// Command runs the server over stdio, e.g. "npx". "~" is the home
+	// directory, here and in Args, Env, and Mounts.
+	Command string `json:"command,omitempty"`
+
+	// Args are the arguments of Command
+	Args []string `json:"args,omitempty"`
+
+	// Env are environment variables of Command. ${env:VAR} in a value is
+	// replaced with the value of VAR in the session or on the host.
+	Env map[string]string `json:"env,omitempty"`
+
+	// URL is the address of a remote server, reached over HTTP
+	URL string `json:"url,omitempty"`
+
+	// Headers are HTTP headers sent to the remote server. ${env:VAR} is
+	// replaced as in Env.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Mounts are host paths the server needs, such as its binary or data,
+	// mounted read-only
+	Mounts []string `json:"mounts,omitempty"`
+}
+
+// DockerInDocker is the docker_in_docker setting. It is true, false, or
+// "rootless" in JSON, and empty when unset.
+type DockerInDocker string
+
+// Values of DockerInDocker
+const (
+	DockerInDockerOff      DockerInDocker = "off"
+	DockerInDockerRootful  DockerInDocker = "rootful"
+	DockerInDockerRootless DockerInDocker = "rootless"
+)
+
+// UnmarshalJSON reads true, false, or "rootless".
+func (d *DockerInDocker) UnmarshalJSON(data []byte) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		*d = DockerInDockerOff
+		if b {
+			*d = DockerInDockerRootful
+		}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil || s != string(DockerInDockerRootless) {
+		return fmt.Errorf("invalid docker_in_docker: %s (must be true, false, or \"rootless\")", data)
+	}
+	*d = DockerInDockerRootless
+	return nil
+}
+
+// MarshalJSON writes d as it is written in config.
+func (d DockerInDocker) MarshalJSON() ([]byte, error) {
+	switch d {
+	case DockerInDockerRootful:
+		return []byte("true"), nil
+	case DockerInDockerRootless:
+		return json.Marshal(string(d))
+	}
+	return []byte("false"), nil
+}
+
+// MergeSecrets returns a new map with the secrets in overlay replacing those
+// of the same name in base.
+func MergeSecrets(base, overlay map[string]Secret) map[string]Secret {
+	if len(overlay) == 0 {
+		return base
+	}
+	result := make(map[string]Secret, len(base)+len(overlay))
+	for k, v := range base {
+		result[k] = v
+	}
+	for k, v := range overlay {
+		result[k] = v
+	}
+	return result
+}
+
+// MergeCacheVolumes returns a new map with the volumes in overlay replacing
+// those of the same name in base.
+func MergeCacheVolumes(base, overlay map[string]string) map[string]string {
+	if len(overlay) == 0 {
+		return base
+	}
+	result := make(map[string]string, len(base)+len(overlay))
+	maps.Copy(result, base)
+	maps.Copy(result, overlay)
+	return result
+}
+
+// MergeBuildStages returns a new map with the stages in overlay replacing
+// those of the same name in base.
+func MergeBuildStages(base, overlay map[string]string) map[string]string {
+	if len(overlay) == 0 {
+		return base
+	}
+	result := make(map[string]string, len(base)+len(overlay))
+	maps.Copy(result, base)
+	maps.Copy(result, overlay)
+	return result
+}
+
+// MergeHookDefinitions returns a new map with the hooks in overlay replacing
+// those of the same name in base.
+func MergeHookDefinitions(base, overlay map[string]string) map[string]string {
+	if len(overlay) == 0 {
+		return base
+	}
+	result := make(map[string]string, len(base)+len(overlay))
+	maps.Copy(result, base)
+	maps.Copy(result, overlay)
+	return result
+}
+
+// MergeServices returns a new map with the services in overlay replacing
+// those of the same name in base.
+func MergeServices(base, overlay map[string]Service) map[string]Service {
+	if len(overlay) == 0 {
+		return base
+	}
+	result := make(map[string]Service, len(base)+len(overlay))
+	maps.Copy(result, base)
+	maps.Copy(result, overlay)
+	return result
+}
+
+// MergeMCPServers returns a new map with the servers in overlay replacing
+// those of the same name in base.
+func MergeMCPServers(base, overlay map[string]MCPServer) map[string]MCPServer {
+	if len(overlay) == 0 {
+		return base
+	}
+	result := make(map[string]MCPServer, len(base)+len(overlay))
+	maps.Copy(result, base)
+	maps.Copy(result, overlay)
+	return result
 }
 
 // SourceInfo tracks the source of configuration values
 type SourceInfo struct {
 	Backend            string                       // source path for backend setting
+	BackendFallback    map[string]string            // value -> source path
+	Presets            map[string]string            // value -> source path
 	Tool               string                       // source path for tool setting
+	A11y               string                       // source path for a11y setting
+	Dockerfile         string                       // source path for dockerfile setting
+	BaseImage          string                       // source path for base_image setting
+	BuildStages        map[string]string            // name -> source path
+	Registry           string                       // source path for registry setting
+	ImageRegistry      string                       // source path for image_registry setting
+	PullPolicy         string                       // source path for pull_policy setting
+	Reproducible       string                       // source path for reproducible setting
+	AptSnapshot        string                       // source path for apt_snapshot setting
+	QuickActionsKey    string                       // source path for quick_actions_key setting
+	Notify             map[string]string            // field -> source path
+	Stop               map[string]string            // field -> source path
+	Timeouts           map[string]string            // field -> source path
+	ContainerName      string                       // source path for container_name setting
+	BranchSandbox      string                       // source path for branch_sandbox setting
+	EncryptRecipients  map[string]string            // value -> source path
+	EncryptIdentity    string                       // source path for encrypt_identity setting
+	StateDir           string                       // source path for state_dir setting
+	ConfigSearch       string                       // source path for config_search setting
 	MountsRO           map[string]string            // value -> source path
 	MountsRW           map[string]string            // value -> source path
+	ExtraWorkspaces    map[string]string            // value -> source path
 	Env                map[string]string            // value -> source path
+	EnvBlocklist       map[string]string            // value -> source path
+	EnvRedact          map[string]string            // value -> source path
 	PreRunHooks        map[string]string            // value -> source path
 	PostBuildHooks     map[string]string            // value -> source path
+	PostRunHooks       map[string]string            // value -> source path
+	OnFailureHooks     map[string]string            // value -> source path
+	HookDefinitions    map[string]string            // name -> source path
+	Resources          map[string]string            // field -> source path
+	Network            string                       // source path for network setting
+	NetworkAllow       map[string]string            // value -> source path
+	NetworkJoin        string                       // source path for network_join setting
+	Profile            string                       // source path for profile setting
+	DockerInDocker     string                       // source path for docker_in_docker setting
+	SSHAgent           string                       // source path for ssh_agent setting
+	ReadOnlyRootfs     string                       // source path for read_only_rootfs setting
+	SeccompProfile     string                       // source path for seccomp_profile setting
+	AppArmorProfile    string                       // source path for apparmor_profile setting
+	CommitSigning      string                       // source path for commit_signing setting
+	SELinuxLabel       string                       // source path for selinux_label setting
+	ContainerUser      map[string]string            // field -> source path
+	Userns             string                       // source path for userns setting
+	Platform           string                       // source path for platform setting
+	Ports              map[string]string            // value -> source path
+	Tmpfs              map[string]string            // value -> source path
+	CacheVolumes       map[string]string            // name -> source path
+	Secrets            map[string]string            // name -> source path
+	BuildSecrets       map[string]string            // name -> source path
+	Services           map[string]string            // name -> source path
+	MCPServers         map[string]string            // name -> source path
 	ToolMountsRO       map[string]map[string]string // tool -> value -> source
 	ToolMountsRW       map[string]map[string]string // tool -> value -> source
+	ToolEphemeral      map[string]string            // tool -> source path
+	ToolSyncBack       map[string]map[string]string // tool -> value -> source
+	ToolIsolate        map[string]map[string]string // tool -> value -> source
+	ToolArgs           map[string]map[string]string // tool -> value -> source
+	ToolDefaultFlags   map[string]string            // tool -> source path
 	ToolEnv            map[string]map[string]string // tool -> value -> source
 	ToolPreRunHooks    map[string]map[string]string // tool -> value -> source
 	ToolPostBuildHooks map[string]map[string]string // tool -> value -> source
+	ToolPostRunHooks   map[string]map[string]string // tool -> value -> source
+	ToolOnFailureHooks map[string]map[string]string // tool -> value -> source
+	ToolResources      map[string]map[string]string // tool -> field -> source
+	ToolNetwork        map[string]string            // tool -> source path
+	ToolNetworkAllow   map[string]map[string]string // tool -> value -> source
+	ToolNetworkJoin    map[string]string            // tool -> source path
+	ToolPlatform       map[string]string            // tool -> source path
+	ToolPorts          map[string]map[string]string // tool -> value -> source
+	ToolTmpfs          map[string]map[string]string // tool -> value -> source
+	ToolCacheVolumes   map[string]map[string]string // tool -> name -> source
+	ToolBuildStages    map[string]map[string]string // tool -> name -> source
+	ToolSecrets        map[string]map[string]string // tool -> name -> source
 	RepoTool           map[string]string            // repo -> source path
+	RepoDockerfile     map[string]string            // repo -> source path
+	RepoBaseImage      map[string]string            // repo -> source path
+	RepoBuildStages    map[string]map[string]string // repo -> name -> source
 	RepoMountsRO       map[string]map[string]string // repo -> value -> source
 	RepoMountsRW       map[string]map[string]string // repo -> value -> source
+	RepoWorkspaces     map[string]map[string]string // repo -> value -> source
 	RepoEnv            map[string]map[string]string // repo -> value -> source
 	RepoPreRunHooks    map[string]map[string]string // repo -> value -> source
 	RepoPostBuildHooks map[string]map[string]string // repo -> value -> source
+	RepoPostRunHooks   map[string]map[string]string // repo -> value -> source
+	RepoOnFailureHooks map[string]map[string]string // repo -> value -> source
+	RepoResources      map[string]map[string]string // repo -> field -> source
+	RepoGitIdentity    map[string]map[string]string // repo -> field -> source
+	RepoNetwork        map[string]string            // repo -> source path
+	RepoNetworkAllow   map[string]map[string]string // repo -> value -> source
+	RepoNetworkJoin    map[string]string            // repo -> source path
+	RepoProfile        map[string]string            // repo -> source path
+	RepoDockerInDocker map[string]string            // repo -> source path
+	RepoSSHAgent       map[string]string            // repo -> source path
+	RepoReadOnlyRootfs map[string]string            // repo -> source path
+	RepoSeccompProfile map[string]string            // repo -> source path
+	RepoAppArmor       map[string]string            // repo -> source path
+	RepoCommitSigning  map[string]string            // repo -> source path
+	RepoPorts          map[string]map[string]string // repo -> value -> source
+	RepoTmpfs          map[string]map[string]string // repo -> value -> source
+	RepoCacheVolumes   map[string]map[string]string // repo -> name -> source
+	RepoSecrets        map[string]map[string]string // repo -> name -> source
+	RepoBuildSecrets   map[string]map[string]string // repo -> name -> source
+	RepoServices       map[string]map[string]string // repo -> name -> source
+	RepoMCPServers     map[string]map[string]string // repo -> name -> source
+	Profiles           map[string]string            // profile -> last source path defining it
 }
 
 // ConfigPath represents a config file path with its status
@@ -113,6 +984,15 @@ type ConfigPath struct {
 	Exists bool
 }
 
+// DefaultEnvBlocklist are the host environment variables never passed
+// through by a pattern unless exempted: cloud and GitHub credentials that
+// reach far beyond the project.
+var DefaultEnvBlocklist = []string{"AWS_*", "GITHUB_TOKEN", "GH_TOKEN"}
+
+// DefaultEnvRedact are the host environment variables whose values are
+// redacted from output, by the names credentials usually have.
+var DefaultEnvRedact = []string{"*TOKEN*", "*SECRET*", "*PASSWORD*", "*_KEY", "AWS_*"}
+
 // DefaultConfig returns the default configuration. toolDefaults supplies
 // per-tool default configs (mounts, env, hooks) so the config package does
 // not need to know about individual tools.
@@ -125,8 +1005,13 @@ func DefaultConfig(toolDefaults map[string]ToolConfig) Config {
 		MountsRO:       []string{},
 		MountsRW:       []string{},
 		Env:            []string{},
+		EnvBlocklist:   slices.Clone(DefaultEnvBlocklist),
+		EnvRedact:      slices.Clone(DefaultEnvRedact),
 		PreRunHooks:    []string{},
 		PostBuildHooks: []string{},
+		NetworkAllow:   []string{},
+		Ports:          []string{},
+		Tmpfs:          []string{},
 		Tools:          tools,
 	}
 }
@@ -142,7 +1027,11 @@ func Load(path string) (Config, error) {
 	if err != nil {
 		return Config{}, err
 	}
+	return parse(path, data)
+}
 
+// parse parses the configuration data of the file at path.
+func parse(path string, data []byte) (Config, error) {
 	// Strip comments from JSONC to get valid JSON
 	jsonData := jsonc.ToJSON(data)
 
@@ -151,9 +1040,46 @@ func Load(path string) (Config, error) {
 		return Config{}, err
 	}
 
+	// Resolve Dockerfile paths against the config file so they work from
+	// any directory.
+	dir := filepath.Dir(path)
+	cfg.Dockerfile = resolvePath(dir, cfg.Dockerfile)
+	resolvePaths(dir, cfg.BuildStages)
+	for _, tool := range cfg.Tools {
+		resolvePaths(dir, tool.BuildStages)
+	}
+	cfg.EncryptIdentity = resolvePath(dir, cfg.EncryptIdentity)
+	cfg.StateDir = resolvePath(dir, cfg.StateDir)
+	for name, repo := range cfg.Repos {
+		repo.Dockerfile = resolvePath(dir, repo.Dockerfile)
+		resolvePaths(dir, repo.BuildStages)
+		cfg.Repos[name] = repo
+	}
+	for name, profile := range cfg.Profiles {
+		profile.Dockerfile = resolvePath(dir, profile.Dockerfile)
+		resolvePaths(dir, profile.BuildStages)
+		cfg.Profiles[name] = profile
+	}
+
 	return cfg, nil
 }
 
+// resolvePath returns path joined to dir if it is relative. Empty paths and
+// paths starting with ~ are returned unchanged.
+func resolvePath(dir, path string) string {
+	if path == "" || path == "~" || strings.HasPrefix(path, "~/") || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// resolvePaths resolves each path in m with resolvePath, in place.
+func resolvePaths(dir string, m map[string]string) {
+	for k, path := range m {
+		m[k] = resolvePath(dir, path)
+	}
+}
+
 // Merge merges two configs, with the overlay taking precedence for arrays (append) and maps (merge)
 func Merge(base, overlay Config) Config {
 	result := base
@@ -162,18 +1088,180 @@ func Merge(base, overlay Config) Config {
 	if overlay.Backend != "" {
 		result.Backend = overlay.Backend
 	}
+	result.BackendFallback = append(result.BackendFallback, overlay.BackendFallback...)
+	result.Presets = append(result.Presets, overlay.Presets...)
+	result.Ignored = append(result.Ignored, overlay.Ignored...)
 
 	// Tool: overlay takes precedence if set
 	if overlay.Tool != "" {
 		result.Tool = overlay.Tool
 	}
 
+	// A11y: enabled if any config enables it
+	if overlay.A11y {
+		result.A11y = true
+	}
+
+	// Dockerfile and BaseImage: overlay takes precedence if set
+	if overlay.Dockerfile != "" {
+		result.Dockerfile = overlay.Dockerfile
+	}
+	if overlay.BaseImage != "" {
+		result.BaseImage = overlay.BaseImage
+	}
+	result.BuildStages = MergeBuildStages(result.BuildStages, overlay.BuildStages)
+
+	// QuickActionsKey: overlay takes precedence if set
+	if overlay.QuickActionsKey != "" {
+		result.QuickActionsKey = overlay.QuickActionsKey
+	}
+
+	// Notify: overlay takes precedence per field, patterns are appended
+	result.Notify = MergeNotify(result.Notify, overlay.Notify)
+
+	// Stop: overlay takes precedence per field
+	result.Stop = MergeStopPolicy(result.Stop, overlay.Stop)
+
+	// Timeouts: overlay takes precedence per field
+	result.Timeouts = MergeTimeouts(result.Timeouts, overlay.Timeouts)
+
+	// ContainerName: overlay takes precedence if set
+	if overlay.ContainerName != "" {
+		result.ContainerName = overlay.ContainerName
+	}
+
+	// BranchSandbox: overlay takes precedence if set
+	if overlay.BranchSandbox != nil {
+		result.BranchSandbox = overlay.BranchSandbox
+	}
+
+	// Registry: overlay takes precedence if set
+	if overlay.Registry != "" {
+		result.Registry = overlay.Registry
+	}
+
+	// ImageRegistry: overlay takes precedence if set
+	if overlay.ImageRegistry != "" {
+		result.ImageRegistry = overlay.ImageRegistry
+	}
+
+	// PullPolicy: overlay takes precedence if set
+	if overlay.PullPolicy != "" {
+		result.PullPolicy = overlay.PullPolicy
+	}
+
+	// Reproducible: enabled if any config enables it
+	if overlay.Reproducible {
+		result.Reproducible = true
+	}
+	if overlay.AptSnapshot != "" {
+		result.AptSnapshot = overlay.AptSnapshot
+	}
+
+	// Encryption: recipients are appended, the identity is replaced
+	result.EncryptRecipients = append(result.EncryptRecipients, overlay.EncryptRecipients...)
+	if overlay.EncryptIdentity != "" {
+		result.EncryptIdentity = overlay.EncryptIdentity
+	}
+
+	// StateDir: overlay takes precedence if set
+	if overlay.StateDir != "" {
+		result.StateDir = overlay.StateDir
+	}
+
+	// ConfigSearch: overlay takes precedence if set
+	if overlay.ConfigSearch != "" {
+		result.ConfigSearch = overlay.ConfigSearch
+	}
+
 	// Append arrays
 	result.MountsRO = append(result.MountsRO, overlay.MountsRO...)
 	result.MountsRW = append(result.MountsRW, overlay.MountsRW...)
+	result.ExtraWorkspaces = append(result.ExtraWorkspaces, overlay.ExtraWorkspaces...)
 	result.Env = append(result.Env, overlay.Env...)
+	result.EnvBlocklist = append(result.EnvBlocklist, overlay.EnvBlocklist...)
+	result.EnvRedact = append(result.EnvRedact, overlay.EnvRedact...)
 	result.PreRunHooks = append(result.PreRunHooks, overlay.PreRunHooks...)
 	result.PostBuildHooks = append(result.PostBuildHooks, overlay.PostBuildHooks...)
+	result.PostRunHooks = append(result.PostRunHooks, overlay.PostRunHooks...)
+	result.OnFailureHooks = append(result.OnFailureHooks, overlay.OnFailureHooks...)
+
+	// HookDefinitions: overlay replaces hooks of the same name
+	result.HookDefinitions = MergeHookDefinitions(result.HookDefinitions, overlay.HookDefinitions)
+
+	// Resources: overlay takes precedence per field
+	result.Resources = MergeResources(result.Resources, overlay.Resources)
+
+	// Network: overlay takes precedence if set, allowed hosts are appended
+	if overlay.Network != "" {
+		result.Network = overlay.Network
+	}
+	result.NetworkAllow = append(result.NetworkAllow, overlay.NetworkAllow...)
+	if overlay.NetworkJoin != "" {
+		result.NetworkJoin = overlay.NetworkJoin
+	}
+
+	// Profile: overlay takes precedence if set
+	if overlay.Profile != "" {
+		result.Profile = overlay.Profile
+	}
+
+	// DockerInDocker: overlay takes precedence if set
+	if overlay.DockerInDocker != "" {
+		result.DockerInDocker = overlay.DockerInDocker
+	}
+
+	// SSHAgent: overlay takes precedence if set
+	if overlay.SSHAgent != nil {
+		result.SSHAgent = overlay.SSHAgent
+	}
+	if overlay.ReadOnlyRootfs != nil {
+		result.ReadOnlyRootfs = overlay.ReadOnlyRootfs
+	}
+	if overlay.SeccompProfile != "" {
+		result.SeccompProfile = overlay.SeccompProfile
+	}
+	if overlay.AppArmorProfile != "" {
+		result.AppArmorProfile = overlay.AppArmorProfile
+	}
+
+	// CommitSigning: overlay takes precedence if set
+	if overlay.CommitSigning != "" {
+		result.CommitSigning = overlay.CommitSigning
+	}
+
+	// SELinuxLabel: overlay takes precedence if set
+	if overlay.SELinuxLabel != "" {
+		result.SELinuxLabel = overlay.SELinuxLabel
+	}
+
+	// ContainerUser: overlay takes precedence per field
+	result.ContainerUser = MergeContainerUser(result.ContainerUser, overlay.ContainerUser)
+
+	// Userns: overlay takes precedence if set
+	if overlay.Userns != "" {
+		result.Userns = overlay.Userns
+	}
+
+	// Platform: overlay takes precedence if set
+	if overlay.Platform != "" {
+		result.Platform = overlay.Platform
+	}
+	result.Ports = append(result.Ports, overlay.Ports...)
+	result.Tmpfs = append(result.Tmpfs, overlay.Tmpfs...)
+
+	// CacheVolumes: overlay replaces volumes of the same name
+	result.CacheVolumes = MergeCacheVolumes(result.CacheVolumes, overlay.CacheVolumes)
+
+	// Secrets: overlay replaces secrets of the same name
+	result.Secrets = MergeSecrets(result.Secrets, overlay.Secrets)
+	result.BuildSecrets = MergeSecrets(result.BuildSecrets, overlay.BuildSecrets)
+
+	// Services: overlay replaces services of the same name
+	result.Services = MergeServices(result.Services, overlay.Services)
+
+	// MCPServers: overlay replaces servers of the same name
+	result.MCPServers = MergeMCPServers(result.MCPServers, overlay.MCPServers)
 
 	// Merge tools map
 	if result.Tools == nil {
@@ -183,9 +1271,36 @@ func Merge(base, overlay Config) Config {
 		if existing, ok := result.Tools[name]; ok {
 			existing.MountsRO = append(existing.MountsRO, tool.MountsRO...)
 			existing.MountsRW = append(existing.MountsRW, tool.MountsRW...)
+			if tool.Ephemeral {
+				existing.Ephemeral = true
+			}
+			existing.SyncBack = append(existing.SyncBack, tool.SyncBack...)
+			existing.Isolate = append(existing.Isolate, tool.Isolate...)
+			existing.Args = append(existing.Args, tool.Args...)
+			if tool.DefaultFlags != nil {
+				existing.DefaultFlags = tool.DefaultFlags
+			}
 			existing.Env = append(existing.Env, tool.Env...)
 			existing.PreRunHooks = append(existing.PreRunHooks, tool.PreRunHooks...)
 			existing.PostBuildHooks = append(existing.PostBuildHooks, tool.PostBuildHooks...)
+			existing.BuildStages = MergeBuildStages(existing.BuildStages, tool.BuildStages)
+			existing.PostRunHooks = append(existing.PostRunHooks, tool.PostRunHooks...)
+			existing.OnFailureHooks = append(existing.OnFailureHooks, tool.OnFailureHooks...)
+			existing.Resources = MergeResources(existing.Resources, tool.Resources)
+			if tool.Network != "" {
+				existing.Network = tool.Network
+			}
+			existing.NetworkAllow = append(existing.NetworkAllow, tool.NetworkAllow...)
+			if tool.NetworkJoin != "" {
+				existing.NetworkJoin = tool.NetworkJoin
+			}
+			if tool.Platform != "" {
+				existing.Platform = tool.Platform
+			}
+			existing.Ports = append(existing.Ports, tool.Ports...)
+			existing.Tmpfs = append(existing.Tmpfs, tool.Tmpfs...)
+			existing.CacheVolumes = MergeCacheVolumes(existing.CacheVolumes, tool.CacheVolumes)
+			existing.Secrets = MergeSecrets(existing.Secrets, tool.Secrets)
 			result.Tools[name] = existing
 		} else {
 			result.Tools[name] = tool
@@ -198,17 +1313,83 @@ func Merge(base, overlay Config) Config {
 	}
 	for name, repo := range overlay.Repos {
 		if existing, ok := result.Repos[name]; ok {
-			existing.MountsRO = append(existing.MountsRO, repo.MountsRO...)
-			existing.MountsRW = append(existing.MountsRW, repo.MountsRW...)
-			existing.Env = append(existing.Env, repo.Env...)
-			existing.PreRunHooks = append(existing.PreRunHooks, repo.PreRunHooks...)
-			existing.PostBuildHooks = append(existing.PostBuildHooks, repo.PostBuildHooks...)
-			result.Repos[name] = existing
+			result.Repos[name] = MergeRepoConfig(existing, repo)
 		} else {
 			result.Repos[name] = repo
 		}
 	}
 
+	// Merge profiles map, the same way as repos
+	if result.Profiles == nil {
+		result.Profiles = make(map[string]RepoConfig)
+	}
+	for name, profile := range overlay.Profiles {
+		if existing, ok := result.Profiles[name]; ok {
+			result.Profiles[name] = MergeRepoConfig(existing, profile)
+		} else {
+			result.Profiles[name] = profile
+		}
+	}
+
+	return result
+}
+
+// MergeRepoConfig merges two configs of a repo or profile, with overlay
+// taking precedence.
+func MergeRepoConfig(base, overlay RepoConfig) RepoConfig {
+	result := base
+	if overlay.Dockerfile != "" {
+		result.Dockerfile = overlay.Dockerfile
+	}
+	if overlay.BaseImage != "" {
+		result.BaseImage = overlay.BaseImage
+	}
+	result.BuildStages = MergeBuildStages(result.BuildStages, overlay.BuildStages)
+	result.MountsRO = append(result.MountsRO, overlay.MountsRO...)
+	result.MountsRW = append(result.MountsRW, overlay.MountsRW...)
+	result.ExtraWorkspaces = append(result.ExtraWorkspaces, overlay.ExtraWorkspaces...)
+	result.Env = append(result.Env, overlay.Env...)
+	result.PreRunHooks = append(result.PreRunHooks, overlay.PreRunHooks...)
+	result.PostBuildHooks = append(result.PostBuildHooks, overlay.PostBuildHooks...)
+	result.PostRunHooks = append(result.PostRunHooks, overlay.PostRunHooks...)
+	result.OnFailureHooks = append(result.OnFailureHooks, overlay.OnFailureHooks...)
+	result.Resources = MergeResources(result.Resources, overlay.Resources)
+	result.GitIdentity = MergeGitIdentity(result.GitIdentity, overlay.GitIdentity)
+	if overlay.Network != "" {
+		result.Network = overlay.Network
+	}
+	result.NetworkAllow = append(result.NetworkAllow, overlay.NetworkAllow...)
+	if overlay.NetworkJoin != "" {
+		result.NetworkJoin = overlay.NetworkJoin
+	}
+	if overlay.Profile != "" {
+		result.Profile = overlay.Profile
+	}
+	if overlay.DockerInDocker != "" {
+		result.DockerInDocker = overlay.DockerInDocker
+	}
+	if overlay.SSHAgent != nil {
+		result.SSHAgent = overlay.SSHAgent
+	}
+	if overlay.ReadOnlyRootfs != nil {
+		result.ReadOnlyRootfs = overlay.ReadOnlyRootfs
+	}
+	if overlay.SeccompProfile != "" {
+		result.SeccompProfile = overlay.SeccompProfile
+	}
+	if overlay.AppArmorProfile != "" {
+		result.AppArmorProfile = overlay.AppArmorProfile
+	}
+	if overlay.CommitSigning != "" {
+		result.CommitSigning = overlay.CommitSigning
+	}
+	result.Ports = append(result.Ports, overlay.Ports...)
+	result.Tmpfs = append(result.Tmpfs, overlay.Tmpfs...)
+	result.CacheVolumes = MergeCacheVolumes(result.CacheVolumes, overlay.CacheVolumes)
+	result.Secrets = MergeSecrets(result.Secrets, overlay.Secrets)
+	result.BuildSecrets = MergeSecrets(result.BuildSecrets, overlay.BuildSecrets)
+	result.Services = MergeServices(result.Services, overlay.Services)
+	result.MCPServers = MergeMCPServers(result.MCPServers, overlay.MCPServers)
 	return result
 }
 
@@ -217,20 +1398,86 @@ func NewSourceInfo() *SourceInfo {
 	return &SourceInfo{
 		MountsRO:           make(map[string]string),
 		MountsRW:           make(map[string]string),
+		ExtraWorkspaces:    make(map[string]string),
 		Env:                make(map[string]string),
+		EnvBlocklist:       make(map[string]string),
+		EnvRedact:          make(map[string]string),
 		PreRunHooks:        make(map[string]string),
 		PostBuildHooks:     make(map[string]string),
+		PostRunHooks:       make(map[string]string),
+		OnFailureHooks:     make(map[string]string),
+		HookDefinitions:    make(map[string]string),
+		BuildStages:        make(map[string]string),
+		Resources:          make(map[string]string),
+		Notify:             make(map[string]string),
+		Stop:               make(map[string]string),
+		Timeouts:           make(map[string]string),
+		ContainerUser:      make(map[string]string),
+		NetworkAllow:       make(map[string]string),
+		EncryptRecipients:  make(map[string]string),
+		BackendFallback:    make(map[string]string),
+		Presets:            make(map[string]string),
+		Ports:              make(map[string]string),
+		Tmpfs:              make(map[string]string),
+		CacheVolumes:       make(map[string]string),
+		Secrets:            make(map[string]string),
+		BuildSecrets:       make(map[string]string),
+		Services:           make(map[string]string),
+		MCPServers:         make(map[string]string),
 		ToolMountsRO:       make(map[string]map[string]string),
 		ToolMountsRW:       make(map[string]map[string]string),
+		ToolEphemeral:      make(map[string]string),
+		ToolSyncBack:       make(map[string]map[string]string),
+		ToolIsolate:        make(map[string]map[string]string),
+		ToolArgs:           make(map[string]map[string]string),
+		ToolDefaultFlags:   make(map[string]string),
 		ToolEnv:            make(map[string]map[string]string),
 		ToolPreRunHooks:    make(map[string]map[string]string),
 		ToolPostBuildHooks: make(map[string]map[string]string),
+		ToolPostRunHooks:   make(map[string]map[string]string),
+		ToolOnFailureHooks: make(map[string]map[string]string),
+		ToolResources:      make(map[string]map[string]string),
+		ToolNetwork:        make(map[string]string),
+		ToolNetworkAllow:   make(map[string]map[string]string),
+		ToolNetworkJoin:    make(map[string]string),
+		ToolPlatform:       make(map[string]string),
+		ToolPorts:          make(map[string]map[string]string),
+		ToolTmpfs:          make(map[string]map[string]string),
+		ToolCacheVolumes:   make(map[string]map[string]string),
+		ToolBuildStages:    make(map[string]map[string]string),
+		ToolSecrets:        make(map[string]map[string]string),
 		RepoTool:           make(map[string]string),
+		RepoDockerfile:     make(map[string]string),
+		RepoBaseImage:      make(map[string]string),
+		RepoBuildStages:    make(map[string]map[string]string),
 		RepoMountsRO:       make(map[string]map[string]string),
 		RepoMountsRW:       make(map[string]map[string]string),
+		RepoWorkspaces:     make(map[string]map[string]string),
 		RepoEnv:            make(map[string]map[string]string),
 		RepoPreRunHooks:    make(map[string]map[string]string),
 		RepoPostBuildHooks: make(map[string]map[string]string),
+		RepoPostRunHooks:   make(map[string]map[string]string),
+		RepoOnFailureHooks: make(map[string]map[string]string),
+		RepoResources:      make(map[string]map[string]string),
+		RepoGitIdentity:    make(map[string]map[string]string),
+		RepoNetwork:        make(map[string]string),
+		RepoNetworkAllow:   make(map[string]map[string]string),
+		RepoNetworkJoin:    make(map[string]string),
+		RepoProfile:        make(map[string]string),
+		RepoDockerInDocker: make(map[string]string),
+		RepoSSHAgent:       make(map[string]string),
+		RepoReadOnlyRootfs: make(map[string]string),
+		RepoSeccompProfile: make(map[string]string),
+		RepoAppArmor:       make(map[string]string),
+		RepoCommitSigning:  make(map[string]string),
+		RepoPorts:          make(map[string]map[string]string),
+		RepoTmpfs:          make(map[string]map[string]string),
+		RepoCacheVolumes:   make(map[string]map[string]string),
+		RepoSecrets:        make(map[string]map[string]string),
+		RepoBuildSecrets:   make(map[string]map[string]string),
+		RepoServices:       make(map[string]map[string]string),
+		RepoMCPServers:     make(map[string]map[string]string),
+		Profiles:           make(map[string]string),
 	}
 }
 
@@ -239,34 +1486,134 @@ func GetConfigPaths() []ConfigPath {
 	var paths []ConfigPath
 
 	// Global config
-	globalConfigPath := filepath.Join(xdg.ConfigHome, "silo", "silo.jsonc")
-	_, err := os.Stat(globalConfigPath)
+	globalConfigPath := GlobalConfigPath()
+	_, err := readFile(globalConfigPath)
 	paths = append(paths, ConfigPath{Path: globalConfigPath, Exists: err == nil})
 
-	// Find all config files from root to current directory
+	// Find all config files from the search root to current directory
 	cwd, err := os.Getwd()
 	if err != nil {
 		return paths
 	}
+	return append(paths, localConfigPaths(cwd, globalSearch())...)
+}
+
+// Values of ConfigSearch
+const (
+	SearchRepo       = "repo"
+	SearchFilesystem = "filesystem"
+)
+
+// GlobalConfigPath returns the path of the global config file.
+func GlobalConfigPath() string {
+	return filepath.Join(xdg.ConfigHome, "silo", "silo.jsonc")
+}
+
+// globalSearch returns the config_search setting of the global config.
+func globalSearch() string {
+	data, err := readFile(GlobalConfigPath())
+	if err != nil {
+		return SearchRepo
+	}
+	cfg, err := parse(GlobalConfigPath(), data)
+	if err != nil || cfg.ConfigSearch == "" {
+		return SearchRepo
+	}
+	return cfg.ConfigSearch
+}
+
+// localConfigPaths returns the silo.jsonc path of each directory from the
+// search root down to dir, whether or not it exists, each followed by the
+// fragments beside it. With SearchRepo the search root is the root of the
+// git worktree containing dir, or the filesystem root outside a worktree.
+func localConfigPaths(dir, search string) []ConfigPath {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	key := search + "\x00" + dir
+	if paths, ok := cache.paths[key]; ok {
+		return paths
+	}
 
-	var localPaths []ConfigPath
-	dir := cwd
-	for {
-		configPath := filepath.Join(dir, "silo.jsonc")
+	var paths []ConfigPath
+	for d := dir; ; d = filepath.Dir(d) {
+		configPath := filepath.Join(d, "silo.jsonc")
 		_, err := os.Stat(configPath)
-		localPaths = append([]ConfigPath{{Path: configPath, Exists: err == nil}}, localPaths...)
+		dirPaths := []ConfigPath{{Path: configPath, Exists: err == nil}}
+		for _, p := range FragmentPaths(d) {
+			dirPaths = append(dirPaths, ConfigPath{Path: p, Exists: true})
+		}
+		paths = append(dirPaths, paths...)
 
-		parent := filepath.Dir(dir)
-		if parent == dir {
+		if search != SearchFilesystem {
+			// A .git directory, or a .git file in a linked worktree
+			if _, err := os.Lstat(filepath.Join(d, ".git")); err == nil {
+				break
+			}
+		}
+		if filepath.Dir(d) == d {
 			break
 		}
-		dir = parent
 	}
 
-	paths = append(paths, localPaths...)
+	if cache.paths == nil {
+		cache.paths = make(map[string][]ConfigPath)
+	}
+	cache.paths[key] = paths
 	return paths
 }
 
+// cache memoizes config discovery for the life of the process, as silo
+// loads the config several times per invocation and the files don't change
+// in between. Files are cached as read, and parsed and merged on each load,
+// so callers never share a Config.
+var cache struct {
+	mu    sync.Mutex
+	paths map[string][]ConfigPath // search + dir -> local config paths
+	files map[string]cachedFile   // path -> contents
+}
+
+type cachedFile struct {
+	data []byte
+	err  error
+}
+
+// readFile returns the contents of the config file at path, reading it only
+// the first time.
+func readFile(path string) ([]byte, error) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if f, ok := cache.files[path]; ok {
+		return f.data, f.err
+	}
+	data, err := os.ReadFile(path)
+	if cache.files == nil {
+		cache.files = make(map[string]cachedFile)
+	}
+	cache.files[path] = cachedFile{data: data, err: err}
+	return data, err
+}
+
+// Reload forgets the config files discovered and read so far, so the next
+// load sees changes made to them since.
+func Reload() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	cache.paths = nil
+	cache.files = nil
+}
+
+// FragmentPaths returns the config fragments in the .silo directory of dir:
+// its *.jsonc files in alphabetical order. They are merged after the
+// silo.jsonc of dir, so a project can commit shared fragments and keep
+// personal ones out of git.
+func FragmentPaths(dir string) []string {
+	paths, _ := filepath.Glob(filepath.Join(dir, ".silo", "*.jsonc"))
+	return slices.DeleteFunc(paths, func(p string) bool {
+		info, err := os.Stat(p)
+		return err != nil || info.IsDir()
+	})
+}
+
 // LoadAll loads and merges all configuration files from XDG config home and current/parent directories.
 // Missing or invalid config files are silently ignored - only defaults and valid configs are merged.
 func LoadAll(toolDefaults map[string]ToolConfig) Config {
@@ -283,42 +1630,124 @@ func LoadAllWithSources(toolDefaults map[string]ToolConfig) (Config, *SourceInfo
 	trackConfigSources(cfg, "default", sources)
 
 	// Load from XDG config home
-	globalConfigPath := filepath.Join(xdg.ConfigHome, "silo", "silo.jsonc")
-	if globalCfg, err := Load(globalConfigPath); err == nil {
-		trackConfigSources(globalCfg, globalConfigPath, sources)
-		cfg = Merge(cfg, globalCfg)
+	cfg = mergeFile(cfg, sources, GlobalConfigPath(), true)
+	cfg.hostHookDefinitions = maps.Clone(cfg.HookDefinitions)
+	if cfg.hostHookDefinitions == nil {
+		cfg.hostHookDefinitions = map[string]string{}
 	}
 
-	// Find all config files from root to current directory
+	// Find all config files from the search root to current directory
 	cwd, err := os.Getwd()
 	if err != nil {
 		return cfg, sources
 	}
 
-	var configPaths []string
-	dir := cwd
-	for {
-		configPath := filepath.Join(dir, "silo.jsonc")
-		if _, err := os.Stat(configPath); err == nil {
-			configPaths = append([]string{configPath}, configPaths...)
+	// Load and merge configs from parent to child (child overrides parent)
+	for _, p := range localConfigPaths(cwd, globalSearch()) {
+		if p.Exists {
+			cfg = mergeFile(cfg, sources, p.Path, false)
 		}
+	}
 
-		parent := filepath.Dir(dir)
-		if parent == dir {
-			break
+	return cfg, sources
+}
+
+// mergeFile merges the config file at path into cfg, preceded by the presets
+// it uses so the file can override them. Missing or invalid files are
+// skipped, as are presets that aren't installed or have been modified.
+// Settings only read from the global config are ignored unless global.
+func mergeFile(cfg Config, sources *SourceInfo, path string, global bool) Config {
+	data, err := readFile(path)
+	if err != nil {
+		return cfg
+	}
+	fileCfg, err := parse(path, data)
+	if err != nil {
+		return cfg
+	}
+	if !global {
+		for _, name := range dropGlobalOnly(&fileCfg) {
+			fileCfg.Ignored = append(fileCfg.Ignored, fmt.Sprintf("%s (%s)", name, path))
 		}
-		dir = parent
 	}
-
-	// Load and merge configs from parent to child (child overrides parent)
-	for _, path := range configPaths {
-		if localCfg, err := Load(path); err == nil {
-			trackConfigSources(localCfg, path, sources)
-			cfg = Merge(cfg, localCfg)
+	for _, name := range fileCfg.Presets {
+		if err := preset.Verify(name); err != nil {
+			continue
+		}
+		presetPath := preset.Path(name)
+		presetCfg, err := Load(presetPath)
+		if err != nil {
+			continue
+		}
+		presetCfg.Presets = nil // presets can't use other presets
+		if !global {
+			for _, name := range dropGlobalOnly(&presetCfg) {
+				presetCfg.Ignored = append(presetCfg.Ignored, fmt.Sprintf("%s (%s)", name, presetPath))
+			}
 		}
+		trackConfigSources(presetCfg, presetPath, sources)
+		cfg = Merge(cfg, presetCfg)
 	}
+	trackConfigSources(fileCfg, path, sources)
+	return Merge(cfg, fileCfg)
+}
 
-	return cfg, sources
+// dropGlobalOnly clears the settings of cfg only read from the global
+// config, as a project's config files are in the working directory the
+// tool can write, and returns the names of those that were set. They are
+// those that reach outside the container: hooks and notify commands run on
+// the host, secrets and build secrets are fetched on the host, from its
+// credential stores or by running commands, and the uid the working directory is shared with.
+func dropGlobalOnly(cfg *Config) []string {
+	var names []string
+	dropHostHooks := func(prefix string, postRun, onFailure *[]string) {
+		if len(*postRun) > 0 {
+			names = append(names, prefix+"post_run_hooks")
+			*postRun = nil
+		}
+		if len(*onFailure) > 0 {
+			names = append(names, prefix+"on_failure_hooks")
+			*onFailure = nil
+		}
+	}
+	dropSecrets := func(name string, secrets *map[string]Secret) {
+		if len(*secrets) > 0 {
+			names = append(names, name)
+			*secrets = nil
+		}
+	}
+	dropHostHooks("", &cfg.PostRunHooks, &cfg.OnFailureHooks)
+	dropSecrets("secrets", &cfg.Secrets)
+	dropSecrets("build_secrets", &cfg.BuildSecrets)
+	for _, name := range slices.Sorted(maps.Keys(cfg.Tools)) {
+		t := cfg.Tools[name]
+		dropHostHooks("tools."+name+".", &t.PostRunHooks, &t.OnFailureHooks)
+		dropSecrets("tools."+name+".secrets", &t.Secrets)
+		cfg.Tools[name] = t
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Repos)) {
+		r := cfg.Repos[name]
+		dropHostHooks("repos."+name+".", &r.PostRunHooks, &r.OnFailureHooks)
+		dropSecrets("repos."+name+".secrets", &r.Secrets)
+		dropSecrets("repos."+name+".build_secrets", &r.BuildSecrets)
+		cfg.Repos[name] = r
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.Profiles)) {
+		p := cfg.Profiles[name]
+		dropHostHooks("profiles."+name+".", &p.PostRunHooks, &p.OnFailureHooks)
+		dropSecrets("profiles."+name+".secrets", &p.Secrets)
+		dropSecrets("profiles."+name+".build_secrets", &p.BuildSecrets)
+		cfg.Profiles[name] = p
+	}
+	if cfg.Notify.Command != "" {
+		names = append(names, "notify.command")
+		cfg.Notify.Command = ""
+	}
+	if cfg.ContainerUser.UID != 0 {
+		names = append(names, "container_user.uid")
+		cfg.ContainerUser.UID = 0
+	}
+	return names
 }
 
 // trackConfigSources records the source for each value in the config
@@ -326,24 +1755,196 @@ func trackConfigSources(cfg Config, source string, info *SourceInfo) {
 	if cfg.Backend != "" {
 		info.Backend = source
 	}
+	for _, v := range cfg.BackendFallback {
+		info.BackendFallback[v] = source
+	}
+	for _, v := range cfg.Presets {
+		info.Presets[v] = source
+	}
 	if cfg.Tool != "" {
 		info.Tool = source
 	}
+	if cfg.A11y {
+		info.A11y = source
+	}
+	if cfg.Dockerfile != "" {
+		info.Dockerfile = source
+	}
+	if cfg.BaseImage != "" {
+		info.BaseImage = source
+	}
+	for name := range cfg.BuildStages {
+		info.BuildStages[name] = source
+	}
+	if cfg.Registry != "" {
+		info.Registry = source
+	}
+	if cfg.ImageRegistry != "" {
+		info.ImageRegistry = source
+	}
+	if cfg.PullPolicy != "" {
+		info.PullPolicy = source
+	}
+	if cfg.Reproducible {
+		info.Reproducible = source
+	}
+	if cfg.AptSnapshot != "" {
+		info.AptSnapshot = source
+	}
+	if cfg.QuickActionsKey != "" {
+		info.QuickActionsKey = source
+	}
+	if cfg.Notify.Enabled {
+		info.Notify["enabled"] = source
+	}
+	if cfg.Notify.Idle != "" {
+		info.Notify["idle"] = source
+	}
+	if len(cfg.Notify.Patterns) > 0 {
+		info.Notify["patterns"] = source
+	}
+	if cfg.Notify.Command != "" {
+		info.Notify["command"] = source
+	}
+	if cfg.Stop.Signal != "" {
+		info.Stop["signal"] = source
+	}
+	if cfg.Stop.GracePeriod != "" {
+		info.Stop["grace_period"] = source
+	}
+	if cfg.Stop.Confirm {
+		info.Stop["confirm"] = source
+	}
+	if cfg.Timeouts.Build != "" {
+		info.Timeouts["build"] = source
+	}
+	if cfg.Timeouts.MountWait != "" {
+		info.Timeouts["mount_wait"] = source
+	}
+	if cfg.Timeouts.Connect != "" {
+		info.Timeouts["connect"] = source
+	}
+	if cfg.ContainerName != "" {
+		info.ContainerName = source
+	}
+	if cfg.BranchSandbox != nil {
+		info.BranchSandbox = source
+	}
+	for _, v := range cfg.EncryptRecipients {
+		info.EncryptRecipients[v] = source
+	}
+	if cfg.EncryptIdentity != "" {
+		info.EncryptIdentity = source
+	}
+	if cfg.StateDir != "" {
+		info.StateDir = source
+	}
+	if cfg.ConfigSearch != "" {
+		info.ConfigSearch = source
+	}
 	for _, v := range cfg.MountsRO {
 		info.MountsRO[v] = source
 	}
 	for _, v := range cfg.MountsRW {
 		info.MountsRW[v] = source
 	}
+	for _, v := range cfg.ExtraWorkspaces {
+		info.ExtraWorkspaces[v] = source
+	}
 	for _, v := range cfg.Env {
 		info.Env[v] = source
 	}
+	for _, v := range cfg.EnvBlocklist {
+		info.EnvBlocklist[v] = source
+	}
+	for _, v := range cfg.EnvRedact {
+		info.EnvRedact[v] = source
+	}
 	for _, v := range cfg.PreRunHooks {
 		info.PreRunHooks[v] = source
 	}
 	for _, v := range cfg.PostBuildHooks {
 		info.PostBuildHooks[v] = source
 	}
+	for _, v := range cfg.PostRunHooks {
+		info.PostRunHooks[v] = source
+	}
+	for _, v := range cfg.OnFailureHooks {
+		info.OnFailureHooks[v] = source
+	}
+	trackResourceSources(cfg.Resources, source, info.Resources)
+	if cfg.Network != "" {
+		info.Network = source
+	}
+	for _, v := range cfg.NetworkAllow {
+		info.NetworkAllow[v] = source
+	}
+	if cfg.NetworkJoin != "" {
+		info.NetworkJoin = source
+	}
+	if cfg.Profile != "" {
+		info.Profile = source
+	}
+	if cfg.DockerInDocker != "" {
+		info.DockerInDocker = source
+	}
+	if cfg.SSHAgent != nil {
+		info.SSHAgent = source
+	}
+	if cfg.ReadOnlyRootfs != nil {
+		info.ReadOnlyRootfs = source
+	}
+	if cfg.SeccompProfile != "" {
+		info.SeccompProfile = source
+	}
+	if cfg.AppArmorProfile != "" {
+		info.AppArmorProfile = source
+	}
+	if cfg.CommitSigning != "" {
+		info.CommitSigning = source
+	}
+	if cfg.SELinuxLabel != "" {
+		info.SELinuxLabel = source
+	}
+	if cfg.ContainerUser.Name != "" {
+		info.ContainerUser["name"] = source
+	}
+	if cfg.ContainerUser.UID != 0 {
+		info.ContainerUser["uid"] = source
+	}
+	if cfg.ContainerUser.GID != 0 {
+		info.ContainerUser["gid"] = source
+	}
+	if cfg.Userns != "" {
+		info.Userns = source
+	}
+	if cfg.Platform != "" {
+		info.Platform = source
+	}
+	for _, v := range cfg.Ports {
+		info.Ports[v] = source
+	}
+	for _, v := range cfg.Tmpfs {
+		info.Tmpfs[v] = source
+	}
+	for name := range cfg.CacheVolumes {
+		info.CacheVolumes[name] = source
+	}
+	for name := range cfg.HookDefinitions {
+		info.HookDefinitions[name] = source
+	}
+	for name := range cfg.Secrets {
+		info.Secrets[name] = source
+	}
+	for name := range cfg.BuildSecrets {
+		info.BuildSecrets[name] = source
+	}
+	for name := range cfg.Services {
+		info.Services[name] = source
+	}
+	for name := range cfg.MCPServers {
+		info.MCPServers[name] = source
+	}
 	for toolName, toolCfg := range cfg.Tools {
 		if info.ToolMountsRO[toolName] == nil {
 			info.ToolMountsRO[toolName] = make(map[string]string)
@@ -366,6 +1967,30 @@ func trackConfigSources(cfg Config, source string, info *SourceInfo) {
 		for _, v := range toolCfg.MountsRW {
 			info.ToolMountsRW[toolName][v] = source
 		}
+		if toolCfg.Ephemeral {
+			info.ToolEphemeral[toolName] = source
+		}
+		if info.ToolSyncBack[toolName] == nil {
+			info.ToolSyncBack[toolName] = make(map[string]string)
+		}
+		for _, v := range toolCfg.SyncBack {
+			info.ToolSyncBack[toolName][v] = source
+		}
+		if info.ToolIsolate[toolName] == nil {
+			info.ToolIsolate[toolName] = make(map[string]string)
+		}
+		for _, v := range toolCfg.Isolate {
+			info.ToolIsolate[toolName][v] = source
+		}
+		if info.ToolArgs[toolName] == nil {
+			info.ToolArgs[toolName] = make(map[string]string)
+		}
+		for _, v := range toolCfg.Args {
+			info.ToolArgs[toolName][v] = source
+		}
+		if toolCfg.DefaultFlags != nil {
+			info.ToolDefaultFlags[toolName] = source
+		}
 		for _, v := range toolCfg.Env {
 			info.ToolEnv[toolName][v] = source
 		}
@@ -375,11 +2000,84 @@ func trackConfigSources(cfg Config, source string, info *SourceInfo) {
 		for _, v := range toolCfg.PostBuildHooks {
 			info.ToolPostBuildHooks[toolName][v] = source
 		}
+		if info.ToolPostRunHooks[toolName] == nil {
+			info.ToolPostRunHooks[toolName] = make(map[string]string)
+		}
+		for _, v := range toolCfg.PostRunHooks {
+			info.ToolPostRunHooks[toolName][v] = source
+		}
+		if info.ToolOnFailureHooks[toolName] == nil {
+			info.ToolOnFailureHooks[toolName] = make(map[string]string)
+		}
+		for _, v := range toolCfg.OnFailureHooks {
+			info.ToolOnFailureHooks[toolName][v] = source
+		}
+		if info.ToolResources[toolName] == nil {
+			info.ToolResources[toolName] = make(map[string]string)
+		}
+		trackResourceSources(toolCfg.Resources, source, info.ToolResources[toolName])
+		if toolCfg.Network != "" {
+			info.ToolNetwork[toolName] = source
+		}
+		if info.ToolNetworkAllow[toolName] == nil {
+			info.ToolNetworkAllow[toolName] = make(map[string]string)
+		}
+		for _, v := range toolCfg.NetworkAllow {
+			info.ToolNetworkAllow[toolName][v] = source
+		}
+		if toolCfg.NetworkJoin != "" {
+			info.ToolNetworkJoin[toolName] = source
+		}
+		if toolCfg.Platform != "" {
+			info.ToolPlatform[toolName] = source
+		}
+		if info.ToolPorts[toolName] == nil {
+			info.ToolPorts[toolName] = make(map[string]string)
+		}
+		for _, v := range toolCfg.Ports {
+			info.ToolPorts[toolName][v] = source
+		}
+		if info.ToolTmpfs[toolName] == nil {
+			info.ToolTmpfs[toolName] = make(map[string]string)
+		}
+		for _, v := range toolCfg.Tmpfs {
+			info.ToolTmpfs[toolName][v] = source
+		}
+		if info.ToolCacheVolumes[toolName] == nil {
+			info.ToolCacheVolumes[toolName] = make(map[string]string)
+		}
+		for name := range toolCfg.CacheVolumes {
+			info.ToolCacheVolumes[toolName][name] = source
+		}
+		if info.ToolBuildStages[toolName] == nil {
+			info.ToolBuildStages[toolName] = make(map[string]string)
+		}
+		for name := range toolCfg.BuildStages {
+			info.ToolBuildStages[toolName][name] = source
+		}
+		if info.ToolSecrets[toolName] == nil {
+			info.ToolSecrets[toolName] = make(map[string]string)
+		}
+		for name := range toolCfg.Secrets {
+			info.ToolSecrets[toolName][name] = source
+		}
 	}
 	for repoName, repoCfg := range cfg.Repos {
 		if repoCfg.Tool != "" {
 			info.RepoTool[repoName] = source
 		}
+		if repoCfg.Dockerfile != "" {
+			info.RepoDockerfile[repoName] = source
+		}
+		if repoCfg.BaseImage != "" {
+			info.RepoBaseImage[repoName] = source
+		}
+		if info.RepoBuildStages[repoName] == nil {
+			info.RepoBuildStages[repoName] = make(map[string]string)
+		}
+		for name := range repoCfg.BuildStages {
+			info.RepoBuildStages[repoName][name] = source
+		}
 		if info.RepoMountsRO[repoName] == nil {
 			info.RepoMountsRO[repoName] = make(map[string]string)
 		}
@@ -410,6 +2108,140 @@ func trackConfigSources(cfg Config, source string, info *SourceInfo) {
 		for _, v := range repoCfg.PostBuildHooks {
 			info.RepoPostBuildHooks[repoName][v] = source
 		}
+		if info.RepoPostRunHooks[repoName] == nil {
+			info.RepoPostRunHooks[repoName] = make(map[string]string)
+		}
+		for _, v := range repoCfg.PostRunHooks {
+			info.RepoPostRunHooks[repoName][v] = source
+		}
+		if info.RepoOnFailureHooks[repoName] == nil {
+			info.RepoOnFailureHooks[repoName] = make(map[string]string)
+		}
+		for _, v := range repoCfg.OnFailureHooks {
+			info.RepoOnFailureHooks[repoName][v] = source
+		}
+		if info.RepoResources[repoName] == nil {
+			info.RepoResources[repoName] = make(map[string]string)
+		}
+		trackResourceSources(repoCfg.Resources, source, info.RepoResources[repoName])
+		if info.RepoGitIdentity[repoName] == nil {
+			info.RepoGitIdentity[repoName] = make(map[string]string)
+		}
+		if repoCfg.GitIdentity.Name != "" {
+			info.RepoGitIdentity[repoName]["name"] = source
+		}
+		if repoCfg.GitIdentity.Email != "" {
+			info.RepoGitIdentity[repoName]["email"] = source
+		}
+		if repoCfg.GitIdentity.Anonymous {
+			info.RepoGitIdentity[repoName]["anonymous"] = source
+		}
+		if repoCfg.Network != "" {
+			info.RepoNetwork[repoName] = source
+		}
+		if info.RepoNetworkAllow[repoName] == nil {
+			info.RepoNetworkAllow[repoName] = make(map[string]string)
+		}
+		for _, v := range repoCfg.NetworkAllow {
+			info.RepoNetworkAllow[repoName][v] = source
+		}
+		if repoCfg.NetworkJoin != "" {
+			info.RepoNetworkJoin[repoName] = source
+		}
+		if repoCfg.Profile != "" {
+			info.RepoProfile[repoName] = source
+		}
+		if repoCfg.DockerInDocker != "" {
+			info.RepoDockerInDocker[repoName] = source
+		}
+		if repoCfg.SSHAgent != nil {
+			info.RepoSSHAgent[repoName] = source
+		}
+		if repoCfg.ReadOnlyRootfs != nil {
+			info.RepoReadOnlyRootfs[repoName] = source
+		}
+		if repoCfg.SeccompProfile != "" {
+			info.RepoSeccompProfile[repoName] = source
+		}
+		if repoCfg.AppArmorProfile != "" {
+			info.RepoAppArmor[repoName] = source
+		}
+		if repoCfg.CommitSigning != "" {
+			info.RepoCommitSigning[repoName] = source
+		}
+		if info.RepoPorts[repoName] == nil {
+			info.RepoPorts[repoName] = make(map[string]string)
+		}
+		for _, v := range repoCfg.Ports {
+			info.RepoPorts[repoName][v] = source
+		}
+		if info.RepoWorkspaces[repoName] == nil {
+			info.RepoWorkspaces[repoName] = make(map[string]string)
+		}
+		for _, v := range repoCfg.ExtraWorkspaces {
+			info.RepoWorkspaces[repoName][v] = source
+		}
+		if info.RepoTmpfs[repoName] == nil {
+			info.RepoTmpfs[repoName] = make(map[string]string)
+		}
+		for _, v := range repoCfg.Tmpfs {
+			info.RepoTmpfs[repoName][v] = source
+		}
+		if info.RepoCacheVolumes[repoName] == nil {
+			info.RepoCacheVolumes[repoName] = make(map[string]string)
+		}
+		for name := range repoCfg.CacheVolumes {
+			info.RepoCacheVolumes[repoName][name] = source
+		}
+		if info.RepoSecrets[repoName] == nil {
+			info.RepoSecrets[repoName] = make(map[string]string)
+		}
+		for name := range repoCfg.Secrets {
+			info.RepoSecrets[repoName][name] = source
+		}
+		if info.RepoBuildSecrets[repoName] == nil {
+			info.RepoBuildSecrets[repoName] = make(map[string]string)
+		}
+		for name := range repoCfg.BuildSecrets {
+			info.RepoBuildSecrets[repoName][name] = source
+		}
+		if info.RepoServices[repoName] == nil {
+			info.RepoServices[repoName] = make(map[string]string)
+		}
+		for name := range repoCfg.Services {
+			info.RepoServices[repoName][name] = source
+		}
+		if info.RepoMCPServers[repoName] == nil {
+			info.RepoMCPServers[repoName] = make(map[string]string)
+		}
+		for name := range repoCfg.MCPServers {
+			info.RepoMCPServers[repoName][name] = source
+		}
+	}
+	for name := range cfg.Profiles {
+		info.Profiles[name] = source
+	}
+}
+
+// trackResourceSources records the source for each resource field that is set
+func trackResourceSources(r Resources, source string, info map[string]string) {
+	if r.CPUs != 0 {
+		info["cpus"] = source
+	}
+	if r.Memory != "" {
+		info["memory"] = source
+	}
+	if r.Swap != "" {
+		info["swap"] = source
+	}
+	if r.PidsLimit != 0 {
+		info["pids_limit"] = source
+	}
+	if r.Nofile != 0 {
+		info["nofile"] = source
+	}
+	if r.InotifyWatches != 0 {
+		info["inotify_watches"] = source
 	}
 }
 
@@ -1,11 +1,18 @@
 package config
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/adrg/xdg"
+	"github.com/leighmcculloch/silo/preset"
 )
 
 func TestDefaultConfig(t *testing.T) {
@@ -112,6 +119,39 @@ func TestLoadInvalidJSON(t *testing.T) {
 	}
 }
 
+func TestLoadResolvesDockerfilePaths(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "silo.jsonc")
+
+	configContent := `{
+		"dockerfile": "./silo.Dockerfile",
+		"repos": {
+			"github.com/org/a": {"dockerfile": "/abs/a.Dockerfile", "build_stages": {"rust": "stages/rust.Dockerfile"}},
+			"github.com/org/b": {"dockerfile": "~/b.Dockerfile"}
+		}
+	}`
+	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if want := filepath.Join(tmpDir, "silo.Dockerfile"); cfg.Dockerfile != want {
+		t.Errorf("expected dockerfile %s, got %s", want, cfg.Dockerfile)
+	}
+	if got := cfg.Repos["github.com/org/a"].Dockerfile; got != "/abs/a.Dockerfile" {
+		t.Errorf("expected absolute path unchanged, got %s", got)
+	}
+	if got := cfg.Repos["github.com/org/b"].Dockerfile; got != "~/b.Dockerfile" {
+		t.Errorf("expected ~ path unchanged, got %s", got)
+	}
+	if got, want := cfg.Repos["github.com/org/a"].BuildStages["rust"], filepath.Join(tmpDir, "stages/rust.Dockerfile"); got != want {
+		t.Errorf("expected build stage %s, got %s", want, got)
+	}
+}
+
 func TestLoadJSONC(t *testing.T) {
 	tmpDir := t.TempDir()
 	configPath := filepath.Join(tmpDir, "config.json")
@@ -218,6 +258,67 @@ func TestMerge(t *testing.T) {
 	}
 }
 
+func TestMergeProfiles(t *testing.T) {
+	base := Config{Profiles: map[string]RepoConfig{
+		"offline": {Network: "none", Env: []string{"BASE"}},
+		"big":     {Resources: Resources{CPUs: 8}},
+	}}
+	overlay := Config{Profiles: map[string]RepoConfig{
+		"offline": {Env: []string{"OVERLAY"}},
+		"gpu":     {BaseImage: "cuda"},
+	}}
+
+	result := Merge(base, overlay)
+	offline := result.Profiles["offline"]
+	if offline.Network != "none" || !slices.Equal(offline.Env, []string{"BASE", "OVERLAY"}) {
+		t.Errorf("expected profiles of the same name to merge, got %+v", offline)
+	}
+	if result.Profiles["big"].Resources.CPUs != 8 || result.Profiles["gpu"].BaseImage != "cuda" {
+		t.Errorf("expected every profile to be kept, got %+v", result.Profiles)
+	}
+}
+
+func TestDockerInDockerJSON(t *testing.T) {
+	for _, tt := range []struct {
+		json string
+		want DockerInDocker
+	}{
+		{`{"docker_in_docker": true}`, DockerInDockerRootful},
+		{`{"docker_in_docker": false}`, DockerInDockerOff},
+		{`{"docker_in_docker": "rootless"}`, DockerInDockerRootless},
+		{`{}`, ""},
+	} {
+		var cfg Config
+		if err := json.Unmarshal([]byte(tt.json), &cfg); err != nil || cfg.DockerInDocker != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.json, cfg.DockerInDocker, err, tt.want)
+		}
+		data, _ := json.Marshal(cfg)
+		var again Config
+		if err := json.Unmarshal(data, &again); err != nil || again.DockerInDocker != tt.want {
+			t.Errorf("%s: round trip through %s got %q, %v", tt.json, data, again.DockerInDocker, err)
+		}
+	}
+
+	var cfg Config
+	if err := json.Unmarshal([]byte(`{"docker_in_docker": "yes"}`), &cfg); err == nil {
+		t.Error("expected an error for an unknown value")
+	}
+}
+
+func TestMergeToolArgs(t *testing.T) {
+	off := false
+	base := Config{Tools: map[string]ToolConfig{"claude": {Args: []string{"--model", "opus"}, DefaultFlags: &off}}}
+	overlay := Config{Tools: map[string]ToolConfig{"claude": {Args: []string{"--verbose"}}}}
+
+	claude := Merge(base, overlay).Tools["claude"]
+	if !slices.Equal(claude.Args, []string{"--model", "opus", "--verbose"}) {
+		t.Errorf("expected args to be appended, got %q", claude.Args)
+	}
+	if claude.DefaultFlags == nil || *claude.DefaultFlags {
+		t.Error("expected default_flags to be kept when the overlay doesn't set it")
+	}
+}
+
 func TestMergePreRunHooksAppend(t *testing.T) {
 	// Test that pre_run_hooks arrays are appended
 	base := Config{
@@ -249,6 +350,250 @@ func TestMergePreRunHooksAppend(t *testing.T) {
 	}
 }
 
+func TestMergeResources(t *testing.T) {
+	base := Config{
+		Resources: Resources{CPUs: 2, Memory: "4g"},
+		Tools: map[string]ToolConfig{
+			"claude": {Resources: Resources{PidsLimit: 512}},
+		},
+	}
+	overlay := Config{
+		Resources: Resources{Memory: "8g", Swap: "1g", Nofile: 4096},
+		Tools: map[string]ToolConfig{
+			"claude": {Resources: Resources{CPUs: 4, InotifyWatches: 65536}},
+		},
+	}
+
+	result := Merge(base, overlay)
+
+	want := Resources{CPUs: 2, Memory: "8g", Swap: "1g", Nofile: 4096}
+	if result.Resources != want {
+		t.Errorf("expected resources %+v, got %+v", want, result.Resources)
+	}
+	wantTool := Resources{CPUs: 4, PidsLimit: 512, InotifyWatches: 65536}
+	if got := result.Tools["claude"].Resources; got != wantTool {
+		t.Errorf("expected claude resources %+v, got %+v", wantTool, got)
+	}
+}
+
+func TestMergeNetwork(t *testing.T) {
+	base := Config{
+		Network:      "allowlist",
+		NetworkAllow: []string{"github.com"},
+	}
+	overlay := Config{
+		NetworkAllow: []string{"api.anthropic.com"},
+		Repos: map[string]RepoConfig{
+			"github.com/org": {Network: "none"},
+		},
+	}
+
+	result := Merge(base, overlay)
+
+	if result.Network != "allowlist" {
+		t.Errorf("expected network allowlist, got %q", result.Network)
+	}
+	if len(result.NetworkAllow) != 2 {
+		t.Errorf("expected 2 allowed hosts, got %v", result.NetworkAllow)
+	}
+	if result.Repos["github.com/org"].Network != "none" {
+		t.Errorf("expected repo network none, got %q", result.Repos["github.com/org"].Network)
+	}
+
+	result = Merge(result, Config{Network: "full"})
+	if result.Network != "full" {
+		t.Errorf("expected overlay network to replace base, got %q", result.Network)
+	}
+}
+
+func TestMergeBranchSandbox(t *testing.T) {
+	on, off := true, false
+	if result := Merge(Config{BranchSandbox: &on}, Config{}); result.BranchSandbox == nil || !*result.BranchSandbox {
+		t.Errorf("expected branch_sandbox kept when the overlay doesn't set it, got %v", result.BranchSandbox)
+	}
+	if result := Merge(Config{BranchSandbox: &on}, Config{BranchSandbox: &off}); result.BranchSandbox == nil || *result.BranchSandbox {
+		t.Errorf("expected an overlay setting false to turn branch_sandbox off, got %v", result.BranchSandbox)
+	}
+}
+
+func TestMergeSSHAgent(t *testing.T) {
+	on, off := true, false
+	base := Config{SSHAgent: &on, Repos: map[string]RepoConfig{"github.com/org": {SSHAgent: &on}}}
+
+	result := Merge(base, Config{})
+	if result.SSHAgent == nil || !*result.SSHAgent {
+		t.Errorf("expected ssh_agent kept when the overlay doesn't set it, got %v", result.SSHAgent)
+	}
+	result = Merge(result, Config{SSHAgent: &off, Repos: map[string]RepoConfig{"github.com/org": {Network: "none"}}})
+	if result.SSHAgent == nil || *result.SSHAgent {
+		t.Errorf("expected an overlay setting false to turn ssh_agent off, got %v", result.SSHAgent)
+	}
+	if rc := result.Repos["github.com/org"]; rc.SSHAgent == nil || !*rc.SSHAgent {
+		t.Errorf("expected the repo's ssh_agent kept, got %v", rc.SSHAgent)
+	}
+}
+
+func TestMergeCommitSigning(t *testing.T) {
+	base := Config{CommitSigning: "gpg", Repos: map[string]RepoConfig{"github.com/org": {CommitSigning: "ssh"}}}
+	result := Merge(base, Config{CommitSigning: "off", Repos: map[string]RepoConfig{"github.com/org": {Network: "none"}}})
+	if result.CommitSigning != "off" {
+		t.Errorf("expected the overlay's commit_signing, got %q", result.CommitSigning)
+	}
+	if rc := result.Repos["github.com/org"]; rc.CommitSigning != "ssh" {
+		t.Errorf("expected the repo's commit_signing kept, got %q", rc.CommitSigning)
+	}
+}
+
+func TestMergeSecrets(t *testing.T) {
+	base := Config{
+		Secrets: map[string]Secret{
+			"A": {Op: "op://vault/a/credential"},
+			"B": {Keychain: "b"},
+		},
+	}
+	overlay := Config{
+		Secrets: map[string]Secret{
+			"B": {Pass: "b"},
+			"C": {Command: "echo c"},
+		},
+	}
+
+	result := Merge(base, overlay)
+
+	if len(result.Secrets) != 3 {
+		t.Fatalf("expected 3 secrets, got %v", result.Secrets)
+	}
+	if result.Secrets["B"] != (Secret{Pass: "b"}) {
+		t.Errorf("expected overlay secret B to replace base, got %+v", result.Secrets["B"])
+	}
+	if len(base.Secrets) != 2 {
+		t.Errorf("expected base secrets to be unmodified, got %v", base.Secrets)
+	}
+}
+
+func TestMergeServices(t *testing.T) {
+	base := Config{
+		Services: map[string]Service{"postgres": {Image: "postgres:15"}},
+		Repos:    map[string]RepoConfig{"github.com/org": {Services: map[string]Service{"redis": {Image: "redis:7"}}}},
+	}
+	overlay := Config{
+		Services: map[string]Service{"postgres": {Image: "postgres:16"}, "redis": {Image: "redis:7"}},
+		Repos:    map[string]RepoConfig{"github.com/org": {Services: map[string]Service{"minio": {Image: "minio/minio"}}}},
+	}
+
+	result := Merge(base, overlay)
+
+	if len(result.Services) != 2 || result.Services["postgres"].Image != "postgres:16" {
+		t.Errorf("expected overlay service postgres to replace base, got %v", result.Services)
+	}
+	if got := result.Repos["github.com/org"].Services; len(got) != 2 {
+		t.Errorf("expected the repo's services merged, got %v", got)
+	}
+	if base.Services["postgres"].Image != "postgres:15" {
+		t.Errorf("expected base services to be unmodified, got %v", base.Services)
+	}
+}
+
+func TestExpandHookRefs(t *testing.T) {
+	cfg := Config{
+		PreRunHooks:     []string{"echo hi", "@setup"},
+		PostBuildHooks:  []string{"@install-go"},
+		HookDefinitions: map[string]string{"setup": "make setup"},
+		Repos: map[string]RepoConfig{
+			"github.com/org": {PostBuildHooks: []string{"@install-rust", "@setup"}},
+		},
+	}
+
+	result, err := ExpandHookRefs(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"echo hi", "make setup"}; !slices.Equal(result.PreRunHooks, want) {
+		t.Errorf("expected pre_run_hooks %q, got %q", want, result.PreRunHooks)
+	}
+	if want := []string{BuiltinHooks["install-go"]}; !slices.Equal(result.PostBuildHooks, want) {
+		t.Errorf("expected the built-in hook, got %q", result.PostBuildHooks)
+	}
+	if want := []string{BuiltinHooks["install-rust"], "make setup"}; !slices.Equal(result.Repos["github.com/org"].PostBuildHooks, want) {
+		t.Errorf("expected repo hooks %q, got %q", want, result.Repos["github.com/org"].PostBuildHooks)
+	}
+	if cfg.Repos["github.com/org"].PostBuildHooks[0] != "@install-rust" {
+		t.Errorf("expected cfg to be unmodified, got %q", cfg.Repos["github.com/org"].PostBuildHooks)
+	}
+
+	// Definitions replace the built-in hooks of the same name
+	cfg.HookDefinitions["install-go"] = "true"
+	if result, _ := ExpandHookRefs(cfg); result.PostBuildHooks[0] != "true" {
+		t.Errorf("expected the definition to replace the built-in hook, got %q", result.PostBuildHooks)
+	}
+
+	cfg.Repos["github.com/org"] = RepoConfig{PreRunHooks: []string{"@missing"}}
+	if _, err := ExpandHookRefs(cfg); err == nil || !strings.Contains(err.Error(), "repos.github.com/org.pre_run_hooks") {
+		t.Errorf("expected an error naming the undefined hook's place, got %v", err)
+	}
+}
+
+func TestMergeNotify(t *testing.T) {
+	base := Config{Notify: Notify{Enabled: true, Idle: "1m", Patterns: []string{"Proceed?"}}}
+	overlay := Config{Notify: Notify{Idle: "10s", Patterns: []string{"Done"}, Command: "say done"}}
+
+	result := Merge(base, overlay).Notify
+
+	if !result.Enabled || result.Idle != "10s" || result.Command != "say done" {
+		t.Errorf("expected overlay fields to replace base, got %+v", result)
+	}
+	if !slices.Equal(result.Patterns, []string{"Proceed?", "Done"}) {
+		t.Errorf("expected patterns appended, got %q", result.Patterns)
+	}
+}
+
+func TestMergeStopPolicy(t *testing.T) {
+	base := Config{Stop: StopPolicy{Signal: "SIGINT", GracePeriod: "1m", Confirm: true}}
+	overlay := Config{Stop: StopPolicy{GracePeriod: "5s"}}
+
+	result := Merge(base, overlay).Stop
+
+	if result != (StopPolicy{Signal: "SIGINT", GracePeriod: "5s", Confirm: true}) {
+		t.Errorf("expected overlay fields to replace base, got %+v", result)
+	}
+}
+
+func TestMergeTimeouts(t *testing.T) {
+	base := Config{Timeouts: Timeouts{Build: "30m", Connect: "1m"}}
+	overlay := Config{Timeouts: Timeouts{Connect: "0", MountWait: "30s"}}
+
+	result := Merge(base, overlay).Timeouts
+
+	if result != (Timeouts{Build: "30m", MountWait: "30s", Connect: "0"}) {
+		t.Errorf("expected overlay fields to replace base, got %+v", result)
+	}
+}
+
+func TestMergeContainerUser(t *testing.T) {
+	base := Config{ContainerUser: ContainerUser{Name: "agent", UID: 1000}}
+	overlay := Config{ContainerUser: ContainerUser{UID: 2000, GID: 2000}, Userns: "host"}
+
+	result := Merge(base, overlay)
+
+	if result.ContainerUser != (ContainerUser{Name: "agent", UID: 2000, GID: 2000}) || result.Userns != "host" {
+		t.Errorf("expected overlay fields to replace base, got %+v, %q", result.ContainerUser, result.Userns)
+	}
+}
+
+func TestMergeMCPServers(t *testing.T) {
+	base := Config{MCPServers: map[string]MCPServer{"github": {Command: "github-mcp-server"}}}
+	overlay := Config{MCPServers: map[string]MCPServer{"github": {URL: "https://api.githubcopilot.com/mcp/"}, "docs": {URL: "https://example.com/mcp"}}}
+
+	result := Merge(base, overlay)
+
+	if len(result.MCPServers) != 2 || result.MCPServers["github"].URL == "" {
+		t.Errorf("expected overlay server github to replace base, got %v", result.MCPServers)
+	}
+	if base.MCPServers["github"].Command == "" || len(base.MCPServers) != 1 {
+		t.Errorf("expected base servers to be unmodified, got %v", base.MCPServers)
+	}
+}
+
 func TestMergeWithNilTools(t *testing.T) {
 	base := Config{
 		MountsRW: []string{"/base"},
@@ -335,3 +680,192 @@ func TestLoadAll(t *testing.T) {
 		t.Error("expected local mount /local to be present")
 	}
 }
+
+func TestLoadAllConfigSearch(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmpDir, ".config"))
+	xdg.Reload()
+	t.Cleanup(xdg.Reload)
+	t.Cleanup(Reload)
+
+	repoDir := filepath.Join(tmpDir, "code", "repo")
+	subDir := filepath.Join(repoDir, "sub")
+	for _, d := range []string{filepath.Join(tmpDir, ".config", "silo"), filepath.Join(repoDir, ".git"), subDir} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range map[string]string{
+		filepath.Join(tmpDir, "code", "silo.jsonc"): `{"mounts_ro": ["/code"]}`,
+		filepath.Join(repoDir, "silo.jsonc"):        `{"mounts_ro": ["/repo"]}`,
+		filepath.Join(subDir, "silo.jsonc"):         `{"mounts_ro": ["/sub"]}`,
+	} {
+		if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(subDir)
+
+	Reload()
+	if got, want := LoadAll(nil).MountsRO, []string{"/repo", "/sub"}; !slices.Equal(got, want) {
+		t.Errorf("MountsRO = %v, want the search to stop at the repository root %v", got, want)
+	}
+	paths := GetConfigPaths()
+	if got := paths[1].Path; got != filepath.Join(repoDir, "silo.jsonc") {
+		t.Errorf("first local config path = %q, want the repository root's", got)
+	}
+
+	global := filepath.Join(tmpDir, ".config", "silo", "silo.jsonc")
+	if err := os.WriteFile(global, []byte(`{"config_search": "filesystem"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := LoadAll(nil).MountsRO; len(got) != 2 {
+		t.Errorf("MountsRO = %v, want the files read before to be reused until Reload", got)
+	}
+	Reload()
+	if got, want := LoadAll(nil).MountsRO, []string{"/code", "/repo", "/sub"}; !slices.Equal(got, want) {
+		t.Errorf("MountsRO = %v, want the search to go up past the repository %v", got, want)
+	}
+}
+
+func TestLoadAllPresets(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmpDir, ".config"))
+	t.Setenv("XDG_DATA_HOME", filepath.Join(tmpDir, ".local", "share"))
+	xdg.Reload()
+	t.Cleanup(xdg.Reload)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"tool": "opencode", "mounts_ro": ["/preset"], "presets": ["other"]}`))
+	}))
+	defer srv.Close()
+	if _, err := preset.Add(context.Background(), srv.URL+"/dev.jsonc", preset.AddOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	projectDir := filepath.Join(tmpDir, "project")
+	if err := os.MkdirAll(projectDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	localConfig := `{"presets": ["dev", "missing"], "tool": "claude", "mounts_ro": ["/local"]}`
+	if err := os.WriteFile(filepath.Join(projectDir, "silo.jsonc"), []byte(localConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	oldWd, _ := os.Getwd()
+	defer os.Chdir(oldWd)
+	os.Chdir(projectDir)
+
+	cfg, sources := LoadAllWithSources(nil)
+	if !slices.Equal(cfg.MountsRO, []string{"/preset", "/local"}) {
+		t.Errorf("expected preset mounts before local mounts, got %v", cfg.MountsRO)
+	}
+	if cfg.Tool != "claude" {
+		t.Errorf("expected config file to override preset tool, got %q", cfg.Tool)
+	}
+	if sources.MountsRO["/preset"] != preset.Path("dev") {
+		t.Errorf("expected /preset sourced from the preset, got %q", sources.MountsRO["/preset"])
+	}
+	if slices.Contains(cfg.Presets, "other") {
+		t.Error("expected presets listed in a preset to be ignored")
+	}
+}
+
+func TestLoadAllFragments(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmpDir, ".config"))
+	xdg.Reload()
+	t.Cleanup(xdg.Reload)
+
+	projectDir := filepath.Join(tmpDir, "project")
+	fragmentDir := filepath.Join(projectDir, ".silo")
+	if err := os.MkdirAll(filepath.Join(fragmentDir, "dir.jsonc"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{
+		filepath.Join(projectDir, "silo.jsonc"):  `{"tool": "claude", "mounts_ro": ["/local"]}`,
+		filepath.Join(fragmentDir, "team.jsonc"): `{"mounts_ro": ["/team"], "tool": "opencode"}`,
+		filepath.Join(fragmentDir, "me.jsonc"):   `{"mounts_ro": ["/me"]}`,
+		filepath.Join(fragmentDir, "notes.txt"):  `{"mounts_ro": ["/ignored"]}`,
+	} {
+		if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(projectDir)
+
+	cfg, sources := LoadAllWithSources(nil)
+	if want := []string{"/local", "/me", "/team"}; !slices.Equal(cfg.MountsRO, want) {
+		t.Errorf("MountsRO = %v, want %v", cfg.MountsRO, want)
+	}
+	if cfg.Tool != "opencode" {
+		t.Errorf("expected fragments to override silo.jsonc, got tool %q", cfg.Tool)
+	}
+	if got := sources.MountsRO["/team"]; got != filepath.Join(fragmentDir, "team.jsonc") {
+		t.Errorf("expected /team sourced from its fragment, got %q", got)
+	}
+
+	var paths []string
+	for _, p := range GetConfigPaths() {
+		if p.Exists {
+			paths = append(paths, p.Path)
+		}
+	}
+	want := []string{filepath.Join(projectDir, "silo.jsonc"), filepath.Join(fragmentDir, "me.jsonc"), filepath.Join(fragmentDir, "team.jsonc")}
+	if !slices.Equal(paths, want) {
+		t.Errorf("GetConfigPaths = %v, want %v", paths, want)
+	}
+}
+
+func TestLoadAllGlobalOnly(t *testing.T) {
+	tmpDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmpDir, ".config"))
+	xdg.Reload()
+	t.Cleanup(xdg.Reload)
+
+	projectDir := filepath.Join(tmpDir, "project")
+	if err := os.MkdirAll(filepath.Dir(GlobalConfigPath()), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(projectDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	localPath := filepath.Join(projectDir, "silo.jsonc")
+	for name, data := range map[string]string{
+		GlobalConfigPath(): `{"container_user": {"uid": 1000}, "hook_definitions": {"log": "echo global"}, "post_run_hooks": ["@log"]}`,
+		localPath:          `{"container_user": {"name": "agent", "uid": 2000}, "hook_definitions": {"log": "echo local"}, "post_run_hooks": ["echo post"], "notify": {"command": "echo notify"}, "secrets": {"TOKEN": {"command": "cat ~/.token"}}, "build_secrets": {"npm": {"pass": "npm"}}, "tools": {"claude": {"on_failure_hooks": ["echo fail"]}}, "repos": {"github.com/org": {"secrets": {"NPM_TOKEN": {"keychain": "npm"}}, "build_secrets": {"npm_token": {"command": "cat ~/.npmrc"}}}}}`,
+	} {
+		if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Chdir(projectDir)
+
+	cfg := LoadAll(nil)
+	if want := (ContainerUser{Name: "agent", UID: 1000}); cfg.ContainerUser != want {
+		t.Errorf("ContainerUser = %+v, want %+v", cfg.ContainerUser, want)
+	}
+	want := []string{"post_run_hooks", "secrets", "build_secrets", "tools.claude.on_failure_hooks", "repos.github.com/org.secrets", "repos.github.com/org.build_secrets", "notify.command", "container_user.uid"}
+	for i := range want {
+		want[i] += " (" + localPath + ")"
+	}
+	if !slices.Equal(cfg.Ignored, want) {
+		t.Errorf("Ignored = %q, want %q", cfg.Ignored, want)
+	}
+
+	expanded, err := ExpandHookRefs(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"echo global"}; !slices.Equal(expanded.PostRunHooks, want) {
+		t.Errorf("PostRunHooks = %q, want %q", expanded.PostRunHooks, want)
+	}
+	if len(cfg.Secrets) != 0 || len(cfg.Repos["github.com/org"].Secrets) != 0 {
+		t.Errorf("secrets = %v, repo secrets %v, want none", cfg.Secrets, cfg.Repos["github.com/org"].Secrets)
+	}
+	if len(cfg.Repos["github.com/org"].BuildSecrets) != 0 {
+		t.Errorf("repo build secrets = %v, want none", cfg.Repos["github.com/org"].BuildSecrets)
+	}
+	if len(expanded.Tools["claude"].OnFailureHooks) != 0 {
+		t.Errorf("tools.claude.on_failure_hooks = %q, want none", expanded.Tools["claude"].OnFailureHooks)
+	}
+}
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// BuiltinHooks are the hooks silo ships, referenced as "@name" like those of
+// hook_definitions. Each is a single shell command, in a subshell so it
+// chains with && like any other, that does nothing if what it sets up is
+// already there, so it works as a post-build hook, with a
+// custom dockerfile or base_image, and as a pre-run hook. Tools are
+// installed where the default image has them, which is on its PATH.
+var BuiltinHooks = map[string]string{
+	"install-go": `(command -v go >/dev/null || (mkdir -p ~/.local && curl -fsSL "https://go.dev/dl/$(curl -fsSL 'https://go.dev/VERSION?m=text' | head -1).linux-$(dpkg --print-architecture).tar.gz" | tar -C ~/.local -xz))`,
+
+	"install-node": `(command -v node >/dev/null || (V=$(curl -fsSL https://nodejs.org/dist/index.json | jq -r '.[0].version') && A=$(dpkg --print-architecture | sed 's/amd64/x64/') && mkdir -p ~/.local && curl -fsSL "https://nodejs.org/dist/$V/node-$V-linux-$A.tar.xz" | tar -C ~/.local -xJ && rm -rf ~/.local/node && mv ~/.local/node-$V-linux-$A ~/.local/node))`,
+
+	"install-rust": `(command -v cargo >/dev/null || curl --proto '=https' --tlsv1.2 -sSf https://sh.rustup.rs | sh -s -- -y)`,
+
+	// The store is meant to be a cache volume, e.g.
+	// {"pnpm-store": "~/.cache/pnpm-store"}, so it is shared by sessions
+	"setup-pnpm-cache": `((command -v pnpm >/dev/null || npm install -g pnpm) && pnpm config set store-dir ~/.cache/pnpm-store)`,
+}
+
+// HookRef returns the name of the hook definition hook references, if it is
+// a reference, of the form "@name".
+func HookRef(hook string) (string, bool) {
+	return strings.CutPrefix(hook, "@")
+}
+
+// LookupHook returns the command of the hook definition name, from defs,
+// the hook_definitions of a config, or else from BuiltinHooks.
+func LookupHook(name string, defs map[string]string) (string, bool) {
+	if cmd, ok := defs[name]; ok {
+		return cmd, true
+	}
+	cmd, ok := BuiltinHooks[name]
+	return cmd, ok
+}
+
+// ExpandHooks returns hooks with the references in them replaced by the
+// commands of the hook definitions they name. It fails if a reference
+// names no definition.
+func ExpandHooks(hooks []string, defs map[string]string) ([]string, error) {
+	if !slices.ContainsFunc(hooks, func(h string) bool { _, ok := HookRef(h); return ok }) {
+		return hooks, nil
+	}
+	result := make([]string, 0, len(hooks))
+	for _, hook := range hooks {
+		if name, ok := HookRef(hook); ok {
+			cmd, ok := LookupHook(name, defs)
+			if !ok {
+				return nil, fmt.Errorf("hook %q is not defined in hook_definitions or built in", hook)
+			}
+			hook = cmd
+		}
+		result = append(result, hook)
+	}
+	return result, nil
+}
+
+// ExpandHookRefs returns cfg with the references in its hooks, and in those
+// of its tools, repos, and profiles, replaced by the commands of the hook
+// definitions they name.
+func ExpandHookRefs(cfg Config) (Config, error) {
+	defs := cfg.HookDefinitions
+	// Hooks run on the host can't use definitions from a project's config
+	hostDefs := cfg.hostHookDefinitions
+	if hostDefs == nil {
+		hostDefs = defs
+	}
+	expand := func(what string, preRun, postBuild, postRun, onFailure *[]string) error {
+		for _, h := range []struct {
+			key   string
+			hooks *[]string
+			defs  map[string]string
+		}{
+			{"pre_run_hooks", preRun, defs},
+			{"post_build_hooks", postBuild, defs},
+			{"post_run_hooks", postRun, hostDefs},
+			{"on_failure_hooks", onFailure, hostDefs},
+		} {
+			var err error
+			if *h.hooks, err = ExpandHooks(*h.hooks, h.defs); err != nil {
+				return fmt.Errorf("%s%s: %w", what, h.key, err)
+			}
+		}
+		return nil
+	}
+
+	if err := expand("", &cfg.PreRunHooks, &cfg.PostBuildHooks, &cfg.PostRunHooks, &cfg.OnFailureHooks); err != nil {
+		return Config{}, err
+	}
+	cfg.Tools = maps.Clone(cfg.Tools)
+	for name, t := range cfg.Tools {
+		if err := expand("tools."+name+".", &t.PreRunHooks, &t.PostBuildHooks, &t.PostRunHooks, &t.OnFailureHooks); err != nil {
+			return Config{}, err
+		}
+		cfg.Tools[name] = t
+	}
+	cfg.Repos = maps.Clone(cfg.Repos)
+	for name, r := range cfg.Repos {
+		if err := expand("repos."+name+".", &r.PreRunHooks, &r.PostBuildHooks, &r.PostRunHooks, &r.OnFailureHooks); err != nil {
+			return Config{}, err
+		}
+		cfg.Repos[name] = r
+	}
+	cfg.Profiles = maps.Clone(cfg.Profiles)
+	for name, p := range cfg.Profiles {
+		if err := expand("profiles."+name+".", &p.PreRunHooks, &p.PostBuildHooks, &p.PostRunHooks, &p.OnFailureHooks); err != nil {
+			return Config{}, err
+		}
+		cfg.Profiles[name] = p
+	}
+	return cfg, nil
+}
@@ -0,0 +1,431 @@
+// Package configdoctor looks for settings in the merged config that are
+// valid but unlikely to do what was intended, such as env entries that
+// never match a host variable or hooks that run commands the image doesn't
+// have. It complements configvalidate, which checks each file on its own.
+package configdoctor
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/leighmcculloch/silo/config"
+	"github.com/leighmcculloch/silo/tilde"
+)
+
+// Finding is a setting that likely doesn't do what was intended.
+type Finding struct {
+	// Source is the config file the setting comes from, or "default"
+	Source  string
+	Message string
+}
+
+// String formats the finding as source: message.
+func (f Finding) String() string {
+	if f.Source == "" {
+		return f.Message
+	}
+	return f.Source + ": " + f.Message
+}
+
+// Options configures Check.
+type Options struct {
+	Config  config.Config
+	Sources *config.SourceInfo
+
+	// Files are the config files that were merged, in merge order
+	Files []string
+
+	// Environ is the host environment, as returned by os.Environ
+	Environ []string
+
+	// Home is the host home directory
+	Home string
+
+	// GOOS is the host operating system
+	GOOS string
+
+	// ImageCommands are the commands installed in the embedded image. Hooks
+	// aren't checked when a dockerfile or base_image override is in use.
+	ImageCommands []string
+
+	// DockerInDockerCommands are the commands installed in the embedded
+	// image only with docker_in_docker
+	DockerInDockerCommands []string
+
+	// ReadDir reads a directory, reporting whether silo can access it.
+	// Defaults to os.ReadDir.
+	ReadDir func(name string) ([]fs.DirEntry, error)
+}
+
+// privacyDirs are directories under home that macOS only lets apps read
+// after the user grants permission in System Settings.
+var privacyDirs = []string{"Desktop", "Documents", "Downloads", "Library/Mobile Documents"}
+
+// shellBuiltins are commands provided by the shell hooks run in.
+var shellBuiltins = []string{
+	".", ":", "[", "alias", "break", "case", "cd", "command", "continue", "do",
+	"done", "echo", "elif", "else", "esac", "eval", "exec", "exit", "export",
+	"false", "fi", "for", "function", "if", "local", "printf", "pwd", "read",
+	"readonly", "return", "set", "shift", "source", "test", "then", "trap",
+	"true", "type", "ulimit", "umask", "unset", "until", "wait", "while",
+}
+
+// section is the part of the config env, mounts, and hooks can be set in:
+// the top level, a tool, or a repository.
+type section struct {
+	name                    string
+	env, mountsRO, mountsRW []string
+	preRun, postBuild       []string
+	postRun, onFailure      []string
+	envSrc, roSrc, rwSrc    map[string]string
+	preRunSrc, postBuildSrc map[string]string
+	postRunSrc, onFailSrc   map[string]string
+
+	// customImage is true if the section overrides the dockerfile or
+	// base_image, so the commands in the image are unknown
+	customImage bool
+
+	// dockerInDocker is true if docker_in_docker is on where the section
+	// applies
+	dockerInDocker bool
+}
+
+// dockerInDocker reports whether the docker_in_docker setting d installs
+// Docker.
+func dockerInDocker(d config.DockerInDocker) bool {
+	return d == config.DockerInDockerRootful || d == config.DockerInDockerRootless
+}
+
+// Check returns the findings for the merged config.
+func Check(opts Options) []Finding {
+	if opts.ReadDir == nil {
+		opts.ReadDir = os.ReadDir
+	}
+	cfg, src := opts.Config, opts.Sources
+	if src == nil {
+		src = config.NewSourceInfo()
+	}
+
+	sections := []section{{
+		env: cfg.Env, mountsRO: cfg.MountsRO, mountsRW: cfg.MountsRW,
+		preRun: cfg.PreRunHooks, postBuild: cfg.PostBuildHooks,
+		postRun: cfg.PostRunHooks, onFailure: cfg.OnFailureHooks,
+		envSrc: src.Env, roSrc: src.MountsRO, rwSrc: src.MountsRW,
+		preRunSrc: src.PreRunHooks, postBuildSrc: src.PostBuildHooks,
+		postRunSrc: src.PostRunHooks, onFailSrc: src.OnFailureHooks,
+
+		dockerInDocker: dockerInDocker(cfg.DockerInDocker),
+	}}
+	for _, name := range sortedKeys(cfg.Tools) {
+		t := cfg.Tools[name]
+		sections = append(sections, section{
+			name: "tools." + name,
+			env:  t.Env, mountsRO: t.MountsRO, mountsRW: t.MountsRW,
+			preRun: t.PreRunHooks, postBuild: t.PostBuildHooks,
+			postRun: t.PostRunHooks, onFailure: t.OnFailureHooks,
+			envSrc: src.ToolEnv[name], roSrc: src.ToolMountsRO[name], rwSrc: src.ToolMountsRW[name],
+			preRunSrc: src.ToolPreRunHooks[name], postBuildSrc: src.ToolPostBuildHooks[name],
+			postRunSrc: src.ToolPostRunHooks[name], onFailSrc: src.ToolOnFailureHooks[name],
+
+			dockerInDocker: dockerInDocker(cfg.DockerInDocker),
+		})
+	}
+	for _, name := range sortedKeys(cfg.Repos) {
+		r := cfg.Repos[name]
+		sections = append(sections, section{
+			name: "repos." + name,
+			env:  r.Env, mountsRO: r.MountsRO, mountsRW: r.MountsRW,
+			preRun: r.PreRunHooks, postBuild: r.PostBuildHooks,
+			postRun: r.PostRunHooks, onFailure: r.OnFailureHooks,
+			envSrc: src.RepoEnv[name], roSrc: src.RepoMountsRO[name], rwSrc: src.RepoMountsRW[name],
+			preRunSrc: src.RepoPreRunHooks[name], postBuildSrc: src.RepoPostBuildHooks[name],
+			postRunSrc: src.RepoPostRunHooks[name], onFailSrc: src.RepoOnFailureHooks[name],
+			customImage: r.Dockerfile != "" || r.BaseImage != "",
+
+			dockerInDocker: dockerInDocker(cmp.Or(r.DockerInDocker, cfg.DockerInDocker)),
+		})
+	}
+
+	var findings []Finding
+	for _, s := range sections {
+		findings = append(findings, checkEnv(s, opts.Environ)...)
+		findings = append(findings, checkHookRefs(s, cfg.HookDefinitions)...)
+		if opts.GOOS == "darwin" {
+			findings = append(findings, checkPrivacy(s, opts.Home, opts.ReadDir)...)
+		}
+	}
+
+	// The commands in a custom image are unknown
+	customImage := cfg.Dockerfile != "" || cfg.BaseImage != ""
+	if opts.ImageCommands != nil && !customImage {
+		installed := installedByHooks(sections)
+		for _, s := range sections {
+			if s.customImage {
+				continue
+			}
+			commands := opts.ImageCommands
+			if s.dockerInDocker {
+				commands = slices.Concat(commands, opts.DockerInDockerCommands)
+			}
+			findings = append(findings, checkHooks(s, commands, installed)...)
+		}
+	}
+
+	findings = append(findings, checkToolDefaults(opts.Files)...)
+	return findings
+}
+
+// checkEnv reports passthrough env entries that can't match a host variable
+// because of their case, e.g. "anthropic_api_key".
+func checkEnv(s section, environ []string) []Finding {
+	host := make(map[string]bool)
+	for _, e := range environ {
+		name, _, _ := strings.Cut(e, "=")
+		host[name] = true
+	}
+	var findings []Finding
+	for _, e := range s.env {
+		if strings.Contains(e, "=") || host[e] {
+			continue
+		}
+		var match string
+		for name := range host {
+			if strings.EqualFold(name, e) {
+				match = name
+				break
+			}
+		}
+		switch {
+		case match != "":
+			findings = append(findings, Finding{
+				Source:  s.envSrc[e],
+				Message: fmt.Sprintf("%s is never set: the host has %s (names are case-sensitive)", s.label("env "+e), match),
+			})
+		case e != strings.ToUpper(e):
+			findings = append(findings, Finding{
+				Source:  s.envSrc[e],
+				Message: fmt.Sprintf("%s is not set on the host, and environment variable names are usually upper case", s.label("env "+e)),
+			})
+		}
+	}
+	return findings
+}
+
+// checkPrivacy reports mounts under directories protected by macOS privacy
+// controls that silo hasn't been given access to. Docker can't share them
+// either, so the mount appears empty in the container.
+func checkPrivacy(s section, home string, readDir func(string) ([]fs.DirEntry, error)) []Finding {
+	var findings []Finding
+	check := func(mounts []string, sources map[string]string) {
+		for _, m := range mounts {
+			path := m
+			if rest, ok := strings.CutPrefix(path, "~/"); ok {
+				path = filepath.Join(home, rest)
+			}
+			dir, ok := privacyDir(path, home)
+			if !ok {
+				continue
+			}
+			if _, err := readDir(dir); err != nil && errors.Is(err, fs.ErrPermission) {
+				findings = append(findings, Finding{
+					Source: sources[m],
+					Message: fmt.Sprintf("%s is under %s, which this terminal hasn't been allowed to access; grant access in System Settings > Privacy & Security > Files and Folders",
+						s.label("mount "+m), filepath.Join("~", strings.TrimPrefix(dir, home+"/"))),
+				})
+			}
+		}
+	}
+	check(s.mountsRO, s.roSrc)
+	check(s.mountsRW, s.rwSrc)
+	return findings
+}
+
+// privacyDir returns the protected directory path is in, if any.
+func privacyDir(path, home string) (string, bool) {
+	for _, d := range privacyDirs {
+		dir := filepath.Join(home, d)
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			return dir, true
+		}
+	}
+	return "", false
+}
+
+// checkHooks reports hooks that run commands which aren't in the image and
+// aren't installed by a post-build hook.
+func checkHooks(s section, imageCommands []string, installed map[string]bool) []Finding {
+	var findings []Finding
+	check := func(kind string, hooks []string, sources map[string]string) {
+		for _, hook := range hooks {
+			// References are checked by checkHookRefs
+			if _, ok := config.HookRef(hook); ok {
+				continue
+			}
+			for _, cmd := range hookCommands(hook) {
+				if slices.Contains(imageCommands, cmd) || slices.Contains(shellBuiltins, cmd) || installed[cmd] {
+					continue
+				}
+				findings = append(findings, Finding{
+					Source:  sources[hook],
+					Message: fmt.Sprintf("%s runs %s, which is not installed in the image (install it with a post_build_hooks entry)", s.label(kind+" "+fmt.Sprintf("%q", hook)), cmd),
+				})
+			}
+		}
+	}
+	check("pre_run_hooks", s.preRun, s.preRunSrc)
+	check("post_build_hooks", s.postBuild, s.postBuildSrc)
+	return findings
+}
+
+// checkHookRefs reports hooks that reference a hook definition that doesn't
+// exist.
+func checkHookRefs(s section, defs map[string]string) []Finding {
+	var findings []Finding
+	check := func(kind string, hooks []string, sources map[string]string) {
+		for _, hook := range hooks {
+			name, ok := config.HookRef(hook)
+			if !ok {
+				continue
+			}
+			if _, ok := config.LookupHook(name, defs); !ok {
+				findings = append(findings, Finding{
+					Source:  sources[hook],
+					Message: fmt.Sprintf("%s references a hook that is not defined in hook_definitions or built in", s.label(kind+" "+fmt.Sprintf("%q", hook))),
+				})
+			}
+		}
+	}
+	check("pre_run_hooks", s.preRun, s.preRunSrc)
+	check("post_build_hooks", s.postBuild, s.postBuildSrc)
+	check("post_run_hooks", s.postRun, s.postRunSrc)
+	check("on_failure_hooks", s.onFailure, s.onFailSrc)
+	return findings
+}
+
+// installedByHooks returns the words that follow "install" in post-build
+// hooks, e.g. the packages in "sudo apt-get install -y ripgrep". Commands
+// with those names are assumed to be installed.
+func installedByHooks(sections []section) map[string]bool {
+	installed := make(map[string]bool)
+	for _, s := range sections {
+		for _, hook := range s.postBuild {
+			words := strings.Fields(hook)
+			for i, w := range words {
+				if w != "install" {
+					continue
+				}
+				for _, pkg := range words[i+1:] {
+					if strings.ContainsAny(pkg, ";&|") {
+						break
+					}
+					if strings.HasPrefix(pkg, "-") {
+						continue
+					}
+					// go install and cargo install name packages by path
+					// and version, the command is the last path element
+					pkg, _, _ = strings.Cut(pkg, "@")
+					installed[filepath.Base(pkg)] = true
+				}
+			}
+		}
+	}
+	return installed
+}
+
+// commandPrefixes are words that can come before the command in a simple
+// command.
+var commandPrefixes = []string{"!", "do", "elif", "else", "env", "exec", "if", "sudo", "then", "time", "until", "while"}
+
+// hookCommands returns the commands a hook runs: the first word of each
+// command in a list or pipeline, after any variable assignments, keywords,
+// and sudo. Commands given by path or containing expansions are skipped.
+func hookCommands(hook string) []string {
+	replacer := strings.NewReplacer("&&", "\n", "||", "\n", ";", "\n", "|", "\n", "(", "\n", ")", "\n", "`", "\n")
+	var commands []string
+	for _, part := range strings.Split(replacer.Replace(hook), "\n") {
+		words := strings.Fields(part)
+		// Options of the prefixes, e.g. sudo -E, start with a dash
+		for len(words) > 0 && (strings.Contains(words[0], "=") || strings.HasPrefix(words[0], "-") || slices.Contains(commandPrefixes, words[0])) {
+			words = words[1:]
+		}
+		if len(words) == 0 {
+			continue
+		}
+		cmd := words[0]
+		if strings.ContainsAny(cmd, "/$'\"{}<>*") {
+			continue
+		}
+		if !slices.Contains(commands, cmd) {
+			commands = append(commands, cmd)
+		}
+	}
+	return commands
+}
+
+// checkToolDefaults reports the default tool being set to different values
+// in more than one config file, where only the last takes effect.
+func checkToolDefaults(files []string) []Finding {
+	type setting struct{ file, tool string }
+	var global []setting
+	repos := make(map[string][]setting)
+	for _, f := range files {
+		cfg, err := config.Load(f)
+		if err != nil {
+			continue
+		}
+		if cfg.Tool != "" {
+			global = append(global, setting{f, cfg.Tool})
+		}
+		for name, r := range cfg.Repos {
+			if r.Tool != "" {
+				repos[name] = append(repos[name], setting{f, r.Tool})
+			}
+		}
+	}
+
+	var findings []Finding
+	report := func(key string, settings []setting) {
+		last := settings[len(settings)-1]
+		for _, s := range settings[:len(settings)-1] {
+			if s.tool != last.tool {
+				findings = append(findings, Finding{
+					Source:  s.file,
+					Message: fmt.Sprintf("%s %q is overridden by %q in %s", key, s.tool, last.tool, tilde.Path(last.file)),
+				})
+			}
+		}
+	}
+	if len(global) > 1 {
+		report("tool", global)
+	}
+	for _, name := range sortedKeys(repos) {
+		if len(repos[name]) > 1 {
+			report("repos."+name+".tool", repos[name])
+		}
+	}
+	return findings
+}
+
+// label prefixes what with the section it is in.
+func (s section) label(what string) string {
+	if s.name == "" {
+		return what
+	}
+	return s.name + " " + what
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
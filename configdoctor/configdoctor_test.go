@@ -0,0 +1,175 @@
+package configdoctor
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/leighmcculloch/silo/config"
+)
+
+func messages(findings []Finding) []string {
+	var msgs []string
+	for _, f := range findings {
+		msgs = append(msgs, f.String())
+	}
+	return msgs
+}
+
+func TestCheckEnv(t *testing.T) {
+	sources := config.NewSourceInfo()
+	sources.Env["anthropic_api_key"] = "a.jsonc"
+	findings := Check(Options{
+		Config: config.Config{
+			Env: []string{"anthropic_api_key", "my_token", "UNSET_TOKEN", "lower=value", "HOME"},
+		},
+		Sources: sources,
+		Environ: []string{"ANTHROPIC_API_KEY=x", "HOME=/home/u"},
+	})
+	got := messages(findings)
+	want := []string{
+		"a.jsonc: env anthropic_api_key is never set: the host has ANTHROPIC_API_KEY (names are case-sensitive)",
+		"env my_token is not set on the host, and environment variable names are usually upper case",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCheckPrivacy(t *testing.T) {
+	readDir := func(name string) ([]fs.DirEntry, error) {
+		if name == "/Users/u/Documents" {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+		}
+		return nil, nil
+	}
+	cfg := config.Config{
+		MountsRO: []string{"~/Documents/notes", "~/Desktop/x", "/Users/u/code"},
+		Tools: map[string]config.ToolConfig{
+			"claude": {MountsRW: []string{"/Users/u/Documents"}},
+		},
+	}
+
+	findings := Check(Options{Config: cfg, Home: "/Users/u", GOOS: "darwin", ReadDir: readDir})
+	if len(findings) != 2 {
+		t.Fatalf("expected 2 findings, got %q", messages(findings))
+	}
+	if !strings.HasPrefix(findings[0].Message, "mount ~/Documents/notes is under ~/Documents") {
+		t.Errorf("unexpected finding: %s", findings[0])
+	}
+	if !strings.HasPrefix(findings[1].Message, "tools.claude mount /Users/u/Documents is under ~/Documents") {
+		t.Errorf("unexpected finding: %s", findings[1])
+	}
+
+	// Only macOS has these controls
+	if findings := Check(Options{Config: cfg, Home: "/Users/u", GOOS: "linux", ReadDir: readDir}); len(findings) != 0 {
+		t.Errorf("expected no findings on linux, got %q", messages(findings))
+	}
+}
+
+func TestCheckHooks(t *testing.T) {
+	image := []string{"git", "apt-get", "go"}
+	cfg := config.Config{
+		PreRunHooks:    []string{"git fetch && rg --version", "FOO=1 sudo make build | tee log"},
+		PostBuildHooks: []string{"sudo apt-get install -y ripgrep fd-find", "go install golang.org/x/tools/cmd/stringer@latest"},
+		Repos: map[string]config.RepoConfig{
+			"example.com/custom": {BaseImage: "custom", PreRunHooks: []string{"custom-tool"}},
+			"example.com/plain":  {PreRunHooks: []string{"stringer -h", "if true; then missing-tool; fi"}},
+		},
+	}
+	got := messages(Check(Options{Config: cfg, ImageCommands: image}))
+	want := []string{
+		`pre_run_hooks "git fetch && rg --version" runs rg, which is not installed in the image (install it with a post_build_hooks entry)`,
+		`pre_run_hooks "FOO=1 sudo make build | tee log" runs make, which is not installed in the image (install it with a post_build_hooks entry)`,
+		`pre_run_hooks "FOO=1 sudo make build | tee log" runs tee, which is not installed in the image (install it with a post_build_hooks entry)`,
+		`repos.example.com/plain pre_run_hooks "if true; then missing-tool; fi" runs missing-tool, which is not installed in the image (install it with a post_build_hooks entry)`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Docker is only installed with docker_in_docker
+	cfg = config.Config{
+		PreRunHooks: []string{"docker info"},
+		Repos: map[string]config.RepoConfig{
+			"example.com/dind": {DockerInDocker: config.DockerInDockerRootless, PreRunHooks: []string{"docker ps"}},
+		},
+	}
+	got = messages(Check(Options{Config: cfg, ImageCommands: image, DockerInDockerCommands: []string{"docker"}}))
+	want = []string{
+		`pre_run_hooks "docker info" runs docker, which is not installed in the image (install it with a post_build_hooks entry)`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// A custom image may have anything installed
+	cfg.BaseImage = "custom"
+	if findings := Check(Options{Config: cfg, ImageCommands: image}); len(findings) != 0 {
+		t.Errorf("expected no findings with a custom image, got %q", messages(findings))
+	}
+}
+
+func TestCheckHookRefs(t *testing.T) {
+	cfg := config.Config{
+		PreRunHooks:     []string{"@install-go", "@mine", "@missing"},
+		HookDefinitions: map[string]string{"mine": "true"},
+		Tools: map[string]config.ToolConfig{
+			"claude": {PostBuildHooks: []string{"@also-missing"}},
+		},
+	}
+	got := messages(Check(Options{Config: cfg, ImageCommands: []string{"true"}}))
+	want := []string{
+		`pre_run_hooks "@missing" references a hook that is not defined in hook_definitions or built in`,
+		`tools.claude post_build_hooks "@also-missing" references a hook that is not defined in hook_definitions or built in`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestHookCommands(t *testing.T) {
+	tests := []struct {
+		hook string
+		want []string
+	}{
+		{"echo hi", []string{"echo"}},
+		{"cd /x && make; ./run.sh || true", []string{"cd", "make", "true"}},
+		{"A=1 B=2 env C=3 sudo -E npm ci", []string{"npm"}},
+		{"$EDITOR file", nil},
+		{"(cd x && go build)", []string{"cd", "go"}},
+	}
+	for _, tt := range tests {
+		if got := hookCommands(tt.hook); !slices.Equal(got, tt.want) {
+			t.Errorf("hookCommands(%q) = %q, want %q", tt.hook, got, tt.want)
+		}
+	}
+}
+
+func TestCheckToolDefaults(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	global := write("global.jsonc", `{"tool": "opencode", "repos": {"org/a": {"tool": "claude"}}}`)
+	same := write("same.jsonc", `{"tool": "claude"}`)
+	local := write("local.jsonc", `{"tool": "claude", "repos": {"org/a": {"tool": "copilot"}}}`)
+
+	findings := Check(Options{Files: []string{global, same, local}})
+	if len(findings) != 2 {
+		t.Fatalf("expected 2 findings, got %q", messages(findings))
+	}
+	if findings[0].Source != global || !strings.HasPrefix(findings[0].Message, `tool "opencode" is overridden by "claude" in `) {
+		t.Errorf("unexpected finding: %s", findings[0])
+	}
+	if findings[1].Source != global || !strings.HasPrefix(findings[1].Message, `repos.org/a.tool "claude" is overridden by "copilot" in `) {
+		t.Errorf("unexpected finding: %s", findings[1])
+	}
+}
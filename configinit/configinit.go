@@ -0,0 +1,96 @@
+package configinit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/tidwall/jsonc"
+)
+
+// commentedKeyRegex matches a commented-out top-level setting such as
+// `// "backend": "docker",` and captures the key.
+var commentedKeyRegex = regexp.MustCompile(`^\s*//\s*"([^"]+)"\s*:`)
+
+// Section is a commented template for one top-level setting in the sample
+// config, including the explanatory comments that precede it.
+type Section struct {
+	Key   string
+	Lines []string
+}
+
+// Sections splits the sample config into its commented sections. Each section
+// ends with the commented-out setting for its key. Uncommented lines, such as
+// the opening brace and "$schema", are not part of any section.
+func Sections(sample string) []Section {
+	var sections []Section
+	var pending []string
+	for _, line := range strings.Split(sample, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "//") {
+			pending = nil
+			continue
+		}
+		pending = append(pending, line)
+		if m := commentedKeyRegex.FindStringSubmatch(line); m != nil {
+			sections = append(sections, Section{Key: m[1], Lines: pending})
+			pending = nil
+		}
+	}
+	return sections
+}
+
+// Missing returns the sections whose key is neither set nor present as a
+// commented-out setting in the existing config, so that merging the same
+// sample twice doesn't duplicate anything.
+func Missing(existing []byte, sections []Section) ([]Section, error) {
+	var values map[string]json.RawMessage
+	if err := json.Unmarshal(jsonc.ToJSON(existing), &values); err != nil {
+		return nil, fmt.Errorf("failed to parse existing config: %w", err)
+	}
+
+	present := map[string]bool{}
+	for key := range values {
+		present[key] = true
+	}
+	for _, line := range strings.Split(string(existing), "\n") {
+		if m := commentedKeyRegex.FindStringSubmatch(line); m != nil {
+			present[m[1]] = true
+		}
+	}
+
+	var missing []Section
+	for _, s := range sections {
+		if !present[s.Key] {
+			missing = append(missing, s)
+		}
+	}
+	return missing, nil
+}
+
+// Append inserts the sections as comments before the closing brace of the
+// existing config. Everything else in the file, including comments and
+// formatting, is left untouched. Because the sections are comments the
+// result stays valid JSONC regardless of trailing commas.
+func Append(existing []byte, sections []Section) ([]byte, error) {
+	end := bytes.LastIndexByte(existing, '}')
+	if end < 0 {
+		return nil, fmt.Errorf("existing config has no closing brace")
+	}
+	if len(sections) == 0 {
+		return existing, nil
+	}
+
+	var buf bytes.Buffer
+	buf.Write(bytes.TrimRight(existing[:end], " \t\r\n"))
+	buf.WriteString("\n")
+	for _, s := range sections {
+		for _, line := range s.Lines {
+			buf.WriteString(line)
+			buf.WriteString("\n")
+		}
+	}
+	buf.Write(existing[end:])
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,106 @@
+package configinit
+
+import (
+	"strings"
+	"testing"
+)
+
+const sample = `{
+  "$schema": "https://example.com/silo.schema.json",
+  // Backend to use
+  // "backend": "docker",
+  // Environment variables
+  // Example: "env": ["FOO=bar"]
+  // "env": [],
+  // Tool-specific configuration
+  // "tools": {}
+}
+`
+
+func TestSections(t *testing.T) {
+	sections := Sections(sample)
+
+	var keys []string
+	for _, s := range sections {
+		keys = append(keys, s.Key)
+	}
+	if got := strings.Join(keys, ","); got != "backend,env,tools" {
+		t.Fatalf("expected keys backend,env,tools, got %s", got)
+	}
+	if len(sections[1].Lines) != 3 {
+		t.Errorf("expected env section to have 3 lines, got %v", sections[1].Lines)
+	}
+}
+
+func TestMissing(t *testing.T) {
+	existing := []byte(`{
+  // my settings
+  "backend": "docker",
+  // "tools": {},
+}`)
+
+	missing, err := Missing(existing, Sections(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 1 || missing[0].Key != "env" {
+		t.Errorf("expected only env to be missing, got %v", missing)
+	}
+}
+
+func TestMissingInvalid(t *testing.T) {
+	if _, err := Missing([]byte(`{"backend":`), Sections(sample)); err == nil {
+		t.Error("expected error for invalid config")
+	}
+}
+
+func TestAppend(t *testing.T) {
+	existing := []byte(`{
+  // my settings
+  "backend": "docker"
+}
+`)
+
+	missing, err := Missing(existing, Sections(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, err := Append(existing, missing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `{
+  // my settings
+  "backend": "docker"
+  // Environment variables
+  // Example: "env": ["FOO=bar"]
+  // "env": [],
+  // Tool-specific configuration
+  // "tools": {}
+}
+`
+	if string(merged) != want {
+		t.Errorf("unexpected merge result:\n%s", merged)
+	}
+
+	// Merging again finds nothing missing.
+	missing, err = Missing(merged, Sections(sample))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 0 {
+		t.Errorf("expected nothing missing after merge, got %v", missing)
+	}
+}
+
+func TestAppendEmptyObject(t *testing.T) {
+	merged, err := Append([]byte("{}"), Sections(sample)[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\n  // Backend to use\n  // \"backend\": \"docker\",\n}"
+	if string(merged) != want {
+		t.Errorf("expected %q, got %q", want, merged)
+	}
+}
@@ -1,12 +1,16 @@
 package configshow
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/charmbracelet/lipgloss"
+	"github.com/leighmcculloch/silo/cli"
 	"github.com/leighmcculloch/silo/config"
 	"github.com/leighmcculloch/silo/tilde"
 )
@@ -45,6 +49,256 @@ func (w *writer) nullableString(indent, name, value, source string, comma bool)
 	}
 }
 
+// rawField writes a JSON field whose value is already rendered (numbers, null, booleans).
+func (w *writer) rawField(indent, name, raw, source string, comma bool) {
+	fmt.Fprintf(w.w, "%s%s: %s%s\n", indent, w.key(name), raw, w.suffix(source, comma))
+}
+
+// nullableInt writes an integer field, or null if it is zero.
+func (w *writer) nullableInt(indent, name string, v int64, source string, comma bool) {
+	if v == 0 {
+		w.rawField(indent, name, "null", source, comma)
+		return
+	}
+	w.rawField(indent, name, strconv.FormatInt(v, 10), source, comma)
+}
+
+// resources writes a resources object. Unset fields are shown as null.
+func (w *writer) resources(indent string, r config.Resources, sources map[string]string, comma bool) {
+	src := func(field string) string { return def(sources[field], "default") }
+	w.openObject(indent, "resources")
+	inner := indent + "  "
+	if r.CPUs != 0 {
+		w.rawField(inner, "cpus", strconv.FormatFloat(r.CPUs, 'g', -1, 64), src("cpus"), true)
+	} else {
+		w.rawField(inner, "cpus", "null", src("cpus"), true)
+	}
+	w.nullableString(inner, "memory", r.Memory, src("memory"), true)
+	w.nullableString(inner, "swap", r.Swap, src("swap"), true)
+	w.nullableInt(inner, "pids_limit", r.PidsLimit, src("pids_limit"), true)
+	w.nullableInt(inner, "nofile", r.Nofile, src("nofile"), true)
+	w.nullableInt(inner, "inotify_watches", r.InotifyWatches, src("inotify_watches"), false)
+	w.closeObject(indent, comma)
+}
+
+// notify writes a notify object. Unset fields are shown with their defaults.
+func (w *writer) notify(indent string, n config.Notify, sources map[string]string, comma bool) {
+	src := func(field string) string { return def(sources[field], "default") }
+	w.openObject(indent, "notify")
+	inner := indent + "  "
+	w.rawField(inner, "enabled", strconv.FormatBool(n.Enabled), src("enabled"), true)
+	w.stringField(inner, "idle", def(n.Idle, "30s"), src("idle"), true)
+	var patternSources map[string]string
+	if sources != nil {
+		patternSources = make(map[string]string)
+		for _, p := range n.Patterns {
+			patternSources[p] = sources["patterns"]
+		}
+	}
+	w.array(inner, "patterns", n.Patterns, patternSources, true)
+	w.nullableString(inner, "command", n.Command, src("command"), false)
+	w.closeObject(indent, comma)
+}
+
+// stop writes a stop object. Unset fields are shown with their defaults.
+func (w *writer) stop(indent string, s config.StopPolicy, sources map[string]string, comma bool) {
+	src := func(field string) string { return def(sources[field], "default") }
+	w.openObject(indent, "stop")
+	inner := indent + "  "
+	w.stringField(inner, "signal", def(s.Signal, "SIGTERM"), src("signal"), true)
+	w.stringField(inner, "grace_period", def(s.GracePeriod, "10s"), src("grace_period"), true)
+	w.rawField(inner, "confirm", strconv.FormatBool(s.Confirm), src("confirm"), false)
+	w.closeObject(indent, comma)
+}
+
+// timeouts writes a timeouts object. Unset fields are shown with their
+// defaults, build as null as it has no limit by default.
+func (w *writer) timeouts(indent string, t config.Timeouts, sources map[string]string, comma bool) {
+	src := func(field string) string { return def(sources[field], "default") }
+	w.openObject(indent, "timeouts")
+	inner := indent + "  "
+	w.nullableString(inner, "build", t.Build, src("build"), true)
+	w.stringField(inner, "mount_wait", def(t.MountWait, "10s"), src("mount_wait"), true)
+	w.stringField(inner, "connect", def(t.Connect, "10s"), src("connect"), false)
+	w.closeObject(indent, comma)
+}
+
+// containerUser writes a container_user object. Unset fields, the host
+// user's, are shown as null.
+func (w *writer) containerUser(indent string, u config.ContainerUser, sources map[string]string, comma bool) {
+	src := func(field string) string { return def(sources[field], "default") }
+	w.openObject(indent, "container_user")
+	inner := indent + "  "
+	w.nullableString(inner, "name", u.Name, src("name"), true)
+	w.nullableInt(inner, "uid", int64(u.UID), src("uid"), true)
+	w.nullableInt(inner, "gid", int64(u.GID), src("gid"), false)
+	w.closeObject(indent, comma)
+}
+
+// gitIdentity writes a git_identity object. Unset fields are shown as null.
+func (w *writer) gitIdentity(indent string, g config.GitIdentity, sources map[string]string, comma bool) {
+	src := func(field string) string { return def(sources[field], "default") }
+	w.openObject(indent, "git_identity")
+	inner := indent + "  "
+	w.nullableString(inner, "name", g.Name, src("name"), true)
+	w.nullableString(inner, "email", g.Email, src("email"), true)
+	w.rawField(inner, "anonymous", strconv.FormatBool(g.Anonymous), src("anonymous"), false)
+	w.closeObject(indent, comma)
+}
+
+// secrets writes an object of secrets with one line per secret. The references
+// are shown, never the values.
+func (w *writer) secrets(indent, name string, s map[string]config.Secret, sources map[string]string, comma bool) {
+	c := ""
+	if comma {
+		c = ","
+	}
+	if len(s) == 0 {
+		fmt.Fprintf(w.w, "%s%s: {}%s\n", indent, w.key(name), c)
+		return
+	}
+	w.openObject(indent, name)
+	keys := sortedKeys(s)
+	for i, k := range keys {
+		kind, ref := "command", s[k].Command
+		switch {
+		case s[k].Keychain != "":
+			kind, ref = "keychain", s[k].Keychain
+		case s[k].Op != "":
+			kind, ref = "op", s[k].Op
+		case s[k].Pass != "":
+			kind, ref = "pass", s[k].Pass
+		}
+		src := ""
+		if sources != nil {
+			src = sources[k]
+		}
+		w.rawField(indent+"  ", k, "{ "+w.key(kind)+": "+w.str(ref)+" }", src, i < len(keys)-1)
+	}
+	w.closeObject(indent, comma)
+}
+
+// services writes a services object with one line per service.
+func (w *writer) services(indent string, s map[string]config.Service, sources map[string]string, comma bool) {
+	c := ""
+	if comma {
+		c = ","
+	}
+	if len(s) == 0 {
+		fmt.Fprintf(w.w, "%s%s: {}%s\n", indent, w.key("services"), c)
+		return
+	}
+	strs := func(values []string) string {
+		quoted := make([]string, len(values))
+		for i, v := range values {
+			quoted[i] = w.str(v)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	}
+	w.openObject(indent, "services")
+	names := sortedKeys(s)
+	for i, name := range names {
+		svc := s[name]
+		fields := []string{w.key("image") + ": " + w.str(svc.Image)}
+		if len(svc.Ports) > 0 {
+			ports := make([]string, len(svc.Ports))
+			for j, p := range svc.Ports {
+				ports[j] = strconv.Itoa(p)
+			}
+			fields = append(fields, w.key("ports")+": ["+strings.Join(ports, ", ")+"]")
+		}
+		if len(svc.Env) > 0 {
+			fields = append(fields, w.key("env")+": "+strs(svc.Env))
+		}
+		if svc.Healthcheck != "" {
+			fields = append(fields, w.key("healthcheck")+": "+w.str(svc.Healthcheck))
+		}
+		if len(svc.ToolEnv) > 0 {
+			fields = append(fields, w.key("tool_env")+": "+strs(svc.ToolEnv))
+		}
+		src := ""
+		if sources != nil {
+			src = sources[name]
+		}
+		w.rawField(indent+"  ", name, "{ "+strings.Join(fields, ", ")+" }", src, i < len(names)-1)
+	}
+	w.closeObject(indent, comma)
+}
+
+// mcpServers writes an mcp_servers object with one line per server.
+func (w *writer) mcpServers(indent string, s map[string]config.MCPServer, sources map[string]string, comma bool) {
+	c := ""
+	if comma {
+		c = ","
+	}
+	if len(s) == 0 {
+		fmt.Fprintf(w.w, "%s%s: {}%s\n", indent, w.key("mcp_servers"), c)
+		return
+	}
+	strs := func(values []string) string {
+		quoted := make([]string, len(values))
+		for i, v := range values {
+			quoted[i] = w.str(v)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	}
+	object := func(m map[string]string) string {
+		var fields []string
+		for _, k := range sortedKeys(m) {
+			fields = append(fields, w.key(k)+": "+w.str(m[k]))
+		}
+		return "{ " + strings.Join(fields, ", ") + " }"
+	}
+	w.openObject(indent, "mcp_servers")
+	names := sortedKeys(s)
+	for i, name := range names {
+		srv := s[name]
+		var fields []string
+		if srv.Command != "" {
+			fields = append(fields, w.key("command")+": "+w.str(srv.Command))
+		}
+		if len(srv.Args) > 0 {
+			fields = append(fields, w.key("args")+": "+strs(srv.Args))
+		}
+		if len(srv.Env) > 0 {
+			fields = append(fields, w.key("env")+": "+object(srv.Env))
+		}
+		if srv.URL != "" {
+			fields = append(fields, w.key("url")+": "+w.str(srv.URL))
+		}
+		if len(srv.Headers) > 0 {
+			fields = append(fields, w.key("headers")+": "+object(srv.Headers))
+		}
+		if len(srv.Mounts) > 0 {
+			fields = append(fields, w.key("mounts")+": "+strs(srv.Mounts))
+		}
+		src := ""
+		if sources != nil {
+			src = sources[name]
+		}
+		w.rawField(indent+"  ", name, "{ "+strings.Join(fields, ", ")+" }", src, i < len(names)-1)
+	}
+	w.closeObject(indent, comma)
+}
+
+// stringMap writes an object of string values with one line per key.
+func (w *writer) stringMap(indent, name string, m map[string]string, sources map[string]string, comma bool) {
+	c := ""
+	if comma {
+		c = ","
+	}
+	if len(m) == 0 {
+		fmt.Fprintf(w.w, "%s%s: {}%s\n", indent, w.key(name), c)
+		return
+	}
+	w.openObject(indent, name)
+	keys := sortedKeys(m)
+	for i, k := range keys {
+		w.stringField(indent+"  ", k, m[k], sources[k], i < len(keys)-1)
+	}
+	w.closeObject(indent, comma)
+}
+
 // array writes a JSON array field with optional per-element source comments.
 func (w *writer) array(indent, name string, values []string, sources map[string]string, comma bool) {
 	fmt.Fprintf(w.w, "%s%s: [\n", indent, w.key(name))
@@ -62,6 +316,142 @@ func (w *writer) array(indent, name string, values []string, sources map[string]
 	fmt.Fprintf(w.w, "%s]%s\n", indent, c)
 }
 
+// repoSources are the sources of the fields of a repo or profile config.
+// Maps are keyed like the fields of SourceInfo of the same name.
+type repoSources struct {
+	tool, dockerfile, baseImage, network, networkJoin, profile, dockerInDocker, sshAgent, readOnlyRootfs string
+	seccomp, appArmor, commitSigning                                                                     string
+
+	mountsRO, mountsRW, workspaces, env, networkAllow, ports, tmpfs     map[string]string
+	preRunHooks, postBuildHooks, postRunHooks, onFailureHooks           map[string]string
+	resources, gitIdentity, cacheVolumes, secrets, services, mcpServers map[string]string
+	buildSecrets, buildStages                                           map[string]string
+}
+
+// sameSource returns repoSources with every field set in rc from source.
+func sameSource(rc config.RepoConfig, source string) repoSources {
+	set := func(v string) string {
+		if v == "" {
+			return ""
+		}
+		return source
+	}
+	each := func(keys ...[]string) map[string]string {
+		m := make(map[string]string)
+		for _, k := range slices.Concat(keys...) {
+			m[k] = source
+		}
+		return m
+	}
+	values := each(rc.MountsRO, rc.MountsRW, rc.ExtraWorkspaces, rc.Env, rc.PreRunHooks, rc.PostBuildHooks, rc.PostRunHooks, rc.OnFailureHooks, rc.NetworkAllow, rc.Ports, rc.Tmpfs)
+	resources := map[string]string{"memory": set(rc.Resources.Memory), "swap": set(rc.Resources.Swap)}
+	if rc.Resources.CPUs != 0 {
+		resources["cpus"] = source
+	}
+	if rc.Resources.PidsLimit != 0 {
+		resources["pids_limit"] = source
+	}
+	gitIdentity := map[string]string{"name": set(rc.GitIdentity.Name), "email": set(rc.GitIdentity.Email)}
+	if rc.GitIdentity.Anonymous {
+		gitIdentity["anonymous"] = source
+	}
+	sshAgent := ""
+	if rc.SSHAgent != nil {
+		sshAgent = source
+	}
+	readOnlyRootfs := ""
+	if rc.ReadOnlyRootfs != nil {
+		readOnlyRootfs = source
+	}
+	return repoSources{
+		tool:           set(rc.Tool),
+		dockerfile:     set(rc.Dockerfile),
+		baseImage:      set(rc.BaseImage),
+		network:        set(rc.Network),
+		networkJoin:    set(rc.NetworkJoin),
+		profile:        set(rc.Profile),
+		dockerInDocker: set(string(rc.DockerInDocker)),
+		sshAgent:       sshAgent,
+		readOnlyRootfs: readOnlyRootfs,
+		seccomp:        set(rc.SeccompProfile),
+		appArmor:       set(rc.AppArmorProfile),
+		commitSigning:  set(rc.CommitSigning),
+		mountsRO:       values,
+		mountsRW:       values,
+		workspaces:     values,
+		env:            values,
+		preRunHooks:    values,
+		postBuildHooks: values,
+		postRunHooks:   values,
+		onFailureHooks: values,
+		networkAllow:   values,
+		ports:          values,
+		tmpfs:          values,
+		resources:      resources,
+		gitIdentity:    gitIdentity,
+		cacheVolumes:   each(sortedKeys(rc.CacheVolumes)),
+		secrets:        each(sortedKeys(rc.Secrets)),
+		buildSecrets:   each(sortedKeys(rc.BuildSecrets)),
+		buildStages:    each(sortedKeys(rc.BuildStages)),
+		services:       each(sortedKeys(rc.Services)),
+		mcpServers:     each(sortedKeys(rc.MCPServers)),
+	}
+}
+
+// optionalBool renders a bool that may be unset as it is written in
+// config, or unset if it isn't set.
+func optionalBool(b *bool, unset string) string {
+	if b == nil {
+		return unset
+	}
+	return strconv.FormatBool(*b)
+}
+
+// dockerInDocker renders a docker_in_docker value as it is written in
+// config, or unset if it isn't set.
+func dockerInDocker(d config.DockerInDocker, unset string) string {
+	if d == "" {
+		return unset
+	}
+	data, _ := json.Marshal(d)
+	return string(data)
+}
+
+// repoConfig writes the fields of a repo or profile config.
+func (w *writer) repoConfig(indent string, rc config.RepoConfig, src repoSources) {
+	w.nullableString(indent, "tool", rc.Tool, def(src.tool, "default"), true)
+	w.nullableString(indent, "dockerfile", rc.Dockerfile, def(src.dockerfile, "default"), true)
+	w.nullableString(indent, "base_image", rc.BaseImage, def(src.baseImage, "default"), true)
+	w.stringMap(indent, "build_stages", rc.BuildStages, src.buildStages, true)
+	w.array(indent, "mounts_ro", rc.MountsRO, src.mountsRO, true)
+	w.array(indent, "mounts_rw", rc.MountsRW, src.mountsRW, true)
+	w.array(indent, "extra_workspaces", rc.ExtraWorkspaces, src.workspaces, true)
+	w.array(indent, "env", rc.Env, src.env, true)
+	w.array(indent, "pre_run_hooks", rc.PreRunHooks, src.preRunHooks, true)
+	w.array(indent, "post_build_hooks", rc.PostBuildHooks, src.postBuildHooks, true)
+	w.array(indent, "post_run_hooks", rc.PostRunHooks, src.postRunHooks, true)
+	w.array(indent, "on_failure_hooks", rc.OnFailureHooks, src.onFailureHooks, true)
+	w.resources(indent, rc.Resources, src.resources, true)
+	w.nullableString(indent, "network", rc.Network, def(src.network, "default"), true)
+	w.array(indent, "network_allow", rc.NetworkAllow, src.networkAllow, true)
+	w.nullableString(indent, "network_join", rc.NetworkJoin, def(src.networkJoin, "default"), true)
+	w.nullableString(indent, "profile", rc.Profile, def(src.profile, "default"), true)
+	w.rawField(indent, "docker_in_docker", dockerInDocker(rc.DockerInDocker, "null"), def(src.dockerInDocker, "default"), true)
+	w.rawField(indent, "ssh_agent", optionalBool(rc.SSHAgent, "null"), def(src.sshAgent, "default"), true)
+	w.rawField(indent, "read_only_rootfs", optionalBool(rc.ReadOnlyRootfs, "null"), def(src.readOnlyRootfs, "default"), true)
+	w.nullableString(indent, "seccomp_profile", rc.SeccompProfile, def(src.seccomp, "default"), true)
+	w.nullableString(indent, "apparmor_profile", rc.AppArmorProfile, def(src.appArmor, "default"), true)
+	w.nullableString(indent, "commit_signing", rc.CommitSigning, def(src.commitSigning, "default"), true)
+	w.gitIdentity(indent, rc.GitIdentity, src.gitIdentity, true)
+	w.array(indent, "ports", rc.Ports, src.ports, true)
+	w.array(indent, "tmpfs", rc.Tmpfs, src.tmpfs, true)
+	w.stringMap(indent, "cache_volumes", rc.CacheVolumes, src.cacheVolumes, true)
+	w.secrets(indent, "secrets", rc.Secrets, src.secrets, true)
+	w.secrets(indent, "build_secrets", rc.BuildSecrets, src.buildSecrets, true)
+	w.services(indent, rc.Services, src.services, true)
+	w.mcpServers(indent, rc.MCPServers, src.mcpServers, false)
+}
+
 // openObject writes the opening of a JSON object field.
 func (w *writer) openObject(indent, name string) {
 	fmt.Fprintf(w.w, "%s%s: {\n", indent, w.key(name))
@@ -86,7 +476,7 @@ func newShowWriter(stdout io.Writer) *writer {
 	keyStyle := lipgloss.NewStyle()
 	stringStyle := lipgloss.NewStyle()
 	commentStyle := lipgloss.NewStyle()
-	if isTTY {
+	if isTTY && !cli.Accessible() {
 		keyStyle = keyStyle.Foreground(lipgloss.Color("6"))         // Cyan
 		stringStyle = stringStyle.Foreground(lipgloss.Color("2"))   // Green
 		commentStyle = commentStyle.Foreground(lipgloss.Color("8")) // Gray
@@ -132,12 +522,61 @@ func Show(stdout io.Writer, toolDefaults map[string]config.ToolConfig) error {
 	fmt.Fprintln(stdout, "{")
 
 	w.stringField("  ", "backend", def(cfg.Backend, "docker"), def(src.Backend, "default"), true)
+	w.array("  ", "backend_fallback", cfg.BackendFallback, src.BackendFallback, true)
+	w.array("  ", "presets", cfg.Presets, src.Presets, true)
 	w.nullableString("  ", "tool", cfg.Tool, def(src.Tool, "default"), true)
+	w.rawField("  ", "a11y", strconv.FormatBool(cfg.A11y), def(src.A11y, "default"), true)
+	w.nullableString("  ", "dockerfile", cfg.Dockerfile, def(src.Dockerfile, "default"), true)
+	w.nullableString("  ", "base_image", cfg.BaseImage, def(src.BaseImage, "default"), true)
+	w.stringMap("  ", "build_stages", cfg.BuildStages, src.BuildStages, true)
+	w.nullableString("  ", "registry", cfg.Registry, def(src.Registry, "default"), true)
+	w.nullableString("  ", "image_registry", cfg.ImageRegistry, def(src.ImageRegistry, "default"), true)
+	w.stringField("  ", "pull_policy", def(cfg.PullPolicy, "never"), def(src.PullPolicy, "default"), true)
+	w.rawField("  ", "reproducible", strconv.FormatBool(cfg.Reproducible), def(src.Reproducible, "default"), true)
+	w.nullableString("  ", "apt_snapshot", cfg.AptSnapshot, def(src.AptSnapshot, "default"), true)
+	w.stringField("  ", "quick_actions_key", def(cfg.QuickActionsKey, `ctrl-\`), def(src.QuickActionsKey, "default"), true)
+	w.notify("  ", cfg.Notify, src.Notify, true)
+	w.stop("  ", cfg.Stop, src.Stop, true)
+	w.timeouts("  ", cfg.Timeouts, src.Timeouts, true)
+	w.nullableString("  ", "container_name", cfg.ContainerName, def(src.ContainerName, "default"), true)
+	w.rawField("  ", "branch_sandbox", optionalBool(cfg.BranchSandbox, "false"), def(src.BranchSandbox, "default"), true)
+	w.array("  ", "encrypt_recipients", cfg.EncryptRecipients, src.EncryptRecipients, true)
+	w.nullableString("  ", "encrypt_identity", cfg.EncryptIdentity, def(src.EncryptIdentity, "default"), true)
+	w.nullableString("  ", "state_dir", cfg.StateDir, def(src.StateDir, "default"), true)
+	w.stringField("  ", "config_search", def(cfg.ConfigSearch, config.SearchRepo), def(src.ConfigSearch, "default"), true)
 	w.array("  ", "mounts_ro", cfg.MountsRO, src.MountsRO, true)
 	w.array("  ", "mounts_rw", cfg.MountsRW, src.MountsRW, true)
+	w.array("  ", "extra_workspaces", cfg.ExtraWorkspaces, src.ExtraWorkspaces, true)
 	w.array("  ", "env", cfg.Env, src.Env, true)
+	w.array("  ", "env_blocklist", cfg.EnvBlocklist, src.EnvBlocklist, true)
+	w.array("  ", "env_redact", cfg.EnvRedact, src.EnvRedact, true)
 	w.array("  ", "post_build_hooks", cfg.PostBuildHooks, src.PostBuildHooks, true)
 	w.array("  ", "pre_run_hooks", cfg.PreRunHooks, src.PreRunHooks, true)
+	w.array("  ", "post_run_hooks", cfg.PostRunHooks, src.PostRunHooks, true)
+	w.array("  ", "on_failure_hooks", cfg.OnFailureHooks, src.OnFailureHooks, true)
+	w.stringMap("  ", "hook_definitions", cfg.HookDefinitions, src.HookDefinitions, true)
+	w.resources("  ", cfg.Resources, src.Resources, true)
+	w.stringField("  ", "network", def(cfg.Network, "full"), def(src.Network, "default"), true)
+	w.array("  ", "network_allow", cfg.NetworkAllow, src.NetworkAllow, true)
+	w.nullableString("  ", "network_join", cfg.NetworkJoin, def(src.NetworkJoin, "default"), true)
+	w.stringField("  ", "profile", def(cfg.Profile, "standard"), def(src.Profile, "default"), true)
+	w.rawField("  ", "docker_in_docker", dockerInDocker(cfg.DockerInDocker, "false"), def(src.DockerInDocker, "default"), true)
+	w.rawField("  ", "ssh_agent", optionalBool(cfg.SSHAgent, "false"), def(src.SSHAgent, "default"), true)
+	w.rawField("  ", "read_only_rootfs", optionalBool(cfg.ReadOnlyRootfs, "false"), def(src.ReadOnlyRootfs, "default"), true)
+	w.stringField("  ", "seccomp_profile", def(cfg.SeccompProfile, "silo"), def(src.SeccompProfile, "default"), true)
+	w.nullableString("  ", "apparmor_profile", cfg.AppArmorProfile, def(src.AppArmorProfile, "default"), true)
+	w.stringField("  ", "commit_signing", def(cfg.CommitSigning, "off"), def(src.CommitSigning, "default"), true)
+	w.nullableString("  ", "selinux_label", cfg.SELinuxLabel, def(src.SELinuxLabel, "default"), true)
+	w.containerUser("  ", cfg.ContainerUser, src.ContainerUser, true)
+	w.nullableString("  ", "userns", cfg.Userns, def(src.Userns, "default"), true)
+	w.nullableString("  ", "platform", cfg.Platform, def(src.Platform, "default"), true)
+	w.array("  ", "ports", cfg.Ports, src.Ports, true)
+	w.array("  ", "tmpfs", cfg.Tmpfs, src.Tmpfs, true)
+	w.stringMap("  ", "cache_volumes", cfg.CacheVolumes, src.CacheVolumes, true)
+	w.secrets("  ", "secrets", cfg.Secrets, src.Secrets, true)
+	w.secrets("  ", "build_secrets", cfg.BuildSecrets, src.BuildSecrets, true)
+	w.services("  ", cfg.Services, src.Services, true)
+	w.mcpServers("  ", cfg.MCPServers, src.MCPServers, true)
 
 	// Tools
 	toolNames := sortedKeys(cfg.Tools)
@@ -147,9 +586,26 @@ func Show(stdout io.Writer, toolDefaults map[string]config.ToolConfig) error {
 		w.openObject("    ", tn)
 		w.array("      ", "mounts_ro", tc.MountsRO, src.ToolMountsRO[tn], true)
 		w.array("      ", "mounts_rw", tc.MountsRW, src.ToolMountsRW[tn], true)
+		w.rawField("      ", "ephemeral", strconv.FormatBool(tc.Ephemeral), def(src.ToolEphemeral[tn], "default"), true)
+		w.array("      ", "sync_back", tc.SyncBack, src.ToolSyncBack[tn], true)
+		w.array("      ", "isolate", tc.Isolate, src.ToolIsolate[tn], true)
+		w.array("      ", "args", tc.Args, src.ToolArgs[tn], true)
+		w.rawField("      ", "default_flags", strconv.FormatBool(tc.DefaultFlags == nil || *tc.DefaultFlags), def(src.ToolDefaultFlags[tn], "default"), true)
 		w.array("      ", "env", tc.Env, src.ToolEnv[tn], true)
 		w.array("      ", "pre_run_hooks", tc.PreRunHooks, src.ToolPreRunHooks[tn], true)
-		w.array("      ", "post_build_hooks", tc.PostBuildHooks, src.ToolPostBuildHooks[tn], false)
+		w.array("      ", "post_build_hooks", tc.PostBuildHooks, src.ToolPostBuildHooks[tn], true)
+		w.stringMap("      ", "build_stages", tc.BuildStages, src.ToolBuildStages[tn], true)
+		w.array("      ", "post_run_hooks", tc.PostRunHooks, src.ToolPostRunHooks[tn], true)
+		w.array("      ", "on_failure_hooks", tc.OnFailureHooks, src.ToolOnFailureHooks[tn], true)
+		w.resources("      ", tc.Resources, src.ToolResources[tn], true)
+		w.nullableString("      ", "network", tc.Network, def(src.ToolNetwork[tn], "default"), true)
+		w.array("      ", "network_allow", tc.NetworkAllow, src.ToolNetworkAllow[tn], true)
+		w.nullableString("      ", "network_join", tc.NetworkJoin, def(src.ToolNetworkJoin[tn], "default"), true)
+		w.nullableString("      ", "platform", tc.Platform, def(src.ToolPlatform[tn], "default"), true)
+		w.array("      ", "ports", tc.Ports, src.ToolPorts[tn], true)
+		w.array("      ", "tmpfs", tc.Tmpfs, src.ToolTmpfs[tn], true)
+		w.stringMap("      ", "cache_volumes", tc.CacheVolumes, src.ToolCacheVolumes[tn], true)
+		w.secrets("      ", "secrets", tc.Secrets, src.ToolSecrets[tn], false)
 		w.closeObject("    ", ti < len(toolNames)-1)
 	}
 	w.closeObject("  ", true)
@@ -158,22 +614,67 @@ func Show(stdout io.Writer, toolDefaults map[string]config.ToolConfig) error {
 	repoNames := sortedKeys(cfg.Repos)
 	w.openObject("  ", "repos")
 	for ri, rn := range repoNames {
-		rc := cfg.Repos[rn]
 		w.openObject("    ", rn)
-		w.nullableString("      ", "tool", rc.Tool, def(src.RepoTool[rn], "default"), true)
-		w.array("      ", "mounts_ro", rc.MountsRO, src.RepoMountsRO[rn], true)
-		w.array("      ", "mounts_rw", rc.MountsRW, src.RepoMountsRW[rn], true)
-		w.array("      ", "env", rc.Env, src.RepoEnv[rn], true)
-		w.array("      ", "pre_run_hooks", rc.PreRunHooks, src.RepoPreRunHooks[rn], true)
-		w.array("      ", "post_build_hooks", rc.PostBuildHooks, src.RepoPostBuildHooks[rn], false)
+		w.repoConfig("      ", cfg.Repos[rn], repoSources{
+			tool:           src.RepoTool[rn],
+			dockerfile:     src.RepoDockerfile[rn],
+			baseImage:      src.RepoBaseImage[rn],
+			mountsRO:       src.RepoMountsRO[rn],
+			mountsRW:       src.RepoMountsRW[rn],
+			workspaces:     src.RepoWorkspaces[rn],
+			env:            src.RepoEnv[rn],
+			preRunHooks:    src.RepoPreRunHooks[rn],
+			postBuildHooks: src.RepoPostBuildHooks[rn],
+			postRunHooks:   src.RepoPostRunHooks[rn],
+			onFailureHooks: src.RepoOnFailureHooks[rn],
+			resources:      src.RepoResources[rn],
+			network:        src.RepoNetwork[rn],
+			networkAllow:   src.RepoNetworkAllow[rn],
+			networkJoin:    src.RepoNetworkJoin[rn],
+			profile:        src.RepoProfile[rn],
+			dockerInDocker: src.RepoDockerInDocker[rn],
+			sshAgent:       src.RepoSSHAgent[rn],
+			readOnlyRootfs: src.RepoReadOnlyRootfs[rn],
+			seccomp:        src.RepoSeccompProfile[rn],
+			appArmor:       src.RepoAppArmor[rn],
+			commitSigning:  src.RepoCommitSigning[rn],
+			gitIdentity:    src.RepoGitIdentity[rn],
+			ports:          src.RepoPorts[rn],
+			tmpfs:          src.RepoTmpfs[rn],
+			cacheVolumes:   src.RepoCacheVolumes[rn],
+			secrets:        src.RepoSecrets[rn],
+			buildSecrets:   src.RepoBuildSecrets[rn],
+			buildStages:    src.RepoBuildStages[rn],
+			services:       src.RepoServices[rn],
+			mcpServers:     src.RepoMCPServers[rn],
+		})
 		w.closeObject("    ", ri < len(repoNames)-1)
 	}
+	w.closeObject("  ", true)
+
+	// Profiles, whose sources are tracked per profile rather than per value
+	profileNames := sortedKeys(cfg.Profiles)
+	w.openObject("  ", "profiles")
+	for pi, pn := range profileNames {
+		w.openObject("    ", pn)
+		w.repoConfig("      ", cfg.Profiles[pn], sameSource(cfg.Profiles[pn], src.Profiles[pn]))
+		w.closeObject("    ", pi < len(profileNames)-1)
+	}
 	w.closeObject("  ", false)
 
 	fmt.Fprintln(stdout, "}")
 	return nil
 }
 
+// ShowJSON outputs the current merged configuration as JSON, without source
+// comments or highlighting, for consumption by scripts.
+func ShowJSON(stdout io.Writer, toolDefaults map[string]config.ToolConfig) error {
+	cfg, _ := config.LoadAllWithSources(toolDefaults)
+	enc := json.NewEncoder(stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(cfg)
+}
+
 // Default outputs the default configuration as JSON.
 func Default(stdout io.Writer, toolDefaults map[string]config.ToolConfig) error {
 	cfg := config.DefaultConfig(toolDefaults)
@@ -181,11 +682,58 @@ func Default(stdout io.Writer, toolDefaults map[string]config.ToolConfig) error
 
 	fmt.Fprintln(stdout, "{")
 
+	w.rawField("  ", "a11y", strconv.FormatBool(cfg.A11y), "", true)
+	w.nullableString("  ", "dockerfile", cfg.Dockerfile, "", true)
+	w.nullableString("  ", "base_image", cfg.BaseImage, "", true)
+	w.stringMap("  ", "build_stages", cfg.BuildStages, nil, true)
+	w.nullableString("  ", "registry", cfg.Registry, "", true)
+	w.nullableString("  ", "image_registry", "", "", true)
+	w.stringField("  ", "pull_policy", "never", "", true)
+	w.rawField("  ", "reproducible", strconv.FormatBool(cfg.Reproducible), "", true)
+	w.nullableString("  ", "apt_snapshot", cfg.AptSnapshot, "", true)
+	w.stringField("  ", "quick_actions_key", `ctrl-\`, "", true)
+	w.notify("  ", config.Notify{}, nil, true)
+	w.stop("  ", config.StopPolicy{}, nil, true)
+	w.timeouts("  ", config.Timeouts{}, nil, true)
+	w.nullableString("  ", "container_name", "", "", true)
+	w.rawField("  ", "branch_sandbox", "false", "", true)
+	w.array("  ", "encrypt_recipients", cfg.EncryptRecipients, nil, true)
+	w.nullableString("  ", "encrypt_identity", cfg.EncryptIdentity, "", true)
+	w.nullableString("  ", "state_dir", cfg.StateDir, "", true)
+	w.stringField("  ", "config_search", config.SearchRepo, "", true)
 	w.array("  ", "mounts_ro", cfg.MountsRO, nil, true)
 	w.array("  ", "mounts_rw", cfg.MountsRW, nil, true)
+	w.array("  ", "extra_workspaces", cfg.ExtraWorkspaces, nil, true)
 	w.array("  ", "env", cfg.Env, nil, true)
+	w.array("  ", "env_blocklist", cfg.EnvBlocklist, nil, true)
+	w.array("  ", "env_redact", cfg.EnvRedact, nil, true)
 	w.array("  ", "post_build_hooks", cfg.PostBuildHooks, nil, true)
 	w.array("  ", "pre_run_hooks", cfg.PreRunHooks, nil, true)
+	w.array("  ", "post_run_hooks", cfg.PostRunHooks, nil, true)
+	w.array("  ", "on_failure_hooks", cfg.OnFailureHooks, nil, true)
+	w.stringMap("  ", "hook_definitions", cfg.HookDefinitions, nil, true)
+	w.resources("  ", cfg.Resources, nil, true)
+	w.stringField("  ", "network", def(cfg.Network, "full"), "", true)
+	w.array("  ", "network_allow", cfg.NetworkAllow, nil, true)
+	w.nullableString("  ", "network_join", cfg.NetworkJoin, "", true)
+	w.stringField("  ", "profile", def(cfg.Profile, "standard"), "", true)
+	w.rawField("  ", "docker_in_docker", "false", "", true)
+	w.rawField("  ", "ssh_agent", "false", "", true)
+	w.rawField("  ", "read_only_rootfs", "false", "", true)
+	w.stringField("  ", "seccomp_profile", "silo", "", true)
+	w.nullableString("  ", "apparmor_profile", "", "", true)
+	w.stringField("  ", "commit_signing", "off", "", true)
+	w.nullableString("  ", "selinux_label", "", "", true)
+	w.containerUser("  ", config.ContainerUser{}, nil, true)
+	w.nullableString("  ", "userns", "", "", true)
+	w.nullableString("  ", "platform", cfg.Platform, "", true)
+	w.array("  ", "ports", cfg.Ports, nil, true)
+	w.array("  ", "tmpfs", cfg.Tmpfs, nil, true)
+	w.stringMap("  ", "cache_volumes", cfg.CacheVolumes, nil, true)
+	w.secrets("  ", "secrets", cfg.Secrets, nil, true)
+	w.secrets("  ", "build_secrets", cfg.BuildSecrets, nil, true)
+	w.services("  ", cfg.Services, nil, true)
+	w.mcpServers("  ", cfg.MCPServers, nil, true)
 
 	// Tools
 	toolNames := sortedKeys(cfg.Tools)
@@ -195,15 +743,33 @@ func Default(stdout io.Writer, toolDefaults map[string]config.ToolConfig) error
 		w.openObject("    ", tn)
 		w.array("      ", "mounts_ro", tc.MountsRO, nil, true)
 		w.array("      ", "mounts_rw", tc.MountsRW, nil, true)
+		w.rawField("      ", "ephemeral", strconv.FormatBool(tc.Ephemeral), "", true)
+		w.array("      ", "sync_back", tc.SyncBack, nil, true)
+		w.array("      ", "isolate", tc.Isolate, nil, true)
+		w.array("      ", "args", tc.Args, nil, true)
+		w.rawField("      ", "default_flags", "true", "", true)
 		w.array("      ", "env", tc.Env, nil, true)
 		w.array("      ", "pre_run_hooks", tc.PreRunHooks, nil, true)
-		w.array("      ", "post_build_hooks", tc.PostBuildHooks, nil, false)
+		w.array("      ", "post_build_hooks", tc.PostBuildHooks, nil, true)
+		w.stringMap("      ", "build_stages", tc.BuildStages, nil, true)
+		w.array("      ", "post_run_hooks", tc.PostRunHooks, nil, true)
+		w.array("      ", "on_failure_hooks", tc.OnFailureHooks, nil, true)
+		w.resources("      ", tc.Resources, nil, true)
+		w.nullableString("      ", "network", tc.Network, "", true)
+		w.array("      ", "network_allow", tc.NetworkAllow, nil, true)
+		w.nullableString("      ", "network_join", tc.NetworkJoin, "", true)
+		w.nullableString("      ", "platform", tc.Platform, "", true)
+		w.array("      ", "ports", tc.Ports, nil, true)
+		w.array("      ", "tmpfs", tc.Tmpfs, nil, true)
+		w.stringMap("      ", "cache_volumes", tc.CacheVolumes, nil, true)
+		w.secrets("      ", "secrets", tc.Secrets, nil, false)
 		w.closeObject("    ", ti < len(toolNames)-1)
 	}
 	w.closeObject("  ", true)
 
-	// Repos (empty by default)
-	fmt.Fprintf(stdout, "  %s: {}\n", w.key("repos"))
+	// Repos and profiles (empty by default)
+	fmt.Fprintf(stdout, "  %s: {},\n", w.key("repos"))
+	fmt.Fprintf(stdout, "  %s: {}\n", w.key("profiles"))
 
 	fmt.Fprintln(stdout, "}")
 	return nil
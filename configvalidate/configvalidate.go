@@ -0,0 +1,314 @@
+// Package configvalidate checks silo config files for mistakes, reporting
+// each with its position in the file. Loading a config is lenient (unknown
+// keys are ignored, invalid files are skipped), so mistakes otherwise go
+// unnoticed.
+package configvalidate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/leighmcculloch/silo/config"
+	"github.com/leighmcculloch/silo/preset"
+	"github.com/leighmcculloch/silo/tmpl"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"github.com/tidwall/jsonc"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+// Problem is a mistake found in a config file.
+type Problem struct {
+	Path    string
+	Line    int
+	Column  int
+	Message string
+
+	// Warning is true if silo still runs with the config as is, e.g. a
+	// mount path that doesn't exist is skipped.
+	Warning bool
+}
+
+// String formats the problem as path:line:column: message.
+func (p Problem) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", p.Path, p.Line, p.Column, p.Message)
+}
+
+// envNameRegex matches valid environment variable names.
+var envNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Validator checks config files against the config JSON schema and the
+// tools silo supports.
+type Validator struct {
+	schema *jsonschema.Schema
+	tools  []string
+}
+
+// New returns a Validator for the JSON schema and tool names.
+func New(schema []byte, tools []string) (*Validator, error) {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schema))
+	if err != nil {
+		return nil, fmt.Errorf("invalid config schema: %w", err)
+	}
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource("silo.schema.json", doc); err != nil {
+		return nil, fmt.Errorf("invalid config schema: %w", err)
+	}
+	s, err := c.Compile("silo.schema.json")
+	if err != nil {
+		return nil, fmt.Errorf("invalid config schema: %w", err)
+	}
+	return &Validator{schema: s, tools: tools}, nil
+}
+
+// File reads and validates the config file at path.
+func (v *Validator) File(path string) ([]Problem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return v.Data(path, data), nil
+}
+
+// Data validates the contents of a config file. path is only used to
+// report problems.
+func (v *Validator) Data(path string, data []byte) []Problem {
+	// Comments are replaced by whitespace, so offsets in the JSON are
+	// offsets in the original file
+	jsonData := jsonc.ToJSON(data)
+	problem := func(offset int, warning bool, format string, args ...any) Problem {
+		line, col := position(data, offset)
+		return Problem{Path: path, Line: line, Column: col, Message: fmt.Sprintf(format, args...), Warning: warning}
+	}
+
+	inst, err := jsonschema.UnmarshalJSON(bytes.NewReader(jsonData))
+	if err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return []Problem{problem(int(syntaxErr.Offset), false, "invalid JSON: %v", syntaxErr)}
+		}
+		return []Problem{problem(0, false, "invalid JSON: %v", err)}
+	}
+	offsets := valueOffsets(jsonData)
+	at := func(location ...string) int {
+		return offsets[pointer(location)]
+	}
+
+	var problems []Problem
+	if err := v.schema.Validate(inst); err != nil {
+		var verr *jsonschema.ValidationError
+		if !errors.As(err, &verr) {
+			return []Problem{problem(0, false, "%v", err)}
+		}
+		printer := message.NewPrinter(language.English)
+		for _, e := range leafErrors(verr) {
+			if ap, ok := e.ErrorKind.(*kind.AdditionalProperties); ok {
+				for _, prop := range ap.Properties {
+					problems = append(problems, problem(at(append(slices.Clone(e.InstanceLocation), prop)...), false, "unknown key %q", prop))
+				}
+				continue
+			}
+			msg := e.ErrorKind.LocalizedString(printer)
+			if len(e.InstanceLocation) > 0 {
+				msg = strings.Join(e.InstanceLocation, ".") + ": " + msg
+			}
+			problems = append(problems, problem(at(e.InstanceLocation...), false, "%s", msg))
+		}
+	}
+
+	root, _ := inst.(map[string]any)
+	for i, name := range stringsAt(root, "presets") {
+		if err := preset.Verify(name); err != nil {
+			problems = append(problems, problem(at("presets", strconv.Itoa(i)), false, "%v", err))
+		}
+	}
+	if s, ok := root["container_name"].(string); ok {
+		if err := tmpl.Check("container_name", s); err != nil {
+			problems = append(problems, problem(at("container_name"), false, "%v", err))
+		}
+	}
+	for name := range objectAt(root, "tools") {
+		if !slices.Contains(v.tools, name) {
+			problems = append(problems, problem(at("tools", name), false, "unknown tool %q (valid: %s)", name, strings.Join(v.tools, ", ")))
+		}
+	}
+
+	// Env and mounts can be set globally, per tool, per repository, and per
+	// profile
+	sections := [][]string{nil}
+	for name := range objectAt(root, "tools") {
+		sections = append(sections, []string{"tools", name})
+	}
+	for name := range objectAt(root, "repos") {
+		sections = append(sections, []string{"repos", name})
+	}
+	for name := range objectAt(root, "profiles") {
+		sections = append(sections, []string{"profiles", name})
+	}
+	for _, section := range sections {
+		obj := root
+		for _, key := range section {
+			obj = objectAt(obj, key)
+		}
+		for i, entry := range stringsAt(obj, "env") {
+			name, _, _ := strings.Cut(entry, "=")
+			if !envNameRegex.MatchString(name) {
+				problems = append(problems, problem(at(append(section, "env", strconv.Itoa(i))...), false, "invalid env entry %q: must be NAME or NAME=value", entry))
+			}
+		}
+		for _, key := range []string{"mounts_ro", "mounts_rw"} {
+			for i, mount := range stringsAt(obj, key) {
+				expanded, ok := expandMount(mount)
+				if !ok {
+					continue
+				}
+				if _, err := os.Lstat(expanded); err != nil {
+					problems = append(problems, problem(at(append(section, key, strconv.Itoa(i))...), true, "mount path %s does not exist and will be skipped", mount))
+				}
+			}
+		}
+	}
+
+	// Catch anything the checks above miss that stops the file loading
+	if len(problems) == 0 {
+		var cfg config.Config
+		if err := json.Unmarshal(jsonData, &cfg); err != nil {
+			problems = append(problems, problem(0, false, "%v", err))
+		}
+	}
+
+	slices.SortStableFunc(problems, func(a, b Problem) int {
+		if a.Line != b.Line {
+			return a.Line - b.Line
+		}
+		return a.Column - b.Column
+	})
+	return problems
+}
+
+// leafErrors returns the errors in the tree rooted at err that have no causes.
+func leafErrors(err *jsonschema.ValidationError) []*jsonschema.ValidationError {
+	if len(err.Causes) == 0 {
+		return []*jsonschema.ValidationError{err}
+	}
+	var leaves []*jsonschema.ValidationError
+	for _, cause := range err.Causes {
+		leaves = append(leaves, leafErrors(cause)...)
+	}
+	return leaves
+}
+
+// objectAt returns the object under key in obj, or nil.
+func objectAt(obj map[string]any, key string) map[string]any {
+	v, _ := obj[key].(map[string]any)
+	return v
+}
+
+// stringsAt returns the strings in the array under key in obj. Values that
+// are not strings are returned as "" so indices match the array.
+func stringsAt(obj map[string]any, key string) []string {
+	arr, _ := obj[key].([]any)
+	values := make([]string, len(arr))
+	for i, v := range arr {
+		values[i], _ = v.(string)
+	}
+	return values
+}
+
+// expandMount expands a leading ~ in a mount path. It returns false for
+// relative paths, which depend on the directory silo is run from.
+func expandMount(path string) (string, bool) {
+	switch {
+	case path == "":
+		return "", false
+	case path == "~":
+		return os.Getenv("HOME"), true
+	case strings.HasPrefix(path, "~/"):
+		return os.Getenv("HOME") + path[1:], true
+	case strings.HasPrefix(path, "/"):
+		return path, true
+	}
+	return "", false
+}
+
+// pointer returns the JSON pointer for the location tokens.
+func pointer(location []string) string {
+	var b strings.Builder
+	for _, token := range location {
+		b.WriteByte('/')
+		token = strings.ReplaceAll(token, "~", "~0")
+		b.WriteString(strings.ReplaceAll(token, "/", "~1"))
+	}
+	return b.String()
+}
+
+// valueOffsets returns the byte offset of each value in the JSON document,
+// keyed by JSON pointer. Object members are located at their key so problems
+// with them point at the line the key is on.
+func valueOffsets(data []byte) map[string]int {
+	offsets := make(map[string]int)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	_ = indexValue(dec, data, "", offsets)
+	return offsets
+}
+
+// indexValue records the offset of the value at ptr and everything in it.
+func indexValue(dec *json.Decoder, data []byte, ptr string, offsets map[string]int) error {
+	if _, ok := offsets[ptr]; !ok {
+		offsets[ptr] = nextToken(data, dec.InputOffset())
+	}
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch tok {
+	case json.Delim('{'):
+		for dec.More() {
+			start := nextToken(data, dec.InputOffset())
+			key, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			member := ptr + pointer([]string{key.(string)})
+			offsets[member] = start
+			if err := indexValue(dec, data, member, offsets); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	case json.Delim('['):
+		for i := 0; dec.More(); i++ {
+			if err := indexValue(dec, data, ptr+"/"+strconv.Itoa(i), offsets); err != nil {
+				return err
+			}
+		}
+		_, err = dec.Token()
+	}
+	return err
+}
+
+// nextToken returns the offset of the next token at or after offset,
+// skipping whitespace and the separators the decoder doesn't return.
+func nextToken(data []byte, offset int64) int {
+	i := int(offset)
+	for i < len(data) && strings.IndexByte(" \t\r\n,:", data[i]) >= 0 {
+		i++
+	}
+	return i
+}
+
+// position returns the 1-based line and column of offset in data.
+func position(data []byte, offset int) (line, col int) {
+	offset = min(offset, len(data))
+	line = 1 + bytes.Count(data[:offset], []byte("\n"))
+	col = offset - bytes.LastIndexByte(data[:offset], '\n')
+	return line, col
+}
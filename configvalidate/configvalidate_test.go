@@ -0,0 +1,91 @@
+package configvalidate
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func newTestValidator(t *testing.T) *Validator {
+	t.Helper()
+	schema, err := os.ReadFile("../silo.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := New(schema, []string{"claude", "opencode", "copilot"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestDataValid(t *testing.T) {
+	v := newTestValidator(t)
+	data := `{
+  // comment
+  "env": ["FOO", "BAR=baz"],
+  "tools": {
+    "claude": { "mounts_ro": ["/"] }
+  },
+  "profiles": {
+    "offline": { "network": "none", "env": ["OFFLINE=1"] }
+  }
+}`
+	if problems := v.Data("silo.jsonc", []byte(data)); len(problems) != 0 {
+		t.Errorf("expected no problems, got %v", problems)
+	}
+}
+
+func TestDataProblems(t *testing.T) {
+	v := newTestValidator(t)
+	data := `{
+  // comment
+  "bogus": true,
+  "env": ["FOO", "1BAD"],
+  "network": "some",
+  "tools": {
+    "cluade": {},
+    "claude": { "mounts_rw": ["/does/not/exist"] }
+  }
+}`
+	problems := v.Data("silo.jsonc", []byte(data))
+	want := []struct {
+		line, col int
+		msg       string
+		warning   bool
+	}{
+		{3, 3, `unknown key "bogus"`, false},
+		{4, 18, `invalid env entry "1BAD"`, false},
+		{5, 3, `network: value must be one of`, false},
+		{7, 5, `unknown tool "cluade"`, false},
+		{8, 31, `mount path /does/not/exist does not exist`, true},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("expected %d problems, got %d: %v", len(want), len(problems), problems)
+	}
+	for i, w := range want {
+		p := problems[i]
+		if p.Line != w.line || p.Column != w.col || !strings.Contains(p.Message, w.msg) || p.Warning != w.warning {
+			t.Errorf("problem %d: got %s (warning %v), want %d:%d: %s (warning %v)", i, p, p.Warning, w.line, w.col, w.msg, w.warning)
+		}
+	}
+}
+
+func TestDataSyntaxError(t *testing.T) {
+	v := newTestValidator(t)
+	problems := v.Data("silo.jsonc", []byte("{\n  \"env\": [\"FOO\",,]\n}"))
+	if len(problems) != 1 || problems[0].Line != 2 || !strings.Contains(problems[0].Message, "invalid JSON") {
+		t.Errorf("expected an invalid JSON problem on line 2, got %v", problems)
+	}
+}
+
+func TestPosition(t *testing.T) {
+	data := []byte("ab\ncd\n")
+	for _, tc := range []struct{ offset, line, col int }{
+		{0, 1, 1}, {1, 1, 2}, {3, 2, 1}, {4, 2, 2},
+	} {
+		if line, col := position(data, tc.offset); line != tc.line || col != tc.col {
+			t.Errorf("position(%d) = %d:%d, want %d:%d", tc.offset, line, col, tc.line, tc.col)
+		}
+	}
+}
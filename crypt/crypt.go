@@ -0,0 +1,222 @@
+// Package crypt optionally encrypts the files silo writes under XDG state
+// with age, so session history at rest is only readable with the identity.
+package crypt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+)
+
+// linePrefix marks an encrypted line in a line-oriented file.
+const linePrefix = "age:"
+
+var (
+	mu         sync.RWMutex
+	recipients []age.Recipient
+	identities []age.Identity
+	configErr  error // set when the recipients are invalid, so nothing is written in plain text
+)
+
+// Configure sets the recipients new data is encrypted to and the identity
+// file used to decrypt, which may start with ~/. With no recipients data is
+// written in plain text. A missing identity file is not an error: encrypted
+// data is then unreadable but can still be written. If a recipient is
+// invalid, SealLine fails until Configure succeeds, rather than falling back
+// to plain text.
+func Configure(recipientKeys []string, identityPath string) error {
+	var rs []age.Recipient
+	for _, key := range recipientKeys {
+		r, err := age.ParseX25519Recipient(key)
+		if err != nil {
+			err = fmt.Errorf("invalid encrypt_recipients key %q: %w", key, err)
+			mu.Lock()
+			configErr = err
+			mu.Unlock()
+			return err
+		}
+		rs = append(rs, r)
+	}
+
+	var ids []age.Identity
+	if rest, ok := strings.CutPrefix(identityPath, "~/"); ok {
+		identityPath = filepath.Join(os.Getenv("HOME"), rest)
+	}
+	if identityPath != "" {
+		f, err := os.Open(identityPath)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return fmt.Errorf("failed to open encrypt_identity: %w", err)
+		default:
+			defer f.Close()
+			ids, err = age.ParseIdentities(f)
+			if err != nil {
+				return fmt.Errorf("failed to parse encrypt_identity: %w", err)
+			}
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	recipients, identities, configErr = rs, ids, nil
+	return nil
+}
+
+// Enabled reports whether new data is encrypted.
+func Enabled() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return len(recipients) > 0
+}
+
+// SealLine returns line encrypted as a single line of text, or line unchanged
+// if encryption is not enabled. Each line is encrypted independently so
+// encrypted files can be appended to.
+func SealLine(line []byte) ([]byte, error) {
+	mu.RLock()
+	rs, err := recipients, configErr
+	mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if len(rs) == 0 {
+		return line, nil
+	}
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, rs...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(line); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return []byte(linePrefix + base64.RawStdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+// OpenLine returns the plain text of a line written by SealLine. Lines that
+// aren't encrypted are returned unchanged. It returns false if the line is
+// encrypted and can't be decrypted with the configured identity.
+func OpenLine(line []byte) ([]byte, bool) {
+	encoded, ok := bytes.CutPrefix(line, []byte(linePrefix))
+	if !ok {
+		return line, true
+	}
+	mu.RLock()
+	ids := identities
+	mu.RUnlock()
+	if len(ids) == 0 {
+		return nil, false
+	}
+	data, err := base64.RawStdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return nil, false
+	}
+	r, err := age.Decrypt(bytes.NewReader(data), ids...)
+	if err != nil {
+		return nil, false
+	}
+	plain, err := io.ReadAll(r)
+	if err != nil {
+		return nil, false
+	}
+	return plain, true
+}
+
+// header starts every file written by age, so files sealed by NewWriter can
+// be told apart from plain text ones.
+const header = "age-encryption.org/v1\n"
+
+// NewWriter returns a writer that encrypts what is written to w as a single
+// age file, or w itself if encryption is not enabled. Closing it closes w.
+// Data is encrypted in chunks of 64 KiB, so if the writer isn't closed the
+// last chunk is lost. Files it writes can also be read with age -d.
+func NewWriter(w io.WriteCloser) (io.WriteCloser, error) {
+	mu.RLock()
+	rs, err := recipients, configErr
+	mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	if len(rs) == 0 {
+		return w, nil
+	}
+	enc, err := age.Encrypt(w, rs...)
+	if err != nil {
+		return nil, err
+	}
+	return &sealedWriter{WriteCloser: enc, file: w}, nil
+}
+
+type sealedWriter struct {
+	io.WriteCloser
+	file io.Closer
+}
+
+func (w *sealedWriter) Close() error {
+	err := w.WriteCloser.Close()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// NewReader returns a reader of the plain text of r, a file written by
+// NewWriter. Files that aren't encrypted are read unchanged. It fails if r
+// is encrypted and can't be decrypted with the configured identity.
+func NewReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if peek, _ := br.Peek(len(header)); string(peek) != header {
+		return br, nil
+	}
+	mu.RLock()
+	ids := identities
+	mu.RUnlock()
+	if len(ids) == 0 {
+		return nil, errors.New("the file is encrypted and encrypt_identity isn't set or doesn't exist")
+	}
+	return age.Decrypt(br, ids...)
+}
+
+// Seal returns data encrypted as by NewWriter, or data unchanged if
+// encryption is not enabled.
+func Seal(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := NewWriter(nopCloser{&buf})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Open returns the plain text of data written by Seal or NewWriter. Data
+// that isn't encrypted is returned unchanged.
+func Open(data []byte) ([]byte, error) {
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
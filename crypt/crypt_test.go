@@ -0,0 +1,114 @@
+package crypt
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestSealOpenLine(t *testing.T) {
+	t.Cleanup(func() { Configure(nil, "") })
+
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idPath := filepath.Join(t.TempDir(), "age.key")
+	if err := os.WriteFile(idPath, []byte(id.String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	line := []byte(`{"tool":"claude"}`)
+
+	// Disabled: lines pass through unchanged
+	if err := Configure(nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := SealLine(line); err != nil || !bytes.Equal(got, line) {
+		t.Errorf("expected plain line, got %q, %v", got, err)
+	}
+
+	// Enabled: lines are encrypted and decrypt with the identity
+	if err := Configure([]string{id.Recipient().String()}, idPath); err != nil {
+		t.Fatal(err)
+	}
+	if !Enabled() {
+		t.Error("expected encryption to be enabled")
+	}
+	sealed, err := SealLine(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("claude")) || bytes.ContainsAny(sealed, "\n") {
+		t.Errorf("expected an opaque single line, got %q", sealed)
+	}
+	if got, ok := OpenLine(sealed); !ok || !bytes.Equal(got, line) {
+		t.Errorf("expected %q, got %q, %v", line, got, ok)
+	}
+	if got, ok := OpenLine(line); !ok || !bytes.Equal(got, line) {
+		t.Errorf("expected plain lines to pass through, got %q, %v", got, ok)
+	}
+
+	// Without the identity encrypted lines can't be read
+	if err := Configure([]string{id.Recipient().String()}, filepath.Join(t.TempDir(), "missing")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := OpenLine(sealed); ok {
+		t.Error("expected encrypted line to be unreadable without the identity")
+	}
+
+	// An invalid recipient fails writes rather than writing plain text
+	if err := Configure([]string{"age1invalid"}, ""); err == nil {
+		t.Fatal("expected error for invalid recipient")
+	}
+	if _, err := SealLine(line); err == nil {
+		t.Error("expected SealLine to fail after an invalid recipient")
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	t.Cleanup(func() { Configure(nil, "") })
+
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idPath := filepath.Join(t.TempDir(), "age.key")
+	if err := os.WriteFile(idPath, []byte(id.String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("step 1/3: FROM ubuntu\nsecret output\n")
+
+	// Disabled: data passes through unchanged
+	if got, err := Seal(data); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("expected plain data, got %q, %v", got, err)
+	}
+
+	// Enabled: data is an age file that decrypts with the identity
+	if err := Configure([]string{id.Recipient().String()}, idPath); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := Seal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("secret")) || !bytes.HasPrefix(sealed, []byte(header)) {
+		t.Errorf("expected an age file, got %q", sealed)
+	}
+	if got, err := Open(sealed); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("expected %q, got %q, %v", data, got, err)
+	}
+	if got, err := Open(data); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("expected plain data to pass through, got %q, %v", got, err)
+	}
+
+	// Without the identity encrypted data can't be read
+	if err := Configure([]string{id.Recipient().String()}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(sealed); err == nil {
+		t.Error("expected encrypted data to be unreadable without the identity")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	_ "embed"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/leighmcculloch/silo/tools"
@@ -14,6 +15,46 @@ var dockerfileBase string
 //go:embed silo.jsonc.example
 var sampleConfig string
 
+//go:embed silo.schema.json
+var configSchema []byte
+
+// baseImageCommands are the commands available in the base stage of the
+// embedded Dockerfile: those of the Ubuntu image and the packages and
+// toolchains Dockerfile.base installs. Keep in sync with Dockerfile.base.
+var baseImageCommands = []string{
+	// Ubuntu base image
+	"apt", "apt-get", "awk", "base64", "basename", "bash", "cat", "chmod",
+	"chown", "cmp", "cp", "cut", "date", "dd", "df", "diff", "dirname",
+	"dpkg", "du", "env", "expr", "find", "grep", "gzip", "head", "hostname",
+	"id", "install", "kill", "ln", "ls", "mkdir", "mktemp", "mv", "nproc",
+	"perl", "ps", "readlink", "realpath", "rm", "rmdir", "sed", "seq", "sh",
+	"sha256sum", "sleep", "sort", "stat", "tail", "tar", "tee", "timeout",
+	"touch", "tr", "uname", "uniq", "wc", "which", "whoami", "xargs", "yes",
+	// Packages
+	"c++", "cc", "curl", "g++", "gcc", "git", "gpg", "jq", "ld", "make",
+	"pkg-config", "scp", "ssh", "ssh-keygen", "sudo", "tinyproxy", "unzip",
+	"zsh", "zstd",
+	// Toolchains and tools
+	"go", "gofmt", "gopls", "node", "npm", "npx", "corepack", "rustup",
+	"cargo", "rustc", "rustfmt", "rust-analyzer", "gh", "github-mcp-server",
+}
+
+// dockerInDockerCommands are the commands the base stage installs only
+// with docker_in_docker.
+var dockerInDockerCommands = []string{"docker", "dockerd"}
+
+// ImageCommands returns the commands available in the embedded image:
+// those in the base stage and each tool's own command.
+func ImageCommands(tt []tools.Tool) []string {
+	commands := slices.Clone(baseImageCommands)
+	for _, t := range tt {
+		if cmd := t.Command(""); len(cmd) > 0 {
+			commands = append(commands, cmd[0])
+		}
+	}
+	return commands
+}
+
 // Dockerfile returns the composed Dockerfile: base stage + all tool stages.
 func Dockerfile(tt []tools.Tool) string {
 	var b strings.Builder
@@ -42,6 +42,12 @@ func TestDockerfile(t *testing.T) {
 	if !strings.Contains(df, "ARG HOME") {
 		t.Error("expected dockerfile to contain HOME build arg")
 	}
+
+	// User build args must follow the system packages so the package layers
+	// can be reused from a prebuilt image
+	if strings.Index(df, "ARG USER") < strings.Index(df, "apt-get install") {
+		t.Error("expected USER build arg after system package install")
+	}
 }
 
 func TestAvailableTools(t *testing.T) {
@@ -0,0 +1,207 @@
+// Package docs holds silo's offline documentation: guides embedded as
+// markdown, and a configuration reference generated from the config structs
+// and the JSON schema, so it can't drift from the settings silo reads.
+package docs
+
+import (
+	"cmp"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/leighmcculloch/silo/config"
+)
+
+//go:embed *.md
+var files embed.FS
+
+// Topic is a documentation topic shown by silo docs.
+type Topic struct {
+	Name  string
+	Title string
+}
+
+// Topics are the documentation topics, in the order they're listed.
+var Topics = []Topic{
+	{Name: "config", Title: "Configuration reference: every setting, its type and default"},
+	{Name: "backends", Title: "Backends: choosing one and how they compare"},
+	{Name: "security", Title: "Security model: what a session can and can't reach"},
+	{Name: "hooks", Title: "Hooks: customizing images and sessions"},
+}
+
+// Markdown returns the markdown of the topic name. schema is the JSON schema
+// of the config, which the configuration reference is generated from.
+func Markdown(name string, schema []byte) (string, error) {
+	if !slices.ContainsFunc(Topics, func(t Topic) bool { return t.Name == name }) {
+		return "", fmt.Errorf("unknown topic: %s (see silo docs)", name)
+	}
+	if name == "config" {
+		return ConfigReference(schema)
+	}
+	data, err := files.ReadFile(name + ".md")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// schemaNode is the part of a JSON schema node the reference is made from.
+type schemaNode struct {
+	Ref                  string                `json:"$ref"`
+	Type                 any                   `json:"type"`
+	Description          string                `json:"description"`
+	Enum                 []any                 `json:"enum"`
+	Const                any                   `json:"const"`
+	Default              any                   `json:"default"`
+	Items                *schemaNode           `json:"items"`
+	OneOf                []schemaNode          `json:"oneOf"`
+	Properties           map[string]schemaNode `json:"properties"`
+	AdditionalProperties json.RawMessage       `json:"additionalProperties"`
+}
+
+type schemaDoc struct {
+	schemaNode
+	Defs map[string]schemaNode `json:"$defs"`
+}
+
+// ConfigReference returns the configuration reference: the settings of the
+// Config, ToolConfig, and RepoConfig structs, in the order they're declared,
+// described by the schema.
+func ConfigReference(schema []byte) (string, error) {
+	var doc schemaDoc
+	if err := json.Unmarshal(schema, &doc); err != nil {
+		return "", fmt.Errorf("failed to parse config schema: %w", err)
+	}
+	var b strings.Builder
+	b.WriteString("# Configuration Reference\n\n")
+	b.WriteString("Settings are read from `~/.config/silo/silo.jsonc` and from `silo.jsonc` files in the current directory and its parents up to the repository root, merged in that order. Lists are appended across files; other settings in later files replace earlier ones. Run `silo config show` to see the merged config and where each value comes from.\n\n")
+
+	sections := []struct {
+		title, intro string
+		typ          reflect.Type
+		props        map[string]schemaNode
+	}{
+		{"Global Settings", "", reflect.TypeFor[config.Config](), doc.Properties},
+		{"Tool Settings", "Set under `tools.<name>`, e.g. `tools.claude`. They apply when that tool runs.", reflect.TypeFor[config.ToolConfig](), doc.Defs["toolConfig"].Properties},
+		{"Repository and Profile Settings", "Set under `repos.<pattern>`, which apply when a git remote URL of the current directory contains the pattern, and under `profiles.<name>`, which apply with `--profile <name>`.", reflect.TypeFor[config.RepoConfig](), doc.Defs["repoConfig"].Properties},
+	}
+	for _, s := range sections {
+		fmt.Fprintf(&b, "## %s\n\n", s.title)
+		if s.intro != "" {
+			fmt.Fprintf(&b, "%s\n\n", s.intro)
+		}
+		for _, name := range jsonNames(s.typ) {
+			prop, ok := s.props[name]
+			if !ok {
+				return "", fmt.Errorf("config schema has no %s", name)
+			}
+			writeSetting(&b, name, prop, doc.Defs)
+		}
+	}
+	return b.String(), nil
+}
+
+// jsonNames returns the JSON names of the fields of the struct t.
+func jsonNames(t reflect.Type) []string {
+	var names []string
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// writeSetting writes the reference of the setting name to b.
+func writeSetting(b *strings.Builder, name string, n schemaNode, defs map[string]schemaNode) {
+	fmt.Fprintf(b, "### %s\n\n", name)
+	desc := n.Description
+	if n.Ref != "" {
+		def := defs[strings.TrimPrefix(n.Ref, "#/$defs/")]
+		desc = cmp.Or(desc, def.Description)
+		n = def
+	}
+	line := "Type: " + typeName(n, defs) + "."
+	if len(n.Enum) > 0 {
+		line += " One of " + codeList(n.Enum) + "."
+	}
+	if n.Default != nil {
+		line += " Default: " + code(n.Default) + "."
+	}
+	fmt.Fprintf(b, "%s\n\n", line)
+	if desc != "" {
+		fmt.Fprintf(b, "%s\n\n", desc)
+	}
+	if len(n.Properties) > 0 {
+		keys := slices.Sorted(maps.Keys(n.Properties))
+		for _, k := range keys {
+			p := n.Properties[k]
+			fmt.Fprintf(b, "- `%s` (%s): %s\n", k, typeName(p, defs), p.Description)
+		}
+		b.WriteString("\n")
+	}
+}
+
+// typeName describes the type of the schema node n, e.g. "list of strings".
+func typeName(n schemaNode, defs map[string]schemaNode) string {
+	if n.Ref != "" {
+		return typeName(defs[strings.TrimPrefix(n.Ref, "#/$defs/")], defs)
+	}
+	if len(n.OneOf) > 0 {
+		var alts []string
+		for _, alt := range n.OneOf {
+			if alt.Const != nil {
+				alts = append(alts, code(alt.Const))
+			} else {
+				alts = append(alts, typeName(alt, defs))
+			}
+		}
+		return strings.Join(alts, " or ")
+	}
+	switch t := n.Type.(type) {
+	case string:
+		switch t {
+		case "array":
+			if n.Items != nil {
+				return "list of " + typeName(*n.Items, defs) + "s"
+			}
+			return "list"
+		case "object":
+			if n.Properties == nil && len(n.AdditionalProperties) > 0 {
+				var item schemaNode
+				if err := json.Unmarshal(n.AdditionalProperties, &item); err == nil && (item.Ref != "" || item.Type != nil) {
+					return "object of " + typeName(item, defs) + "s"
+				}
+			}
+			return "object"
+		}
+		return t
+	case []any:
+		var alts []string
+		for _, a := range t {
+			alts = append(alts, fmt.Sprint(a))
+		}
+		return strings.Join(alts, " or ")
+	}
+	return "any"
+}
+
+// code formats the JSON value v as inline code.
+func code(v any) string {
+	data, _ := json.Marshal(v)
+	return "`" + string(data) + "`"
+}
+
+// codeList formats the JSON values vs as a list of inline code.
+func codeList(vs []any) string {
+	var parts []string
+	for _, v := range vs {
+		parts = append(parts, code(v))
+	}
+	return strings.Join(parts, ", ")
+}
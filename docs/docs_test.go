@@ -0,0 +1,72 @@
+package docs
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/leighmcculloch/silo/config"
+)
+
+func TestConfigReference(t *testing.T) {
+	schema, err := os.ReadFile("../silo.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := ConfigReference(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every setting is documented, so the reference can't miss new fields
+	for _, typ := range []reflect.Type{reflect.TypeFor[config.Config](), reflect.TypeFor[config.ToolConfig](), reflect.TypeFor[config.RepoConfig]()} {
+		for _, name := range jsonNames(typ) {
+			if !strings.Contains(ref, "\n### "+name+"\n") {
+				t.Errorf("reference is missing %s", name)
+			}
+		}
+	}
+	for _, want := range []string{
+		"Type: string. One of `\"docker\"`, `\"container\"`, `\"sandbox\"`.",
+		"Type: boolean or `\"rootless\"`. Default: `false`.",
+		"Type: list of strings.",
+		"- `cpus` (number): ",
+	} {
+		if !strings.Contains(ref, want) {
+			t.Errorf("reference is missing %q", want)
+		}
+	}
+
+	if _, err := ConfigReference([]byte(`{"properties": {}}`)); err == nil {
+		t.Error("expected an error for a schema missing settings")
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	schema, err := os.ReadFile("../silo.schema.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range Topics {
+		md, err := Markdown(topic.Name, schema)
+		if err != nil {
+			t.Errorf("%s: %v", topic.Name, err)
+		} else if !strings.HasPrefix(md, "# ") {
+			t.Errorf("%s doesn't start with a title", topic.Name)
+		}
+	}
+	if _, err := Markdown("nope", schema); err == nil {
+		t.Error("expected an error for an unknown topic")
+	}
+}
+
+func TestRender(t *testing.T) {
+	md := "# Title\n\nSee [the hooks](#hooks) and [Docker](https://docs.docker.com), set `env`.\n\n" +
+		"```jsonc\n{ \"a\": 1 }\n```\n\n- a list item that is long enough to wrap\n| a | b |"
+	got := Render(md, 20, true)
+	want := "TITLE\n\nSee the hooks and\nDocker\n(https://docs.docker\n.com), set env.\n\n    { \"a\": 1 }\n\n" +
+		"• a list item that\n  is long enough to\n  wrap\n| a | b |\n"
+	if got != want {
+		t.Errorf("Render() =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,101 @@
+package docs
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// styles are the styles of the rendered markdown elements.
+type styles struct {
+	h1, h2, h3, code, bold, bullet lipgloss.Style
+}
+
+var colorStyles = styles{
+	h1:     lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("205")),
+	h2:     lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("86")),
+	h3:     lipgloss.NewStyle().Bold(true),
+	code:   lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+	bold:   lipgloss.NewStyle().Bold(true),
+	bullet: lipgloss.NewStyle().Foreground(lipgloss.Color("205")),
+}
+
+var (
+	inlineCode = regexp.MustCompile("`([^`]+)`")
+	boldText   = regexp.MustCompile(`\*\*([^*]+)\*\*`)
+	link       = regexp.MustCompile(`\[([^\]]+)\]\(([^)]+)\)`)
+)
+
+// Render renders the markdown md for a terminal width columns wide: headings
+// and inline code are styled, paragraphs and list items are wrapped, code
+// blocks are indented, and links show their URL after the text. Tables are
+// written as they are. With plain, nothing is styled, for screen readers.
+func Render(md string, width int, plain bool) string {
+	s := colorStyles
+	if plain {
+		s = styles{}
+	}
+	var b strings.Builder
+	inCode := false
+	for line := range strings.SplitSeq(strings.TrimRight(md, "\n"), "\n") {
+		if strings.HasPrefix(line, "```") {
+			inCode = !inCode
+			continue
+		}
+		switch {
+		case inCode:
+			b.WriteString("    " + s.code.Render(line))
+		case strings.HasPrefix(line, "# "):
+			b.WriteString(s.h1.Render(strings.ToUpper(strings.TrimPrefix(line, "# "))))
+		case strings.HasPrefix(line, "## "):
+			b.WriteString(s.h2.Render(strings.TrimPrefix(line, "## ")))
+		case strings.HasPrefix(line, "### "):
+			b.WriteString(s.h3.Render(strings.TrimPrefix(line, "### ")))
+		case strings.HasPrefix(line, "|"):
+			b.WriteString(inline(line, s))
+		case strings.HasPrefix(line, "- "):
+			b.WriteString(s.bullet.Render("•") + " " + indent(wrap(inline(strings.TrimPrefix(line, "- "), s), width-2), "  "))
+		default:
+			b.WriteString(wrap(inline(line, s), width))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// inline styles the inline code and bold text of line, and replaces links
+// with their text, followed by the URL unless it's an anchor in the page.
+func inline(line string, s styles) string {
+	line = link.ReplaceAllStringFunc(line, func(m string) string {
+		parts := link.FindStringSubmatch(m)
+		if strings.HasPrefix(parts[2], "#") {
+			return parts[1]
+		}
+		return parts[1] + " (" + parts[2] + ")"
+	})
+	line = inlineCode.ReplaceAllStringFunc(line, func(m string) string {
+		return s.code.Render(strings.Trim(m, "`"))
+	})
+	return boldText.ReplaceAllStringFunc(line, func(m string) string {
+		return s.bold.Render(strings.Trim(m, "*"))
+	})
+}
+
+// wrap wraps s at width columns, ignoring escape sequences.
+func wrap(s string, width int) string {
+	if width <= 0 || s == "" {
+		return s
+	}
+	lines := strings.Split(lipgloss.NewStyle().Width(width).Render(s), "\n")
+	for i, l := range lines {
+		// The style pads lines to the width
+		lines[i] = strings.TrimRight(l, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// indent indents every line of s but the first with prefix.
+func indent(s, prefix string) string {
+	return strings.ReplaceAll(s, "\n", "\n"+prefix)
+}
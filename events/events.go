@@ -0,0 +1,79 @@
+// Package events writes the progress of silo as a stream of JSON lines, for
+// editors and other programs embedding silo to show it natively instead of
+// parsing silo's messages on stderr.
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Types of events.
+const (
+	BuildStarted     = "build_started"     // an image is being built
+	BuildProgress    = "build_progress"    // a line of a build's output
+	HooksRunning     = "hooks_running"     // hooks are running, see Event.Hooks
+	ContainerStarted = "container_started" // the container of a session started
+	ToolExited       = "tool_exited"       // the tool of an attached session exited
+)
+
+// Event is a line of the stream. Fields that don't apply to its Type are
+// left out.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Tool      string    `json:"tool,omitempty"`
+	Image     string    `json:"image,omitempty"`
+	Container string    `json:"container,omitempty"`
+
+	// Hooks is which hooks are running, "pre_run", "on_failure", or
+	// "post_run", and Count how many, for hooks_running
+	Hooks string `json:"hooks,omitempty"`
+	Count int    `json:"count,omitempty"`
+
+	// Line is a line of build output, without its newline, for
+	// build_progress
+	Line string `json:"line,omitempty"`
+
+	// ExitCode is the tool's exit code, or Error why the session failed
+	// without one, for tool_exited
+	ExitCode *int   `json:"exit_code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+var stream struct {
+	mu sync.Mutex
+	w  io.WriteCloser
+}
+
+// Set sets where events are written, closing where they were written
+// before. nil stops writing events.
+func Set(w io.WriteCloser) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if stream.w != nil {
+		stream.w.Close()
+	}
+	stream.w = w
+}
+
+// Emit writes e, with the current time if it has none. It does nothing when
+// events aren't written, and a reader that has gone away doesn't fail the
+// run.
+func Emit(e Event) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	if stream.w == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	stream.w.Write(append(line, '\n'))
+}
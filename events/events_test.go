@@ -0,0 +1,42 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// buffer is an event stream that is a buffer.
+type buffer struct{ bytes.Buffer }
+
+func (*buffer) Close() error { return nil }
+
+func TestEmit(t *testing.T) {
+	Emit(Event{Type: BuildStarted}) // no stream, nothing written
+
+	var buf buffer
+	Set(&buf)
+	t.Cleanup(func() { Set(nil) })
+	code := 0
+	Emit(Event{Type: BuildProgress, Tool: "claude", Line: "#1 DONE"})
+	Emit(Event{Type: ToolExited, Tool: "claude", ExitCode: &code})
+
+	var got []map[string]any
+	for line := range strings.Lines(buf.String()) {
+		var e map[string]any
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("line %q isn't JSON: %v", line, err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != 2 || got[0]["type"] != BuildProgress || got[0]["line"] != "#1 DONE" || got[0]["time"] == nil {
+		t.Fatalf("events = %v, want the build progress first", got)
+	}
+	if _, ok := got[0]["exit_code"]; ok {
+		t.Errorf("build_progress has an exit code: %v", got[0])
+	}
+	if got[1]["exit_code"] != 0.0 {
+		t.Errorf("tool_exited = %v, want exit code 0", got[1])
+	}
+}
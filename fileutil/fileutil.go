@@ -0,0 +1,164 @@
+// Package fileutil provides atomic file writes and advisory locks so that
+// concurrent silo invocations don't corrupt shared config and state files,
+// and copying of file trees.
+package fileutil
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/leighmcculloch/silo/statedir"
+	"golang.org/x/sys/unix"
+)
+
+// lockDir returns the directory holding lock files. Locks live outside the
+// locked file's directory so that locking a config file in a repository
+// doesn't leave files behind in the working tree. They stay in the default
+// state directory when state_dir is set, so processes whose configs set
+// different state directories, such as in different repositories, still
+// exclude each other from the files they share, such as the global config.
+var lockDir = func() string {
+	return filepath.Join(statedir.Default(), "locks")
+}
+
+// WriteFile writes data to path atomically. The data is written to a
+// temporary file in the same directory and renamed over path, so readers see
+// either the old or the new content and never a partial write.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Lock takes an exclusive advisory lock identified by key, blocking until any
+// other silo process holding it releases it. Use a file's absolute path as the
+// key to guard that file. The returned function releases the lock, and the
+// lock is released automatically if the process exits.
+func Lock(key string) (unlock func(), err error) {
+	dir := lockDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	name := fmt.Sprintf("%x", sha256.Sum256([]byte(key)))[:16] + ".lock"
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock: %w", err)
+	}
+	for {
+		err = unix.Flock(int(f.Fd()), unix.LOCK_EX)
+		if err != unix.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", key, err)
+	}
+	return func() {
+		unix.Flock(int(f.Fd()), unix.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// Copy copies the file or directory tree at src to dst. Directories are
+// merged into existing ones: files and symlinks in dst are replaced by those
+// in src, and anything only in dst is kept. Permissions and symlinks are
+// preserved; other special files, such as sockets, are skipped.
+func Copy(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	return copyEntry(src, dst, info)
+}
+
+func copyEntry(src, dst string, info fs.FileInfo) error {
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+
+	case info.IsDir():
+		if existing, err := os.Lstat(dst); err == nil && !existing.IsDir() {
+			if err := os.Remove(dst); err != nil {
+				return err
+			}
+		}
+		// Keep the directory writable until its contents are copied
+		if err := os.MkdirAll(dst, info.Mode().Perm()|0o700); err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			entryInfo, err := e.Info()
+			if err != nil {
+				return err
+			}
+			if err := copyEntry(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name()), entryInfo); err != nil {
+				return err
+			}
+		}
+		return os.Chmod(dst, info.Mode().Perm())
+
+	case info.Mode().IsRegular():
+		if existing, err := os.Lstat(dst); err == nil && !existing.Mode().IsRegular() {
+			if err := os.RemoveAll(dst); err != nil {
+				return err
+			}
+		}
+		return copyFile(src, dst, info.Mode().Perm())
+	}
+	return nil
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm|0o200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, perm)
+}
@@ -0,0 +1,160 @@
+package fileutil
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "silo.jsonc")
+
+	if err := os.WriteFile(path, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(path, []byte("new"), 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("content = %q, want %q", data, "new")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o644 {
+		t.Errorf("perm = %v, want %v", info.Mode().Perm(), os.FileMode(0o644))
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the written file, got %d entries", len(entries))
+	}
+}
+
+func TestLock(t *testing.T) {
+	tmp := t.TempDir()
+	orig := lockDir
+	lockDir = func() string { return tmp }
+	t.Cleanup(func() { lockDir = orig })
+
+	unlock, err := Lock("/some/file")
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+
+	var mu sync.Mutex
+	acquired := false
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		unlock2, err := Lock("/some/file")
+		if err != nil {
+			t.Errorf("second Lock: %v", err)
+			return
+		}
+		mu.Lock()
+		acquired = true
+		mu.Unlock()
+		unlock2()
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	if acquired {
+		t.Error("second Lock acquired while the first was held")
+	}
+	mu.Unlock()
+
+	unlock()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second Lock not acquired after unlock")
+	}
+
+	// Different keys don't contend.
+	unlockA, err := Lock("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlockA()
+	unlockB, err := Lock("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlockB()
+}
+
+func TestCopy(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	dst := filepath.Join(t.TempDir(), "dst")
+	write := func(path, content string, perm os.FileMode) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(src, "a.json"), "new", 0o600)
+	write(filepath.Join(src, "sub", "b"), "b", 0o755)
+	if err := os.Symlink("a.json", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	write(filepath.Join(dst, "a.json"), "old", 0o644)
+	write(filepath.Join(dst, "only-in-dst"), "kept", 0o644)
+
+	if err := Copy(src, dst); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+
+	for path, want := range map[string]string{
+		"a.json":      "new",
+		"sub/b":       "b",
+		"only-in-dst": "kept",
+		"link":        "new",
+	} {
+		data, err := os.ReadFile(filepath.Join(dst, path))
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", path, data, want)
+		}
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "link")); err != nil || target != "a.json" {
+		t.Errorf("link = %q, %v, want a symlink to a.json", target, err)
+	}
+	for path, want := range map[string]os.FileMode{"a.json": 0o600, "sub/b": 0o755} {
+		info, err := os.Stat(filepath.Join(dst, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("%s perm = %v, want %v", path, info.Mode().Perm(), want)
+		}
+	}
+
+	// Copying a single file
+	file := filepath.Join(t.TempDir(), "file")
+	if err := Copy(filepath.Join(src, "a.json"), file); err != nil {
+		t.Fatalf("Copy file: %v", err)
+	}
+	if data, _ := os.ReadFile(file); string(data) != "new" {
+		t.Errorf("file = %q, want %q", data, "new")
+	}
+}
@@ -1,10 +1,13 @@
 package git
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 )
 
 // GetGitWorktreeRoots returns git worktree common directories for the given directory.
@@ -119,9 +122,32 @@ func GetGitIdentity() (name, email string) {
 	return name, email
 }
 
+// GetGitConfig returns the value of key in the global git config, or "" if
+// it isn't set.
+func GetGitConfig(key string) string {
+	out, err := exec.Command("git", "config", "--global", key).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// remoteURLs memoizes GetGitRemoteURLs by directory, as repo config is
+// matched against the remotes several times per invocation.
+var remoteURLs sync.Map // dir -> []string
+
 // GetGitRemoteURLs returns all remote URLs for the git repository in the given directory.
 // If the directory is not a git repository, it returns an empty slice.
 func GetGitRemoteURLs(dir string) []string {
+	if urls, ok := remoteURLs.Load(dir); ok {
+		return slices.Clone(urls.([]string))
+	}
+	urls := getGitRemoteURLs(dir)
+	remoteURLs.Store(dir, urls)
+	return slices.Clone(urls)
+}
+
+func getGitRemoteURLs(dir string) []string {
 	// Get list of remotes
 	cmd := exec.Command("git", "-C", dir, "remote")
 	out, err := cmd.Output()
@@ -146,3 +172,257 @@ func GetGitRemoteURLs(dir string) []string {
 
 	return urls
 }
+
+// AddWorktree returns the path of a worktree with branch checked out for the
+// repository containing dir, creating one if needed. New worktrees are placed
+// next to the main worktree and named after it and the branch (e.g.,
+// ../myrepo-feature-x). The branch is created from HEAD if no local or remote
+// branch of that name exists.
+func AddWorktree(dir, branch string) (string, error) {
+	out, err := exec.Command("git", "-C", dir, "worktree", "list", "--porcelain").Output()
+	if err != nil {
+		return "", fmt.Errorf("not a git repository: %s", dir)
+	}
+	worktrees := parseWorktreeList(string(out))
+	if len(worktrees) == 0 {
+		return "", fmt.Errorf("no worktrees found for %s", dir)
+	}
+	for _, wt := range worktrees {
+		if wt.Branch == branch {
+			return wt.Path, nil
+		}
+	}
+
+	mainPath := worktrees[0].Path
+	path := filepath.Join(filepath.Dir(mainPath), filepath.Base(mainPath)+"-"+worktreeSuffix(branch))
+
+	args := []string{"-C", dir, "worktree", "add", path, branch}
+	refs, _ := exec.Command("git", "-C", dir, "for-each-ref", "--format=%(refname)",
+		"refs/heads/"+branch, "refs/remotes/*/"+branch).Output()
+	if strings.TrimSpace(string(refs)) == "" {
+		args = []string{"-C", dir, "worktree", "add", "-b", branch, path}
+	}
+	if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+		return "", fmt.Errorf("git worktree add failed: %s", strings.TrimSpace(string(out)))
+	}
+	return path, nil
+}
+
+// worktree is an entry of git worktree list.
+type worktree struct {
+	Path   string
+	Branch string // short branch name, empty if detached
+}
+
+// parseWorktreeList parses the output of git worktree list --porcelain. The
+// main worktree is always first.
+func parseWorktreeList(out string) []worktree {
+	var worktrees []worktree
+	for _, line := range strings.Split(out, "\n") {
+		switch {
+		case strings.HasPrefix(line, "worktree "):
+			worktrees = append(worktrees, worktree{Path: strings.TrimPrefix(line, "worktree ")})
+		case strings.HasPrefix(line, "branch ") && len(worktrees) > 0:
+			worktrees[len(worktrees)-1].Branch = strings.TrimPrefix(line, "branch refs/heads/")
+		}
+	}
+	return worktrees
+}
+
+// worktreeSuffix turns a branch name into a directory name component by
+// replacing path separators and other unsafe characters with '-'.
+func worktreeSuffix(branch string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '-'
+	}, branch)
+}
+
+// Head returns the commit checked out in dir, or "" if dir isn't in a git
+// repository or has no commits.
+func Head(dir string) string {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--verify", "-q", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// MainRoot returns the root of the main worktree of the repository containing
+// dir, which is the same for all of its worktrees, or "" if dir isn't in a
+// git repository. The git directory of a submodule is under the parent's
+// .git/modules rather than in its worktree, so its worktree is found from
+// the core.worktree the git directory records, and a repository without one,
+// such as a bare repository, is identified by its git directory.
+func MainRoot(dir string) string {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "--path-format=absolute", "--git-common-dir").Output()
+	if err != nil {
+		return ""
+	}
+	common := strings.TrimSpace(string(out))
+	if filepath.Base(common) == ".git" {
+		return filepath.Dir(common)
+	}
+	out, err = exec.Command("git", "--git-dir="+common, "rev-parse", "--show-toplevel").Output()
+	if err != nil {
+		return common
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Branch returns the branch checked out in dir, or "" if dir isn't in a git
+// repository or HEAD is detached.
+func Branch(dir string) string {
+	out, err := exec.Command("git", "-C", dir, "symbolic-ref", "--short", "-q", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// Branches returns the local branches of the repository containing dir
+// whose names match pattern, a git for-each-ref pattern such as "silo/*".
+func Branches(dir, pattern string) ([]string, error) {
+	out, err := exec.Command("git", "-C", dir, "for-each-ref", "--format=%(refname:short)", "refs/heads/"+pattern).Output()
+	if err != nil {
+		return nil, fmt.Errorf("not a git repository: %s", dir)
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// Merge merges branch into the branch checked out in dir. If the merge
+// stops, for example on a conflict, it is aborted and dir is left as it was.
+func Merge(dir, branch string) error {
+	out, err := exec.Command("git", "-C", dir, "merge", "--no-edit", branch).CombinedOutput()
+	if err != nil {
+		exec.Command("git", "-C", dir, "merge", "--abort").Run()
+		return fmt.Errorf("git merge failed, nothing was merged: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// RemoveWorktree removes the worktree at path of the repository containing
+// dir, and then branch. Neither is removed if the worktree has uncommitted
+// changes, nor the branch if it isn't merged.
+func RemoveWorktree(dir, path, branch string) error {
+	if out, err := exec.Command("git", "-C", dir, "worktree", "remove", path).CombinedOutput(); err != nil {
+		return fmt.Errorf("git worktree remove failed: %s", strings.TrimSpace(string(out)))
+	}
+	if out, err := exec.Command("git", "-C", dir, "branch", "-d", branch).CombinedOutput(); err != nil {
+		return fmt.Errorf("git branch -d failed: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ChangedFiles returns the tracked files in dir that differ from base, in
+// commits since base or in the working tree.
+func ChangedFiles(dir, base string) ([]string, error) {
+	out, err := exec.Command("git", "-C", dir, "diff", "--name-only", base, "--").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git diff failed in %s: %w", dir, err)
+	}
+	var files []string
+	for line := range strings.SplitSeq(string(out), "\n") {
+		if line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// Commit is a commit listed by Commits.
+type Commit struct {
+	Hash    string
+	Subject string
+}
+
+// Commits returns the commits reachable from HEAD in dir but not from base,
+// oldest first.
+func Commits(dir, base string) ([]Commit, error) {
+	out, err := exec.Command("git", "-C", dir, "log", "--reverse", "--format=%H%x00%s", base+"..HEAD").Output()
+	if err != nil {
+		return nil, fmt.Errorf("git log failed in %s: %w", dir, err)
+	}
+	var commits []Commit
+	for line := range strings.SplitSeq(strings.TrimSpace(string(out)), "\n") {
+		if hash, subject, ok := strings.Cut(line, "\x00"); ok {
+			commits = append(commits, Commit{Hash: hash, Subject: subject})
+		}
+	}
+	return commits, nil
+}
+
+// Rewrite actions for the commits passed to Rewrite.
+const (
+	Keep   = "pick"
+	Squash = "squash"
+	Drop   = "drop"
+)
+
+// Rewrite rewrites the commits after base in dir, applying actions[i] to
+// commits[i] with an interactive rebase. Squashed commits are combined with
+// the commit before them, keeping both messages. commits must be those
+// Commits returns for base, and base an ancestor of HEAD with no merges
+// since. If the rebase stops, for example on a conflict, it is aborted and
+// the branch is left as it was.
+func Rewrite(dir, base string, commits []Commit, actions []string) error {
+	if len(actions) != len(commits) {
+		return fmt.Errorf("got %d actions for %d commits", len(actions), len(commits))
+	}
+	if err := exec.Command("git", "-C", dir, "merge-base", "--is-ancestor", base, "HEAD").Run(); err != nil {
+		return fmt.Errorf("%s is no longer an ancestor of HEAD in %s", base[:min(len(base), 12)], dir)
+	}
+	if out, err := exec.Command("git", "-C", dir, "rev-list", "--merges", base+"..HEAD").Output(); err != nil || len(out) > 0 {
+		return fmt.Errorf("can't rewrite merge commits in %s", dir)
+	}
+	var todo strings.Builder
+	kept := false
+	for i, c := range commits {
+		switch actions[i] {
+		case Squash:
+			if !kept {
+				return fmt.Errorf("can't squash %s: there is no kept commit before it", c.Hash[:min(len(c.Hash), 12)])
+			}
+		case Keep:
+			kept = true
+		case Drop:
+		default:
+			return fmt.Errorf("unknown action: %s", actions[i])
+		}
+		fmt.Fprintf(&todo, "%s %s %s\n", actions[i], c.Hash, c.Subject)
+	}
+
+	f, err := os.CreateTemp("", "silo-rebase-todo-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(todo.String()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("git", "-C", dir, "rebase", "-i", base)
+	// The todo list replaces the one git generates, and squashed messages
+	// are accepted as git combines them
+	cmd.Env = append(os.Environ(),
+		"GIT_SEQUENCE_EDITOR=cp "+shellQuote(f.Name()),
+		"GIT_EDITOR=true",
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		exec.Command("git", "-C", dir, "rebase", "--abort").Run()
+		return fmt.Errorf("git rebase failed, no commits were changed: %s", strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -2,7 +2,9 @@ package git
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
+	"slices"
 	"testing"
 )
 
@@ -171,3 +173,232 @@ func TestGetGitIdentity(t *testing.T) {
 	_ = name
 	_ = email
 }
+
+func TestAddWorktree(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	// git reports resolved paths, and the temp dir is a symlink on macOS
+	tmpDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := filepath.Join(tmpDir, "myrepo")
+	for _, args := range [][]string{
+		{"init", "-q", repo},
+		{"-C", repo, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "--allow-empty", "-m", "init"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	path, err := AddWorktree(repo, "feature/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(tmpDir, "myrepo-feature-x")
+	if path != want {
+		t.Errorf("expected %s, got %s", want, path)
+	}
+	if roots, _ := GetGitWorktreeRoots(path); len(roots) != 1 || roots[0] != repo {
+		t.Errorf("expected worktree of %s, got roots %v", repo, roots)
+	}
+	if root := MainRoot(path); root != repo {
+		t.Errorf("MainRoot of the worktree = %s, want %s", root, repo)
+	}
+	if root := MainRoot(tmpDir); root != "" {
+		t.Errorf("MainRoot outside a repository = %s, want none", root)
+	}
+
+	// Asking again, even from inside the worktree, reuses it.
+	again, err := AddWorktree(path, "feature/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != path {
+		t.Errorf("expected existing worktree %s, got %s", path, again)
+	}
+}
+
+func TestMainRootSubmodule(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	tmpDir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(tmpDir, "sub")
+	parent := filepath.Join(tmpDir, "parent")
+	for _, args := range [][]string{
+		{"init", "-q", sub},
+		{"-C", sub, "-c", "user.name=t", "-c", "user.email=t@t", "commit", "-q", "--allow-empty", "-m", "init"},
+		{"init", "-q", parent},
+		{"-C", parent, "-c", "protocol.file.allow=always", "submodule", "-q", "add", sub, "a"},
+		{"-C", parent, "-c", "protocol.file.allow=always", "submodule", "-q", "add", sub, "b"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	// Each submodule is a repository of its own
+	for _, name := range []string{"a", "b"} {
+		if root := MainRoot(filepath.Join(parent, name)); root != filepath.Join(parent, name) {
+			t.Errorf("MainRoot of submodule %s = %s", name, root)
+		}
+	}
+	if root := MainRoot(parent); root != parent {
+		t.Errorf("MainRoot of the parent = %s, want %s", root, parent)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	for _, k := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(k, "t")
+	}
+	for _, k := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(k, "t@t")
+	}
+	repo := t.TempDir()
+	gitRun := func(args ...string) {
+		t.Helper()
+		if out, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	gitRun("init", "-q")
+	gitRun("commit", "-q", "--allow-empty", "-m", "base")
+	base := Head(repo)
+	for _, name := range []string{"a", "b", "c", "d"} {
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		gitRun("add", name)
+		gitRun("commit", "-q", "-m", "add "+name)
+	}
+
+	commits, err := Commits(repo, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != 4 || commits[0].Subject != "add a" || commits[3].Subject != "add d" {
+		t.Fatalf("unexpected commits: %+v", commits)
+	}
+
+	if err := Rewrite(repo, base, commits, []string{Squash, Keep, Keep, Keep}); err == nil {
+		t.Error("expected an error squashing the first commit")
+	}
+
+	if err := Rewrite(repo, base, commits, []string{Keep, Squash, Drop, Keep}); err != nil {
+		t.Fatal(err)
+	}
+	after, err := Commits(repo, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != 2 || after[0].Subject != "add a" || after[1].Subject != "add d" {
+		t.Errorf("unexpected commits after rewrite: %+v", after)
+	}
+	for name, want := range map[string]bool{"a": true, "b": true, "c": false, "d": true} {
+		if _, err := os.Stat(filepath.Join(repo, name)); (err == nil) != want {
+			t.Errorf("file %s exists = %v, want %v", name, err == nil, want)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(repo, "a"), []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := ChangedFiles(repo, after[0].Hash)
+	if err != nil || !slices.Equal(changed, []string{"a", "d"}) {
+		t.Errorf("ChangedFiles() = %q, %v, want the committed and working tree changes", changed, err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	for _, k := range []string{"GIT_AUTHOR_NAME", "GIT_COMMITTER_NAME"} {
+		t.Setenv(k, "t")
+	}
+	for _, k := range []string{"GIT_AUTHOR_EMAIL", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(k, "t@t")
+	}
+	repo := t.TempDir()
+	gitRun := func(args ...string) {
+		t.Helper()
+		if out, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	commit := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(repo, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		gitRun("add", name)
+		gitRun("commit", "-q", "-m", "write "+name)
+	}
+	gitRun("init", "-q", "-b", "main")
+	commit("a", "a")
+	gitRun("branch", "silo/claude-1")
+	gitRun("branch", "silo/claude-2")
+	gitRun("branch", "other")
+
+	branches, err := Branches(repo, "silo/*")
+	if err != nil || !slices.Equal(branches, []string{"silo/claude-1", "silo/claude-2"}) {
+		t.Errorf("Branches() = %q, %v", branches, err)
+	}
+
+	gitRun("checkout", "-q", "silo/claude-1")
+	commit("b", "b")
+	gitRun("checkout", "-q", "silo/claude-2")
+	commit("a", "conflict")
+	gitRun("checkout", "-q", "main")
+
+	if err := Merge(repo, "silo/claude-1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(repo, "b")); err != nil {
+		t.Errorf("merged file missing: %v", err)
+	}
+
+	commit("a", "changed on main")
+	head := Head(repo)
+	if err := Merge(repo, "silo/claude-2"); err == nil {
+		t.Error("expected an error merging a conflicting branch")
+	}
+	if Head(repo) != head {
+		t.Error("HEAD moved after a failed merge")
+	}
+	if changed, _ := ChangedFiles(repo, head); len(changed) > 0 {
+		t.Errorf("failed merge left changes: %q", changed)
+	}
+
+	// The worktree of an unmerged branch is removed, but not the branch
+	path, err := AddWorktree(repo, "silo/claude-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveWorktree(repo, path, "silo/claude-2"); err == nil {
+		t.Error("expected an error deleting an unmerged branch")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("worktree %s not removed: %v", path, err)
+	}
+	path, err = AddWorktree(repo, "silo/claude-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveWorktree(repo, path, "silo/claude-1"); err != nil {
+		t.Fatal(err)
+	}
+	if branches, _ := Branches(repo, "silo/*"); !slices.Equal(branches, []string{"silo/claude-2"}) {
+		t.Errorf("branches after removing silo/claude-1 = %q", branches)
+	}
+}
@@ -0,0 +1,157 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/leighmcculloch/silo/crypt"
+	"github.com/leighmcculloch/silo/fileutil"
+	"github.com/leighmcculloch/silo/statedir"
+)
+
+// Entry is a single record in the run journal, written once per tool run.
+// Besides usage stats, it is an audit record of what the tool had access
+// to. Environment variable values are never recorded, only their names.
+type Entry struct {
+	Time           time.Time `json:"time"`
+	Tool           string    `json:"tool"`
+	Backend        string    `json:"backend"`
+	ImageTag       string    `json:"image_tag,omitempty"`
+	TemplateHash   string    `json:"template_hash,omitempty"`   // hash of the embedded Dockerfile template
+	Version        string    `json:"version,omitempty"`         // silo version that ran the tool
+	Built          bool      `json:"built"`                     // whether the image was built (cache miss)
+	BuildSeconds   float64   `json:"build_seconds,omitempty"`   // time spent building the image
+	SessionSeconds float64   `json:"session_seconds,omitempty"` // time the container ran
+	Dir            string    `json:"dir,omitempty"`             // host directory the tool ran in
+	Remotes        []string  `json:"remotes,omitempty"`         // git remote URLs of dir
+	Head           string    `json:"head,omitempty"`            // commit checked out in dir when the session started
+	Branch         string    `json:"branch,omitempty"`          // branch checked out in dir when the session started
+	Workspaces     []string  `json:"workspaces,omitempty"`      // extra workspaces mounted alongside dir
+	Container      string    `json:"container,omitempty"`
+	Session        string    `json:"session,omitempty"` // ID of the session, also a label of its container
+	MountsRO       []string  `json:"mounts_ro,omitempty"`
+	MountsRW       []string  `json:"mounts_rw,omitempty"`
+	EnvNames       []string  `json:"env_names,omitempty"`
+	PreRunHooks    []string  `json:"pre_run_hooks,omitempty"`
+	PostBuildHooks []string  `json:"post_build_hooks,omitempty"`
+	Detached       bool      `json:"detached,omitempty"`
+	ExitCode       *int      `json:"exit_code,omitempty"` // unset if detached or the run failed before the tool exited
+	Error          string    `json:"error,omitempty"`     // why the run failed, other than the tool's exit status
+	Recording      string    `json:"recording,omitempty"` // file the session was recorded to
+}
+
+// Path returns the location of the run journal.
+var Path = func() string {
+	return statedir.Path("runs.jsonl")
+}
+
+// Append writes e as a new line at the end of the journal.
+func Append(e Entry) error {
+	p := Path()
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	data, err = crypt.SealLine(data)
+	if err != nil {
+		return err
+	}
+	unlock, err := fileutil.Lock(p)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// Read returns all entries in the journal, oldest first. A missing journal
+// returns no entries and no error. Lines that fail to parse, or are encrypted
+// and can't be decrypted, are skipped.
+func Read() ([]Entry, error) {
+	f, err := os.Open(Path())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line, ok := crypt.OpenLine(scanner.Bytes())
+		if !ok {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Remove deletes the entries for which match returns true and returns how
+// many were removed. Lines that can't be read, such as those encrypted to a
+// key that isn't available, are kept as they are.
+func Remove(match func(Entry) bool) (int, error) {
+	p := Path()
+	unlock, err := fileutil.Lock(p)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	data, err := os.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	var kept []byte
+	removed := 0
+	for _, raw := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(bytes.TrimSpace(raw)) == 0 {
+			continue
+		}
+		if line, ok := crypt.OpenLine(bytes.TrimSuffix(raw, []byte("\n"))); ok {
+			var e Entry
+			if err := json.Unmarshal(line, &e); err == nil && match(e) {
+				removed++
+				continue
+			}
+		}
+		kept = append(kept, raw...)
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, fileutil.WriteFile(p, kept, 0o600)
+}
+
+// Last returns the most recent entry for tool, or false if there is none.
+func Last(entries []Entry, tool string) (Entry, bool) {
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Tool == tool {
+			return entries[i], true
+		}
+	}
+	return Entry{}, false
+}
@@ -0,0 +1,47 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "runs.jsonl")
+	orig := Path
+	Path = func() string { return path }
+	t.Cleanup(func() { Path = orig })
+
+	for _, c := range []string{"app-1", "app-2", "app-1"} {
+		if err := Append(Entry{Tool: "claude", Container: c}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Lines that can't be read are kept
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("unreadable\n")
+	f.Close()
+
+	n, err := Remove(func(e Entry) bool { return e.Container == "app-1" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("removed %d entries, want 2", n)
+	}
+	entries, err := Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Container != "app-2" {
+		t.Errorf("unexpected entries left: %+v", entries)
+	}
+	data, _ := os.ReadFile(path)
+	if !strings.HasSuffix(string(data), "unreadable\n") {
+		t.Errorf("expected unreadable line to be kept, got %q", data)
+	}
+}
@@ -1,34 +1,82 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"maps"
 	"os"
 	"os/exec"
+	"os/signal"
+	"os/user"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/adrg/xdg"
 	"github.com/charmbracelet/huh"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/dustin/go-humanize"
 	"github.com/leighmcculloch/silo/backend"
 	applecontainer "github.com/leighmcculloch/silo/backend/container"
 	"github.com/leighmcculloch/silo/backend/docker"
+	"github.com/leighmcculloch/silo/backend/sandbox"
 	"github.com/leighmcculloch/silo/cli"
+	"github.com/leighmcculloch/silo/completion"
 	"github.com/leighmcculloch/silo/config"
+	"github.com/leighmcculloch/silo/configdoctor"
+	"github.com/leighmcculloch/silo/configinit"
 	"github.com/leighmcculloch/silo/configshow"
+	"github.com/leighmcculloch/silo/configvalidate"
+	"github.com/leighmcculloch/silo/crypt"
+	"github.com/leighmcculloch/silo/docs"
+	"github.com/leighmcculloch/silo/events"
+	"github.com/leighmcculloch/silo/fileutil"
+	"github.com/leighmcculloch/silo/git"
+	"github.com/leighmcculloch/silo/journal"
+	"github.com/leighmcculloch/silo/mountwait"
+	"github.com/leighmcculloch/silo/preset"
+	"github.com/leighmcculloch/silo/prune"
+	"github.com/leighmcculloch/silo/queue"
+	"github.com/leighmcculloch/silo/recording"
 	"github.com/leighmcculloch/silo/run"
+	"github.com/leighmcculloch/silo/secrets"
+	"github.com/leighmcculloch/silo/selfupdate"
+	"github.com/leighmcculloch/silo/statedir"
+	"github.com/leighmcculloch/silo/stats"
+	"github.com/leighmcculloch/silo/termstate"
+	"github.com/leighmcculloch/silo/tilde"
+	"github.com/leighmcculloch/silo/tmpl"
 	"github.com/leighmcculloch/silo/tools"
 	"github.com/leighmcculloch/silo/tools/claudecode"
 	"github.com/leighmcculloch/silo/tools/copilotcli"
 	"github.com/leighmcculloch/silo/tools/opencode"
+	"github.com/mattn/go-isatty"
+	"github.com/moby/term"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 var (
+	// version, commit, and date are set at release with -ldflags "-X
+	// main.version=...". When unset, main fills them in from the module and
+	// VCS info Go embeds in the binary.
 	version = "dev"
+	commit  = ""
+	date    = ""
 
 	// supportedTools is the single source of truth for which tools silo
 	// supports. To add a tool: create tools/<name>/, define its Tool, and
@@ -56,24 +104,47 @@ func findTool(name string) *tools.Tool {
 }
 
 func main() {
-	os.Exit(runMain(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+	version, commit, date = buildVersion()
+	termstate.HandleSignals()
+	code := runMain(os.Args[1:], os.Stdin, os.Stdout, os.Stderr)
+	termstate.RestoreAll()
+	os.Exit(code)
 }
 
 // runMain is the main entry point that can be called by tests
 func runMain(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	defer termstate.Guard()
 	rootCmd := newRootCmd(stdout, stderr)
 	rootCmd.SetArgs(args)
 	rootCmd.SetIn(stdin)
 	rootCmd.SetOut(stdout)
 	rootCmd.SetErr(stderr)
 
+	defer cli.SetLogFile(nil)
+	defer events.Set(nil)
+
 	if err := rootCmd.Execute(); err != nil {
+		// Exit with the tool's exit code, so scripts see the same status as
+		// running the tool directly. The tool has reported why it failed.
+		var exitErr *backend.ExitError
+		if errors.As(err, &exitErr) {
+			return toolExitCode(exitErr.Code)
+		}
 		cli.LogErrorTo(stderr, "%v", err)
 		return 1
 	}
 	return 0
 }
 
+// toolExitCode returns the exit code of silo for a tool that exited with code,
+// which is code unless it isn't a valid exit code.
+func toolExitCode(code int) int {
+	if code < 1 || code > 255 {
+		return 1
+	}
+	return code
+}
+
 func newRootCmd(stdout, stderr io.Writer) *cobra.Command {
 	rootCmd := &cobra.Command{
 		Use:   "silo",
@@ -111,14 +182,61 @@ Configuration is loaded from (in order, merged):
   silo claude -- --help`,
 		SilenceUsage:  true,
 		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := setUserEnv(cmd); err != nil {
+				return err
+			}
+			cfg := config.LoadAll(toolDefaults())
+			a11y, _ := cmd.Flags().GetBool("a11y")
+			cli.SetAccessible(a11y || cfg.A11y)
+			if err := setLogging(cmd, stderr); err != nil {
+				return err
+			}
+			if err := setEvents(cmd); err != nil {
+				return err
+			}
+			statedir.Set(cfg.StateDir)
+			// A bad key must not stop the user running silo config edit to
+			// fix it. Nothing is written unencrypted until it is fixed.
+			if err := crypt.Configure(cfg.EncryptRecipients, cfg.EncryptIdentity); err != nil {
+				cli.LogWarningTo(stderr, "%v, run history will not be recorded", err)
+			}
+			return nil
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runSilo(cmd, args, stdout, stderr)
 		},
 	}
 
-	rootCmd.Flags().String("backend", "", "Backend to use: docker, container")
+	rootCmd.PersistentFlags().Bool("a11y", false, "Screen-reader friendly output: no animation or color, status as plain sentences")
+	rootCmd.PersistentFlags().String("log-level", "info", "Least severe messages to show: debug, info, warn, error")
+	rootCmd.PersistentFlags().Bool("log-json", false, "Write messages as JSON lines with their time and level")
+	rootCmd.PersistentFlags().String("log-file", "", "Also append every message, debug ones too, to this file as JSON lines")
+	rootCmd.PersistentFlags().Int("events-fd", 0, "Write JSON events of the run's progress to this open file descriptor, e.g. 3")
+	rootCmd.PersistentFlags().String("events-file", "", "Append JSON events of the run's progress to this file or named pipe")
+	rootCmd.MarkFlagsMutuallyExclusive("events-fd", "events-file")
+
+	rootCmd.Flags().String("backend", "", "Backend to use: docker, container, sandbox")
 	rootCmd.Flags().Bool("force-build", false, "Force rebuild of container image, ignoring cache")
+	rootCmd.Flags().Bool("offline", false, "Never use the network to prepare the run, using images from silo prefetch")
 	rootCmd.Flags().BoolP("verbose", "v", false, "Show detailed output instead of progress bar")
+	rootCmd.Flags().Bool("keep", false, "Keep the container after it exits so it can be restarted with silo start")
+	rootCmd.Flags().BoolP("detach", "d", false, "Run the tool in the background (see silo ps and silo logs)")
+	rootCmd.Flags().Bool("record", false, "Record the session's output to play back with silo replay")
+	rootCmd.Flags().Bool("no-tty", false, "Run without a TTY, piping stdin to the tool and its stdout and stderr back (default when stdin or stdout isn't a terminal)")
+	rootCmd.Flags().String("worktree", "", "Run in a git worktree for this branch, creating it if needed")
+	rootCmd.Flags().Bool("branch-sandbox", false, "Run on a new silo/<tool>-<date>-N branch in its own worktree (see silo merge)")
+	rootCmd.Flags().String("profile", "", "Sandbox profile (strict, standard, permissive) or a profile from the profiles of the config")
+	rootCmd.Flags().String("platform", "", "Platform to build and run the image for, e.g. linux/amd64 (default: from config)")
+	rootCmd.Flags().String("home", "", "Home directory of the host user (default: $HOME)")
+	rootCmd.Flags().String("user", "", "Name of the host user (default: $USER)")
+	addOverrideFlags(rootCmd)
+	rootCmd.Flags().Bool("dry-run", false, "Print the Dockerfile, mounts, environment, and command of the run without running it")
+	rootCmd.MarkFlagsMutuallyExclusive("dry-run", "worktree", "branch-sandbox")
+	rootCmd.MarkFlagsMutuallyExclusive("record", "detach")
+	rootCmd.MarkFlagsMutuallyExclusive("no-tty", "detach")
+	rootCmd.MarkFlagsMutuallyExclusive("no-tty", "keep")
+	rootCmd.MarkFlagsMutuallyExclusive("offline", "force-build")
 
 	// Define command groups (order here determines display order in --help)
 	rootCmd.AddGroup(
@@ -139,9 +257,27 @@ Configuration is loaded from (in order, merged):
 				return runTool(cmd, toolDef, args, stdout, stderr)
 			},
 		}
-		toolCmd.Flags().String("backend", "", "Backend to use: docker, container")
+		toolCmd.Flags().String("backend", "", "Backend to use: docker, container, sandbox")
 		toolCmd.Flags().Bool("force-build", false, "Force rebuild of container image, ignoring cache")
+		toolCmd.Flags().Bool("offline", false, "Never use the network to prepare the run, using images from silo prefetch")
 		toolCmd.Flags().BoolP("verbose", "v", false, "Show detailed output instead of progress bar")
+		toolCmd.Flags().Bool("keep", false, "Keep the container after it exits so it can be restarted with silo start")
+		toolCmd.Flags().BoolP("detach", "d", false, "Run the tool in the background (see silo ps and silo logs)")
+		toolCmd.Flags().Bool("record", false, "Record the session's output to play back with silo replay")
+		toolCmd.Flags().Bool("no-tty", false, "Run without a TTY, piping stdin to the tool and its stdout and stderr back (default when stdin or stdout isn't a terminal)")
+		toolCmd.Flags().String("worktree", "", "Run in a git worktree for this branch, creating it if needed")
+		toolCmd.Flags().Bool("branch-sandbox", false, "Run on a new silo/<tool>-<date>-N branch in its own worktree (see silo merge)")
+		toolCmd.Flags().String("profile", "", "Sandbox profile (strict, standard, permissive) or a profile from the profiles of the config")
+		toolCmd.Flags().String("platform", "", "Platform to build and run the image for, e.g. linux/amd64 (default: from config)")
+		toolCmd.Flags().String("home", "", "Home directory of the host user (default: $HOME)")
+		toolCmd.Flags().String("user", "", "Name of the host user (default: $USER)")
+		addOverrideFlags(toolCmd)
+		toolCmd.Flags().Bool("dry-run", false, "Print the Dockerfile, mounts, environment, and command of the run without running it")
+		toolCmd.MarkFlagsMutuallyExclusive("dry-run", "worktree", "branch-sandbox")
+		toolCmd.MarkFlagsMutuallyExclusive("record", "detach")
+		toolCmd.MarkFlagsMutuallyExclusive("no-tty", "detach")
+		toolCmd.MarkFlagsMutuallyExclusive("no-tty", "keep")
+		toolCmd.MarkFlagsMutuallyExclusive("offline", "force-build")
 		rootCmd.AddCommand(toolCmd)
 	}
 
@@ -154,10 +290,22 @@ Configuration is loaded from (in order, merged):
 	configShowCmd := &cobra.Command{
 		Use:   "show",
 		Short: "Show the current merged configuration",
+		Long: `Show the current merged configuration.
+
+The default jsonc format annotates each value with the file it came from. The
+json format prints the merged configuration without annotations, for scripts.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			format, err := formatFlag(cmd, "jsonc", "json")
+			if err != nil {
+				return err
+			}
+			if format == "json" {
+				return configshow.ShowJSON(stdout, toolDefaults())
+			}
 			return configshow.Show(stdout, toolDefaults())
 		},
 	}
+	configShowCmd.Flags().String("format", "jsonc", "Output format: jsonc, json")
 
 	configPathsCmd := &cobra.Command{
 		Use:   "paths",
@@ -183,21 +331,64 @@ Configuration is loaded from (in order, merged):
 		},
 	}
 
+	configValidateCmd := &cobra.Command{
+		Use:   "validate [file...]",
+		Short: "Check config files for mistakes",
+		Long: `Check config files against the config schema and report each problem with
+its file, line, and column. Unknown keys, invalid values, unknown tool names,
+and malformed env entries are errors. Mount paths that don't exist are
+warnings, as silo skips them.
+
+With no arguments, every config file in the merge chain is checked (see silo
+config paths). Exits non-zero if any errors are found.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runConfigValidate(args, stderr)
+		},
+	}
+
+	configDoctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Look for settings that likely don't do what was intended",
+		Long: `Check the merged config for settings that are valid but likely don't do what
+was intended:
+
+  - env entries that never match a host variable because of their case
+  - mounts under ~/Desktop, ~/Documents, ~/Downloads, or iCloud Drive that
+    macOS hasn't allowed this terminal to access
+  - hooks that run commands not installed in the image
+  - the default tool set differently in more than one config file
+
+Everything is checked locally; nothing is sent anywhere. Use silo config
+validate to check each file against the schema. Exits non-zero if anything
+is found.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runConfigDoctor(stderr)
+		},
+	}
+
 	configInitCmd := &cobra.Command{
 		Use:   "init",
 		Short: "Create a sample configuration file",
 		Long: `Create a sample silo configuration file.
 
 By default, an interactive prompt lets you choose between local and global config.
-Use --local or --global to skip the prompt.`,
+Use --local or --global to skip the prompt.
+
+If the config file already exists, the sample sections it is missing can be
+appended as commented templates, leaving existing content and comments intact.
+You are prompted to confirm when running interactively; use --merge to skip
+the prompt.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			globalFlag, _ := cmd.Flags().GetBool("global")
 			localFlag, _ := cmd.Flags().GetBool("local")
-			return runInit(cmd, args, stderr, globalFlag, localFlag)
+			mergeFlag, _ := cmd.Flags().GetBool("merge")
+			return runInit(cmd, args, stderr, globalFlag, localFlag, mergeFlag)
 		},
 	}
 	configInitCmd.Flags().BoolP("global", "g", false, "Create global config (~/.config/silo/silo.jsonc)")
 	configInitCmd.Flags().BoolP("local", "l", false, "Create local config (silo.jsonc)")
+	configInitCmd.Flags().Bool("merge", false, "Append missing sample sections to an existing config without prompting")
 	configInitCmd.MarkFlagsMutuallyExclusive("global", "local")
 
 	configCmd.AddCommand(configShowCmd)
@@ -205,33 +396,599 @@ Use --local or --global to skip the prompt.`,
 	configCmd.AddCommand(configEditCmd)
 	configCmd.AddCommand(configDefaultCmd)
 	configCmd.AddCommand(configInitCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configDoctorCmd)
 
 	rootCmd.AddCommand(configCmd)
 
+	presetCmd := &cobra.Command{
+		Use:     "preset",
+		Short:   "Manage presets shared by others",
+		GroupID: "config",
+		Long: `Manage presets: config fragments (tool settings, hooks, mounts, and
+optionally a Dockerfile) published as a git repository with a preset.jsonc at
+its root, or as a single .jsonc file.
+
+Installed presets are used by listing their names in a config file's
+"presets". They are merged before that file, so it can extend or override
+them.`,
+	}
+
+	presetAddCmd := &cobra.Command{
+		Use:   "add <url>",
+		Short: "Install a preset from a git repository or URL",
+		Long: `Install a preset from a git repository, or a URL ending in .json or .jsonc.
+
+The installed files are hashed. Pass --sha256 to refuse a preset whose files
+don't match a known hash, and --ref to pin a git tag or commit. Presets whose
+files change after they are installed are not used.`,
+		Example: `  silo preset add https://github.com/example/silo-rust-dev --ref v1.0.0
+  silo preset add https://example.com/presets/go.jsonc --sha256 3f2a...`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPresetAdd(cmd, args[0], stderr)
+		},
+	}
+	presetAddCmd.Flags().String("name", "", "Name to install the preset as (default: last element of the URL)")
+	presetAddCmd.Flags().String("ref", "", "Git branch, tag, or commit to install")
+	presetAddCmd.Flags().String("sha256", "", "Expected hash of the preset's files")
+	presetAddCmd.Flags().Bool("force", false, "Replace an installed preset of the same name")
+
+	presetLsCmd := &cobra.Command{
+		Use:   "ls",
+		Short: "List installed presets",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPresetList(stdout, stderr)
+		},
+	}
+
+	presetRmCmd := &cobra.Command{
+		Use:   "rm <name...>",
+		Short: "Uninstall presets",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, name := range args {
+				if err := preset.Remove(name); err != nil {
+					return err
+				}
+				cli.LogTo(stderr, "Removed preset %s", name)
+			}
+			return nil
+		},
+	}
+
+	presetCmd.AddCommand(presetAddCmd)
+	presetCmd.AddCommand(presetLsCmd)
+	presetCmd.AddCommand(presetRmCmd)
+	rootCmd.AddCommand(presetCmd)
+
+	secretCmd := &cobra.Command{
+		Use:     "secret",
+		Short:   "Manage secrets stored in the keychain",
+		GroupID: "config",
+	}
+
+	secretSetCmd := &cobra.Command{
+		Use:   "set <service>",
+		Short: "Store the value of a keychain secret",
+		Long: `Prompt for a value and store it in the keychain under the service name, for
+use by a secret with "keychain" set to that name. On macOS the value is stored
+in the login Keychain, and elsewhere in the Secret Service (GNOME Keyring or
+KWallet) through libsecret's secret-tool. An existing value is replaced.
+
+The value is typed into the keychain tool directly, so it never appears in
+silo's config, your shell history, or a command line.`,
+		Example: `  silo secret set anthropic-api-key`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := secrets.Store(context.Background(), args[0], os.Stdin, stdout, stderr); err != nil {
+				return err
+			}
+			cli.LogSuccessTo(stderr, "Stored %s", args[0])
+			cli.LogDimTo(stderr, `Use it with: "secrets": { "NAME": { "keychain": %q } }`, args[0])
+			return nil
+		},
+	}
+
+	secretCmd.AddCommand(secretSetCmd)
+	rootCmd.AddCommand(secretCmd)
+
+	templateCmd := &cobra.Command{
+		Use:     "template",
+		Short:   "Help with templated config strings",
+		GroupID: "config",
+	}
+
+	templateVarsCmd := &cobra.Command{
+		Use:   "vars",
+		Short: "List the variables and functions of templated config strings",
+		Long: `List the variables and functions available to templated config strings,
+such as container_name, with the values the variables would have for a run in
+the current directory. Templates use Go template syntax, e.g.
+"{{.Repo}}-{{slug .Branch}}".`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runTemplateVars(cmd, stdout)
+		},
+	}
+	templateVarsCmd.Flags().String("tool", "", "Tool to show the variables for (default: from config)")
+
+	templateCmd.AddCommand(templateVarsCmd)
+	rootCmd.AddCommand(templateCmd)
+
+	envCmd := &cobra.Command{
+		Use:     "env [tool]",
+		Short:   "List the environment variables a tool would get",
+		GroupID: "config",
+		Long: `List the environment variables a run of a tool in the current directory
+would pass to the container, and why: where each is configured, and whether it
+is set in config, passed through from the host, not set on the host, or
+blocked by env_blocklist. Values are never printed.`,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: AvailableTools(supportedTools),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runEnv(cmd, args, stdout)
+		},
+	}
+	envCmd.Flags().String("profile", "", "Sandbox or config profile to list the variables for")
+	rootCmd.AddCommand(envCmd)
+
 	lsCmd := &cobra.Command{
 		Use:     "ls",
 		Short:   "List all silo-created containers",
 		GroupID: "container",
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runList(cmd, args, stdout, stderr)
+			return runList(cmd, args, stdout, stderr, false)
 		},
 	}
-	lsCmd.Flags().String("backend", "", "Backend to use: docker, container (default: both)")
+	lsCmd.Flags().String("backend", "", "Backend to use: docker, container, sandbox (default: all)")
 	lsCmd.Flags().BoolP("quiet", "q", false, "Only display container names")
+	lsCmd.Flags().String("format", "table", "Output format: table, json")
+	lsCmd.MarkFlagsMutuallyExclusive("quiet", "format")
 	rootCmd.AddCommand(lsCmd)
 
+	psCmd := &cobra.Command{
+		Use:     "ps",
+		Short:   "List running silo sessions",
+		GroupID: "container",
+		Long:    `List running silo containers, including sessions started in the background with --detach.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runList(cmd, args, stdout, stderr, true)
+		},
+	}
+	psCmd.Flags().String("backend", "", "Backend to use: docker, container, sandbox (default: all)")
+	psCmd.Flags().BoolP("quiet", "q", false, "Only display container names")
+	psCmd.Flags().String("format", "table", "Output format: table, json")
+	psCmd.MarkFlagsMutuallyExclusive("quiet", "format")
+	rootCmd.AddCommand(psCmd)
+
+	logsCmd := &cobra.Command{
+		Use:     "logs [container]",
+		Short:   "Show the output of a silo container",
+		GroupID: "container",
+		Long: `Show the terminal output of a silo container, such as a session started with --detach.
+
+With --build, show the full output of the last build of an image instead,
+given by its tag or tool, or of the latest build without one. It is kept in
+~/.local/state/silo/logs whether or not the build showed it.`,
+		Example: `  # Follow the output of a background session
+  silo logs -f silo-myproject-1
+
+  # Show why the last build of the claude image failed
+  silo logs --build claude`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if build, _ := cmd.Flags().GetBool("build"); build {
+				return cobra.MaximumNArgs(1)(cmd, args)
+			}
+			return cobra.ExactArgs(1)(cmd, args)
+		},
+		ValidArgsFunction: completeAllContainerNames,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if build, _ := cmd.Flags().GetBool("build"); build {
+				name := ""
+				if len(args) > 0 {
+					name = args[0]
+				}
+				path, err := run.FindBuildLog(name)
+				if err != nil {
+					return err
+				}
+				f, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				r, err := crypt.NewReader(f)
+				if err != nil {
+					return fmt.Errorf("failed to read %s: %w", tilde.Path(path), err)
+				}
+				_, err = io.Copy(stdout, r)
+				return err
+			}
+			follow, _ := cmd.Flags().GetBool("follow")
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return withContainer(cmd, args[0], func(b backend.Backend) error {
+				return b.Logs(ctx, args[0], follow, stdout)
+			})
+		},
+	}
+	logsCmd.Flags().String("backend", "", "Backend to use: docker, container, sandbox (default: all)")
+	logsCmd.Flags().BoolP("follow", "f", false, "Keep streaming output until the container exits")
+	logsCmd.Flags().Bool("build", false, "Show the output of the last build of an image or tool")
+	logsCmd.MarkFlagsMutuallyExclusive("build", "follow")
+	logsCmd.MarkFlagsMutuallyExclusive("build", "backend")
+	rootCmd.AddCommand(logsCmd)
+
+	replayCmd := &cobra.Command{
+		Use:     "replay [session]",
+		Short:   "Play back a session recorded with --record",
+		GroupID: "container",
+		Long: `Play back the output of a session recorded with --record, at the pace it was
+recorded, in the terminal. The session is a container name, which plays its
+latest recording, or the name of a recording. Without one, the recordings are
+listed.
+
+Recordings are asciicast v2 files in ~/.local/state/silo/recordings, which
+asciinema can also play and upload, unless encrypt_recipients is set.`,
+		Example: `  # List recordings
+  silo replay
+
+  # Play back the latest recording of a session, twice as fast, skipping pauses
+  silo replay silo-myproject-1 --speed 2 --idle-limit 2s`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeRecordings,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runReplay(cmd, args, stdout, stderr)
+		},
+	}
+	replayCmd.Flags().Float64("speed", 1, "Playback speed, e.g. 2 for twice as fast")
+	replayCmd.Flags().Duration("idle-limit", 0, "Shorten pauses in the output to at most this long, e.g. 2s (default: no limit)")
+	rootCmd.AddCommand(replayCmd)
+
 	rmCmd := &cobra.Command{
 		Use:     "rm [container...]",
 		Short:   "Remove silo containers",
 		GroupID: "container",
-		Args:    cobra.MinimumNArgs(1),
+		Long: `Remove silo containers.
+
+Containers can be named by glob patterns, such as 'myrepo-*', which only
+match silo containers. The matching containers are listed and removal is
+confirmed first, unless --yes is given.
+
+With --purge, everything else left behind by the sessions is removed too:
+images no other silo container uses, and the sessions' entries in the run
+history. Entries are matched by the session that created the container, so
+those of earlier containers of the same name are kept.`,
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: completeContainerList,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runRemove(cmd, args, stderr)
+			return runRemove(cmd, args, stdout, stderr)
 		},
 	}
-	rmCmd.Flags().String("backend", "", "Backend to use: docker, container (default: both)")
+	rmCmd.Flags().String("backend", "", "Backend to use: docker, container, sandbox (default: all)")
+	rmCmd.Flags().String("format", "table", "Output format: table, json")
+	rmCmd.Flags().Bool("purge", false, "Also remove unused images and run history of the containers")
+	rmCmd.Flags().BoolP("yes", "y", false, "Remove containers matching patterns without confirming")
 	rootCmd.AddCommand(rmCmd)
 
+	pruneCmd := &cobra.Command{
+		Use:     "prune",
+		Short:   "Remove stopped containers and stale images",
+		GroupID: "container",
+		Long: `Remove stale silo resources across backends and report the space reclaimed.
+
+Every change to hooks, build args, or a tool version builds a new image, so
+old images accumulate. Prune removes stopped containers and images that no
+kept container uses, keeping the most recently used image of each tool.
+Volumes are only removed with --volumes.
+
+With no type flags, containers and images are pruned.`,
+		Example: `  # Remove stopped containers and stale images
+  silo prune
+
+  # Only remove images not used for 30 days
+  silo prune --images --older-than 30d
+
+  # Show what would be removed
+  silo prune --dry-run`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runPrune(cmd, stdout, stderr)
+		},
+	}
+	pruneCmd.Flags().String("backend", "", "Backend to use: docker, container, sandbox (default: all)")
+	pruneCmd.Flags().Bool("containers", false, "Remove stopped containers")
+	pruneCmd.Flags().Bool("images", false, "Remove stale images")
+	pruneCmd.Flags().Bool("volumes", false, "Remove silo volumes")
+	pruneCmd.Flags().String("older-than", "", "Only remove resources unused for this long (e.g. 30d, 12h)")
+	pruneCmd.Flags().BoolP("dry-run", "n", false, "Show what would be removed without removing it")
+	rootCmd.AddCommand(pruneCmd)
+
+	fanoutCmd := &cobra.Command{
+		Use:     "fanout <branch>... [-- args...]",
+		Short:   "Run a tool in the background in a worktree per branch",
+		GroupID: "container",
+		Long: `Start one background session per branch, each in its own git worktree.
+
+Worktrees are created next to the repository (e.g., ../myrepo-feature-x) if
+they don't already exist, and branches that don't exist are created from the
+current HEAD. silo ls shows the branch each container is bound to.`,
+		Example: `  # Run three agents on three branches
+  silo fanout --tool claude fix-login add-tests refactor-db
+
+  # Pass the same arguments to each session
+  silo fanout fix-login add-tests -- --model opus`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runFanout(cmd, args, stdout, stderr)
+		},
+	}
+	fanoutCmd.Flags().String("tool", "", "Tool to run (default: from config or prompt)")
+	fanoutCmd.Flags().String("backend", "", "Backend to use: docker, container, sandbox")
+	fanoutCmd.Flags().Bool("force-build", false, "Force rebuild of container image, ignoring cache")
+	fanoutCmd.Flags().BoolP("verbose", "v", false, "Show detailed output instead of progress bar")
+	fanoutCmd.Flags().String("profile", "", "Sandbox profile (strict, standard, permissive) or a profile from the profiles of the config")
+	fanoutCmd.Flags().String("platform", "", "Platform to build and run the image for, e.g. linux/amd64 (default: from config)")
+	fanoutCmd.Flags().String("home", "", "Home directory of the host user (default: $HOME)")
+	fanoutCmd.Flags().String("user", "", "Name of the host user (default: $USER)")
+	addOverrideFlags(fanoutCmd)
+	rootCmd.AddCommand(fanoutCmd)
+
+	batchCmd := &cobra.Command{
+		Use:     "batch <tool> [-- args...]",
+		Short:   "Run a tool on a prompt without interaction",
+		GroupID: "container",
+		Long: `Run a tool once on a prompt, without a terminal or any interaction, and exit
+with the tool's exit status. Use it in CI jobs and scripts that run agents.
+
+The tool's output is shown and also written to the --output file, by default
+a new file in silo's state directory. With --timeout the session is stopped
+after that long, and silo exits with status 124.`,
+		Example: `  # Run a task with a time limit
+  silo batch claude --prompt-file task.md --timeout 30m
+
+  # Read the prompt from stdin and keep the output
+  echo "Fix the failing tests" | silo batch opencode --prompt-file - --output fix.log`,
+		Args:      cobra.MinimumNArgs(1),
+		ValidArgs: AvailableTools(supportedTools),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBatch(cmd, args, stdout, stderr)
+		},
+	}
+	addBatchFlags(batchCmd)
+	rootCmd.AddCommand(batchCmd)
+
+	queueCmd := &cobra.Command{
+		Use:     "queue",
+		Short:   "Queue batch sessions and run them a few at a time",
+		GroupID: "container",
+		Long: `Queue batch sessions, like those of silo batch, and run them with a limit on
+how many run at once, so many tasks can be handed off without running them
+all together.
+
+The queue is kept in silo's state directory and survives restarts. Each job
+runs in the directory it was added from, so give jobs in the same
+repository a --worktree or --branch-sandbox to keep them apart.`,
+		Example: `  # Queue three tasks, each on its own branch
+  silo queue add claude --prompt-file login.md --branch-sandbox
+  silo queue add claude --prompt-file tests.md --branch-sandbox
+  silo queue add opencode --prompt-file docs.md --branch-sandbox
+
+  # Run them, at most two at a time
+  silo queue start --parallel 2`,
+	}
+
+	queueAddCmd := &cobra.Command{
+		Use:   "add <tool> [-- args...]",
+		Short: "Add a batch session to the queue",
+		Long: `Add a batch session to the queue, to run in the current directory. It takes
+the flags and arguments of silo batch. A prompt from stdin is read now and
+kept with the job.`,
+		Args:      cobra.MinimumNArgs(1),
+		ValidArgs: AvailableTools(supportedTools),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runQueueAdd(cmd, args, stdout, stderr)
+		},
+	}
+	addBatchFlags(queueAddCmd)
+
+	queueStartCmd := &cobra.Command{
+		Use:   "start",
+		Short: "Run the queued sessions",
+		Long: `Run the queued sessions, at most --parallel at a time, until none are left.
+Jobs queued while it runs are picked up too. The output of each job is
+written to its log, shown by silo queue ls.
+
+Ctrl-C stops the running sessions and leaves the remaining jobs queued.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runQueueStart(cmd, stdout, stderr)
+		},
+	}
+	queueStartCmd.Flags().IntP("parallel", "p", 2, "Number of sessions to run at once")
+
+	queueLsCmd := &cobra.Command{
+		Use:   "ls",
+		Short: "List queued, running and finished jobs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runQueueList(stdout, stderr)
+		},
+	}
+
+	queueRmCmd := &cobra.Command{
+		Use:   "rm [id...]",
+		Short: "Remove jobs from the queue",
+		Long:  `Remove jobs, and their logs, from the queue. Running jobs can't be removed.`,
+		Example: `  # Remove a job
+  silo queue rm 3
+
+  # Remove the jobs that have finished
+  silo queue rm --finished`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runQueueRemove(cmd, args, stdout, stderr)
+		},
+	}
+	queueRmCmd.Flags().Bool("finished", false, "Remove the jobs that have finished")
+
+	queueCmd.AddCommand(queueAddCmd)
+	queueCmd.AddCommand(queueStartCmd)
+	queueCmd.AddCommand(queueLsCmd)
+	queueCmd.AddCommand(queueRmCmd)
+	rootCmd.AddCommand(queueCmd)
+
+	buildCmd := &cobra.Command{
+		Use:     "build [tool]",
+		Short:   "Build the image for a tool without running it",
+		GroupID: "container",
+		Long: `Build the image a tool runs in for the current directory, without running
+anything, and print its tag. Cached images are reused unless --force-build
+is given.
+
+Use it in CI, or to warm the cache before going offline. Images depend on
+the config that applies to the current directory, including repository
+specific post-build hooks.`,
+		Example: `  # Build the default tool's image
+  silo build
+
+  # Rebuild every tool's image
+  silo build --all --force-build`,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: AvailableTools(supportedTools),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBuild(cmd, args, run.Build, stdout, stderr)
+		},
+	}
+	buildCmd.Flags().Bool("all", false, "Build the images of all tools")
+	buildCmd.Flags().String("backend", "", "Backend to use: docker, container, sandbox")
+	buildCmd.Flags().Bool("force-build", false, "Force rebuild of container image, ignoring cache")
+	buildCmd.Flags().BoolP("verbose", "v", false, "Show detailed output instead of progress bar")
+	buildCmd.Flags().String("platform", "", "Platform to build the image for, e.g. linux/amd64 (default: from config)")
+	addBuildCacheFlags(buildCmd)
+	rootCmd.AddCommand(buildCmd)
+
+	prefetchCmd := &cobra.Command{
+		Use:     "prefetch [tool]",
+		Short:   "Fetch everything a tool needs so it can run with --offline",
+		GroupID: "container",
+		Long: `Fetch everything a run of a tool in the current directory needs from the
+network: the latest release of the tool is looked up, its image is built,
+pulling the base image and installing the tool, and the images of the
+services that apply are pulled.
+
+Runs with --offline then never use the network to prepare the run. They
+fail if the image or a service's image is missing, rather than building or
+pulling it, and don't check for new releases of the tool. The tool itself
+still uses the network it is given.`,
+		Example: `  # Prepare to run claude without a network
+  silo prefetch claude
+  silo claude --offline
+
+  # Prepare every tool
+  silo prefetch --all`,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: AvailableTools(supportedTools),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBuild(cmd, args, run.Prefetch, stdout, stderr)
+		},
+	}
+	prefetchCmd.Flags().Bool("all", false, "Fetch what all tools need")
+	prefetchCmd.Flags().String("backend", "", "Backend to use: docker, container, sandbox")
+	prefetchCmd.Flags().Bool("force-build", false, "Force rebuild of container image, ignoring cache")
+	prefetchCmd.Flags().BoolP("verbose", "v", false, "Show detailed output instead of progress bar")
+	prefetchCmd.Flags().String("platform", "", "Platform to build the image for, e.g. linux/amd64 (default: from config)")
+	addBuildCacheFlags(prefetchCmd)
+	rootCmd.AddCommand(prefetchCmd)
+
+	imageCmd := &cobra.Command{
+		Use:     "image",
+		Short:   "Share images through a registry",
+		GroupID: "container",
+		Long: `Push and pull the images tools run in, so a team can build them once and
+share them. The registry repository defaults to the image_registry config
+setting, and authentication comes from the Docker CLI config and its
+credential helpers (docker login).
+
+With image_registry set, images are built for a common user and tagged with
+a hash of everything else they are built from, so they're shared between
+users. A small layer built locally on top makes the image the user's. With
+"pull_policy": "if-not-present" a missing image is pulled before it is
+built.
+
+An image's digest is recorded when it's first pulled or pushed, and later
+pulls are of that digest, so an image replaced in the registry under the
+same tag isn't used. Images aren't signed: the first pull trusts the
+registry.`,
+	}
+
+	imagePushCmd := &cobra.Command{
+		Use:     "push [registry/repo]",
+		Short:   "Push a tool's built image to a registry",
+		Example: `  silo build claude && silo image push ghcr.io/myorg/silo --tool claude`,
+		Args:    cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runImageShare(cmd, args, run.PushImage, "Pushed", stdout, stderr)
+		},
+	}
+
+	imagePullCmd := &cobra.Command{
+		Use:     "pull [registry/repo]",
+		Short:   "Pull a tool's image from a registry instead of building it",
+		Example: `  silo image pull ghcr.io/myorg/silo --all`,
+		Args:    cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runImageShare(cmd, args, run.PullImage, "Pulled", stdout, stderr)
+		},
+	}
+
+	for _, c := range []*cobra.Command{imagePushCmd, imagePullCmd} {
+		c.Flags().String("tool", "", "Tool whose image to transfer (default: the default tool)")
+		c.Flags().Bool("all", false, "Transfer the images of all tools")
+		c.Flags().String("backend", "", "Backend to use: docker, container, sandbox")
+		c.Flags().String("platform", "", "Platform of the image, e.g. linux/amd64 (default: from config)")
+	}
+	imageCmd.AddCommand(imagePushCmd)
+	imageCmd.AddCommand(imagePullCmd)
+	rootCmd.AddCommand(imageCmd)
+
+	volumeCmd := &cobra.Command{
+		Use:     "volume",
+		Short:   "Manage cache volumes",
+		GroupID: "container",
+		Long: `Manage the volumes created for cache_volumes. They persist across sessions so
+caches such as downloaded Go modules survive, and are created the first time
+a session mounts them.`,
+	}
+
+	volumeLsCmd := &cobra.Command{
+		Use:   "ls",
+		Short: "List cache volumes",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runVolumeList(cmd, stdout, stderr)
+		},
+	}
+	volumeLsCmd.Flags().String("backend", "", "Only list volumes of this backend: docker, container, sandbox")
+
+	volumeRmCmd := &cobra.Command{
+		Use:   "rm <name...>",
+		Short: "Remove cache volumes",
+		Long: `Remove cache volumes by their cache_volumes name, from every backend that has
+them. Volumes mounted by a container can't be removed until it is removed.`,
+		Example: `  silo volume rm gomod`,
+		Args:    cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runVolumeRemove(cmd, args, stdout, stderr)
+		},
+	}
+	volumeRmCmd.Flags().String("backend", "", "Only remove volumes of this backend: docker, container, sandbox")
+
+	volumeCmd.AddCommand(volumeLsCmd)
+	volumeCmd.AddCommand(volumeRmCmd)
+	rootCmd.AddCommand(volumeCmd)
+
 	execCmd := &cobra.Command{
 		Use:     "exec [container] [command] [args...]",
 		Short:   "Run a command in a running silo container",
@@ -248,7 +1005,7 @@ Use --local or --global to skip the prompt.`,
 			return runExec(cmd, args[0], args[1:], stderr)
 		},
 	}
-	execCmd.Flags().String("backend", "", "Backend to use: docker, container (default: both)")
+	execCmd.Flags().String("backend", "", "Backend to use: docker, container, sandbox (default: all)")
 	rootCmd.AddCommand(execCmd)
 
 	shellCmd := &cobra.Command{
@@ -263,246 +1020,1504 @@ Use --local or --global to skip the prompt.`,
 			return runExec(cmd, args[0], []string{"/bin/bash"}, stderr)
 		},
 	}
-	shellCmd.Flags().String("backend", "", "Backend to use: docker, container (default: both)")
+	shellCmd.Flags().String("backend", "", "Backend to use: docker, container, sandbox (default: all)")
 	rootCmd.AddCommand(shellCmd)
 
-	rootCmd.Version = version
-	rootCmd.SetVersionTemplate("silo version {{.Version}}\n")
-
-	return rootCmd
-}
-
-func runSilo(cmd *cobra.Command, args []string, stdout, stderr io.Writer) error {
-	// Load configuration
-	cfg := config.LoadAll(toolDefaults())
+	stopCmd := &cobra.Command{
+		Use:               "stop [container...]",
+		Short:             "Stop running silo containers",
+		GroupID:           "container",
+		Long:              `Stop running silo containers without removing them. Containers started with --keep can be restarted with silo start.`,
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: completeRunningContainerList,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, name := range args {
+				if err := withContainer(cmd, name, func(b backend.Backend) error {
+					return b.Stop(context.Background(), name)
+				}); err != nil {
+					return err
+				}
+				cli.LogSuccessTo(stderr, "Stopped %s", name)
+			}
+			return nil
+		},
+	}
+	stopCmd.Flags().String("backend", "", "Backend to use: docker, container, sandbox (default: all)")
+	rootCmd.AddCommand(stopCmd)
 
-	// Get cwd for repo matching
-	cwd, _ := os.Getwd()
+	killCmd := &cobra.Command{
+		Use:     "kill [container...]",
+		Short:   "Kill running silo containers straight away",
+		GroupID: "container",
+		Long: `Kill running silo containers straight away, without giving the tool time to
+exit as silo stop does. Use it when a session doesn't stop, such as when the
+tool ignores its stop signal.`,
+		Args:              cobra.MinimumNArgs(1),
+		ValidArgsFunction: completeRunningContainerList,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, name := range args {
+				if err := withContainer(cmd, name, func(b backend.Backend) error {
+					return b.Kill(context.Background(), name)
+				}); err != nil {
+					return err
+				}
+				cli.LogSuccessTo(stderr, "Killed %s", name)
+			}
+			return nil
+		},
+	}
+	killCmd.Flags().String("backend", "", "Backend to use: docker, container, sandbox (default: all)")
+	rootCmd.AddCommand(killCmd)
 
-	// Determine tool (priority: repo config > global config > interactive)
-	var tool string
-	var err error
+	startCmd := &cobra.Command{
+		Use:     "start [container]",
+		Short:   "Start a stopped silo container",
+		GroupID: "container",
+		Long: `Start a silo container that was kept with --keep and has since stopped.
 
-	// Check repo-specific tool setting (applied in specificity order)
-	for _, m := range run.GetMatchingRepos(cfg, cwd) {
-		if m.Config.Tool != "" {
-			tool = m.Config.Tool
-		}
-	}
-	// Fall back to global config tool
-	if tool == "" && cfg.Tool != "" {
-		tool = cfg.Tool
-	}
-	// Interactive selection as last resort
-	if tool == "" {
-		tool, err = selectTool()
-		if err != nil {
-			return err
-		}
+The tool is started again inside the same container, so files written
+outside of mounts are preserved. Use --attach to connect your terminal.`,
+		Example:           `  silo start --attach silo-myproject-1`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeStoppedContainerNames,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			attach, _ := cmd.Flags().GetBool("attach")
+			return withContainer(cmd, args[0], func(b backend.Backend) error {
+				warnVersionMismatch(b, args[0], stderr)
+				return b.Start(context.Background(), args[0], attach)
+			})
+		},
 	}
+	startCmd.Flags().String("backend", "", "Backend to use: docker, container, sandbox (default: all)")
+	startCmd.Flags().BoolP("attach", "a", false, "Attach the terminal to the container")
+	rootCmd.AddCommand(startCmd)
 
-	// Validate tool
-	validTools := AvailableTools(supportedTools)
-	if !slices.Contains(validTools, tool) {
-		return fmt.Errorf("invalid tool: %s (valid tools: %s)", tool, strings.Join(validTools, ", "))
+	attachCmd := &cobra.Command{
+		Use:               "attach [container]",
+		Short:             "Attach to a running silo container",
+		GroupID:           "container",
+		Long:              `Attach your terminal to the tool running in a silo container, for example after the terminal that started it was closed.`,
+		Example:           `  silo attach silo-myproject-1`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeContainerNames,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withContainer(cmd, args[0], func(b backend.Backend) error {
+				warnVersionMismatch(b, args[0], stderr)
+				return b.Attach(context.Background(), args[0])
+			})
+		},
 	}
+	attachCmd.Flags().String("backend", "", "Backend to use: docker, container, sandbox (default: all)")
+	rootCmd.AddCommand(attachCmd)
 
-	// Find tool definition
-	toolDef := findTool(tool)
-	if toolDef == nil {
-		return fmt.Errorf("tool definition not found: %s", tool)
+	backendsCmd := &cobra.Command{
+		Use:     "backends",
+		Short:   "List backends, their status, and supported features",
+		GroupID: "container",
+		Long: `List each backend, whether it is installed and reachable, its version, the
+silo features it supports, and which backend silo would use right now.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBackends(stdout, stderr)
+		},
 	}
+	rootCmd.AddCommand(backendsCmd)
 
-	// Override backend from flag
-	if b, _ := cmd.Flags().GetString("backend"); b != "" {
-		cfg.Backend = b
+	versionCmd := &cobra.Command{
+		Use:   "version",
+		Short: "Show the versions of silo and its components",
+		Long: `Show the version of silo and the commit and date it was built from, the
+versions of the backends, and hashes of the embedded Dockerfile template and
+config schema. Include the output in bug reports.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runVersion(cmd, stdout)
+		},
 	}
+	versionCmd.Flags().String("format", "text", "Output format: text, json")
+	rootCmd.AddCommand(versionCmd)
 
-	// Get force-build flag
-	forceBuild, _ := cmd.Flags().GetBool("force-build")
+	updateCmd := &cobra.Command{
+		Use:   "update",
+		Short: "Update silo to the latest release",
+		Long: `Update silo to its latest GitHub release. The release's archive for this
+platform is downloaded, verified against the release's checksums, whose
+signature is verified with silo's release key, and replaces the silo
+executable. Sessions that are running keep running.
 
-	// Get verbose flag
-	verbose, _ := cmd.Flags().GetBool("verbose")
+With --check nothing is installed: the latest release is compared with this
+version, and silo exits with status 1 if it is newer, for example to be
+notified of new releases in CI. silo installed with Homebrew is updated with
+brew instead.`,
+		Example: `  silo update
+  silo update --check`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runUpdate(cmd, stdout, stderr)
+		},
+	}
+	updateCmd.Flags().Bool("check", false, "Only report whether a newer release is available")
+	updateCmd.Flags().Bool("force", false, "Install the latest release even if it isn't newer")
+	rootCmd.AddCommand(updateCmd)
 
-	// Run the tool
-	return run.Tool(run.Options{
-		ToolDef:    *toolDef,
-		Config:     cfg,
-		Dockerfile: Dockerfile(supportedTools),
-		ForceBuild: forceBuild,
-		Verbose:    verbose,
-		Stdout:     stdout,
-		Stderr:     stderr,
-	})
+	dockerfileCmd := &cobra.Command{
+		Use:    "dockerfile",
+		Short:  "Print the embedded Dockerfile",
+		Hidden: true,
+		Long: `Print the embedded Dockerfile, or with --prebuilt-tag the tag its prebuilt
+base image is published under. Used to publish prebuilt images to the
+repository the registry config setting names:
+
+  docker buildx build --target base --platform linux/amd64,linux/arm64 \
+    -t "ghcr.io/myorg/silo:$(silo dockerfile --prebuilt-tag)" --push - < <(silo dockerfile)`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dockerfile := Dockerfile(supportedTools)
+			if tag, _ := cmd.Flags().GetBool("prebuilt-tag"); tag {
+				fmt.Fprintln(stdout, run.PrebuiltTag(dockerfile))
+				return nil
+			}
+			fmt.Fprint(stdout, dockerfile)
+			return nil
+		},
+	}
+	dockerfileCmd.Flags().Bool("prebuilt-tag", false, "Print the prebuilt base image tag instead")
+	rootCmd.AddCommand(dockerfileCmd)
+
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "Show past runs and what each had access to",
+		Long: `Show past runs from the run journal, most recent first.
+
+Each run records the tool, backend, image, working directory and its git
+remotes, container name, mounts, the names (never the values) of environment
+variables, hooks, exit code, and duration. The table shows a summary; use
+--format json for the full record.`,
+		Example: `  silo history
+  silo history --tool claude --since 7d
+  silo history --dir ~/code/project --format json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runHistory(cmd, stdout, stderr)
+		},
+	}
+	historyCmd.Flags().String("tool", "", "Only show runs of this tool")
+	historyCmd.Flags().String("dir", "", "Only show runs in this directory or below it")
+	historyCmd.Flags().String("since", "", "Only show runs within this long ago (e.g. 7d, 12h)")
+	historyCmd.Flags().IntP("limit", "n", 20, "Show at most this many runs (0 for all)")
+	historyCmd.Flags().String("format", "table", "Output format: table or json")
+	rootCmd.AddCommand(historyCmd)
+
+	reviewCmd := &cobra.Command{
+		Use:   "review [container]",
+		Short: "Keep, squash, or drop the commits of a session",
+		Long: `Review the commits made since a session started and choose, for each one,
+whether to keep it, squash it into the commit before it, or drop it. The
+branch is then rewritten with git rebase.
+
+Without a container name the latest session in the current directory is
+reviewed. Run it before merging or pushing the branch the session worked on,
+for example in a worktree created with --worktree.`,
+		Example: `  silo review
+  silo review silo-myproject-2`,
+		Args:              cobra.MaximumNArgs(1),
+		ValidArgsFunction: completeAllContainerNames,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runReview(args, stderr)
+		},
+	}
+	rootCmd.AddCommand(reviewCmd)
+
+	mergeCmd := &cobra.Command{
+		Use:   "merge <container>",
+		Short: "Merge the branch of a session into the current branch",
+		Long: `Merge the branch a session ran on, such as the branch of a session started
+with --branch-sandbox or --worktree, into the branch checked out in the
+current directory. If the merge stops on a conflict it is aborted and
+nothing is changed. Once a sandbox branch is merged, its worktree and the
+branch are removed.
+
+Run silo review in the session's worktree first to clean up its commits.`,
+		Example:           `  silo merge myproject-silo-claude-20260601-1-1`,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: completeAllContainerNames,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runMerge(args[0], stderr)
+		},
+	}
+	rootCmd.AddCommand(mergeCmd)
+
+	docsCmd := &cobra.Command{
+		Use:   "docs [topic]",
+		Short: "Read the documentation offline",
+		Long: `Read silo's documentation in the terminal, without network access. Without a
+topic the topics are listed. The configuration reference is generated from the
+settings this version of silo reads.
+
+Output is shown in $PAGER (default: less) when writing to a terminal.`,
+		Example: `  silo docs
+  silo docs config
+  silo docs security --no-pager`,
+		Args:      cobra.MaximumNArgs(1),
+		ValidArgs: docsTopicNames(),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDocs(cmd, args, stdout)
+		},
+	}
+	docsCmd.Flags().Bool("no-pager", false, "Write to stdout instead of the pager")
+	rootCmd.AddCommand(docsCmd)
+
+	statsCmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Local usage statistics",
+		Long: `Summarize past runs from the run journal by tool: the number of sessions,
+the time spent in them, how often the image had to be built, and how often
+an already built image was reused (the cache hit rate). Use --period to see
+how usage changes over time.
+
+Stats are computed locally from the journal; nothing is sent anywhere.`,
+		Example: `  silo stats
+  silo stats --since 30d --period week
+  silo stats --format json`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runStats(cmd, stdout, stderr)
+		},
+	}
+	statsCmd.Flags().String("since", "", "Only count runs within this long ago (e.g. 30d, 12h)")
+	statsCmd.Flags().String("period", "", "Summarize by period as well as tool: day, week, month")
+	statsCmd.Flags().String("format", "table", "Output format: table or json")
+
+	statsExportCmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export usage metrics",
+		Long: `Export a snapshot of usage metrics recorded from past runs.
+
+The prometheus format is compatible with node_exporter's textfile collector.
+When --output is given the file is replaced atomically so the collector never
+reads a partial file.`,
+		Example: `  silo stats export --format prometheus -o /var/lib/node_exporter/textfile/silo.prom`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runStatsExport(cmd, stdout)
+		},
+	}
+	statsExportCmd.Flags().String("format", "prometheus", "Output format: prometheus")
+	statsExportCmd.Flags().StringP("output", "o", "", "Write to a file instead of stdout")
+	statsCmd.AddCommand(statsExportCmd)
+	rootCmd.AddCommand(statsCmd)
+
+	// Extend cobra's default completion command with an install subcommand.
+	// The completion scripts capture the output writer when the command is
+	// created, so set it first.
+	rootCmd.SetOut(stdout)
+	rootCmd.InitDefaultCompletionCmd()
+	if completionCmd, _, err := rootCmd.Find([]string{"completion"}); err == nil {
+		completionInstallCmd := &cobra.Command{
+			Use:   "install",
+			Short: "Install the autocompletion script for your shell",
+			Long: `Detect your shell and install the silo completion script where the shell
+will load it automatically:
+
+  bash  ~/.local/share/bash-completion/completions/silo (sourced from ~/.bashrc)
+  zsh   $(brew --prefix)/share/zsh/site-functions/_silo, or ~/.zsh/completions/_silo
+  fish  ~/.config/fish/completions/silo.fish
+
+The installed script is then loaded in the shell to verify it works.`,
+			Args: cobra.NoArgs,
+			RunE: func(cmd *cobra.Command, args []string) error {
+				shell, _ := cmd.Flags().GetString("shell")
+				return completion.Install(rootCmd, shell, stderr)
+			},
+		}
+		completionInstallCmd.Flags().String("shell", "", "Shell to install for: bash, zsh, fish (default: detected from $SHELL)")
+		completionCmd.AddCommand(completionInstallCmd)
+	}
+
+	rootCmd.Version = version
+	rootCmd.SetVersionTemplate("silo version {{.Version}}\n")
+
+	return rootCmd
 }
 
-func runTool(cmd *cobra.Command, toolDef tools.Tool, args []string, stdout, stderr io.Writer) error {
+func runSilo(cmd *cobra.Command, args []string, stdout, stderr io.Writer) error {
 	// Load configuration
 	cfg := config.LoadAll(toolDefaults())
 
-	// Get tool-specific args (everything after --)
-	var toolArgs []string
-	if cmd.ArgsLenAtDash() > -1 {
-		toolArgs = args[cmd.ArgsLenAtDash():]
+	profile, _ := cmd.Flags().GetString("profile")
+	toolDef, err := chooseTool(cfg, "", profile)
+	if err != nil {
+		return err
 	}
 
 	// Override backend from flag
 	if b, _ := cmd.Flags().GetString("backend"); b != "" {
 		cfg.Backend = b
 	}
+	cfg = config.Merge(cfg, configOverrides(cmd))
 
 	// Get force-build flag
 	forceBuild, _ := cmd.Flags().GetBool("force-build")
+	offline, _ := cmd.Flags().GetBool("offline")
 
 	// Get verbose flag
 	verbose, _ := cmd.Flags().GetBool("verbose")
 
-	// Run the tool
-	return run.Tool(run.Options{
-		ToolDef:    toolDef,
-		ToolArgs:   toolArgs,
+	// Get keep and detach flags
+	keep, _ := cmd.Flags().GetBool("keep")
+	detach, _ := cmd.Flags().GetBool("detach")
+	record, _ := cmd.Flags().GetBool("record")
+	noTTY := runWithoutTTY(cmd, detach, keep)
+	platform, _ := cmd.Flags().GetString("platform")
+
+	// Switch to the worktree for --worktree or the branch sandbox
+	dir, labels, err := worktreeDir(cmd, cfg, toolDef.Name, stderr)
+	if err != nil {
+		return err
+	}
+
+	opts := run.Options{
+		ToolDef:    *toolDef,
 		Config:     cfg,
 		Dockerfile: Dockerfile(supportedTools),
+		Version:    version,
 		ForceBuild: forceBuild,
+		Offline:    offline,
+		Keep:       keep,
+		Detach:     detach,
+		Record:     record,
+		NoTTY:      noTTY,
+		Dir:        dir,
+		Labels:     labels,
+		Profile:    profile,
+		Platform:   platform,
 		Verbose:    verbose,
 		Stdout:     stdout,
 		Stderr:     stderr,
-	})
+	}
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		return run.Plan(opts)
+	}
+
+	// Run the tool
+	return run.Tool(opts)
 }
 
-func selectTool() (string, error) {
-	names := AvailableTools(supportedTools)
+// addBatchFlags adds the flags of silo batch to cmd.
+func addBatchFlags(cmd *cobra.Command) {
+	cmd.Flags().String("prompt", "", "Prompt to run the tool on")
+	cmd.Flags().String("prompt-file", "", "File to read the prompt from, - for stdin")
+	cmd.Flags().Duration("timeout", 0, "Stop the session after this long, e.g. 30m (default: no limit)")
+	cmd.Flags().String("output", "", "File to write the output to (default: a new file in the state directory)")
+	cmd.Flags().String("backend", "", "Backend to use: docker, container, sandbox")
+	cmd.Flags().Bool("force-build", false, "Force rebuild of container image, ignoring cache")
+	cmd.Flags().Bool("offline", false, "Never use the network to prepare the run, using images from silo prefetch")
+	cmd.Flags().BoolP("verbose", "v", false, "Show detailed output instead of progress bar")
+	cmd.Flags().String("profile", "", "Sandbox profile (strict, standard, permissive) or a profile from the profiles of the config")
+	cmd.Flags().String("platform", "", "Platform to build and run the image for, e.g. linux/amd64 (default: from config)")
+	cmd.Flags().String("worktree", "", "Run in a git worktree for this branch, creating it if needed")
+	cmd.Flags().Bool("branch-sandbox", false, "Run on a new silo/<tool>-<date>-N branch in its own worktree (see silo merge)")
+	cmd.MarkFlagsOneRequired("prompt", "prompt-file")
+	cmd.MarkFlagsMutuallyExclusive("prompt", "prompt-file")
+	cmd.MarkFlagsMutuallyExclusive("worktree", "branch-sandbox")
+	cmd.MarkFlagsMutuallyExclusive("offline", "force-build")
+	addOverrideFlags(cmd)
+}
 
-	var options []huh.Option[string]
-	for _, t := range names {
-		options = append(options, huh.NewOption(ToolDescription(supportedTools, t), t))
+func runBatch(cmd *cobra.Command, args []string, stdout, stderr io.Writer) error {
+	cfg := config.LoadAll(toolDefaults())
+
+	name, toolArgs, err := batchArgs(cmd, args)
+	if err != nil {
+		return err
+	}
+	profile, _ := cmd.Flags().GetString("profile")
+	toolDef, err := chooseTool(cfg, name, profile)
+	if err != nil {
+		return err
+	}
+	if toolDef.Headless == nil {
+		return fmt.Errorf("%s can't run without interaction", toolDef.Name)
 	}
 
-	var selected string
-	form := huh.NewForm(
-		huh.NewGroup(
-			huh.NewSelect[string]().
-				Title("Select AI Tool").
-				Description("Choose which AI coding assistant to run").
-				Options(options...).
-				Value(&selected),
-		),
-	)
+	prompt, _ := cmd.Flags().GetString("prompt")
+	if path, _ := cmd.Flags().GetString("prompt-file"); path != "" {
+		var data []byte
+		if path == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read prompt: %w", err)
+		}
+		prompt = string(data)
+	}
+	if strings.TrimSpace(prompt) == "" {
+		return fmt.Errorf("the prompt is empty")
+	}
 
-	if err := form.Run(); err != nil {
-		return "", fmt.Errorf("selection cancelled")
+	if b, _ := cmd.Flags().GetString("backend"); b != "" {
+		cfg.Backend = b
+	}
+	cfg = config.Merge(cfg, configOverrides(cmd))
+	forceBuild, _ := cmd.Flags().GetBool("force-build")
+	offline, _ := cmd.Flags().GetBool("offline")
+	verbose, _ := cmd.Flags().GetBool("verbose")
+	platform, _ := cmd.Flags().GetString("platform")
+	timeout, _ := cmd.Flags().GetDuration("timeout")
+
+	// Output kept under XDG state is encrypted like the rest of it, while
+	// a file asked for with --output is written as is
+	outputPath, _ := cmd.Flags().GetString("output")
+	encrypt := outputPath == ""
+	if encrypt {
+		outputPath = statedir.Path("batch", toolDef.Name+"-"+time.Now().Format("20060102-150405")+".log")
+	}
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0o700); err != nil {
+		return err
+	}
+	outputFile, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
 	}
+	var output io.WriteCloser = outputFile
+	if encrypt {
+		if output, err = crypt.NewWriter(outputFile); err != nil {
+			outputFile.Close()
+			return fmt.Errorf("failed to encrypt output file: %w", err)
+		}
+	}
+	defer output.Close()
 
-	return selected, nil
+	// Switch to the worktree for --worktree or the branch sandbox
+	dir, labels, err := worktreeDir(cmd, cfg, toolDef.Name, stderr)
+	if err != nil {
+		return err
+	}
+
+	// The prompt is the tool's only input, so it doesn't wait for more on
+	// stdin
+	if devNull, err := os.Open(os.DevNull); err == nil {
+		defer devNull.Close()
+		os.Stdin = devNull
+	}
+
+	err = run.Tool(run.Options{
+		ToolDef:    *toolDef,
+		ToolArgs:   slices.Concat(toolDef.Headless(prompt), toolArgs),
+		Config:     cfg,
+		Dockerfile: Dockerfile(supportedTools),
+		Version:    version,
+		ForceBuild: forceBuild,
+		Offline:    offline,
+		NoTTY:      true,
+		Timeout:    timeout,
+		Output:     output,
+		Dir:        dir,
+		Labels:     labels,
+		Profile:    profile,
+		Platform:   platform,
+		Verbose:    verbose,
+		Stdout:     stdout,
+		Stderr:     stderr,
+	})
+	var exitErr *backend.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		// The tool didn't run, so there is no output to keep
+		output.Close()
+		os.Remove(outputPath)
+		return err
+	}
+	if err := output.Close(); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	cli.LogDimTo(stderr, "Output: %s", tilde.Path(outputPath))
+	return err
 }
 
-func runConfigPaths(_ *cobra.Command, _ []string, stdout io.Writer) error {
-	paths := config.GetConfigPaths()
+// batchArgs returns the tool and tool arguments of silo batch, the tool
+// coming before -- and its arguments after.
+func batchArgs(cmd *cobra.Command, args []string) (string, []string, error) {
+	name := args[0]
+	switch dash := cmd.ArgsLenAtDash(); {
+	case dash == 0:
+		return "", nil, fmt.Errorf("no tool given")
+	case dash != 1 && len(args) > 1:
+		return "", nil, fmt.Errorf("unexpected arguments, pass arguments for %s after --", name)
+	}
+	return name, args[1:], nil
+}
 
-	for _, p := range paths {
-		if p.Exists {
-			fmt.Fprintln(stdout, p.Path)
+func runQueueAdd(cmd *cobra.Command, args []string, stdout, stderr io.Writer) error {
+	name, toolArgs, err := batchArgs(cmd, args)
+	if err != nil {
+		return err
+	}
+	if validTools := AvailableTools(supportedTools); !slices.Contains(validTools, name) {
+		return fmt.Errorf("invalid tool: %s (valid tools: %s)", name, strings.Join(validTools, ", "))
+	}
+
+	// Keep the flags given, for silo batch to parse when the job runs. A
+	// prompt from stdin won't be there then, so it's read now.
+	jobArgs := []string{name}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		switch v := f.Value.(type) {
+		case pflag.SliceValue:
+			for _, s := range v.GetSlice() {
+				jobArgs = append(jobArgs, "--"+f.Name+"="+s)
+			}
+		default:
+			if f.Name == "prompt-file" && v.String() == "-" {
+				var data []byte
+				if data, err = io.ReadAll(os.Stdin); err == nil {
+					jobArgs = append(jobArgs, "--prompt="+string(data))
+				}
+				return
+			}
+			jobArgs = append(jobArgs, "--"+f.Name+"="+v.String())
 		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read prompt: %w", err)
+	}
+	if len(toolArgs) > 0 {
+		jobArgs = append(append(jobArgs, "--"), toolArgs...)
 	}
 
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	job, err := queue.Add(cwd, jobArgs)
+	if err != nil {
+		return err
+	}
+	cli.LogSuccessTo(stderr, "Queued job %d, run the queue with: silo queue start", job.ID)
+	fmt.Fprintln(stdout, job.ID)
 	return nil
 }
 
-func runConfigEdit(_ *cobra.Command, _ []string, _, stderr io.Writer) error {
-	paths := config.GetConfigPaths()
+func runQueueStart(cmd *cobra.Command, stdout, stderr io.Writer) error {
+	parallel, _ := cmd.Flags().GetInt("parallel")
+	if parallel < 1 {
+		return fmt.Errorf("--parallel must be at least 1")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
 
-	// Build options for the selector:
-	// - Always include global config (first path)
-	// - Only include local configs that exist
-	var options []huh.Option[string]
-	for i, p := range paths {
-		isGlobal := i == 0
-		if !isGlobal && !p.Exists {
-			continue
+	// Ctrl-C reaches the running sessions from the terminal too, and each
+	// stops its container
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	interrupted := ctx.Done()
+
+	type result struct {
+		job      queue.Job
+		code     int
+		err      error
+		duration time.Duration
+	}
+	finished := make(chan result)
+	running, done, failed := 0, 0, 0
+	var claimErr error
+	for {
+		// Start jobs until the limit is reached or none are queued. If the
+		// queue can't be read, the running jobs are left to finish.
+		for running < parallel && ctx.Err() == nil && claimErr == nil {
+			var job queue.Job
+			var ok bool
+			job, ok, claimErr = queue.Claim(os.Getpid())
+			if !ok {
+				break
+			}
+			running++
+			cli.LogTo(stderr, "Started job %d: %s in %s (%s)", job.ID, job.Tool(), tilde.Path(job.Dir), queueProgress(running))
+			go func() {
+				start := time.Now()
+				code, err := runQueueJob(ctx, self, job)
+				finished <- result{job, code, err, time.Since(start)}
+			}()
 		}
-		label := p.Path
-		if !p.Exists {
-			label += " (new)"
+		if running == 0 {
+			break
 		}
-		options = append(options, huh.NewOption(label, p.Path))
-	}
 
-	var selectedPath string
-	form := huh.NewForm(
-		huh.NewGroup(
-			huh.NewSelect[string]().
-				Title("Select Config to Edit").
-				Description("Configs are merged in order shown (later overrides earlier)").
-				Options(options...).
-				Value(&selectedPath),
-		),
-	)
+		select {
+		case r := <-finished:
+			running--
+			if err := queue.Finish(r.job.ID, r.code, r.err); err != nil {
+				cli.LogWarningTo(stderr, "Failed to record job %d: %v", r.job.ID, err)
+			}
+			switch {
+			case r.err != nil:
+				failed++
+				cli.LogErrorTo(stderr, "Job %d failed: %v (%s)", r.job.ID, r.err, queueProgress(running))
+			case r.code != 0:
+				failed++
+				cli.LogErrorTo(stderr, "Job %d failed with status %d after %s, see %s (%s)", r.job.ID, r.code, r.duration.Round(time.Second), tilde.Path(r.job.Log()), queueProgress(running))
+			default:
+				done++
+				cli.LogSuccessTo(stderr, "Job %d finished after %s (%s)", r.job.ID, r.duration.Round(time.Second), queueProgress(running))
+			}
+		case <-interrupted:
+			interrupted = nil
+			cli.LogTo(stderr, "Stopping the running jobs, queued jobs stay queued")
+		}
+	}
 
-	if err := form.Run(); err != nil {
-		return fmt.Errorf("selection cancelled")
+	switch {
+	case claimErr != nil:
+		return claimErr
+	case done+failed == 0:
+		cli.LogTo(stderr, "No jobs queued")
+	case failed > 0:
+		return fmt.Errorf("%d of %d jobs failed", failed, done+failed)
+	default:
+		cli.LogSuccessTo(stderr, "All %d jobs finished", done)
 	}
+	return nil
+}
 
-	// Get editor from environment
-	editor := os.Getenv("EDITOR")
-	if editor == "" {
-		editor = os.Getenv("VISUAL")
+// runQueueJob runs job with the silo executable self, writing its output
+// to the job's log, encrypted if encrypt_recipients is set, and returns its
+// exit code.
+func runQueueJob(ctx context.Context, self string, job queue.Job) (int, error) {
+	f, err := os.OpenFile(job.Log(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return 0, err
 	}
-	if editor == "" {
-		editor = "vi"
+	log, err := crypt.NewWriter(f)
+	if err != nil {
+		f.Close()
+		return 0, err
 	}
+	defer log.Close()
 
-	// Ensure parent directory exists for new files
-	dir := filepath.Dir(selectedPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create directory: %w", err)
+	c := exec.CommandContext(ctx, self, append([]string{"batch"}, job.Args...)...)
+	c.Dir = job.Dir
+	c.Stdout = log
+	c.Stderr = log
+	// Stop the session gracefully, as silo does on Ctrl-C
+	c.Cancel = func() error {
+		return c.Process.Signal(syscall.SIGTERM)
 	}
+	err = c.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return backend.NewExitError(exitErr).Code, nil
+	}
+	return 0, err
+}
 
-	// If file doesn't exist, pre-fill with template
-	if _, err := os.Stat(selectedPath); os.IsNotExist(err) {
-		if err := os.WriteFile(selectedPath, []byte(sampleConfig), 0644); err != nil {
-			return fmt.Errorf("failed to create config: %w", err)
+// queueProgress describes how many jobs are running and queued.
+func queueProgress(running int) string {
+	queued := 0
+	if jobs, err := queue.List(); err == nil {
+		for _, j := range jobs {
+			if j.Status == queue.Queued {
+				queued++
+			}
 		}
 	}
+	return fmt.Sprintf("%d running, %d queued", running, queued)
+}
 
-	// Open editor
-	cmd := exec.Command(editor, selectedPath)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = stderr
+func runQueueList(stdout, stderr io.Writer) error {
+	jobs, err := queue.List()
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		cli.LogTo(stderr, "No jobs queued")
+		return nil
+	}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("editor failed: %w", err)
+	type jobRow struct{ id, status, tool, dir, added, log string }
+	var rows []jobRow
+	for _, j := range jobs {
+		status := string(j.Status)
+		if j.ExitCode != nil && *j.ExitCode != 0 {
+			status += fmt.Sprintf(" (%d)", *j.ExitCode)
+		}
+		var log string
+		if !j.Started.IsZero() {
+			log = tilde.Path(j.Log())
+		}
+		rows = append(rows, jobRow{strconv.Itoa(j.ID), status, j.Tool(), tilde.Path(j.Dir), humanize.Time(j.Added), log})
+	}
+	widths := []int{len("ID"), len("STATUS"), len("TOOL"), len("DIR"), len("ADDED")}
+	for _, r := range rows {
+		for i, s := range []string{r.id, r.status, r.tool, r.dir, r.added} {
+			widths[i] = max(widths[i], len(s))
+		}
+	}
+	format := fmt.Sprintf("%%-%ds  %%-%ds  %%-%ds  %%-%ds  %%-%ds  %%s\n", widths[0], widths[1], widths[2], widths[3], widths[4])
+	fmt.Fprintf(stdout, format, "ID", "STATUS", "TOOL", "DIR", "ADDED", "LOG")
+	for _, r := range rows {
+		fmt.Fprintf(stdout, format, r.id, r.status, r.tool, r.dir, r.added, r.log)
+	}
+	for _, j := range jobs {
+		if j.Error != "" {
+			cli.LogWarningTo(stderr, "Job %d: %s", j.ID, j.Error)
+		}
+	}
+	return nil
+}
+
+func runQueueRemove(cmd *cobra.Command, args []string, stdout, stderr io.Writer) error {
+	finished, _ := cmd.Flags().GetBool("finished")
+	if len(args) == 0 && !finished {
+		return fmt.Errorf("no jobs given, pass job IDs or --finished")
+	}
+	ids := map[int]bool{}
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("invalid job ID: %s", arg)
+		}
+		ids[id] = true
 	}
 
+	removed, err := queue.Remove(func(j queue.Job) bool {
+		return ids[j.ID] || finished && (j.Status == queue.Done || j.Status == queue.Failed)
+	})
+	if err != nil {
+		return err
+	}
+	for _, j := range removed {
+		delete(ids, j.ID)
+		fmt.Fprintln(stdout, j.ID)
+	}
+	for _, id := range slices.Sorted(maps.Keys(ids)) {
+		cli.LogWarningTo(stderr, "Job %d isn't in the queue or is running", id)
+	}
+	cli.LogSuccessTo(stderr, "Removed %d jobs", len(removed))
 	return nil
 }
 
-func runInit(_ *cobra.Command, _ []string, stderr io.Writer, globalFlag, localFlag bool) error {
-	var configType string
+// chooseTool returns the named tool, or if name is empty determines the tool
+// from the config profile, repo config, then global config, then an
+// interactive prompt.
+func chooseTool(cfg config.Config, name, profile string) (*tools.Tool, error) {
+	// Get cwd for repo matching
+	cwd, _ := os.Getwd()
 
-	// Determine config type from flags or interactive prompt
-	if globalFlag {
-		configType = "global"
-	} else if localFlag {
-		configType = "local"
-	} else {
-		// Interactive selection
-		form := huh.NewForm(
-			huh.NewGroup(
-				huh.NewSelect[string]().
-					Title("Create Configuration").
-					Description("Choose which configuration file to create").
-					Options(
-						huh.NewOption("Local (silo.jsonc in current directory)", "local"),
-						huh.NewOption("Global (~/.config/silo/silo.jsonc)", "global"),
-					).
-					Value(&configType),
-			),
-		)
+	// Determine tool (priority: flag > config profile > repo config > global config > interactive)
+	tool := name
+	var err error
 
-		if err := form.Run(); err != nil {
-			return fmt.Errorf("selection cancelled")
-		}
+	// Check the tool of the config profile, which applies last
+	if tool == "" {
+		tool = cfg.Profiles[profile].Tool
+	}
+
+	// Check repo-specific tool setting (applied in specificity order)
+	if tool == "" {
+		for _, m := range run.GetMatchingRepos(cfg, cwd) {
+			if m.Config.Tool != "" {
+				tool = m.Config.Tool
+			}
+		}
+	}
+	// Fall back to global config tool
+	if tool == "" && cfg.Tool != "" {
+		tool = cfg.Tool
+	}
+	// Interactive selection as last resort
+	if tool == "" {
+		tool, err = selectTool()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Validate tool
+	validTools := AvailableTools(supportedTools)
+	if !slices.Contains(validTools, tool) {
+		return nil, fmt.Errorf("invalid tool: %s (valid tools: %s)", tool, strings.Join(validTools, ", "))
+	}
+
+	// Find tool definition
+	toolDef := findTool(tool)
+	if toolDef == nil {
+		return nil, fmt.Errorf("tool definition not found: %s", tool)
+	}
+
+	return toolDef, nil
+}
+
+// addBuildCacheFlags adds the flags importing and exporting build caches to
+// cmd.
+func addBuildCacheFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("cache-from", nil, "Import build cache: type=registry,ref=<repo>, type=local,src=<dir>, or a repository (repeatable)")
+	cmd.Flags().StringArray("cache-to", nil, "Export build cache: type=registry,ref=<repo>, type=local,dest=<dir>, or a repository (repeatable)")
+}
+
+// runBuild prepares the images of the selected tools with build, run.Build
+// or run.Prefetch, and prints their tags.
+func runBuild(cmd *cobra.Command, args []string, build func(run.Options) (string, error), stdout, stderr io.Writer) error {
+	cfg := config.LoadAll(toolDefaults())
+	if b, _ := cmd.Flags().GetString("backend"); b != "" {
+		cfg.Backend = b
+	}
+	all, _ := cmd.Flags().GetBool("all")
+	forceBuild, _ := cmd.Flags().GetBool("force-build")
+	verbose, _ := cmd.Flags().GetBool("verbose")
+	platform, _ := cmd.Flags().GetString("platform")
+	cacheFrom, _ := cmd.Flags().GetStringArray("cache-from")
+	cacheTo, _ := cmd.Flags().GetStringArray("cache-to")
+
+	var name string
+	if len(args) > 0 {
+		name = args[0]
+	}
+	toolDefs, err := selectTools(cfg, name, all)
+	if err != nil {
+		return err
+	}
+
+	for _, toolDef := range toolDefs {
+		tag, err := build(run.Options{
+			ToolDef:    toolDef,
+			Config:     cfg,
+			Dockerfile: Dockerfile(supportedTools),
+			ForceBuild: forceBuild,
+			CacheFrom:  cacheFrom,
+			CacheTo:    cacheTo,
+			Platform:   platform,
+			Verbose:    verbose,
+			Stderr:     stderr,
+		})
+		if err != nil {
+			return fmt.Errorf("%s: %w", toolDef.Name, err)
+		}
+		cli.LogSuccessTo(stderr, "Image for %s is ready", toolDef.Name)
+		fmt.Fprintln(stdout, tag)
+	}
+	return nil
+}
+
+// selectTools returns every available tool if all is set, otherwise the
+// named tool, or the default one if name is empty.
+func selectTools(cfg config.Config, name string, all bool) ([]tools.Tool, error) {
+	if all {
+		if name != "" {
+			return nil, fmt.Errorf("--all can't be combined with a tool name")
+		}
+		var toolDefs []tools.Tool
+		for _, name := range AvailableTools(supportedTools) {
+			toolDefs = append(toolDefs, *findTool(name))
+		}
+		return toolDefs, nil
+	}
+	toolDef, err := chooseTool(cfg, name, "")
+	if err != nil {
+		return nil, err
+	}
+	return []tools.Tool{*toolDef}, nil
+}
+
+// runImageShare pushes or pulls the images of the selected tools.
+func runImageShare(cmd *cobra.Command, args []string, share func(run.Options, string) (string, error), done string, stdout, stderr io.Writer) error {
+	cfg := config.LoadAll(toolDefaults())
+	if b, _ := cmd.Flags().GetString("backend"); b != "" {
+		cfg.Backend = b
+	}
+	all, _ := cmd.Flags().GetBool("all")
+	name, _ := cmd.Flags().GetString("tool")
+	platform, _ := cmd.Flags().GetString("platform")
+	var registry string
+	if len(args) > 0 {
+		registry = args[0]
+	}
+
+	toolDefs, err := selectTools(cfg, name, all)
+	if err != nil {
+		return err
+	}
+	for _, toolDef := range toolDefs {
+		ref, err := share(run.Options{
+			ToolDef:    toolDef,
+			Config:     cfg,
+			Dockerfile: Dockerfile(supportedTools),
+			Platform:   platform,
+			Stderr:     stderr,
+		}, registry)
+		if err != nil {
+			return fmt.Errorf("%s: %w", toolDef.Name, err)
+		}
+		cli.LogSuccessTo(stderr, "%s image for %s", done, toolDef.Name)
+		fmt.Fprintln(stdout, ref)
+	}
+	return nil
+}
+
+func runTool(cmd *cobra.Command, toolDef tools.Tool, args []string, stdout, stderr io.Writer) error {
+	// Load configuration
+	cfg := config.LoadAll(toolDefaults())
+
+	// Get tool-specific args (everything after --)
+	var toolArgs []string
+	if cmd.ArgsLenAtDash() > -1 {
+		toolArgs = args[cmd.ArgsLenAtDash():]
+	}
+
+	// Override backend from flag
+	if b, _ := cmd.Flags().GetString("backend"); b != "" {
+		cfg.Backend = b
+	}
+	cfg = config.Merge(cfg, configOverrides(cmd))
+
+	// Get force-build flag
+	forceBuild, _ := cmd.Flags().GetBool("force-build")
+	offline, _ := cmd.Flags().GetBool("offline")
+
+	// Get verbose flag
+	verbose, _ := cmd.Flags().GetBool("verbose")
+
+	// Get keep and detach flags
+	keep, _ := cmd.Flags().GetBool("keep")
+	detach, _ := cmd.Flags().GetBool("detach")
+	record, _ := cmd.Flags().GetBool("record")
+	noTTY := runWithoutTTY(cmd, detach, keep)
+	profile, _ := cmd.Flags().GetString("profile")
+	platform, _ := cmd.Flags().GetString("platform")
+
+	// Switch to the worktree for --worktree or the branch sandbox
+	dir, labels, err := worktreeDir(cmd, cfg, toolDef.Name, stderr)
+	if err != nil {
+		return err
+	}
+
+	opts := run.Options{
+		ToolDef:    toolDef,
+		ToolArgs:   toolArgs,
+		Config:     cfg,
+		Dockerfile: Dockerfile(supportedTools),
+		Version:    version,
+		ForceBuild: forceBuild,
+		Offline:    offline,
+		Keep:       keep,
+		Detach:     detach,
+		Record:     record,
+		NoTTY:      noTTY,
+		Dir:        dir,
+		Labels:     labels,
+		Profile:    profile,
+		Platform:   platform,
+		Verbose:    verbose,
+		Stdout:     stdout,
+		Stderr:     stderr,
+	}
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun {
+		return run.Plan(opts)
+	}
+
+	// Run the tool
+	return run.Tool(opts)
+}
+
+// addOverrideFlags adds the flags that add to the loaded config for a single
+// run.
+func addOverrideFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArray("mount-ro", nil, "Mount a path read-only, in addition to the config (repeatable)")
+	cmd.Flags().StringArray("mount-rw", nil, "Mount a path read-write, in addition to the config (repeatable)")
+	cmd.Flags().StringArray("also", nil, "Mount another repository read-write alongside the current directory, with its git worktree roots (repeatable)")
+	cmd.Flags().StringArray("env", nil, "Set NAME=value, or pass NAME through from the host, in addition to the config (repeatable)")
+	cmd.Flags().StringArray("pre-run-hook", nil, "Run a command before the tool, after the global pre-run hooks (repeatable)")
+	cmd.Flags().StringArray("post-build-hook", nil, "Run a command when building the image, after the global post-build hooks (repeatable)")
+	cmd.Flags().Bool("notify", false, "Raise a notification on the host when the tool needs attention (see notify in the config)")
+}
+
+// configOverrides returns the config from the SILO_MOUNTS_RO,
+// SILO_MOUNTS_RW, SILO_EXTRA_WORKSPACES, SILO_ENV, SILO_PRE_RUN_HOOKS, and SILO_POST_BUILD_HOOKS
+// environment variables followed by the override flags, to merge over the
+// loaded config for a single run. Mounts in the environment variables are
+// separated like PATH, and the other values by newlines. Relative mount
+// paths are resolved against the current directory.
+func configOverrides(cmd *cobra.Command) config.Config {
+	values := func(envVar, sep, flag string) []string {
+		var vs []string
+		if v := os.Getenv(envVar); v != "" {
+			vs = slices.DeleteFunc(strings.Split(v, sep), func(s string) bool { return strings.TrimSpace(s) == "" })
+		}
+		fromFlag, _ := cmd.Flags().GetStringArray(flag)
+		return append(vs, fromFlag...)
+	}
+	mounts := func(envVar, flag string) []string {
+		paths := values(envVar, string(os.PathListSeparator), flag)
+		for i, p := range paths {
+			if !filepath.IsAbs(p) && p != "~" && !strings.HasPrefix(p, "~/") {
+				if abs, err := filepath.Abs(p); err == nil {
+					paths[i] = abs
+				}
+			}
+		}
+		return paths
+	}
+	notify, _ := cmd.Flags().GetBool("notify")
+	return config.Config{
+		MountsRO:        mounts("SILO_MOUNTS_RO", "mount-ro"),
+		MountsRW:        mounts("SILO_MOUNTS_RW", "mount-rw"),
+		ExtraWorkspaces: mounts("SILO_EXTRA_WORKSPACES", "also"),
+		Env:             values("SILO_ENV", "\n", "env"),
+		PreRunHooks:     values("SILO_PRE_RUN_HOOKS", "\n", "pre-run-hook"),
+		PostBuildHooks:  values("SILO_POST_BUILD_HOOKS", "\n", "post-build-hook"),
+		Notify:          config.Notify{Enabled: notify},
+	}
+}
+
+// worktreeDir returns the directory and container labels for the --worktree
+// flag, creating the worktree if needed. Without the flag, and with
+// --branch-sandbox or branch_sandbox set, it creates a new sandbox branch for
+// tool and its worktree. Otherwise it returns an empty directory, meaning the
+// current directory.
+func worktreeDir(cmd *cobra.Command, cfg config.Config, tool string, stderr io.Writer) (string, map[string]string, error) {
+	branch, _ := cmd.Flags().GetString("worktree")
+	if branch != "" {
+		return addWorktree(branch, stderr)
+	}
+	sandbox, _ := cmd.Flags().GetBool("branch-sandbox")
+	if dryRun, _ := cmd.Flags().GetBool("dry-run"); dryRun || !sandbox && (cfg.BranchSandbox == nil || !*cfg.BranchSandbox) {
+		return "", nil, nil
+	}
+	cwd, _ := os.Getwd()
+	existing, err := git.Branches(cwd, sandboxBranchPrefix+"*")
+	if err != nil {
+		if sandbox {
+			return "", nil, fmt.Errorf("--branch-sandbox: %w", err)
+		}
+		// branch_sandbox is often set globally, and outside a repository
+		// there are no commits to keep off the current branch
+		return "", nil, nil
+	}
+	return addWorktree(sandboxBranch(tool, time.Now(), existing), stderr)
+}
+
+// sandboxBranchPrefix is the prefix of the branches of the branch sandbox.
+const sandboxBranchPrefix = "silo/"
+
+// sandboxBranch returns the name of a new sandbox branch for tool, such as
+// silo/claude-20260601-1, numbered after the existing branches of that day.
+func sandboxBranch(tool string, now time.Time, existing []string) string {
+	return backend.NextName(sandboxBranchPrefix+tool+"-"+now.Format("20060102"), existing)
+}
+
+// addWorktree creates or reuses the worktree for branch in the current
+// repository and returns its path and the labels binding a container to it.
+func addWorktree(branch string, stderr io.Writer) (string, map[string]string, error) {
+	cwd, _ := os.Getwd()
+	dir, err := git.AddWorktree(cwd, branch)
+	if err != nil {
+		return "", nil, err
+	}
+	cli.LogTo(stderr, "Worktree %s: %s", branch, tilde.Path(dir))
+	return dir, map[string]string{
+		backend.LabelBranch:   branch,
+		backend.LabelWorktree: dir,
+	}, nil
+}
+
+func runFanout(cmd *cobra.Command, args []string, stdout, stderr io.Writer) error {
+	// Load configuration
+	cfg := config.LoadAll(toolDefaults())
+
+	// Branches come before --, tool args after
+	branches := args
+	var toolArgs []string
+	if cmd.ArgsLenAtDash() > -1 {
+		branches = args[:cmd.ArgsLenAtDash()]
+		toolArgs = args[cmd.ArgsLenAtDash():]
+	}
+	if len(branches) == 0 {
+		return fmt.Errorf("no branches given")
+	}
+
+	toolName, _ := cmd.Flags().GetString("tool")
+	profile, _ := cmd.Flags().GetString("profile")
+	toolDef, err := chooseTool(cfg, toolName, profile)
+	if err != nil {
+		return err
+	}
+
+	if b, _ := cmd.Flags().GetString("backend"); b != "" {
+		cfg.Backend = b
+	}
+	cfg = config.Merge(cfg, configOverrides(cmd))
+	forceBuild, _ := cmd.Flags().GetBool("force-build")
+	verbose, _ := cmd.Flags().GetBool("verbose")
+	platform, _ := cmd.Flags().GetString("platform")
+
+	// Start every session even if one fails, and report the failures at the end.
+	failed := 0
+	for i, branch := range branches {
+		dir, labels, err := addWorktree(branch, stderr)
+		if err == nil {
+			err = run.Tool(run.Options{
+				ToolDef:    *toolDef,
+				ToolArgs:   toolArgs,
+				Config:     cfg,
+				Dockerfile: Dockerfile(supportedTools),
+				Version:    version,
+				ForceBuild: forceBuild && i == 0, // the image is shared, build it once
+				Detach:     true,
+				Dir:        dir,
+				Labels:     labels,
+				Profile:    profile,
+				Platform:   platform,
+				Verbose:    verbose,
+				Stdout:     stdout,
+				Stderr:     stderr,
+			})
+		}
+		if err != nil {
+			cli.LogErrorTo(stderr, "%s: %v", branch, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sessions failed to start", failed, len(branches))
+	}
+	return nil
+}
+
+func selectTool() (string, error) {
+	names := AvailableTools(supportedTools)
+
+	var options []huh.Option[string]
+	for _, t := range names {
+		options = append(options, huh.NewOption(ToolDescription(supportedTools, t), t))
+	}
+
+	var selected string
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Title("Select AI Tool").
+				Description("Choose which AI coding assistant to run").
+				Options(options...).
+				Value(&selected),
+		),
+	)
+
+	if err := form.WithAccessible(cli.Accessible()).Run(); err != nil {
+		return "", fmt.Errorf("selection cancelled")
+	}
+
+	return selected, nil
+}
+
+func runConfigPaths(_ *cobra.Command, _ []string, stdout io.Writer) error {
+	paths := config.GetConfigPaths()
+
+	for _, p := range paths {
+		if p.Exists {
+			fmt.Fprintln(stdout, p.Path)
+		}
+	}
+
+	return nil
+}
+
+func runTemplateVars(cmd *cobra.Command, stdout io.Writer) error {
+	cfg := config.LoadAll(toolDefaults())
+	tool, _ := cmd.Flags().GetString("tool")
+	if tool == "" {
+		tool = cfg.Tool
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	vars := run.TemplateVars(cwd, tool, os.Getenv("USER"))
+	vars.Time = vars.Time.Truncate(time.Second)
+
+	nameWidth := len("VARIABLE")
+	for _, d := range slices.Concat(tmpl.VarDocs, tmpl.FuncDocs) {
+		nameWidth = max(nameWidth, len(d.Name))
+	}
+	format := fmt.Sprintf("%%-%ds  %%s\n", nameWidth)
+	fmt.Fprintf(stdout, format, "VARIABLE", "VALUE (DESCRIPTION)")
+	for _, d := range tmpl.VarDocs {
+		value, err := tmpl.Render(d.Name, "{{"+d.Name+"}}", vars)
+		if err != nil {
+			return err
+		}
+		if value == "" {
+			value = `""`
+		}
+		fmt.Fprintf(stdout, format, d.Name, value+" ("+d.Description+")")
+	}
+	fmt.Fprintln(stdout)
+	fmt.Fprintf(stdout, format, "FUNCTION", "DESCRIPTION")
+	for _, d := range tmpl.FuncDocs {
+		fmt.Fprintf(stdout, format, d.Name, d.Description)
+	}
+	return nil
+}
+
+func runEnv(cmd *cobra.Command, args []string, stdout io.Writer) error {
+	cfg := config.LoadAll(toolDefaults())
+	var name string
+	if len(args) > 0 {
+		name = args[0]
+	}
+	profile, _ := cmd.Flags().GetString("profile")
+	toolDef, err := chooseTool(cfg, name, profile)
+	if err != nil {
+		return err
+	}
+	vars, err := run.Env(run.Options{ToolDef: *toolDef, Config: cfg, Profile: profile})
+	if err != nil {
+		return err
+	}
+
+	nameWidth, statusWidth := len("NAME"), len("STATUS")
+	for _, v := range vars {
+		nameWidth = max(nameWidth, len(v.Name))
+		statusWidth = max(statusWidth, len(v.Status))
+	}
+	format := fmt.Sprintf("%%-%ds  %%-%ds  %%s\n", nameWidth, statusWidth)
+	fmt.Fprintf(stdout, format, "NAME", "STATUS", "SOURCE")
+	for _, v := range vars {
+		fmt.Fprintf(stdout, format, v.Name, v.Status, v.Source)
+	}
+	return nil
+}
+
+func runPresetAdd(cmd *cobra.Command, url string, stderr io.Writer) error {
+	var opts preset.AddOptions
+	opts.Name, _ = cmd.Flags().GetString("name")
+	opts.Ref, _ = cmd.Flags().GetString("ref")
+	opts.SHA256, _ = cmd.Flags().GetString("sha256")
+	opts.Force, _ = cmd.Flags().GetBool("force")
+
+	p, err := preset.Add(context.Background(), url, opts)
+	if err != nil {
+		return err
+	}
+	cli.LogSuccessTo(stderr, "Installed preset %s", p.Name)
+	if p.Source.Commit != "" {
+		cli.LogBulletTo(stderr, "commit %s", p.Source.Commit)
+	}
+	cli.LogBulletTo(stderr, "sha256 %s", p.Source.SHA256)
+	cli.LogDimTo(stderr, "Add %q to \"presets\" in a config file to use it", p.Name)
+	return nil
+}
+
+func runPresetList(stdout, stderr io.Writer) error {
+	presets, err := preset.List()
+	if err != nil {
+		return err
+	}
+	if len(presets) == 0 {
+		cli.LogTo(stderr, "No presets installed")
+		return nil
+	}
+
+	nameWidth := len("NAME")
+	urlWidth := len("URL")
+	for _, p := range presets {
+		nameWidth = max(nameWidth, len(p.Name))
+		urlWidth = max(urlWidth, len(p.Source.URL))
+	}
+	format := fmt.Sprintf("%%-%ds  %%-%ds  %%-12s  %%-8s  %%s\n", nameWidth, urlWidth)
+	fmt.Fprintf(stdout, format, "NAME", "URL", "COMMIT", "STATUS", "SHA256")
+	for _, p := range presets {
+		commit := p.Source.Commit
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		if commit == "" {
+			commit = "-"
+		}
+		status := "ok"
+		if err := preset.Verify(p.Name); err != nil {
+			status = "modified"
+		}
+		fmt.Fprintf(stdout, format, p.Name, p.Source.URL, commit, status, p.Source.SHA256)
+	}
+	return nil
+}
+
+func runConfigDoctor(stderr io.Writer) error {
+	cfg, sources := config.LoadAllWithSources(toolDefaults())
+	var files []string
+	for _, p := range config.GetConfigPaths() {
+		if p.Exists {
+			files = append(files, p.Path)
+		}
+	}
+
+	findings := configdoctor.Check(configdoctor.Options{
+		Config:        cfg,
+		Sources:       sources,
+		Files:         files,
+		Environ:       os.Environ(),
+		Home:          os.Getenv("HOME"),
+		GOOS:          runtime.GOOS,
+		ImageCommands: ImageCommands(supportedTools),
+
+		DockerInDockerCommands: dockerInDockerCommands,
+	})
+	if len(findings) == 0 {
+		cli.LogSuccessTo(stderr, "No problems found")
+		return nil
+	}
+	for _, f := range findings {
+		f.Source = tilde.Path(f.Source)
+		cli.LogWarningTo(stderr, "%s", f)
+	}
+	return fmt.Errorf("found %d problems", len(findings))
+}
+
+func runConfigValidate(args []string, stderr io.Writer) error {
+	paths := args
+	if len(paths) == 0 {
+		for _, p := range config.GetConfigPaths() {
+			if p.Exists {
+				paths = append(paths, p.Path)
+			}
+		}
+	}
+	if len(paths) == 0 {
+		cli.LogTo(stderr, "No config files found")
+		return nil
+	}
+
+	var toolNames []string
+	for _, t := range supportedTools {
+		toolNames = append(toolNames, t.Name)
+	}
+	v, err := configvalidate.New(configSchema, toolNames)
+	if err != nil {
+		return err
+	}
+
+	errorCount := 0
+	for _, path := range paths {
+		problems, err := v.File(path)
+		if err != nil {
+			cli.LogErrorTo(stderr, "%s: %v", tilde.Path(path), err)
+			errorCount++
+			continue
+		}
+		if len(problems) == 0 {
+			cli.LogSuccessTo(stderr, "%s", tilde.Path(path))
+			continue
+		}
+		for _, p := range problems {
+			p.Path = tilde.Path(p.Path)
+			if p.Warning {
+				cli.LogWarningTo(stderr, "%s", p)
+			} else {
+				cli.LogErrorTo(stderr, "%s", p)
+				errorCount++
+			}
+		}
+	}
+	if errorCount > 0 {
+		return fmt.Errorf("found %d config errors", errorCount)
+	}
+	return nil
+}
+
+func runConfigEdit(_ *cobra.Command, _ []string, _, stderr io.Writer) error {
+	paths := config.GetConfigPaths()
+
+	// Build options for the selector:
+	// - Always include global config (first path)
+	// - Only include local configs that exist
+	var options []huh.Option[string]
+	for i, p := range paths {
+		isGlobal := i == 0
+		if !isGlobal && !p.Exists {
+			continue
+		}
+		label := p.Path
+		if !p.Exists {
+			label += " (new)"
+		}
+		options = append(options, huh.NewOption(label, p.Path))
+	}
+
+	var selectedPath string
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Title("Select Config to Edit").
+				Description("Configs are merged in order shown (later overrides earlier)").
+				Options(options...).
+				Value(&selectedPath),
+		),
+	)
+
+	if err := form.WithAccessible(cli.Accessible()).Run(); err != nil {
+		return fmt.Errorf("selection cancelled")
+	}
+
+	// Get editor from environment
+	editor := os.Getenv("EDITOR")
+	if editor == "" {
+		editor = os.Getenv("VISUAL")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	// Ensure parent directory exists for new files
+	dir := filepath.Dir(selectedPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	// If file doesn't exist, pre-fill with template
+	if err := createConfig(selectedPath); err != nil {
+		return fmt.Errorf("failed to create config: %w", err)
+	}
+
+	// Open editor
+	cmd := exec.Command(editor, selectedPath)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor failed: %w", err)
+	}
+
+	return nil
+}
+
+func runInit(_ *cobra.Command, _ []string, stderr io.Writer, globalFlag, localFlag, mergeFlag bool) error {
+	var configType string
+
+	// Determine config type from flags or interactive prompt
+	if globalFlag {
+		configType = "global"
+	} else if localFlag {
+		configType = "local"
+	} else {
+		// Interactive selection
+		form := huh.NewForm(
+			huh.NewGroup(
+				huh.NewSelect[string]().
+					Title("Create Configuration").
+					Description("Choose which configuration file to create").
+					Options(
+						huh.NewOption("Local (silo.jsonc in current directory)", "local"),
+						huh.NewOption("Global (~/.config/silo/silo.jsonc)", "global"),
+					).
+					Value(&configType),
+			),
+		)
+
+		if err := form.WithAccessible(cli.Accessible()).Run(); err != nil {
+			return fmt.Errorf("selection cancelled")
+		}
 	}
 
 	var configPath string
@@ -511,268 +2526,1861 @@ func runInit(_ *cobra.Command, _ []string, stderr io.Writer, globalFlag, localFl
 		if err := os.MkdirAll(configDir, 0755); err != nil {
 			return fmt.Errorf("failed to create config directory: %w", err)
 		}
-		configPath = filepath.Join(configDir, "silo.jsonc")
+		configPath = filepath.Join(configDir, "silo.jsonc")
+	} else {
+		configPath = "silo.jsonc"
+	}
+
+	// Hold the lock across the existence check and the write so that
+	// concurrent invocations can't both create or merge the file.
+	unlock, err := lockConfig(configPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(configPath); err == nil {
+		return mergeInit(configPath, stderr, mergeFlag)
+	}
+
+	if err := fileutil.WriteFile(configPath, []byte(sampleConfig), 0644); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	cli.LogSuccessTo(stderr, "Created %s", configPath)
+	return nil
+}
+
+// lockConfig takes the lock guarding writes to the config file at path.
+func lockConfig(path string) (func(), error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return fileutil.Lock(abs)
+}
+
+// createConfig writes the sample config to path unless a file already exists.
+func createConfig(path string) error {
+	unlock, err := lockConfig(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return nil
+	}
+	return fileutil.WriteFile(path, []byte(sampleConfig), 0644)
+}
+
+// mergeInit appends the sample config sections missing from the existing
+// config at configPath as commented templates.
+func mergeInit(configPath string, stderr io.Writer, mergeFlag bool) error {
+	if !mergeFlag && !isatty.IsTerminal(os.Stdin.Fd()) {
+		return fmt.Errorf("config file already exists: %s (use --merge to add missing sections)", configPath)
+	}
+
+	existing, err := os.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+
+	missing, err := configinit.Missing(existing, configinit.Sections(sampleConfig))
+	if err != nil {
+		return fmt.Errorf("%s: %w", configPath, err)
+	}
+	if len(missing) == 0 {
+		cli.LogTo(stderr, "%s already has every sample section", configPath)
+		return nil
+	}
+
+	keys := make([]string, len(missing))
+	for i, s := range missing {
+		keys[i] = s.Key
+	}
+
+	if !mergeFlag {
+		confirmed := true
+		form := huh.NewForm(
+			huh.NewGroup(
+				huh.NewConfirm().
+					Title(fmt.Sprintf("%s already exists", configPath)).
+					Description("Append commented templates for missing sections?\n" + strings.Join(keys, ", ")).
+					Affirmative("Append").
+					Negative("Cancel").
+					Value(&confirmed),
+			),
+		)
+		if err := form.WithAccessible(cli.Accessible()).Run(); err != nil || !confirmed {
+			return fmt.Errorf("config file already exists: %s", configPath)
+		}
+	}
+
+	merged, err := configinit.Append(existing, missing)
+	if err != nil {
+		return fmt.Errorf("%s: %w", configPath, err)
+	}
+	if err := fileutil.WriteFile(configPath, merged, 0644); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	cli.LogSuccessTo(stderr, "Added %s to %s", strings.Join(keys, ", "), configPath)
+	return nil
+}
+
+func runPrune(cmd *cobra.Command, stdout, stderr io.Writer) error {
+	ctx := context.Background()
+
+	backendFlag, _ := cmd.Flags().GetString("backend")
+	olderThan, _ := cmd.Flags().GetString("older-than")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+	opts := prune.Options{Now: time.Now()}
+	opts.Containers, _ = cmd.Flags().GetBool("containers")
+	opts.Images, _ = cmd.Flags().GetBool("images")
+	opts.Volumes, _ = cmd.Flags().GetBool("volumes")
+	if !opts.Containers && !opts.Images && !opts.Volumes {
+		opts.Containers, opts.Images = true, true
+	}
+	if olderThan != "" {
+		d, err := prune.ParseAge(olderThan)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid --older-than: %q (e.g. 30d, 12h)", olderThan)
+		}
+		opts.OlderThan = d
+	}
+
+	var backends []string
+	if backendFlag != "" {
+		backends = []string{backendFlag}
+	} else {
+		backends = []string{"docker", "container", "sandbox"}
+	}
+
+	entries, _ := journal.Read()
+
+	action := "Removed"
+	if dryRun {
+		action = "Would remove"
+	}
+
+	var reclaimed int64
+	var count int
+	for _, backendType := range backends {
+		var backendClient backend.Backend
+		var err error
+
+		switch backendType {
+		case "docker":
+			backendClient, err = docker.NewClient()
+			if err != nil {
+				continue
+			}
+		case "container":
+			backendClient, err = applecontainer.NewClient()
+			if err != nil {
+				continue
+			}
+		case "sandbox":
+			backendClient, err = sandbox.NewClient()
+			if err != nil {
+				continue
+			}
+		default:
+			return fmt.Errorf("unknown backend: %s", backendType)
+		}
+
+		plan, err := planPrune(ctx, backendClient, backendType, entries, opts)
+		if err != nil {
+			backendClient.Close()
+			cli.LogWarningTo(stderr, "Failed to list resources (%s): %v", backendType, err)
+			continue
+		}
+
+		var containers, images, volumes []string
+		for _, c := range plan.Containers {
+			containers = append(containers, c.Name)
+		}
+		sizes := make(map[string]int64)
+		for _, img := range plan.Images {
+			images = append(images, img.Name)
+			sizes[img.Name] = img.Size
+		}
+		for _, v := range plan.Volumes {
+			volumes = append(volumes, v.Name)
+		}
+
+		if !dryRun {
+			// Containers go first so the images they used can be removed.
+			if len(containers) > 0 {
+				if containers, err = backendClient.Remove(ctx, containers); err != nil {
+					cli.LogWarningTo(stderr, "%v", err)
+				}
+				for _, name := range containers {
+					os.RemoveAll(run.EphemeralDir(name))
+				}
+			}
+			if len(images) > 0 {
+				if images, err = backendClient.RemoveImages(ctx, images); err != nil {
+					cli.LogWarningTo(stderr, "%v", err)
+				}
+			}
+			if len(volumes) > 0 {
+				if volumes, err = backendClient.RemoveVolumes(ctx, volumes); err != nil {
+					cli.LogWarningTo(stderr, "%v", err)
+				}
+			}
+		}
+		// Services and networks of sessions that didn't exit cleanly
+		var sidecars []string
+		if opts.Containers {
+			if sidecars, err = backendClient.PruneSidecars(ctx, dryRun); err != nil {
+				cli.LogWarningTo(stderr, "%v", err)
+			}
+		}
+		backendClient.Close()
+
+		for _, name := range containers {
+			fmt.Fprintf(stdout, "%s container %s (%s)\n", action, name, backendType)
+		}
+		for _, name := range images {
+			fmt.Fprintf(stdout, "%s image %s (%s)\n", action, name, backendType)
+			reclaimed += sizes[name]
+		}
+		for _, name := range volumes {
+			fmt.Fprintf(stdout, "%s volume %s (%s)\n", action, name, backendType)
+		}
+		for _, name := range sidecars {
+			fmt.Fprintf(stdout, "%s leftover %s (%s)\n", action, name, backendType)
+		}
+		count += len(containers) + len(images) + len(volumes) + len(sidecars)
+	}
+
+	switch {
+	case count == 0:
+		cli.LogTo(stderr, "Nothing to prune")
+	case dryRun:
+		cli.LogTo(stderr, "Would reclaim %s", humanize.IBytes(uint64(reclaimed)))
+	default:
+		cli.LogSuccessTo(stderr, "Reclaimed %s", humanize.IBytes(uint64(reclaimed)))
+	}
+	return nil
+}
+
+// eachBackend calls fn with each backend that is available, or only the one
+// named by the --backend flag.
+func eachBackend(cmd *cobra.Command, fn func(backendType string, b backend.Backend) error) error {
+	backendFlag, _ := cmd.Flags().GetString("backend")
+	backends := []string{"docker", "container", "sandbox"}
+	if backendFlag != "" {
+		backends = []string{backendFlag}
+	}
+	for _, backendType := range backends {
+		var backendClient backend.Backend
+		var err error
+		switch backendType {
+		case "docker":
+			backendClient, err = docker.NewClient()
+		case "container":
+			backendClient, err = applecontainer.NewClient()
+		case "sandbox":
+			backendClient, err = sandbox.NewClient()
+		default:
+			return fmt.Errorf("unknown backend: %s", backendType)
+		}
+		if err != nil {
+			continue
+		}
+		err = fn(backendType, backendClient)
+		backendClient.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func runReplay(cmd *cobra.Command, args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 {
+		recordings, err := recording.List()
+		if err != nil {
+			return err
+		}
+		if len(recordings) == 0 {
+			cli.LogTo(stderr, "No recordings, record a session with --record")
+			return nil
+		}
+		nameWidth := len("NAME")
+		sessionWidth := len("SESSION")
+		for _, r := range recordings {
+			nameWidth = max(nameWidth, len(r.Name))
+			sessionWidth = max(sessionWidth, len(r.Session))
+		}
+		format := fmt.Sprintf("%%-%ds  %%-%ds  %%-16s  %%s\n", nameWidth, sessionWidth)
+		fmt.Fprintf(stdout, format, "NAME", "SESSION", "STARTED", "SIZE")
+		for _, r := range recordings {
+			fmt.Fprintf(stdout, format, r.Name, r.Session, r.Time.Format("2006-01-02 15:04"), humanize.IBytes(uint64(r.Size)))
+		}
+		return nil
+	}
+
+	speed, _ := cmd.Flags().GetFloat64("speed")
+	idleLimit, _ := cmd.Flags().GetDuration("idle-limit")
+	r, err := recording.Find(args[0])
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(r.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	cli.LogDimTo(stderr, "Playing %s, recorded %s", r.Name, r.Time.Format("2006-01-02 15:04"))
+	err = recording.Play(ctx, f, stdout, speed, idleLimit)
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// runWithoutTTY reports whether a session runs without a TTY: with --no-tty, or
+// when stdin or stdout isn't a terminal, such as in CI or a pipe, unless it
+// is detached or kept, which need one.
+func runWithoutTTY(cmd *cobra.Command, detach, keep bool) bool {
+	if noTTY, _ := cmd.Flags().GetBool("no-tty"); noTTY {
+		return true
+	}
+	return !detach && !keep && (!term.IsTerminal(os.Stdin.Fd()) || !term.IsTerminal(os.Stdout.Fd()))
+}
+
+// completeRecordings completes the sessions and names of recordings.
+func completeRecordings(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	recordings, _ := recording.List()
+	var names []string
+	for _, r := range recordings {
+		if !slices.Contains(names, r.Session) {
+			names = append(names, r.Session)
+		}
+		names = append(names, r.Name)
+	}
+	return names, cobra.ShellCompDirectiveNoFileComp
+}
+
+func runVolumeList(cmd *cobra.Command, stdout, stderr io.Writer) error {
+	ctx := context.Background()
+	type volumeRow struct{ name, volume, backendType string }
+	var rows []volumeRow
+	err := eachBackend(cmd, func(backendType string, b backend.Backend) error {
+		volumes, err := b.ListVolumes(ctx)
+		if err != nil {
+			cli.LogWarningTo(stderr, "Failed to list volumes (%s): %v", backendType, err)
+			return nil
+		}
+		for _, v := range volumes {
+			if name, ok := strings.CutPrefix(v.Name, backend.CacheVolumePrefix); ok {
+				rows = append(rows, volumeRow{name, v.Name, backendType})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(rows) == 0 {
+		cli.LogTo(stderr, "No cache volumes")
+		return nil
+	}
+
+	nameWidth := len("NAME")
+	volumeWidth := len("VOLUME")
+	for _, r := range rows {
+		nameWidth = max(nameWidth, len(r.name))
+		volumeWidth = max(volumeWidth, len(r.volume))
+	}
+	format := fmt.Sprintf("%%-%ds  %%-%ds  %%s\n", nameWidth, volumeWidth)
+	fmt.Fprintf(stdout, format, "NAME", "VOLUME", "BACKEND")
+	for _, r := range rows {
+		fmt.Fprintf(stdout, format, r.name, r.volume, r.backendType)
+	}
+	return nil
+}
+
+func runVolumeRemove(cmd *cobra.Command, names []string, stdout, stderr io.Writer) error {
+	ctx := context.Background()
+	var volumes []string
+	for _, name := range names {
+		volumes = append(volumes, backend.CacheVolumePrefix+strings.TrimPrefix(name, backend.CacheVolumePrefix))
+	}
+
+	found := make(map[string]bool)
+	err := eachBackend(cmd, func(backendType string, b backend.Backend) error {
+		existing, err := b.ListVolumes(ctx)
+		if err != nil {
+			cli.LogWarningTo(stderr, "Failed to list volumes (%s): %v", backendType, err)
+			return nil
+		}
+		var remove []string
+		for _, v := range existing {
+			if slices.Contains(volumes, v.Name) {
+				remove = append(remove, v.Name)
+				found[v.Name] = true
+			}
+		}
+		if len(remove) == 0 {
+			return nil
+		}
+		removed, err := b.RemoveVolumes(ctx, remove)
+		for _, name := range removed {
+			fmt.Fprintf(stdout, "Removed volume %s (%s)\n", name, backendType)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for i, v := range volumes {
+		if !found[v] {
+			return fmt.Errorf("cache volume %s not found", names[i])
+		}
+	}
+	return nil
+}
+
+// planPrune lists the silo resources on a backend and returns the stale ones.
+func planPrune(ctx context.Context, b backend.Backend, backendType string, entries []journal.Entry, opts prune.Options) (prune.Plan, error) {
+	containers, err := b.List(ctx)
+	if err != nil {
+		return prune.Plan{}, err
+	}
+	var images []backend.ImageInfo
+	if opts.Images {
+		if images, err = b.ListImages(ctx); err != nil {
+			return prune.Plan{}, err
+		}
+	}
+	var volumes []backend.VolumeInfo
+	if opts.Volumes {
+		if volumes, err = b.ListVolumes(ctx); err != nil {
+			return prune.Plan{}, err
+		}
+	}
+
+	lastUsed := make(map[string]time.Time)
+	for _, e := range entries {
+		if e.Backend == backendType && e.Time.After(lastUsed[e.ImageTag]) {
+			lastUsed[e.ImageTag] = e.Time
+		}
+	}
+
+	return prune.Stale(containers, images, volumes, lastUsed, opts), nil
+}
+
+// removedJSON is a removed container as printed by rm --format json.
+type removedJSON struct {
+	Name    string `json:"name"`
+	Backend string `json:"backend"`
+	Image   string `json:"image,omitempty"` // image removed with --purge
+}
+
+func runRemove(cmd *cobra.Command, args []string, stdout, stderr io.Writer) error {
+	purge, _ := cmd.Flags().GetBool("purge")
+	format, err := formatFlag(cmd, "table", "json")
+	if err != nil {
+		return err
+	}
+	names, err := expandRemoveArgs(cmd, args, stderr)
+	if err != nil {
+		return err
+	}
+	removedAll := []removedJSON{}
+	var removedSessions []string
+
+	// removal is what removing the containers from one backend did, along
+	// with warnings to print in backend order once every backend is done
+	type removal struct {
+		containers []backend.ContainerInfo
+		removed    []string
+		images     []string
+		warnings   []string
+	}
+	results, err := queryBackends(cmd, removeTimeout, func(ctx context.Context, backendType string, b backend.Backend) (removal, error) {
+		var r removal
+		args := args
+		if names != nil {
+			if args = names[backendType]; len(args) == 0 {
+				return r, nil
+			}
+		}
+		// The images in use are found before removing the containers,
+		// as removed containers no longer report their image
+		if purge {
+			var err error
+			if r.containers, err = b.List(ctx); err != nil {
+				r.warnings = append(r.warnings, fmt.Sprintf("failed to list containers (%s): %v", backendType, err))
+			}
+		}
+		removed, err := b.Remove(ctx, args)
+		if err != nil {
+			return r, err
+		}
+		r.removed = removed
+		if purge {
+			if r.images, err = b.RemoveImages(ctx, unusedImages(r.containers, removed)); err != nil {
+				r.warnings = append(r.warnings, err.Error())
+			}
+		}
+		return r, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, res := range results {
+		backendType := res.backendType
+		if res.clientErr != nil {
+			cli.LogWarningTo(stderr, "%s not available: %v", backendLabel(backendType), res.clientErr)
+			continue
+		}
+		for _, w := range res.value.warnings {
+			cli.LogWarningTo(stderr, "%s", w)
+		}
+		if res.err != nil {
+			cli.LogWarningTo(stderr, "failed to remove containers (%s): %v", backendType, res.err)
+			continue
+		}
+		containers, removed, images := res.value.containers, res.value.removed, res.value.images
+
+		removedSessions = append(removedSessions, sessionsOf(containers, removed)...)
+		for _, name := range removed {
+			os.RemoveAll(run.EphemeralDir(name))
+			// Each image is reported with the first container that used it
+			var image string
+			if i := slices.IndexFunc(containers, func(ctr backend.ContainerInfo) bool { return ctr.Name == name }); i >= 0 {
+				if j := slices.Index(images, strings.TrimSuffix(containers[i].Image, ":latest")); j >= 0 {
+					image = images[j]
+					images = slices.Delete(images, j, j+1)
+				}
+			}
+			if format == "json" {
+				removedAll = append(removedAll, removedJSON{Name: name, Backend: backendType, Image: image})
+				continue
+			}
+			cli.LogTo(stderr, "Removed %s (%s)", name, backendType)
+			if image != "" {
+				cli.LogTo(stderr, "Removed image %s (%s)", image, backendType)
+			}
+		}
+	}
+
+	if purge && len(removedSessions) > 0 {
+		n, err := journal.Remove(func(e journal.Entry) bool {
+			return e.Session != "" && slices.Contains(removedSessions, e.Session)
+		})
+		if err != nil {
+			cli.LogWarningTo(stderr, "failed to remove run history: %v", err)
+		} else if n > 0 && format != "json" {
+			cli.LogTo(stderr, "Removed %d run history entries", n)
+		}
+	}
+
+	if format == "json" {
+		return writeJSON(stdout, removedAll)
+	}
+	return nil
+}
+
+// sessionsOf returns the sessions, the LabelSession labels, of the
+// containers named in names. Names are reused once a container is removed,
+// so run history is matched to a container by its session rather than its
+// name. Containers from before sessions were labelled have none.
+func sessionsOf(containers []backend.ContainerInfo, names []string) []string {
+	var sessions []string
+	for _, c := range containers {
+		if s := c.Labels[backend.LabelSession]; s != "" && slices.Contains(names, c.Name) {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions
+}
+
+// expandRemoveArgs returns the containers of each backend that the glob
+// patterns in args match, along with the names given, after listing them
+// and confirming their removal. It returns nil if args has no patterns, in
+// which case the names are removed as given.
+func expandRemoveArgs(cmd *cobra.Command, args []string, stderr io.Writer) (map[string][]string, error) {
+	if !slices.ContainsFunc(args, backend.IsPattern) {
+		return nil, nil
+	}
+	// Malformed patterns are reported once, before asking any backend
+	if _, err := backend.MatchNames(args, nil); err != nil {
+		return nil, err
+	}
+	yes, _ := cmd.Flags().GetBool("yes")
+	results, err := queryBackends(cmd, listTimeout, func(ctx context.Context, backendType string, b backend.Backend) ([]string, error) {
+		containers, err := b.List(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return backend.MatchNames(args, containers)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[string][]string{}
+	var lines []string
+	for _, res := range results {
+		if res.clientErr != nil {
+			continue
+		}
+		if res.err != nil {
+			cli.LogWarningTo(stderr, "failed to list containers (%s): %v", res.backendType, res.err)
+			continue
+		}
+		names[res.backendType] = res.value
+		for _, name := range res.value {
+			lines = append(lines, fmt.Sprintf("  %s (%s)", name, res.backendType))
+		}
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("no silo containers match %s", strings.Join(args, " "))
+	}
+
+	cli.LogTo(stderr, "%d containers match:\n%s", len(lines), strings.Join(lines, "\n"))
+	if yes {
+		return names, nil
+	}
+	if !isatty.IsTerminal(os.Stdin.Fd()) {
+		return nil, fmt.Errorf("removing containers matching a pattern needs confirmation: run in a terminal or use --yes")
+	}
+	confirmed := false
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewConfirm().
+				Title(fmt.Sprintf("Remove %d containers?", len(lines))).
+				Affirmative("Remove").
+				Negative("Cancel").
+				Value(&confirmed),
+		),
+	)
+	if err := form.WithAccessible(cli.Accessible()).Run(); err != nil || !confirmed {
+		return nil, fmt.Errorf("removal cancelled")
+	}
+	return names, nil
+}
+
+// listTimeout and removeTimeout bound how long ls and rm wait for each
+// backend, so a daemon that is down or hung doesn't stall the command.
+const (
+	listTimeout   = 10 * time.Second
+	removeTimeout = 2 * time.Minute
+)
+
+// backendResult is the outcome of calling one backend in queryBackends.
+type backendResult[T any] struct {
+	backendType string
+	value       T
+	// clientErr is set when no client could be created for the backend,
+	// in which case fn wasn't called.
+	clientErr error
+	err       error
+}
+
+// queryBackends calls fn concurrently on each backend selected by --backend,
+// each with its own timeout, and returns the results in backend order.
+func queryBackends[T any](cmd *cobra.Command, timeout time.Duration, fn func(ctx context.Context, backendType string, b backend.Backend) (T, error)) ([]backendResult[T], error) {
+	backendFlag, _ := cmd.Flags().GetString("backend")
+	backends := []string{"docker", "container", "sandbox"}
+	if backendFlag != "" {
+		if !slices.Contains(backends, backendFlag) {
+			return nil, fmt.Errorf("unknown backend: %s", backendFlag)
+		}
+		backends = []string{backendFlag}
+	}
+
+	results := make([]backendResult[T], len(backends))
+	var wg sync.WaitGroup
+	for i, backendType := range backends {
+		results[i].backendType = backendType
+		wg.Go(func() {
+			var backendClient backend.Backend
+			var err error
+			switch backendType {
+			case "docker":
+				backendClient, err = docker.NewClient()
+			case "container":
+				backendClient, err = applecontainer.NewClient()
+			case "sandbox":
+				backendClient, err = sandbox.NewClient()
+			}
+			if err != nil {
+				results[i].clientErr = err
+				return
+			}
+			defer backendClient.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			results[i].value, results[i].err = fn(ctx, backendType, backendClient)
+			if errors.Is(results[i].err, context.DeadlineExceeded) {
+				results[i].err = fmt.Errorf("timed out after %s", timeout)
+			}
+		})
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// backendLabel is how warnings name a backend that isn't available.
+func backendLabel(backendType string) string {
+	switch backendType {
+	case "docker":
+		return "Docker"
+	case "sandbox":
+		return "Sandbox backend"
+	}
+	return "Container backend"
+}
+
+// unusedImages returns the images of the removed containers that no other
+// container uses.
+func unusedImages(containers []backend.ContainerInfo, removed []string) []string {
+	var images []string
+	for _, ctr := range containers {
+		if slices.Contains(removed, ctr.Name) {
+			images = append(images, strings.TrimSuffix(ctr.Image, ":latest"))
+		}
+	}
+	return slices.DeleteFunc(slices.Compact(slices.Sorted(slices.Values(images))), func(image string) bool {
+		return slices.ContainsFunc(containers, func(ctr backend.ContainerInfo) bool {
+			return !slices.Contains(removed, ctr.Name) && strings.TrimSuffix(ctr.Image, ":latest") == image
+		})
+	})
+}
+
+// setUserEnv sets HOME and USER from the --home and --user flags, filling
+// them from the user database when they are missing, e.g. when silo is
+// started by launchd or a minimal CI shell. Config paths, mount expansion,
+// and the image all then agree on who the user is.
+func setUserEnv(cmd *cobra.Command) error {
+	home, _ := cmd.Flags().GetString("home")
+	name, _ := cmd.Flags().GetString("user")
+	if home != "" {
+		if !filepath.IsAbs(home) {
+			return fmt.Errorf("--home must be an absolute path: %s", home)
+		}
+		os.Setenv("HOME", home)
+	}
+	if name != "" {
+		os.Setenv("USER", name)
+	}
+	if os.Getenv("HOME") == "" || os.Getenv("USER") == "" {
+		if u, err := user.Current(); err == nil {
+			if os.Getenv("HOME") == "" {
+				os.Setenv("HOME", u.HomeDir)
+			}
+			if os.Getenv("USER") == "" {
+				os.Setenv("USER", u.Username)
+			}
+		}
+	}
+	// Paths in the XDG directories are derived from HOME
+	xdg.Reload()
+	return nil
+}
+
+// setLogging configures the messages silo writes to stderr from the
+// --log-level, --log-json, and --log-file flags, including those of packages
+// that log with slog, such as the debug messages of the backends.
+func setLogging(cmd *cobra.Command, stderr io.Writer) error {
+	levelName, _ := cmd.Flags().GetString("log-level")
+	level, err := cli.ParseLogLevel(levelName)
+	if err != nil {
+		return err
+	}
+	logJSON, _ := cmd.Flags().GetBool("log-json")
+	cli.SetLogLevel(level)
+	cli.SetLogJSON(logJSON)
+	slog.SetDefault(cli.DefaultLogger(stderr))
+
+	path, _ := cmd.Flags().GetString("log-file")
+	if path == "" {
+		cli.SetLogFile(nil)
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	cli.SetLogFile(f)
+	return nil
+}
+
+// setEvents sets where the events of the run are written from the
+// --events-fd and --events-file flags.
+func setEvents(cmd *cobra.Command) error {
+	fd, _ := cmd.Flags().GetInt("events-fd")
+	path, _ := cmd.Flags().GetString("events-file")
+	switch {
+	case fd > 0:
+		f := os.NewFile(uintptr(fd), "events")
+		if _, err := f.Stat(); err != nil {
+			return fmt.Errorf("--events-fd %d is not an open file descriptor", fd)
+		}
+		if fd <= 2 {
+			// stdout and stderr stay open for silo's own output
+			events.Set(nopWriteCloser{f})
+		} else {
+			// Processes silo starts, such as the tool and hooks, mustn't
+			// inherit it and write events of their own
+			syscall.CloseOnExec(fd)
+			events.Set(f)
+		}
+	case path != "":
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to open events file: %w", err)
+		}
+		events.Set(f)
+	default:
+		events.Set(nil)
+	}
+	return nil
+}
+
+// nopWriteCloser is a writer whose Close does nothing.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// formatFlag returns the value of the --format flag, or an error if it is
+// not one of valid.
+func formatFlag(cmd *cobra.Command, valid ...string) (string, error) {
+	format, _ := cmd.Flags().GetString("format")
+	if !slices.Contains(valid, format) {
+		return "", fmt.Errorf("unknown format: %s (valid formats: %s)", format, strings.Join(valid, ", "))
+	}
+	return format, nil
+}
+
+// writeJSON writes v to w as indented JSON.
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func runExec(cmd *cobra.Command, name string, command []string, stderr io.Writer) error {
+	return withContainer(cmd, name, func(b backend.Backend) error {
+		return b.Exec(context.Background(), name, command)
+	})
+}
+
+// warnVersionMismatch warns if the container was created by a different
+// silo version, as the scripts it runs are those of that version.
+func warnVersionMismatch(b backend.Backend, name string, stderr io.Writer) {
+	containers, err := b.List(context.Background())
+	if err != nil {
+		return
+	}
+	for _, ctr := range containers {
+		if ctr.Name != name {
+			continue
+		}
+		if msg := versionMismatch(ctr.Labels, version, mountwait.ScriptVersion); msg != "" {
+			cli.LogWarningTo(stderr, "%s %s", name, msg)
+		}
+		return
+	}
+}
+
+// versionMismatch describes how the silo that created a container, as
+// recorded in its labels, differs from this one. It returns "" if they match.
+func versionMismatch(labels map[string]string, silo, scripts string) string {
+	created, ok := labels[backend.LabelVersion]
+	if !ok {
+		return "was created by an older silo version, its behavior may differ from this version"
+	}
+	if labels[backend.LabelScriptVersion] != scripts {
+		return fmt.Sprintf("was created by silo %s with scripts version %s, this silo %s uses version %s; recreate the container to use them",
+			created, labels[backend.LabelScriptVersion], silo, scripts)
+	}
+	if created != silo {
+		return fmt.Sprintf("was created by silo %s, this is silo %s (the scripts it runs are unchanged)", created, silo)
+	}
+	return ""
+}
+
+// withContainer calls fn with each backend selected by the --backend flag
+// (default: all) until one finds the named container.
+func withContainer(cmd *cobra.Command, name string, fn func(backend.Backend) error) error {
+	backendFlag, _ := cmd.Flags().GetString("backend")
+
+	var backends []string
+	if backendFlag != "" {
+		backends = []string{backendFlag}
 	} else {
-		configPath = "silo.jsonc"
+		backends = []string{"docker", "container", "sandbox"}
+	}
+
+	for _, backendType := range backends {
+		var backendClient backend.Backend
+		var err error
+
+		switch backendType {
+		case "docker":
+			backendClient, err = docker.NewClient()
+			if err != nil {
+				continue
+			}
+		case "container":
+			backendClient, err = applecontainer.NewClient()
+			if err != nil {
+				continue
+			}
+		case "sandbox":
+			backendClient, err = sandbox.NewClient()
+			if err != nil {
+				continue
+			}
+		default:
+			return fmt.Errorf("unknown backend: %s", backendType)
+		}
+
+		err = fn(backendClient)
+		backendClient.Close()
+
+		if err == nil {
+			return nil
+		}
+
+		// If the error is "not found", try the next backend.
+		// If the error is something else (not running, exec failure), return it.
+		if !strings.Contains(err.Error(), "not found") {
+			return err
+		}
+	}
+
+	return fmt.Errorf("container %s not found", name)
+}
+
+func completeContainerNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completeContainers(cmd, args, toComplete, false, func(c backend.ContainerInfo) bool { return c.IsRunning })
+}
+
+func completeStoppedContainerNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completeContainers(cmd, args, toComplete, false, func(c backend.ContainerInfo) bool { return !c.IsRunning })
+}
+
+func completeAllContainerNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completeContainers(cmd, args, toComplete, false, func(backend.ContainerInfo) bool { return true })
+}
+
+// completeRunningContainerList completes any number of running container
+// names, for commands like stop that take several.
+func completeRunningContainerList(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completeContainers(cmd, args, toComplete, true, func(c backend.ContainerInfo) bool { return c.IsRunning })
+}
+
+// completeContainerList completes any number of container names, running or
+// not, for commands like rm that take several.
+func completeContainerList(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completeContainers(cmd, args, toComplete, true, func(backend.ContainerInfo) bool { return true })
+}
+
+// completionTimeout bounds how long completion waits for a backend to list
+// its containers, so a stalled daemon doesn't hang the shell.
+const completionTimeout = 2 * time.Second
+
+// completeContainers completes the names of silo containers matching filter
+// on the backends selected by --backend. Only the first arg is completed
+// unless multi is set.
+func completeContainers(cmd *cobra.Command, args []string, toComplete string, multi bool, filter func(backend.ContainerInfo) bool) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 && !multi {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+
+	var containers []backend.ContainerInfo
+	_ = eachBackend(cmd, func(_ string, b backend.Backend) error {
+		ctx, cancel := context.WithTimeout(context.Background(), completionTimeout)
+		defer cancel()
+		if list, err := b.List(ctx); err == nil {
+			containers = append(containers, list...)
+		}
+		return nil
+	})
+
+	return matchContainerNames(containers, args, toComplete, filter), cobra.ShellCompDirectiveNoFileComp
+}
+
+// matchContainerNames returns the names of containers that match filter and
+// start with toComplete, leaving out those already in args.
+func matchContainerNames(containers []backend.ContainerInfo, args []string, toComplete string, filter func(backend.ContainerInfo) bool) []string {
+	var names []string
+	for _, ctr := range containers {
+		if filter(ctr) && strings.HasPrefix(ctr.Name, toComplete) && !slices.Contains(args, ctr.Name) && !slices.Contains(names, ctr.Name) {
+			names = append(names, ctr.Name)
+		}
+	}
+	return names
+}
+
+// containerJSON is a container as printed by ls --format json.
+type containerJSON struct {
+	Name        string    `json:"name"`
+	Image       string    `json:"image"`
+	Backend     string    `json:"backend"`
+	Status      string    `json:"status"`
+	Running     bool      `json:"running"`
+	MemoryBytes uint64    `json:"memory_bytes,omitempty"`
+	Created     time.Time `json:"created,omitzero"`
+	Branch      string    `json:"branch,omitempty"`
+	Worktree    string    `json:"worktree,omitempty"`
+}
+
+// runList prints silo containers from each backend. With runningOnly, stopped
+// containers are skipped.
+func runList(cmd *cobra.Command, _ []string, stdout, stderr io.Writer, runningOnly bool) error {
+	quietFlag, _ := cmd.Flags().GetBool("quiet")
+	format, err := formatFlag(cmd, "table", "json")
+	if err != nil {
+		return err
+	}
+	jsonRows := []containerJSON{}
+
+	hasContainers := false
+
+	// Collect all container info first to calculate column widths
+	type containerRow struct {
+		name        string
+		image       string
+		backendType string
+		memory      string
+		status      string
+		branch      string
+		worktree    string
+	}
+	var rows []containerRow
+	hasWorktrees := false
+
+	results, err := queryBackends(cmd, listTimeout, func(ctx context.Context, _ string, b backend.Backend) ([]backend.ContainerInfo, error) {
+		return b.List(ctx)
+	})
+	if err != nil {
+		return err
+	}
+	for _, res := range results {
+		backendType := res.backendType
+		if res.clientErr != nil {
+			if !quietFlag {
+				cli.LogWarningTo(stderr, "%s not available: %v", backendLabel(backendType), res.clientErr)
+			}
+			continue
+		}
+		if res.err != nil {
+			if !quietFlag {
+				cli.LogWarningTo(stderr, "Failed to list containers (%s): %v", backendType, res.err)
+			}
+			continue
+		}
+
+		for _, ctr := range res.value {
+			if runningOnly && !ctr.IsRunning {
+				continue
+			}
+			hasContainers = true
+			if quietFlag {
+				fmt.Fprintln(stdout, ctr.Name)
+			} else if format == "json" {
+				jsonRows = append(jsonRows, containerJSON{
+					Name:        ctr.Name,
+					Image:       ctr.Image,
+					Backend:     backendType,
+					Status:      ctr.Status,
+					Running:     ctr.IsRunning,
+					MemoryBytes: ctr.MemoryUsage,
+					Created:     ctr.Created,
+					Branch:      ctr.Labels[backend.LabelBranch],
+					Worktree:    ctr.Labels[backend.LabelWorktree],
+				})
+			} else {
+				rows = append(rows, containerRow{
+					name:        ctr.Name,
+					image:       ctr.Image,
+					backendType: backendType,
+					memory:      formatMemoryUsage(ctr.MemoryUsage, ctr.IsRunning),
+					status:      ctr.Status,
+					branch:      ctr.Labels[backend.LabelBranch],
+					worktree:    tilde.Path(ctr.Labels[backend.LabelWorktree]),
+				})
+				if ctr.Labels[backend.LabelBranch] != "" {
+					hasWorktrees = true
+				}
+			}
+		}
+	}
+
+	// Print table with dynamic column widths
+	if len(rows) > 0 {
+		// Calculate max widths for each column
+		nameWidth := len("NAME")
+		imageWidth := len("IMAGE")
+		backendWidth := len("BACKEND")
+		memoryWidth := len("MEMORY")
+		branchWidth := len("BRANCH")
+		worktreeWidth := len("WORKTREE")
+
+		for _, r := range rows {
+			if len(r.name) > nameWidth {
+				nameWidth = len(r.name)
+			}
+			if len(r.image) > imageWidth {
+				imageWidth = len(r.image)
+			}
+			if len(r.backendType) > backendWidth {
+				backendWidth = len(r.backendType)
+			}
+			if len(r.memory) > memoryWidth {
+				memoryWidth = len(r.memory)
+			}
+			if len(r.branch) > branchWidth {
+				branchWidth = len(r.branch)
+			}
+			if len(r.worktree) > worktreeWidth {
+				worktreeWidth = len(r.worktree)
+			}
+		}
+
+		// Branch and worktree columns are only shown when a container is
+		// bound to a worktree (see silo fanout and --worktree)
+		if hasWorktrees {
+			format := fmt.Sprintf("%%-%ds  %%-%ds  %%-%ds  %%-%ds  %%-%ds  %%-%ds  %%s\n",
+				nameWidth, branchWidth, worktreeWidth, imageWidth, backendWidth, memoryWidth)
+			fmt.Fprintf(stdout, format, "NAME", "BRANCH", "WORKTREE", "IMAGE", "BACKEND", "MEMORY", "STATUS")
+			for _, r := range rows {
+				fmt.Fprintf(stdout, format, r.name, r.branch, r.worktree, r.image, r.backendType, r.memory, r.status)
+			}
+		} else {
+			// Print header
+			format := fmt.Sprintf("%%-%ds  %%-%ds  %%-%ds  %%-%ds  %%s\n",
+				nameWidth, imageWidth, backendWidth, memoryWidth)
+			fmt.Fprintf(stdout, format, "NAME", "IMAGE", "BACKEND", "MEMORY", "STATUS")
+
+			// Print rows
+			for _, r := range rows {
+				fmt.Fprintf(stdout, format, r.name, r.image, r.backendType, r.memory, r.status)
+			}
+		}
+	}
+
+	if format == "json" {
+		return writeJSON(stdout, jsonRows)
+	}
+
+	if !hasContainers && !quietFlag {
+		if runningOnly {
+			cli.LogTo(stderr, "No running silo containers found")
+		} else {
+			cli.LogTo(stderr, "No silo containers found")
+		}
+	}
+
+	return nil
+}
+
+// versionJSON is the output of silo version --format json.
+type versionJSON struct {
+	Version        string               `json:"version"`
+	Commit         string               `json:"commit,omitempty"`
+	Date           string               `json:"date,omitempty"`
+	Go             string               `json:"go"`
+	Platform       string               `json:"platform"`
+	ScriptsVersion string               `json:"scripts_version"`
+	TemplateHash   string               `json:"template_hash"`
+	SchemaHash     string               `json:"schema_hash"`
+	Backends       []backendVersionJSON `json:"backends"`
+}
+
+// backendVersionJSON is a backend in the output of silo version.
+type backendVersionJSON struct {
+	Name      string `json:"name"`
+	Available bool   `json:"available"`
+	Version   string `json:"version,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// pseudoVersionRegex matches the pseudo-versions Go gives untagged commits,
+// such as v0.0.0-20260102150405-0123456789ab.
+var pseudoVersionRegex = regexp.MustCompile(`\d{14}-[0-9a-f]{12}(\+dirty)?$`)
+
+// buildVersion returns the version, commit, and build date of silo,
+// preferring those set with -ldflags and falling back to the build info.
+func buildVersion() (v, rev, built string) {
+	v, rev, built = version, commit, date
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v, rev, built
+	}
+	// Installed with go install module@version, rather than built from a
+	// checkout, which Go stamps with a pseudo-version
+	if v == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" && !pseudoVersionRegex.MatchString(bi.Main.Version) {
+		v = bi.Main.Version
+	}
+	var fromVCS, modified bool
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if rev == "" {
+				rev, fromVCS = s.Value, true
+			}
+		case "vcs.time":
+			if built == "" {
+				built = s.Value
+			}
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if fromVCS && modified {
+		rev += "-dirty"
+	}
+	return v, rev, built
+}
+
+func runVersion(cmd *cobra.Command, stdout io.Writer) error {
+	format, err := formatFlag(cmd, "text", "json")
+	if err != nil {
+		return err
+	}
+
+	v, rev, built := buildVersion()
+	out := versionJSON{
+		Version:        v,
+		Commit:         rev,
+		Date:           built,
+		Go:             runtime.Version(),
+		Platform:       runtime.GOOS + "/" + runtime.GOARCH,
+		ScriptsVersion: mountwait.ScriptVersion,
+		TemplateHash:   run.TemplateHash(Dockerfile(supportedTools)),
+		SchemaHash:     fmt.Sprintf("%x", sha256.Sum256(configSchema))[:12],
+	}
+	infos := probeBackends(context.Background())
+	for i, name := range []string{"docker", "container", "sandbox"} {
+		out.Backends = append(out.Backends, backendVersionJSON{
+			Name:      name,
+			Available: infos[i].Available,
+			Version:   infos[i].Version,
+			Detail:    infos[i].Detail,
+		})
+	}
+
+	if format == "json" {
+		return writeJSON(stdout, out)
+	}
+
+	row := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(stdout, "%-10s  %s\n", name, value)
+		}
+	}
+	row("silo", out.Version)
+	row("commit", out.Commit)
+	row("built", out.Date)
+	row("go", out.Go+" "+out.Platform)
+	row("scripts", out.ScriptsVersion)
+	row("template", out.TemplateHash)
+	row("schema", out.SchemaHash)
+	for _, b := range out.Backends {
+		switch {
+		case b.Available && b.Version != "":
+			row(b.Name, b.Version)
+		case b.Available:
+			row(b.Name, "available")
+		default:
+			row(b.Name, "unavailable ("+b.Detail+")")
+		}
+	}
+	return nil
+}
+
+// updateTimeout bounds how long silo update waits for GitHub, including
+// downloading the release.
+const updateTimeout = 5 * time.Minute
+
+// runUpdate replaces silo with its latest release if it's newer, or with
+// --check reports whether it is.
+func runUpdate(cmd *cobra.Command, stdout, stderr io.Writer) error {
+	check, _ := cmd.Flags().GetBool("check")
+	force, _ := cmd.Flags().GetBool("force")
+
+	ctx, cancel := context.WithTimeout(cmd.Context(), updateTimeout)
+	defer cancel()
+	rel, err := selfupdate.Latest(ctx)
+	if err != nil {
+		return err
+	}
+	newer := selfupdate.Newer(rel.Version, version)
+
+	if check {
+		switch {
+		case newer:
+			fmt.Fprintf(stdout, "silo %s is available, this is %s: %s\n", rel.Version, version, rel.URL)
+			return &backend.ExitError{Code: 1}
+		case rel.Version == version:
+			fmt.Fprintf(stdout, "silo %s is up to date\n", version)
+		default:
+			fmt.Fprintf(stdout, "silo %s is the latest release, this is %s\n", rel.Version, version)
+		}
+		return nil
 	}
 
-	if _, err := os.Stat(configPath); err == nil {
-		return fmt.Errorf("config file already exists: %s", configPath)
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the silo executable: %w", err)
 	}
-
-	if err := os.WriteFile(configPath, []byte(sampleConfig), 0644); err != nil {
-		return fmt.Errorf("failed to write config: %w", err)
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil && strings.Contains(resolved, "/Cellar/") {
+		return fmt.Errorf("silo was installed with Homebrew, update it with: brew upgrade --fetch-head silo")
+	}
+	if !newer && !force {
+		// Builds from source have no release version to compare with
+		if !strings.HasPrefix(version, "v") {
+			return fmt.Errorf("silo %s was built from source, update it with: go install github.com/%s@latest, or install release %s from %s", version, selfupdate.Repo, rel.Version, rel.URL)
+		}
+		cli.LogSuccessTo(stderr, "silo %s is up to date", version)
+		return nil
 	}
 
-	cli.LogSuccessTo(stderr, "Created %s", configPath)
+	cli.LogTo(stderr, "Downloading silo %s for %s/%s...", rel.Version, runtime.GOOS, runtime.GOARCH)
+	binary, err := selfupdate.Download(ctx, rel, runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		return err
+	}
+	if err := selfupdate.Replace(exe, binary); err != nil {
+		return err
+	}
+	cli.LogSuccessTo(stderr, "Updated silo %s to %s", version, rel.Version)
 	return nil
 }
 
-func runRemove(cmd *cobra.Command, args []string, stderr io.Writer) error {
-	ctx := context.Background()
-
-	backendFlag, _ := cmd.Flags().GetString("backend")
+// backendProbes probe the docker, container and sandbox backends. Tests
+// replace them so they don't depend on what the host has installed.
+var backendProbes = []func(context.Context) backend.Info{docker.Probe, applecontainer.Probe, sandbox.Probe}
 
-	var backends []string
-	if backendFlag != "" {
-		backends = []string{backendFlag}
-	} else {
-		backends = []string{"docker", "container"}
+// probeBackends runs backendProbes concurrently and returns their info in
+// that order.
+func probeBackends(ctx context.Context) []backend.Info {
+	infos := make([]backend.Info, len(backendProbes))
+	var wg sync.WaitGroup
+	for i, probe := range backendProbes {
+		wg.Go(func() { infos[i] = probe(ctx) })
 	}
+	wg.Wait()
+	return infos
+}
 
-	for _, backendType := range backends {
-		var backendClient backend.Backend
-		var err error
+func runBackends(stdout, stderr io.Writer) error {
+	ctx := context.Background()
 
-		switch backendType {
-		case "docker":
-			backendClient, err = docker.NewClient()
-			if err != nil {
-				cli.LogWarningTo(stderr, "Docker not available: %v", err)
-				continue
-			}
-		case "container":
-			backendClient, err = applecontainer.NewClient()
-			if err != nil {
-				cli.LogWarningTo(stderr, "Container backend not available: %v", err)
-				continue
-			}
-		default:
-			return fmt.Errorf("unknown backend: %s", backendType)
+	type backendRow struct {
+		name     string
+		info     backend.Info
+		features backend.Features
+	}
+	infos := probeBackends(ctx)
+	rows := []backendRow{
+		{name: "docker", info: infos[0], features: docker.Features},
+		{name: "container", info: infos[1], features: applecontainer.Features},
+		{name: "sandbox", info: infos[2], features: sandbox.Features},
+	}
+
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
 		}
+		return "no"
+	}
 
-		removed, err := backendClient.Remove(ctx, args)
-		backendClient.Close()
-		if err != nil {
-			cli.LogWarningTo(stderr, "failed to remove containers (%s): %v", backendType, err)
-			continue
+	format := "%-10s  %-11s  %-8s  %-11s  %-5s  %-5s  %-4s  %-7s  %-6s  %-17s  %s\n"
+	fmt.Fprintf(stdout, format, "BACKEND", "STATUS", "VERSION", "FILE MOUNTS", "PORTS", "STATS", "EXEC", "PERSIST", "ATTACH", "NETWORK ISOLATION", "SERVICES")
+	for _, r := range rows {
+		status := "available"
+		if !r.info.Available {
+			status = "unavailable"
 		}
+		version := r.info.Version
+		if version == "" {
+			version = "-"
+		}
+		f := r.features
+		fmt.Fprintf(stdout, format, r.name, status, version,
+			yesNo(f.FileMounts), yesNo(f.Ports), yesNo(f.Stats), yesNo(f.Exec),
+			yesNo(f.Persist), yesNo(f.Attach), yesNo(f.NetworkIsolation), yesNo(f.Services))
+	}
+	fmt.Fprintln(stdout)
 
-		for _, name := range removed {
-			cli.LogTo(stderr, "Removed %s (%s)", name, backendType)
+	for _, r := range rows {
+		if r.info.Detail != "" {
+			fmt.Fprintf(stdout, "%s: %s\n", r.name, r.info.Detail)
 		}
 	}
 
+	cfg, src := config.LoadAllWithSources(toolDefaults())
+	selected, reason := run.SelectBackend(cfg.Backend)
+	if cfg.Backend != "" {
+		reason = "set in " + tilde.Path(src.Backend)
+	}
+	fmt.Fprintf(stdout, "Selected: %s (%s)\n", selected, reason)
+	if len(cfg.BackendFallback) > 0 {
+		fmt.Fprintf(stdout, "Fallback: %s\n", strings.Join(cfg.BackendFallback, ", "))
+	}
+	for _, r := range rows {
+		if r.name == selected && !r.info.Available {
+			cli.LogWarningTo(stderr, "The selected backend is not available")
+		}
+	}
 	return nil
 }
 
-func runExec(cmd *cobra.Command, name string, command []string, stderr io.Writer) error {
-	ctx := context.Background()
+// reviewEntry returns the latest session to review: the one that ran in
+// container if it is set, and otherwise in dir. Only sessions that recorded
+// the commit they started from can be reviewed.
+func reviewEntry(entries []journal.Entry, container, dir string) (journal.Entry, bool) {
+	for _, e := range slices.Backward(entries) {
+		if e.Head == "" {
+			continue
+		}
+		if container != "" && e.Container == container || container == "" && e.Dir == dir {
+			return e, true
+		}
+	}
+	return journal.Entry{}, false
+}
 
-	backendFlag, _ := cmd.Flags().GetString("backend")
+// docsTopicNames returns the names of the documentation topics.
+func docsTopicNames() []string {
+	var names []string
+	for _, t := range docs.Topics {
+		names = append(names, t.Name)
+	}
+	return names
+}
 
-	var backends []string
-	if backendFlag != "" {
-		backends = []string{backendFlag}
-	} else {
-		backends = []string{"docker", "container"}
+func runDocs(cmd *cobra.Command, args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		nameWidth := len("TOPIC")
+		for _, t := range docs.Topics {
+			nameWidth = max(nameWidth, len(t.Name))
+		}
+		format := fmt.Sprintf("%%-%ds  %%s\n", nameWidth)
+		fmt.Fprintf(stdout, format, "TOPIC", "DESCRIPTION")
+		for _, t := range docs.Topics {
+			fmt.Fprintf(stdout, format, t.Name, t.Title)
+		}
+		return nil
+	}
+	md, err := docs.Markdown(args[0], configSchema)
+	if err != nil {
+		return err
 	}
 
-	for _, backendType := range backends {
-		var backendClient backend.Backend
-		var err error
+	noPager, _ := cmd.Flags().GetBool("no-pager")
+	f, ok := stdout.(*os.File)
+	if !ok || !isatty.IsTerminal(f.Fd()) {
+		_, err := io.WriteString(stdout, docs.Render(md, 80, true))
+		return err
+	}
+	width := 80
+	if ws, err := term.GetWinsize(f.Fd()); err == nil && ws.Width > 0 {
+		width = min(int(ws.Width), 100)
+	}
+	out := docs.Render(md, width, cli.Accessible())
+	if noPager {
+		_, err := io.WriteString(stdout, out)
+		return err
+	}
+	return page(out, stdout)
+}
 
-		switch backendType {
-		case "docker":
-			backendClient, err = docker.NewClient()
-			if err != nil {
-				continue
-			}
-		case "container":
-			backendClient, err = applecontainer.NewClient()
-			if err != nil {
-				continue
-			}
-		default:
-			return fmt.Errorf("unknown backend: %s", backendType)
-		}
+// page shows s in $PAGER, or less, writing it to w instead if the pager
+// can't be started.
+func page(s string, w io.Writer) error {
+	pager := cmp.Or(strings.TrimSpace(os.Getenv("PAGER")), "less")
+	if _, err := exec.LookPath(strings.Fields(pager)[0]); err != nil {
+		_, err := io.WriteString(w, s)
+		return err
+	}
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdin = strings.NewReader(s)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	// Show colors, and exit at once if it fits on one screen
+	if os.Getenv("LESS") == "" {
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	// The pager's exit status is ignored, as it can be quit before the end
+	_ = cmd.Run()
+	return nil
+}
 
-		err = backendClient.Exec(ctx, name, command)
-		backendClient.Close()
+func runMerge(container string, stderr io.Writer) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	entries, err := journal.Read()
+	if err != nil {
+		return err
+	}
+	entry, ok := reviewEntry(entries, container, "")
+	if !ok {
+		return fmt.Errorf("no session of %s to merge", container)
+	}
+	branch := entry.Branch
+	if branch == "" {
+		// Entries written before branches were recorded
+		branch = git.Branch(entry.Dir)
+	}
+	if branch == "" {
+		return fmt.Errorf("%s didn't run on a branch", container)
+	}
+	current := git.Branch(cwd)
+	if current == "" {
+		return fmt.Errorf("no branch checked out in %s to merge into", tilde.Path(cwd))
+	}
+	if current == branch {
+		return fmt.Errorf("%s is checked out in %s, run silo merge where the branch to merge into is checked out", branch, tilde.Path(cwd))
+	}
 
-		if err == nil {
+	if err := git.Merge(cwd, branch); err != nil {
+		return err
+	}
+	cli.LogSuccessTo(stderr, "Merged %s into %s", branch, current)
+	if entry.Dir == cwd {
+		return nil
+	}
+	if _, err := os.Stat(entry.Dir); err != nil {
+		return nil
+	}
+	// Sandbox branches exist only for the session, so they go once merged
+	if strings.HasPrefix(branch, sandboxBranchPrefix) {
+		if err := git.RemoveWorktree(cwd, entry.Dir, branch); err != nil {
+			cli.LogWarningTo(stderr, "%v", err)
 			return nil
 		}
-
-		// If the error is "not found", try the next backend.
-		// If the error is something else (not running, exec failure), return it.
-		if !strings.Contains(err.Error(), "not found") {
-			return err
-		}
+		cli.LogTo(stderr, "Removed worktree %s and branch %s", tilde.Path(entry.Dir), branch)
+		return nil
 	}
-
-	return fmt.Errorf("container %s not found", name)
+	cli.LogTo(stderr, "Remove the session's worktree with: git worktree remove %s", entry.Dir)
+	return nil
 }
 
-func completeContainerNames(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-	// Only complete the first arg (container name)
+func runReview(args []string, stderr io.Writer) error {
+	var container string
 	if len(args) > 0 {
-		return nil, cobra.ShellCompDirectiveNoFileComp
+		container = args[0]
 	}
-
-	ctx := context.Background()
-	var names []string
-
-	// Try docker backend
-	if dc, err := docker.NewClient(); err == nil {
-		if containers, err := dc.List(ctx); err == nil {
-			for _, ctr := range containers {
-				if ctr.IsRunning && strings.HasPrefix(ctr.Name, toComplete) {
-					names = append(names, ctr.Name)
-				}
-			}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	entries, err := journal.Read()
+	if err != nil {
+		return err
+	}
+	entry, ok := reviewEntry(entries, container, cwd)
+	if !ok {
+		if container != "" {
+			return fmt.Errorf("no session of %s to review", container)
 		}
-		dc.Close()
+		return fmt.Errorf("no session in %s to review", tilde.Path(cwd))
 	}
 
-	// Try container backend
-	if cc, err := applecontainer.NewClient(); err == nil {
-		if containers, err := cc.List(ctx); err == nil {
-			for _, ctr := range containers {
-				if ctr.IsRunning && strings.HasPrefix(ctr.Name, toComplete) {
-					names = append(names, ctr.Name)
-				}
-			}
+	commits, err := git.Commits(entry.Dir, entry.Head)
+	if err != nil {
+		return err
+	}
+	if len(commits) == 0 {
+		cli.LogTo(stderr, "No commits since %s started at %s", entry.Container, entry.Head[:12])
+		return nil
+	}
+	if !isatty.IsTerminal(os.Stdin.Fd()) {
+		return fmt.Errorf("silo review needs a terminal")
+	}
+
+	cli.LogTo(stderr, "%d commits since %s started in %s", len(commits), entry.Container, tilde.Path(entry.Dir))
+	actions := make([]string, len(commits))
+	var fields []huh.Field
+	for i, c := range commits {
+		actions[i] = git.Keep
+		options := []huh.Option[string]{huh.NewOption("Keep", git.Keep)}
+		if i > 0 {
+			options = append(options, huh.NewOption("Squash into the commit before", git.Squash))
 		}
-		cc.Close()
+		options = append(options, huh.NewOption("Drop", git.Drop))
+		fields = append(fields, huh.NewSelect[string]().
+			Title(c.Hash[:12]+" "+c.Subject).
+			Options(options...).
+			Value(&actions[i]))
+	}
+	confirmed := false
+	fields = append(fields, huh.NewConfirm().
+		Title("Rewrite the branch?").
+		Affirmative("Rewrite").
+		Negative("Cancel").
+		Value(&confirmed))
+	form := huh.NewForm(huh.NewGroup(fields...))
+	if err := form.WithAccessible(cli.Accessible()).Run(); err != nil || !confirmed {
+		return fmt.Errorf("review cancelled")
 	}
 
-	return names, cobra.ShellCompDirectiveNoFileComp
+	if !slices.ContainsFunc(actions, func(a string) bool { return a != git.Keep }) {
+		cli.LogTo(stderr, "Nothing to change")
+		return nil
+	}
+	if err := git.Rewrite(entry.Dir, entry.Head, commits, actions); err != nil {
+		return err
+	}
+	cli.LogSuccessTo(stderr, "Rewrote %d commits: %d kept, %d squashed, %d dropped", len(commits),
+		countActions(actions, git.Keep), countActions(actions, git.Squash), countActions(actions, git.Drop))
+	return nil
 }
 
-func runList(cmd *cobra.Command, _ []string, stdout, stderr io.Writer) error {
-	ctx := context.Background()
-
-	backendFlag, _ := cmd.Flags().GetString("backend")
-	quietFlag, _ := cmd.Flags().GetBool("quiet")
+// countActions returns how many of actions are action.
+func countActions(actions []string, action string) int {
+	n := 0
+	for _, a := range actions {
+		if a == action {
+			n++
+		}
+	}
+	return n
+}
 
-	var backends []string
-	if backendFlag != "" {
-		backends = []string{backendFlag}
-	} else {
-		backends = []string{"docker", "container"}
+func runHistory(cmd *cobra.Command, stdout, stderr io.Writer) error {
+	tool, _ := cmd.Flags().GetString("tool")
+	dir, _ := cmd.Flags().GetString("dir")
+	since, _ := cmd.Flags().GetString("since")
+	limit, _ := cmd.Flags().GetInt("limit")
+	format, err := formatFlag(cmd, "table", "json")
+	if err != nil {
+		return err
 	}
 
-	hasContainers := false
+	var after time.Time
+	if since != "" {
+		d, err := prune.ParseAge(since)
+		if err != nil {
+			return fmt.Errorf("invalid --since: %q (e.g. 7d, 12h)", since)
+		}
+		after = time.Now().Add(-d)
+	}
+	if dir != "" {
+		dir, err = filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+	}
 
-	// Collect all container info first to calculate column widths
-	type containerRow struct {
-		name        string
-		image       string
-		backendType string
-		memory      string
-		status      string
+	entries, err := journal.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read run journal: %w", err)
 	}
-	var rows []containerRow
+	matched := filterHistory(entries, tool, dir, after, limit)
 
-	for _, backendType := range backends {
-		var backendClient backend.Backend
-		var err error
+	if format == "json" {
+		return writeJSON(stdout, matched)
+	}
+	if len(matched) == 0 {
+		cli.LogTo(stderr, "No runs found")
+		return nil
+	}
 
-		switch backendType {
-		case "docker":
-			backendClient, err = docker.NewClient()
-			if err != nil {
-				if !quietFlag {
-					cli.LogWarningTo(stderr, "Docker not available: %v", err)
-				}
-				continue
-			}
-		case "container":
-			backendClient, err = applecontainer.NewClient()
-			if err != nil {
-				if !quietFlag {
-					cli.LogWarningTo(stderr, "Container backend not available: %v", err)
-				}
-				continue
+	type historyRow struct {
+		time, tool, backend, container, exit, duration, dir string
+	}
+	rows := make([]historyRow, len(matched))
+	widths := []int{len("TIME"), len("TOOL"), len("BACKEND"), len("CONTAINER"), len("EXIT"), len("DURATION")}
+	for i, e := range matched {
+		r := historyRow{
+			time:      e.Time.Local().Format("2006-01-02 15:04"),
+			tool:      e.Tool,
+			backend:   e.Backend,
+			container: e.Container,
+			exit:      "-",
+			duration:  "-",
+			dir:       tilde.Path(e.Dir),
+		}
+		switch {
+		case e.ExitCode != nil:
+			r.exit = strconv.Itoa(*e.ExitCode)
+		case e.Error != "":
+			r.exit = "error"
+		case e.Detached:
+			r.exit = "detached"
+		}
+		if e.SessionSeconds > 0 {
+			r.duration = (time.Duration(e.SessionSeconds) * time.Second).String()
+		}
+		for _, s := range []*string{&r.container, &r.dir} {
+			if *s == "" {
+				*s = "-"
 			}
-		default:
-			return fmt.Errorf("unknown backend: %s", backendType)
 		}
+		for j, s := range []string{r.time, r.tool, r.backend, r.container, r.exit, r.duration} {
+			widths[j] = max(widths[j], len(s))
+		}
+		rows[i] = r
+	}
+	rowFormat := fmt.Sprintf("%%-%ds  %%-%ds  %%-%ds  %%-%ds  %%-%ds  %%-%ds  %%s\n",
+		widths[0], widths[1], widths[2], widths[3], widths[4], widths[5])
+	fmt.Fprintf(stdout, rowFormat, "TIME", "TOOL", "BACKEND", "CONTAINER", "EXIT", "DURATION", "DIR")
+	for _, r := range rows {
+		fmt.Fprintf(stdout, rowFormat, r.time, r.tool, r.backend, r.container, r.exit, r.duration, r.dir)
+	}
+	return nil
+}
 
-		containers, err := backendClient.List(ctx)
-		backendClient.Close()
-		if err != nil {
-			if !quietFlag {
-				cli.LogWarningTo(stderr, "Failed to list containers (%s): %v", backendType, err)
-			}
+// filterHistory returns the journal entries matching tool, within dir, and
+// after the given time, most recent first. Empty filters match everything.
+// At most limit entries are returned, unless limit is 0.
+func filterHistory(entries []journal.Entry, tool, dir string, after time.Time, limit int) []journal.Entry {
+	matched := []journal.Entry{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		switch {
+		case tool != "" && e.Tool != tool:
+			continue
+		case dir != "" && e.Dir != dir && !strings.HasPrefix(e.Dir, dir+string(filepath.Separator)):
+			continue
+		case e.Time.Before(after):
 			continue
 		}
+		matched = append(matched, e)
+		if limit > 0 && len(matched) == limit {
+			break
+		}
+	}
+	return matched
+}
 
-		for _, ctr := range containers {
-			hasContainers = true
-			if quietFlag {
-				fmt.Fprintln(stdout, ctr.Name)
-			} else {
-				rows = append(rows, containerRow{
-					name:        ctr.Name,
-					image:       ctr.Image,
-					backendType: backendType,
-					memory:      formatMemoryUsage(ctr.MemoryUsage, ctr.IsRunning),
-					status:      ctr.Status,
-				})
-			}
+// runStats prints the usage of each tool from the run journal.
+func runStats(cmd *cobra.Command, stdout, stderr io.Writer) error {
+	since, _ := cmd.Flags().GetString("since")
+	period, _ := cmd.Flags().GetString("period")
+	format, err := formatFlag(cmd, "table", "json")
+	if err != nil {
+		return err
+	}
+
+	entries, err := journal.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read run journal: %w", err)
+	}
+	if since != "" {
+		d, err := prune.ParseAge(since)
+		if err != nil {
+			return fmt.Errorf("invalid --since: %q (e.g. 30d, 12h)", since)
 		}
+		after := time.Now().Add(-d)
+		entries = slices.DeleteFunc(entries, func(e journal.Entry) bool { return e.Time.Before(after) })
+	}
+	summaries, err := stats.Summarize(entries, period)
+	if err != nil {
+		return err
 	}
 
-	// Print table with dynamic column widths
-	if len(rows) > 0 {
-		// Calculate max widths for each column
-		nameWidth := len("NAME")
-		imageWidth := len("IMAGE")
-		backendWidth := len("BACKEND")
-		memoryWidth := len("MEMORY")
+	if format == "json" {
+		return writeJSON(stdout, summaries)
+	}
+	if len(summaries) == 0 {
+		cli.LogTo(stderr, "No runs found")
+		return nil
+	}
 
-		for _, r := range rows {
-			if len(r.name) > nameWidth {
-				nameWidth = len(r.name)
-			}
-			if len(r.image) > imageWidth {
-				imageWidth = len(r.image)
-			}
-			if len(r.backendType) > backendWidth {
-				backendWidth = len(r.backendType)
-			}
-			if len(r.memory) > memoryWidth {
-				memoryWidth = len(r.memory)
+	header := []string{"TOOL", "SESSIONS", "RUNTIME", "BUILDS", "BUILD TIME", "CACHE HITS", "LAST USED"}
+	if period != "" {
+		header = append([]string{strings.ToUpper(period)}, header...)
+	}
+	rows := [][]string{header}
+	for _, s := range summaries {
+		hits := "-"
+		if s.CacheHitRate != nil {
+			hits = fmt.Sprintf("%.0f%%", *s.CacheHitRate*100)
+		}
+		row := []string{
+			s.Tool,
+			strconv.Itoa(s.Sessions),
+			statsDuration(s.SessionSeconds),
+			strconv.Itoa(s.Builds),
+			statsDuration(s.BuildSeconds),
+			hits,
+			s.LastSession.Local().Format("2006-01-02"),
+		}
+		if period != "" {
+			row = append([]string{s.Period}, row...)
+		}
+		rows = append(rows, row)
+	}
+	widths := make([]int, len(header))
+	for _, row := range rows {
+		for i, c := range row {
+			widths[i] = max(widths[i], len(c))
+		}
+	}
+	for _, row := range rows {
+		var b strings.Builder
+		for i, c := range row {
+			if i == len(row)-1 {
+				b.WriteString(c)
+			} else {
+				fmt.Fprintf(&b, "%-*s  ", widths[i], c)
 			}
 		}
+		fmt.Fprintln(stdout, b.String())
+	}
+	return nil
+}
+
+// statsDuration formats seconds as a duration rounded to the minute, or
+// the second when under a minute, e.g. 3h25m or 42s.
+func statsDuration(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second))
+	if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+	return strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+}
 
-		// Print header
-		format := fmt.Sprintf("%%-%ds  %%-%ds  %%-%ds  %%-%ds  %%s\n",
-			nameWidth, imageWidth, backendWidth, memoryWidth)
-		fmt.Fprintf(stdout, format, "NAME", "IMAGE", "BACKEND", "MEMORY", "STATUS")
+func runStatsExport(cmd *cobra.Command, stdout io.Writer) error {
+	format, _ := cmd.Flags().GetString("format")
+	output, _ := cmd.Flags().GetString("output")
 
-		// Print rows
-		for _, r := range rows {
-			fmt.Fprintf(stdout, format, r.name, r.image, r.backendType, r.memory, r.status)
-		}
+	if format != "prometheus" {
+		return fmt.Errorf("unknown format: %s (valid formats: prometheus)", format)
 	}
 
-	if !hasContainers && !quietFlag {
-		cli.LogTo(stderr, "No silo containers found")
+	entries, err := journal.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read run journal: %w", err)
 	}
 
+	if output == "" {
+		return stats.WritePrometheus(stdout, entries)
+	}
+
+	// Written atomically so scrapers never read a partially written file
+	var buf bytes.Buffer
+	if err := stats.WritePrometheus(&buf, entries); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	if err := fileutil.WriteFile(output, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
 	return nil
 }
 
@@ -2,14 +2,26 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"4d63.com/testcli"
 	"github.com/adrg/xdg"
+	"github.com/leighmcculloch/silo/backend"
+	"github.com/leighmcculloch/silo/config"
+	"github.com/leighmcculloch/silo/journal"
+	"github.com/leighmcculloch/silo/queue"
+	"github.com/leighmcculloch/silo/selfupdate"
+	"github.com/spf13/cobra"
 )
 
 // mainFunc wraps our runMain function to match testcli.MainFunc signature
@@ -63,6 +75,49 @@ func TestHelp(t *testing.T) {
 	}
 }
 
+func TestLifecycleCommandsHelp(t *testing.T) {
+	exitCode, stdout, _ := testcli.Main(t, []string{"--help"}, nil, mainFunc)
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d", exitCode)
+	}
+	for _, name := range []string{"stop", "start", "attach", "ps", "logs", "replay", "kill", "fanout", "batch", "queue", "prefetch"} {
+		if !strings.Contains(stdout, "  "+name+" ") {
+			t.Errorf("expected %s command in help output", name)
+		}
+	}
+	for _, flag := range []string{"--keep", "--detach", "--worktree", "--record", "--offline"} {
+		if !strings.Contains(stdout, flag) {
+			t.Errorf("expected %s flag in help output", flag)
+		}
+	}
+
+	exitCode, stdout, _ = testcli.Main(t, []string{"start", "--help"}, nil, mainFunc)
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d", exitCode)
+	}
+	if !strings.Contains(stdout, "--attach") {
+		t.Error("expected --attach flag in start help output")
+	}
+}
+
+func TestBackendsCommand(t *testing.T) {
+	tmpDir := testcli.MkdirTemp(t)
+	testcli.Chdir(t, tmpDir)
+	if err := os.WriteFile(filepath.Join(tmpDir, "silo.jsonc"), []byte(`{"backend": "docker"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	exitCode, stdout, stderr := testcli.Main(t, []string{"backends"}, nil, mainFunc)
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d, stderr: %s", exitCode, stderr)
+	}
+	for _, want := range []string{"BACKEND", "docker", "container", "FILE MOUNTS", "Selected: docker (set in"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("expected %q in output, got:\n%s", want, stdout)
+		}
+	}
+}
+
 func TestVersion(t *testing.T) {
 	exitCode, stdout, _ := testcli.Main(t, []string{"--version"}, nil, mainFunc)
 
@@ -75,6 +130,78 @@ func TestVersion(t *testing.T) {
 	}
 }
 
+func TestVersionCommand(t *testing.T) {
+	defer func(p []func(context.Context) backend.Info) { backendProbes = p }(backendProbes)
+	backendProbes = []func(context.Context) backend.Info{
+		func(context.Context) backend.Info { return backend.Info{Available: true, Version: "28.0.0"} },
+		func(context.Context) backend.Info { return backend.Info{Detail: "not installed"} },
+		func(context.Context) backend.Info { return backend.Info{Available: true} },
+	}
+
+	exitCode, stdout, _ := testcli.Main(t, []string{"version", "--format", "json"}, nil, mainFunc)
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d", exitCode)
+	}
+	var got versionJSON
+	if err := json.Unmarshal([]byte(stdout), &got); err != nil {
+		t.Fatalf("invalid json: %v\n%s", err, stdout)
+	}
+	if got.Version == "" || got.Go == "" || len(got.TemplateHash) != 12 || len(got.SchemaHash) != 12 {
+		t.Errorf("missing fields: %+v", got)
+	}
+	var names []string
+	for _, b := range got.Backends {
+		names = append(names, b.Name)
+	}
+	if !slices.Equal(names, []string{"docker", "container", "sandbox"}) {
+		t.Errorf("backends = %v", names)
+	}
+	if b := got.Backends[0]; !b.Available || b.Version != "28.0.0" {
+		t.Errorf("docker backend = %+v, want the probed info", b)
+	}
+}
+
+func TestUpdateCheck(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"tag_name":"v99.0.0","html_url":"https://example.com/v99.0.0","assets":[]}`))
+	}))
+	defer srv.Close()
+	defer func(u, v string) { selfupdate.APIURL, version = u, v }(selfupdate.APIURL, version)
+	selfupdate.APIURL, version = srv.URL, "v1.0.0"
+
+	exitCode, stdout, stderr := testcli.Main(t, []string{"update", "--check"}, nil, mainFunc)
+	if exitCode != 1 || stderr != "" {
+		t.Fatalf("expected exit code 1 and no error, got %d: %s", exitCode, stderr)
+	}
+	if want := "silo v99.0.0 is available, this is v1.0.0: https://example.com/v99.0.0"; !strings.Contains(stdout, want) {
+		t.Errorf("expected %q, got: %s", want, stdout)
+	}
+
+	version = "v99.0.0"
+	if exitCode, stdout, stderr = testcli.Main(t, []string{"update", "--check"}, nil, mainFunc); exitCode != 0 || !strings.Contains(stdout, "up to date") {
+		t.Errorf("expected exit code 0 when up to date, got %d: %s%s", exitCode, stdout, stderr)
+	}
+
+	version = "dev"
+	exitCode, _, stderr = testcli.Main(t, []string{"update"}, nil, mainFunc)
+	if exitCode == 0 || !strings.Contains(stderr, "built from source") {
+		t.Errorf("expected a build from source not to be replaced without --force, got %d: %s", exitCode, stderr)
+	}
+}
+
+func TestPseudoVersion(t *testing.T) {
+	for v, want := range map[string]bool{
+		"v1.2.3":                                   false,
+		"v0.0.0-20261018012755-688ce3ee3dd7":       true,
+		"v0.0.0-20261018012755-688ce3ee3dd7+dirty": true,
+		"v1.2.4-0.20261018012755-688ce3ee3dd7":     true,
+	} {
+		if got := pseudoVersionRegex.MatchString(v); got != want {
+			t.Errorf("pseudoVersionRegex.MatchString(%q) = %v, want %v", v, got, want)
+		}
+	}
+}
+
 func TestConfigShowCommand(t *testing.T) {
 	exitCode, stdout, _ := testcli.Main(t, []string{"config", "show"}, nil, mainFunc)
 
@@ -118,6 +245,61 @@ func TestConfigShowCommand(t *testing.T) {
 	}
 }
 
+func TestConfigShowJSON(t *testing.T) {
+	exitCode, stdout, stderr := testcli.Main(t, []string{"config", "show", "--format", "json"}, nil, mainFunc)
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d, stderr: %s", exitCode, stderr)
+	}
+	var cfg config.Config
+	if err := json.Unmarshal([]byte(stdout), &cfg); err != nil {
+		t.Fatalf("expected valid JSON, got %v:\n%s", err, stdout)
+	}
+	if _, ok := cfg.Tools["claude"]; !ok {
+		t.Error("expected claude tool in JSON output")
+	}
+}
+
+func TestConfigValidateCommand(t *testing.T) {
+	tmpDir := testcli.MkdirTemp(t)
+	valid := filepath.Join(tmpDir, "valid.jsonc")
+	invalid := filepath.Join(tmpDir, "invalid.jsonc")
+	if err := os.WriteFile(valid, []byte(`{"tool": "claude"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(invalid, []byte("{\n  \"tool\": \"claude\",\n  \"mount_ro\": []\n}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	exitCode, _, stderr := testcli.Main(t, []string{"config", "validate", valid}, nil, mainFunc)
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d, stderr: %s", exitCode, stderr)
+	}
+
+	exitCode, _, stderr = testcli.Main(t, []string{"config", "validate", valid, invalid}, nil, mainFunc)
+	if exitCode == 0 {
+		t.Fatal("expected non-zero exit code")
+	}
+	if !strings.Contains(stderr, invalid+`:3:3: unknown key "mount_ro"`) {
+		t.Errorf("expected unknown key error with position, got:\n%s", stderr)
+	}
+}
+
+func TestFormatFlag(t *testing.T) {
+	for _, args := range [][]string{
+		{"ls", "--format", "yaml"},
+		{"rm", "--format", "yaml", "x"},
+		{"config", "show", "--format", "table"},
+	} {
+		exitCode, _, stderr := testcli.Main(t, args, nil, mainFunc)
+		if exitCode == 0 {
+			t.Errorf("%v: expected non-zero exit code", args)
+		}
+		if !strings.Contains(stderr, "unknown format") {
+			t.Errorf("%v: expected unknown format error, got %q", args, stderr)
+		}
+	}
+}
+
 func TestConfigHelp(t *testing.T) {
 	exitCode, stdout, _ := testcli.Main(t, []string{"config", "--help"}, nil, mainFunc)
 
@@ -218,6 +400,49 @@ func TestInitCommandAlreadyExists(t *testing.T) {
 	}
 }
 
+func TestInitCommandMerge(t *testing.T) {
+	tmpDir := testcli.MkdirTemp(t)
+	testcli.Chdir(t, tmpDir)
+
+	configPath := filepath.Join(tmpDir, "silo.jsonc")
+	existing := "{\n  // keep this comment\n  \"tool\": \"claude\"\n}\n"
+	if err := os.WriteFile(configPath, []byte(existing), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	exitCode, _, stderr := testcli.Main(t, []string{"config", "init", "--local", "--merge"}, nil, mainFunc)
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d, stderr: %s", exitCode, stderr)
+	}
+
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("failed to read config file: %v", err)
+	}
+	if !strings.HasPrefix(string(content), "{\n  // keep this comment\n  \"tool\": \"claude\"\n") {
+		t.Errorf("expected existing content to be preserved, got:\n%s", content)
+	}
+	if !strings.Contains(string(content), `// "mounts_ro": []`) {
+		t.Errorf("expected mounts_ro template to be appended, got:\n%s", content)
+	}
+	if strings.Contains(string(content), `// "tool": "claude"`) {
+		t.Errorf("expected tool template to be skipped, got:\n%s", content)
+	}
+
+	// Running again has nothing left to add.
+	exitCode, _, stderr = testcli.Main(t, []string{"config", "init", "--local", "--merge"}, nil, mainFunc)
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d, stderr: %s", exitCode, stderr)
+	}
+	again, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(content) {
+		t.Errorf("expected second merge to leave the file unchanged")
+	}
+}
+
 func TestInitHelp(t *testing.T) {
 	exitCode, stdout, _ := testcli.Main(t, []string{"config", "init", "--help"}, nil, mainFunc)
 
@@ -276,6 +501,29 @@ func TestInvalidTool(t *testing.T) {
 	}
 }
 
+func TestBatchArgs(t *testing.T) {
+	testcli.Chdir(t, testcli.MkdirTemp(t))
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"batch", "claude"}, "at least one of the flags"},
+		{[]string{"batch", "claude", "--prompt", " "}, "the prompt is empty"},
+		{[]string{"batch", "claude", "extra", "--prompt", "hi"}, "pass arguments for claude after --"},
+		{[]string{"batch", "nope", "--prompt", "hi"}, "invalid tool: nope"},
+		{[]string{"batch", "claude", "--prompt-file", "missing.md"}, "failed to read prompt"},
+	}
+	for _, tt := range tests {
+		exitCode, _, stderr := testcli.Main(t, tt.args, nil, mainFunc)
+		if exitCode == 0 {
+			t.Errorf("%v: expected failure", tt.args)
+		}
+		if !strings.Contains(stderr, tt.want) {
+			t.Errorf("%v: expected %q in stderr, got: %s", tt.args, tt.want, stderr)
+		}
+	}
+}
+
 func TestCompletionCommand(t *testing.T) {
 	shells := []string{"bash", "zsh", "fish", "powershell"}
 
@@ -332,3 +580,297 @@ func TestRunMainFunction(t *testing.T) {
 		t.Error("expected help output")
 	}
 }
+
+func TestStatsExportPrometheus(t *testing.T) {
+	tmpDir := testcli.MkdirTemp(t)
+
+	oldState := os.Getenv("XDG_STATE_HOME")
+	os.Setenv("XDG_STATE_HOME", tmpDir)
+	xdg.Reload()
+	defer func() {
+		os.Setenv("XDG_STATE_HOME", oldState)
+		xdg.Reload()
+	}()
+
+	journalPath := filepath.Join(tmpDir, "silo", "runs.jsonl")
+	testcli.Mkdir(t, filepath.Dir(journalPath))
+	entry := `{"time":"2026-01-02T03:04:05Z","tool":"claude","backend":"docker","built":true,"build_seconds":12.5,"session_seconds":60}` + "\n"
+	if err := os.WriteFile(journalPath, []byte(entry), 0644); err != nil {
+		t.Fatalf("failed to write journal: %v", err)
+	}
+
+	outPath := filepath.Join(tmpDir, "silo.prom")
+	exitCode, _, stderr := testcli.Main(t, []string{"stats", "export", "--format", "prometheus", "-o", outPath}, nil, mainFunc)
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d, stderr: %s", exitCode, stderr)
+	}
+
+	content, err := os.ReadFile(outPath)
+	if err != nil {
+		t.Fatalf("failed to read metrics file: %v", err)
+	}
+	if !strings.Contains(string(content), `silo_sessions_total{tool="claude",backend="docker"} 1`) {
+		t.Errorf("expected sessions metric, got:\n%s", content)
+	}
+}
+
+func TestStatsCommand(t *testing.T) {
+	tmpDir := testcli.MkdirTemp(t)
+
+	oldState := os.Getenv("XDG_STATE_HOME")
+	os.Setenv("XDG_STATE_HOME", tmpDir)
+	xdg.Reload()
+	defer func() {
+		os.Setenv("XDG_STATE_HOME", oldState)
+		xdg.Reload()
+	}()
+
+	journalPath := filepath.Join(tmpDir, "silo", "runs.jsonl")
+	testcli.Mkdir(t, filepath.Dir(journalPath))
+	entries := `{"time":"2026-01-02T03:04:05Z","tool":"claude","backend":"docker","image_tag":"img","built":true,"build_seconds":12.5,"session_seconds":5400}` + "\n" +
+		`{"time":"2026-01-03T03:04:05Z","tool":"claude","backend":"docker","image_tag":"img","session_seconds":600}` + "\n"
+	if err := os.WriteFile(journalPath, []byte(entries), 0644); err != nil {
+		t.Fatalf("failed to write journal: %v", err)
+	}
+
+	exitCode, stdout, stderr := testcli.Main(t, []string{"stats"}, nil, mainFunc)
+	if exitCode != 0 {
+		t.Fatalf("expected exit code 0, got %d, stderr: %s", exitCode, stderr)
+	}
+	fields := strings.Fields(strings.Split(stdout, "\n")[1])
+	if want := []string{"claude", "2", "1h40m", "1", "13s", "50%", "2026-01-03"}; !slices.Equal(fields, want) {
+		t.Errorf("row = %v, want %v\n%s", fields, want, stdout)
+	}
+
+	exitCode, _, stderr = testcli.Main(t, []string{"stats", "--since", "1d"}, nil, mainFunc)
+	if exitCode != 0 || !strings.Contains(stderr, "No runs found") {
+		t.Errorf("expected no runs in the last day, got %d: %s", exitCode, stderr)
+	}
+}
+
+func TestQueueCommands(t *testing.T) {
+	tmpDir := testcli.MkdirTemp(t)
+	testcli.Chdir(t, tmpDir)
+
+	oldState := os.Getenv("XDG_STATE_HOME")
+	os.Setenv("XDG_STATE_HOME", tmpDir)
+	xdg.Reload()
+	defer func() {
+		os.Setenv("XDG_STATE_HOME", oldState)
+		xdg.Reload()
+	}()
+
+	exitCode, stdout, stderr := testcli.Main(t, []string{"queue", "add", "claude", "--prompt", "fix it", "--env", "A=1", "--env", "B=2", "--", "--model", "opus"}, nil, mainFunc)
+	if exitCode != 0 || strings.TrimSpace(stdout) != "1" {
+		t.Fatalf("expected job 1, got %d: %s %s", exitCode, stdout, stderr)
+	}
+	jobs, err := queue.List()
+	if err != nil || len(jobs) != 1 {
+		t.Fatalf("List = %v, %v", jobs, err)
+	}
+	want := []string{"claude", "--env=A=1", "--env=B=2", "--prompt=fix it", "--", "--model", "opus"}
+	if !slices.Equal(jobs[0].Args, want) {
+		t.Errorf("args = %q, want %q", jobs[0].Args, want)
+	}
+
+	exitCode, stdout, _ = testcli.Main(t, []string{"queue", "ls"}, nil, mainFunc)
+	if fields := strings.Fields(strings.Split(stdout, "\n")[1]); exitCode != 0 || !slices.Equal(fields[:3], []string{"1", "queued", "claude"}) {
+		t.Errorf("expected the queued job, got %d:\n%s", exitCode, stdout)
+	}
+
+	exitCode, _, stderr = testcli.Main(t, []string{"queue", "rm", "1"}, nil, mainFunc)
+	if exitCode != 0 || !strings.Contains(stderr, "Removed 1 jobs") {
+		t.Errorf("expected the job removed, got %d: %s", exitCode, stderr)
+	}
+}
+
+func TestFilterHistory(t *testing.T) {
+	now := time.Now()
+	entries := []journal.Entry{
+		{Time: now.Add(-48 * time.Hour), Tool: "claude", Dir: "/code/a"},
+		{Time: now.Add(-2 * time.Hour), Tool: "opencode", Dir: "/code/a/sub"},
+		{Time: now.Add(-1 * time.Hour), Tool: "claude", Dir: "/code/ab"},
+	}
+	dirs := func(entries []journal.Entry) []string {
+		var dirs []string
+		for _, e := range entries {
+			dirs = append(dirs, e.Dir)
+		}
+		return dirs
+	}
+	tests := []struct {
+		name  string
+		tool  string
+		dir   string
+		after time.Time
+		limit int
+		want  []string
+	}{
+		{name: "all, most recent first", want: []string{"/code/ab", "/code/a/sub", "/code/a"}},
+		{name: "tool", tool: "claude", want: []string{"/code/ab", "/code/a"}},
+		{name: "dir includes subdirectories only", dir: "/code/a", want: []string{"/code/a/sub", "/code/a"}},
+		{name: "since", after: now.Add(-24 * time.Hour), want: []string{"/code/ab", "/code/a/sub"}},
+		{name: "limit", limit: 1, want: []string{"/code/ab"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := dirs(filterHistory(entries, tt.tool, tt.dir, tt.after, tt.limit))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionMismatch(t *testing.T) {
+	tests := []struct {
+		labels map[string]string
+		want   string
+	}{
+		{map[string]string{backend.LabelVersion: "1.2.0", backend.LabelScriptVersion: "2"}, ""},
+		{map[string]string{backend.LabelVersion: "1.1.0", backend.LabelScriptVersion: "2"}, "was created by silo 1.1.0, this is silo 1.2.0"},
+		{map[string]string{backend.LabelVersion: "1.0.0", backend.LabelScriptVersion: "1"}, "was created by silo 1.0.0 with scripts version 1"},
+		{nil, "was created by an older silo version"},
+	}
+	for _, tt := range tests {
+		got := versionMismatch(tt.labels, "1.2.0", "2")
+		if tt.want == "" && got != "" || !strings.HasPrefix(got, tt.want) {
+			t.Errorf("versionMismatch(%v) = %q, want prefix %q", tt.labels, got, tt.want)
+		}
+	}
+}
+
+func TestUnusedImages(t *testing.T) {
+	containers := []backend.ContainerInfo{
+		{Name: "app-1", Image: "silo-claude-aaa"},
+		{Name: "app-2", Image: "silo-claude-aaa:latest"},
+		{Name: "web-1", Image: "silo-claude-bbb"},
+		{Name: "web-2", Image: "silo-copilot-ccc"},
+		{Name: "other-1", Image: "silo-claude-aaa"},
+	}
+	got := unusedImages(containers, []string{"app-1", "app-2", "web-1", "web-2"})
+	if want := []string{"silo-claude-bbb", "silo-copilot-ccc"}; !slices.Equal(got, want) {
+		t.Errorf("unusedImages = %q, want %q", got, want)
+	}
+}
+
+func TestSessionsOf(t *testing.T) {
+	containers := []backend.ContainerInfo{
+		{Name: "app-1", Labels: map[string]string{backend.LabelSession: "s1"}},
+		{Name: "app-2", Labels: map[string]string{backend.LabelSession: "s2"}},
+		{Name: "app-3"},
+	}
+	got := sessionsOf(containers, []string{"app-1", "app-3"})
+	if want := []string{"s1"}; !slices.Equal(got, want) {
+		t.Errorf("sessionsOf = %q, want %q", got, want)
+	}
+}
+
+func TestMatchContainerNames(t *testing.T) {
+	containers := []backend.ContainerInfo{
+		{Name: "silo-api-1", IsRunning: true},
+		{Name: "silo-api-2"},
+		{Name: "silo-web-1", IsRunning: true},
+		{Name: "silo-api-1", IsRunning: true},
+	}
+	all := func(backend.ContainerInfo) bool { return true }
+	running := func(c backend.ContainerInfo) bool { return c.IsRunning }
+
+	for _, tt := range []struct {
+		name       string
+		args       []string
+		toComplete string
+		filter     func(backend.ContainerInfo) bool
+		want       []string
+	}{
+		{"prefix", nil, "silo-api", all, []string{"silo-api-1", "silo-api-2"}},
+		{"filter", nil, "", running, []string{"silo-api-1", "silo-web-1"}},
+		{"skip args", []string{"silo-api-1"}, "silo-", all, []string{"silo-api-2", "silo-web-1"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchContainerNames(containers, tt.args, tt.toComplete, tt.filter)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryBackendsUnknownBackend(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.Flags().String("backend", "podman", "")
+	_, err := queryBackends(cmd, time.Second, func(context.Context, string, backend.Backend) (struct{}, error) {
+		t.Error("fn called for an unknown backend")
+		return struct{}{}, nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "unknown backend: podman") {
+		t.Errorf("got %v, want unknown backend error", err)
+	}
+}
+
+func TestReviewEntry(t *testing.T) {
+	entries := []journal.Entry{
+		{Dir: "/a", Container: "silo-a-1", Head: "1111"},
+		{Dir: "/a", Container: "silo-a-2", Head: "2222"},
+		{Dir: "/a", Container: "silo-a-3"},
+		{Dir: "/b", Container: "silo-b-1", Head: "3333"},
+	}
+	for _, tt := range []struct {
+		container, dir string
+		want           string
+	}{
+		{"", "/a", "2222"},
+		{"silo-a-1", "/b", "1111"},
+		{"silo-a-3", "/a", ""},
+		{"", "/c", ""},
+	} {
+		got, ok := reviewEntry(entries, tt.container, tt.dir)
+		if got.Head != tt.want || ok != (tt.want != "") {
+			t.Errorf("reviewEntry(%q, %q) = %q, %v, want %q", tt.container, tt.dir, got.Head, ok, tt.want)
+		}
+	}
+}
+
+func TestSandboxBranch(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	if got, want := sandboxBranch("claude", now, nil), "silo/claude-20260601-1"; got != want {
+		t.Errorf("sandboxBranch() = %q, want %q", got, want)
+	}
+	existing := []string{"silo/claude-20260601-1", "silo/claude-20260601-2", "silo/claude-20260531-7", "silo/opencode-20260601-4"}
+	if got, want := sandboxBranch("claude", now, existing), "silo/claude-20260601-3"; got != want {
+		t.Errorf("sandboxBranch() = %q, want %q", got, want)
+	}
+}
+
+func TestConfigOverrides(t *testing.T) {
+	t.Chdir(t.TempDir())
+	cwd, _ := os.Getwd()
+	t.Setenv("SILO_MOUNTS_RO", "/opt/data"+string(os.PathListSeparator)+"~/notes")
+	t.Setenv("SILO_MOUNTS_RW", "")
+	t.Setenv("SILO_ENV", "A=1\nB")
+	t.Setenv("SILO_PRE_RUN_HOOKS", "echo env")
+	t.Setenv("SILO_POST_BUILD_HOOKS", "")
+
+	cmd := &cobra.Command{}
+	addOverrideFlags(cmd)
+	if err := cmd.ParseFlags([]string{"--mount-rw", "scratch", "--env", "C=a,b", "--pre-run-hook", "echo a, b", "--post-build-hook", "apt-get install -y jq"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := configOverrides(cmd)
+	if want := []string{"/opt/data", "~/notes"}; !slices.Equal(got.MountsRO, want) {
+		t.Errorf("MountsRO = %q, want %q", got.MountsRO, want)
+	}
+	if want := []string{filepath.Join(cwd, "scratch")}; !slices.Equal(got.MountsRW, want) {
+		t.Errorf("MountsRW = %q, want %q", got.MountsRW, want)
+	}
+	if want := []string{"A=1", "B", "C=a,b"}; !slices.Equal(got.Env, want) {
+		t.Errorf("Env = %q, want %q", got.Env, want)
+	}
+	if want := []string{"echo env", "echo a, b"}; !slices.Equal(got.PreRunHooks, want) {
+		t.Errorf("PreRunHooks = %q, want %q", got.PreRunHooks, want)
+	}
+	if want := []string{"apt-get install -y jq"}; !slices.Equal(got.PostBuildHooks, want) {
+		t.Errorf("PostBuildHooks = %q, want %q", got.PostBuildHooks, want)
+	}
+}
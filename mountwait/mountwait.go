@@ -3,18 +3,25 @@ package mountwait
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/kballard/go-shellquote"
 )
 
+// ScriptVersion is the version of the script GenerateScript returns. Bump it
+// whenever the script's behavior changes, so containers created with an
+// older script can be told apart.
+const ScriptVersion = "1"
+
 // GenerateScript generates a bash script that waits for all mount paths to exist.
-// It polls each path at 1ms intervals for up to 10s total timeout.
-// If verbose is true, it logs progress to stderr.
+// It polls each path at 1ms intervals for up to timeout, and is empty if
+// timeout is 0. If verbose is true, it logs progress to stderr.
 // This should be prepended to pre-run hooks to ensure mounts are ready before other commands run.
-func GenerateScript(paths []string, verbose bool) string {
-	if len(paths) == 0 {
+func GenerateScript(paths []string, timeout time.Duration, verbose bool) string {
+	if len(paths) == 0 || timeout <= 0 {
 		return ""
 	}
+	polls := timeout.Milliseconds()
 	var quotedPaths []string
 	for _, p := range paths {
 		quotedPaths = append(quotedPaths, shellquote.Join(p))
@@ -25,7 +32,7 @@ func GenerateScript(paths []string, verbose bool) string {
 		// - Info (==>) color 86, Success (✓) color 82, Error (✗) color 196
 		return fmt.Sprintf(`__silo_tilde() { case "$1" in "$HOME"*) echo "~${1#$HOME}";; *) echo "$1";; esac; }
 __silo_wait_for_mount() {
-  local p=$1 timeout=10000 i=0
+  local p=$1 timeout=%d i=0
   local c_success=$'\033[38;5;82m' c_error=$'\033[38;5;196m' c_reset=$'\033[0m'
   local display=$(__silo_tilde "$p")
   if [ -e "$p" ]; then
@@ -60,12 +67,12 @@ __silo_wait_for_mounts() {
     exit 1
   fi
   printf "  ${c_success}✓ All mounts ready${c_reset}\n" >&2
-}; __silo_wait_for_mounts`, strings.Join(quotedPaths, " "))
+}; __silo_wait_for_mounts`, polls, strings.Join(quotedPaths, " "))
 	}
 
 	// Quiet version - no output unless there's an error
 	return fmt.Sprintf(`__silo_wait_for_mount() {
-  local p=$1 timeout=10000 i=0
+  local p=$1 timeout=%d i=0
   [ -e "$p" ] && return 0
   while [ ! -e "$p" ] && [ $i -lt $timeout ]; do
     sleep 0.001
@@ -89,5 +96,5 @@ __silo_wait_for_mounts() {
   if [ $failed -eq 1 ]; then
     exit 1
   fi
-}; __silo_wait_for_mounts`, strings.Join(quotedPaths, " "))
+}; __silo_wait_for_mounts`, polls, strings.Join(quotedPaths, " "))
 }
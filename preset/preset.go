@@ -0,0 +1,314 @@
+// Package preset installs and verifies presets: config fragments shared by
+// others, published as a git repository or a single file. A preset is
+// installed under XDG data and used by listing its name in a config's
+// presets.
+package preset
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adrg/xdg"
+	"github.com/tidwall/jsonc"
+)
+
+// FileName is the config fragment at the root of a preset.
+const FileName = "preset.jsonc"
+
+// sourceFileName records where an installed preset came from.
+const sourceFileName = ".silo-preset.json"
+
+// nameRegex matches valid preset names.
+var nameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// Source records where an installed preset came from.
+type Source struct {
+	URL    string    `json:"url"`
+	Ref    string    `json:"ref,omitempty"`    // requested git ref
+	Commit string    `json:"commit,omitempty"` // git commit installed
+	SHA256 string    `json:"sha256"`           // hash of the preset's files
+	Added  time.Time `json:"added"`
+}
+
+// Preset is an installed preset.
+type Preset struct {
+	Name   string
+	Dir    string
+	Source Source
+}
+
+// Dir returns the directory presets are installed in.
+func Dir() string {
+	return filepath.Join(xdg.DataHome, "silo", "presets")
+}
+
+// Path returns the path of the config fragment of the named preset.
+func Path(name string) string {
+	return filepath.Join(Dir(), name, FileName)
+}
+
+// AddOptions configures Add.
+type AddOptions struct {
+	// Name to install the preset as. Defaults to the last element of the URL.
+	Name string
+
+	// Ref is the branch, tag, or commit of a git repository to install.
+	// Defaults to the repository's default branch.
+	Ref string
+
+	// SHA256, if set, is the expected hash of the preset's files (as shown
+	// by silo preset ls). The preset is not installed if it doesn't match.
+	SHA256 string
+
+	// Force replaces an installed preset of the same name.
+	Force bool
+}
+
+// Add installs the preset at url. URLs ending in .json or .jsonc are
+// downloaded as the preset's config fragment; anything else is cloned as a
+// git repository with a preset.jsonc at its root.
+func Add(ctx context.Context, url string, opts AddOptions) (Preset, error) {
+	name := opts.Name
+	if name == "" {
+		name = DefaultName(url)
+	}
+	if !nameRegex.MatchString(name) {
+		return Preset{}, fmt.Errorf("invalid preset name: %q", name)
+	}
+	dir := filepath.Join(Dir(), name)
+	if _, err := os.Stat(dir); err == nil && !opts.Force {
+		return Preset{}, fmt.Errorf("preset %s is already installed (use --force to replace it)", name)
+	}
+
+	if err := os.MkdirAll(Dir(), 0o755); err != nil {
+		return Preset{}, err
+	}
+	tmp, err := os.MkdirTemp(Dir(), "."+name+"-")
+	if err != nil {
+		return Preset{}, err
+	}
+	defer os.RemoveAll(tmp)
+
+	src := Source{URL: url, Ref: opts.Ref, Added: time.Now().UTC()}
+	if isFileURL(url) {
+		if opts.Ref != "" {
+			return Preset{}, fmt.Errorf("--ref is only supported for git repositories")
+		}
+		err = download(ctx, url, filepath.Join(tmp, FileName))
+	} else {
+		src.Commit, err = clone(ctx, url, opts.Ref, tmp)
+	}
+	if err != nil {
+		return Preset{}, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(tmp, FileName))
+	if err != nil {
+		return Preset{}, fmt.Errorf("%s has no %s", url, FileName)
+	}
+	var fragment map[string]any
+	if err := json.Unmarshal(jsonc.ToJSON(data), &fragment); err != nil {
+		return Preset{}, fmt.Errorf("invalid %s: %w", FileName, err)
+	}
+
+	src.SHA256, err = hashDir(tmp)
+	if err != nil {
+		return Preset{}, err
+	}
+	if opts.SHA256 != "" && !strings.EqualFold(opts.SHA256, src.SHA256) {
+		return Preset{}, fmt.Errorf("preset hash mismatch: expected %s, got %s", opts.SHA256, src.SHA256)
+	}
+	srcData, err := json.MarshalIndent(src, "", "  ")
+	if err != nil {
+		return Preset{}, err
+	}
+	if err := os.WriteFile(filepath.Join(tmp, sourceFileName), srcData, 0o644); err != nil {
+		return Preset{}, err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return Preset{}, err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return Preset{}, err
+	}
+	return Preset{Name: name, Dir: dir, Source: src}, nil
+}
+
+// DefaultName returns the name a preset at url is installed as by default:
+// the last element of the URL without a .git, .json, or .jsonc extension.
+func DefaultName(url string) string {
+	name := path.Base(strings.TrimRight(url, "/"))
+	for _, ext := range []string{".git", ".jsonc", ".json"} {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// List returns the installed presets sorted by name.
+func List() ([]Preset, error) {
+	entries, err := os.ReadDir(Dir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var presets []Preset
+	for _, e := range entries {
+		if !e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		p := Preset{Name: e.Name(), Dir: filepath.Join(Dir(), e.Name())}
+		if data, err := os.ReadFile(filepath.Join(p.Dir, sourceFileName)); err == nil {
+			_ = json.Unmarshal(data, &p.Source)
+		}
+		presets = append(presets, p)
+	}
+	return presets, nil
+}
+
+// Remove uninstalls the named preset.
+func Remove(name string) error {
+	if !nameRegex.MatchString(name) {
+		return fmt.Errorf("invalid preset name: %q", name)
+	}
+	dir := filepath.Join(Dir(), name)
+	if _, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("preset %s is not installed", name)
+	}
+	return os.RemoveAll(dir)
+}
+
+// Verify checks the named preset is installed and its files haven't
+// changed since it was installed.
+func Verify(name string) error {
+	if !nameRegex.MatchString(name) {
+		return fmt.Errorf("invalid preset name: %q", name)
+	}
+	dir := filepath.Join(Dir(), name)
+	data, err := os.ReadFile(filepath.Join(dir, sourceFileName))
+	if err != nil {
+		return fmt.Errorf("preset %s is not installed", name)
+	}
+	var src Source
+	if err := json.Unmarshal(data, &src); err != nil {
+		return fmt.Errorf("preset %s: %w", name, err)
+	}
+	sum, err := hashDir(dir)
+	if err != nil {
+		return fmt.Errorf("preset %s: %w", name, err)
+	}
+	if sum != src.SHA256 {
+		return fmt.Errorf("preset %s has been modified since it was installed", name)
+	}
+	return nil
+}
+
+// isFileURL reports whether url points at a single config file rather than
+// a git repository.
+func isFileURL(url string) bool {
+	return (strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")) &&
+		(strings.HasSuffix(url, ".jsonc") || strings.HasSuffix(url, ".json"))
+}
+
+// download writes the body of url to dest.
+func download(ctx context.Context, url, dest string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download preset: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download preset: %s", resp.Status)
+	}
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to download preset: %w", err)
+	}
+	return f.Close()
+}
+
+// clone checks out ref (or the default branch) of the git repository at url
+// into dir without history, and returns the commit checked out.
+func clone(ctx context.Context, url, ref, dir string) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"fetch", "--quiet", "--depth", "1", url, ref},
+		{"checkout", "--quiet", "FETCH_HEAD"},
+	} {
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return "", fmt.Errorf("git %s failed: %s", args[0], strings.TrimSpace(string(out)))
+		}
+	}
+	out, err := exec.CommandContext(ctx, "git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", fmt.Errorf("git rev-parse failed: %w", err)
+	}
+	if err := os.RemoveAll(filepath.Join(dir, ".git")); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// hashDir returns a hash of the names and contents of the files in dir,
+// excluding the source record.
+func hashDir(dir string) (string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			if rel != sourceFileName {
+				files = append(files, filepath.ToSlash(rel))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	slices.Sort(files)
+
+	h := sha256.New()
+	for _, f := range files {
+		data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(f)))
+		if err != nil {
+			return "", err
+		}
+		fileSum := sha256.Sum256(data)
+		fmt.Fprintf(h, "%s  %s\n", hex.EncodeToString(fileSum[:]), f)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,128 @@
+package preset
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/adrg/xdg"
+)
+
+// setDataHome points XDG data at a temporary directory for the test.
+func setDataHome(t *testing.T) {
+	t.Helper()
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	xdg.Reload()
+	t.Cleanup(xdg.Reload)
+}
+
+func TestDefaultName(t *testing.T) {
+	for url, want := range map[string]string{
+		"https://github.com/example/silo-rust.git":  "silo-rust",
+		"https://github.com/example/silo-rust/":     "silo-rust",
+		"https://example.com/presets/go-dev.jsonc":  "go-dev",
+		"https://example.com/presets/node-dev.json": "node-dev",
+	} {
+		if got := DefaultName(url); got != want {
+			t.Errorf("DefaultName(%q) = %q, want %q", url, got, want)
+		}
+	}
+}
+
+func TestAddGit(t *testing.T) {
+	setDataHome(t)
+	repo := t.TempDir()
+	if err := os.WriteFile(filepath.Join(repo, FileName), []byte(`{"env": ["FOO"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "--quiet"},
+		{"add", "."},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "init"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", repo}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+
+	p, err := Add(context.Background(), repo, AddOptions{Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Source.Commit == "" || p.Source.SHA256 == "" {
+		t.Errorf("expected commit and hash to be recorded, got %+v", p.Source)
+	}
+	if _, err := os.Stat(filepath.Join(p.Dir, ".git")); !os.IsNotExist(err) {
+		t.Error("expected git metadata to be removed")
+	}
+	if err := Verify("test"); err != nil {
+		t.Errorf("expected preset to verify, got %v", err)
+	}
+
+	if _, err := Add(context.Background(), repo, AddOptions{Name: "test"}); err == nil || !strings.Contains(err.Error(), "already installed") {
+		t.Errorf("expected already installed error, got %v", err)
+	}
+
+	// Modifying an installed preset fails verification
+	if err := os.WriteFile(Path("test"), []byte(`{"env": ["BAR"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify("test"); err == nil {
+		t.Error("expected modified preset to fail verification")
+	}
+
+	presets, err := List()
+	if err != nil || len(presets) != 1 || presets[0].Name != "test" || presets[0].Source.URL != repo {
+		t.Errorf("unexpected presets %+v, err %v", presets, err)
+	}
+	if err := Remove("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify("test"); err == nil || !strings.Contains(err.Error(), "not installed") {
+		t.Errorf("expected not installed error, got %v", err)
+	}
+}
+
+func TestAddURL(t *testing.T) {
+	setDataHome(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"mounts_ro": ["~/.cargo"]}`))
+	}))
+	defer srv.Close()
+	url := srv.URL + "/rust-dev.jsonc"
+
+	if _, err := Add(context.Background(), url, AddOptions{SHA256: "00"}); err == nil || !strings.Contains(err.Error(), "hash mismatch") {
+		t.Fatalf("expected hash mismatch, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(Dir(), "rust-dev")); !os.IsNotExist(err) {
+		t.Error("expected preset with mismatched hash not to be installed")
+	}
+
+	p, err := Add(context.Background(), url, AddOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "rust-dev" {
+		t.Errorf("expected name rust-dev, got %q", p.Name)
+	}
+	if _, err := Add(context.Background(), url, AddOptions{SHA256: p.Source.SHA256, Force: true}); err != nil {
+		t.Errorf("expected matching hash to install, got %v", err)
+	}
+}
+
+func TestAddInvalidFragment(t *testing.T) {
+	setDataHome(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`not json`))
+	}))
+	defer srv.Close()
+
+	if _, err := Add(context.Background(), srv.URL+"/bad.jsonc", AddOptions{}); err == nil || !strings.Contains(err.Error(), "invalid preset.jsonc") {
+		t.Errorf("expected invalid fragment error, got %v", err)
+	}
+}
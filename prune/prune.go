@@ -0,0 +1,114 @@
+// Package prune decides which silo containers, images, and volumes are stale
+// so they can be removed.
+package prune
+
+import (
+	"strings"
+	"time"
+
+	"github.com/leighmcculloch/silo/backend"
+)
+
+// Options selects what to prune.
+type Options struct {
+	Containers bool
+	Images     bool
+	Volumes    bool
+
+	// OlderThan keeps anything used or created more recently than this. When
+	// set, anything whose age is unknown is kept too.
+	OlderThan time.Duration
+
+	// Now is the time ages are measured from.
+	Now time.Time
+}
+
+// Plan lists the resources to remove.
+type Plan struct {
+	Containers []backend.ContainerInfo
+	Images     []backend.ImageInfo
+	Volumes    []backend.VolumeInfo
+}
+
+// Stale returns the resources to remove:
+//
+//   - Stopped containers.
+//   - Images not used by a container that is kept, except the most recently
+//     used image of each tool, which the next run would otherwise rebuild.
+//   - Volumes. Volumes still mounted by a container fail to remove.
+//
+// lastUsed maps image names to when a run last used them. Images missing
+// from it fall back to their creation time.
+func Stale(containers []backend.ContainerInfo, images []backend.ImageInfo, volumes []backend.VolumeInfo, lastUsed map[string]time.Time, opts Options) Plan {
+	old := func(t time.Time) bool {
+		if opts.OlderThan == 0 {
+			return true
+		}
+		return !t.IsZero() && opts.Now.Sub(t) >= opts.OlderThan
+	}
+
+	var plan Plan
+	inUse := make(map[string]bool)
+	for _, c := range containers {
+		if opts.Containers && !c.IsRunning && old(c.Created) {
+			plan.Containers = append(plan.Containers, c)
+			continue
+		}
+		inUse[strings.TrimSuffix(c.Image, ":latest")] = true
+	}
+
+	if opts.Images {
+		used := func(img backend.ImageInfo) time.Time {
+			if t, ok := lastUsed[img.Name]; ok && t.After(img.Created) {
+				return t
+			}
+			return img.Created
+		}
+
+		// The most recently used image of each tool is kept.
+		newest := make(map[string]backend.ImageInfo)
+		for _, img := range images {
+			tool := Tool(img.Name)
+			if cur, ok := newest[tool]; !ok || used(img).After(used(cur)) {
+				newest[tool] = img
+			}
+		}
+
+		for _, img := range images {
+			if inUse[img.Name] || newest[Tool(img.Name)].Name == img.Name || !old(used(img)) {
+				continue
+			}
+			plan.Images = append(plan.Images, img)
+		}
+	}
+
+	if opts.Volumes {
+		for _, v := range volumes {
+			if old(v.Created) {
+				plan.Volumes = append(plan.Volumes, v)
+			}
+		}
+	}
+
+	return plan
+}
+
+// Tool returns the tool an image was built for, from its name
+// (silo-<tool>-<hash>).
+func Tool(image string) string {
+	name := strings.TrimPrefix(image, "silo-")
+	if i := strings.LastIndex(name, "-"); i >= 0 {
+		return name[:i]
+	}
+	return name
+}
+
+// ParseAge parses an age such as "30d", "12h", or "1h30m". In addition to Go
+// durations, a "d" suffix means days.
+func ParseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		d, err := time.ParseDuration(days + "h")
+		return d * 24, err
+	}
+	return time.ParseDuration(s)
+}
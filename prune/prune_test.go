@@ -0,0 +1,110 @@
+package prune
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/leighmcculloch/silo/backend"
+)
+
+func names[T any](items []T, name func(T) string) []string {
+	var out []string
+	for _, i := range items {
+		out = append(out, name(i))
+	}
+	return out
+}
+
+func TestStale(t *testing.T) {
+	now := time.Date(2026, 1, 31, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+
+	containers := []backend.ContainerInfo{
+		{Name: "running", Image: "silo-claude-aaaa", IsRunning: true, Created: now.Add(-40 * day)},
+		{Name: "stopped-old", Image: "silo-claude-bbbb", Created: now.Add(-40 * day)},
+		{Name: "stopped-new", Image: "silo-opencode-cccc", Created: now.Add(-1 * day)},
+	}
+	images := []backend.ImageInfo{
+		{Name: "silo-claude-aaaa", Created: now.Add(-50 * day)}, // used by running container
+		{Name: "silo-claude-bbbb", Created: now.Add(-45 * day)}, // used by a pruned container
+		{Name: "silo-claude-dddd", Created: now.Add(-60 * day)}, // most recently used claude image
+		{Name: "silo-opencode-cccc", Created: now.Add(-35 * day)},
+		{Name: "silo-opencode-eeee", Created: now.Add(-2 * day)}, // newest opencode image
+		{Name: "silo-copilot-ffff"},                              // only copilot image, age unknown
+	}
+	volumes := []backend.VolumeInfo{
+		{Name: "silo-cache-old", Created: now.Add(-40 * day)},
+		{Name: "silo-cache-unknown"},
+	}
+	lastUsed := map[string]time.Time{
+		"silo-claude-dddd": now.Add(-1 * day),
+	}
+
+	tests := []struct {
+		name           string
+		opts           Options
+		wantContainers []string
+		wantImages     []string
+		wantVolumes    []string
+	}{
+		{
+			name:           "everything",
+			opts:           Options{Containers: true, Images: true, Volumes: true, Now: now},
+			wantContainers: []string{"stopped-old", "stopped-new"},
+			wantImages:     []string{"silo-claude-bbbb", "silo-opencode-cccc"},
+			wantVolumes:    []string{"silo-cache-old", "silo-cache-unknown"},
+		},
+		{
+			name:           "older than 30 days",
+			opts:           Options{Containers: true, Images: true, Volumes: true, OlderThan: 30 * day, Now: now},
+			wantContainers: []string{"stopped-old"},
+			wantImages:     []string{"silo-claude-bbbb"},
+			wantVolumes:    []string{"silo-cache-old"},
+		},
+		{
+			name:       "images only keeps images of stopped containers",
+			opts:       Options{Images: true, Now: now},
+			wantImages: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := Stale(containers, images, volumes, lastUsed, tt.opts)
+			if got := names(plan.Containers, func(c backend.ContainerInfo) string { return c.Name }); !slices.Equal(got, tt.wantContainers) {
+				t.Errorf("containers = %v, want %v", got, tt.wantContainers)
+			}
+			if got := names(plan.Images, func(i backend.ImageInfo) string { return i.Name }); !slices.Equal(got, tt.wantImages) {
+				t.Errorf("images = %v, want %v", got, tt.wantImages)
+			}
+			if got := names(plan.Volumes, func(v backend.VolumeInfo) string { return v.Name }); !slices.Equal(got, tt.wantVolumes) {
+				t.Errorf("volumes = %v, want %v", got, tt.wantVolumes)
+			}
+		})
+	}
+}
+
+func TestTool(t *testing.T) {
+	if got := Tool("silo-claude-0123456789abcdef"); got != "claude" {
+		t.Errorf("Tool = %q, want claude", got)
+	}
+}
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+		err  bool
+	}{
+		{"30d", 30 * 24 * time.Hour, false},
+		{"12h", 12 * time.Hour, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"x", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAge(tt.in)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("ParseAge(%q) = %v, %v; want %v, err %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Package queue keeps a queue of batch sessions, persisted in the state
+// directory, that silo queue start runs with bounded parallelism.
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/leighmcculloch/silo/crypt"
+	"github.com/leighmcculloch/silo/fileutil"
+	"github.com/leighmcculloch/silo/statedir"
+)
+
+// Status is the state of a job.
+type Status string
+
+const (
+	Queued  Status = "queued"
+	Running Status = "running"
+	Done    Status = "done"   // the session exited with status 0
+	Failed  Status = "failed" // the session exited with another status, or didn't run
+)
+
+// Job is a queued batch session: the arguments of silo batch, run in Dir.
+type Job struct {
+	ID       int       `json:"id"`
+	Dir      string    `json:"dir"`
+	Args     []string  `json:"args"`
+	Added    time.Time `json:"added"`
+	Status   Status    `json:"status"`
+	PID      int       `json:"pid,omitempty"` // process running the job
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+	ExitCode *int      `json:"exit_code,omitempty"`
+	Error    string    `json:"error,omitempty"` // why the job failed, other than its exit status
+}
+
+// Tool returns the tool the job runs.
+func (j Job) Tool() string {
+	if len(j.Args) == 0 {
+		return ""
+	}
+	return j.Args[0]
+}
+
+// Log returns the file the job's output is written to.
+func (j Job) Log() string {
+	return filepath.Join(Dir(), strconv.Itoa(j.ID)+".log")
+}
+
+// Dir returns the directory holding the queue and the logs of its jobs.
+var Dir = func() string {
+	return statedir.Path("queue")
+}
+
+func path() string {
+	return filepath.Join(Dir(), "queue.json")
+}
+
+// Add appends a job running silo batch with args in dir, and returns it.
+func Add(dir string, args []string) (Job, error) {
+	var job Job
+	err := update(func(jobs []Job) ([]Job, error) {
+		id := 1
+		for _, j := range jobs {
+			id = max(id, j.ID+1)
+		}
+		job = Job{ID: id, Dir: dir, Args: args, Added: time.Now(), Status: Queued}
+		return append(jobs, job), nil
+	})
+	return job, err
+}
+
+// List returns the jobs, in the order they were added.
+func List() ([]Job, error) {
+	var jobs []Job
+	err := update(func(j []Job) ([]Job, error) {
+		jobs = j
+		return j, nil
+	})
+	return jobs, err
+}
+
+// Claim marks the oldest queued job as running in the process pid and
+// returns it, or false if no job is queued.
+func Claim(pid int) (Job, bool, error) {
+	var job Job
+	var ok bool
+	err := update(func(jobs []Job) ([]Job, error) {
+		for i, j := range jobs {
+			if j.Status == Queued {
+				jobs[i].Status = Running
+				jobs[i].PID = pid
+				jobs[i].Started = time.Now()
+				job, ok = jobs[i], true
+				break
+			}
+		}
+		return jobs, nil
+	})
+	return job, ok, err
+}
+
+// Finish records that the job with id exited with code, or failed to run
+// with runErr.
+func Finish(id int, code int, runErr error) error {
+	return update(func(jobs []Job) ([]Job, error) {
+		i := slices.IndexFunc(jobs, func(j Job) bool { return j.ID == id })
+		if i < 0 {
+			return jobs, nil
+		}
+		jobs[i].Finished = time.Now()
+		jobs[i].PID = 0
+		switch {
+		case runErr != nil:
+			jobs[i].Status = Failed
+			jobs[i].Error = runErr.Error()
+		case code == 0:
+			jobs[i].Status = Done
+			jobs[i].ExitCode = &code
+		default:
+			jobs[i].Status = Failed
+			jobs[i].ExitCode = &code
+		}
+		return jobs, nil
+	})
+}
+
+// Remove deletes the jobs for which match returns true, with their logs,
+// and returns them. Running jobs are never removed.
+func Remove(match func(Job) bool) ([]Job, error) {
+	var removed []Job
+	err := update(func(jobs []Job) ([]Job, error) {
+		return slices.DeleteFunc(jobs, func(j Job) bool {
+			if j.Status == Running || !match(j) {
+				return false
+			}
+			removed = append(removed, j)
+			os.Remove(j.Log())
+			return true
+		}), nil
+	})
+	return removed, err
+}
+
+// update reads the jobs, replaces them with those fn returns, and writes
+// them back, holding the queue's lock throughout. Jobs left running by a
+// process that has since exited are marked failed first.
+func update(fn func([]Job) ([]Job, error)) error {
+	p := path()
+	unlock, err := fileutil.Lock(p)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var jobs []Job
+	data, err := os.ReadFile(p)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return err
+	default:
+		if data, err = crypt.Open(data); err != nil {
+			return fmt.Errorf("failed to read queue %s: %w", p, err)
+		}
+		if err := json.Unmarshal(data, &jobs); err != nil {
+			return fmt.Errorf("failed to read queue %s: %w", p, err)
+		}
+	}
+	for i, j := range jobs {
+		if j.Status == Running && !alive(j.PID) {
+			jobs[i].Status = Failed
+			jobs[i].PID = 0
+			jobs[i].Error = "interrupted, silo exited while it ran"
+		}
+	}
+
+	jobs, err = fn(jobs)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(Dir(), 0o700); err != nil {
+		return err
+	}
+	data, err = json.MarshalIndent(jobs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if data, err = crypt.Seal(data); err != nil {
+		return err
+	}
+	return fileutil.WriteFile(p, data, 0o600)
+}
+
+// alive reports whether the process pid exists.
+func alive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,96 @@
+package queue
+
+import (
+	"errors"
+	"os"
+	"slices"
+	"testing"
+)
+
+func useDir(t *testing.T) {
+	dir := t.TempDir()
+	orig := Dir
+	Dir = func() string { return dir }
+	t.Cleanup(func() { Dir = orig })
+}
+
+func TestQueue(t *testing.T) {
+	useDir(t)
+
+	for _, args := range [][]string{{"claude", "--prompt", "a"}, {"opencode", "--prompt", "b"}, {"claude", "--prompt", "c"}} {
+		if _, err := Add("/src/proj", args); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pid := os.Getpid()
+	first, ok, err := Claim(pid)
+	if err != nil || !ok || first.ID != 1 || first.Status != Running || first.Tool() != "claude" {
+		t.Fatalf("Claim = %+v, %v, %v", first, ok, err)
+	}
+	second, _, _ := Claim(pid)
+	if second.ID != 2 || second.Tool() != "opencode" {
+		t.Fatalf("second Claim = %+v", second)
+	}
+
+	if err := Finish(first.ID, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := Finish(second.ID, 0, errors.New("no such file")); err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var statuses []Status
+	for _, j := range jobs {
+		statuses = append(statuses, j.Status)
+	}
+	if !slices.Equal(statuses, []Status{Done, Failed, Queued}) {
+		t.Errorf("statuses = %v", statuses)
+	}
+	if jobs[0].ExitCode == nil || *jobs[0].ExitCode != 0 || jobs[1].Error != "no such file" {
+		t.Errorf("got jobs %+v", jobs)
+	}
+
+	// Finished jobs are removed, IDs aren't reused while jobs remain
+	removed, err := Remove(func(j Job) bool { return j.Status != Queued })
+	if err != nil || len(removed) != 2 {
+		t.Fatalf("Remove = %v, %v", removed, err)
+	}
+	job, _ := Add("/src/proj", []string{"claude", "--prompt", "d"})
+	if job.ID != 4 {
+		t.Errorf("ID = %d, want 4", job.ID)
+	}
+}
+
+func TestClaimEmpty(t *testing.T) {
+	useDir(t)
+	if _, ok, err := Claim(os.Getpid()); ok || err != nil {
+		t.Errorf("Claim = %v, %v, want nothing queued", ok, err)
+	}
+}
+
+func TestInterruptedJob(t *testing.T) {
+	useDir(t)
+	Add("/src/proj", []string{"claude"})
+	// A process that has exited, as PIDs this high aren't allocated
+	if _, _, err := Claim(1 << 30); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobs[0].Status != Failed || jobs[0].Error == "" {
+		t.Errorf("got %+v, want the interrupted job failed", jobs[0])
+	}
+	// Running jobs aren't removed
+	Add("/src/proj", []string{"claude"})
+	Claim(os.Getpid())
+	if removed, _ := Remove(func(Job) bool { return true }); len(removed) != 1 || removed[0].ID != 1 {
+		t.Errorf("removed %+v, want only the interrupted job", removed)
+	}
+}
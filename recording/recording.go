@@ -0,0 +1,285 @@
+// Package recording records the output of sessions to files in the state
+// directory, in asciinema's asciicast v2 format, so they can be played back
+// with silo replay or asciinema, and plays them back.
+package recording
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/leighmcculloch/silo/crypt"
+	"github.com/leighmcculloch/silo/statedir"
+)
+
+// Ext is the extension of recordings.
+const Ext = ".cast"
+
+// timeLayout is the layout of the time a recording started, which follows
+// the session name in its file name.
+const timeLayout = "20060102-150405"
+
+// Dir returns the directory recordings are written to.
+var Dir = func() string {
+	return statedir.Path("recordings")
+}
+
+// header is the first line of an asciicast v2 file.
+type header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Recorder writes the output written to it to a recording, one event per
+// write, so a recording is complete up to the last write even if silo
+// doesn't exit cleanly. If encrypt_recipients is set each line is encrypted
+// on its own, as in the run history, so only silo replay can play it.
+type Recorder struct {
+	path  string
+	start time.Time
+
+	mu      sync.Mutex
+	f       *os.File
+	partial []byte // an incomplete UTF-8 sequence ending the last write
+	err     error
+}
+
+// Create starts a recording of the session name, the container name, on a
+// terminal of width by height, titled title.
+func Create(name, title string, width, height int) (*Recorder, error) {
+	if err := os.MkdirAll(Dir(), 0o700); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	path := filepath.Join(Dir(), name+"-"+start.Format(timeLayout)+Ext)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	h := header{Version: 2, Width: width, Height: height, Timestamp: start.Unix(), Title: title}
+	if t := os.Getenv("TERM"); t != "" {
+		h.Env = map[string]string{"TERM": t}
+	}
+	data, err := json.Marshal(h)
+	if err == nil {
+		err = writeLine(f, data)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return &Recorder{path: path, start: start, f: f}, nil
+}
+
+// Path returns the file the recording is written to.
+func (r *Recorder) Path() string {
+	return r.path
+}
+
+// Write records b as output. It never fails, so recording can't disrupt a
+// session; the first error is returned by Close.
+func (r *Recorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil || r.f == nil {
+		return len(b), nil
+	}
+	// Events are JSON strings, so a UTF-8 sequence split across writes is
+	// held back until the rest of it arrives
+	data := append(r.partial, b...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	r.partial = slices.Clone(data[cut:])
+	if cut > 0 {
+		r.err = r.event(data[:cut])
+	}
+	return len(b), nil
+}
+
+// event writes an output event of data. r.mu must be held.
+func (r *Recorder) event(data []byte) error {
+	event, err := json.Marshal([]any{time.Since(r.start).Seconds(), "o", string(data)})
+	if err != nil {
+		return err
+	}
+	return writeLine(r.f, event)
+}
+
+// writeLine writes line to w, encrypted if encrypt_recipients is set.
+func writeLine(w io.Writer, line []byte) error {
+	line, err := crypt.SealLine(line)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// readLine returns the plain text of a line written by writeLine.
+func readLine(line []byte) ([]byte, error) {
+	line, ok := crypt.OpenLine(line)
+	if !ok {
+		return nil, errors.New("the recording is encrypted and encrypt_identity isn't set or can't decrypt it")
+	}
+	return line, nil
+}
+
+// Close ends the recording.
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.f == nil {
+		return r.err
+	}
+	if len(r.partial) > 0 && r.err == nil {
+		r.err = r.event(r.partial)
+	}
+	err := r.f.Close()
+	r.f = nil
+	return errors.Join(r.err, err)
+}
+
+// Recording is a recording in Dir.
+type Recording struct {
+	Name    string // file name without the extension
+	Session string // container name of the session
+	Path    string
+	Time    time.Time // when the session started
+	Size    int64
+}
+
+// List returns the recordings in Dir, oldest first.
+func List() ([]Recording, error) {
+	entries, err := os.ReadDir(Dir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var recordings []Recording
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), Ext)
+		if !ok || e.IsDir() || len(name) <= len(timeLayout)+1 {
+			continue
+		}
+		session, stamp := name[:len(name)-len(timeLayout)-1], name[len(name)-len(timeLayout):]
+		t, err := time.ParseInLocation(timeLayout, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		recordings = append(recordings, Recording{
+			Name:    name,
+			Session: session,
+			Path:    filepath.Join(Dir(), e.Name()),
+			Time:    t,
+			Size:    info.Size(),
+		})
+	}
+	slices.SortStableFunc(recordings, func(a, b Recording) int { return a.Time.Compare(b.Time) })
+	return recordings, nil
+}
+
+// Find returns the recording named name, or else the latest recording of
+// the session name.
+func Find(name string) (Recording, error) {
+	recordings, err := List()
+	if err != nil {
+		return Recording{}, err
+	}
+	name = strings.TrimSuffix(filepath.Base(name), Ext)
+	if i := slices.IndexFunc(recordings, func(r Recording) bool { return r.Name == name }); i >= 0 {
+		return recordings[i], nil
+	}
+	for i := len(recordings) - 1; i >= 0; i-- {
+		if recordings[i].Session == name {
+			return recordings[i], nil
+		}
+	}
+	return Recording{}, fmt.Errorf("no recording of %s, list them with: silo replay", name)
+}
+
+// Play writes the output recorded in r to w at the pace it was recorded,
+// sped up by speed. Pauses are shortened to maxIdle, unless it is 0. Events
+// other than output, such as input, are skipped.
+func Play(ctx context.Context, r io.Reader, w io.Writer, speed float64, maxIdle time.Duration) error {
+	if speed <= 0 {
+		return fmt.Errorf("invalid speed %v: must be greater than 0", speed)
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	if !scanner.Scan() {
+		return errors.Join(errors.New("empty recording"), scanner.Err())
+	}
+	line, err := readLine(scanner.Bytes())
+	if err != nil {
+		return err
+	}
+	var h header
+	if err := json.Unmarshal(line, &h); err != nil || h.Version != 2 {
+		return errors.New("not an asciicast v2 recording")
+	}
+
+	var last float64
+	for scanner.Scan() {
+		line, err := readLine(scanner.Bytes())
+		if err != nil {
+			return err
+		}
+		var event []any
+		if err := json.Unmarshal(line, &event); err != nil || len(event) != 3 {
+			return fmt.Errorf("invalid event in recording: %s", line)
+		}
+		at, ok1 := event[0].(float64)
+		kind, ok2 := event[1].(string)
+		data, ok3 := event[2].(string)
+		if !ok1 || !ok2 || !ok3 {
+			return fmt.Errorf("invalid event in recording: %s", line)
+		}
+		if kind != "o" {
+			continue
+		}
+		pause := time.Duration((at - last) / speed * float64(time.Second))
+		last = at
+		if maxIdle > 0 {
+			pause = min(pause, maxIdle)
+		}
+		if pause > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(pause):
+			}
+		}
+		if _, err := io.WriteString(w, data); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,153 @@
+package recording
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+
+	"github.com/leighmcculloch/silo/crypt"
+)
+
+func useDir(t *testing.T) {
+	dir := t.TempDir()
+	orig := Dir
+	Dir = func() string { return dir }
+	t.Cleanup(func() { Dir = orig })
+}
+
+func TestRecordAndPlay(t *testing.T) {
+	useDir(t)
+
+	r, err := Create("proj-1", "claude in proj", 120, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("hello \xe2\x9c"))
+	r.Write([]byte("\x93 done\r\n"))
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(r.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var h header
+	if err := json.Unmarshal([]byte(lines[0]), &h); err != nil || h.Version != 2 || h.Width != 120 || h.Height != 40 || h.Title != "claude in proj" {
+		t.Errorf("got header %s, %v", lines[0], err)
+	}
+	// The check mark split across writes is recorded whole
+	if len(lines) != 3 || !strings.HasSuffix(lines[1], `"o","hello "]`) || !strings.HasSuffix(lines[2], `"o","✓ done\r\n"]`) {
+		t.Errorf("got events:\n%s", strings.Join(lines[1:], "\n"))
+	}
+
+	var out bytes.Buffer
+	if err := Play(context.Background(), bytes.NewReader(data), &out, 1, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "hello ✓ done\r\n" {
+		t.Errorf("played %q", out.String())
+	}
+
+	if err := Play(context.Background(), strings.NewReader("not a recording\n"), &out, 1, 0); err == nil {
+		t.Error("expected an error playing something other than a recording")
+	}
+}
+
+func TestRecordAndPlayEncrypted(t *testing.T) {
+	useDir(t)
+	t.Cleanup(func() { crypt.Configure(nil, "") })
+
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idPath := filepath.Join(t.TempDir(), "age.key")
+	if err := os.WriteFile(idPath, []byte(id.String()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := crypt.Configure([]string{id.Recipient().String()}, idPath); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Create("proj-1", "claude in proj", 120, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Write([]byte("secret output\r\n"))
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(r.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) || bytes.Contains(data, []byte("claude")) {
+		t.Errorf("expected the recording to be encrypted, got %s", data)
+	}
+
+	var out bytes.Buffer
+	if err := Play(context.Background(), bytes.NewReader(data), &out, 1, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "secret output\r\n" {
+		t.Errorf("played %q", out.String())
+	}
+
+	// Without the identity it can't be played
+	if err := crypt.Configure([]string{id.Recipient().String()}, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := Play(context.Background(), bytes.NewReader(data), &out, 1, 0); err == nil || !strings.Contains(err.Error(), "encrypted") {
+		t.Errorf("expected an error playing without the identity, got %v", err)
+	}
+}
+
+func TestPlayTiming(t *testing.T) {
+	cast := `{"version": 2, "width": 80, "height": 24}
+[0.5, "o", "a"]
+[0.6, "i", "typed"]
+[10, "o", "b"]
+`
+	var out bytes.Buffer
+	start := time.Now()
+	if err := Play(context.Background(), strings.NewReader(cast), &out, 10, 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// 0.05s at 10x, then the 0.94s pause is capped at 0.1s
+	if d := time.Since(start); d < 150*time.Millisecond || d > time.Second {
+		t.Errorf("played in %s, want about 150ms", d)
+	}
+	if out.String() != "ab" {
+		t.Errorf("played %q, want only the output", out.String())
+	}
+}
+
+func TestFind(t *testing.T) {
+	useDir(t)
+	for _, name := range []string{"proj-1-20260101-100000", "proj-1-20260102-100000", "proj-1-x-1-20260103-100000", "notes"} {
+		os.WriteFile(Dir()+"/"+name+Ext, []byte("{}\n"), 0o600)
+	}
+
+	recordings, err := List()
+	if err != nil || len(recordings) != 3 {
+		t.Fatalf("List() = %v, %v, want the 3 recordings", recordings, err)
+	}
+	if r, err := Find("proj-1"); err != nil || r.Name != "proj-1-20260102-100000" {
+		t.Errorf("Find(proj-1) = %v, %v, want its latest recording", r.Name, err)
+	}
+	if r, err := Find("proj-1-20260101-100000.cast"); err != nil || r.Name != "proj-1-20260101-100000" {
+		t.Errorf("Find() by file name = %v, %v", r.Name, err)
+	}
+	if _, err := Find("other-1"); err == nil {
+		t.Error("expected an error for a session with no recording")
+	}
+}
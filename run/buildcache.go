@@ -0,0 +1,122 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/leighmcculloch/silo/backend"
+	"github.com/leighmcculloch/silo/fileutil"
+)
+
+// buildCache is where Build imports image layers from (Options.CacheFrom)
+// or exports them to (Options.CacheTo), so CI jobs that don't share a
+// backend can reuse each other's builds. Exactly one field is set.
+type buildCache struct {
+	registry string // repository the image of each tool is tagged in by the tool's name
+	dir      string // directory holding <tool>.tar, the image of each tool, and <tool>.tag, its tag
+}
+
+// parseBuildCache parses a cache as docker buildx takes it,
+// "type=registry,ref=<repository>" or "type=local,src=<dir>" (dest= for an
+// export), or a repository on its own.
+func parseBuildCache(s string) (buildCache, error) {
+	if !strings.Contains(s, "=") {
+		return buildCache{registry: s}, nil
+	}
+	attrs := map[string]string{}
+	for _, field := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(field, "=")
+		if !ok || v == "" {
+			return buildCache{}, fmt.Errorf("invalid build cache %q: %q isn't key=value", s, field)
+		}
+		attrs[k] = v
+	}
+	switch attrs["type"] {
+	case "registry":
+		if attrs["ref"] != "" {
+			return buildCache{registry: attrs["ref"]}, nil
+		}
+	case "local":
+		dir := attrs["src"]
+		if dir == "" {
+			dir = attrs["dest"]
+		}
+		if dir != "" {
+			return buildCache{dir: expandPath(dir)}, nil
+		}
+	default:
+		return buildCache{}, fmt.Errorf("invalid build cache %q: type must be registry or local", s)
+	}
+	return buildCache{}, fmt.Errorf("invalid build cache %q: registry caches need ref=, local caches src= or dest=", s)
+}
+
+// parseBuildCaches parses each of specs with parseBuildCache.
+func parseBuildCaches(specs []string) ([]buildCache, error) {
+	var caches []buildCache
+	for _, s := range specs {
+		c, err := parseBuildCache(s)
+		if err != nil {
+			return nil, err
+		}
+		caches = append(caches, c)
+	}
+	return caches, nil
+}
+
+// ref returns the image of tool in a registry cache.
+func (c buildCache) ref(tool string) string {
+	return c.registry + ":" + tool
+}
+
+// importBuildCaches makes the images of tool in caches available to a build
+// and returns those to reuse layers from. A local cache holding the image
+// being built loads it, so it needn't be built at all. Caches that don't
+// exist yet, as in the first job, are skipped.
+func importBuildCaches(ctx context.Context, b backend.Backend, tool string, caches []buildCache, logSection func(string, ...any)) []string {
+	var cacheFrom []string
+	for _, c := range caches {
+		if c.registry != "" {
+			cacheFrom = append(cacheFrom, c.ref(tool))
+			continue
+		}
+		tag, err := os.ReadFile(filepath.Join(c.dir, tool+".tag"))
+		if err != nil {
+			logSection("No build cache for %s in %s", tool, c.dir)
+			continue
+		}
+		if err := b.LoadImage(ctx, filepath.Join(c.dir, tool+".tar")); err != nil {
+			logSection("Skipping build cache in %s: %v", c.dir, err)
+			continue
+		}
+		logSection("Loaded build cache %s from %s", strings.TrimSpace(string(tag)), c.dir)
+		cacheFrom = append(cacheFrom, strings.TrimSpace(string(tag)))
+	}
+	return cacheFrom
+}
+
+// exportBuildCaches exports the image tag of tool to caches.
+func exportBuildCaches(ctx context.Context, b backend.Backend, tool, tag string, caches []buildCache, logSection func(string, ...any)) error {
+	for _, c := range caches {
+		if c.registry != "" {
+			logSection("Pushing build cache %s", c.ref(tool))
+			if _, err := b.PushImage(ctx, tag, c.ref(tool)); err != nil {
+				return fmt.Errorf("failed to export build cache: %w", err)
+			}
+			continue
+		}
+		logSection("Saving build cache to %s", c.dir)
+		if err := os.MkdirAll(c.dir, 0o755); err != nil {
+			return fmt.Errorf("failed to export build cache: %w", err)
+		}
+		if err := b.SaveImage(ctx, tag, filepath.Join(c.dir, tool+".tar")); err != nil {
+			return fmt.Errorf("failed to export build cache: %w", err)
+		}
+		if err := fileutil.WriteFile(filepath.Join(c.dir, tool+".tag"), []byte(tag+"\n"), 0o644); err != nil {
+			return fmt.Errorf("failed to export build cache: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package run
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/leighmcculloch/silo/backend"
+)
+
+func TestParseBuildCache(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    buildCache
+		wantErr bool
+	}{
+		{"ghcr.io/org/cache", buildCache{registry: "ghcr.io/org/cache"}, false},
+		{"type=registry,ref=ghcr.io/org/cache", buildCache{registry: "ghcr.io/org/cache"}, false},
+		{"type=local,src=/tmp/cache", buildCache{dir: "/tmp/cache"}, false},
+		{"type=local,dest=/tmp/cache", buildCache{dir: "/tmp/cache"}, false},
+		{"type=gha", buildCache{}, true},
+		{"type=local", buildCache{}, true},
+		{"type=registry,ref", buildCache{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseBuildCache(tt.spec)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseBuildCache(%q) = %+v, %v, want %+v", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+// cacheBackend records the images exported to it and loads those saved.
+type cacheBackend struct {
+	backend.Backend
+	pushed []string
+	loaded []string
+}
+
+func (b *cacheBackend) PushImage(_ context.Context, name, ref string) (string, error) {
+	b.pushed = append(b.pushed, name+" "+ref)
+	return "", nil
+}
+
+func (b *cacheBackend) SaveImage(_ context.Context, name, path string) error {
+	return os.WriteFile(path, []byte(name), 0o644)
+}
+
+func (b *cacheBackend) LoadImage(_ context.Context, path string) error {
+	data, err := os.ReadFile(path)
+	b.loaded = append(b.loaded, string(data))
+	return err
+}
+
+func TestBuildCaches(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	caches := []buildCache{{registry: "ghcr.io/org/cache"}, {dir: dir}}
+	b := &cacheBackend{}
+	logSection := func(string, ...any) {}
+
+	// The first job has no local cache to import
+	if got := importBuildCaches(context.Background(), b, "claude", caches, logSection); !slices.Equal(got, []string{"ghcr.io/org/cache:claude"}) {
+		t.Errorf("cacheFrom = %v, want only the registry cache", got)
+	}
+
+	if err := exportBuildCaches(context.Background(), b, "claude", "silo-claude-abc", caches, logSection); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(b.pushed, []string{"silo-claude-abc ghcr.io/org/cache:claude"}) {
+		t.Errorf("pushed %v", b.pushed)
+	}
+
+	got := importBuildCaches(context.Background(), b, "claude", caches, logSection)
+	if !slices.Equal(got, []string{"ghcr.io/org/cache:claude", "silo-claude-abc"}) || !slices.Equal(b.loaded, []string{"silo-claude-abc"}) {
+		t.Errorf("cacheFrom = %v, loaded %v, want the saved image loaded", got, b.loaded)
+	}
+}
@@ -0,0 +1,71 @@
+package run
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/leighmcculloch/silo/crypt"
+	"github.com/leighmcculloch/silo/statedir"
+)
+
+// BuildLog returns the file the output of the last build of the image tag
+// is written to, whether or not it is shown.
+func BuildLog(tag string) string {
+	return statedir.Path("logs", "build-"+tag+".log")
+}
+
+// createBuildLog creates the build log at path, replacing the log of an
+// earlier build of the image. It is encrypted if encrypt_recipients is set.
+func createBuildLog(path string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	w, err := crypt.NewWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// FindBuildLog returns the build log of name, an image tag or a tool, whose
+// latest build log is returned, or of the latest build if name is "".
+func FindBuildLog(name string) (string, error) {
+	if name != "" {
+		if _, err := os.Stat(BuildLog(name)); err == nil {
+			return BuildLog(name), nil
+		}
+	}
+	pattern := BuildLog("silo-*")
+	if name != "" {
+		pattern = BuildLog("silo-" + name + "-*")
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return "", err
+	}
+	var latest string
+	var latestMod int64
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+		if mod := info.ModTime().UnixNano(); latest == "" || mod > latestMod {
+			latest, latestMod = m, mod
+		}
+	}
+	if latest == "" && name == "" {
+		return "", fmt.Errorf("no build logs in %s", filepath.Dir(pattern))
+	}
+	if latest == "" {
+		return "", fmt.Errorf("no build log for %s in %s", name, filepath.Dir(pattern))
+	}
+	return latest, nil
+}
@@ -0,0 +1,41 @@
+package run
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/leighmcculloch/silo/statedir"
+)
+
+func TestFindBuildLog(t *testing.T) {
+	statedir.Set(t.TempDir())
+	t.Cleanup(func() { statedir.Set("") })
+
+	if _, err := FindBuildLog(""); err == nil {
+		t.Error("expected an error without build logs")
+	}
+	old := time.Now().Add(-time.Hour)
+	for _, tag := range []string{"silo-claude-aaa", "silo-claude-bbb", "silo-opencode-ccc"} {
+		f, err := createBuildLog(BuildLog(tag))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		os.Chtimes(BuildLog(tag), old, old)
+		old = old.Add(time.Minute)
+	}
+
+	for name, want := range map[string]string{
+		"":                "silo-opencode-ccc",
+		"claude":          "silo-claude-bbb",
+		"silo-claude-aaa": "silo-claude-aaa",
+	} {
+		if got, err := FindBuildLog(name); err != nil || got != BuildLog(want) {
+			t.Errorf("FindBuildLog(%q) = %q, %v, want the log of %s", name, got, err, want)
+		}
+	}
+	if _, err := FindBuildLog("copilot"); err == nil {
+		t.Error("expected an error for a tool without build logs")
+	}
+}
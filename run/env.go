@@ -0,0 +1,147 @@
+package run
+
+import (
+	"cmp"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/leighmcculloch/silo/backend"
+	"github.com/leighmcculloch/silo/git"
+	"github.com/leighmcculloch/silo/journal"
+	"github.com/leighmcculloch/silo/secrets"
+)
+
+// EnvVar is an environment variable of a run: where it comes from and
+// whether it is passed to the container. It never holds the value.
+type EnvVar struct {
+	Name   string
+	Source string // e.g. "config", "config, claude", "config, repo github.com/org", "secret"
+	Status string // one of the EnvVar statuses
+}
+
+// EnvVar statuses.
+const (
+	EnvSet     = "set"                      // value set in config or by silo
+	EnvHost    = "from host"                // passed through from the host
+	EnvNotSet  = "not set on host"          // passthrough of a variable the host doesn't have
+	EnvBlocked = "blocked by env_blocklist" // passthrough by pattern of a variable matching env_blocklist
+	EnvFetched = "fetched at run time"      // secret fetched when the session starts
+
+	// EnvListedBlocked is a passthrough by name of a variable matching
+	// env_blocklist, passed with a warning as it was listed
+	EnvListedBlocked = "from host, in env_blocklist"
+)
+
+// matchEnv reports whether the variable name matches patterns, such as
+// env_blocklist. '*' matches any characters, and a pattern starting with
+// '!' unmatches the names it matches, so the last matching pattern wins.
+func matchEnv(name string, patterns []string) bool {
+	matched := false
+	for _, p := range patterns {
+		negated := strings.HasPrefix(p, "!")
+		if ok, _ := path.Match(strings.TrimPrefix(p, "!"), name); ok {
+			matched = !negated
+		}
+	}
+	return matched
+}
+
+// minRedactLen is the length below which the values passed to the container
+// aren't redacted, as short values such as "1" or "true" would hide ordinary
+// text. Values of variables matching env_redact are redacted at any length.
+const minRedactLen = 8
+
+// redactValues returns the values to redact from output: those of the
+// configured variables passed to the container, and those of host variables
+// matching redact.
+func redactValues(log envLogInfo, redact []string) []string {
+	var values []string
+	for _, v := range log.values {
+		if len(v) >= minRedactLen {
+			values = append(values, v)
+		}
+	}
+	for _, e := range os.Environ() {
+		if name, val, _ := strings.Cut(e, "="); val != "" && matchEnv(name, redact) {
+			values = append(values, val)
+		}
+	}
+	// Longest first, so a value containing another is replaced whole
+	slices.SortFunc(values, func(a, b string) int { return cmp.Or(len(b)-len(a), strings.Compare(a, b)) })
+	return slices.Compact(values)
+}
+
+// redactEntry returns e with values redacted from the fields that can hold
+// them: the hooks, which may interpolate variables, and the error.
+func redactEntry(e journal.Entry, values []string) journal.Entry {
+	redact := func(s []string) []string {
+		var out []string
+		for _, v := range s {
+			out = append(out, secrets.Redact(v, values))
+		}
+		return out
+	}
+	e.PreRunHooks = redact(e.PreRunHooks)
+	e.PostBuildHooks = redact(e.PostBuildHooks)
+	e.Error = secrets.Redact(e.Error, values)
+	return e
+}
+
+// Env returns the environment variables a run of opts would have, with
+// where each comes from and whether it is passed, without fetching
+// secrets.
+func Env(opts Options) ([]EnvVar, error) {
+	tool := opts.ToolDef.Name
+	cfg := opts.Config
+	cwd := opts.Dir
+	if cwd == "" {
+		cwd, _ = os.Getwd()
+	}
+	repoMatches, _ := withConfigProfile(cfg, opts.Profile, matchRepos(cfg, git.GetGitRemoteURLs(cwd)))
+	hostName, hostEmail := git.GetGitIdentity()
+	gitName, gitEmail, err := resolveGitIdentity(repoMatches, hostName, hostEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	_, log := collectEnvVars(tool, cfg, repoMatches, gitName, gitEmail)
+	vars := log.vars
+	signing, _ := resolveCommitSigning(cfg, repoMatches)
+	if signing != SigningOff && signing != "" {
+		vars = append(vars, EnvVar{Name: "GIT_CONFIG_COUNT", Source: "commit_signing", Status: EnvSet})
+	}
+	if resolveSSHAgent(cfg, repoMatches) || signing == SigningSSH {
+		status := EnvHost
+		if os.Getenv("SSH_AUTH_SOCK") == "" {
+			status = EnvNotSet
+		}
+		vars = append(vars, EnvVar{Name: "SSH_AUTH_SOCK", Source: "ssh_agent", Status: status})
+	}
+	_, serviceEnv, _ := resolveServices(cfg, repoMatches, backend.Network{})
+	for _, e := range serviceEnv {
+		name, _, _ := strings.Cut(e, "=")
+		vars = append(vars, EnvVar{Name: name, Source: "services", Status: EnvSet})
+	}
+	for _, name := range slices.Sorted(maps.Keys(resolveSecrets(tool, cfg, repoMatches))) {
+		vars = append(vars, EnvVar{Name: name, Source: "secret", Status: EnvFetched})
+	}
+	for _, e := range resolveInstructions(opts.ToolDef, cwd).env {
+		name, _, _ := strings.Cut(e, "=")
+		if !slices.ContainsFunc(vars, func(v EnvVar) bool { return v.Name == name && v.Status != EnvBlocked && v.Status != EnvNotSet }) {
+			vars = append(vars, EnvVar{Name: name, Source: "agent instructions", Status: EnvSet})
+		}
+	}
+	if mcp, err := resolveMCP(cfg, repoMatches, os.Getenv("HOME")); err == nil {
+		env, _ := mcpArgs(opts.ToolDef, mcp, MCPConfigPath(""))
+		for _, e := range env {
+			name, _, _ := strings.Cut(e, "=")
+			if !slices.ContainsFunc(vars, func(v EnvVar) bool { return v.Name == name && v.Status != EnvBlocked && v.Status != EnvNotSet }) {
+				vars = append(vars, EnvVar{Name: name, Source: "mcp_servers", Status: EnvSet})
+			}
+		}
+	}
+	return vars, nil
+}
@@ -0,0 +1,81 @@
+package run
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/leighmcculloch/silo/config"
+	"github.com/leighmcculloch/silo/journal"
+)
+
+func TestMatchEnv(t *testing.T) {
+	patterns := slices.Concat(config.DefaultEnvBlocklist, []string{"!AWS_REGION", "NPM_*"})
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"AWS_SECRET_ACCESS_KEY", true},
+		{"AWS_REGION", false},
+		{"GH_TOKEN", true},
+		{"GITHUB_TOKEN", true},
+		{"NPM_TOKEN", true},
+		{"ANTHROPIC_API_KEY", false},
+	}
+	for _, tt := range tests {
+		if got := matchEnv(tt.name, patterns); got != tt.want {
+			t.Errorf("matchEnv(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCollectEnvVarsBlocklist(t *testing.T) {
+	t.Setenv("GH_TOKEN", "ghp_secret")
+	t.Setenv("MY_VAR", "value")
+	t.Setenv("SILO_TEST_AWS_A", "a")
+	t.Setenv("SILO_TEST_AWS_B", "b")
+	cfg := config.Config{
+		Env:          []string{"GH_TOKEN", "MY_VAR", "UNSET_VAR_FOR_TEST", "EXPLICIT=1", "SILO_TEST_AWS_*", "SILO_TEST_UNSET_*"},
+		EnvBlocklist: append(slices.Clone(config.DefaultEnvBlocklist), "SILO_TEST_AWS_B"),
+	}
+	envVars, log := collectEnvVars("claude", cfg, nil, "", "")
+	if want := []string{"GH_TOKEN=ghp_secret", "MY_VAR=value", "EXPLICIT=1", "SILO_TEST_AWS_A=a"}; !slices.Equal(envVars, want) {
+		t.Errorf("envVars = %v, want %v", envVars, want)
+	}
+	if !slices.Equal(log.blocked, []string{"SILO_TEST_AWS_B"}) {
+		t.Errorf("blocked = %v, want SILO_TEST_AWS_B", log.blocked)
+	}
+	if !slices.Equal(log.listedBlocked, []string{"GH_TOKEN"}) {
+		t.Errorf("listedBlocked = %v, want GH_TOKEN", log.listedBlocked)
+	}
+	want := []EnvVar{
+		{Name: "GH_TOKEN", Source: "config", Status: EnvListedBlocked},
+		{Name: "MY_VAR", Source: "config", Status: EnvHost},
+		{Name: "UNSET_VAR_FOR_TEST", Source: "config", Status: EnvNotSet},
+		{Name: "EXPLICIT", Source: "config", Status: EnvSet},
+		{Name: "SILO_TEST_AWS_A", Source: "config", Status: EnvHost},
+		{Name: "SILO_TEST_AWS_B", Source: "config", Status: EnvBlocked},
+		{Name: "SILO_TEST_UNSET_*", Source: "config", Status: EnvNotSet},
+	}
+	if !slices.Equal(log.vars, want) {
+		t.Errorf("vars = %v, want %v", log.vars, want)
+	}
+}
+
+func TestRedactValues(t *testing.T) {
+	t.Setenv("SILO_TEST_TOKEN", "tok")
+	t.Setenv("SILO_TEST_OTHER", "not-redacted-value")
+	log := envLogInfo{values: []string{"1", "a-long-value", "a-long-value-and-more"}}
+	values := redactValues(log, []string{"SILO_TEST_*TOKEN*"})
+	if want := []string{"a-long-value-and-more", "a-long-value", "tok"}; !slices.Equal(values, want) {
+		t.Errorf("redactValues() = %v, want %v", values, want)
+	}
+
+	e := redactEntry(journal.Entry{
+		PreRunHooks: []string{"echo a-long-value-and-more"},
+		Error:       "failed with tok",
+		EnvNames:    []string{"SILO_TEST_TOKEN"},
+	}, values)
+	if e.PreRunHooks[0] != "echo ***" || e.Error != "failed with ***" || e.EnvNames[0] != "SILO_TEST_TOKEN" {
+		t.Errorf("redactEntry() = %+v, want values redacted and names kept", e)
+	}
+}
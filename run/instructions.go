@@ -0,0 +1,95 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/leighmcculloch/silo/tools"
+)
+
+// instructionFiles returns the agent instruction files of the project in
+// dir: those in dir and in each parent up to the root of its git
+// repository, nearest first. Outside a repository only dir is searched.
+func instructionFiles(dir string) []string {
+	var files []string
+	for d := dir; ; d = filepath.Dir(d) {
+		for _, name := range tools.InstructionFiles {
+			if info, err := os.Stat(filepath.Join(d, name)); err == nil && info.Mode().IsRegular() {
+				files = append(files, filepath.Join(d, name))
+			}
+		}
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return files
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	return slices.DeleteFunc(files, func(f string) bool { return !isWithin(f, dir) })
+}
+
+// instructions are the agent instruction files of a run and how the tool is
+// made to read them.
+type instructions struct {
+	files    []string // every instruction file found
+	included []string // files the tool doesn't read on its own, included with env and args
+	env      []string
+	args     []string
+	mounts   []string // files outside the working directory, mounted so the tool can see them
+}
+
+// resolveInstructions finds the instruction files of the project in dir and
+// returns how to make toolDef read those it doesn't read on its own.
+func resolveInstructions(toolDef tools.Tool, dir string) instructions {
+	var in instructions
+	in.files = instructionFiles(dir)
+	for _, f := range in.files {
+		if !isWithin(f, dir) {
+			in.mounts = append(in.mounts, f)
+		}
+		if !slices.Contains(toolDef.Instructions.Reads, instructionName(f)) {
+			in.included = append(in.included, f)
+		}
+	}
+	if len(in.included) > 0 && toolDef.Instructions.Include != nil {
+		in.env, in.args = toolDef.Instructions.Include(in.included)
+	}
+	return in
+}
+
+// instructionName returns which of tools.InstructionFiles path is.
+func instructionName(path string) string {
+	for _, name := range tools.InstructionFiles {
+		if strings.HasSuffix(path, string(filepath.Separator)+filepath.FromSlash(name)) {
+			return name
+		}
+	}
+	return filepath.Base(path)
+}
+
+// withToolEnv returns envVars with env, which configures the tool, e.g. to
+// read instruction files, appended, except variables already set, so the
+// user's own settings win.
+func withToolEnv(envVars, env []string) []string {
+	for _, e := range env {
+		name, _, _ := strings.Cut(e, "=")
+		if !slices.ContainsFunc(envVars, func(v string) bool { return strings.HasPrefix(v, name+"=") }) {
+			envVars = append(envVars, e)
+		}
+	}
+	return envVars
+}
+
+// withFileMounts returns mountsRO with files added, such as instruction
+// files outside the working directory, unless a mount already covers them.
+func withFileMounts(mountsRO, mountsRW, files []string) []string {
+	for _, f := range files {
+		covered := slices.ContainsFunc(slices.Concat(mountsRO, mountsRW), func(m string) bool { return isWithin(f, m) })
+		if !covered {
+			mountsRO = append(mountsRO, f)
+		}
+	}
+	return mountsRO
+}
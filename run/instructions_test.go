@@ -0,0 +1,87 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/leighmcculloch/silo/tools"
+)
+
+func TestInstructionFiles(t *testing.T) {
+	root := t.TempDir()
+	sub := filepath.Join(root, "pkg", "api")
+	for _, dir := range []string{filepath.Join(root, ".git"), filepath.Join(sub, ".github")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{
+		filepath.Join(root, "AGENTS.md"),
+		filepath.Join(root, ".cursorrules"),
+		filepath.Join(sub, "CLAUDE.md"),
+		filepath.Join(sub, ".github", "copilot-instructions.md"),
+	} {
+		if err := os.WriteFile(f, []byte("be nice"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := instructionFiles(sub)
+	want := []string{
+		filepath.Join(sub, "CLAUDE.md"),
+		filepath.Join(sub, ".github", "copilot-instructions.md"),
+		filepath.Join(root, "AGENTS.md"),
+		filepath.Join(root, ".cursorrules"),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("instructionFiles() = %v, want %v", got, want)
+	}
+
+	var included []string
+	toolDef := tools.Tool{Instructions: tools.Instructions{
+		Reads: []string{"CLAUDE.md"},
+		Include: func(files []string) (env, args []string) {
+			included = files
+			return []string{"INSTRUCTIONS=1"}, []string{"--instructions"}
+		},
+	}}
+	in := resolveInstructions(toolDef, sub)
+	if want := []string{want[1], want[2], want[3]}; !slices.Equal(included, want) || !slices.Equal(in.included, want) {
+		t.Errorf("included %v, want %v", included, want)
+	}
+	if !slices.Equal(in.env, []string{"INSTRUCTIONS=1"}) || !slices.Equal(in.args, []string{"--instructions"}) {
+		t.Errorf("env %v, args %v", in.env, in.args)
+	}
+	if want := []string{want[2], want[3]}; !slices.Equal(in.mounts, want) {
+		t.Errorf("mounts %v, want %v", in.mounts, want)
+	}
+	if got := withFileMounts(nil, []string{sub}, in.mounts); !slices.Equal(got, in.mounts) {
+		t.Errorf("withFileMounts() = %v, want %v", got, in.mounts)
+	}
+	if got := withFileMounts(nil, []string{root}, in.mounts); len(got) != 0 {
+		t.Errorf("withFileMounts() = %v, want none when the root is mounted", got)
+	}
+}
+
+func TestInstructionFilesOutsideRepo(t *testing.T) {
+	parent := t.TempDir()
+	dir := filepath.Join(parent, "project")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(parent, "AGENTS.md"), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := instructionFiles(dir); len(got) != 0 {
+		t.Errorf("instructionFiles() = %v, want none outside a repository", got)
+	}
+}
+
+func TestWithToolEnv(t *testing.T) {
+	got := withToolEnv([]string{"OPENCODE_CONFIG_CONTENT={}", "A=1"}, []string{"OPENCODE_CONFIG_CONTENT={\"instructions\":[]}", "B=2"})
+	if want := []string{"OPENCODE_CONFIG_CONTENT={}", "A=1", "B=2"}; !slices.Equal(got, want) {
+		t.Errorf("withToolEnv() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,65 @@
+package run
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/leighmcculloch/silo/backend"
+	"github.com/leighmcculloch/silo/cli"
+	"github.com/leighmcculloch/silo/events"
+)
+
+// exitHookEnv returns the environment variables, besides the host's, of the
+// hooks run after the container exits, and whether the run failed, given
+// the error the backend's Run returned:
+//
+//   - SILO_EXIT_CODE, the tool's exit code, unless the run failed without one
+//   - SILO_ERROR, why the run failed, if it failed without an exit code
+//   - SILO_CONTAINER, the container name
+//   - SILO_TOOL, the tool name
+func exitHookEnv(tool, container string, runErr error) ([]string, bool) {
+	env := []string{"SILO_CONTAINER=" + container, "SILO_TOOL=" + tool}
+	var exitErr *backend.ExitError
+	switch {
+	case runErr == nil:
+		return append(env, "SILO_EXIT_CODE=0"), false
+	case errors.As(runErr, &exitErr):
+		return append(env, "SILO_EXIT_CODE="+strconv.Itoa(exitErr.Code)), exitErr.Code != 0
+	}
+	return append(env, "SILO_ERROR="+runErr.Error()), true
+}
+
+// runExitHooks runs the on-failure hooks, if the run failed, and then the
+// post-run hooks on the host, in dir. Each list is chained with && like
+// pre-run hooks. Failures are reported as warnings: the run is over, so
+// they don't change its outcome.
+func runExitHooks(postRun, onFailure []string, dir, tool, container string, runErr error, stdout, stderr io.Writer) {
+	env, failed := exitHookEnv(tool, container, runErr)
+	if failed && len(onFailure) > 0 {
+		events.Emit(events.Event{Type: events.HooksRunning, Tool: tool, Container: container, Hooks: "on_failure", Count: len(onFailure)})
+		if err := runHostHooks(onFailure, dir, env, stdout, stderr); err != nil {
+			cli.LogWarningTo(stderr, "on_failure_hooks failed: %v", err)
+		}
+	}
+	if len(postRun) > 0 {
+		events.Emit(events.Event{Type: events.HooksRunning, Tool: tool, Container: container, Hooks: "post_run", Count: len(postRun)})
+		if err := runHostHooks(postRun, dir, env, stdout, stderr); err != nil {
+			cli.LogWarningTo(stderr, "post_run_hooks failed: %v", err)
+		}
+	}
+}
+
+// runHostHooks runs hooks on the host with sh, in dir, with the host's
+// environment and env.
+func runHostHooks(hooks []string, dir string, env []string, stdout, stderr io.Writer) error {
+	cmd := exec.Command("/bin/sh", "-c", strings.Join(hooks, " &&\n"))
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
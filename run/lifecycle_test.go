@@ -0,0 +1,62 @@
+package run
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/leighmcculloch/silo/backend"
+)
+
+func TestExitHookEnv(t *testing.T) {
+	tests := []struct {
+		err        error
+		want       string
+		wantFailed bool
+	}{
+		{nil, "SILO_EXIT_CODE=0", false},
+		{fmt.Errorf("run: %w", &backend.ExitError{Code: 0}), "SILO_EXIT_CODE=0", false},
+		{&backend.ExitError{Code: 3}, "SILO_EXIT_CODE=3", true},
+		{errors.New("no such image"), "SILO_ERROR=no such image", true},
+	}
+	for _, tt := range tests {
+		env, failed := exitHookEnv("claude", "silo-x-1", tt.err)
+		if failed != tt.wantFailed {
+			t.Errorf("exitHookEnv(%v) failed = %v, want %v", tt.err, failed, tt.wantFailed)
+		}
+		want := []string{"SILO_CONTAINER=silo-x-1", "SILO_TOOL=claude", tt.want}
+		if strings.Join(env, " ") != strings.Join(want, " ") {
+			t.Errorf("exitHookEnv(%v) = %q, want %q", tt.err, env, want)
+		}
+	}
+}
+
+func TestRunExitHooks(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "marker"), []byte("in dir\n"), 0o644)
+	postRun := []string{"echo post $SILO_EXIT_CODE $SILO_CONTAINER", "cat marker"}
+	onFailure := []string{"echo failed $SILO_TOOL"}
+
+	var stdout, stderr bytes.Buffer
+	runExitHooks(postRun, onFailure, dir, "claude", "silo-x-1", nil, &stdout, &stderr)
+	if want := "post 0 silo-x-1\nin dir\n"; stdout.String() != want {
+		t.Errorf("after a success got output %q, want %q", stdout.String(), want)
+	}
+
+	stdout.Reset()
+	runExitHooks(postRun[:1], onFailure, dir, "claude", "silo-x-1", &backend.ExitError{Code: 2}, &stdout, &stderr)
+	if want := "failed claude\npost 2 silo-x-1\n"; stdout.String() != want {
+		t.Errorf("after a failure got output %q, want %q", stdout.String(), want)
+	}
+
+	// A failing hook stops the ones after it and is only a warning
+	stdout.Reset()
+	runExitHooks([]string{"false", "echo unreachable"}, nil, dir, "claude", "silo-x-1", nil, &stdout, &stderr)
+	if stdout.Len() != 0 || !strings.Contains(stderr.String(), "post_run_hooks failed") {
+		t.Errorf("got output %q and warnings %q, want only a warning", stdout.String(), stderr.String())
+	}
+}
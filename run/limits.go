@@ -0,0 +1,75 @@
+package run
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/leighmcculloch/silo/backend"
+)
+
+// Limits a session's output can show it ran out of.
+const (
+	limitNofile         = "nofile"
+	limitInotifyWatches = "inotify_watches"
+)
+
+// limitErrors are the messages, lowercased, that tools and their file
+// watchers print when they run out of a limit.
+var limitErrors = []struct {
+	message string
+	limit   string
+}{
+	{"system limit for number of file watchers reached", limitInotifyWatches}, // Node.js (ENOSPC)
+	{"user limit of inotify watches reached", limitInotifyWatches},            // tail, Java, Rust notify
+	{"inotify watch limit reached", limitInotifyWatches},                      // Go fsnotify, watchman
+	{"too many open files", limitNofile},                                      // EMFILE
+}
+
+// limitTail is how much of the output is kept between writes, so a message
+// split across writes is still found.
+const limitTail = 64
+
+// limitDetector is a writer that watches a session's output for messages
+// saying it ran out of open files or file watchers.
+type limitDetector struct {
+	mu   sync.Mutex
+	tail []byte
+	hit  []string
+}
+
+func (d *limitDetector) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	buf := bytes.ToLower(append(d.tail, b...))
+	for _, e := range limitErrors {
+		if !slices.Contains(d.hit, e.limit) && bytes.Contains(buf, []byte(e.message)) {
+			d.hit = append(d.hit, e.limit)
+		}
+	}
+	d.tail = slices.Clone(buf[max(0, len(buf)-limitTail):])
+	return len(b), nil
+}
+
+// Hit returns the limits the session ran out of, in the order first seen.
+func (d *limitDetector) Hit() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Clone(d.hit)
+}
+
+// limitHint explains how to raise a limit a session on backendType with
+// resources r ran out of.
+func limitHint(limit, backendType string, r backend.Resources) string {
+	switch {
+	case limit == limitNofile && r.Nofile == 0:
+		return `The session ran out of open files. Raise the limit with "resources": { "nofile": 1048576 } in silo.jsonc.`
+	case limit == limitNofile:
+		return fmt.Sprintf(`The session ran out of open files (limit %d). Raise it with "resources": { "nofile": %d } in silo.jsonc.`, r.Nofile, r.Nofile*2)
+	case backendType == "docker":
+		return fmt.Sprintf("The session ran out of file watchers. Docker containers share the kernel's limit, so raise it on the Docker host with: sudo sysctl -w fs.inotify.max_user_watches=%d (on Docker Desktop: docker run --rm --privileged alpine sysctl -w fs.inotify.max_user_watches=%d)", r.InotifyWatches*2, r.InotifyWatches*2)
+	default:
+		return fmt.Sprintf(`The session ran out of file watchers (limit %d). Raise it with "resources": { "inotify_watches": %d } in silo.jsonc.`, r.InotifyWatches, r.InotifyWatches*2)
+	}
+}
@@ -0,0 +1,45 @@
+package run
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/leighmcculloch/silo/backend"
+)
+
+func TestLimitDetector(t *testing.T) {
+	d := &limitDetector{}
+	d.Write([]byte("compiled successfully\r\n"))
+	if hit := d.Hit(); len(hit) != 0 {
+		t.Fatalf("Hit() = %v, want none", hit)
+	}
+
+	// A message split across writes is still found
+	d.Write([]byte("Error: ENOSPC: System limit for number of file "))
+	d.Write([]byte("watchers reached, watch '/src'\r\n"))
+	d.Write([]byte("EMFILE: too many open files, open 'a.js'\r\n"))
+	d.Write([]byte("Too many open files\r\n"))
+	if hit, want := d.Hit(), []string{limitInotifyWatches, limitNofile}; !slices.Equal(hit, want) {
+		t.Errorf("Hit() = %v, want %v", hit, want)
+	}
+}
+
+func TestLimitHint(t *testing.T) {
+	r := backend.Resources{Nofile: 1024, InotifyWatches: 8192}
+	tests := []struct {
+		limit, backendType, want string
+	}{
+		{limitNofile, "docker", `"nofile": 2048`},
+		{limitInotifyWatches, "container", `"inotify_watches": 16384`},
+		{limitInotifyWatches, "docker", "sudo sysctl -w fs.inotify.max_user_watches=16384"},
+	}
+	for _, tt := range tests {
+		if got := limitHint(tt.limit, tt.backendType, r); !strings.Contains(got, tt.want) {
+			t.Errorf("limitHint(%s, %s) = %q, missing %q", tt.limit, tt.backendType, got, tt.want)
+		}
+	}
+	if got := limitHint(limitNofile, "docker", backend.Resources{}); !strings.Contains(got, `"nofile": 1048576`) {
+		t.Errorf("limitHint without nofile set = %q", got)
+	}
+}
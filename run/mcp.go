@@ -0,0 +1,214 @@
+package run
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/leighmcculloch/silo/config"
+	"github.com/leighmcculloch/silo/fileutil"
+	"github.com/leighmcculloch/silo/tools"
+)
+
+// mcpServerNameRegex matches MCP server names.
+var mcpServerNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// mcp is the MCP servers of a run.
+type mcp struct {
+	servers []string                    // names of the servers
+	config  map[string]config.MCPServer // the servers, with paths expanded
+	mounts  []string                    // host paths the servers need, mounted read-only
+}
+
+// resolveMCP returns the MCP servers of a run, the global mcp_servers
+// replaced by those of the same name in matching repos. "~" in their paths
+// is expanded to home, the home directory on the host and in the container
+// alike, and the paths they need are mounted.
+func resolveMCP(cfg config.Config, repoMatches []RepoMatch, home string) (mcp, error) {
+	merged := cfg.MCPServers
+	for _, rm := range repoMatches {
+		merged = config.MergeMCPServers(merged, rm.Config.MCPServers)
+	}
+	var m mcp
+	if len(merged) == 0 {
+		return m, nil
+	}
+
+	expand := func(p string) string {
+		if p == "~" {
+			return home
+		}
+		if rest, ok := strings.CutPrefix(p, "~/"); ok {
+			return filepath.Join(home, rest)
+		}
+		return p
+	}
+	servers := make(map[string]config.MCPServer, len(merged))
+	for _, name := range slices.Sorted(maps.Keys(merged)) {
+		s := merged[name]
+		if !mcpServerNameRegex.MatchString(name) {
+			return mcp{}, fmt.Errorf("invalid mcp server name %q: must be letters, digits, '_', or '-'", name)
+		}
+		switch {
+		case s.Command == "" && s.URL == "":
+			return mcp{}, fmt.Errorf("mcp server %s needs a command or a url", name)
+		case s.Command != "" && s.URL != "":
+			return mcp{}, fmt.Errorf("mcp server %s can't have both a command and a url", name)
+		case s.URL != "":
+			if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return mcp{}, fmt.Errorf("invalid url %q for mcp server %s: must be http or https", s.URL, name)
+			}
+		}
+
+		s.Command = expand(s.Command)
+		s.Args = slices.Clone(s.Args)
+		for i, a := range s.Args {
+			s.Args[i] = expand(a)
+		}
+		if s.Env != nil {
+			env := make(map[string]string, len(s.Env))
+			for k, v := range s.Env {
+				env[k] = expand(v)
+			}
+			s.Env = env
+		}
+		var mounts []string
+		for _, p := range s.Mounts {
+			p = expand(p)
+			if !filepath.IsAbs(p) {
+				return mcp{}, fmt.Errorf("invalid mount %q for mcp server %s: must be absolute or start with ~/", p, name)
+			}
+			mounts = append(mounts, filepath.Clean(p))
+		}
+		s.Mounts = mounts
+		if filepath.IsAbs(s.Command) {
+			mounts = append(mounts, s.Command)
+		}
+		for _, p := range mounts {
+			if !slices.Contains(m.mounts, p) {
+				m.mounts = append(m.mounts, p)
+			}
+		}
+		servers[name] = s
+		m.servers = append(m.servers, name)
+	}
+	m.config = servers
+	return m, nil
+}
+
+// MCPConfigPath returns the file the MCP config of the named container is
+// written to, with the copies of its ephemeral mounts so it is kept and
+// removed along with them.
+func MCPConfigPath(containerName string) string {
+	return filepath.Join(EphemeralDir(containerName), "mcp.json")
+}
+
+// mcpArgs returns the env and args that make toolDef use the servers of m
+// from the config at path.
+func mcpArgs(toolDef tools.Tool, m mcp, path string) (env, args []string) {
+	if toolDef.MCP == nil || len(m.config) == 0 {
+		return nil, nil
+	}
+	_, env, args = toolDef.MCP(m.config, path)
+	return env, args
+}
+
+// writeMCPConfig writes the servers of m to path in toolDef's MCP config
+// format, readable only by the user, and returns the env and args that make
+// the tool use them. ${env:VAR} placeholders in the env and headers of the
+// servers are replaced with the values lookup returns, so tokens can be
+// kept in secrets or the host environment rather than in the config. It
+// fails, naming every missing or blocked variable, if any can't be
+// resolved.
+func writeMCPConfig(toolDef tools.Tool, m mcp, path string, lookup func(string) (string, bool, error)) (env, args []string, err error) {
+	if toolDef.MCP == nil || len(m.config) == 0 {
+		return nil, nil, nil
+	}
+	var missing, blocked []string
+	expand := func(values map[string]string) map[string]string {
+		if values == nil {
+			return nil
+		}
+		expanded := make(map[string]string, len(values))
+		for k, v := range values {
+			expanded[k] = hookEnvRegex.ReplaceAllStringFunc(v, func(placeholder string) string {
+				name := hookEnvRegex.FindStringSubmatch(placeholder)[1]
+				v, ok, err := lookup(name)
+				switch {
+				case errors.Is(err, errHookEnvBlocked):
+					if !slices.Contains(blocked, name) {
+						blocked = append(blocked, name)
+					}
+				case !ok:
+					if !slices.Contains(missing, name) {
+						missing = append(missing, name)
+					}
+				}
+				return v
+			})
+		}
+		return expanded
+	}
+	servers := make(map[string]config.MCPServer, len(m.config))
+	for name, s := range m.config {
+		s.Env, s.Headers = expand(s.Env), expand(s.Headers)
+		servers[name] = s
+	}
+	var problems []string
+	if len(missing) > 0 {
+		slices.Sort(missing)
+		problems = append(problems, "unset environment variables: "+strings.Join(missing, ", "))
+	}
+	if len(blocked) > 0 {
+		slices.Sort(blocked)
+		problems = append(problems, "environment variables blocked by env_blocklist: "+strings.Join(blocked, ", "))
+	}
+	if len(problems) > 0 {
+		return nil, nil, fmt.Errorf("mcp_servers reference %s", strings.Join(problems, "; "))
+	}
+
+	data, env, args := toolDef.MCP(servers, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, nil, err
+	}
+	if err := fileutil.WriteFile(path, data, 0o600); err != nil {
+		return nil, nil, fmt.Errorf("failed to write mcp config: %w", err)
+	}
+	return env, args, nil
+}
+
+// mergeToolEnv returns the env of a and b, which configure the tool. A
+// variable both set to JSON objects, such as OPENCODE_CONFIG_CONTENT, is set
+// to the objects merged, so each configures its part. Otherwise b wins.
+func mergeToolEnv(a, b []string) []string {
+	result := slices.Clone(a)
+	for _, e := range b {
+		name, val, _ := strings.Cut(e, "=")
+		i := slices.IndexFunc(result, func(v string) bool { return strings.HasPrefix(v, name+"=") })
+		if i < 0 {
+			result = append(result, e)
+			continue
+		}
+		_, prev, _ := strings.Cut(result[i], "=")
+		var x, y map[string]json.RawMessage
+		if json.Unmarshal([]byte(prev), &x) != nil || json.Unmarshal([]byte(val), &y) != nil || x == nil {
+			result[i] = e
+			continue
+		}
+		maps.Copy(x, y)
+		data, err := json.Marshal(x)
+		if err != nil {
+			result[i] = e
+			continue
+		}
+		result[i] = name + "=" + string(data)
+	}
+	return result
+}
@@ -0,0 +1,111 @@
+package run
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/leighmcculloch/silo/config"
+	"github.com/leighmcculloch/silo/tools"
+)
+
+func TestResolveMCP(t *testing.T) {
+	cfg := config.Config{MCPServers: map[string]config.MCPServer{
+		"local":  {Command: "~/bin/server", Args: []string{"--db", "~/data/db.sqlite"}, Env: map[string]string{"DIR": "~/data"}, Mounts: []string{"~/data/"}},
+		"remote": {URL: "https://example.com/mcp"},
+	}}
+	repos := []RepoMatch{{Name: "github.com/org", Config: config.RepoConfig{MCPServers: map[string]config.MCPServer{
+		"remote": {URL: "https://mcp.example.org/", Headers: map[string]string{"X-Team": "org"}},
+	}}}}
+	m, err := resolveMCP(cfg, repos, "/home/u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(m.servers, []string{"local", "remote"}) {
+		t.Errorf("servers %v", m.servers)
+	}
+	if want := []string{"/home/u/data", "/home/u/bin/server"}; !slices.Equal(m.mounts, want) {
+		t.Errorf("mounts %v, want %v", m.mounts, want)
+	}
+	given := m.config
+	local := given["local"]
+	if local.Command != "/home/u/bin/server" || !slices.Equal(local.Args, []string{"--db", "/home/u/data/db.sqlite"}) || local.Env["DIR"] != "/home/u/data" {
+		t.Errorf("expected the paths of local expanded, got %+v", local)
+	}
+	if cfg.MCPServers["local"].Args[1] != "~/data/db.sqlite" {
+		t.Error("expected the config to be unmodified")
+	}
+	if given["remote"].URL != "https://mcp.example.org/" {
+		t.Errorf("expected the repo's remote server, got %+v", given["remote"])
+	}
+
+	for _, servers := range []map[string]config.MCPServer{
+		{"bad name": {Command: "x"}},
+		{"none": {}},
+		{"both": {Command: "x", URL: "https://example.com"}},
+		{"scheme": {URL: "file:///tmp/x"}},
+		{"mount": {Command: "x", Mounts: []string{"data"}}},
+	} {
+		if _, err := resolveMCP(config.Config{MCPServers: servers}, nil, "/home/u"); err == nil {
+			t.Errorf("expected error for %v", servers)
+		}
+	}
+}
+
+func TestWriteMCPConfig(t *testing.T) {
+	var given map[string]config.MCPServer
+	toolDef := tools.Tool{Name: "t", MCP: func(servers map[string]config.MCPServer, path string) ([]byte, []string, []string) {
+		given = servers
+		return []byte(`{"servers":1}`), []string{"MCP=" + path}, []string{"--mcp=" + path}
+	}}
+	m := mcp{servers: []string{"remote"}, config: map[string]config.MCPServer{
+		"remote": {URL: "https://example.com/mcp", Headers: map[string]string{"Authorization": "Bearer ${env:TOKEN}"}},
+	}}
+	lookup := hookEnvLookup([]string{"TOKEN=t0ken"}, []string{"HOST_*"})
+	path := filepath.Join(t.TempDir(), "session", "mcp.json")
+
+	env, args, err := writeMCPConfig(toolDef, m, path, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(env, []string{"MCP=" + path}) || !slices.Equal(args, []string{"--mcp=" + path}) {
+		t.Errorf("env %v, args %v", env, args)
+	}
+	if got := given["remote"].Headers["Authorization"]; got != "Bearer t0ken" {
+		t.Errorf("expected the placeholder resolved, got %q", got)
+	}
+	if m.config["remote"].Headers["Authorization"] != "Bearer ${env:TOKEN}" {
+		t.Error("expected the servers to be unmodified")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected the config to be private, got %v", info.Mode().Perm())
+	}
+	if data, _ := os.ReadFile(path); string(data) != `{"servers":1}` {
+		t.Errorf("wrote %s", data)
+	}
+
+	// Unresolved placeholders fail, naming each variable
+	t.Setenv("HOST_TOKEN", "x")
+	m.config["remote"] = config.MCPServer{URL: "https://example.com/mcp", Headers: map[string]string{"A": "${env:MISSING}", "B": "${env:HOST_TOKEN}"}}
+	_, _, err = writeMCPConfig(toolDef, m, path, lookup)
+	if err == nil || !strings.Contains(err.Error(), "MISSING") || !strings.Contains(err.Error(), "HOST_TOKEN") {
+		t.Errorf("expected an error naming both variables, got %v", err)
+	}
+}
+
+func TestMergeToolEnv(t *testing.T) {
+	got := mergeToolEnv(
+		[]string{`OPENCODE_CONFIG_CONTENT={"instructions":["a.md"]}`, "A=1"},
+		[]string{`OPENCODE_CONFIG_CONTENT={"mcp":{}}`, "A=2", "B=3"},
+	)
+	want := []string{`OPENCODE_CONFIG_CONTENT={"instructions":["a.md"],"mcp":{}}`, "A=2", "B=3"}
+	if !slices.Equal(got, want) {
+		t.Errorf("mergeToolEnv() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,167 @@
+package run
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/leighmcculloch/silo/config"
+)
+
+// defaultNotifyIdle is how long a tool must be quiet before it is assumed
+// to be waiting for input, when notify.idle isn't set.
+const defaultNotifyIdle = 30 * time.Second
+
+// notifyCooldown is the least time between notifications, as tools redraw
+// their prompts, and quiet spells follow one another.
+const notifyCooldown = time.Minute
+
+// notifyTail is how many bytes of output are kept between writes so
+// patterns split across writes are matched.
+const notifyTail = 256
+
+// resolveNotify returns how long a session must be quiet before a
+// notification, or 0 for never, with notify.idle defaulting to 30s.
+func resolveNotify(n config.Notify) (time.Duration, error) {
+	if n.Idle == "" {
+		return defaultNotifyIdle, nil
+	}
+	if n.Idle == "0" {
+		return 0, nil
+	}
+	idle, err := time.ParseDuration(n.Idle)
+	if err != nil || idle < 0 {
+		return 0, fmt.Errorf("invalid notify idle %q: must be a duration, e.g. 30s", n.Idle)
+	}
+	return idle, nil
+}
+
+// notifier watches a session's output, written to it, and calls notify when
+// the tool has been quiet for idle after writing, or writes one of patterns.
+type notifier struct {
+	idle     time.Duration
+	patterns [][]byte // lower case
+	notify   func(message string)
+
+	// now, afterFunc, and spawn are time.Now, time.AfterFunc, and starting
+	// a goroutine, replaced in tests
+	now       func() time.Time
+	afterFunc func(time.Duration, func()) timer
+	spawn     func(func())
+
+	mu    sync.Mutex
+	tail  []byte
+	timer timer
+	last  time.Time // of the last notification
+	done  bool
+}
+
+// timer is the part of *time.Timer the notifier uses.
+type timer interface {
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+func newNotifier(idle time.Duration, patterns []string, notify func(message string)) *notifier {
+	n := &notifier{
+		idle:      idle,
+		notify:    notify,
+		now:       time.Now,
+		afterFunc: func(d time.Duration, f func()) timer { return time.AfterFunc(d, f) },
+		spawn:     func(f func()) { go f() },
+	}
+	for _, p := range patterns {
+		if p != "" {
+			n.patterns = append(n.patterns, bytes.ToLower([]byte(p)))
+		}
+	}
+	return n
+}
+
+func (n *notifier) Write(b []byte) (int, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.done {
+		return len(b), nil
+	}
+	buf := bytes.ToLower(append(n.tail, b...))
+	matched := slices.IndexFunc(n.patterns, func(p []byte) bool { return bytes.Contains(buf, p) })
+	if matched >= 0 {
+		// Forget the output matched so it isn't matched again
+		n.tail = nil
+		n.fire(fmt.Sprintf("wrote %q", n.patterns[matched]))
+	} else {
+		n.tail = slices.Clone(buf[max(0, len(buf)-notifyTail):])
+	}
+	if n.idle > 0 {
+		if n.timer == nil {
+			n.timer = n.afterFunc(n.idle, n.quiet)
+		} else {
+			n.timer.Reset(n.idle)
+		}
+	}
+	return len(b), nil
+}
+
+// quiet is called when the tool has been quiet for idle.
+func (n *notifier) quiet() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.done {
+		n.fire("is waiting for input")
+	}
+}
+
+// fire calls notify with message, unless it was called within the
+// cooldown. n.mu must be held.
+func (n *notifier) fire(message string) {
+	if !n.last.IsZero() && n.now().Sub(n.last) < notifyCooldown {
+		return
+	}
+	n.last = n.now()
+	n.spawn(func() { n.notify(message) })
+}
+
+// Stop stops notifying, once the session has ended.
+func (n *notifier) Stop() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.done = true
+	if n.timer != nil {
+		n.timer.Stop()
+	}
+}
+
+// hostNotify returns a function raising a notification on the host about
+// tool in container: with command, run with sh, if set, otherwise with
+// osascript on macOS or notify-send where it is installed, and otherwise
+// by ringing the terminal bell on stderr.
+func hostNotify(command, tool, container string, stderr io.Writer) func(message string) {
+	return func(message string) {
+		message = tool + " " + message
+		var cmd *exec.Cmd
+		switch {
+		case command != "":
+			cmd = exec.Command("/bin/sh", "-c", command)
+			cmd.Env = append(os.Environ(), "SILO_NOTIFY_MESSAGE="+message, "SILO_CONTAINER="+container, "SILO_TOOL="+tool)
+		case runtime.GOOS == "darwin":
+			// The message is an argument, so it needs no AppleScript quoting
+			cmd = exec.Command("osascript", "-e", "on run argv", "-e", `display notification (item 1 of argv) with title "silo" subtitle (item 2 of argv)`, "-e", "end run", message, container)
+		default:
+			if path, err := exec.LookPath("notify-send"); err == nil {
+				cmd = exec.Command(path, "silo: "+container, message)
+			}
+		}
+		if cmd == nil {
+			fmt.Fprint(stderr, "\a")
+			return
+		}
+		_ = cmd.Run()
+	}
+}
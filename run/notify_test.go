@@ -0,0 +1,137 @@
+package run
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leighmcculloch/silo/config"
+)
+
+func TestResolveNotify(t *testing.T) {
+	tests := []struct {
+		idle    string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", defaultNotifyIdle, false},
+		{"0", 0, false},
+		{"2m", 2 * time.Minute, false},
+		{"soon", 0, true},
+		{"-1s", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := resolveNotify(config.Notify{Idle: tt.idle})
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("resolveNotify(%q) = %v, %v, want %v, error %v", tt.idle, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+// fakeClock is a clock for notifiers whose timers fire only when the test
+// advances it.
+type fakeClock struct {
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock  *fakeClock
+	at     time.Time
+	f      func()
+	active bool
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	active := t.active
+	t.at, t.active = t.clock.now.Add(d), true
+	return active
+}
+
+func (t *fakeTimer) Stop() bool {
+	active := t.active
+	t.active = false
+	return active
+}
+
+func (c *fakeClock) afterFunc(d time.Duration, f func()) timer {
+	t := &fakeTimer{clock: c, f: f}
+	t.Reset(d)
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// advance moves the clock on by d, firing the timers that come due.
+func (c *fakeClock) advance(d time.Duration) {
+	c.now = c.now.Add(d)
+	for _, t := range c.timers {
+		if t.active && !t.at.After(c.now) {
+			t.active = false
+			t.f()
+		}
+	}
+}
+
+// testNotifier returns a notifier on a fake clock that records its
+// messages as they are raised.
+func testNotifier(idle time.Duration, patterns []string) (*notifier, *fakeClock, *[]string) {
+	var messages []string
+	n := newNotifier(idle, patterns, func(message string) { messages = append(messages, message) })
+	c := &fakeClock{now: time.Unix(0, 0)}
+	n.now = func() time.Time { return c.now }
+	n.afterFunc = c.afterFunc
+	n.spawn = func(f func()) { f() }
+	return n, c, &messages
+}
+
+func TestNotifierPatterns(t *testing.T) {
+	n, c, messages := testNotifier(0, []string{"Do you want to proceed?"})
+	defer n.Stop()
+
+	// Matched across writes and regardless of case
+	n.Write([]byte("Editing main.go\nDo you want "))
+	n.Write([]byte("to PROCEED?\n"))
+	// Redrawn prompts within the cooldown don't notify again
+	c.advance(notifyCooldown / 2)
+	n.Write([]byte("Do you want to proceed?\n"))
+	if got := *messages; len(got) != 1 || got[0] != `wrote "do you want to proceed?"` {
+		t.Errorf("got notifications %q, want one for the pattern", got)
+	}
+
+	// After the cooldown they do
+	c.advance(notifyCooldown)
+	n.Write([]byte("Do you want to proceed?\n"))
+	if got := *messages; len(got) != 2 {
+		t.Errorf("got notifications %q, want another after the cooldown", got)
+	}
+}
+
+func TestNotifierIdle(t *testing.T) {
+	n, c, messages := testNotifier(20*time.Second, nil)
+	defer n.Stop()
+
+	// Nothing is written, so nothing is waited on
+	c.advance(time.Minute)
+	if got := *messages; len(got) != 0 {
+		t.Fatalf("got notifications %q before any output", got)
+	}
+
+	n.Write([]byte("Working...\n"))
+	c.advance(10 * time.Second)
+	n.Write([]byte("Done. Anything else?\n"))
+	c.advance(10 * time.Second)
+	if got := *messages; len(got) != 0 {
+		t.Fatalf("got notifications %q while the tool was writing", got)
+	}
+	c.advance(10 * time.Second)
+	if got := *messages; len(got) != 1 || got[0] != "is waiting for input" {
+		t.Errorf("got notifications %q, want one for the quiet tool", got)
+	}
+
+	// Nothing fires after the session ends
+	n.Stop()
+	n.Write([]byte("more"))
+	c.advance(2 * notifyCooldown)
+	if got := *messages; len(got) != 1 {
+		t.Errorf("got notifications %q after Stop", got)
+	}
+}
@@ -0,0 +1,244 @@
+package run
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+
+	"github.com/kballard/go-shellquote"
+	"github.com/leighmcculloch/silo/backend"
+	applecontainer "github.com/leighmcculloch/silo/backend/container"
+	"github.com/leighmcculloch/silo/backend/docker"
+	"github.com/leighmcculloch/silo/backend/sandbox"
+	"github.com/leighmcculloch/silo/cli"
+	"github.com/leighmcculloch/silo/config"
+)
+
+// Plan prints what Tool would do for opts without touching the backend: the
+// Dockerfile with its hooks, the image tag, the mounts, the names of the
+// environment variables, the pre-run hook script, and a backend command
+// equivalent to the run. Secrets aren't fetched and ${env:VAR} placeholders
+// in hooks aren't resolved, so no secret values are printed.
+func Plan(opts Options) error {
+	tool := opts.ToolDef.Name
+	cfg, err := config.ExpandHookRefs(opts.Config)
+	if err != nil {
+		return err
+	}
+	opts.Config = cfg
+	w := opts.Stdout
+
+	logSection := func(format string, args ...any) {
+		if opts.Verbose {
+			cli.LogTo(opts.Stderr, format, args...)
+		}
+	}
+	rc, err := resolveRun(opts, logSection)
+	if err != nil {
+		return err
+	}
+
+	mountsRO, mountsRW := collectMounts(tool, cfg, rc.cwd, rc.repoMatches, rc.repoRoots())
+	mountsRO = withFileMounts(mountsRO, mountsRW, slices.Concat(rc.instructions.mounts, rc.mcp.mounts))
+	mountsRO = append(mountsRO, rc.signing.mountsRO...)
+	if rc.profile == ProfileStrict {
+		mountsRO, mountsRW = strictMounts(mountsRO, mountsRW, append([]string{rc.cwd}, rc.repoRoots()...))
+	}
+	envVars, _ := collectEnvVars(tool, cfg, rc.repoMatches, rc.gitName, rc.gitEmail)
+	envVars = withToolEnv(envVars, rc.instructions.env)
+	envVars = append(envVars, rc.signing.env...)
+	envVars = append(envVars, rc.serviceEnv...)
+	env := envNames(envVars)
+	for _, name := range slices.Sorted(maps.Keys(rc.secretDefs)) {
+		if !slices.Contains(env, name) {
+			env = append(env, name)
+		}
+	}
+
+	// The number of the container is picked from the containers that exist
+	// when it runs
+	containerName := backend.NextName(rc.containerBase, nil)
+
+	// The MCP config is only written when the session runs
+	command := rc.command
+	if len(rc.mcp.config) > 0 && opts.ToolDef.MCP != nil {
+		mcpConfig := MCPConfigPath(containerName)
+		mcpEnv, args := mcpArgs(opts.ToolDef, rc.mcp, mcpConfig)
+		for _, name := range envNames(mcpEnv) {
+			if !slices.Contains(env, name) {
+				env = append(env, name)
+			}
+		}
+		command = slices.Concat(command, args)
+		mountsRO = withFileMounts(mountsRO, mountsRW, []string{mcpConfig})
+	}
+
+	runMountsRW := slices.DeleteFunc(slices.Clone(mountsRW), func(m string) bool {
+		return slices.Contains(rc.ephemeralMounts, m)
+	})
+	mountsMapped := ephemeralCopies(EphemeralDir(containerName), rc.ephemeralMounts)
+	if rc.profile == ProfileStrict {
+		mountsMapped = append(mountsMapped, scratchMount(EphemeralDir(containerName)))
+	}
+	mountsMapped = append(mountsMapped, isolatedMounts(rc.isolateDir, rc.isolatePaths)...)
+
+	timeouts, err := resolveTimeouts(cfg.Timeouts)
+	if err != nil {
+		return err
+	}
+	preRunHooks := preparePreRunHooks(slices.Concat(cfg.PreRunHooks, rc.toolPreRunHooks, rc.repoPreRunHooks), nil, nil, mountsRO, mountsRW, timeouts.mountWait, opts.Verbose)
+
+	runOpts := backend.RunOptions{
+		Image:          rc.img.tag,
+		Name:           containerName,
+		WorkDir:        rc.cwd,
+		MountsRO:       mountsRO,
+		MountsRW:       runMountsRW,
+		MountsMapped:   mountsMapped,
+		Env:            env,
+		Command:        command,
+		Args:           opts.ToolArgs,
+		PreRunHooks:    preRunHooks,
+		Resources:      rc.resources,
+		Network:        rc.network,
+		Keep:           opts.Keep,
+		Detach:         opts.Detach,
+		Labels:         runLabels(opts),
+		Ports:          rc.ports,
+		Tmpfs:          rc.tmpfs,
+		ReadOnlyRootfs: rc.readOnlyRootfs,
+		Platform:       rc.img.platform,
+		Volumes:        rc.volumes,
+		DockerInDocker: rc.dockerInDocker,
+		SELinuxLabel:   rc.selinuxLabel,
+		Userns:         rc.userns,
+		UID:            rc.uid,
+		SSHAgent:       rc.sshAgent,
+		GPGAgent:       rc.signing.gpgAgent,
+		GPGKeyring:     rc.signing.gpgKeyring,
+		Services:       rc.services,
+		Stop:           rc.stop,
+		NoTTY:          opts.NoTTY && !opts.Detach,
+	}
+
+	backendType, reason := SelectBackend(cfg.Backend)
+	cli.LogTo(w, "Backend: %s (%s)", backendType, reason)
+	runOpts.Tmpfs = runTmpfs(rc, backendType)
+	runOpts.Seccomp, runOpts.AppArmor, err = securityProfiles(rc, backendType, func(format string, args ...any) {
+		cli.LogWarningTo(w, format, args...)
+	})
+	if err != nil {
+		return err
+	}
+
+	cli.LogTo(w, "Image: %s", rc.img.tag)
+	if rc.img.platform != "" {
+		cli.LogDimTo(w, "platform %s", rc.img.platform)
+	}
+	for _, k := range slices.Sorted(maps.Keys(rc.img.buildArgs)) {
+		cli.LogBulletTo(w, "build arg %s=%s", k, rc.img.buildArgs[k])
+	}
+
+	cli.LogTo(w, "Dockerfile:")
+	writeBlock(w, rc.img.dockerfile)
+
+	cli.LogTo(w, "Mounts:")
+	for _, m := range mountsRO {
+		cli.LogBulletTo(w, "%s (read-only%s)", m, missingNote(m))
+	}
+	for _, m := range runMountsRW {
+		cli.LogBulletTo(w, "%s (read-write%s)", m, missingNote(m))
+	}
+	for _, m := range mountsMapped {
+		cli.LogBulletTo(w, "%s (copy at %s)", m.Target, m.Source)
+	}
+	for _, v := range rc.volumes {
+		cli.LogBulletTo(w, "%s (volume %s)", v.Target, v.Source)
+	}
+	for _, p := range rc.tmpfs {
+		cli.LogBulletTo(w, "%s (tmpfs)", p)
+	}
+
+	if len(rc.services) > 0 {
+		cli.LogTo(w, "Services:")
+		for _, svc := range rc.services {
+			cli.LogBulletTo(w, "%s (%s)", svc.Name, svc.Image)
+		}
+	}
+
+	cli.LogTo(w, "Environment variables:")
+	for _, name := range env {
+		if _, ok := rc.secretDefs[name]; ok {
+			cli.LogBulletTo(w, "%s (secret)", name)
+		} else {
+			cli.LogBulletTo(w, "%s", name)
+		}
+	}
+
+	cli.LogTo(w, "Pre-run hook script:")
+	writeBlock(w, strings.Join(preRunHooks, " &&\n"))
+
+	if len(rc.onFailureHooks) > 0 {
+		cli.LogTo(w, "On-failure hook script (on the host):")
+		writeBlock(w, strings.Join(rc.onFailureHooks, " &&\n"))
+	}
+	if len(rc.postRunHooks) > 0 {
+		cli.LogTo(w, "Post-run hook script (on the host):")
+		writeBlock(w, strings.Join(rc.postRunHooks, " &&\n"))
+	}
+
+	cli.LogTo(w, "Command:")
+	switch backendType {
+	case "docker":
+		if rc.network.Mode == backend.NetworkAllowlist {
+			cli.LogDimTo(w, "the egress proxy of the allowlist network is started alongside")
+		}
+		if len(rc.services) > 0 {
+			cli.LogDimTo(w, "the services are started first, on a network the container is connected to")
+		}
+		if rc.uid != 0 && runtime.GOOS == "linux" {
+			cli.LogDimTo(w, "the read-write mounts are shared with the container user (uid %d) using ACLs first, unless Docker is rootless", rc.uid)
+		}
+		writeBlock(w, shellquote.Join(docker.RunCommand(runOpts)...))
+	case "container":
+		args := applecontainer.RunCommand(runOpts)
+		if args == nil {
+			cli.LogDimTo(w, "container backend is only available on macOS")
+			return nil
+		}
+		writeBlock(w, shellquote.Join(args...))
+	case "sandbox":
+		args := sandbox.RunCommand(runOpts)
+		if args == nil {
+			cli.LogDimTo(w, "sandbox backend is only available on macOS")
+			return nil
+		}
+		cli.LogDimTo(w, "runs on the host, with weaker isolation than a container")
+		writeBlock(w, shellquote.Join(args...))
+	default:
+		return fmt.Errorf("unknown backend: %s (valid: docker, container, sandbox)", backendType)
+	}
+	return nil
+}
+
+// missingNote returns a note for a mount path that doesn't exist, which
+// isn't mounted.
+func missingNote(path string) string {
+	if _, err := os.Lstat(path); err != nil {
+		return ", not found so not mounted"
+	}
+	return ""
+}
+
+// writeBlock writes text as is, such as a Dockerfile or script, ending it
+// with a newline.
+func writeBlock(w io.Writer, text string) {
+	if text == "" {
+		return
+	}
+	fmt.Fprintln(w, strings.TrimSuffix(text, "\n"))
+}
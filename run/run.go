@@ -1,28 +1,51 @@
 package run
 
 import (
+	"cmp"
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"net"
 	"os"
 	"os/exec"
 	"os/signal"
+	osuser "os/user"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
+	"github.com/kballard/go-shellquote"
 	"github.com/leighmcculloch/silo/backend"
 	applecontainer "github.com/leighmcculloch/silo/backend/container"
 	"github.com/leighmcculloch/silo/backend/docker"
+	"github.com/leighmcculloch/silo/backend/sandbox"
+	"github.com/leighmcculloch/silo/backend/termproxy"
 	"github.com/leighmcculloch/silo/cli"
 	"github.com/leighmcculloch/silo/config"
+	"github.com/leighmcculloch/silo/events"
+	"github.com/leighmcculloch/silo/fileutil"
 	"github.com/leighmcculloch/silo/git"
+	"github.com/leighmcculloch/silo/journal"
 	"github.com/leighmcculloch/silo/mountwait"
+	"github.com/leighmcculloch/silo/recording"
+	"github.com/leighmcculloch/silo/secrets"
+	"github.com/leighmcculloch/silo/statedir"
 	"github.com/leighmcculloch/silo/tilde"
+	"github.com/leighmcculloch/silo/tmpl"
 	"github.com/leighmcculloch/silo/tools"
+	"github.com/moby/term"
 )
 
 // Options configures a tool run.
@@ -31,7 +54,21 @@ type Options struct {
 	ToolArgs   []string
 	Config     config.Config
 	Dockerfile string // raw Dockerfile template (before hook injection)
+	Version    string // silo version, used in update notices
 	ForceBuild bool
+	Offline    bool              // never use the network to prepare the run, see Prefetch
+	CacheFrom  []string          // build caches to import, see parseBuildCache; Build only
+	CacheTo    []string          // build caches to export, see parseBuildCache; Build only
+	Keep       bool              // keep the container after it exits
+	Detach     bool              // start the container in the background
+	Record     bool              // record the session's output, see the recording package
+	NoTTY      bool              // run without a TTY, see backend.RunOptions.NoTTY
+	Timeout    time.Duration     // stop the session after this long, 0 for no limit
+	Output     io.Writer         // receives a copy of the session's output
+	Dir        string            // directory to run in (default: current directory)
+	Labels     map[string]string // labels set on the container
+	Profile    string            // sandbox profile, overriding the configured one
+	Platform   string            // platform, overriding the configured one
 	Verbose    bool
 	Stdout     io.Writer
 	Stderr     io.Writer
@@ -40,8 +77,18 @@ type Options struct {
 // Tool runs a tool inside a container.
 func Tool(opts Options) error {
 	tool := opts.ToolDef.Name
-	cfg := opts.Config
+	cfg, err := config.ExpandHookRefs(opts.Config)
+	if err != nil {
+		return err
+	}
+	opts.Config = cfg
 	stderr := opts.Stderr
+	for _, setting := range cfg.Ignored {
+		cli.LogWarningTo(stderr, "Ignoring %s: it's only read from the global config, %s", setting, config.GlobalConfigPath())
+	}
+	if opts.NoTTY && opts.Keep {
+		return errors.New("--keep needs a terminal, as kept sessions are reattached from one: run silo in a terminal, or without --keep")
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -59,6 +106,15 @@ func Tool(opts Options) error {
 		"Running",
 	}
 
+	// Let the user know when a new silo version changed the environment
+	// since the last run, as it explains the rebuild that follows.
+	templateHash := TemplateHash(opts.Dockerfile)
+	if entries, err := journal.Read(); err == nil {
+		if notice := updateNotice(entries, tool, templateHash, opts.Version); notice != "" {
+			cli.LogTo(stderr, "%s", notice)
+		}
+	}
+
 	// Create progress bar (only used when not verbose)
 	var progress *cli.Progress
 	if !opts.Verbose {
@@ -88,7 +144,14 @@ func Tool(opts Options) error {
 	if progress != nil {
 		progress.SetSection("Backend")
 	}
-	backendClient, err := createBackend(cfg.Backend, stderr, opts.Verbose)
+	timeouts, err := resolveTimeouts(cfg.Timeouts)
+	if err != nil {
+		if progress != nil {
+			progress.Complete()
+		}
+		return err
+	}
+	backendClient, backendType, err := selectAvailableBackend(ctx, cfg.Backend, cfg.BackendFallback, timeouts.connect, stderr, opts.Verbose, progress)
 	if err != nil {
 		if progress != nil {
 			progress.Complete()
@@ -98,89 +161,48 @@ func Tool(opts Options) error {
 	defer backendClient.Close()
 
 	// Start async version fetch (updates cache for this or next run)
-	go opts.ToolDef.FetchVersion(ctx)
-
-	// Get current user info
-	home := os.Getenv("HOME")
-	user := os.Getenv("USER")
-	uid := os.Getuid()
-	cwd, _ := os.Getwd()
-
-	// Pre-fetch git data concurrently to avoid sequential subprocess calls
-	var remoteURLs []string
-	var worktreeRoots []string
-	var gitName, gitEmail string
-	var gitWg sync.WaitGroup
-	gitWg.Add(3)
-	go func() {
-		defer gitWg.Done()
-		remoteURLs = git.GetGitRemoteURLs(cwd)
-	}()
-	go func() {
-		defer gitWg.Done()
-		worktreeRoots, _ = git.GetGitWorktreeRoots(cwd)
-	}()
-	go func() {
-		defer gitWg.Done()
-		gitName, gitEmail = git.GetGitIdentity()
-	}()
-	gitWg.Wait()
-	repoMatches := matchRepos(cfg, remoteURLs)
-
-	// Get tool-specific hooks
-	var toolPreRunHooks, toolPostBuildHooks []string
-	if toolCfg, ok := cfg.Tools[tool]; ok {
-		toolPreRunHooks = toolCfg.PreRunHooks
-		toolPostBuildHooks = toolCfg.PostBuildHooks
-	}
-
-	// Get repo-specific hooks
-	var repoPreRunHooks, repoPostBuildHooks []string
-	var matchedRepoNames []string
-	for _, m := range repoMatches {
-		matchedRepoNames = append(matchedRepoNames, m.Name)
-		repoPreRunHooks = append(repoPreRunHooks, m.Config.PreRunHooks...)
-		repoPostBuildHooks = append(repoPostBuildHooks, m.Config.PostBuildHooks...)
+	if !opts.Offline {
+		go opts.ToolDef.FetchVersion(ctx)
 	}
 
-	// Prepare build configuration (imageTag depends only on dockerfile + buildArgs, not mounts)
-	dockerfile := dockerfileWithHooks(opts.Dockerfile, cfg.PostBuildHooks, tool, toolPostBuildHooks, repoPostBuildHooks)
-	buildArgs := map[string]string{
-		"HOME": home,
-		"USER": user,
-		"UID":  fmt.Sprintf("%d", uid),
+	rc, err := resolveRun(opts, logSection)
+	if err != nil {
+		if progress != nil {
+			progress.Complete()
+		}
+		return err
 	}
-
-	// Read cached tool version for cache-busting
-	toolVersion := opts.ToolDef.CachedVersion()
-	if toolVersion != "" {
-		logSection("Tool version (cached): %s", toolVersion)
-		buildArgs["CACHE_BUST"] = toolVersion
+	dockerfile, buildArgs, imageTag, cacheFrom := rc.img.dockerfile, rc.img.buildArgs, rc.img.tag, rc.img.cacheFrom
+	if hint := selinuxHint(cfg.SELinuxLabel, backendType, selinuxEnforcing()); hint != "" {
+		cli.LogWarningTo(stderr, "%s", hint)
 	}
 
-	imageTag := buildImageTag(tool, dockerfile, buildArgs)
-
 	// Run independent operations concurrently
 	var mountsRO, mountsRW []string
 	var envVars []string
 	var envLog envLogInfo
+	var secretEnv, secretValues []string
+	var secretsErr error
 	var containerName string
 	var imageExists bool
 	var imageExistsErr error
 	var opsWg sync.WaitGroup
-	opsWg.Add(4)
+	opsWg.Add(5)
+	go func() {
+		defer opsWg.Done()
+		mountsRO, mountsRW = collectMounts(tool, cfg, rc.cwd, rc.repoMatches, rc.repoRoots())
+	}()
 	go func() {
 		defer opsWg.Done()
-		mountsRO, mountsRW = collectMounts(tool, cfg, cwd, repoMatches, worktreeRoots)
+		envVars, envLog = collectEnvVars(tool, cfg, rc.repoMatches, rc.gitName, rc.gitEmail)
 	}()
 	go func() {
 		defer opsWg.Done()
-		envVars, envLog = collectEnvVars(tool, cfg, repoMatches, gitName, gitEmail)
+		secretEnv, secretValues, secretsErr = secrets.FetchAll(ctx, rc.secretDefs)
 	}()
 	go func() {
 		defer opsWg.Done()
-		baseName := sanitizeContainerName(filepath.Base(cwd))
-		containerName = backendClient.NextContainerName(ctx, baseName)
+		containerName = backendClient.NextContainerName(ctx, rc.containerBase)
 	}()
 	go func() {
 		defer opsWg.Done()
@@ -190,6 +212,12 @@ func Tool(opts Options) error {
 	}()
 	opsWg.Wait()
 
+	mountsRO = withFileMounts(mountsRO, mountsRW, slices.Concat(rc.instructions.mounts, rc.mcp.mounts))
+	mountsRO = append(mountsRO, rc.signing.mountsRO...)
+	if rc.profile == ProfileStrict {
+		mountsRO, mountsRW = strictMounts(mountsRO, mountsRW, append([]string{rc.cwd}, rc.repoRoots()...))
+	}
+
 	// Surface backend errors early (e.g. daemon not running) rather than
 	// letting them manifest as a confusing "build failed" later.
 	if imageExistsErr != nil {
@@ -198,11 +226,47 @@ func Tool(opts Options) error {
 		}
 		return imageExistsErr
 	}
+	if secretsErr != nil {
+		if progress != nil {
+			progress.Complete()
+		}
+		return secretsErr
+	}
+	for _, name := range envLog.listedBlocked {
+		cli.LogWarningTo(stderr, "Passing %s, listed in env, though it matches env_blocklist: add \"!%s\" to env_blocklist to allow it without this warning", name, name)
+	}
+	envVars = withSecrets(envVars, secretEnv)
+	envVars = withToolEnv(envVars, rc.instructions.env)
+	envVars = append(envVars, rc.signing.env...)
+	envVars = append(envVars, rc.serviceEnv...)
+
+	if opts.Offline {
+		if err := checkOffline(ctx, backendClient, tool, imageExists, rc.services); err != nil {
+			if progress != nil {
+				progress.Complete()
+			}
+			return err
+		}
+	}
+
+	// Pull a shared image rather than building it, if configured
+	if !imageExists && !opts.ForceBuild && !opts.Offline {
+		if progress != nil && cfg.PullPolicy == PullIfNotPresent {
+			progress.SetSection("Pulling image")
+		}
+		if err := pullShared(ctx, backendClient, cfg, rc.img, logSection); err != nil {
+			if progress != nil {
+				progress.Complete()
+			}
+			return err
+		}
+	}
 
 	// Build or use cached image
 	if progress != nil {
 		progress.SetSection("Post-build hooks")
 	}
+	buildStart := time.Now()
 	if err := buildEnvironment(ctx, backendClient, buildEnvOptions{
 		tool:               tool,
 		dockerfile:         dockerfile,
@@ -212,10 +276,15 @@ func Tool(opts Options) error {
 		mountsRW:           mountsRW,
 		forceBuild:         opts.ForceBuild,
 		imageExists:        imageExists,
+		cacheFrom:          cacheFrom,
+		platform:           rc.img.platform,
+		buildSecrets:       rc.img.buildSecrets,
+		shared:             rc.img.shared,
+		timeout:            timeouts.build,
 		globalPostBuild:    cfg.PostBuildHooks,
-		toolPostBuildHooks: toolPostBuildHooks,
-		repoPostBuildHooks: repoPostBuildHooks,
-		matchedRepoNames:   matchedRepoNames,
+		toolPostBuildHooks: rc.toolPostBuildHooks,
+		repoPostBuildHooks: rc.repoPostBuildHooks,
+		matchedRepoNames:   rc.matchedRepoNames,
 		stderr:             stderr,
 		verbose:            opts.Verbose,
 		progress:           progress,
@@ -225,6 +294,8 @@ func Tool(opts Options) error {
 		}
 		return err
 	}
+	buildDuration := time.Since(buildStart)
+	warnEmulation(ctx, backendClient, backendType, tool, imageTag, stderr, progress)
 
 	// Log configuration
 	if progress != nil {
@@ -237,18 +308,72 @@ func Tool(opts Options) error {
 		mountsRW:         mountsRW,
 		envLog:           envLog,
 		globalPreRun:     cfg.PreRunHooks,
-		toolPreRun:       toolPreRunHooks,
-		repoPreRun:       repoPreRunHooks,
-		matchedRepoNames: matchedRepoNames,
+		toolPreRun:       rc.toolPreRunHooks,
+		repoPreRun:       rc.repoPreRunHooks,
+		matchedRepoNames: rc.matchedRepoNames,
 		containerName:    containerName,
-		gitName:          gitName,
-		gitEmail:         gitEmail,
+		network:          rc.network,
+		ports:            rc.ports,
+		tmpfs:            rc.tmpfs,
+		volumes:          rc.volumes,
+		secrets:          rc.secretDefs,
+		secretValues:     slices.Concat(secretValues, redactValues(envLog, cfg.EnvRedact)),
+		gitName:          rc.gitName,
+		gitEmail:         rc.gitEmail,
 		verbose:          opts.Verbose,
 		progress:         progress,
 	})
 
-	// Prepare pre-run hooks
-	preRunHooks := preparePreRunHooks(cfg.PreRunHooks, toolPreRunHooks, repoPreRunHooks, mountsRO, mountsRW, opts.Verbose)
+	// Prepare pre-run hooks, resolving ${env:VAR} placeholders only now so
+	// their values are never part of the image
+	userPreRunHooks, hookEnv, err := expandHookEnv(slices.Concat(cfg.PreRunHooks, rc.toolPreRunHooks, rc.repoPreRunHooks), hookEnvLookup(envVars, cfg.EnvBlocklist))
+	if err != nil {
+		if progress != nil {
+			progress.Complete()
+		}
+		return err
+	}
+	envVars = append(envVars, hookEnv...)
+	preRunHooks := preparePreRunHooks(userPreRunHooks, nil, nil, mountsRO, mountsRW, timeouts.mountWait, opts.Verbose)
+
+	// Mount copies of the tool's config instead of the originals so the
+	// tool can't modify them
+	runMountsRW := mountsRW
+	var mountsMapped []backend.Mount
+	// Until the backend runs the session, which takes over what is staged
+	// in its ephemeral directory, a failure removes it
+	staged := true
+	defer func() {
+		if staged {
+			os.RemoveAll(EphemeralDir(containerName))
+		}
+	}()
+	if len(rc.ephemeralMounts) > 0 {
+		runMountsRW = slices.DeleteFunc(slices.Clone(mountsRW), func(m string) bool {
+			return slices.Contains(rc.ephemeralMounts, m)
+		})
+		mountsMapped, err = stageEphemeral(EphemeralDir(containerName), rc.ephemeralMounts)
+		if err != nil {
+			if progress != nil {
+				progress.Complete()
+			}
+			return err
+		}
+		for _, m := range mountsMapped {
+			logSection("Mounting a copy of %s", m.Target)
+		}
+	}
+	if rc.profile == ProfileStrict {
+		scratch, err := stageScratch(EphemeralDir(containerName))
+		if err != nil {
+			if progress != nil {
+				progress.Complete()
+			}
+			return err
+		}
+		mountsMapped = append(mountsMapped, scratch)
+		logSection("Profile strict: repository is read-only, scratch directory at %s", scratch.Target)
+	}
 
 	if progress != nil {
 		progress.SetSection("Running")
@@ -260,18 +385,245 @@ func Tool(opts Options) error {
 		progress.Complete()
 	}
 
+	// Guide the user through logging in before the tool starts, rather than
+	// letting it fail confusingly mid-session.
+	if opts.ToolDef.Auth != nil {
+		preRunHooks = checkAuth(stderr, tool, opts.ToolDef.Auth(), envVars, preRunHooks, opts.Detach || opts.NoTTY)
+	}
+
+	// Write the MCP config, with its ${env:VAR} placeholders resolved, to a
+	// private file rather than passing it where the container's config
+	// shows it
+	command := rc.command
+	if len(rc.mcp.config) > 0 && opts.ToolDef.MCP != nil {
+		mcpConfig := MCPConfigPath(containerName)
+		mcpEnv, mcpArgs, err := writeMCPConfig(opts.ToolDef, rc.mcp, mcpConfig, hookEnvLookup(envVars, cfg.EnvBlocklist))
+		if err != nil {
+			return err
+		}
+		envVars = withToolEnv(envVars, mcpEnv)
+		command = slices.Concat(command, mcpArgs)
+		mountsRO = withFileMounts(mountsRO, mountsRW, []string{mcpConfig})
+	}
+
+	// Keep the tool's state of this repository apart from that of others
+	isolated, err := stageIsolated(rc.isolateDir, rc.isolatePaths)
+	if err != nil {
+		return err
+	}
+	for _, m := range isolated {
+		logSection("Isolating %s in %s", m.Target, m.Source)
+	}
+
+	// Record the silo that created the container, so resuming it later with
+	// a different silo can warn that its scripts are from that version
+	labels := runLabels(opts)
+	session := newSessionID()
+	labels[backend.LabelSession] = session
+	logSection("silo %s, scripts version %s", opts.Version, mountwait.ScriptVersion)
+
 	// Run the container/VM
-	err = backendClient.Run(ctx, backend.RunOptions{
-		Image:       imageTag,
-		Name:        containerName,
-		WorkDir:     cwd,
-		MountsRO:    mountsRO,
-		MountsRW:    mountsRW,
-		Env:         envVars,
-		Command:     opts.ToolDef.Command(home),
-		Args:        opts.ToolArgs,
-		PreRunHooks: preRunHooks,
+	sessionStart := time.Now()
+	limits := &limitDetector{}
+	var output io.Writer = limits
+	var notify *notifier
+	if cfg.Notify.Enabled && !opts.Detach {
+		notify = newNotifier(rc.notifyIdle, cfg.Notify.Patterns, hostNotify(cfg.Notify.Command, tool, containerName, stderr))
+		output = io.MultiWriter(limits, notify)
+		logSection("Notifying when %s needs attention", tool)
+	}
+	var recorder *recording.Recorder
+	if opts.Record && !opts.Detach {
+		// The size of the terminal the session is attached to, which
+		// players need to lay the output out as it was
+		width, height := 80, 24
+		if ws, err := term.GetWinsize(os.Stdout.Fd()); err == nil && ws.Width > 0 {
+			width, height = int(ws.Width), int(ws.Height)
+		}
+		if backendType == "sandbox" {
+			cli.LogWarningTo(stderr, "The sandbox backend can't record sessions, not recording")
+		} else if recorder, err = recording.Create(containerName, tool+" in "+rc.cwd, width, height); err != nil {
+			return fmt.Errorf("failed to start recording: %w", err)
+		} else {
+			output = io.MultiWriter(output, recorder)
+			logSection("Recording to %s", recorder.Path())
+		}
+	}
+	if opts.Output != nil {
+		output = io.MultiWriter(output, opts.Output)
+	}
+	seccomp, appArmor, err := securityProfiles(rc, backendType, func(format string, args ...any) {
+		cli.LogWarningTo(stderr, format, args...)
+	})
+	if err != nil {
+		return err
+	}
+	runCtx := ctx
+	if opts.Timeout > 0 && !opts.Detach {
+		var cancelRun context.CancelFunc
+		runCtx, cancelRun = context.WithTimeout(ctx, opts.Timeout)
+		defer cancelRun()
+	}
+	staged = false
+	err = backendClient.Run(runCtx, backend.RunOptions{
+		Image:          imageTag,
+		Name:           containerName,
+		WorkDir:        rc.cwd,
+		MountsRO:       mountsRO,
+		MountsRW:       runMountsRW,
+		MountsMapped:   slices.Concat(mountsMapped, isolated),
+		Env:            envVars,
+		Command:        command,
+		Args:           opts.ToolArgs,
+		PreRunHooks:    preRunHooks,
+		Resources:      rc.resources,
+		Network:        rc.network,
+		Keep:           opts.Keep,
+		Detach:         opts.Detach,
+		Labels:         labels,
+		Ports:          rc.ports,
+		Tmpfs:          runTmpfs(rc, backendType),
+		ReadOnlyRootfs: rc.readOnlyRootfs,
+		Seccomp:        seccomp,
+		AppArmor:       appArmor,
+		Platform:       rc.img.platform,
+		Volumes:        rc.volumes,
+		DockerInDocker: rc.dockerInDocker,
+		SELinuxLabel:   rc.selinuxLabel,
+		Userns:         rc.userns,
+		UID:            rc.uid,
+		ConfirmShare: func(dir string) bool {
+			return cli.Confirm(
+				fmt.Sprintf("Share %s with uid %d?", dir, rc.uid),
+				fmt.Sprintf("The container user (uid %d) isn't you, so silo gives it read-write access to %s, and gives you access to what it creates, with ACLs that stay after the session.", rc.uid, dir),
+				"Share", "Cancel")
+		},
+		SSHAgent:   rc.sshAgent,
+		GPGAgent:   rc.signing.gpgAgent,
+		GPGKeyring: rc.signing.gpgKeyring,
+		Services:   rc.services,
+		MenuKey:    rc.menuKey,
+		Output:     output,
+		OnStart: func() {
+			events.Emit(events.Event{Type: events.ContainerStarted, Tool: tool, Image: imageTag, Container: containerName})
+			if len(preRunHooks) > 0 {
+				events.Emit(events.Event{Type: events.HooksRunning, Tool: tool, Container: containerName, Hooks: "pre_run", Count: len(preRunHooks)})
+			}
+		},
+		Stop:  rc.stop,
+		NoTTY: opts.NoTTY && !opts.Detach,
 	})
+	if errors.Is(runCtx.Err(), context.DeadlineExceeded) {
+		// The backend stopped the session, exit like timeout(1) does
+		cli.LogErrorTo(stderr, "%s timed out after %s", tool, opts.Timeout)
+		err = &backend.ExitError{Code: 124}
+	}
+	if !opts.Detach {
+		exited := events.Event{Type: events.ToolExited, Tool: tool, Container: containerName}
+		var exitErr *backend.ExitError
+		switch {
+		case errors.As(err, &exitErr):
+			exited.ExitCode = &exitErr.Code
+		case err != nil:
+			exited.Error = secrets.Redact(err.Error(), slices.Concat(secretValues, redactValues(envLog, cfg.EnvRedact)))
+		default:
+			exited.ExitCode = new(int)
+		}
+		events.Emit(exited)
+	}
+	if notify != nil {
+		notify.Stop()
+	}
+	if recorder != nil {
+		if err := recorder.Close(); err != nil {
+			cli.LogWarningTo(stderr, "Recording may be incomplete: %v", err)
+		}
+	}
+
+	// Record the run for stats and auditing. Failures to write the journal
+	// never fail the run.
+	entry := journal.Entry{
+		Time:           sessionStart,
+		Tool:           tool,
+		Backend:        backendType,
+		ImageTag:       imageTag,
+		TemplateHash:   templateHash,
+		Version:        opts.Version,
+		SessionSeconds: time.Since(sessionStart).Seconds(),
+		Dir:            rc.cwd,
+		Remotes:        rc.remoteURLs,
+		Head:           rc.head,
+		Branch:         rc.branch,
+		Workspaces:     rc.workspaces,
+		Container:      containerName,
+		Session:        session,
+		MountsRO:       mountsRO,
+		MountsRW:       mountsRW,
+		EnvNames:       envNames(envVars),
+		PreRunHooks:    slices.Concat(cfg.PreRunHooks, rc.toolPreRunHooks, rc.repoPreRunHooks),
+		PostBuildHooks: slices.Concat(cfg.PostBuildHooks, rc.toolPostBuildHooks, rc.repoPostBuildHooks),
+		Detached:       opts.Detach,
+	}
+	if recorder != nil {
+		entry.Recording = recorder.Path()
+	}
+	if opts.Detach {
+		// The session continues in the background, so its length is unknown.
+		entry.SessionSeconds = 0
+	}
+	if !imageExists {
+		entry.Built = true
+		entry.BuildSeconds = buildDuration.Seconds()
+	}
+	var exitErr *backend.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		entry.ExitCode = &exitErr.Code
+	case err != nil:
+		entry.Error = err.Error()
+	case !opts.Detach:
+		entry.ExitCode = new(int)
+	}
+	_ = journal.Append(redactEntry(entry, slices.Concat(secretValues, redactValues(envLog, cfg.EnvRedact))))
+
+	// Recap a session that ran to the end, before the copies of ephemeral
+	// mounts holding the tool's logs are removed
+	if !opts.Detach && (err == nil || exitErr != nil) {
+		// Isolated directories come first, as they can be under the copies
+		// of ephemeral mounts, and the tool's logs may be in them
+		cli.LogTo(stderr, "Session: %s", sessionSummary(opts.ToolDef, rc.cwd, rc.head, sessionStart, slices.Concat(isolated, mountsMapped)))
+	}
+	for _, limit := range limits.Hit() {
+		cli.LogWarningTo(stderr, "%s", limitHint(limit, backendType, rc.resources))
+	}
+
+	// Copies and the MCP config of a kept session stay in place until it is
+	// removed
+	if !opts.Keep && !opts.Detach {
+		if len(mountsMapped) > 0 {
+			if err := syncBack(mountsMapped, rc.syncBackPaths); err != nil {
+				cli.LogWarningTo(stderr, "%v", err)
+			}
+		}
+		os.RemoveAll(EphemeralDir(containerName))
+	}
+
+	// A detached session hasn't exited yet
+	if !opts.Detach {
+		runExitHooks(rc.postRunHooks, rc.onFailureHooks, rc.cwd, tool, containerName, err, opts.Stdout, stderr)
+	}
+
+	switch {
+	case opts.Detach && err == nil:
+		cli.LogSuccessTo(stderr, "Started %s in the background", containerName)
+		cli.LogDimTo(stderr, "Follow output:  silo logs -f %s", containerName)
+		cli.LogDimTo(stderr, "Attach:         silo attach %s", containerName)
+	case opts.Keep:
+		cli.LogTo(stderr, "Kept container %s, resume with: silo start --attach %s", containerName, containerName)
+	}
+	if recorder != nil {
+		cli.LogDimTo(stderr, "Replay:  silo replay %s", containerName)
+	}
 
 	if err != nil {
 		return fmt.Errorf("run error: %w", err)
@@ -280,133 +632,2017 @@ func Tool(opts Options) error {
 	return nil
 }
 
-// RepoMatch holds a matched repo pattern name and its associated config.
-type RepoMatch struct {
-	Name   string
-	Config config.RepoConfig
+// runConfig is the configuration of a run resolved from the config, the
+// directory, and its git repository, before any backend is involved.
+type runConfig struct {
+	home, cwd     string
+	remoteURLs    []string
+	worktreeRoots []string
+	workspaces    []string // extra workspaces, see resolveWorkspaces
+	head, branch  string
+	repoMatches   []RepoMatch
+
+	gitName, gitEmail string
+
+	toolPreRunHooks, toolPostBuildHooks []string
+	repoPreRunHooks, repoPostBuildHooks []string
+	matchedRepoNames                    []string
+
+	// Hooks run on the host after the container exits, global, tool, and
+	// repo ones in that order
+	postRunHooks, onFailureHooks []string
+
+	img       image
+	resources backend.Resources
+	network   backend.Network
+	profile   string
+	ports     []backend.Port
+	tmpfs     []string
+	volumes   []backend.Mount
+	services  []backend.Service
+	menuKey   byte
+
+	notifyIdle time.Duration // see resolveNotify, used when notify is enabled
+	stop       backend.StopPolicy
+
+	ephemeralMounts, syncBackPaths []string
+	isolatePaths                   []string // see resolveIsolate
+	isolateDir                     string   // where the repository's isolated paths are kept
+	secretDefs                     map[string]config.Secret
+	serviceEnv                     []string // where the services are, see resolveServices
+
+	containerBase  string        // base of the container name, see containerBaseName
+	dockerInDocker string        // backend.DockerInDocker mode, "" for none
+	selinuxLabel   string        // backend.SELinuxLabel value, "" for none
+	userns         string        // backend.Userns value, see resolveUserns
+	uid            int           // the image user's uid if it isn't the host user's
+	sshAgent       string        // host SSH agent socket to forward, "" for none
+	readOnlyRootfs bool          // see resolveReadOnlyRootfs
+	seccomp        string        // see resolveSeccomp
+	appArmor       string        // AppArmor profile, "" for the runtime's default
+	signing        commitSigning // how commits are signed, see prepareCommitSigning
+	command        []string      // the tool's command line, see toolCommand
+	instructions   instructions
+	mcp            mcp
 }
 
-// GetMatchingRepos returns repo matches (name + config) for repos whose pattern
-// matches any of the git remote URLs, sorted by pattern length (shortest first)
-// so more specific configs are applied last. This is a convenience wrapper that
-// fetches remote URLs internally; use matchRepos to supply pre-fetched URLs.
-func GetMatchingRepos(cfg config.Config, cwd string) []RepoMatch {
-	return matchRepos(cfg, git.GetGitRemoteURLs(cwd))
-}
+// resolveRun resolves the configuration of a run of opts.
+func resolveRun(opts Options, logSection func(string, ...any)) (runConfig, error) {
+	tool := opts.ToolDef.Name
+	cfg := opts.Config
 
-// matchRepos returns repo matches for pre-fetched remote URLs.
-func matchRepos(cfg config.Config, remoteURLs []string) []RepoMatch {
-	if len(remoteURLs) == 0 {
-		return nil
+	// Get current user info
+	hostUser, err := ResolveUser("", "")
+	if err != nil {
+		return runConfig{}, err
+	}
+	home := hostUser.Home
+	cwd := opts.Dir
+	if cwd == "" {
+		cwd, _ = os.Getwd()
+	}
+
+	// Pre-fetch git data concurrently to avoid sequential subprocess calls
+	var remoteURLs []string
+	var worktreeRoots []string
+	var gitName, gitEmail, head, branch string
+	var gitWg sync.WaitGroup
+	gitWg.Add(3)
+	go func() {
+		defer gitWg.Done()
+		remoteURLs = git.GetGitRemoteURLs(cwd)
+		head = git.Head(cwd)
+		branch = git.Branch(cwd)
+	}()
+	go func() {
+		defer gitWg.Done()
+		worktreeRoots, _ = git.GetGitWorktreeRoots(cwd)
+	}()
+	go func() {
+		defer gitWg.Done()
+		gitName, gitEmail = git.GetGitIdentity()
+	}()
+	gitWg.Wait()
+	repoMatches, sandboxProfile := withConfigProfile(cfg, opts.Profile, matchRepos(cfg, remoteURLs))
+	gitName, gitEmail, err = resolveGitIdentity(repoMatches, gitName, gitEmail)
+	if err != nil {
+		return runConfig{}, err
+	}
+
+	workspaces, err := resolveWorkspaces(cfg, repoMatches, cwd)
+	if err != nil {
+		return runConfig{}, err
+	}
+	for _, ws := range workspaces {
+		roots, _ := git.GetGitWorktreeRoots(ws)
+		worktreeRoots = append(worktreeRoots, roots...)
+		logSection("Workspace: also mounting %s", ws)
+	}
+
+	// Get tool-specific hooks
+	var toolPreRunHooks, toolPostBuildHooks []string
+	postRunHooks := slices.Clone(cfg.PostRunHooks)
+	onFailureHooks := slices.Clone(cfg.OnFailureHooks)
+	if toolCfg, ok := cfg.Tools[tool]; ok {
+		toolPreRunHooks = toolCfg.PreRunHooks
+		toolPostBuildHooks = toolCfg.PostBuildHooks
+		postRunHooks = append(postRunHooks, toolCfg.PostRunHooks...)
+		onFailureHooks = append(onFailureHooks, toolCfg.OnFailureHooks...)
 	}
 
-	var matches []RepoMatch
-	for pattern, repoCfg := range cfg.Repos {
-		for _, url := range remoteURLs {
-			if repoURLMatches(url, pattern) {
-				matches = append(matches, RepoMatch{Name: pattern, Config: repoCfg})
-				break // Only add each repo config once
+	// Get repo-specific hooks
+	var repoPreRunHooks, repoPostBuildHooks []string
+	var matchedRepoNames []string
+	for _, m := range repoMatches {
+		matchedRepoNames = append(matchedRepoNames, m.Name)
+		repoPreRunHooks = append(repoPreRunHooks, m.Config.PreRunHooks...)
+		repoPostBuildHooks = append(repoPostBuildHooks, m.Config.PostBuildHooks...)
+		postRunHooks = append(postRunHooks, m.Config.PostRunHooks...)
+		onFailureHooks = append(onFailureHooks, m.Config.OnFailureHooks...)
+	}
+
+	imgUser, err := imageUser(hostUser, cfg.ContainerUser)
+	if err != nil {
+		return runConfig{}, err
+	}
+	var uid int
+	if imgUser.UID != hostUser.UID {
+		uid = imgUser.UID
+		logSection("Container user: %s (uid %d)", imgUser.Name, uid)
+	}
+	userns, err := resolveUserns(cfg.Userns)
+	if err != nil {
+		return runConfig{}, err
+	}
+	img, err := resolveImage(opts, repoMatches, imgUser, toolPostBuildHooks, repoPostBuildHooks, logSection)
+	if err != nil {
+		return runConfig{}, err
+	}
+
+	resources, err := resolveResources(tool, cfg, repoMatches)
+	if err != nil {
+		return runConfig{}, err
+	}
+
+	network, err := resolveNetwork(tool, cfg, repoMatches)
+	if err != nil {
+		return runConfig{}, err
+	}
+
+	selinuxLabel, err := resolveSELinuxLabel(cfg.SELinuxLabel)
+	if err != nil {
+		return runConfig{}, err
+	}
+
+	profile, err := resolveProfile(sandboxProfile, cfg, repoMatches)
+	if err != nil {
+		return runConfig{}, err
+	}
+	network = profileNetwork(profile, network)
+
+	ports, err := resolvePorts(tool, cfg, repoMatches, network)
+	if err != nil {
+		return runConfig{}, err
+	}
+
+	tmpfs, err := resolveTmpfs(tool, cfg, repoMatches, home)
+	if err != nil {
+		return runConfig{}, err
+	}
+	volumes, err := resolveCacheVolumes(tool, cfg, repoMatches, home)
+	if err != nil {
+		return runConfig{}, err
+	}
+
+	// Strict sessions have no network to reach services on
+	var services []backend.Service
+	var serviceEnv []string
+	if profile == ProfileStrict {
+		if len(cfg.Services) > 0 || slices.ContainsFunc(repoMatches, func(rm RepoMatch) bool { return len(rm.Config.Services) > 0 }) {
+			logSection("Profile strict: services aren't started")
+		}
+	} else {
+		if services, serviceEnv, err = resolveServices(cfg, repoMatches, network); err != nil {
+			return runConfig{}, err
+		}
+		for _, svc := range services {
+			logSection("Service: %s (%s)", svc.Name, svc.Image)
+		}
+	}
+
+	menuKey, err := termproxy.ParseKey(cfg.QuickActionsKey)
+	if err != nil {
+		return runConfig{}, fmt.Errorf("invalid quick_actions_key: %w", err)
+	}
+
+	var notifyIdle time.Duration
+	if cfg.Notify.Enabled {
+		if notifyIdle, err = resolveNotify(cfg.Notify); err != nil {
+			return runConfig{}, err
+		}
+	}
+
+	stop, err := resolveStop(cfg.Stop)
+	if err != nil {
+		return runConfig{}, err
+	}
+
+	ephemeralMounts, syncBackPaths, err := resolveEphemeral(tool, cfg)
+	if err != nil {
+		return runConfig{}, err
+	}
+	isolatePaths, err := resolveIsolate(tool, cfg)
+	if err != nil {
+		return runConfig{}, err
+	}
+	isolateRoot := cmp.Or(git.MainRoot(cwd), cwd)
+
+	secretDefs := resolveSecrets(tool, cfg, repoMatches)
+
+	// Signing with an SSH key needs the agent holding it
+	signingMode, err := resolveCommitSigning(cfg, repoMatches)
+	if err != nil {
+		return runConfig{}, err
+	}
+	var signing commitSigning
+	if profile != ProfileStrict && signingMode != SigningOff {
+		if signing, err = prepareCommitSigning(signingMode); err != nil {
+			return runConfig{}, err
+		}
+		logSection("Commit signing: %s", signingMode)
+	}
+
+	var sshAgent string
+	if profile != ProfileStrict && (resolveSSHAgent(cfg, repoMatches) || signingMode == SigningSSH) {
+		if sshAgent = os.Getenv("SSH_AUTH_SOCK"); sshAgent != "" {
+			logSection("SSH agent: forwarding %s", sshAgent)
+		} else {
+			logSection("SSH agent: SSH_AUTH_SOCK is not set, no agent to forward")
+		}
+	}
+
+	dockerInDocker := profileDockerInDocker(profile, resolveDockerInDocker(cfg, repoMatches))
+	readOnlyRootfs := resolveReadOnlyRootfs(cfg, repoMatches)
+	if readOnlyRootfs && dockerInDocker != "" {
+		return runConfig{}, errors.New("read_only_rootfs can't be combined with docker_in_docker, as the Docker daemon writes to the root filesystem")
+	}
+	seccomp, err := resolveSeccomp(cfg, repoMatches)
+	if err != nil {
+		return runConfig{}, err
+	}
+	appArmor := resolveAppArmor(cfg, repoMatches)
+
+	containerBase, err := containerBaseName(cfg.ContainerName, templateVars(cwd, remoteURLs, branch, tool, hostUser.Name))
+	if err != nil {
+		return runConfig{}, err
+	}
+
+	instructions := resolveInstructions(opts.ToolDef, cwd)
+	if len(instructions.included) > 0 {
+		if opts.ToolDef.Instructions.Include != nil {
+			logSection("Instructions: passing %s to %s", strings.Join(instructions.included, ", "), tool)
+		} else {
+			logSection("Instructions: %s can't read %s", tool, strings.Join(instructions.included, ", "))
+		}
+	}
+
+	mcp, err := resolveMCP(cfg, repoMatches, home)
+	if err != nil {
+		return runConfig{}, err
+	}
+	if len(mcp.servers) > 0 {
+		if opts.ToolDef.MCP != nil {
+			logSection("MCP servers: giving %s %s", tool, strings.Join(mcp.servers, ", "))
+		} else {
+			logSection("MCP servers: %s can't be given %s", tool, strings.Join(mcp.servers, ", "))
+		}
+	}
+
+	return runConfig{
+		home:               home,
+		cwd:                cwd,
+		remoteURLs:         remoteURLs,
+		worktreeRoots:      worktreeRoots,
+		workspaces:         workspaces,
+		head:               head,
+		branch:             branch,
+		repoMatches:        repoMatches,
+		gitName:            gitName,
+		gitEmail:           gitEmail,
+		toolPreRunHooks:    toolPreRunHooks,
+		toolPostBuildHooks: toolPostBuildHooks,
+		repoPreRunHooks:    repoPreRunHooks,
+		repoPostBuildHooks: repoPostBuildHooks,
+		matchedRepoNames:   matchedRepoNames,
+		postRunHooks:       postRunHooks,
+		onFailureHooks:     onFailureHooks,
+		img:                img,
+		resources:          resources,
+		network:            network,
+		profile:            profile,
+		ports:              ports,
+		tmpfs:              tmpfs,
+		volumes:            volumes,
+		services:           services,
+		serviceEnv:         serviceEnv,
+		menuKey:            menuKey,
+		notifyIdle:         notifyIdle,
+		stop:               stop,
+		ephemeralMounts:    ephemeralMounts,
+		syncBackPaths:      syncBackPaths,
+		isolatePaths:       isolatePaths,
+		isolateDir:         IsolatedDir(tool, isolateRoot),
+		secretDefs:         secretDefs,
+		containerBase:      containerBase,
+		dockerInDocker:     dockerInDocker,
+		readOnlyRootfs:     readOnlyRootfs,
+		seccomp:            seccomp,
+		appArmor:           appArmor,
+		selinuxLabel:       selinuxLabel,
+		userns:             userns,
+		uid:                uid,
+		sshAgent:           sshAgent,
+		signing:            signing,
+		command:            slices.Concat(toolCommand(opts.ToolDef.Command(home), opts.ToolDef.PermissionFlags, cfg.Tools[tool]), instructions.args),
+		instructions:       instructions,
+		mcp:                mcp,
+	}, nil
+}
+
+// repoRoots returns the directories mounted read-write alongside the
+// current directory: the extra workspaces and the git worktree roots.
+func (rc runConfig) repoRoots() []string {
+	return slices.Concat(rc.workspaces, rc.worktreeRoots)
+}
+
+// resolveWorkspaces returns the extra workspaces mounted read-write
+// alongside cwd: the global extra_workspaces followed by those of matching
+// repos, without duplicates or cwd itself. Relative paths are relative to
+// cwd, and each must be a directory.
+func resolveWorkspaces(cfg config.Config, repoMatches []RepoMatch, cwd string) ([]string, error) {
+	paths := slices.Clone(cfg.ExtraWorkspaces)
+	for _, rm := range repoMatches {
+		paths = append(paths, rm.Config.ExtraWorkspaces...)
+	}
+	var workspaces []string
+	for _, p := range paths {
+		p = expandPath(p)
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(cwd, p)
+		}
+		p = filepath.Clean(p)
+		if fi, err := os.Stat(p); err != nil || !fi.IsDir() {
+			return nil, fmt.Errorf("invalid extra workspace: %s is not a directory", p)
+		}
+		if p != cwd && !slices.Contains(workspaces, p) {
+			workspaces = append(workspaces, p)
+		}
+	}
+	return workspaces, nil
+}
+
+// toolCommand returns the command line of a tool from its default command,
+// the program followed by the flags silo runs it with, and its config: the
+// permissionFlags among them are dropped if default_flags is false, and
+// args are added.
+func toolCommand(command, permissionFlags []string, toolCfg config.ToolConfig) []string {
+	if toolCfg.DefaultFlags != nil && !*toolCfg.DefaultFlags {
+		command = slices.DeleteFunc(slices.Clone(command), func(arg string) bool {
+			return slices.Contains(permissionFlags, arg)
+		})
+	}
+	return slices.Concat(command, toolCfg.Args)
+}
+
+// TemplateVars returns the variables of templated config strings for a run
+// of tool by user in dir.
+func TemplateVars(dir, tool, user string) tmpl.Vars {
+	return templateVars(dir, git.GetGitRemoteURLs(dir), git.Branch(dir), tool, user)
+}
+
+func templateVars(dir string, remoteURLs []string, branch, tool, user string) tmpl.Vars {
+	vars := tmpl.Vars{Dir: filepath.Base(dir), Path: dir, Branch: branch, Tool: tool, User: user, Time: time.Now()}
+	if len(remoteURLs) > 0 {
+		vars.Repo = tmpl.RepoName(remoteURLs[0])
+	}
+	return vars
+}
+
+// containerBaseName returns the base of the names of containers, from the
+// container_name template if set and otherwise the directory name.
+func containerBaseName(template string, vars tmpl.Vars) (string, error) {
+	if template == "" {
+		return backend.ContainerBaseName(vars.Dir), nil
+	}
+	name, err := tmpl.Render("container_name", template, vars)
+	if err != nil {
+		return "", err
+	}
+	return backend.ContainerBaseName(name), nil
+}
+
+// runLabels returns the labels of the container of a run of opts, which
+// record the silo that created it.
+func runLabels(opts Options) map[string]string {
+	labels := maps.Clone(opts.Labels)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[backend.LabelVersion] = opts.Version
+	labels[backend.LabelScriptVersion] = mountwait.ScriptVersion
+	return labels
+}
+
+// newSessionID returns a random ID for a session, which tells its
+// container apart from others that had the same name.
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// EphemeralDir returns the directory holding the copies of the ephemeral
+// mounts of the named container.
+func EphemeralDir(containerName string) string {
+	return statedir.Path("ephemeral", containerName)
+}
+
+// resolveEphemeral returns the tool's read-write mounts to copy for each
+// session, if the tool is ephemeral, and the paths to sync back from the
+// copies when the session ends.
+func resolveEphemeral(tool string, cfg config.Config) (mounts, syncBack []string, err error) {
+	toolCfg := cfg.Tools[tool]
+	if !toolCfg.Ephemeral {
+		return nil, nil, nil
+	}
+	for _, m := range toolCfg.MountsRW {
+		mounts = append(mounts, expandPath(m))
+	}
+	for _, p := range toolCfg.SyncBack {
+		p = expandPath(p)
+		if !slices.ContainsFunc(mounts, func(m string) bool { return isWithin(p, m) }) {
+			return nil, nil, fmt.Errorf("invalid tools.%s.sync_back: %s is not under one of the tool's mounts_rw", tool, p)
+		}
+		syncBack = append(syncBack, p)
+	}
+	return mounts, syncBack, nil
+}
+
+// IsolatedDir returns the directory holding the tool's isolated paths for
+// the repository at root, named after it to be recognizable.
+func IsolatedDir(tool, root string) string {
+	sum := sha256.Sum256([]byte(root))
+	return statedir.Path("isolated", tool, fmt.Sprintf("%s-%x", filepath.Base(root), sum[:4]))
+}
+
+// resolveIsolate returns the tool's paths to keep apart per repository,
+// which must be under its read-write mounts.
+func resolveIsolate(tool string, cfg config.Config) ([]string, error) {
+	toolCfg := cfg.Tools[tool]
+	var mounts, paths []string
+	for _, m := range toolCfg.MountsRW {
+		mounts = append(mounts, expandPath(m))
+	}
+	for _, p := range toolCfg.Isolate {
+		p = expandPath(p)
+		if !slices.ContainsFunc(mounts, func(m string) bool { return isWithin(p, m) }) {
+			return nil, fmt.Errorf("invalid tools.%s.isolate: %s is not under one of the tool's mounts_rw", tool, p)
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// isolatedMounts returns mounts of the directories in dir replacing each
+// path.
+func isolatedMounts(dir string, paths []string) []backend.Mount {
+	var mapped []backend.Mount
+	for _, p := range paths {
+		mapped = append(mapped, backend.Mount{Source: filepath.Join(dir, p), Target: p})
+	}
+	return mapped
+}
+
+// stageIsolated creates the directories in dir replacing each path, keeping
+// those of earlier sessions, and returns mounts of them.
+func stageIsolated(dir string, paths []string) ([]backend.Mount, error) {
+	mapped := isolatedMounts(dir, paths)
+	for _, m := range mapped {
+		if info, err := os.Stat(m.Target); err == nil && !info.IsDir() {
+			return nil, fmt.Errorf("can't isolate %s, only directories can be isolated", m.Target)
+		}
+		if err := os.MkdirAll(m.Source, 0o700); err != nil {
+			return nil, err
+		}
+	}
+	return mapped, nil
+}
+
+// stageEphemeral copies each mount into dir, replacing anything there, and
+// returns mounts of the copies at the original paths. Mounts that don't
+// exist are skipped.
+func stageEphemeral(dir string, mounts []string) ([]backend.Mount, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	mapped := ephemeralCopies(dir, mounts)
+	for _, m := range mapped {
+		if err := os.MkdirAll(filepath.Dir(m.Source), 0o700); err != nil {
+			return nil, err
+		}
+		if err := fileutil.Copy(m.Target, m.Source); err != nil {
+			return nil, fmt.Errorf("failed to copy %s: %w", m.Target, err)
+		}
+	}
+	return mapped, nil
+}
+
+// ephemeralCopies returns mounts of the copies in dir of each mount at the
+// original paths. Mounts that don't exist are skipped.
+func ephemeralCopies(dir string, mounts []string) []backend.Mount {
+	var mapped []backend.Mount
+	for i, m := range mounts {
+		if _, err := os.Lstat(m); err != nil {
+			continue
+		}
+		// Copies keep their base name, which file mounts on the container
+		// backend rely on
+		copyPath := filepath.Join(dir, strconv.Itoa(i), filepath.Base(m))
+		mapped = append(mapped, backend.Mount{Source: copyPath, Target: m})
+	}
+	return mapped
+}
+
+// syncBack copies each path from the copy of the mount it is under back to
+// the host. Paths that don't exist in the copy are skipped.
+func syncBack(mapped []backend.Mount, paths []string) error {
+	var errs []error
+	for _, p := range paths {
+		for _, m := range mapped {
+			if !isWithin(p, m.Target) {
+				continue
+			}
+			rel, _ := filepath.Rel(m.Target, p)
+			src := filepath.Join(m.Source, rel)
+			if _, err := os.Lstat(src); err != nil {
+				break
+			}
+			if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+				errs = append(errs, fmt.Errorf("failed to sync back %s: %w", p, err))
+				break
+			}
+			if err := fileutil.Copy(src, p); err != nil {
+				errs = append(errs, fmt.Errorf("failed to sync back %s: %w", p, err))
+			}
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// isWithin reports whether path is dir or inside it.
+func isWithin(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// aptSnapshotLayout is the time format of Ubuntu archive snapshot IDs.
+const aptSnapshotLayout = "20060102T150405Z"
+
+// aptSnapshot returns the time of the Ubuntu archive snapshot reproducible
+// builds install from: the configured snapshot, or the start of now's month
+// in UTC so builds on different machines in the same month match.
+func aptSnapshot(configured string, now time.Time) (time.Time, error) {
+	if configured == "" {
+		now = now.UTC()
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+	}
+	t, err := time.Parse(aptSnapshotLayout, configured)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid apt_snapshot: %q (e.g. 20261001T000000Z)", configured)
+	}
+	return t, nil
+}
+
+// envNames returns the names of the NAME=value environment variables.
+func envNames(envVars []string) []string {
+	names := make([]string, 0, len(envVars))
+	for _, e := range envVars {
+		name, _, _ := strings.Cut(e, "=")
+		names = append(names, name)
+	}
+	return names
+}
+
+// TemplateHash returns a short hash of the embedded Dockerfile template.
+func TemplateHash(dockerfile string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(dockerfile)))[:12]
+}
+
+// PrebuiltImage returns the reference of the prebuilt base image for the
+// Dockerfile template in registry, or "" if registry is "" or "none". The
+// image is the template's base stage, tagged with PrebuiltTag.
+func PrebuiltImage(registry, template string) string {
+	if registry == "" || registry == "none" {
+		return ""
+	}
+	return registry + ":" + PrebuiltTag(template)
+}
+
+// PrebuiltTag returns the tag the prebuilt base image for the Dockerfile
+// template is published under.
+func PrebuiltTag(template string) string {
+	return "base-" + TemplateHash(template)
+}
+
+// updateNotice returns a one-line notice when the last run of tool used a
+// different Dockerfile template, meaning a newer silo shipped an updated
+// environment. It returns "" when there is nothing to report, including on
+// the first run or when the last run predates template tracking.
+func updateNotice(entries []journal.Entry, tool, templateHash, version string) string {
+	last, ok := journal.Last(entries, tool)
+	if !ok || last.TemplateHash == "" || last.TemplateHash == templateHash {
+		return ""
+	}
+	changelog := "https://github.com/leighmcculloch/silo/commits/main"
+	if version != "" && version != "dev" {
+		changelog = "https://github.com/leighmcculloch/silo/releases/tag/v" + strings.TrimPrefix(version, "v")
+	}
+	return fmt.Sprintf("Environment update available for %s, the image will be rebuilt (changelog: %s)", tool, changelog)
+}
+
+// RepoMatch holds a matched repo pattern name and its associated config.
+type RepoMatch struct {
+	Name   string
+	Config config.RepoConfig
+}
+
+// GetMatchingRepos returns repo matches (name + config) for repos whose pattern
+// matches any of the git remote URLs, sorted by pattern length (shortest first)
+// so more specific configs are applied last. This is a convenience wrapper that
+// fetches remote URLs internally; use matchRepos to supply pre-fetched URLs.
+func GetMatchingRepos(cfg config.Config, cwd string) []RepoMatch {
+	return matchRepos(cfg, git.GetGitRemoteURLs(cwd))
+}
+
+// matchRepos returns repo matches for pre-fetched remote URLs.
+func matchRepos(cfg config.Config, remoteURLs []string) []RepoMatch {
+	if len(remoteURLs) == 0 {
+		return nil
+	}
+
+	var matches []RepoMatch
+	for pattern, repoCfg := range cfg.Repos {
+		for _, url := range remoteURLs {
+			if repoURLMatches(url, pattern) {
+				matches = append(matches, RepoMatch{Name: pattern, Config: repoCfg})
+				break // Only add each repo config once
+			}
+		}
+	}
+
+	// Sort by pattern length (shortest first = less specific first)
+	sort.Slice(matches, func(i, j int) bool {
+		return len(matches[i].Name) < len(matches[j].Name)
+	})
+
+	return matches
+}
+
+// withConfigProfile returns repoMatches with the profile of the config named
+// by profile appended, so it applies after the matching repos, and the
+// sandbox profile to use as an override, which is empty when profile names a
+// profile of the config instead of strict, standard, or permissive.
+func withConfigProfile(cfg config.Config, profile string, repoMatches []RepoMatch) ([]RepoMatch, string) {
+	switch profile {
+	case "", ProfileStrict, ProfileStandard, ProfilePermissive:
+		return repoMatches, profile
+	}
+	p, ok := cfg.Profiles[profile]
+	if !ok {
+		return repoMatches, profile
+	}
+	return append(repoMatches, RepoMatch{Name: "profile " + profile, Config: p}), ""
+}
+
+// repoURLMatches checks if a git remote URL matches a pattern.
+// Both the URL and pattern have .git suffix stripped before comparison.
+// The pattern matches if it is a substring of the URL, allowing for prefix matching
+// (e.g., "github.com/stellar" matches "github.com/stellar/stellar-core").
+// SSH URLs (git@host:path) are normalized to host/path format for matching.
+func repoURLMatches(url, pattern string) bool {
+	url = strings.TrimSuffix(url, ".git")
+	pattern = strings.TrimSuffix(pattern, ".git")
+
+	// Normalize SSH URL format (git@github.com:org/repo -> github.com/org/repo)
+	if strings.HasPrefix(url, "git@") {
+		url = strings.TrimPrefix(url, "git@")
+		url = strings.Replace(url, ":", "/", 1)
+	}
+
+	return strings.Contains(url, pattern)
+}
+
+// SelectBackend returns the backend type to use given the configured one,
+// and the reason it was chosen. When none is configured the container backend
+// is preferred on macOS if its CLI is installed, otherwise docker.
+func SelectBackend(configured string) (backendType, reason string) {
+	if configured != "" {
+		return configured, "set by config or --backend"
+	}
+	if runtime.GOOS != "darwin" {
+		return "docker", "the default on " + runtime.GOOS
+	}
+	if _, err := exec.LookPath("container"); err == nil {
+		return "container", "container CLI is installed"
+	}
+	return "docker", "container CLI not found"
+}
+
+// backendCandidates returns the backends to try in order: the selected one
+// followed by the fallbacks, without duplicates.
+func backendCandidates(selected string, fallback []string) []string {
+	candidates := []string{selected}
+	for _, b := range fallback {
+		if !slices.Contains(candidates, b) {
+			candidates = append(candidates, b)
+		}
+	}
+	return candidates
+}
+
+// probeBackend reports whether the backend is installed and reachable.
+func probeBackend(ctx context.Context, backendType string) backend.Info {
+	switch backendType {
+	case "docker":
+		return docker.Probe(ctx)
+	case "container":
+		return applecontainer.Probe(ctx)
+	case "sandbox":
+		return sandbox.Probe(ctx)
+	default:
+		return backend.Info{Detail: fmt.Sprintf("unknown backend: %s (valid: docker, container, sandbox)", backendType)}
+	}
+}
+
+// selectAvailableBackend creates the selected backend, or if it is not
+// available, the first available backend in fallback. Without fallbacks a
+// backend that is installed but not reachable yet, such as a Docker daemon
+// that is starting, is waited for for up to connect; any other error
+// surfaces when it is used.
+func selectAvailableBackend(ctx context.Context, configured string, fallback []string, connect time.Duration, stderr io.Writer, verbose bool, progress *cli.Progress) (backend.Backend, string, error) {
+	selected, reason := SelectBackend(configured)
+	cli.LogDebugTo(stderr, "Selected the %s backend: %s", selected, reason)
+	candidates := backendCandidates(selected, fallback)
+	if len(candidates) == 1 {
+		if connect > 0 {
+			waiting := false
+			probe := func(ctx context.Context) backend.Info { return probeBackend(ctx, selected) }
+			info := waitForBackend(ctx, probe, connect, func(info backend.Info) {
+				cli.LogDebugTo(stderr, "The %s backend isn't reachable, probing it again: %s", selected, info.Detail)
+				if !waiting {
+					if progress != nil {
+						progress.Interrupt()
+					}
+					cli.LogTo(stderr, "Waiting for the %s backend: %s", selected, info.Detail)
+					waiting = true
+				}
+			})
+			if !info.Available && info.Retry {
+				return nil, selected, fmt.Errorf("the %s backend is not available after %s: %s", selected, connect, info.Detail)
+			}
+		}
+		return createBackend(selected, stderr, verbose)
+	}
+
+	var unavailable []string
+	for i, name := range candidates {
+		info := probeBackend(ctx, name)
+		if info.Available {
+			if i > 0 {
+				cli.LogTo(stderr, "Falling back to the %s backend", name)
+			}
+			return createBackend(name, stderr, verbose)
+		}
+		if progress != nil {
+			progress.Interrupt()
+		}
+		cli.LogWarningTo(stderr, "The %s backend is not available: %s", name, info.Detail)
+		unavailable = append(unavailable, name)
+	}
+	return nil, selected, fmt.Errorf("no backend available (tried %s)", strings.Join(unavailable, ", "))
+}
+
+// createBackend creates the appropriate backend based on configuration. It
+// returns the backend along with the resolved backend type.
+func createBackend(backendType string, stderr io.Writer, verbose bool) (backend.Backend, string, error) {
+	backendType, _ = SelectBackend(backendType)
+
+	switch backendType {
+	case "docker":
+		if verbose {
+			cli.LogTo(stderr, "Using docker backend...")
+		}
+		client, err := docker.NewClient()
+		if err != nil {
+			return nil, backendType, fmt.Errorf("failed to connect to Docker: %w", err)
+		}
+		return client, backendType, nil
+	case "container":
+		if verbose {
+			cli.LogTo(stderr, "Using apple container (lightweight vms) backend...")
+		}
+		client, err := applecontainer.NewClient()
+		if err != nil {
+			return nil, backendType, fmt.Errorf("failed to initialize container backend: %w", err)
+		}
+		return client, backendType, nil
+	case "sandbox":
+		if verbose {
+			cli.LogTo(stderr, "Using sandbox-exec backend (runs on the host, weaker isolation)...")
+		}
+		client, err := sandbox.NewClient()
+		if err != nil {
+			return nil, backendType, fmt.Errorf("failed to initialize sandbox backend: %w", err)
+		}
+		return client, backendType, nil
+	default:
+		return nil, backendType, fmt.Errorf("unknown backend: %s (valid: docker, container, sandbox)", backendType)
+	}
+}
+
+// collectMounts gathers all mount paths from config for a specific tool.
+func collectMounts(tool string, cfg config.Config, cwd string, repoMatches []RepoMatch, repoRoots []string) (mountsRO, mountsRW []string) {
+	mountsRW = []string{cwd}
+
+	// Add tool-specific mounts
+	if toolCfg, ok := cfg.Tools[tool]; ok {
+		for _, m := range toolCfg.MountsRO {
+			mountsRO = append(mountsRO, expandPath(m))
+		}
+		for _, m := range toolCfg.MountsRW {
+			mountsRW = append(mountsRW, expandPath(m))
+		}
+	}
+
+	// Add repo-specific mounts
+	for _, rm := range repoMatches {
+		for _, m := range rm.Config.MountsRO {
+			mountsRO = append(mountsRO, expandPath(m))
+		}
+		for _, m := range rm.Config.MountsRW {
+			mountsRW = append(mountsRW, expandPath(m))
+		}
+	}
+
+	// Add global config mounts
+	for _, m := range cfg.MountsRO {
+		mountsRO = append(mountsRO, expandPath(m))
+	}
+	for _, m := range cfg.MountsRW {
+		mountsRW = append(mountsRW, expandPath(m))
+	}
+
+	// Add extra workspaces and git worktree roots (read-write for git
+	// operations)
+	mountsRW = append(mountsRW, repoRoots...)
+
+	return mountsRO, mountsRW
+}
+
+// resolveResources merges resource limits from global, tool, and repo config
+// (in increasing precedence) and converts them to backend resources.
+func resolveResources(tool string, cfg config.Config, repoMatches []RepoMatch) (backend.Resources, error) {
+	r := cfg.Resources
+	if toolCfg, ok := cfg.Tools[tool]; ok {
+		r = config.MergeResources(r, toolCfg.Resources)
+	}
+	for _, rm := range repoMatches {
+		r = config.MergeResources(r, rm.Config.Resources)
+	}
+
+	if r.CPUs < 0 {
+		return backend.Resources{}, fmt.Errorf("invalid resources.cpus: %g", r.CPUs)
+	}
+	if r.PidsLimit < 0 {
+		return backend.Resources{}, fmt.Errorf("invalid resources.pids_limit: %d", r.PidsLimit)
+	}
+	if r.Nofile < 0 {
+		return backend.Resources{}, fmt.Errorf("invalid resources.nofile: %d", r.Nofile)
+	}
+	if r.InotifyWatches < 0 {
+		return backend.Resources{}, fmt.Errorf("invalid resources.inotify_watches: %d", r.InotifyWatches)
+	}
+	memory, err := parseMemory(r.Memory)
+	if err != nil {
+		return backend.Resources{}, fmt.Errorf("invalid resources.memory: %w", err)
+	}
+	swap, err := parseMemory(r.Swap)
+	if err != nil {
+		return backend.Resources{}, fmt.Errorf("invalid resources.swap: %w", err)
+	}
+	// Docker only limits swap along with memory
+	if swap > 0 && memory == 0 {
+		return backend.Resources{}, fmt.Errorf("resources.swap %s needs resources.memory to be set", r.Swap)
+	}
+
+	return backend.Resources{
+		CPUs:           r.CPUs,
+		MemoryBytes:    memory,
+		SwapBytes:      swap,
+		PidsLimit:      r.PidsLimit,
+		Nofile:         r.Nofile,
+		InotifyWatches: cmp.Or(r.InotifyWatches, defaultInotifyWatches),
+	}, nil
+}
+
+// defaultInotifyWatches is raised from the usual 8192 by default, as dev
+// servers' file watchers often run out. The open files limit is left to
+// the backend, as a higher hard limit than the daemon's fails to create
+// the container.
+const defaultInotifyWatches = 1 << 19
+
+// The identity used for commits in repos with git_identity.anonymous set.
+const (
+	anonymousGitName  = "Anonymous"
+	anonymousGitEmail = "anonymous@localhost"
+)
+
+// resolveGitIdentity returns the git identity for commits in the container:
+// the host's, unless a matching repo sets git_identity. An identity that
+// replaces the host's must have both a name and an email, so commits never
+// mix the two.
+func resolveGitIdentity(repoMatches []RepoMatch, hostName, hostEmail string) (name, email string, err error) {
+	var id config.GitIdentity
+	for _, rm := range repoMatches {
+		id = config.MergeGitIdentity(id, rm.Config.GitIdentity)
+	}
+	if id.Anonymous {
+		return cmp.Or(id.Name, anonymousGitName), cmp.Or(id.Email, anonymousGitEmail), nil
+	}
+	switch {
+	case id.Name == "" && id.Email == "":
+		return hostName, hostEmail, nil
+	case id.Name == "" || id.Email == "":
+		return "", "", fmt.Errorf("git_identity needs both a name and an email, or anonymous")
+	}
+	return id.Name, id.Email, nil
+}
+
+// Sandbox profiles, named bundles of network, mount, and daemon settings.
+const (
+	ProfileStrict     = "strict"     // no network, read-only repository, scratch directory
+	ProfileStandard   = "standard"   // the configured settings (default)
+	ProfilePermissive = "permissive" // full network and Docker where the backend supports it
+)
+
+// platformRegex matches the platforms images can be built for.
+var platformRegex = regexp.MustCompile(`^linux/[a-z0-9]+(/[a-z0-9]+)?$`)
+
+// resolvePlatform returns the platform to build and run tool for: override
+// if set, else the tool's platform, else the global one. "" is the
+// backend's native platform.
+func resolvePlatform(override, tool string, cfg config.Config) (string, error) {
+	platform := cfg.Platform
+	if toolCfg, ok := cfg.Tools[tool]; ok && toolCfg.Platform != "" {
+		platform = toolCfg.Platform
+	}
+	if override != "" {
+		platform = override
+	}
+	if platform != "" && !platformRegex.MatchString(platform) {
+		return "", fmt.Errorf("invalid platform: %q (must be linux/<arch>, e.g. linux/amd64)", platform)
+	}
+	return platform, nil
+}
+
+// warnEmulation warns when the image tag is for a different CPU
+// architecture than this machine, as it then runs under emulation.
+func warnEmulation(ctx context.Context, b backend.Backend, backendType, tool, tag string, stderr io.Writer, progress *cli.Progress) {
+	arch, err := b.ImageArch(ctx, tag)
+	if err != nil || arch == "" || arch == runtime.GOARCH {
+		return
+	}
+	if progress != nil {
+		progress.Interrupt()
+	}
+	cli.LogWarningTo(stderr, "The %s image is linux/%s but this machine is %s: it runs under emulation, typically several times slower", tool, arch, runtime.GOARCH)
+	cli.LogTo(stderr, "%s", emulationHint(backendType, runtime.GOOS, runtime.GOARCH))
+}
+
+// emulationHint suggests how to run an image for another architecture
+// faster, or natively, on a backend and host.
+func emulationHint(backendType, goos, hostArch string) string {
+	native := fmt.Sprintf(`To run natively, set "platform": "linux/%s" or pass --platform linux/%s, if the tool and base image support it.`, hostArch, hostArch)
+	switch {
+	case backendType == "container":
+		return "The container backend emulates amd64 with Rosetta (install it with: softwareupdate --install-rosetta). " + native
+	case goos == "darwin":
+		return "In Docker Desktop, enable Settings > General > \"Use Rosetta for x86_64/amd64 emulation on Apple Silicon\", which is faster than QEMU. " + native
+	default:
+		return "Docker emulates other architectures with QEMU (install it with: docker run --privileged --rm tonistiigi/binfmt --install all). " + native
+	}
+}
+
+// scratchTarget is where the scratch directory of the strict profile is
+// mounted in the container.
+const scratchTarget = "/scratch"
+
+// resolveProfile returns the sandbox profile: override if set, else the
+// global profile overridden by matching repos.
+func resolveProfile(override string, cfg config.Config, repoMatches []RepoMatch) (string, error) {
+	profile := cfg.Profile
+	for _, rm := range repoMatches {
+		if rm.Config.Profile != "" {
+			profile = rm.Config.Profile
+		}
+	}
+	if override != "" {
+		profile = override
+	}
+	switch profile {
+	case "":
+		return ProfileStandard, nil
+	case ProfileStrict, ProfileStandard, ProfilePermissive:
+		return profile, nil
+	}
+	if len(cfg.Profiles) > 0 {
+		names := slices.Sorted(maps.Keys(cfg.Profiles))
+		return "", fmt.Errorf("invalid profile: %q (must be strict, standard, permissive, or a config profile: %s)", profile, strings.Join(names, ", "))
+	}
+	return "", fmt.Errorf("invalid profile: %q (must be strict, standard, or permissive)", profile)
+}
+
+// resolveDockerInDocker returns the backend.DockerInDocker mode from the
+// global docker_in_docker overridden by matching repos, or "" if Docker
+// doesn't run in the container.
+func resolveDockerInDocker(cfg config.Config, repoMatches []RepoMatch) string {
+	d := cfg.DockerInDocker
+	for _, rm := range repoMatches {
+		if rm.Config.DockerInDocker != "" {
+			d = rm.Config.DockerInDocker
+		}
+	}
+	switch d {
+	case config.DockerInDockerRootful:
+		return backend.DockerInDockerRootful
+	case config.DockerInDockerRootless:
+		return backend.DockerInDockerRootless
+	}
+	return ""
+}
+
+// resolveSSHAgent reports whether the host's SSH agent is forwarded: the
+// global ssh_agent overridden by matching repos, off by default.
+func resolveSSHAgent(cfg config.Config, repoMatches []RepoMatch) bool {
+	on := cfg.SSHAgent != nil && *cfg.SSHAgent
+	for _, rm := range repoMatches {
+		if rm.Config.SSHAgent != nil {
+			on = *rm.Config.SSHAgent
+		}
+	}
+	return on
+}
+
+// resolveReadOnlyRootfs reports whether the container's root filesystem is
+// read-only: the global read_only_rootfs overridden by matching repos, off
+// by default.
+func resolveReadOnlyRootfs(cfg config.Config, repoMatches []RepoMatch) bool {
+	on := cfg.ReadOnlyRootfs != nil && *cfg.ReadOnlyRootfs
+	for _, rm := range repoMatches {
+		if rm.Config.ReadOnlyRootfs != nil {
+			on = *rm.Config.ReadOnlyRootfs
+		}
+	}
+	return on
+}
+
+// runTmpfs returns the tmpfs mounts of a run on backendType. A read-only
+// root filesystem gets tmpfs where programs expect to write, except in the
+// sandbox, which only lets the tool write to its mounts and temporary
+// directories anyway.
+func runTmpfs(rc runConfig, backendType string) []string {
+	if !rc.readOnlyRootfs || backendType == "sandbox" {
+		return rc.tmpfs
+	}
+	tmpfs := slices.Clone(rc.tmpfs)
+	for _, p := range []string{"/tmp", "/var/tmp", rc.home + "/.cache", rc.home + "/.npm"} {
+		if !slices.Contains(tmpfs, p) {
+			tmpfs = append(tmpfs, p)
+		}
+	}
+	return tmpfs
+}
+
+// Seccomp profile settings, other than the path of a profile.
+const (
+	SeccompSilo       = "silo"       // silo's profile, backend.SeccompProfile
+	SeccompDefault    = "default"    // the container runtime's default profile
+	SeccompUnconfined = "unconfined" // no profile
+)
+
+// resolveSeccomp returns the seccomp profile setting: the global
+// seccomp_profile overridden by matching repos, silo's profile by default.
+// A profile path must exist.
+func resolveSeccomp(cfg config.Config, repoMatches []RepoMatch) (string, error) {
+	profile := cfg.SeccompProfile
+	for _, rm := range repoMatches {
+		if rm.Config.SeccompProfile != "" {
+			profile = rm.Config.SeccompProfile
+		}
+	}
+	switch profile {
+	case "":
+		return SeccompSilo, nil
+	case SeccompSilo, SeccompDefault, SeccompUnconfined:
+		return profile, nil
+	}
+	path := expandPath(profile)
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("invalid seccomp_profile: %q (must be silo, default, unconfined, or the absolute path of a profile)", profile)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("invalid seccomp_profile: %w", err)
+	}
+	return path, nil
+}
+
+// resolveAppArmor returns the AppArmor profile: the global
+// apparmor_profile overridden by matching repos, "" for the runtime's
+// default.
+func resolveAppArmor(cfg config.Config, repoMatches []RepoMatch) string {
+	profile := cfg.AppArmorProfile
+	for _, rm := range repoMatches {
+		if rm.Config.AppArmorProfile != "" {
+			profile = rm.Config.AppArmorProfile
+		}
+	}
+	return profile
+}
+
+// securityProfiles returns the backend.RunOptions Seccomp and AppArmor
+// values of a run on backendType. Only the docker backend applies them, and
+// not with docker_in_docker, whose container runs privileged, so profiles
+// configured otherwise are warned about and dropped. Silo's seccomp profile
+// is written to the state directory for Docker to read.
+func securityProfiles(rc runConfig, backendType string, warn func(string, ...any)) (seccomp, appArmor string, err error) {
+	configured := (rc.seccomp != SeccompSilo && rc.seccomp != SeccompDefault) || rc.appArmor != ""
+	switch {
+	case backendType != "docker":
+		if configured {
+			warn("seccomp_profile and apparmor_profile are only applied by the docker backend, not the %s backend", backendType)
+		}
+		return "", "", nil
+	case rc.dockerInDocker != "":
+		if configured {
+			warn("seccomp_profile and apparmor_profile aren't applied with docker_in_docker, which runs the container privileged")
+		}
+		return "", "", nil
+	}
+	switch rc.seccomp {
+	case SeccompDefault:
+	case SeccompSilo:
+		if seccomp, err = writeSeccompProfile(); err != nil {
+			return "", "", err
+		}
+	default:
+		seccomp = rc.seccomp
+	}
+	return seccomp, rc.appArmor, nil
+}
+
+// writeSeccompProfile writes silo's seccomp profile to the state directory,
+// named by its content so a profile in use is never changed, and returns
+// its path.
+func writeSeccompProfile() (string, error) {
+	sum := sha256.Sum256(backend.SeccompProfile)
+	path := statedir.Path("seccomp", fmt.Sprintf("silo-%x.json", sum[:6]))
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", fmt.Errorf("failed to write seccomp profile: %w", err)
+	}
+	if err := fileutil.WriteFile(path, backend.SeccompProfile, 0o600); err != nil {
+		return "", fmt.Errorf("failed to write seccomp profile: %w", err)
+	}
+	return path, nil
+}
+
+// SELinux label settings.
+const (
+	SELinuxShared  = "shared"  // relabel mounts for use by any container (:z)
+	SELinuxPrivate = "private" // relabel mounts for use by this container only (:Z)
+	SELinuxNone    = "none"    // don't relabel mounts
+)
+
+// resolveSELinuxLabel returns the backend.SELinuxLabel value for the
+// selinux_label setting. Unset, mounts aren't relabeled, as relabeling
+// changes the labels of the host's own files, such as those in the home
+// directory, for good; selinuxHint says how to let the container read
+// them when SELinux is enforcing.
+func resolveSELinuxLabel(label string) (string, error) {
+	switch label {
+	case "", SELinuxNone:
+		return "", nil
+	case SELinuxShared:
+		return backend.SELinuxShared, nil
+	case SELinuxPrivate:
+		return backend.SELinuxPrivate, nil
+	}
+	return "", fmt.Errorf("invalid selinux_label: %q (must be %s, %s, or %s)", label, SELinuxShared, SELinuxPrivate, SELinuxNone)
+}
+
+// selinuxHint returns a hint for when mounts on backendType may be
+// unreadable in the container because SELinux is enforcing and selinux_label
+// is unset, or "" if they aren't.
+func selinuxHint(label, backendType string, enforcing bool) string {
+	if label != "" || backendType != "docker" || !enforcing {
+		return ""
+	}
+	return `SELinux is enforcing and mounts aren't relabeled, so the container may not be able to read them. Set selinux_label to "shared" or "private" to relabel them, which changes their labels on the host for good, or to "none" to hide this hint`
+}
+
+// selinuxEnforcing reports whether the host is Linux with SELinux enforcing.
+func selinuxEnforcing() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	b, err := os.ReadFile("/sys/fs/selinux/enforce")
+	return err == nil && strings.TrimSpace(string(b)) == "1"
+}
+
+// profileDockerInDocker returns the Docker in Docker mode for a profile.
+// Strict never runs Docker in the container, though the image has it.
+func profileDockerInDocker(profile, mode string) string {
+	if profile == ProfileStrict {
+		return ""
+	}
+	return mode
+}
+
+// profileNetwork returns the network for a profile. Strict and permissive
+// replace the configured mode.
+func profileNetwork(profile string, n backend.Network) backend.Network {
+	switch profile {
+	case ProfileStrict:
+		return backend.Network{Mode: backend.NetworkNone}
+	case ProfilePermissive:
+		return backend.Network{Mode: backend.NetworkFull, Join: n.Join}
+	}
+	return n
+}
+
+// strictMounts moves the repository mounts, the working directory and git
+// worktree roots, from the read-write to the read-only mounts. Tool and
+// configured read-write mounts stay writable.
+func strictMounts(mountsRO, mountsRW, repo []string) ([]string, []string) {
+	var rw []string
+	for _, m := range mountsRW {
+		if slices.Contains(repo, m) {
+			mountsRO = append(mountsRO, m)
+		} else {
+			rw = append(rw, m)
+		}
+	}
+	return mountsRO, rw
+}
+
+// stageScratch creates an empty scratch directory under dir and returns
+// its mount, so sessions with a read-only repository have somewhere to
+// write. It is removed with the rest of dir.
+func stageScratch(dir string) (backend.Mount, error) {
+	m := scratchMount(dir)
+	if err := os.RemoveAll(m.Source); err != nil {
+		return backend.Mount{}, err
+	}
+	if err := os.MkdirAll(m.Source, 0o700); err != nil {
+		return backend.Mount{}, err
+	}
+	return m, nil
+}
+
+// scratchMount returns the mount of the scratch directory under dir.
+func scratchMount(dir string) backend.Mount {
+	return backend.Mount{Source: filepath.Join(dir, "scratch"), Target: scratchTarget}
+}
+
+// allowHostRegex matches a hostname, optionally prefixed with "*." to match
+// any subdomain.
+var allowHostRegex = regexp.MustCompile(`^(\*\.)?[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)*$`)
+
+// resolveNetwork merges the network mode, allowed hosts, and joined network
+// from global, tool, and repo config. The mode and joined network are
+// replaced in increasing precedence while the allowed hosts accumulate.
+func resolveNetwork(tool string, cfg config.Config, repoMatches []RepoMatch) (backend.Network, error) {
+	n := backend.Network{Mode: cfg.Network, Allow: cfg.NetworkAllow, Join: cfg.NetworkJoin}
+	if toolCfg, ok := cfg.Tools[tool]; ok {
+		if toolCfg.Network != "" {
+			n.Mode = toolCfg.Network
+		}
+		n.Allow = append(n.Allow, toolCfg.NetworkAllow...)
+		if toolCfg.NetworkJoin != "" {
+			n.Join = toolCfg.NetworkJoin
+		}
+	}
+	for _, rm := range repoMatches {
+		if rm.Config.Network != "" {
+			n.Mode = rm.Config.Network
+		}
+		n.Allow = append(n.Allow, rm.Config.NetworkAllow...)
+		if rm.Config.NetworkJoin != "" {
+			n.Join = rm.Config.NetworkJoin
+		}
+	}
+	n.Allow = slices.Compact(slices.Sorted(slices.Values(n.Allow)))
+
+	if n.Join != "" && n.Mode != "" && n.Mode != backend.NetworkFull {
+		return backend.Network{}, fmt.Errorf("network_join requires network %q, got %q", backend.NetworkFull, n.Mode)
+	}
+
+	switch n.Mode {
+	case "", backend.NetworkFull:
+		n.Mode = backend.NetworkFull
+		n.Allow = nil
+	case backend.NetworkNone:
+		n.Allow = nil
+	case backend.NetworkAllowlist:
+		if len(n.Allow) == 0 {
+			return backend.Network{}, fmt.Errorf("network is %q but network_allow is empty", n.Mode)
+		}
+		for _, host := range n.Allow {
+			if !allowHostRegex.MatchString(host) {
+				return backend.Network{}, fmt.Errorf("invalid network_allow host: %q", host)
+			}
+		}
+	default:
+		return backend.Network{}, fmt.Errorf("invalid network: %q (must be full, allowlist, or none)", n.Mode)
+	}
+	return n, nil
+}
+
+// resolvePorts collects the published ports from global, tool, and repo
+// config. Ports can't be published from a container without a route to the
+// host, so they require the full network mode.
+func resolvePorts(tool string, cfg config.Config, repoMatches []RepoMatch, network backend.Network) ([]backend.Port, error) {
+	specs := slices.Clone(cfg.Ports)
+	if toolCfg, ok := cfg.Tools[tool]; ok {
+		specs = append(specs, toolCfg.Ports...)
+	}
+	for _, rm := range repoMatches {
+		specs = append(specs, rm.Config.Ports...)
+	}
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	if network.Mode != backend.NetworkFull {
+		return nil, fmt.Errorf("ports require network %q, got %q", backend.NetworkFull, network.Mode)
+	}
+
+	var ports []backend.Port
+	hostPorts := make(map[string]backend.Port)
+	for _, spec := range specs {
+		p, err := parsePort(spec)
+		if err != nil {
+			return nil, err
+		}
+		key := fmt.Sprintf("%s:%d/%s", p.HostIP, p.HostPort, p.Protocol)
+		if existing, ok := hostPorts[key]; ok {
+			if existing != p {
+				return nil, fmt.Errorf("host port %d/%s is published twice", p.HostPort, p.Protocol)
+			}
+			continue
+		}
+		hostPorts[key] = p
+		ports = append(ports, p)
+	}
+	return ports, nil
+}
+
+// resolveTmpfs collects the tmpfs mount paths from global, tool, and repo
+// config, expanding "~" to home.
+func resolveTmpfs(tool string, cfg config.Config, repoMatches []RepoMatch, home string) ([]string, error) {
+	paths := slices.Clone(cfg.Tmpfs)
+	if toolCfg, ok := cfg.Tools[tool]; ok {
+		paths = append(paths, toolCfg.Tmpfs...)
+	}
+	for _, rm := range repoMatches {
+		paths = append(paths, rm.Config.Tmpfs...)
+	}
+
+	var tmpfs []string
+	for _, p := range paths {
+		if p == "~" || strings.HasPrefix(p, "~/") {
+			p = home + p[1:]
+		}
+		if !filepath.IsAbs(p) {
+			return nil, fmt.Errorf("invalid tmpfs path %q: must be absolute or start with ~/", p)
+		}
+		p = filepath.Clean(p)
+		if p == "/" {
+			return nil, fmt.Errorf("invalid tmpfs path %q", p)
+		}
+		if !slices.Contains(tmpfs, p) {
+			tmpfs = append(tmpfs, p)
+		}
+	}
+	return tmpfs, nil
+}
+
+// cacheVolumeNameRegex matches valid cache_volumes names.
+var cacheVolumeNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// resolveCacheVolumes collects the cache volumes from global, tool, and repo
+// config, later ones replacing earlier ones of the same name, expanding "~"
+// in their paths to home. They are sorted by name.
+func resolveCacheVolumes(tool string, cfg config.Config, repoMatches []RepoMatch, home string) ([]backend.Mount, error) {
+	merged := cfg.CacheVolumes
+	if toolCfg, ok := cfg.Tools[tool]; ok {
+		merged = config.MergeCacheVolumes(merged, toolCfg.CacheVolumes)
+	}
+	for _, rm := range repoMatches {
+		merged = config.MergeCacheVolumes(merged, rm.Config.CacheVolumes)
+	}
+
+	var volumes []backend.Mount
+	targets := make(map[string]string)
+	for _, name := range slices.Sorted(maps.Keys(merged)) {
+		p := merged[name]
+		if !cacheVolumeNameRegex.MatchString(name) {
+			return nil, fmt.Errorf("invalid cache volume name %q: must be letters, digits, '_', '.', or '-'", name)
+		}
+		if p == "~" || strings.HasPrefix(p, "~/") {
+			p = home + p[1:]
+		}
+		if !filepath.IsAbs(p) || filepath.Clean(p) == "/" {
+			return nil, fmt.Errorf("invalid path %q for cache volume %s: must be absolute or start with ~/", merged[name], name)
+		}
+		p = filepath.Clean(p)
+		if other, ok := targets[p]; ok {
+			return nil, fmt.Errorf("cache volumes %s and %s are both mounted at %s", other, name, p)
+		}
+		targets[p] = name
+		volumes = append(volumes, backend.Mount{Source: backend.CacheVolumePrefix + name, Target: p})
+	}
+	return volumes, nil
+}
+
+// parsePort parses a port spec: "3000", "8080:80", or "0.0.0.0:8080:80",
+// optionally followed by "/tcp" or "/udp". A single port is published on the
+// same host port, and ports listen on 127.0.0.1 unless an address is given.
+func parsePort(spec string) (backend.Port, error) {
+	p := backend.Port{HostIP: "127.0.0.1", Protocol: "tcp"}
+	s := spec
+	if rest, proto, ok := strings.Cut(s, "/"); ok {
+		if proto != "tcp" && proto != "udp" {
+			return backend.Port{}, fmt.Errorf("invalid port %q: protocol must be tcp or udp", spec)
+		}
+		s, p.Protocol = rest, proto
+	}
+
+	// The host address may be an IPv6 address in brackets
+	if strings.HasPrefix(s, "[") {
+		end := strings.Index(s, "]:")
+		if end < 0 {
+			return backend.Port{}, fmt.Errorf("invalid port %q", spec)
+		}
+		p.HostIP, s = s[1:end], s[end+2:]
+	} else if strings.Count(s, ":") == 2 {
+		p.HostIP, s, _ = strings.Cut(s, ":")
+	}
+
+	host, container, ok := strings.Cut(s, ":")
+	if !ok {
+		container = host
+	}
+	var err error
+	if p.HostPort, err = parsePortNumber(host); err != nil {
+		return backend.Port{}, fmt.Errorf("invalid port %q: %w", spec, err)
+	}
+	if p.ContainerPort, err = parsePortNumber(container); err != nil {
+		return backend.Port{}, fmt.Errorf("invalid port %q: %w", spec, err)
+	}
+	if net.ParseIP(p.HostIP) == nil {
+		return backend.Port{}, fmt.Errorf("invalid port %q: bad host address %q", spec, p.HostIP)
+	}
+	return p, nil
+}
+
+// parsePortNumber parses a port number between 1 and 65535.
+func parsePortNumber(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > 65535 {
+		return 0, fmt.Errorf("%q is not a port number", s)
+	}
+	return n, nil
+}
+
+// resolveSecrets merges the secrets from global, tool, and repo config. A
+// secret of the same name is replaced in increasing precedence.
+func resolveSecrets(tool string, cfg config.Config, repoMatches []RepoMatch) map[string]config.Secret {
+	s := cfg.Secrets
+	if toolCfg, ok := cfg.Tools[tool]; ok {
+		s = config.MergeSecrets(s, toolCfg.Secrets)
+	}
+	for _, rm := range repoMatches {
+		s = config.MergeSecrets(s, rm.Config.Secrets)
+	}
+	return s
+}
+
+// withSecrets returns envVars with secretEnv appended, dropping any existing
+// variables that a secret of the same name replaces.
+func withSecrets(envVars, secretEnv []string) []string {
+	if len(secretEnv) == 0 {
+		return envVars
+	}
+	names := make(map[string]bool, len(secretEnv))
+	for _, e := range secretEnv {
+		name, _, _ := strings.Cut(e, "=")
+		names[name] = true
+	}
+	result := make([]string, 0, len(envVars)+len(secretEnv))
+	for _, e := range envVars {
+		name, _, _ := strings.Cut(e, "=")
+		if !names[name] {
+			result = append(result, e)
+		}
+	}
+	return append(result, secretEnv...)
+}
+
+// parseMemory parses a memory size like "512m" or "8g" into bytes. Suffixes
+// are binary (k = 1024) and case-insensitive, matching docker's --memory flag.
+// An empty string returns 0.
+func parseMemory(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	num := strings.ToLower(strings.TrimSpace(s))
+	num = strings.TrimSuffix(num, "b")
+	multiplier := int64(1)
+	if n := len(num); n > 0 {
+		switch num[n-1] {
+		case 'k':
+			multiplier = 1 << 10
+		case 'm':
+			multiplier = 1 << 20
+		case 'g':
+			multiplier = 1 << 30
+		case 't':
+			multiplier = 1 << 40
+		}
+		if multiplier != 1 {
+			num = num[:n-1]
+		}
+	}
+	value, err := strconv.ParseFloat(num, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("%q is not a valid size (e.g. 512m, 8g)", s)
+	}
+	return int64(value * float64(multiplier)), nil
+}
+
+// image is the image a tool runs in.
+type image struct {
+	dockerfile string            // Dockerfile with post-build hooks injected
+	buildArgs  map[string]string // build args, part of the tag
+	tag        string            // tag derived from the Dockerfile and build args
+	cacheFrom  []string          // prebuilt images to reuse layers from
+	platform   string            // platform to build for, "" for native
+
+	// shared is the image shared through image_registry, built for
+	// sharedUser, that this one is a layer on top of, or nil if
+	// image_registry isn't set
+	shared *image
+
+	// buildSecrets are the build secrets the post-build hooks reference,
+	// fetched only when the image is built
+	buildSecrets map[string]config.Secret
+
+	// The hooks and repos that went into the image, set by dirImage
+	toolPostBuildHooks []string
+	repoPostBuildHooks []string
+	matchedRepoNames   []string
+}
+
+// resolveImage prepares the build of the image for opts.ToolDef. The tag
+// depends only on the Dockerfile and build args, not on mounts. With
+// image_registry set, the image is built for sharedUser, to be shared, and
+// the image returned is the layer on top that makes it u's.
+func resolveImage(opts Options, repoMatches []RepoMatch, u User, toolPostBuildHooks, repoPostBuildHooks []string, logSection func(string, ...any)) (image, error) {
+	tool, cfg := opts.ToolDef.Name, opts.Config
+	dockerfileTemplate, err := resolveDockerfile(opts.Dockerfile, cfg, repoMatches)
+	if err != nil {
+		return image{}, err
+	}
+	hooks := slices.Concat(cfg.PostBuildHooks, toolPostBuildHooks, repoPostBuildHooks)
+	for _, hook := range hooks {
+		if m := hookEnvRegex.FindString(hook); m != "" {
+			return image{}, fmt.Errorf("post_build_hooks can't use %s, as its value would be baked into the image: use it in pre_run_hooks instead, or a ${secret:name} of build_secrets", m)
+		}
+	}
+	buildSecrets, err := resolveBuildSecrets(cfg, repoMatches, hookSecrets(hooks))
+	if err != nil {
+		return image{}, err
+	}
+	stages := config.MergeBuildStages(cfg.BuildStages, cfg.Tools[tool].BuildStages)
+	for _, m := range repoMatches {
+		stages = config.MergeBuildStages(stages, m.Config.BuildStages)
+	}
+	withStages, err := spliceBuildStages(dockerfileTemplate, tool, stages)
+	if err != nil {
+		return image{}, err
+	}
+	dockerfile := dockerfileWithHooks(withStages, cfg.PostBuildHooks, tool, toolPostBuildHooks, repoPostBuildHooks)
+
+	// Prebuilt base images are only published for the embedded template
+	var cacheFrom []string
+	if ref := PrebuiltImage(cfg.Registry, opts.Dockerfile); ref != "" && dockerfileTemplate == opts.Dockerfile {
+		cacheFrom = []string{ref}
+	}
+	buildArgs := userBuildArgs(u)
+	if mode := resolveDockerInDocker(cfg, repoMatches); mode != "" {
+		buildArgs["DOCKER_IN_DOCKER"] = mode
+		logSection("Docker in Docker: %s", mode)
+	}
+	if cfg.Reproducible {
+		snapshot, err := aptSnapshot(cfg.AptSnapshot, time.Now())
+		if err != nil {
+			return image{}, err
+		}
+		buildArgs["APT_SNAPSHOT"] = snapshot.Format(aptSnapshotLayout)
+		buildArgs["SOURCE_DATE_EPOCH"] = strconv.FormatInt(snapshot.Unix(), 10)
+		logSection("Reproducible build from apt snapshot %s", buildArgs["APT_SNAPSHOT"])
+	}
+
+	// Read cached tool version for cache-busting
+	toolVersion := opts.ToolDef.CachedVersion()
+	if toolVersion != "" {
+		logSection("Tool version (cached): %s", toolVersion)
+		buildArgs["CACHE_BUST"] = toolVersion
+	}
+
+	platform, err := resolvePlatform(opts.Platform, tool, cfg)
+	if err != nil {
+		return image{}, err
+	}
+	if platform != "" {
+		logSection("Platform: %s", platform)
+	}
+
+	img := image{
+		dockerfile: dockerfile,
+		buildArgs:  buildArgs,
+		tag:        buildImageTag(tool, dockerfile, buildArgs, platform),
+		cacheFrom:  cacheFrom,
+		platform:   platform,
+
+		buildSecrets: buildSecrets,
+	}
+	if cfg.ImageRegistry != "" {
+		img = sharedImages(tool, img, u)
+	}
+	return img, nil
+}
+
+// resolveBuildSecrets returns the build secrets named names, which
+// post-build hooks reference, from those of cfg and of the repos that
+// match. It fails if one isn't configured.
+func resolveBuildSecrets(cfg config.Config, repoMatches []RepoMatch, names []string) (map[string]config.Secret, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	all := cfg.BuildSecrets
+	for _, m := range repoMatches {
+		all = config.MergeSecrets(all, m.Config.BuildSecrets)
+	}
+	result := make(map[string]config.Secret, len(names))
+	for _, name := range names {
+		s, ok := all[name]
+		if !ok {
+			return nil, fmt.Errorf("post_build_hooks use ${secret:%s}, but build_secrets has no secret %s", name, name)
+		}
+		result[name] = s
+	}
+	return result, nil
+}
+
+// dirImage resolves the image for opts.ToolDef in opts.Dir, applying the
+// config of the repos that match it.
+func dirImage(opts Options, logSection func(string, ...any)) (image, error) {
+	hostUser, err := ResolveUser("", "")
+	if err != nil {
+		return image{}, err
+	}
+	cwd := opts.Dir
+	if cwd == "" {
+		cwd, _ = os.Getwd()
+	}
+	repoMatches, _ := withConfigProfile(opts.Config, opts.Profile, matchRepos(opts.Config, git.GetGitRemoteURLs(cwd)))
+
+	var toolPostBuildHooks, repoPostBuildHooks []string
+	if toolCfg, ok := opts.Config.Tools[opts.ToolDef.Name]; ok {
+		toolPostBuildHooks = toolCfg.PostBuildHooks
+	}
+	var matchedRepoNames []string
+	for _, m := range repoMatches {
+		matchedRepoNames = append(matchedRepoNames, m.Name)
+		repoPostBuildHooks = append(repoPostBuildHooks, m.Config.PostBuildHooks...)
+	}
+
+	imgUser, err := imageUser(hostUser, opts.Config.ContainerUser)
+	if err != nil {
+		return image{}, err
+	}
+	img, err := resolveImage(opts, repoMatches, imgUser, toolPostBuildHooks, repoPostBuildHooks, logSection)
+	if err != nil {
+		return image{}, err
+	}
+	img.toolPostBuildHooks = toolPostBuildHooks
+	img.repoPostBuildHooks = repoPostBuildHooks
+	img.matchedRepoNames = matchedRepoNames
+	return img, nil
+}
+
+// Pull policies for images shared in config.Config.ImageRegistry.
+const (
+	PullNever        = "never"          // always build locally (default)
+	PullIfNotPresent = "if-not-present" // pull before building an image that isn't present
+)
+
+// SharedImage returns the reference of the image tag in registry, a
+// registry and repository such as "ghcr.io/myorg/silo".
+func SharedImage(registry, tag string) string {
+	return registry + ":" + tag
+}
+
+// pullShared pulls img.shared, the image img is a layer on top of, from the
+// configured image registry when the pull policy allows it and it isn't
+// present. Pull failures are not errors: the image is built locally
+// instead.
+func pullShared(ctx context.Context, b backend.Backend, cfg config.Config, img image, logSection func(string, ...any)) error {
+	switch cfg.PullPolicy {
+	case "", PullNever:
+		return nil
+	case PullIfNotPresent:
+	default:
+		return fmt.Errorf("invalid pull_policy: %q (must be %s or %s)", cfg.PullPolicy, PullNever, PullIfNotPresent)
+	}
+	if cfg.ImageRegistry == "" || img.shared == nil {
+		return fmt.Errorf("pull_policy %q requires image_registry", cfg.PullPolicy)
+	}
+	tag := img.shared.tag
+	if exists, err := b.ImageExists(ctx, tag); err != nil || exists {
+		return err
+	}
+	logSection("Pulling %s", SharedImage(cfg.ImageRegistry, tag))
+	if _, err := pullPinned(ctx, b, cfg.ImageRegistry, tag); err != nil {
+		logSection("Pull failed, building locally: %v", err)
+	}
+	return nil
+}
+
+// checkOffline returns an error if a run of tool needs the network to be
+// prepared: to build its image, which isn't built if imageExists is false,
+// or to pull the images of services.
+func checkOffline(ctx context.Context, b backend.Backend, tool string, imageExists bool, services []backend.Service) error {
+	if !imageExists {
+		return fmt.Errorf("the image for %s isn't built, and building it needs the network: run silo prefetch %s first, or run without --offline", tool, tool)
+	}
+	for _, svc := range services {
+		exists, err := b.ImageExists(ctx, svc.Image)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("the image %s of service %s isn't pulled: run silo prefetch %s first, or run without --offline", svc.Image, svc.Name, tool)
+		}
+	}
+	return nil
+}
+
+// Prefetch prepares everything a run of opts.ToolDef in opts.Dir needs from
+// the network, so later runs with opts.Offline don't use it: the latest
+// tool version is fetched and the image built, as Build does, and the
+// images of the services that apply are pulled. It returns the image's tag.
+func Prefetch(opts Options) (string, error) {
+	tag, err := Build(opts)
+	if err != nil {
+		return "", err
+	}
+
+	cfg := opts.Config
+	cwd := opts.Dir
+	if cwd == "" {
+		cwd, _ = os.Getwd()
+	}
+	repoMatches, _ := withConfigProfile(cfg, opts.Profile, matchRepos(cfg, git.GetGitRemoteURLs(cwd)))
+	network, err := resolveNetwork(opts.ToolDef.Name, cfg, repoMatches)
+	if err != nil {
+		return "", err
+	}
+	services, _, err := resolveServices(cfg, repoMatches, network)
+	if err != nil || len(services) == 0 {
+		return tag, err
+	}
+
+	timeouts, err := resolveTimeouts(cfg.Timeouts)
+	if err != nil {
+		return "", err
+	}
+	ctx := context.Background()
+	backendClient, _, err := selectAvailableBackend(ctx, cfg.Backend, cfg.BackendFallback, timeouts.connect, opts.Stderr, opts.Verbose, nil)
+	if err != nil {
+		return "", err
+	}
+	defer backendClient.Close()
+	for _, svc := range services {
+		if exists, err := backendClient.ImageExists(ctx, svc.Image); err != nil {
+			return "", err
+		} else if exists {
+			continue
+		}
+		if opts.Verbose {
+			cli.LogTo(opts.Stderr, "Pulling %s for service %s", svc.Image, svc.Name)
+		}
+		if _, err := backendClient.PullImage(ctx, svc.Image, svc.Image); err != nil {
+			return "", err
+		}
+	}
+	return tag, nil
+}
+
+// Build builds the image for opts.ToolDef in opts.Dir, as Tool would before
+// running it, and returns its tag. A cached image is reused unless
+// opts.ForceBuild is set. Options that only affect running are ignored.
+func Build(opts Options) (string, error) {
+	tool := opts.ToolDef.Name
+	cfg, err := config.ExpandHookRefs(opts.Config)
+	if err != nil {
+		return "", err
+	}
+	opts.Config = cfg
+	stderr := opts.Stderr
+	ctx := context.Background()
+	cacheFrom, err := parseBuildCaches(opts.CacheFrom)
+	if err != nil {
+		return "", err
+	}
+	cacheTo, err := parseBuildCaches(opts.CacheTo)
+	if err != nil {
+		return "", err
+	}
+
+	logSection := func(format string, args ...any) {
+		if opts.Verbose {
+			cli.LogTo(stderr, format, args...)
+		}
+	}
+
+	timeouts, err := resolveTimeouts(cfg.Timeouts)
+	if err != nil {
+		return "", err
+	}
+	backendClient, backendType, err := selectAvailableBackend(ctx, cfg.Backend, cfg.BackendFallback, timeouts.connect, stderr, opts.Verbose, nil)
+	if err != nil {
+		return "", err
+	}
+	defer backendClient.Close()
+
+	// Refresh the tool version first, so the image has the latest release
+	opts.ToolDef.FetchVersion(ctx)
+
+	img, err := dirImage(opts, logSection)
+	if err != nil {
+		return "", err
+	}
+	var imageExists bool
+	if !opts.ForceBuild {
+		if imageExists, err = backendClient.ImageExists(ctx, img.tag); err != nil {
+			return "", err
+		}
+		if !imageExists {
+			if err := pullShared(ctx, backendClient, cfg, img, logSection); err != nil {
+				return "", err
+			}
+		}
+		if !imageExists && len(cacheFrom) > 0 {
+			// Layers are reused in the image the tool's content is built in
+			built := &img
+			if img.shared != nil {
+				built = img.shared
+			}
+			built.cacheFrom = append(built.cacheFrom, importBuildCaches(ctx, backendClient, tool, cacheFrom, logSection)...)
+			if imageExists, err = backendClient.ImageExists(ctx, img.tag); err != nil {
+				return "", err
 			}
 		}
 	}
 
-	// Sort by pattern length (shortest first = less specific first)
-	sort.Slice(matches, func(i, j int) bool {
-		return len(matches[i].Name) < len(matches[j].Name)
+	var progress *cli.Progress
+	if !opts.Verbose && !imageExists {
+		progress = cli.NewProgress(stderr, []string{"Post-build hooks", "Building environment"})
+		progress.Start()
+	}
+	err = buildEnvironment(ctx, backendClient, buildEnvOptions{
+		tool:               tool,
+		dockerfile:         img.dockerfile,
+		imageTag:           img.tag,
+		buildArgs:          img.buildArgs,
+		forceBuild:         opts.ForceBuild,
+		imageExists:        imageExists,
+		cacheFrom:          img.cacheFrom,
+		platform:           img.platform,
+		buildSecrets:       img.buildSecrets,
+		shared:             img.shared,
+		timeout:            timeouts.build,
+		globalPostBuild:    cfg.PostBuildHooks,
+		toolPostBuildHooks: img.toolPostBuildHooks,
+		repoPostBuildHooks: img.repoPostBuildHooks,
+		matchedRepoNames:   img.matchedRepoNames,
+		stderr:             stderr,
+		verbose:            opts.Verbose,
+		progress:           progress,
 	})
-
-	return matches
-}
-
-// repoURLMatches checks if a git remote URL matches a pattern.
-// Both the URL and pattern have .git suffix stripped before comparison.
-// The pattern matches if it is a substring of the URL, allowing for prefix matching
-// (e.g., "github.com/stellar" matches "github.com/stellar/stellar-core").
-// SSH URLs (git@host:path) are normalized to host/path format for matching.
-func repoURLMatches(url, pattern string) bool {
-	url = strings.TrimSuffix(url, ".git")
-	pattern = strings.TrimSuffix(pattern, ".git")
-
-	// Normalize SSH URL format (git@github.com:org/repo -> github.com/org/repo)
-	if strings.HasPrefix(url, "git@") {
-		url = strings.TrimPrefix(url, "git@")
-		url = strings.Replace(url, ":", "/", 1)
+	if progress != nil {
+		progress.Complete()
 	}
-
-	return strings.Contains(url, pattern)
-}
-
-// createBackend creates the appropriate backend based on configuration.
-func createBackend(backendType string, stderr io.Writer, verbose bool) (backend.Backend, error) {
-	if backendType == "" {
-		// Default to container if available, otherwise docker
-		if _, err := exec.LookPath("container"); err == nil {
-			backendType = "container"
-		} else {
-			backendType = "docker"
-		}
+	if err != nil {
+		return "", err
 	}
+	if err := exportBuildCaches(ctx, backendClient, tool, img.tag, cacheTo, logSection); err != nil {
+		return "", err
+	}
+	warnEmulation(ctx, backendClient, backendType, tool, img.tag, stderr, nil)
+	return img.tag, nil
+}
 
-	switch backendType {
-	case "docker":
-		if verbose {
-			cli.LogTo(stderr, "Using docker backend...")
-		}
-		client, err := docker.NewClient()
+// PushImage pushes the shared image for opts.ToolDef in opts.Dir, which
+// must already be built, to registry and returns the pushed reference. Its
+// digest is pinned, as if it had been pulled.
+func PushImage(opts Options, registry string) (string, error) {
+	return shareImage(opts, registry, func(ctx context.Context, b backend.Backend, registry, tag string) (string, error) {
+		exists, err := b.ImageExists(ctx, tag)
 		if err != nil {
-			return nil, fmt.Errorf("failed to connect to Docker: %w", err)
+			return "", err
 		}
-		return client, nil
-	case "container":
-		if verbose {
-			cli.LogTo(stderr, "Using apple container (lightweight vms) backend...")
+		if !exists {
+			return "", fmt.Errorf("image %s does not exist, build it first with silo build and image_registry set", tag)
 		}
-		client, err := applecontainer.NewClient()
+		ref := SharedImage(registry, tag)
+		digest, err := b.PushImage(ctx, tag, ref)
 		if err != nil {
-			return nil, fmt.Errorf("failed to initialize container backend: %w", err)
+			return "", err
 		}
-		return client, nil
-	default:
-		return nil, fmt.Errorf("unknown backend: %s (valid: docker, container)", backendType)
-	}
+		_, err = pinImage(ref, digest, true)
+		return ref, err
+	})
 }
 
-// collectMounts gathers all mount paths from config for a specific tool.
-func collectMounts(tool string, cfg config.Config, cwd string, repoMatches []RepoMatch, worktreeRoots []string) (mountsRO, mountsRW []string) {
-	mountsRW = []string{cwd}
+// PullImage pulls the shared image for opts.ToolDef in opts.Dir from
+// registry, by its pinned digest if it has one, so that Tool and Build only
+// build the user's layer on top of it, and returns the pulled reference.
+func PullImage(opts Options, registry string) (string, error) {
+	return shareImage(opts, registry, func(ctx context.Context, b backend.Backend, registry, tag string) (string, error) {
+		return pullPinned(ctx, b, registry, tag)
+	})
+}
 
-	// Add tool-specific mounts
-	if toolCfg, ok := cfg.Tools[tool]; ok {
-		for _, m := range toolCfg.MountsRO {
-			mountsRO = append(mountsRO, expandPath(m))
-		}
-		for _, m := range toolCfg.MountsRW {
-			mountsRW = append(mountsRW, expandPath(m))
-		}
+// shareImage resolves the shared image for opts, as it would be with
+// image_registry set to registry, and calls fn to transfer its tag.
+func shareImage(opts Options, registry string, fn func(ctx context.Context, b backend.Backend, registry, tag string) (string, error)) (string, error) {
+	cfg, err := config.ExpandHookRefs(opts.Config)
+	if err != nil {
+		return "", err
 	}
-
-	// Add repo-specific mounts
-	for _, rm := range repoMatches {
-		for _, m := range rm.Config.MountsRO {
-			mountsRO = append(mountsRO, expandPath(m))
-		}
-		for _, m := range rm.Config.MountsRW {
-			mountsRW = append(mountsRW, expandPath(m))
-		}
+	opts.Config = cfg
+	ctx := context.Background()
+	if registry == "" {
+		registry = cfg.ImageRegistry
+	}
+	if registry == "" {
+		return "", fmt.Errorf("no registry given and image_registry is not configured")
 	}
+	opts.Config.ImageRegistry = registry
 
-	// Add global config mounts
-	for _, m := range cfg.MountsRO {
-		mountsRO = append(mountsRO, expandPath(m))
+	timeouts, err := resolveTimeouts(cfg.Timeouts)
+	if err != nil {
+		return "", err
 	}
-	for _, m := range cfg.MountsRW {
-		mountsRW = append(mountsRW, expandPath(m))
+	backendClient, _, err := selectAvailableBackend(ctx, cfg.Backend, cfg.BackendFallback, timeouts.connect, opts.Stderr, opts.Verbose, nil)
+	if err != nil {
+		return "", err
 	}
+	defer backendClient.Close()
 
-	// Add git worktree roots (read-write for git operations)
-	mountsRW = append(mountsRW, worktreeRoots...)
+	// The tag depends on the tool version, as it does when building
+	opts.ToolDef.FetchVersion(ctx)
 
-	return mountsRO, mountsRW
+	img, err := dirImage(opts, func(string, ...any) {})
+	if err != nil {
+		return "", err
+	}
+	return fn(ctx, backendClient, registry, img.shared.tag)
 }
 
 // buildEnvOptions contains options for building the container environment.
@@ -418,7 +2654,12 @@ type buildEnvOptions struct {
 	mountsRO           []string
 	mountsRW           []string
 	forceBuild         bool
-	imageExists        bool // pre-checked image existence (from parallel phase)
+	imageExists        bool     // pre-checked image existence (from parallel phase)
+	cacheFrom          []string // prebuilt images to reuse layers from
+	platform           string
+	buildSecrets       map[string]config.Secret
+	shared             *image        // image shared through image_registry the image is a layer on top of
+	timeout            time.Duration // how long the build may take, 0 for no limit
 	globalPostBuild    []string
 	toolPostBuildHooks []string
 	repoPostBuildHooks []string
@@ -480,15 +2721,95 @@ func buildEnvironment(ctx context.Context, backendClient backend.Backend, opts b
 		return nil
 	}
 
-	_, err := backendClient.Build(ctx, backend.BuildOptions{
+	// A shared image, unless pulled, is built first, and the user's layer
+	// on top of it
+	if s := opts.shared; s != nil {
+		sharedOpts := opts
+		sharedOpts.dockerfile, sharedOpts.imageTag, sharedOpts.buildArgs = s.dockerfile, s.tag, s.buildArgs
+		sharedOpts.cacheFrom, sharedOpts.platform, sharedOpts.buildSecrets = s.cacheFrom, s.platform, s.buildSecrets
+		if _, err := buildImage(ctx, backendClient, sharedOpts); err != nil {
+			return err
+		}
+	}
+	built, err := buildImage(ctx, backendClient, opts)
+	if err != nil {
+		return err
+	}
+	if built {
+		logSuccessBullet("Environment ready")
+	} else {
+		logSuccessBullet("Environment cached")
+	}
+	return nil
+}
+
+// buildImage builds the image opts.imageTag, unless opts.forceBuild is
+// false and it exists, and reports whether it was built.
+func buildImage(ctx context.Context, backendClient backend.Backend, opts buildEnvOptions) (bool, error) {
+	// Serialize builds of the same image across silo processes. Another
+	// process may have finished building it while this one waited.
+	unlock, err := fileutil.Lock("build:" + opts.imageTag)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	if !opts.forceBuild {
+		if exists, err := backendClient.ImageExists(ctx, opts.imageTag); err == nil && exists {
+			return false, nil
+		}
+	}
+
+	// Build secrets are fetched only now, as fetching one may prompt
+	secretValues := make(map[string]string, len(opts.buildSecrets))
+	for name, s := range opts.buildSecrets {
+		v, err := secrets.Fetch(ctx, s)
+		if err != nil {
+			if opts.progress != nil {
+				opts.progress.Fail()
+			}
+			return false, fmt.Errorf("failed to fetch build secret %s: %w", name, err)
+		}
+		secretValues[name] = v
+	}
+	redact := slices.Collect(maps.Values(secretValues))
+
+	// The whole output is written to the build log, as the progress view
+	// only shows the last line of it. Builds still run if it can't be.
+	logPath := BuildLog(opts.imageTag)
+	var buildLog io.Writer = io.Discard
+	if f, err := createBuildLog(logPath); err == nil {
+		defer f.Close()
+		buildLog = f
+	} else {
+		logPath = ""
+	}
+
+	buildCtx := ctx
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		buildCtx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+	events.Emit(events.Event{Type: events.BuildStarted, Tool: opts.tool, Image: opts.imageTag})
+	_, err = backendClient.Build(buildCtx, backend.BuildOptions{
 		Dockerfile: opts.dockerfile,
-		Target:     opts.tool,
+		Target:     buildTarget(opts.dockerfile, opts.tool),
 		Tag:        opts.imageTag,
 		BuildArgs:  opts.buildArgs,
 		MountsRO:   opts.mountsRO,
 		MountsRW:   opts.mountsRW,
 		NoCache:    opts.forceBuild,
+		CacheFrom:  opts.cacheFrom,
+		Platform:   opts.platform,
+		Secrets:    secretValues,
 		OnProgress: func(msg string) {
+			msg = secrets.Redact(msg, redact)
+			fmt.Fprint(buildLog, msg)
+			for line := range strings.Lines(msg) {
+				if line = strings.TrimRight(line, "\r\n"); line != "" {
+					events.Emit(events.Event{Type: events.BuildProgress, Tool: opts.tool, Image: opts.imageTag, Line: line})
+				}
+			}
 			if opts.verbose {
 				fmt.Fprint(opts.stderr, msg)
 			} else if opts.progress != nil {
@@ -496,11 +2817,19 @@ func buildEnvironment(ctx context.Context, backendClient backend.Backend, opts b
 			}
 		},
 	})
+	if err != nil && errors.Is(buildCtx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s (timeouts.build)", opts.timeout)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to build environment: %w", err)
+		if opts.progress != nil {
+			opts.progress.Fail()
+		}
+		if logPath != "" {
+			return false, fmt.Errorf("failed to build environment: %w (full output in %s)", err, logPath)
+		}
+		return false, fmt.Errorf("failed to build environment: %w", err)
 	}
-	logSuccessBullet("Environment ready")
-	return nil
+	return true, nil
 }
 
 // envLogInfo holds environment variable categorization for logging.
@@ -510,9 +2839,16 @@ type envLogInfo struct {
 	explicitRepo   []string // explicit from repoCfg.Env (KEY=VALUE)
 	fromHost       []string // lifted from host env
 	notFound       []string // configured but not in host env
+	blocked        []string // matching a pattern but also env_blocklist
+	listedBlocked  []string // listed by name, so passed, but matching env_blocklist
+	vars           []EnvVar // every variable, in the order configured
+	values         []string // values of the configured variables, for redaction
 }
 
-// collectEnvVars gathers environment variables from config and host.
+// collectEnvVars gathers environment variables from config and host. Host
+// variables matching a pattern in env, such as "AWS_*", aren't passed
+// through if they match cfg.EnvBlocklist. Those listed by name are, but are
+// noted in log.listedBlocked to be warned about.
 func collectEnvVars(tool string, cfg config.Config, repoMatches []RepoMatch, gitName, gitEmail string) (envVars []string, log envLogInfo) {
 	// Set git identity
 	if gitName != "" {
@@ -527,48 +2863,68 @@ func collectEnvVars(tool string, cfg config.Config, repoMatches []RepoMatch, git
 			"GIT_COMMITTER_EMAIL="+gitEmail,
 		)
 	}
-
-	// Process global env vars (passthrough if no '=', explicit if has '=')
-	for _, e := range cfg.Env {
-		if strings.Contains(e, "=") {
-			envVars = append(envVars, e)
-			log.explicitGlobal = append(log.explicitGlobal, strings.SplitN(e, "=", 2)[0])
-		} else if val := os.Getenv(e); val != "" {
-			envVars = append(envVars, e+"="+val)
-			log.fromHost = append(log.fromHost, e)
-		} else {
-			log.notFound = append(log.notFound, e)
-		}
+	for _, e := range envVars {
+		name, _, _ := strings.Cut(e, "=")
+		log.vars = append(log.vars, EnvVar{Name: name, Source: "git identity", Status: EnvSet})
 	}
 
-	// Tool-specific env vars
-	if toolCfg, ok := cfg.Tools[tool]; ok {
-		for _, e := range toolCfg.Env {
-			if strings.Contains(e, "=") {
+	// Passthrough if no '=', explicit if has '='. A passthrough with '*'
+	// is a pattern passing every host variable it matches.
+	fromHost := func(name, val, source, status string) {
+		envVars = append(envVars, name+"="+val)
+		log.fromHost = append(log.fromHost, name)
+		log.vars = append(log.vars, EnvVar{Name: name, Source: source, Status: status})
+		log.values = append(log.values, val)
+	}
+	add := func(env []string, source string, explicit *[]string) {
+		for _, e := range env {
+			if name, val, ok := strings.Cut(e, "="); ok {
 				envVars = append(envVars, e)
-				log.explicitTool = append(log.explicitTool, strings.SplitN(e, "=", 2)[0])
-			} else if val := os.Getenv(e); val != "" {
-				envVars = append(envVars, e+"="+val)
-				log.fromHost = append(log.fromHost, e)
-			} else {
+				*explicit = append(*explicit, name)
+				log.vars = append(log.vars, EnvVar{Name: name, Source: source, Status: EnvSet})
+				log.values = append(log.values, val)
+			} else if strings.Contains(e, "*") {
+				matched := false
+				for _, hostEnv := range slices.Sorted(slices.Values(os.Environ())) {
+					name, val, _ := strings.Cut(hostEnv, "=")
+					if val == "" || !matchEnv(name, []string{e}) {
+						continue
+					}
+					matched = true
+					if matchEnv(name, cfg.EnvBlocklist) {
+						log.blocked = append(log.blocked, name)
+						log.vars = append(log.vars, EnvVar{Name: name, Source: source, Status: EnvBlocked})
+					} else {
+						fromHost(name, val, source, EnvHost)
+					}
+				}
+				if !matched {
+					log.notFound = append(log.notFound, e)
+					log.vars = append(log.vars, EnvVar{Name: e, Source: source, Status: EnvNotSet})
+				}
+			} else if val := os.Getenv(e); val == "" {
 				log.notFound = append(log.notFound, e)
+				log.vars = append(log.vars, EnvVar{Name: e, Source: source, Status: EnvNotSet})
+			} else if matchEnv(e, cfg.EnvBlocklist) {
+				log.listedBlocked = append(log.listedBlocked, e)
+				fromHost(e, val, source, EnvListedBlocked)
+			} else {
+				fromHost(e, val, source, EnvHost)
 			}
 		}
 	}
 
+	// Global env vars
+	add(cfg.Env, "config", &log.explicitGlobal)
+
+	// Tool-specific env vars
+	if toolCfg, ok := cfg.Tools[tool]; ok {
+		add(toolCfg.Env, "config, "+tool, &log.explicitTool)
+	}
+
 	// Repo-specific env vars
 	for _, rm := range repoMatches {
-		for _, e := range rm.Config.Env {
-			if strings.Contains(e, "=") {
-				envVars = append(envVars, e)
-				log.explicitRepo = append(log.explicitRepo, strings.SplitN(e, "=", 2)[0])
-			} else if val := os.Getenv(e); val != "" {
-				envVars = append(envVars, e+"="+val)
-				log.fromHost = append(log.fromHost, e)
-			} else {
-				log.notFound = append(log.notFound, e)
-			}
-		}
+		add(rm.Config.Env, "config, repo "+rm.Name, &log.explicitRepo)
 	}
 
 	return envVars, log
@@ -586,6 +2942,12 @@ type logRunConfigOptions struct {
 	repoPreRun       []string
 	matchedRepoNames []string
 	containerName    string
+	network          backend.Network
+	ports            []backend.Port
+	tmpfs            []string
+	volumes          []backend.Mount
+	secrets          map[string]config.Secret
+	secretValues     []string
 	gitName          string
 	gitEmail         string
 	verbose          bool
@@ -602,7 +2964,7 @@ func logRunConfig(opts logRunConfigOptions) {
 	}
 	logBullet := func(format string, args ...any) {
 		if opts.verbose {
-			cli.LogBulletTo(opts.stderr, format, args...)
+			cli.LogBulletTo(opts.stderr, "%s", secrets.Redact(fmt.Sprintf(format, args...), opts.secretValues))
 		}
 	}
 
@@ -640,6 +3002,18 @@ func logRunConfig(opts logRunConfigOptions) {
 		seen[m] = true
 		logBullet("%s", tilde.Path(m))
 	}
+	if len(opts.tmpfs) > 0 {
+		logSection("Mounts (tmpfs):")
+		for _, m := range opts.tmpfs {
+			logBullet("%s", tilde.Path(m))
+		}
+	}
+	if len(opts.volumes) > 0 {
+		logSection("Mounts (cache volumes):")
+		for _, v := range opts.volumes {
+			logBullet("%s (%s)", tilde.Path(v.Target), v.Source)
+		}
+	}
 
 	// Log environment variables
 	if opts.progress != nil {
@@ -663,7 +3037,7 @@ func logRunConfig(opts logRunConfigOptions) {
 			logBullet("%s", name)
 		}
 	}
-	if len(opts.envLog.fromHost) > 0 || len(opts.envLog.notFound) > 0 {
+	if len(opts.envLog.fromHost) > 0 || len(opts.envLog.notFound) > 0 || len(opts.envLog.blocked) > 0 {
 		logSection("Environment (host):")
 		for _, name := range opts.envLog.fromHost {
 			logBullet("%s", name)
@@ -671,6 +3045,15 @@ func logRunConfig(opts logRunConfigOptions) {
 		for _, name := range opts.envLog.notFound {
 			logBullet("%s (not set)", name)
 		}
+		for _, name := range opts.envLog.blocked {
+			logBullet("%s (blocked by env_blocklist)", name)
+		}
+	}
+	if len(opts.secrets) > 0 {
+		logSection("Secrets:")
+		for _, name := range slices.Sorted(maps.Keys(opts.secrets)) {
+			logBullet("%s (%s)", name, secrets.Source(opts.secrets[name]))
+		}
 	}
 
 	// Log pre-run hooks
@@ -700,10 +3083,51 @@ func logRunConfig(opts logRunConfigOptions) {
 		opts.progress.SetSection("Container")
 	}
 	logSection("Container name: %s", opts.containerName)
+
+	// Log network
+	switch opts.network.Mode {
+	case backend.NetworkNone:
+		logSection("Network: none")
+	case backend.NetworkAllowlist:
+		logSection("Network (allowlist):")
+		for _, host := range opts.network.Allow {
+			logBullet("%s", host)
+		}
+	}
+	if opts.network.Join != "" {
+		logSection("Network: %s (reachable as %s)", opts.network.Join, backend.DNSName(opts.containerName))
+	}
+
+	// Log ports
+	if len(opts.ports) > 0 {
+		logSection("Ports:")
+		for _, p := range opts.ports {
+			logBullet("%s:%d -> %d/%s", p.HostIP, p.HostPort, p.ContainerPort, p.Protocol)
+		}
+	}
+}
+
+// checkAuth warns when no credentials for tool are available and returns the
+// pre-run hooks with the tool's login flow appended, if it has one. The login
+// flow is interactive so it is skipped for sessions without a terminal, such
+// as detached ones.
+func checkAuth(stderr io.Writer, tool string, auth tools.Auth, envVars, preRunHooks []string, noTerminal bool) []string {
+	if auth.LoggedIn(envVars) {
+		return preRunHooks
+	}
+	cli.LogWarningTo(stderr, "No %s credentials found", tool)
+	if auth.Hint != "" {
+		cli.LogDimTo(stderr, "%s", auth.Hint)
+	}
+	if len(auth.LoginCommand) == 0 || noTerminal {
+		return preRunHooks
+	}
+	cli.LogTo(stderr, "Starting the %s login flow first", tool)
+	return append(slices.Clip(preRunHooks), shellquote.Join(auth.LoginCommand...))
 }
 
 // preparePreRunHooks combines and prepares pre-run hooks including mount wait.
-func preparePreRunHooks(globalHooks, toolHooks, repoHooks []string, mountsRO, mountsRW []string, verbose bool) []string {
+func preparePreRunHooks(globalHooks, toolHooks, repoHooks []string, mountsRO, mountsRW []string, mountWait time.Duration, verbose bool) []string {
 	preRunHooks := append(globalHooks, toolHooks...)
 	preRunHooks = append(preRunHooks, repoHooks...)
 
@@ -722,20 +3146,173 @@ func preparePreRunHooks(globalHooks, toolHooks, repoHooks []string, mountsRO, mo
 	sort.Strings(allMountPaths)
 
 	// Prepend mount wait hook to ensure mounts are ready before other hooks run
-	if mountWaitHook := mountwait.GenerateScript(allMountPaths, verbose); mountWaitHook != "" {
+	if mountWaitHook := mountwait.GenerateScript(allMountPaths, mountWait, verbose); mountWaitHook != "" {
 		preRunHooks = append([]string{mountWaitHook}, preRunHooks...)
 	}
 
 	return preRunHooks
 }
 
-// buildImageTag returns a content-addressed image tag encoding the build inputs.
-func buildImageTag(target, dockerfile string, buildArgs map[string]string) string {
+// hookEnvRegex matches a ${env:VAR} placeholder in a hook, or in the env
+// and headers of an MCP server.
+var hookEnvRegex = regexp.MustCompile(`\$\{env:([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// hookSecretRegex matches a ${secret:name} placeholder in a post-build hook.
+var hookSecretRegex = regexp.MustCompile(`\$\{secret:([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// hookSecrets returns the names of the build secrets hooks reference, each
+// once, in the order first referenced.
+func hookSecrets(hooks []string) []string {
+	var names []string
+	for _, hook := range hooks {
+		for _, m := range hookSecretRegex.FindAllStringSubmatch(hook, -1) {
+			if !slices.Contains(names, m[1]) {
+				names = append(names, m[1])
+			}
+		}
+	}
+	return names
+}
+
+// hookRun returns the RUN instruction of a post-build hook. Each build
+// secret the hook references is mounted for the instruction only, and its
+// placeholder replaced by a command reading it, so its value is in neither
+// the Dockerfile nor the image, and changing it doesn't change the tag.
+func hookRun(hook string) string {
+	var b strings.Builder
+	b.WriteString("RUN ")
+	for _, name := range hookSecrets([]string{hook}) {
+		fmt.Fprintf(&b, "--mount=type=secret,id=%s,required=true ", name)
+	}
+	b.WriteString(hookSecretRegex.ReplaceAllString(hook, "$$(cat /run/secrets/$1)"))
+	b.WriteString("\n")
+	return b.String()
+}
+
+// hookEnvPrefix prefixes the name of the variable the value of a ${env:VAR}
+// placeholder is passed to a container in, so it doesn't replace a
+// variable of the container, such as HOME.
+const hookEnvPrefix = "__SILO_ENV_"
+
+// errHookEnvBlocked is returned by a hookEnvLookup for a host variable
+// matching env_blocklist.
+var errHookEnvBlocked = errors.New("blocked by env_blocklist")
+
+// hookEnvLookup looks up a variable for a hook or MCP placeholder in the
+// environment of the session, given as NAME=value, and then in the host
+// environment, where variables matching blocklist aren't looked up.
+func hookEnvLookup(envVars, blocklist []string) func(string) (string, bool, error) {
+	return func(name string) (string, bool, error) {
+		for _, e := range slices.Backward(envVars) {
+			if k, v, ok := strings.Cut(e, "="); ok && k == name {
+				return v, true, nil
+			}
+		}
+		if matchEnv(name, blocklist) {
+			return "", false, errHookEnvBlocked
+		}
+		v, ok := os.LookupEnv(name)
+		return v, ok, nil
+	}
+}
+
+// expandHookEnv replaces ${env:VAR} placeholders in hooks with a reference
+// to a variable holding the value lookup returns, quoted for where the
+// placeholder is, and returns those variables as NAME=value. Values are
+// passed in the environment rather than the hooks, which are on the
+// container's command line, so they can't be seen in its config and are
+// never parsed as shell. It fails, naming every missing or blocked
+// variable, if any placeholder can't be resolved.
+func expandHookEnv(hooks []string, lookup func(string) (string, bool, error)) ([]string, []string, error) {
+	var env, missing, blocked []string
+	expanded := make([]string, len(hooks))
+	for i, hook := range hooks {
+		var b strings.Builder
+		last := 0
+		for _, loc := range hookEnvRegex.FindAllStringSubmatchIndex(hook, -1) {
+			name := hook[loc[2]:loc[3]]
+			v, ok, err := lookup(name)
+			switch {
+			case errors.Is(err, errHookEnvBlocked):
+				if !slices.Contains(blocked, name) {
+					blocked = append(blocked, name)
+				}
+			case !ok:
+				if !slices.Contains(missing, name) {
+					missing = append(missing, name)
+				}
+			}
+			if e := hookEnvPrefix + name + "=" + v; !slices.Contains(env, e) {
+				env = append(env, e)
+			}
+			b.WriteString(hook[last:loc[0]])
+			b.WriteString(quoteHookEnvRef(hookEnvPrefix+name, shellQuoteAt(hook, loc[0])))
+			last = loc[1]
+		}
+		b.WriteString(hook[last:])
+		expanded[i] = b.String()
+	}
+	var problems []string
+	if len(missing) > 0 {
+		problems = append(problems, "unset environment variables: "+strings.Join(missing, ", "))
+	}
+	if len(blocked) > 0 {
+		problems = append(problems, "environment variables blocked by env_blocklist: "+strings.Join(blocked, ", "))
+	}
+	if len(problems) > 0 {
+		return nil, nil, fmt.Errorf("pre_run_hooks reference %s", strings.Join(problems, "; "))
+	}
+	return expanded, env, nil
+}
+
+// shellQuoteAt returns the quote, ' or ", the shell is in at offset i of
+// script, or 0 if it's in neither.
+func shellQuoteAt(script string, i int) byte {
+	var quote byte
+	for j := 0; j < i; j++ {
+		switch c := script[j]; {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '\\':
+			j++
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		}
+	}
+	return quote
+}
+
+// quoteHookEnvRef returns a reference to the variable name, quoted so it
+// expands to a single word wherever the shell is in quote.
+func quoteHookEnvRef(name string, quote byte) string {
+	switch quote {
+	case '\'':
+		return `'"${` + name + `}"'`
+	case '"':
+		return "${" + name + "}"
+	}
+	return `"${` + name + `}"`
+}
+
+// buildImageTag returns a content-addressed image tag encoding the build
+// inputs. An empty platform leaves the tag as it was before platforms could
+// be chosen.
+func buildImageTag(target, dockerfile string, buildArgs map[string]string, platform string) string {
 	h := sha256.New()
 	h.Write([]byte(dockerfile))
 	h.Write([]byte{0})
 	h.Write([]byte(target))
 	h.Write([]byte{0})
+	if platform != "" {
+		h.Write([]byte("platform=" + platform))
+		h.Write([]byte{0})
+	}
 
 	keys := make([]string, 0, len(buildArgs))
 	for k := range buildArgs {
@@ -763,9 +3340,7 @@ func dockerfileWithHooks(dockerfileTemplate string, globalHooks []string, tool s
 	if len(globalHooks) > 0 {
 		var runCmds strings.Builder
 		for _, hook := range globalHooks {
-			runCmds.WriteString("RUN ")
-			runCmds.WriteString(hook)
-			runCmds.WriteString("\n")
+			runCmds.WriteString(hookRun(hook))
 		}
 		result = strings.Replace(result, "# SILO_POST_BUILD_HOOKS\n", runCmds.String()+"# SILO_POST_BUILD_HOOKS\n", 1)
 	}
@@ -776,9 +3351,7 @@ func dockerfileWithHooks(dockerfileTemplate string, globalHooks []string, tool s
 		toolMarker := fmt.Sprintf("# SILO_POST_BUILD_HOOKS_%s\n", strings.ToUpper(tool))
 		var runCmds strings.Builder
 		for _, hook := range allToolStageHooks {
-			runCmds.WriteString("RUN ")
-			runCmds.WriteString(hook)
-			runCmds.WriteString("\n")
+			runCmds.WriteString(hookRun(hook))
 		}
 		result = strings.Replace(result, toolMarker, runCmds.String()+toolMarker, 1)
 	}
@@ -786,27 +3359,184 @@ func dockerfileWithHooks(dockerfileTemplate string, globalHooks []string, tool s
 	return result
 }
 
-// sanitizeContainerName converts a directory name into a valid container name.
-// Container names must match [a-zA-Z0-9][a-zA-Z0-9_.-]. Invalid characters
-// are replaced with hyphens, and leading/trailing/consecutive hyphens are
-// collapsed.
-func sanitizeContainerName(name string) string {
-	var b strings.Builder
-	prevHyphen := true // treat start as hyphen to strip leading hyphens
-	for _, r := range name {
-		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
-			b.WriteRune(r)
-			prevHyphen = false
-		} else if !prevHyphen {
-			b.WriteByte('-')
-			prevHyphen = true
+// baseStageRegex matches the FROM line of the stage named base, capturing
+// everything after the image.
+var baseStageRegex = regexp.MustCompile(`(?mi)^FROM\s+(?:--\S+\s+)*\S+(\s+AS\s+base\s*)$`)
+
+// resolveDockerfile returns the Dockerfile template to build. The configured
+// dockerfile replaces the embedded template, and the configured base_image
+// replaces the image of its base stage. Repo config takes precedence over
+// global config.
+func resolveDockerfile(template string, cfg config.Config, repoMatches []RepoMatch) (string, error) {
+	path, baseImage := cfg.Dockerfile, cfg.BaseImage
+	for _, rm := range repoMatches {
+		if rm.Config.Dockerfile != "" {
+			path = rm.Config.Dockerfile
+		}
+		if rm.Config.BaseImage != "" {
+			baseImage = rm.Config.BaseImage
 		}
 	}
-	s := strings.TrimRight(b.String(), "-")
-	if s == "" {
-		return "silo"
+
+	if path != "" {
+		data, err := os.ReadFile(expandPath(path))
+		if err != nil {
+			return "", fmt.Errorf("failed to read dockerfile: %w", err)
+		}
+		template = string(data)
+	}
+
+	if baseImage != "" {
+		if strings.ContainsAny(baseImage, " \t\r\n") {
+			return "", fmt.Errorf("invalid base_image: %q", baseImage)
+		}
+		loc := baseStageRegex.FindStringSubmatchIndex(template)
+		if loc == nil {
+			return "", fmt.Errorf("base_image is set but the Dockerfile has no stage named base")
+		}
+		template = template[:loc[0]] + "FROM " + baseImage + template[loc[2]:]
+	}
+
+	return template, nil
+}
+
+// buildStageNameRegex matches the stage names build_stages may use.
+var buildStageNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_.-]*$`)
+
+// spliceBuildStages returns dockerfile with the build stages, files of
+// Dockerfile instructions by stage name, built in order of name between
+// the base stage and the stage of tool, which must be FROM base. Each
+// stage is FROM the one before it, and flags of the tool's FROM, such as
+// --platform, are kept. The instructions are part of the Dockerfile, so
+// they are part of the image tag.
+func spliceBuildStages(dockerfile, tool string, stages map[string]string) (string, error) {
+	if len(stages) == 0 {
+		return dockerfile, nil
+	}
+	toolStage := regexp.MustCompile(`(?mi)^FROM\s+((?:--\S+\s+)*)base(\s+AS\s+` + regexp.QuoteMeta(tool) + `\s*)$`)
+	loc := toolStage.FindStringSubmatchIndex(dockerfile)
+	if loc == nil {
+		return "", fmt.Errorf("build_stages need the Dockerfile to have a stage FROM base AS %s", tool)
+	}
+
+	var spliced strings.Builder
+	from := "base"
+	for _, name := range slices.Sorted(maps.Keys(stages)) {
+		if !buildStageNameRegex.MatchString(name) || regexp.MustCompile(`(?mi)^FROM\s+.*\s+AS\s+`+regexp.QuoteMeta(name)+`\s*$`).MatchString(dockerfile) {
+			return "", fmt.Errorf("invalid build_stages name %q: it must be lowercase and not name a stage of the Dockerfile", name)
+		}
+		data, err := os.ReadFile(expandPath(stages[name]))
+		if err != nil {
+			return "", fmt.Errorf("failed to read build stage %s: %w", name, err)
+		}
+		if regexp.MustCompile(`(?mi)^\s*FROM\s`).Match(data) {
+			return "", fmt.Errorf("build stage %s in %s must not have a FROM instruction, it is built FROM the stage before it", name, stages[name])
+		}
+		fmt.Fprintf(&spliced, "FROM %s AS %s\n%s\n\n", from, name, strings.TrimRight(string(data), "\n"))
+		from = name
+	}
+	flags := dockerfile[loc[2]:loc[3]]
+	return dockerfile[:loc[0]] + spliced.String() + "FROM " + flags + from + dockerfile[loc[4]:], nil
+}
+
+// buildTarget returns the stage to build for tool: the stage named after the
+// tool if the Dockerfile has one, otherwise the last stage. Custom
+// Dockerfiles need not define a stage per tool.
+func buildTarget(dockerfile, tool string) string {
+	stage := regexp.MustCompile(`(?mi)^FROM\s+.*\s+AS\s+` + regexp.QuoteMeta(tool) + `\s*$`)
+	if stage.MatchString(dockerfile) {
+		return tool
+	}
+	return ""
+}
+
+// User is the host user the container user is created to match.
+type User struct {
+	Name string
+	Home string
+	UID  int
+	GID  int
+}
+
+// userNameRegex matches the user names useradd accepts.
+var userNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// ResolveUser returns the host user. The name and home are taken from the
+// arguments if set, then $USER and $HOME, then the user database, so silo
+// still works when launched by launchd or a minimal CI shell that doesn't
+// set them. The result is validated because it becomes build args of the
+// image, where a bad value would otherwise fail late or build the wrong
+// image.
+func ResolveUser(name, home string) (User, error) {
+	if name == "" {
+		name = os.Getenv("USER")
+	}
+	if home == "" {
+		home = os.Getenv("HOME")
+	}
+	if name == "" || home == "" {
+		u, err := osuser.Current()
+		if err != nil {
+			return User{}, fmt.Errorf("cannot determine the current user, HOME or USER is not set: %w (set them, or use --home and --user)", err)
+		}
+		if name == "" {
+			name = u.Username
+		}
+		if home == "" {
+			home = u.HomeDir
+		}
+	}
+
+	u := User{Name: name, Home: filepath.Clean(home), UID: os.Getuid(), GID: os.Getgid()}
+	return u, validateUser(u)
+}
+
+// validateUser returns an error if the user can't be recreated in the image.
+func validateUser(u User) error {
+	if u.UID == 0 {
+		return errors.New("silo cannot run as root, the container user is created with the host user's uid: run silo as your own user rather than with sudo")
+	}
+	if !userNameRegex.MatchString(u.Name) {
+		return fmt.Errorf("invalid user name %q: use --user to set one", u.Name)
+	}
+	if !filepath.IsAbs(u.Home) || u.Home == "/" {
+		return fmt.Errorf("invalid home directory %q, it must be an absolute path other than /: use --home to set one", u.Home)
+	}
+	return nil
+}
+
+// imageUser returns the user created in the image: the host user u with
+// the fields set in container_user replacing its own. The home directory
+// stays the host user's, as mounts are at the same paths as on the host.
+func imageUser(u User, cu config.ContainerUser) (User, error) {
+	if cu.Name != "" {
+		if !userNameRegex.MatchString(cu.Name) {
+			return User{}, fmt.Errorf("invalid container_user.name %q", cu.Name)
+		}
+		u.Name = cu.Name
+	}
+	if cu.UID < 0 || cu.GID < 0 {
+		return User{}, errors.New("invalid container_user: uid and gid can't be negative")
+	}
+	if cu.UID != 0 {
+		u.UID = cu.UID
+	}
+	if cu.GID != 0 {
+		u.GID = cu.GID
+	}
+	return u, nil
+}
+
+// Userns settings.
+const UsernsHost = "host" // run outside the Docker daemon's userns-remap
+
+// resolveUserns returns the backend.Userns value for the userns setting.
+func resolveUserns(userns string) (string, error) {
+	switch userns {
+	case "", UsernsHost:
+		return userns, nil
 	}
-	return strings.ToLower(s)
+	return "", fmt.Errorf("invalid userns: %q (must be host, or unset for the daemon's setting)", userns)
 }
 
 // expandPath expands ~ to the user's home directory.
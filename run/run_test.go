@@ -1,38 +1,25 @@
 package run
 
 import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
-)
+	"time"
 
-func TestSanitizeContainerName(t *testing.T) {
-	tests := []struct {
-		input string
-		want  string
-	}{
-		{"my-project", "my-project"},
-		{"My Project", "my-project"},
-		{"hello.world", "hello-world"},
-		{"foo  bar", "foo-bar"},
-		{"  leading", "leading"},
-		{"trailing  ", "trailing"},
-		{"a/b/c", "a-b-c"},
-		{"café", "caf"},
-		{"", "silo"},
-		{"...", "silo"},
-		{"my_project", "my-project"},
-		{"123", "123"},
-		{"MyProject", "myproject"},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.input, func(t *testing.T) {
-			got := sanitizeContainerName(tt.input)
-			if got != tt.want {
-				t.Errorf("sanitizeContainerName(%q) = %q, want %q", tt.input, got, tt.want)
-			}
-		})
-	}
-}
+	"github.com/leighmcculloch/silo/backend"
+	"github.com/leighmcculloch/silo/config"
+	"github.com/leighmcculloch/silo/journal"
+	"github.com/leighmcculloch/silo/statedir"
+	"github.com/leighmcculloch/silo/tools"
+)
 
 func TestRepoURLMatches(t *testing.T) {
 	tests := []struct {
@@ -60,3 +47,1318 @@ func TestRepoURLMatches(t *testing.T) {
 		})
 	}
 }
+
+func TestParseMemory(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1024", 1024, false},
+		{"512k", 512 << 10, false},
+		{"512m", 512 << 20, false},
+		{"8g", 8 << 30, false},
+		{"8G", 8 << 30, false},
+		{"8gb", 8 << 30, false},
+		{"1.5g", 3 << 29, false},
+		{"1t", 1 << 40, false},
+		{"g", 0, true},
+		{"lots", 0, true},
+		{"-1g", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := parseMemory(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMemory(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseMemory(%q) = %d, want %d", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveResources(t *testing.T) {
+	cfg := config.Config{
+		Resources: config.Resources{CPUs: 2, Memory: "4g"},
+		Tools: map[string]config.ToolConfig{
+			"claude": {Resources: config.Resources{Memory: "8g", PidsLimit: 1024}},
+		},
+	}
+	repoMatches := []RepoMatch{
+		{Name: "github.com/org", Config: config.RepoConfig{Resources: config.Resources{CPUs: 6}}},
+	}
+
+	got, err := resolveResources("claude", cfg, repoMatches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := backend.Resources{CPUs: 6, MemoryBytes: 8 << 30, PidsLimit: 1024, InotifyWatches: defaultInotifyWatches}
+	if got != want {
+		t.Errorf("resolveResources() = %+v, want %+v", got, want)
+	}
+
+	got, err = resolveResources("opencode", cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = backend.Resources{CPUs: 2, MemoryBytes: 4 << 30, InotifyWatches: defaultInotifyWatches}
+	if got != want {
+		t.Errorf("resolveResources() = %+v, want %+v", got, want)
+	}
+
+	cfg.Resources.Nofile = 4096
+	cfg.Resources.InotifyWatches = 65536
+	got, err = resolveResources("opencode", cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Nofile != 4096 || got.InotifyWatches != 65536 {
+		t.Errorf("expected configured limits, got %+v", got)
+	}
+
+	cfg.Resources.Swap = "bogus"
+	if _, err := resolveResources("opencode", cfg, nil); err == nil {
+		t.Error("expected error for invalid swap")
+	}
+
+	cfg.Resources = config.Resources{Swap: "1g"}
+	if _, err := resolveResources("opencode", cfg, nil); err == nil {
+		t.Error("expected error for swap without memory")
+	}
+}
+
+func TestResolveNetwork(t *testing.T) {
+	cfg := config.Config{
+		Network:      "allowlist",
+		NetworkAllow: []string{"github.com"},
+		Tools: map[string]config.ToolConfig{
+			"claude":  {NetworkAllow: []string{"api.anthropic.com", "github.com"}},
+			"copilot": {Network: "none"},
+		},
+	}
+
+	got, err := resolveNetwork("claude", cfg, []RepoMatch{
+		{Name: "github.com/org", Config: config.RepoConfig{NetworkAllow: []string{"*.example.com"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Mode != backend.NetworkAllowlist {
+		t.Errorf("expected allowlist, got %q", got.Mode)
+	}
+	if want := []string{"*.example.com", "api.anthropic.com", "github.com"}; !slices.Equal(got.Allow, want) {
+		t.Errorf("expected allow %v, got %v", want, got.Allow)
+	}
+
+	got, err = resolveNetwork("copilot", cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Mode != backend.NetworkNone || got.Allow != nil {
+		t.Errorf("expected none with no hosts, got %+v", got)
+	}
+
+	got, err = resolveNetwork("claude", cfg, []RepoMatch{
+		{Name: "github.com/org", Config: config.RepoConfig{Network: "full"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Mode != backend.NetworkFull {
+		t.Errorf("expected repo to override to full, got %q", got.Mode)
+	}
+
+	got, err = resolveNetwork("opencode", config.Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Mode != backend.NetworkFull {
+		t.Errorf("expected full by default, got %q", got.Mode)
+	}
+
+	got, err = resolveNetwork("claude", config.Config{NetworkJoin: "silo"}, []RepoMatch{
+		{Name: "github.com/org", Config: config.RepoConfig{NetworkJoin: "compose_default"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Mode != backend.NetworkFull || got.Join != "compose_default" {
+		t.Errorf("expected repo to override joined network, got %+v", got)
+	}
+
+	for _, bad := range []config.Config{
+		{Network: "offline"},
+		{Network: "allowlist"},
+		{Network: "allowlist", NetworkAllow: []string{"https://github.com"}},
+		{Network: "none", NetworkJoin: "silo"},
+	} {
+		if _, err := resolveNetwork("opencode", bad, nil); err == nil {
+			t.Errorf("expected error for %+v", bad)
+		}
+	}
+}
+
+func TestUpdateNotice(t *testing.T) {
+	entries := []journal.Entry{
+		{Tool: "claude", TemplateHash: "aaa"},
+		{Tool: "opencode", TemplateHash: "bbb"},
+		{Tool: "copilot"},
+	}
+
+	tests := []struct {
+		name    string
+		tool    string
+		hash    string
+		version string
+		want    string
+	}{
+		{"unchanged", "claude", "aaa", "dev", ""},
+		{"first run", "other", "aaa", "dev", ""},
+		{"untracked", "copilot", "aaa", "dev", ""},
+		{"changed dev", "claude", "ccc", "dev", "https://github.com/leighmcculloch/silo/commits/main"},
+		{"changed release", "opencode", "ccc", "1.2.0", "https://github.com/leighmcculloch/silo/releases/tag/v1.2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := updateNotice(entries, tt.tool, tt.hash, tt.version)
+			if tt.want == "" {
+				if got != "" {
+					t.Errorf("expected no notice, got %q", got)
+				}
+				return
+			}
+			if !strings.Contains(got, tt.want) || !strings.Contains(got, tt.tool) {
+				t.Errorf("expected notice for %s mentioning %s, got %q", tt.tool, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCheckAuth(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	auth := tools.Auth{
+		Env:          []string{"API_KEY"},
+		LoginCommand: []string{"tool", "login"},
+	}
+	hooks := []string{"echo hi"}
+
+	tests := []struct {
+		name   string
+		env    []string
+		detach bool
+		want   []string
+	}{
+		{"logged in", []string{"API_KEY=x"}, false, []string{"echo hi"}},
+		{"logged out", nil, false, []string{"echo hi", "tool login"}},
+		{"logged out detached", nil, true, []string{"echo hi"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkAuth(io.Discard, "tool", auth, tt.env, hooks, tt.detach)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("hooks = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if len(hooks) != 1 {
+		t.Errorf("input hooks modified: %v", hooks)
+	}
+}
+
+func TestWithSecrets(t *testing.T) {
+	envVars := []string{"A=env", "B=env", "PATH_LIKE=x=y"}
+	got := withSecrets(envVars, []string{"B=secret"})
+	want := []string{"A=env", "PATH_LIKE=x=y", "B=secret"}
+	if !slices.Equal(got, want) {
+		t.Errorf("withSecrets = %v, want %v", got, want)
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	cfg := config.Config{
+		Secrets: map[string]config.Secret{"A": {Keychain: "global"}, "B": {Keychain: "global"}},
+		Tools: map[string]config.ToolConfig{
+			"claude": {Secrets: map[string]config.Secret{"B": {Keychain: "tool"}}},
+		},
+	}
+	repoMatches := []RepoMatch{
+		{Name: "github.com/org", Config: config.RepoConfig{Secrets: map[string]config.Secret{"A": {Op: "op://repo"}}}},
+	}
+
+	got := resolveSecrets("claude", cfg, repoMatches)
+	if got["A"] != (config.Secret{Op: "op://repo"}) {
+		t.Errorf("A = %+v, want repo secret", got["A"])
+	}
+	if got["B"] != (config.Secret{Keychain: "tool"}) {
+		t.Errorf("B = %+v, want tool secret", got["B"])
+	}
+	if cfg.Secrets["A"] != (config.Secret{Keychain: "global"}) {
+		t.Errorf("global secrets modified: %+v", cfg.Secrets)
+	}
+}
+
+func TestResolveDockerfile(t *testing.T) {
+	template := "FROM ubuntu:24.04 AS base\nRUN true\n\nFROM base AS claude\n"
+
+	got, err := resolveDockerfile(template, config.Config{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != template {
+		t.Errorf("expected template unchanged, got %q", got)
+	}
+
+	got, err = resolveDockerfile(template, config.Config{BaseImage: "ghcr.io/org/dev:latest"}, []RepoMatch{
+		{Name: "github.com/org/repo", Config: config.RepoConfig{BaseImage: "ghcr.io/org/repo-dev:1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "FROM ghcr.io/org/repo-dev:1 AS base\nRUN true\n\nFROM base AS claude\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "silo.Dockerfile")
+	custom := "FROM --platform=linux/amd64 debian:12 AS base\nFROM base\n"
+	if err := os.WriteFile(path, []byte(custom), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err = resolveDockerfile(template, config.Config{Dockerfile: path, BaseImage: "debian:13"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "FROM debian:13 AS base\nFROM base\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+
+	noBase := filepath.Join(dir, "nobase.Dockerfile")
+	if err := os.WriteFile(noBase, []byte("FROM debian:12\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range []config.Config{
+		{Dockerfile: filepath.Join(dir, "missing")},
+		{BaseImage: "ubuntu:24.04\nRUN evil"},
+		{Dockerfile: noBase, BaseImage: "debian:13"},
+	} {
+		if _, err := resolveDockerfile(template, bad, nil); err == nil {
+			t.Errorf("expected error for %+v", bad)
+		}
+	}
+}
+
+func TestSpliceBuildStages(t *testing.T) {
+	template := "FROM ubuntu:24.04 AS base\nRUN true\n\nFROM base AS claude\nRUN claude\n"
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	rust := write("rust.Dockerfile", "ARG RUST_VERSION=1.90\nRUN rustup default $RUST_VERSION\n")
+	tools := write("tools.Dockerfile", "COPY --from=ghcr.io/org/tools:1 /bin/tool /usr/local/bin/\n")
+
+	got, err := spliceBuildStages(template, "claude", map[string]string{"tools": tools, "rust": rust})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "FROM ubuntu:24.04 AS base\nRUN true\n\n" +
+		"FROM base AS rust\nARG RUST_VERSION=1.90\nRUN rustup default $RUST_VERSION\n\n" +
+		"FROM rust AS tools\nCOPY --from=ghcr.io/org/tools:1 /bin/tool /usr/local/bin/\n\n" +
+		"FROM tools AS claude\nRUN claude\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	for _, stages := range []map[string]string{
+		{"Rust": rust},
+		{"base": rust},
+		{"rust": filepath.Join(dir, "missing")},
+		{"rust": write("from.Dockerfile", "FROM alpine\n")},
+	} {
+		if _, err := spliceBuildStages(template, "claude", stages); err == nil {
+			t.Errorf("expected error for %v", stages)
+		}
+	}
+	// Flags of the tool's FROM are kept
+	got, err = spliceBuildStages("FROM ubuntu AS base\n\nFROM --platform=linux/amd64 base AS claude\n", "claude", map[string]string{"rust": rust})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(got, "FROM --platform=linux/amd64 rust AS claude\n") {
+		t.Errorf("tool stage lost its flags:\n%s", got)
+	}
+
+	if _, err := spliceBuildStages(template, "opencode", map[string]string{"rust": rust}); err == nil {
+		t.Error("expected error for a Dockerfile without the tool's stage")
+	}
+}
+
+func TestBuildTarget(t *testing.T) {
+	if got := buildTarget("FROM ubuntu AS base\nFROM base AS claude\n", "claude"); got != "claude" {
+		t.Errorf("expected claude, got %q", got)
+	}
+	if got := buildTarget("FROM ubuntu AS base\nFROM base\n", "claude"); got != "" {
+		t.Errorf("expected last stage, got %q", got)
+	}
+}
+
+func TestParsePort(t *testing.T) {
+	tests := []struct {
+		spec string
+		want backend.Port
+	}{
+		{"3000", backend.Port{HostIP: "127.0.0.1", HostPort: 3000, ContainerPort: 3000, Protocol: "tcp"}},
+		{"8080:80", backend.Port{HostIP: "127.0.0.1", HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}},
+		{"0.0.0.0:8080:80/udp", backend.Port{HostIP: "0.0.0.0", HostPort: 8080, ContainerPort: 80, Protocol: "udp"}},
+		{"[::1]:8080:80", backend.Port{HostIP: "::1", HostPort: 8080, ContainerPort: 80, Protocol: "tcp"}},
+	}
+	for _, tt := range tests {
+		got, err := parsePort(tt.spec)
+		if err != nil {
+			t.Errorf("parsePort(%q): %v", tt.spec, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parsePort(%q) = %+v, want %+v", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "http", "0", "70000", "80/sctp", "host:8080:80", "1:2:3:4"} {
+		if _, err := parsePort(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestResolvePorts(t *testing.T) {
+	cfg := config.Config{
+		Ports: []string{"3000"},
+		Tools: map[string]config.ToolConfig{
+			"claude": {Ports: []string{"8080:80", "3000"}},
+		},
+	}
+	full := backend.Network{Mode: backend.NetworkFull}
+
+	got, err := resolvePorts("claude", cfg, []RepoMatch{
+		{Name: "github.com/org", Config: config.RepoConfig{Ports: []string{"5173"}}},
+	}, full)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var specs []string
+	for _, p := range got {
+		specs = append(specs, p.String())
+	}
+	if want := []string{"127.0.0.1:3000:3000/tcp", "127.0.0.1:8080:80/tcp", "127.0.0.1:5173:5173/tcp"}; !slices.Equal(specs, want) {
+		t.Errorf("expected %v, got %v", want, specs)
+	}
+
+	if _, err := resolvePorts("opencode", config.Config{Ports: []string{"8080:80", "8080:81"}}, nil, full); err == nil {
+		t.Error("expected error for a host port published twice")
+	}
+	if _, err := resolvePorts("opencode", cfg, nil, backend.Network{Mode: backend.NetworkNone}); err == nil {
+		t.Error("expected error for ports without full network")
+	}
+}
+
+func TestResolveTmpfs(t *testing.T) {
+	cfg := config.Config{
+		Tmpfs: []string{"~/.cache/go-build"},
+		Tools: map[string]config.ToolConfig{
+			"claude": {Tmpfs: []string{"/var/tmp/", "~/.cache/go-build"}},
+		},
+	}
+	got, err := resolveTmpfs("claude", cfg, []RepoMatch{
+		{Name: "github.com/org", Config: config.RepoConfig{Tmpfs: []string{"~"}}},
+	}, "/home/u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/home/u/.cache/go-build", "/var/tmp", "/home/u"}; !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	for _, p := range []string{"cache", "~user/cache", "/"} {
+		if _, err := resolveTmpfs("claude", config.Config{Tmpfs: []string{p}}, nil, "/home/u"); err == nil {
+			t.Errorf("expected error for %q", p)
+		}
+	}
+}
+
+func TestResolveCacheVolumes(t *testing.T) {
+	cfg := config.Config{
+		CacheVolumes: map[string]string{"gomod": "~/go/pkg/mod", "npm": "~/.npm"},
+		Tools: map[string]config.ToolConfig{
+			"claude": {CacheVolumes: map[string]string{"npm": "/var/cache/npm/"}},
+		},
+	}
+	got, err := resolveCacheVolumes("claude", cfg, []RepoMatch{
+		{Name: "github.com/org", Config: config.RepoConfig{CacheVolumes: map[string]string{"cargo": "~/.cargo/registry"}}},
+	}, "/home/u")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []backend.Mount{
+		{Source: "silo-cache-cargo", Target: "/home/u/.cargo/registry"},
+		{Source: "silo-cache-gomod", Target: "/home/u/go/pkg/mod"},
+		{Source: "silo-cache-npm", Target: "/var/cache/npm"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	for _, volumes := range []map[string]string{
+		{"bad/name": "/cache"},
+		{"rel": "cache"},
+		{"root": "/"},
+		{"a": "/cache", "b": "/cache/"},
+	} {
+		if _, err := resolveCacheVolumes("claude", config.Config{CacheVolumes: volumes}, nil, "/home/u"); err == nil {
+			t.Errorf("expected error for %v", volumes)
+		}
+	}
+}
+
+func TestResolvePlatform(t *testing.T) {
+	cfg := config.Config{
+		Platform: "linux/arm64",
+		Tools:    map[string]config.ToolConfig{"copilot": {Platform: "linux/amd64"}},
+	}
+	for _, tt := range []struct {
+		override, tool, want string
+	}{
+		{"", "claude", "linux/arm64"},
+		{"", "copilot", "linux/amd64"},
+		{"linux/arm/v7", "copilot", "linux/arm/v7"},
+	} {
+		got, err := resolvePlatform(tt.override, tt.tool, cfg)
+		if err != nil || got != tt.want {
+			t.Errorf("resolvePlatform(%q, %q) = %q, %v, want %q", tt.override, tt.tool, got, err, tt.want)
+		}
+	}
+	for _, p := range []string{"amd64", "darwin/arm64", "linux/"} {
+		if _, err := resolvePlatform(p, "claude", cfg); err == nil {
+			t.Errorf("expected error for %q", p)
+		}
+	}
+}
+
+func TestBuildImageTagPlatform(t *testing.T) {
+	args := map[string]string{"USER": "u"}
+	native := buildImageTag("claude", "FROM x", args, "")
+	if buildImageTag("claude", "FROM x", args, "linux/amd64") == native {
+		t.Error("expected the platform to change the tag")
+	}
+}
+
+func TestEmulationHint(t *testing.T) {
+	for _, tt := range []struct{ backendType, goos, want string }{
+		{"container", "darwin", "Rosetta"},
+		{"docker", "darwin", "Docker Desktop"},
+		{"docker", "linux", "binfmt"},
+	} {
+		got := emulationHint(tt.backendType, tt.goos, "arm64")
+		if !strings.Contains(got, tt.want) || !strings.Contains(got, "linux/arm64") {
+			t.Errorf("emulationHint(%q, %q) = %q", tt.backendType, tt.goos, got)
+		}
+	}
+}
+
+func TestPrebuiltImage(t *testing.T) {
+	df := "FROM ubuntu AS base\n"
+	if got := PrebuiltImage("", df); got != "" {
+		t.Errorf("expected no image by default, got %q", got)
+	}
+	if got := PrebuiltImage("example.com/silo", df); got != "example.com/silo:base-"+TemplateHash(df) {
+		t.Errorf("unexpected ref %q", got)
+	}
+	if got := PrebuiltImage("none", df); got != "" {
+		t.Errorf("expected no image, got %q", got)
+	}
+}
+
+func TestAptSnapshot(t *testing.T) {
+	now := time.Date(2026, 10, 17, 23, 30, 0, 0, time.FixedZone("", -5*60*60))
+	got, err := aptSnapshot("", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 23:30 at UTC-5 is already the next day in UTC, but the same month
+	if want := "20261001T000000Z"; got.Format(aptSnapshotLayout) != want {
+		t.Errorf("expected %s, got %s", want, got.Format(aptSnapshotLayout))
+	}
+
+	got, err = aptSnapshot("20250301T120000Z", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	if _, err := aptSnapshot("2025-03-01", now); err == nil {
+		t.Error("expected error for invalid snapshot")
+	}
+}
+
+func TestResolveEphemeral(t *testing.T) {
+	t.Setenv("HOME", "/home/u")
+	cfg := config.Config{Tools: map[string]config.ToolConfig{
+		"claude": {
+			MountsRW:  []string{"~/.claude", "~/.claude.json"},
+			Ephemeral: true,
+			SyncBack:  []string{"~/.claude/projects"},
+		},
+		"opencode": {MountsRW: []string{"~/.opencode"}, SyncBack: []string{"~/.opencode/x"}},
+		"copilot":  {MountsRW: []string{"~/.copilot"}, Ephemeral: true, SyncBack: []string{"~/.copilot-other"}},
+	}}
+
+	mounts, syncBack, err := resolveEphemeral("claude", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/home/u/.claude", "/home/u/.claude.json"}; !slices.Equal(mounts, want) {
+		t.Errorf("mounts = %v, want %v", mounts, want)
+	}
+	if want := []string{"/home/u/.claude/projects"}; !slices.Equal(syncBack, want) {
+		t.Errorf("sync back = %v, want %v", syncBack, want)
+	}
+
+	if mounts, _, err := resolveEphemeral("opencode", cfg); err != nil || mounts != nil {
+		t.Errorf("expected no mounts for a tool that isn't ephemeral, got %v, %v", mounts, err)
+	}
+	if _, _, err := resolveEphemeral("copilot", cfg); err == nil {
+		t.Error("expected error for a sync_back path outside the tool's mounts")
+	}
+}
+
+func TestStageEphemeralAndSyncBack(t *testing.T) {
+	home := t.TempDir()
+	claudeDir := filepath.Join(home, ".claude")
+	claudeJSON := filepath.Join(home, ".claude.json")
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(claudeDir, ".credentials.json"), "secret")
+	write(filepath.Join(claudeDir, "projects", "a.jsonl"), "old")
+	write(claudeJSON, "{}")
+
+	staging := filepath.Join(t.TempDir(), "staging")
+	mapped, err := stageEphemeral(staging, []string{claudeDir, claudeJSON, filepath.Join(home, "missing")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mapped) != 2 {
+		t.Fatalf("expected 2 mapped mounts, got %v", mapped)
+	}
+	for _, m := range mapped {
+		if filepath.Base(m.Source) != filepath.Base(m.Target) || !strings.HasPrefix(m.Source, staging) {
+			t.Errorf("unexpected mount %+v", m)
+		}
+	}
+
+	// The session corrupts the credentials and writes history
+	write(filepath.Join(mapped[0].Source, ".credentials.json"), "corrupted")
+	write(filepath.Join(mapped[0].Source, "projects", "a.jsonl"), "new")
+	write(filepath.Join(mapped[0].Source, "projects", "b.jsonl"), "b")
+
+	if err := syncBack(mapped, []string{filepath.Join(claudeDir, "projects"), filepath.Join(claudeDir, "todos")}); err != nil {
+		t.Fatal(err)
+	}
+	for path, want := range map[string]string{
+		".credentials.json": "secret",
+		"projects/a.jsonl":  "new",
+		"projects/b.jsonl":  "b",
+	} {
+		data, err := os.ReadFile(filepath.Join(claudeDir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", path, data, want)
+		}
+	}
+}
+
+func TestIsolate(t *testing.T) {
+	t.Setenv("HOME", "/home/u")
+	cfg := config.Config{Tools: map[string]config.ToolConfig{
+		"claude":   {MountsRW: []string{"~/.claude"}, Isolate: []string{"~/.claude/projects", "~/.claude/todos"}},
+		"opencode": {MountsRW: []string{"~/.opencode"}, Isolate: []string{"~/.claude/projects"}},
+	}}
+	paths, err := resolveIsolate("claude", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/home/u/.claude/projects", "/home/u/.claude/todos"}; !slices.Equal(paths, want) {
+		t.Errorf("paths = %v, want %v", paths, want)
+	}
+	if _, err := resolveIsolate("opencode", cfg); err == nil {
+		t.Error("expected error for an isolate path outside the tool's mounts")
+	}
+
+	// Each repository has a directory of its own, the same for each session
+	a, b := IsolatedDir("claude", "/src/a"), IsolatedDir("claude", "/other/a")
+	if a == b || a != IsolatedDir("claude", "/src/a") || !strings.HasPrefix(filepath.Base(a), "a-") {
+		t.Errorf("got isolated dirs %s and %s", a, b)
+	}
+
+	home := t.TempDir()
+	dir := t.TempDir()
+	projects := filepath.Join(home, ".claude", "projects")
+	mapped, err := stageIsolated(dir, []string{projects})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mapped) != 1 || mapped[0].Target != projects || mapped[0].Source != filepath.Join(dir, projects) {
+		t.Fatalf("mapped = %+v", mapped)
+	}
+	if info, err := os.Stat(mapped[0].Source); err != nil || !info.IsDir() {
+		t.Errorf("expected the isolated directory created, got %v", err)
+	}
+
+	file := filepath.Join(home, ".claude.json")
+	if err := os.WriteFile(file, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stageIsolated(dir, []string{file}); err == nil {
+		t.Error("expected error isolating a file")
+	}
+}
+
+func TestBackendCandidates(t *testing.T) {
+	got := backendCandidates("container", []string{"docker", "container", "docker"})
+	if !slices.Equal(got, []string{"container", "docker"}) {
+		t.Errorf("unexpected candidates %v", got)
+	}
+}
+
+func TestSelectAvailableBackendNoneAvailable(t *testing.T) {
+	var stderr bytes.Buffer
+	_, _, err := selectAvailableBackend(context.Background(), "bogus", []string{"other"}, 0, &stderr, false, nil)
+	if err == nil || !strings.Contains(err.Error(), "tried bogus, other") {
+		t.Fatalf("expected no backend available error, got %v", err)
+	}
+	if !strings.Contains(stderr.String(), "bogus backend is not available") {
+		t.Errorf("expected warning for bogus backend, got %q", stderr.String())
+	}
+}
+
+func TestValidateUser(t *testing.T) {
+	tests := []struct {
+		user User
+		err  string
+	}{
+		{User{Name: "leigh", Home: "/Users/leigh", UID: 501}, ""},
+		{User{Name: "first.last", Home: "/home/first.last", UID: 1000}, ""},
+		{User{Name: "root", Home: "/root", UID: 0}, "cannot run as root"},
+		{User{Name: "", Home: "/home/x", UID: 1000}, "invalid user name"},
+		{User{Name: "a b", Home: "/home/x", UID: 1000}, "invalid user name"},
+		{User{Name: "x", Home: "home/x", UID: 1000}, "invalid home directory"},
+		{User{Name: "x", Home: "/", UID: 1000}, "invalid home directory"},
+	}
+	for _, tt := range tests {
+		err := validateUser(tt.user)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("validateUser(%+v) = %v, want nil", tt.user, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("validateUser(%+v) = %v, want error containing %q", tt.user, err, tt.err)
+		}
+	}
+}
+
+func TestResolveUserOverrides(t *testing.T) {
+	if os.Getuid() == 0 {
+		t.Skip("ResolveUser refuses to run as root")
+	}
+	t.Setenv("HOME", "")
+	t.Setenv("USER", "")
+	u, err := ResolveUser("someone", "/home/someone/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name != "someone" || u.Home != "/home/someone" {
+		t.Errorf("got %+v", u)
+	}
+
+	// Missing values come from the user database
+	u, err = ResolveUser("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Name == "" || u.Home == "" {
+		t.Errorf("expected fallback from the user database, got %+v", u)
+	}
+}
+
+func TestImageUser(t *testing.T) {
+	host := User{Name: "leigh", Home: "/home/leigh", UID: 1234, GID: 1234}
+	u, err := imageUser(host, config.ContainerUser{})
+	if err != nil || u != host {
+		t.Errorf("imageUser = %+v, %v, want the host user", u, err)
+	}
+	u, err = imageUser(host, config.ContainerUser{Name: "agent", UID: 1000})
+	if err != nil || u != (User{Name: "agent", Home: "/home/leigh", UID: 1000, GID: 1234}) {
+		t.Errorf("imageUser = %+v, %v, want the name and uid replaced", u, err)
+	}
+	for _, cu := range []config.ContainerUser{{Name: "a b"}, {UID: -1}} {
+		if _, err := imageUser(host, cu); err == nil {
+			t.Errorf("imageUser(%+v) = nil error, want invalid", cu)
+		}
+	}
+
+	if got, err := resolveUserns(UsernsHost); err != nil || got != "host" {
+		t.Errorf("resolveUserns(host) = %q, %v", got, err)
+	}
+	if _, err := resolveUserns("private"); err == nil {
+		t.Error("expected an invalid userns to be rejected")
+	}
+}
+
+func TestResolveProfile(t *testing.T) {
+	cfg := config.Config{Profile: "permissive"}
+	repos := []RepoMatch{{Name: "org/a", Config: config.RepoConfig{Profile: "strict"}}}
+
+	tests := []struct {
+		override string
+		repos    []RepoMatch
+		want     string
+	}{
+		{"", nil, ProfilePermissive},
+		{"", repos, ProfileStrict},
+		{"standard", repos, ProfileStandard},
+	}
+	for _, tt := range tests {
+		got, err := resolveProfile(tt.override, cfg, tt.repos)
+		if err != nil || got != tt.want {
+			t.Errorf("resolveProfile(%q) = %q, %v, want %q", tt.override, got, err, tt.want)
+		}
+	}
+
+	if got, err := resolveProfile("", config.Config{}, nil); err != nil || got != ProfileStandard {
+		t.Errorf("expected standard by default, got %q, %v", got, err)
+	}
+	if _, err := resolveProfile("paranoid", cfg, nil); err == nil {
+		t.Error("expected error for unknown profile")
+	}
+}
+
+func TestWithConfigProfile(t *testing.T) {
+	cfg := config.Config{
+		Network: "full",
+		Repos:   map[string]config.RepoConfig{"org/a": {Network: "allowlist", NetworkAllow: []string{"example.com"}}},
+		Profiles: map[string]config.RepoConfig{
+			"offline": {Network: "none"},
+			"locked":  {Profile: "strict"},
+		},
+	}
+	repos := matchRepos(cfg, []string{"git@github.com:org/a.git"})
+
+	matches, sandbox := withConfigProfile(cfg, "offline", repos)
+	if sandbox != "" || len(matches) != 2 || matches[1].Name != "profile offline" {
+		t.Fatalf("withConfigProfile(offline) = %v, %q, want the profile appended", matches, sandbox)
+	}
+	network, err := resolveNetwork("claude", cfg, matches)
+	if err != nil || network.Mode != backend.NetworkNone {
+		t.Errorf("expected the profile to apply over the repo, got %v, %v", network, err)
+	}
+
+	matches, sandbox = withConfigProfile(cfg, "locked", nil)
+	if got, err := resolveProfile(sandbox, cfg, matches); err != nil || got != ProfileStrict {
+		t.Errorf("expected the sandbox profile of the config profile, got %q, %v", got, err)
+	}
+
+	matches, sandbox = withConfigProfile(cfg, "strict", repos)
+	if sandbox != "strict" || len(matches) != 1 {
+		t.Errorf("withConfigProfile(strict) = %v, %q, want a sandbox profile", matches, sandbox)
+	}
+	if _, err := resolveProfile("gpu", cfg, nil); err == nil || !strings.Contains(err.Error(), "locked, offline") {
+		t.Errorf("expected an error listing the config profiles, got %v", err)
+	}
+}
+
+func TestResolveDockerInDocker(t *testing.T) {
+	cfg := config.Config{DockerInDocker: config.DockerInDockerRootful}
+	if got := resolveDockerInDocker(cfg, nil); got != backend.DockerInDockerRootful {
+		t.Errorf("resolveDockerInDocker() = %q, want rootful", got)
+	}
+	repos := []RepoMatch{
+		{Name: "org", Config: config.RepoConfig{DockerInDocker: config.DockerInDockerRootless}},
+		{Name: "org/a", Config: config.RepoConfig{DockerInDocker: config.DockerInDockerOff}},
+	}
+	if got := resolveDockerInDocker(cfg, repos[:1]); got != backend.DockerInDockerRootless {
+		t.Errorf("resolveDockerInDocker() = %q, want the repo's rootless", got)
+	}
+	if got := resolveDockerInDocker(cfg, repos); got != "" {
+		t.Errorf("resolveDockerInDocker() = %q, want the most specific repo to turn it off", got)
+	}
+	if got := resolveDockerInDocker(config.Config{}, nil); got != "" {
+		t.Errorf("resolveDockerInDocker() = %q, want off by default", got)
+	}
+	if got := profileDockerInDocker(ProfileStrict, backend.DockerInDockerRootful); got != "" {
+		t.Errorf("expected strict to never run Docker in the container, got %q", got)
+	}
+}
+
+func TestResolveWorkspaces(t *testing.T) {
+	root := t.TempDir()
+	cwd := filepath.Join(root, "app")
+	lib := filepath.Join(root, "lib")
+	docs := filepath.Join(root, "docs")
+	for _, d := range []string{cwd, lib, docs} {
+		os.Mkdir(d, 0o755)
+	}
+
+	cfg := config.Config{ExtraWorkspaces: []string{"../lib", cwd}}
+	repos := []RepoMatch{{Name: "org/app", Config: config.RepoConfig{ExtraWorkspaces: []string{docs, lib}}}}
+	got, err := resolveWorkspaces(cfg, repos, cwd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{lib, docs}; !slices.Equal(got, want) {
+		t.Errorf("resolveWorkspaces() = %v, want %v without duplicates or the current directory", got, want)
+	}
+
+	cfg = config.Config{ExtraWorkspaces: []string{"../missing"}}
+	if _, err := resolveWorkspaces(cfg, nil, cwd); err == nil {
+		t.Error("expected an error for a workspace that doesn't exist")
+	}
+}
+
+func TestResolveSSHAgent(t *testing.T) {
+	on, off := true, false
+	if resolveSSHAgent(config.Config{}, nil) {
+		t.Error("expected the SSH agent not to be forwarded by default")
+	}
+	cfg := config.Config{SSHAgent: &on}
+	if !resolveSSHAgent(cfg, nil) {
+		t.Error("expected ssh_agent to forward the SSH agent")
+	}
+	repos := []RepoMatch{
+		{Name: "org", Config: config.RepoConfig{SSHAgent: &off}},
+		{Name: "org/a", Config: config.RepoConfig{SSHAgent: &on}},
+		{Name: "org/a/b", Config: config.RepoConfig{}},
+	}
+	if resolveSSHAgent(cfg, repos[:1]) {
+		t.Error("expected the repo to turn the SSH agent off")
+	}
+	if !resolveSSHAgent(config.Config{}, repos) {
+		t.Error("expected the most specific repo setting it to turn the SSH agent on")
+	}
+}
+
+func TestReadOnlyRootfs(t *testing.T) {
+	on, off := true, false
+	if resolveReadOnlyRootfs(config.Config{}, nil) {
+		t.Error("expected the root filesystem writable by default")
+	}
+	cfg := config.Config{ReadOnlyRootfs: &on}
+	if !resolveReadOnlyRootfs(cfg, nil) {
+		t.Error("expected read_only_rootfs to make the root filesystem read-only")
+	}
+	if resolveReadOnlyRootfs(cfg, []RepoMatch{{Name: "org", Config: config.RepoConfig{ReadOnlyRootfs: &off}}}) {
+		t.Error("expected the repo to make the root filesystem writable")
+	}
+
+	rc := runConfig{home: "/home/u", tmpfs: []string{"/tmp", "/scratch"}, readOnlyRootfs: true}
+	if got, want := runTmpfs(rc, "docker"), []string{"/tmp", "/scratch", "/var/tmp", "/home/u/.cache", "/home/u/.npm"}; !slices.Equal(got, want) {
+		t.Errorf("tmpfs = %v, want %v", got, want)
+	}
+	if got := runTmpfs(rc, "sandbox"); !slices.Equal(got, rc.tmpfs) {
+		t.Errorf("sandbox tmpfs = %v, want only those configured", got)
+	}
+	rc.readOnlyRootfs = false
+	if got := runTmpfs(rc, "docker"); !slices.Equal(got, rc.tmpfs) {
+		t.Errorf("tmpfs = %v, want only those configured with a writable root", got)
+	}
+}
+
+func TestSeccomp(t *testing.T) {
+	profile := filepath.Join(t.TempDir(), "seccomp.json")
+	os.WriteFile(profile, []byte("{}"), 0o600)
+	tests := []struct {
+		global, repo string
+		want         string
+		wantErr      bool
+	}{
+		{"", "", SeccompSilo, false},
+		{SeccompUnconfined, "", SeccompUnconfined, false},
+		{SeccompUnconfined, SeccompDefault, SeccompDefault, false},
+		{"", profile, profile, false},
+		{"seccomp.json", "", "", true},
+		{profile + ".missing", "", "", true},
+	}
+	for _, tt := range tests {
+		got, err := resolveSeccomp(config.Config{SeccompProfile: tt.global}, []RepoMatch{{Name: "org", Config: config.RepoConfig{SeccompProfile: tt.repo}}})
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("resolveSeccomp(%q, %q) = %q, %v, want %q", tt.global, tt.repo, got, err, tt.want)
+		}
+	}
+	if got := resolveAppArmor(config.Config{AppArmorProfile: "a"}, []RepoMatch{{Name: "org", Config: config.RepoConfig{AppArmorProfile: "b"}}}); got != "b" {
+		t.Errorf("resolveAppArmor = %q, want the repo's profile", got)
+	}
+
+	statedir.Set(t.TempDir())
+	t.Cleanup(func() { statedir.Set("") })
+	var warnings []string
+	warn := func(format string, args ...any) { warnings = append(warnings, fmt.Sprintf(format, args...)) }
+
+	seccomp, appArmor, err := securityProfiles(runConfig{seccomp: SeccompSilo, appArmor: "agent"}, "docker", warn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(seccomp); err != nil || !bytes.Equal(data, backend.SeccompProfile) || appArmor != "agent" {
+		t.Errorf("profiles = %q, %q, want silo's seccomp profile written", seccomp, appArmor)
+	}
+	if seccomp, _, _ := securityProfiles(runConfig{seccomp: SeccompDefault}, "docker", warn); seccomp != "" {
+		t.Errorf("seccomp = %q, want the runtime's default", seccomp)
+	}
+	if len(warnings) > 0 {
+		t.Errorf("warnings = %q, want none on docker", warnings)
+	}
+
+	if seccomp, _, _ := securityProfiles(runConfig{seccomp: SeccompSilo}, "sandbox", warn); seccomp != "" || len(warnings) > 0 {
+		t.Errorf("seccomp = %q, warnings = %q, want the default dropped silently", seccomp, warnings)
+	}
+	securityProfiles(runConfig{seccomp: profile}, "container", warn)
+	securityProfiles(runConfig{seccomp: SeccompSilo, appArmor: "agent", dockerInDocker: backend.DockerInDockerRootful}, "docker", warn)
+	if len(warnings) != 2 {
+		t.Errorf("warnings = %q, want configured profiles that aren't applied warned about", warnings)
+	}
+}
+
+// imagesBackend is a backend with only the images listed, which panics if
+// anything but ImageExists is called.
+type imagesBackend struct {
+	backend.Backend
+	images []string
+}
+
+func (b imagesBackend) ImageExists(_ context.Context, name string) (bool, error) {
+	return slices.Contains(b.images, name), nil
+}
+
+func TestCheckOffline(t *testing.T) {
+	b := imagesBackend{images: []string{"postgres:16"}}
+	services := []backend.Service{{Name: "db", Image: "postgres:16"}}
+	if err := checkOffline(context.Background(), b, "claude", true, services); err != nil {
+		t.Errorf("checkOffline = %v, want nil with everything present", err)
+	}
+	if err := checkOffline(context.Background(), b, "claude", false, nil); err == nil || !strings.Contains(err.Error(), "silo prefetch claude") {
+		t.Errorf("checkOffline = %v, want the missing image pointing at silo prefetch", err)
+	}
+	services = append(services, backend.Service{Name: "cache", Image: "redis:7"})
+	if err := checkOffline(context.Background(), b, "claude", true, services); err == nil || !strings.Contains(err.Error(), "redis:7") {
+		t.Errorf("checkOffline = %v, want the missing service image", err)
+	}
+}
+
+func TestSelectBackend(t *testing.T) {
+	if got, _ := SelectBackend("container"); got != "container" {
+		t.Errorf("SelectBackend(container) = %q, want the configured backend", got)
+	}
+	if runtime.GOOS == "darwin" {
+		return
+	}
+	if got, _ := SelectBackend(""); got != "docker" {
+		t.Errorf("SelectBackend() = %q, want docker on %s", got, runtime.GOOS)
+	}
+}
+
+func TestResolveSELinuxLabel(t *testing.T) {
+	tests := []struct {
+		label   string
+		want    string
+		wantErr bool
+	}{
+		{"", "", false},
+		{SELinuxShared, backend.SELinuxShared, false},
+		{SELinuxPrivate, backend.SELinuxPrivate, false},
+		{SELinuxNone, "", false},
+		{"z", "", true},
+	}
+	for _, tt := range tests {
+		got, err := resolveSELinuxLabel(tt.label)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("resolveSELinuxLabel(%q) = %q, %v; want %q, error %v", tt.label, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	// Unset, enforcing SELinux on docker gets a hint rather than a relabel
+	if selinuxHint("", "docker", true) == "" {
+		t.Error("expected a hint when SELinux is enforcing and selinux_label is unset")
+	}
+	for _, tt := range []struct {
+		label, backendType string
+		enforcing          bool
+	}{
+		{"", "docker", false},
+		{"", "container", true},
+		{SELinuxNone, "docker", true},
+		{SELinuxShared, "docker", true},
+	} {
+		if hint := selinuxHint(tt.label, tt.backendType, tt.enforcing); hint != "" {
+			t.Errorf("selinuxHint(%q, %q, %v) = %q, want none", tt.label, tt.backendType, tt.enforcing, hint)
+		}
+	}
+}
+
+func TestProfileNetwork(t *testing.T) {
+	configured := backend.Network{Mode: backend.NetworkAllowlist, Allow: []string{"example.com"}, Join: "silo"}
+	if got := profileNetwork(ProfileStandard, configured); got.Mode != backend.NetworkAllowlist {
+		t.Errorf("standard changed the network: %+v", got)
+	}
+	if got := profileNetwork(ProfileStrict, configured); got.Mode != backend.NetworkNone || got.Join != "" {
+		t.Errorf("strict network = %+v", got)
+	}
+	if got := profileNetwork(ProfilePermissive, configured); got.Mode != backend.NetworkFull || got.Allow != nil || got.Join != "silo" {
+		t.Errorf("permissive network = %+v", got)
+	}
+}
+
+func TestStrictMounts(t *testing.T) {
+	ro, rw := strictMounts(
+		[]string{"/home/u/.gitconfig"},
+		[]string{"/work/repo", "/home/u/.claude", "/work/repo-main"},
+		[]string{"/work/repo", "/work/repo-main"},
+	)
+	if want := []string{"/home/u/.gitconfig", "/work/repo", "/work/repo-main"}; !slices.Equal(ro, want) {
+		t.Errorf("ro = %q, want %q", ro, want)
+	}
+	if want := []string{"/home/u/.claude"}; !slices.Equal(rw, want) {
+		t.Errorf("rw = %q, want %q", rw, want)
+	}
+}
+
+func TestResolveGitIdentity(t *testing.T) {
+	repo := func(id config.GitIdentity) RepoMatch {
+		return RepoMatch{Name: "org", Config: config.RepoConfig{GitIdentity: id}}
+	}
+	tests := []struct {
+		name      string
+		repos     []RepoMatch
+		wantName  string
+		wantEmail string
+		wantErr   bool
+	}{
+		{"host", nil, "Host", "host@example.com", false},
+		{"override", []RepoMatch{repo(config.GitIdentity{Name: "Bot", Email: "bot@example.com"})}, "Bot", "bot@example.com", false},
+		{"anonymous", []RepoMatch{repo(config.GitIdentity{Anonymous: true})}, "Anonymous", "anonymous@localhost", false},
+		{"anonymous with name", []RepoMatch{repo(config.GitIdentity{Anonymous: true, Name: "Agent"})}, "Agent", "anonymous@localhost", false},
+		{"merged", []RepoMatch{
+			repo(config.GitIdentity{Name: "Org Bot", Email: "bot@org.example"}),
+			repo(config.GitIdentity{Email: "repo-bot@org.example"}),
+		}, "Org Bot", "repo-bot@org.example", false},
+		{"name only", []RepoMatch{repo(config.GitIdentity{Name: "Bot"})}, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, email, err := resolveGitIdentity(tt.repos, "Host", "host@example.com")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if name != tt.wantName || email != tt.wantEmail {
+				t.Errorf("got %q <%s>, want %q <%s>", name, email, tt.wantName, tt.wantEmail)
+			}
+		})
+	}
+}
+
+func TestPullSharedPolicy(t *testing.T) {
+	// None of these reach the backend, so a nil one is fine.
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr bool
+	}{
+		{"default", config.Config{ImageRegistry: "ghcr.io/myorg/silo"}, false},
+		{"never", config.Config{ImageRegistry: "ghcr.io/myorg/silo", PullPolicy: PullNever}, false},
+		{"invalid", config.Config{ImageRegistry: "ghcr.io/myorg/silo", PullPolicy: "always"}, true},
+		{"no registry", config.Config{PullPolicy: PullIfNotPresent}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := image{shared: &image{tag: "silo-claude-abc123"}}
+			err := pullShared(context.Background(), nil, tt.cfg, img, func(string, ...any) {})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSharedImage(t *testing.T) {
+	if got, want := SharedImage("ghcr.io/myorg/silo", "silo-claude-abc123"), "ghcr.io/myorg/silo:silo-claude-abc123"; got != want {
+		t.Errorf("SharedImage = %q, want %q", got, want)
+	}
+}
+
+func TestExpandHookEnv(t *testing.T) {
+	lookup := hookEnvLookup([]string{"TOKEN=from-secret"}, []string{"BLOCKED_*"})
+	t.Setenv("TOKEN", "from-host")
+	t.Setenv("HOST_ONLY", "h; rm -rf ~")
+	t.Setenv("BLOCKED_TOKEN", "b")
+
+	got, env, err := expandHookEnv([]string{
+		"login ${env:TOKEN}",
+		"echo ${env:HOST_ONLY}-$HOME ${env:HOST_ONLY}",
+		`echo "a ${env:TOKEN}" 'b ${env:TOKEN}' \'${env:TOKEN}`,
+		"echo ${HOME}",
+	}, lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`login "${__SILO_ENV_TOKEN}"`,
+		`echo "${__SILO_ENV_HOST_ONLY}"-$HOME "${__SILO_ENV_HOST_ONLY}"`,
+		`echo "a ${__SILO_ENV_TOKEN}" 'b '"${__SILO_ENV_TOKEN}"'' \'"${__SILO_ENV_TOKEN}"`,
+		"echo ${HOME}",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	wantEnv := []string{"__SILO_ENV_TOKEN=from-secret", "__SILO_ENV_HOST_ONLY=h; rm -rf ~"}
+	if !slices.Equal(env, wantEnv) {
+		t.Errorf("env = %q, want %q", env, wantEnv)
+	}
+
+	_, _, err = expandHookEnv([]string{"a ${env:SILO_TEST_MISSING_A}", "b ${env:SILO_TEST_MISSING_B} ${env:SILO_TEST_MISSING_A}"}, lookup)
+	if err == nil || !strings.HasSuffix(err.Error(), "SILO_TEST_MISSING_A, SILO_TEST_MISSING_B") {
+		t.Errorf("got %v, want error naming both missing variables", err)
+	}
+
+	_, _, err = expandHookEnv([]string{"a ${env:BLOCKED_TOKEN}"}, lookup)
+	if err == nil || !strings.HasSuffix(err.Error(), "blocked by env_blocklist: BLOCKED_TOKEN") {
+		t.Errorf("got %v, want error naming the blocked variable", err)
+	}
+}
+
+func TestExpandHookEnvRuns(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	t.Setenv("VALUE", `it's "a" $(value); exit 3`)
+	got, env, err := expandHookEnv([]string{`printf '%s|' ${env:VALUE} "${env:VALUE}" '${env:VALUE}'`}, hookEnvLookup(nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sh", "-c", got[0])
+	cmd.Env = env
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := os.Getenv("VALUE")
+	if want := v + "|" + v + "|" + v + "|"; string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestHookRun(t *testing.T) {
+	got := hookRun(`NPM_TOKEN=${secret:npm_token} npm install -g @org/cli && echo "${secret:npm_token}${secret:pip}" >/dev/null`)
+	want := `RUN --mount=type=secret,id=npm_token,required=true --mount=type=secret,id=pip,required=true NPM_TOKEN=$(cat /run/secrets/npm_token) npm install -g @org/cli && echo "$(cat /run/secrets/npm_token)$(cat /run/secrets/pip)" >/dev/null` + "\n"
+	if got != want {
+		t.Errorf("hookRun() =\n%s\nwant\n%s", got, want)
+	}
+	if got := hookRun("echo ${env:HOME} $HOME"); got != "RUN echo ${env:HOME} $HOME\n" {
+		t.Errorf("hookRun() = %q, want the hook unchanged", got)
+	}
+}
+
+func TestResolveBuildSecrets(t *testing.T) {
+	cfg := config.Config{BuildSecrets: map[string]config.Secret{"npm_token": {Op: "op://a"}, "unused": {Pass: "b"}}}
+	repos := []RepoMatch{{Config: config.RepoConfig{BuildSecrets: map[string]config.Secret{"npm_token": {Op: "op://repo"}}}}}
+
+	got, err := resolveBuildSecrets(cfg, repos, hookSecrets([]string{"a ${secret:npm_token}", "b ${secret:npm_token}"}))
+	if err != nil || len(got) != 1 || got["npm_token"].Op != "op://repo" {
+		t.Errorf("got %v, %v, want only the repo's npm_token", got, err)
+	}
+	if _, err := resolveBuildSecrets(cfg, repos, []string{"missing"}); err == nil {
+		t.Error("got no error for a secret that isn't configured")
+	}
+}
+
+func TestSessionSummary(t *testing.T) {
+	mapped := []backend.Mount{{Source: "/state/ephemeral/0/.claude", Target: "/home/me/.claude"}}
+	toolDef := tools.Tool{
+		Usage: func(resolve func(string) string, dir string, since time.Time) (tools.Usage, bool) {
+			if got := resolve("/home/me/.claude/projects"); got != "/state/ephemeral/0/.claude/projects" {
+				t.Errorf("resolve() = %q, want the path in the copy", got)
+			}
+			return tools.Usage{InputTokens: 1200, CacheReadTokens: 1_500_000, OutputTokens: 830, Cost: 1.234}, true
+		},
+	}
+
+	got := sessionSummary(toolDef, t.TempDir(), "", time.Now().Add(-90*time.Second), mapped)
+	if want := "1m30s · 1.5M tokens in, 830 out · $1.23"; got != want {
+		t.Errorf("sessionSummary() = %q, want %q", got, want)
+	}
+
+	if got := sessionSummary(tools.Tool{}, t.TempDir(), "", time.Now(), nil); got != "0s" {
+		t.Errorf("sessionSummary() = %q, want only the length without usage", got)
+	}
+}
+
+func TestToolCommand(t *testing.T) {
+	command := []string{"claude", "--mcp-config=/home/me/.claude/mcp.json", "--dangerously-skip-permissions"}
+	permissionFlags := []string{"--dangerously-skip-permissions"}
+	off := false
+
+	tests := []struct {
+		name    string
+		toolCfg config.ToolConfig
+		want    []string
+	}{
+		{"default", config.ToolConfig{}, command},
+		{"args", config.ToolConfig{Args: []string{"--model", "opus"}}, []string{"claude", "--mcp-config=/home/me/.claude/mcp.json", "--dangerously-skip-permissions", "--model", "opus"}},
+		{"no default flags", config.ToolConfig{Args: []string{"--model", "opus"}, DefaultFlags: &off}, []string{"claude", "--mcp-config=/home/me/.claude/mcp.json", "--model", "opus"}},
+	}
+	for _, tt := range tests {
+		if got := toolCommand(command, permissionFlags, tt.toolCfg); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: toolCommand() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if !slices.Equal(command, []string{"claude", "--mcp-config=/home/me/.claude/mcp.json", "--dangerously-skip-permissions"}) {
+		t.Errorf("expected the default command to be left as is, got %q", command)
+	}
+}
+
+func TestContainerBaseName(t *testing.T) {
+	vars := templateVars("/src/My Project", []string{"git@github.com:org/widget.git"}, "feature/x", "claude", "leigh")
+	for _, tt := range []struct {
+		template, want string
+	}{
+		{"", "my-project"},
+		{"{{.Repo}}-{{.Branch}}", "widget-feature-x"},
+		{"{{.Tool}}", "claude"},
+	} {
+		got, err := containerBaseName(tt.template, vars)
+		if err != nil {
+			t.Errorf("containerBaseName(%q): %v", tt.template, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("containerBaseName(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+	if _, err := containerBaseName("{{.Missing}}", vars); err == nil {
+		t.Error("expected an unknown variable to be an error")
+	}
+}
@@ -0,0 +1,69 @@
+package run
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/leighmcculloch/silo/backend"
+	"github.com/leighmcculloch/silo/config"
+)
+
+// serviceNameRegex matches service names, which are hostnames on the
+// services network.
+var serviceNameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// resolveServices returns the sidecar services of a run, the global
+// services replaced by those of the same name in matching repos, in name
+// order, and the environment variables that tell the tool where they are:
+// <NAME>_HOST and <NAME>_PORT of each service, and its tool_env.
+func resolveServices(cfg config.Config, repoMatches []RepoMatch, network backend.Network) ([]backend.Service, []string, error) {
+	merged := cfg.Services
+	for _, rm := range repoMatches {
+		merged = config.MergeServices(merged, rm.Config.Services)
+	}
+	if len(merged) == 0 {
+		return nil, nil, nil
+	}
+	if network.Mode == backend.NetworkNone {
+		return nil, nil, fmt.Errorf("services require network %q or %q, got %q", backend.NetworkFull, backend.NetworkAllowlist, network.Mode)
+	}
+
+	var services []backend.Service
+	var env []string
+	for _, name := range slices.Sorted(maps.Keys(merged)) {
+		svc := merged[name]
+		if !serviceNameRegex.MatchString(name) {
+			return nil, nil, fmt.Errorf("invalid service name %q: must be lowercase letters, digits, or '-'", name)
+		}
+		if svc.Image == "" {
+			return nil, nil, fmt.Errorf("service %s has no image", name)
+		}
+		for _, p := range svc.Ports {
+			if p < 1 || p > 65535 {
+				return nil, nil, fmt.Errorf("invalid port %d for service %s", p, name)
+			}
+		}
+		for _, e := range slices.Concat(svc.Env, svc.ToolEnv) {
+			if k, _, ok := strings.Cut(e, "="); !ok || k == "" {
+				return nil, nil, fmt.Errorf("invalid env %q for service %s: must be KEY=VALUE", e, name)
+			}
+		}
+		services = append(services, backend.Service{
+			Name:        name,
+			Image:       svc.Image,
+			Env:         svc.Env,
+			Healthcheck: svc.Healthcheck,
+		})
+		prefix := strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+		env = append(env, prefix+"_HOST="+name)
+		if len(svc.Ports) > 0 {
+			env = append(env, prefix+"_PORT="+strconv.Itoa(svc.Ports[0]))
+		}
+		env = append(env, svc.ToolEnv...)
+	}
+	return services, env, nil
+}
@@ -0,0 +1,62 @@
+package run
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/leighmcculloch/silo/backend"
+	"github.com/leighmcculloch/silo/config"
+)
+
+func TestResolveServices(t *testing.T) {
+	cfg := config.Config{
+		Services: map[string]config.Service{
+			"postgres": {Image: "postgres:16", Ports: []int{5432}, Env: []string{"POSTGRES_PASSWORD=pw"}, Healthcheck: "pg_isready"},
+			"redis":    {Image: "redis:7", Ports: []int{6379}},
+		},
+	}
+	repos := []RepoMatch{{Name: "github.com/org", Config: config.RepoConfig{Services: map[string]config.Service{
+		"redis":     {Image: "valkey/valkey:8", Ports: []int{6379}, ToolEnv: []string{"REDIS_URL=redis://redis:6379"}},
+		"object-db": {Image: "minio/minio"},
+	}}}}
+	services, env, err := resolveServices(cfg, repos, backend.Network{Mode: backend.NetworkFull})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantServices := []backend.Service{
+		{Name: "object-db", Image: "minio/minio"},
+		{Name: "postgres", Image: "postgres:16", Env: []string{"POSTGRES_PASSWORD=pw"}, Healthcheck: "pg_isready"},
+		{Name: "redis", Image: "valkey/valkey:8"},
+	}
+	if !slices.EqualFunc(services, wantServices, func(a, b backend.Service) bool {
+		return a.Name == b.Name && a.Image == b.Image && slices.Equal(a.Env, b.Env) && a.Healthcheck == b.Healthcheck
+	}) {
+		t.Errorf("expected services %v, got %v", wantServices, services)
+	}
+	wantEnv := []string{
+		"OBJECT_DB_HOST=object-db",
+		"POSTGRES_HOST=postgres", "POSTGRES_PORT=5432",
+		"REDIS_HOST=redis", "REDIS_PORT=6379", "REDIS_URL=redis://redis:6379",
+	}
+	if !slices.Equal(env, wantEnv) {
+		t.Errorf("expected env %v, got %v", wantEnv, env)
+	}
+
+	if services, env, err := resolveServices(config.Config{}, nil, backend.Network{Mode: backend.NetworkNone}); services != nil || env != nil || err != nil {
+		t.Errorf("expected no services, got %v, %v, %v", services, env, err)
+	}
+	if _, _, err := resolveServices(cfg, nil, backend.Network{Mode: backend.NetworkNone}); err == nil {
+		t.Error("expected error for network none")
+	}
+	for _, svcs := range []map[string]config.Service{
+		{"Postgres": {Image: "postgres"}},
+		{"pg_1": {Image: "postgres"}},
+		{"postgres": {}},
+		{"postgres": {Image: "postgres", Ports: []int{0}}},
+		{"postgres": {Image: "postgres", ToolEnv: []string{"DATABASE_URL"}}},
+	} {
+		if _, _, err := resolveServices(config.Config{Services: svcs}, nil, backend.Network{}); err == nil {
+			t.Errorf("expected error for %v", svcs)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/leighmcculloch/silo/backend"
+	"github.com/leighmcculloch/silo/fileutil"
+	"github.com/leighmcculloch/silo/statedir"
+)
+
+// sharedUser is the user images shared through image_registry are built
+// for, so their tags don't depend on who builds them. The image a tool runs
+// in is a layer on top that makes the user the host user (userLayer).
+var sharedUser = User{Name: "silo", Home: "/home/silo", UID: 1000, GID: 1000}
+
+// userLayer is the Dockerfile of the layer that makes a shared image, built
+// for sharedUser, the user's: sharedUser is renamed and renumbered, and its
+// home moved, with a symlink left where it was for the paths the shared
+// image's environment and tools refer to. See userLayerDockerfile.
+const userLayer = `FROM %s
+USER root
+ARG USER
+ARG UID
+ARG GID
+ARG HOME
+RUN set -e; \
+    if [ "${GID}" = %[3]d ] || ! getent group "${GID}" >/dev/null; then \
+      groupmod -g "${GID}" %[2]s; \
+      if [ "${USER}" != %[2]s ]; then groupmod -n "${USER}" %[2]s; fi; \
+    fi; \
+    if [ "${HOME}" != %[4]s ]; then \
+      mkdir -p "$(dirname "${HOME}")"; \
+      mv %[4]s "${HOME}"; \
+      ln -s "${HOME}" %[4]s; \
+    fi; \
+    if [ "${USER}" != %[2]s ]; then \
+      usermod -l "${USER}" %[2]s; \
+      sed "s/^%[2]s /${USER} /" /etc/sudoers.d/%[2]s > "/etc/sudoers.d/${USER}"; \
+      chmod 0440 "/etc/sudoers.d/${USER}"; \
+      rm /etc/sudoers.d/%[2]s; \
+    fi; \
+    usermod -u "${UID}" -g "${GID}" -d "${HOME}" "${USER}"; \
+    chown -R -h "${UID}:${GID}" "${HOME}"
+ENV HOME="${HOME}"
+USER ${USER}
+WORKDIR ${HOME}
+`
+
+// userLayerDockerfile returns the Dockerfile of the layer that makes the
+// shared image tag the user's.
+func userLayerDockerfile(tag string) string {
+	u := sharedUser
+	return fmt.Sprintf(userLayer, tag, u.Name, u.GID, u.Home)
+}
+
+// userBuildArgs returns the build args that create the user u in an image.
+func userBuildArgs(u User) map[string]string {
+	return map[string]string{
+		"HOME": u.Home,
+		"USER": u.Name,
+		"UID":  strconv.Itoa(u.UID),
+		"GID":  strconv.Itoa(u.GID),
+	}
+}
+
+// sharedImages splits img, built for the user u, into the image shared
+// through image_registry, built for sharedUser, and returns the image of
+// the layer on top of it that makes it u's.
+func sharedImages(tool string, img image, u User) image {
+	shared := img
+	shared.buildArgs = maps.Clone(img.buildArgs)
+	maps.Copy(shared.buildArgs, userBuildArgs(sharedUser))
+	shared.tag = buildImageTag(tool, shared.dockerfile, shared.buildArgs, shared.platform)
+
+	dockerfile := userLayerDockerfile(shared.tag)
+	buildArgs := userBuildArgs(u)
+	return image{
+		dockerfile: dockerfile,
+		buildArgs:  buildArgs,
+		tag:        buildImageTag(tool, dockerfile, buildArgs, img.platform),
+		platform:   img.platform,
+		shared:     &shared,
+	}
+}
+
+// ImagePins returns the file the digests of shared images are recorded in,
+// by reference, when they're first pulled or pushed.
+func ImagePins() string {
+	return statedir.Path("image-pins.json")
+}
+
+// loadImagePins returns the digests recorded in ImagePins.
+func loadImagePins() (map[string]string, error) {
+	pins := map[string]string{}
+	data, err := os.ReadFile(ImagePins())
+	if errors.Is(err, os.ErrNotExist) {
+		return pins, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &pins); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", ImagePins(), err)
+	}
+	return pins, nil
+}
+
+// pinImage records digest as the digest of ref in ImagePins and returns
+// it, unless a digest is already recorded for ref and replace is false, in
+// which case that digest is returned.
+func pinImage(ref, digest string, replace bool) (string, error) {
+	path := ImagePins()
+	unlock, err := fileutil.Lock(path)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	pins, err := loadImagePins()
+	if err != nil {
+		return "", err
+	}
+	if pinned := pins[ref]; pinned != "" && !replace {
+		return pinned, nil
+	}
+	pins[ref] = digest
+	data, err := json.MarshalIndent(pins, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return "", err
+	}
+	return digest, fileutil.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// pullPinned pulls the image tag from registry and returns its reference.
+// Tags are content-addressed, so the digest first pulled or pushed for one
+// is recorded, and later pulls are of that digest: an image replaced in the
+// registry under the same tag isn't used. Images aren't signed, so the
+// first pull of a tag trusts the registry.
+func pullPinned(ctx context.Context, b backend.Backend, registry, tag string) (string, error) {
+	ref := SharedImage(registry, tag)
+	pins, err := loadImagePins()
+	if err != nil {
+		return "", err
+	}
+	pinned := pins[ref]
+	from := ref
+	if pinned != "" {
+		from = registry + "@" + pinned
+	}
+	digest, err := b.PullImage(ctx, from, tag)
+	if err != nil {
+		return "", err
+	}
+	if pinned == "" {
+		if pinned, err = pinImage(ref, digest, false); err != nil {
+			return "", err
+		}
+	}
+	if digest != pinned {
+		b.RemoveImages(ctx, []string{tag})
+		return "", fmt.Errorf("%s has digest %s, not %s it's pinned to in %s", ref, digest, pinned, ImagePins())
+	}
+	return from, nil
+}
@@ -0,0 +1,98 @@
+package run
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/leighmcculloch/silo/backend"
+	"github.com/leighmcculloch/silo/statedir"
+)
+
+func TestSharedImages(t *testing.T) {
+	img := image{
+		dockerfile: "FROM ubuntu AS claude\n",
+		buildArgs:  map[string]string{"DOCKER_IN_DOCKER": "rootless"},
+		cacheFrom:  []string{"ghcr.io/myorg/silo:base-abc"},
+	}
+	alice := sharedImages("claude", img, User{Name: "alice", Home: "/home/alice", UID: 1000, GID: 1000})
+	bob := sharedImages("claude", img, User{Name: "bob", Home: "/Users/bob", UID: 501, GID: 20})
+
+	if alice.shared.tag != bob.shared.tag {
+		t.Errorf("shared tags differ between users: %s, %s", alice.shared.tag, bob.shared.tag)
+	}
+	if alice.tag == bob.tag || alice.tag == alice.shared.tag {
+		t.Errorf("user tags %s, %s aren't distinct from each other and the shared tag %s", alice.tag, bob.tag, alice.shared.tag)
+	}
+	if got := alice.shared.buildArgs; got["USER"] != "silo" || got["HOME"] != "/home/silo" || got["DOCKER_IN_DOCKER"] != "rootless" {
+		t.Errorf("shared build args = %v", got)
+	}
+	if img.buildArgs["USER"] != "" {
+		t.Error("sharedImages modified the build args of img")
+	}
+	if len(alice.shared.cacheFrom) != 1 || alice.cacheFrom != nil {
+		t.Errorf("cacheFrom = %v, shared %v, want only the shared image's", alice.cacheFrom, alice.shared.cacheFrom)
+	}
+	if !strings.HasPrefix(bob.dockerfile, "FROM "+bob.shared.tag+"\n") {
+		t.Errorf("user layer isn't built from the shared image:\n%s", bob.dockerfile)
+	}
+	if bob.buildArgs["USER"] != "bob" || bob.buildArgs["UID"] != "501" {
+		t.Errorf("user build args = %v", bob.buildArgs)
+	}
+}
+
+// pullBackend pulls images with the digests in digests, by reference.
+type pullBackend struct {
+	backend.Backend
+	digests map[string]string
+	pulled  []string
+	removed []string
+}
+
+func (b *pullBackend) PullImage(_ context.Context, ref, name string) (string, error) {
+	b.pulled = append(b.pulled, ref)
+	return b.digests[ref], nil
+}
+
+func (b *pullBackend) RemoveImages(_ context.Context, names []string) ([]string, error) {
+	b.removed = append(b.removed, names...)
+	return names, nil
+}
+
+func TestPullPinned(t *testing.T) {
+	statedir.Set(t.TempDir())
+	t.Cleanup(func() { statedir.Set("") })
+	const registry, tag = "ghcr.io/myorg/silo", "silo-claude-abc123"
+	b := &pullBackend{digests: map[string]string{
+		registry + ":" + tag:      "sha256:1111",
+		registry + "@sha256:1111": "sha256:1111",
+	}}
+
+	// The first pull is by tag, and pins its digest
+	if ref, err := pullPinned(context.Background(), b, registry, tag); err != nil || ref != registry+":"+tag {
+		t.Fatalf("first pull = %q, %v", ref, err)
+	}
+	// Later pulls are by the pinned digest, whatever the tag is now
+	b.digests[registry+":"+tag] = "sha256:2222"
+	if ref, err := pullPinned(context.Background(), b, registry, tag); err != nil || ref != registry+"@sha256:1111" {
+		t.Fatalf("pinned pull = %q, %v", ref, err)
+	}
+
+	// An image that doesn't match its pin is removed
+	b.digests[registry+"@sha256:1111"] = "sha256:2222"
+	if _, err := pullPinned(context.Background(), b, registry, tag); err == nil {
+		t.Fatal("pull of an image that doesn't match its pin succeeded")
+	}
+	if len(b.removed) != 1 || b.removed[0] != tag {
+		t.Errorf("removed = %v, want [%s]", b.removed, tag)
+	}
+
+	// Pushing replaces the pin
+	if _, err := pinImage(registry+":"+tag, "sha256:2222", true); err != nil {
+		t.Fatal(err)
+	}
+	b.digests[registry+"@sha256:2222"] = "sha256:2222"
+	if ref, err := pullPinned(context.Background(), b, registry, tag); err != nil || ref != registry+"@sha256:2222" {
+		t.Fatalf("pull by a replaced pin = %q, %v", ref, err)
+	}
+}
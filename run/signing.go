@@ -0,0 +1,142 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/leighmcculloch/silo/config"
+	"github.com/leighmcculloch/silo/git"
+)
+
+// Commit signing settings.
+const (
+	SigningOff = "off" // commits aren't signed (default)
+	SigningSSH = "ssh" // signed with a key of the forwarded SSH agent
+	SigningGPG = "gpg" // signed by the forwarded gpg-agent
+)
+
+// resolveCommitSigning returns how commits made in the container are
+// signed: the global commit_signing overridden by matching repos.
+func resolveCommitSigning(cfg config.Config, repoMatches []RepoMatch) (string, error) {
+	signing := cfg.CommitSigning
+	for _, rm := range repoMatches {
+		if rm.Config.CommitSigning != "" {
+			signing = rm.Config.CommitSigning
+		}
+	}
+	switch signing {
+	case "", SigningOff:
+		return SigningOff, nil
+	case SigningSSH, SigningGPG:
+		return signing, nil
+	}
+	return "", fmt.Errorf("invalid commit_signing %q (valid: off, ssh, gpg)", signing)
+}
+
+// commitSigning is what a run needs to sign commits in the container. The
+// private key never enters it: the forwarded agent signs.
+type commitSigning struct {
+	env        []string // the git config of the signing, as GIT_CONFIG_* variables
+	mountsRO   []string // the allowed signers file, if configured
+	gpgAgent   string   // the host's gpg-agent extra socket, for gpg
+	gpgKeyring []string // the host's public keyring files, for gpg
+}
+
+// prepareCommitSigning returns the commitSigning of mode, one of the
+// commit signing settings other than off, from the host's global git config.
+func prepareCommitSigning(mode string) (commitSigning, error) {
+	key := git.GetGitConfig("user.signingkey")
+	switch mode {
+	case SigningSSH:
+		if key == "" {
+			return commitSigning{}, errors.New("commit_signing ssh needs user.signingkey set in your global git config")
+		}
+		if os.Getenv("SSH_AUTH_SOCK") == "" {
+			return commitSigning{}, errors.New("commit_signing ssh needs an SSH agent holding the signing key, and SSH_AUTH_SOCK is not set")
+		}
+		pub, err := sshSigningKey(key)
+		if err != nil {
+			return commitSigning{}, err
+		}
+		pairs := [][2]string{{"gpg.format", "ssh"}, {"user.signingkey", pub}, {"commit.gpgsign", "true"}, {"tag.gpgsign", "true"}}
+		var mounts []string
+		if f := git.GetGitConfig("gpg.ssh.allowedSignersFile"); f != "" {
+			f = expandPath(f)
+			pairs = append(pairs, [2]string{"gpg.ssh.allowedSignersFile", f})
+			mounts = append(mounts, f)
+		}
+		return commitSigning{env: gitConfigEnv(pairs), mountsRO: mounts}, nil
+	case SigningGPG:
+		sock, home := gpgconfDir("agent-extra-socket"), gpgconfDir("homedir")
+		if sock == "" || home == "" {
+			return commitSigning{}, errors.New("commit_signing gpg needs GnuPG installed on the host")
+		}
+		var keyring []string
+		for _, name := range []string{"pubring.kbx", "pubring.gpg", "trustdb.gpg"} {
+			if _, err := os.Stat(filepath.Join(home, name)); err == nil {
+				keyring = append(keyring, filepath.Join(home, name))
+			}
+		}
+		pairs := [][2]string{{"gpg.format", "openpgp"}, {"commit.gpgsign", "true"}, {"tag.gpgsign", "true"}}
+		if key != "" {
+			pairs = append(pairs, [2]string{"user.signingkey", key})
+		}
+		return commitSigning{env: gitConfigEnv(pairs), gpgAgent: sock, gpgKeyring: keyring}, nil
+	}
+	return commitSigning{}, nil
+}
+
+// sshSigningKey returns the public key of user.signingkey as git's literal
+// key form, "key::<key>", as the key file it names isn't in the container.
+// A private key file is read from its .pub file.
+func sshSigningKey(key string) (string, error) {
+	switch {
+	case strings.HasPrefix(key, "key::"):
+		return key, nil
+	case strings.HasPrefix(key, "ssh-"), strings.HasPrefix(key, "ecdsa-"), strings.HasPrefix(key, "sk-"):
+		return "key::" + key, nil
+	}
+	path := expandPath(key)
+	if !strings.HasSuffix(path, ".pub") {
+		if _, err := os.Stat(path + ".pub"); err == nil {
+			path += ".pub"
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read user.signingkey: %w", err)
+	}
+	pub := strings.TrimSpace(string(data))
+	if strings.Contains(pub, "PRIVATE KEY") {
+		return "", fmt.Errorf("user.signingkey %s is a private key with no .pub file next to it", key)
+	}
+	return "key::" + pub, nil
+}
+
+// gitConfigEnv returns the environment setting the git config pairs, which
+// git applies on top of its config files.
+func gitConfigEnv(pairs [][2]string) []string {
+	env := []string{"GIT_CONFIG_COUNT=" + strconv.Itoa(len(pairs))}
+	for i, p := range pairs {
+		env = append(env,
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, p[0]),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, p[1]),
+		)
+	}
+	return env
+}
+
+// gpgconfDir returns the GnuPG directory or socket name, e.g. homedir, or ""
+// if gpgconf isn't installed.
+func gpgconfDir(name string) string {
+	out, err := exec.Command("gpgconf", "--list-dirs", name).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,47 @@
+package run
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/leighmcculloch/silo/backend"
+	"github.com/leighmcculloch/silo/config"
+)
+
+// defaultStopGracePeriod is how long a tool has to exit after it is sent
+// the stop signal, when stop.grace_period isn't set. It is Docker's default.
+const defaultStopGracePeriod = 10 * time.Second
+
+// stopSignals are the signals a tool can be sent to stop it.
+var stopSignals = []string{"SIGHUP", "SIGINT", "SIGQUIT", "SIGTERM", "SIGUSR1", "SIGUSR2", "SIGKILL"}
+
+// resolveStop returns the stop policy of the backends from s, with the
+// signal defaulting to SIGTERM and the grace period to 10s. Signals may be
+// given without the SIG prefix, in any case.
+func resolveStop(s config.StopPolicy) (backend.StopPolicy, error) {
+	policy := backend.StopPolicy{Signal: "SIGTERM", GracePeriod: defaultStopGracePeriod, Confirm: s.Confirm}
+	if s.Signal != "" {
+		signal := strings.ToUpper(s.Signal)
+		if !strings.HasPrefix(signal, "SIG") {
+			signal = "SIG" + signal
+		}
+		if !slices.Contains(stopSignals, signal) {
+			return backend.StopPolicy{}, fmt.Errorf("invalid stop signal %q: must be one of %s", s.Signal, strings.Join(stopSignals, ", "))
+		}
+		policy.Signal = signal
+	}
+	switch s.GracePeriod {
+	case "":
+	case "0":
+		policy.GracePeriod = 0
+	default:
+		d, err := time.ParseDuration(s.GracePeriod)
+		if err != nil || d < 0 {
+			return backend.StopPolicy{}, fmt.Errorf("invalid stop grace_period %q: must be a duration, e.g. 10s", s.GracePeriod)
+		}
+		policy.GracePeriod = d
+	}
+	return policy, nil
+}
@@ -0,0 +1,30 @@
+package run
+
+import (
+	"testing"
+	"time"
+
+	"github.com/leighmcculloch/silo/backend"
+	"github.com/leighmcculloch/silo/config"
+)
+
+func TestResolveStop(t *testing.T) {
+	tests := []struct {
+		stop    config.StopPolicy
+		want    backend.StopPolicy
+		wantErr bool
+	}{
+		{config.StopPolicy{}, backend.StopPolicy{Signal: "SIGTERM", GracePeriod: 10 * time.Second}, false},
+		{config.StopPolicy{Signal: "int", GracePeriod: "1m", Confirm: true}, backend.StopPolicy{Signal: "SIGINT", GracePeriod: time.Minute, Confirm: true}, false},
+		{config.StopPolicy{Signal: "SIGHUP", GracePeriod: "0"}, backend.StopPolicy{Signal: "SIGHUP"}, false},
+		{config.StopPolicy{Signal: "SIGSTOP"}, backend.StopPolicy{}, true},
+		{config.StopPolicy{GracePeriod: "10"}, backend.StopPolicy{}, true},
+		{config.StopPolicy{GracePeriod: "-1s"}, backend.StopPolicy{}, true},
+	}
+	for _, tt := range tests {
+		got, err := resolveStop(tt.stop)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("resolveStop(%+v) = %+v, %v, want %+v, error %v", tt.stop, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package run
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/leighmcculloch/silo/backend"
+	"github.com/leighmcculloch/silo/git"
+	"github.com/leighmcculloch/silo/tools"
+)
+
+// sessionSummary returns a one-line recap of a session of toolDef in dir
+// that started at start with head checked out: how long it ran, the files
+// changed and commits made since head, and the tokens the tool used if it
+// logs them. Anything that can't be found out is left out. mapped are the
+// copies of ephemeral mounts, where the tool's logs are until they are
+// removed.
+func sessionSummary(toolDef tools.Tool, dir, head string, start time.Time, mapped []backend.Mount) string {
+	parts := []string{time.Since(start).Round(time.Second).String()}
+
+	if head != "" {
+		if files, err := git.ChangedFiles(dir, head); err == nil {
+			parts = append(parts, count(len(files), "file changed", "files changed"))
+		}
+		if commits, err := git.Commits(dir, head); err == nil {
+			parts = append(parts, count(len(commits), "commit", "commits"))
+		}
+	}
+
+	if toolDef.Usage != nil {
+		resolve := func(path string) string {
+			for _, m := range mapped {
+				if isWithin(path, m.Target) {
+					rel, _ := filepath.Rel(m.Target, path)
+					return filepath.Join(m.Source, rel)
+				}
+			}
+			return path
+		}
+		if u, ok := toolDef.Usage(resolve, dir, start); ok {
+			in := u.InputTokens + u.CacheReadTokens + u.CacheWriteTokens
+			parts = append(parts, fmt.Sprintf("%s tokens in, %s out", tokens(in), tokens(u.OutputTokens)))
+			if u.Cost > 0 {
+				parts = append(parts, fmt.Sprintf("$%.2f", u.Cost))
+			}
+		}
+	}
+
+	return strings.Join(parts, " · ")
+}
+
+// count returns n followed by singular or plural.
+func count(n int, singular, plural string) string {
+	if n == 1 {
+		return "1 " + singular
+	}
+	return fmt.Sprintf("%d %s", n, plural)
+}
+
+// tokens formats a number of tokens compactly, e.g. 1.2M or 830k.
+func tokens(n int64) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1_000_000)
+	case n >= 1_000:
+		return fmt.Sprintf("%.0fk", float64(n)/1_000)
+	}
+	return fmt.Sprint(n)
+}
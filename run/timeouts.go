@@ -0,0 +1,83 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/leighmcculloch/silo/backend"
+	"github.com/leighmcculloch/silo/config"
+)
+
+// defaultMountWait and defaultConnect are the timeouts used when
+// timeouts.mount_wait and timeouts.connect aren't set.
+const (
+	defaultMountWait = 10 * time.Second
+	defaultConnect   = 10 * time.Second
+)
+
+// connectBackoff and maxConnectBackoff are the first and the longest delay
+// between probes of a backend that isn't reachable yet.
+const (
+	connectBackoff    = 250 * time.Millisecond
+	maxConnectBackoff = 2 * time.Second
+)
+
+// timeoutPolicy is the resolved timeouts config. Zero is no limit for build,
+// and no waiting for mountWait and connect.
+type timeoutPolicy struct {
+	build     time.Duration
+	mountWait time.Duration
+	connect   time.Duration
+}
+
+// resolveTimeouts returns the timeouts of t, with those unset defaulting.
+func resolveTimeouts(t config.Timeouts) (timeoutPolicy, error) {
+	var result timeoutPolicy
+	for _, f := range []struct {
+		name  string
+		value string
+		def   time.Duration
+		d     *time.Duration
+	}{
+		{"build", t.Build, 0, &result.build},
+		{"mount_wait", t.MountWait, defaultMountWait, &result.mountWait},
+		{"connect", t.Connect, defaultConnect, &result.connect},
+	} {
+		switch f.value {
+		case "":
+			*f.d = f.def
+		case "0":
+			*f.d = 0
+		default:
+			d, err := time.ParseDuration(f.value)
+			if err != nil || d < 0 {
+				return timeoutPolicy{}, fmt.Errorf("invalid timeouts %s %q: must be a duration, e.g. 30s", f.name, f.value)
+			}
+			*f.d = d
+		}
+	}
+	return result, nil
+}
+
+// waitForBackend probes a backend with probe until it is available, for up
+// to timeout while the probe says it may become available, waiting longer
+// between each probe. onRetry is called before each retry with the last
+// probe's info. It returns the last probe's info.
+func waitForBackend(ctx context.Context, probe func(context.Context) backend.Info, timeout time.Duration, onRetry func(backend.Info)) backend.Info {
+	deadline := time.Now().Add(timeout)
+	delay := connectBackoff
+	for {
+		info := probe(ctx)
+		if info.Available || !info.Retry || time.Now().Add(delay).After(deadline) {
+			return info
+		}
+		onRetry(info)
+		select {
+		case <-ctx.Done():
+			return info
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxConnectBackoff)
+	}
+}
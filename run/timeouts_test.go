@@ -0,0 +1,63 @@
+package run
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/leighmcculloch/silo/backend"
+	"github.com/leighmcculloch/silo/config"
+)
+
+func TestResolveTimeouts(t *testing.T) {
+	tests := []struct {
+		timeouts config.Timeouts
+		want     timeoutPolicy
+		wantErr  bool
+	}{
+		{config.Timeouts{}, timeoutPolicy{mountWait: 10 * time.Second, connect: 10 * time.Second}, false},
+		{config.Timeouts{Build: "30m", MountWait: "0", Connect: "1m"}, timeoutPolicy{build: 30 * time.Minute, connect: time.Minute}, false},
+		{config.Timeouts{Build: "30"}, timeoutPolicy{}, true},
+		{config.Timeouts{Connect: "-1s"}, timeoutPolicy{}, true},
+	}
+	for _, tt := range tests {
+		got, err := resolveTimeouts(tt.timeouts)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("resolveTimeouts(%+v) = %+v, %v, want %+v, error %v", tt.timeouts, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestWaitForBackend(t *testing.T) {
+	// A daemon that is reachable on the third probe
+	probes := 0
+	probe := func(context.Context) backend.Info {
+		probes++
+		return backend.Info{Available: probes == 3, Retry: true}
+	}
+	retries := 0
+	info := waitForBackend(context.Background(), probe, 5*time.Second, func(backend.Info) { retries++ })
+	if !info.Available || probes != 3 || retries != 2 {
+		t.Errorf("available %v after %d probes and %d retries, want available after 3 probes", info.Available, probes, retries)
+	}
+
+	// A backend that isn't installed isn't retried
+	probes = 0
+	info = waitForBackend(context.Background(), func(context.Context) backend.Info {
+		probes++
+		return backend.Info{Detail: "not installed"}
+	}, 5*time.Second, func(backend.Info) {})
+	if info.Available || probes != 1 {
+		t.Errorf("available %v after %d probes, want unavailable after 1", info.Available, probes)
+	}
+
+	// Nor is one that isn't reachable in time
+	probes = 0
+	info = waitForBackend(context.Background(), func(context.Context) backend.Info {
+		probes++
+		return backend.Info{Retry: true}
+	}, 100*time.Millisecond, func(backend.Info) {})
+	if info.Available || probes != 1 {
+		t.Errorf("available %v after %d probes, want unavailable after 1", info.Available, probes)
+	}
+}
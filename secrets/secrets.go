@@ -0,0 +1,161 @@
+// Package secrets fetches secret values on the host at run time so they can
+// be injected into a container's environment without being stored in config,
+// baked into an image, or written to logs.
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/leighmcculloch/silo/config"
+)
+
+// runCommand runs a command and returns its standard output. It is a variable
+// so tests can replace it.
+var runCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(string(ee.Stderr)))
+	}
+	return out, err
+}
+
+// runInteractive runs a command connected to the given streams, so it can
+// prompt for input. It is a variable so tests can replace it.
+var runInteractive = func(ctx context.Context, stdin io.Reader, stdout, stderr io.Writer, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	return cmd.Run()
+}
+
+// goos is the operating system whose keychain is used. It is a variable so
+// tests can replace it.
+var goos = runtime.GOOS
+
+// keychainAccount is the account of the keychain items silo stores.
+const keychainAccount = "silo"
+
+// Source returns a short description of where s is fetched from, e.g.
+// "keychain" or "op". It never includes the value.
+func Source(s config.Secret) string {
+	switch {
+	case s.Keychain != "":
+		return "keychain"
+	case s.Op != "":
+		return "op"
+	case s.Pass != "":
+		return "pass"
+	case s.Command != "":
+		return "command"
+	}
+	return ""
+}
+
+// Fetch returns the value of the secret s. Exactly one source must be set.
+func Fetch(ctx context.Context, s config.Secret) (string, error) {
+	set := 0
+	for _, v := range []string{s.Keychain, s.Op, s.Pass, s.Command} {
+		if v != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return "", fmt.Errorf("exactly one of keychain, op, pass, or command must be set")
+	}
+
+	var out []byte
+	var err error
+	switch {
+	case s.Keychain != "":
+		lookup := keychainLookup(s.Keychain)
+		out, err = runCommand(ctx, lookup[0], lookup[1:]...)
+	case s.Op != "":
+		out, err = runCommand(ctx, "op", "read", s.Op)
+	case s.Pass != "":
+		out, err = runCommand(ctx, "pass", "show", s.Pass)
+		if err == nil {
+			first, _, _ := strings.Cut(string(out), "\n")
+			out = []byte(first)
+		}
+	case s.Command != "":
+		out, err = runCommand(ctx, "/bin/sh", "-c", s.Command)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", Source(s), err)
+	}
+
+	value := strings.TrimRight(string(out), "\r\n")
+	if value == "" {
+		// libsecret reports a missing item as an empty value
+		if s.Keychain != "" {
+			return "", fmt.Errorf("keychain: no item for service %s, store one with: silo secret set %s", s.Keychain, s.Keychain)
+		}
+		return "", fmt.Errorf("%s: empty value", Source(s))
+	}
+	return value, nil
+}
+
+// keychainLookup returns the command printing the password of the keychain
+// item for service: the macOS Keychain on macOS, and the Secret Service of
+// the desktop (GNOME Keyring, KWallet) through libsecret elsewhere.
+func keychainLookup(service string) []string {
+	if goos == "darwin" {
+		return []string{"security", "find-generic-password", "-s", service, "-w"}
+	}
+	return []string{"secret-tool", "lookup", "service", service}
+}
+
+// Store prompts on the terminal for the value of the keychain item for
+// service and stores it, replacing any existing value, so it can be used as
+// a keychain secret. The value is typed into the keychain tool directly and
+// never passes through silo or appears on a command line.
+func Store(ctx context.Context, service string, stdin io.Reader, stdout, stderr io.Writer) error {
+	var args []string
+	if goos == "darwin" {
+		// -w must come last for security to prompt for the password
+		args = []string{"security", "add-generic-password", "-U", "-a", keychainAccount, "-s", service, "-l", "silo: " + service, "-w"}
+	} else {
+		args = []string{"secret-tool", "store", "--label", "silo: " + service, "service", service}
+	}
+	if err := runInteractive(ctx, stdin, stdout, stderr, args[0], args[1:]...); err != nil {
+		return fmt.Errorf("storing keychain item %s: %w", service, err)
+	}
+	return nil
+}
+
+// FetchAll fetches every secret in m and returns them as KEY=VALUE
+// environment variables sorted by name, along with the values for
+// redaction. Secrets are fetched one at a time, in order of name, so the
+// prompts of password managers such as op don't overlap.
+func FetchAll(ctx context.Context, m map[string]config.Secret) (env, values []string, err error) {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, err := Fetch(ctx, m[name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("secret %s: %w", name, err)
+		}
+		env = append(env, name+"="+value)
+		values = append(values, value)
+	}
+	return env, values, nil
+}
+
+// Redact replaces every occurrence of the values in s with "***".
+func Redact(s string, values []string) string {
+	for _, v := range values {
+		if v != "" {
+			s = strings.ReplaceAll(s, v, "***")
+		}
+	}
+	return s
+}
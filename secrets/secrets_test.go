@@ -0,0 +1,168 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/leighmcculloch/silo/config"
+)
+
+func stubCommand(t *testing.T, fn func(name string, args ...string) ([]byte, error)) {
+	t.Helper()
+	orig := runCommand
+	runCommand = func(_ context.Context, name string, args ...string) ([]byte, error) {
+		return fn(name, args...)
+	}
+	t.Cleanup(func() { runCommand = orig })
+}
+
+func stubOS(t *testing.T, os string) {
+	t.Helper()
+	orig := goos
+	goos = os
+	t.Cleanup(func() { goos = orig })
+}
+
+func TestFetch(t *testing.T) {
+	stubOS(t, "darwin")
+	var got []string
+	stubCommand(t, func(name string, args ...string) ([]byte, error) {
+		got = append([]string{name}, args...)
+		return []byte("value\nsecond line\n"), nil
+	})
+
+	tests := []struct {
+		name   string
+		secret config.Secret
+		cmd    []string
+		want   string
+	}{
+		{"keychain", config.Secret{Keychain: "svc"}, []string{"security", "find-generic-password", "-s", "svc", "-w"}, "value\nsecond line"},
+		{"op", config.Secret{Op: "op://v/i/f"}, []string{"op", "read", "op://v/i/f"}, "value\nsecond line"},
+		{"pass", config.Secret{Pass: "a/b"}, []string{"pass", "show", "a/b"}, "value"},
+		{"command", config.Secret{Command: "echo hi"}, []string{"/bin/sh", "-c", "echo hi"}, "value\nsecond line"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := Fetch(context.Background(), tt.secret)
+			if err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+			if value != tt.want {
+				t.Errorf("value = %q, want %q", value, tt.want)
+			}
+			if !slices.Equal(got, tt.cmd) {
+				t.Errorf("command = %v, want %v", got, tt.cmd)
+			}
+		})
+	}
+}
+
+func TestFetchLibsecret(t *testing.T) {
+	stubOS(t, "linux")
+	var got []string
+	stubCommand(t, func(name string, args ...string) ([]byte, error) {
+		got = append([]string{name}, args...)
+		if args[len(args)-1] == "missing" {
+			return nil, nil
+		}
+		return []byte("value"), nil
+	})
+
+	value, err := Fetch(context.Background(), config.Secret{Keychain: "svc"})
+	if err != nil || value != "value" {
+		t.Errorf("Fetch = %q, %v", value, err)
+	}
+	if want := []string{"secret-tool", "lookup", "service", "svc"}; !slices.Equal(got, want) {
+		t.Errorf("command = %v, want %v", got, want)
+	}
+
+	_, err = Fetch(context.Background(), config.Secret{Keychain: "missing"})
+	if err == nil || !strings.Contains(err.Error(), "silo secret set missing") {
+		t.Errorf("expected a missing item to suggest storing one, got %v", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	var got []string
+	orig := runInteractive
+	runInteractive = func(_ context.Context, _ io.Reader, _, _ io.Writer, name string, args ...string) error {
+		got = append([]string{name}, args...)
+		return nil
+	}
+	t.Cleanup(func() { runInteractive = orig })
+
+	for _, tt := range []struct {
+		os   string
+		want []string
+	}{
+		{"darwin", []string{"security", "add-generic-password", "-U", "-a", "silo", "-s", "svc", "-l", "silo: svc", "-w"}},
+		{"linux", []string{"secret-tool", "store", "--label", "silo: svc", "service", "svc"}},
+	} {
+		stubOS(t, tt.os)
+		if err := Store(context.Background(), "svc", nil, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: command = %v, want %v", tt.os, got, tt.want)
+		}
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	stubCommand(t, func(name string, args ...string) ([]byte, error) {
+		if name == "op" {
+			return nil, errors.New("not signed in")
+		}
+		return []byte("\n"), nil
+	})
+
+	tests := []struct {
+		name   string
+		secret config.Secret
+	}{
+		{"no source", config.Secret{}},
+		{"two sources", config.Secret{Op: "op://v/i/f", Pass: "a"}},
+		{"command fails", config.Secret{Op: "op://v/i/f"}},
+		{"empty value", config.Secret{Command: "true"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Fetch(context.Background(), tt.secret); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestFetchAll(t *testing.T) {
+	stubOS(t, "darwin")
+	stubCommand(t, func(name string, args ...string) ([]byte, error) {
+		return []byte(args[len(args)-1] + "-value\n"), nil
+	})
+
+	env, values, err := FetchAll(context.Background(), map[string]config.Secret{
+		"B": {Keychain: "b"},
+		"A": {Op: "op://a"},
+	})
+	if err != nil {
+		t.Fatalf("FetchAll: %v", err)
+	}
+	if want := []string{"A=op://a-value", "B=-w-value"}; !slices.Equal(env, want) {
+		t.Errorf("env = %v, want %v", env, want)
+	}
+	if want := []string{"op://a-value", "-w-value"}; !slices.Equal(values, want) {
+		t.Errorf("values = %v, want %v", values, want)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	got := Redact("export KEY=sk-123 && echo sk-123", []string{"sk-123", ""})
+	if want := "export KEY=*** && echo ***"; got != want {
+		t.Errorf("Redact = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,238 @@
+// Package selfupdate updates silo to its latest GitHub release: it finds the
+// release, downloads the archive built for the platform, verifies it against
+// the release's checksums, whose signature it verifies with silo's release
+// key, and replaces the running executable.
+package selfupdate
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Repo is the GitHub repository silo is released from.
+const Repo = "leighmcculloch/silo"
+
+// APIURL is the GitHub API the latest release is looked up with.
+var APIURL = "https://api.github.com"
+
+// ChecksumsName is the release asset listing the SHA-256 of every archive,
+// in the format of sha256sum.
+const ChecksumsName = "checksums.txt"
+
+// Release is a release of silo.
+type Release struct {
+	Version string            // tag, e.g. v1.2.0
+	URL     string            // release page
+	Assets  map[string]string // asset name -> download URL
+}
+
+// Latest returns the latest release of silo, excluding pre-releases.
+func Latest(ctx context.Context) (Release, error) {
+	var rel struct {
+		TagName string `json:"tag_name"`
+		HTMLURL string `json:"html_url"`
+		Assets  []struct {
+			Name string `json:"name"`
+			URL  string `json:"browser_download_url"`
+		} `json:"assets"`
+	}
+	body, err := get(ctx, APIURL+"/repos/"+Repo+"/releases/latest")
+	if err != nil {
+		return Release{}, fmt.Errorf("failed to find the latest release: %w", err)
+	}
+	if err := json.Unmarshal(body, &rel); err != nil {
+		return Release{}, fmt.Errorf("failed to parse the latest release: %w", err)
+	}
+	r := Release{Version: rel.TagName, URL: rel.HTMLURL, Assets: make(map[string]string)}
+	for _, a := range rel.Assets {
+		r.Assets[a.Name] = a.URL
+	}
+	return r, nil
+}
+
+// AssetName returns the name of the release archive of version for goos and
+// goarch, e.g. silo_1.2.0_darwin_arm64.tar.gz.
+func AssetName(version, goos, goarch string) string {
+	return fmt.Sprintf("silo_%s_%s_%s.tar.gz", strings.TrimPrefix(version, "v"), goos, goarch)
+}
+
+// Download downloads the archive of rel for goos and goarch, verifies it
+// against the release's checksums, after verifying their signature with
+// PublicKey, and returns the silo binary in it.
+func Download(ctx context.Context, rel Release, goos, goarch string) ([]byte, error) {
+	if PublicKey == "" {
+		return nil, errors.New("this silo has no release key to verify the download with, as it wasn't built by a release")
+	}
+	name := AssetName(rel.Version, goos, goarch)
+	url, ok := rel.Assets[name]
+	if !ok {
+		return nil, fmt.Errorf("release %s has no build for %s/%s", rel.Version, goos, goarch)
+	}
+	sumsURL, ok := rel.Assets[ChecksumsName]
+	if !ok {
+		return nil, fmt.Errorf("release %s has no %s to verify the download with", rel.Version, ChecksumsName)
+	}
+	sigURL, ok := rel.Assets[SignatureName]
+	if !ok {
+		return nil, fmt.Errorf("release %s has no %s to verify its checksums with", rel.Version, SignatureName)
+	}
+	sums, err := get(ctx, sumsURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", ChecksumsName, err)
+	}
+	sig, err := get(ctx, sigURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", SignatureName, err)
+	}
+	if err := verify(PublicKey, sums, sig); err != nil {
+		return nil, fmt.Errorf("failed to verify the signature of %s of release %s: %w", ChecksumsName, rel.Version, err)
+	}
+	want, err := checksum(sums, name)
+	if err != nil {
+		return nil, err
+	}
+	archive, err := get(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", name, err)
+	}
+	if got := sha256.Sum256(archive); hex.EncodeToString(got[:]) != want {
+		return nil, fmt.Errorf("checksum mismatch for %s: the download is corrupt or was replaced after it was signed", name)
+	}
+	return extract(archive)
+}
+
+// checksum returns the SHA-256 of name in sums, the contents of a checksums
+// file.
+func checksum(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		sum, file, ok := strings.Cut(scanner.Text(), "  ")
+		if ok && strings.TrimPrefix(file, "*") == name {
+			return strings.ToLower(sum), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no checksum for %s", ChecksumsName, name)
+}
+
+// extract returns the silo binary in archive, a gzipped tar.
+func extract(archive []byte) ([]byte, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("archive has no silo binary")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if hdr.Typeflag == tar.TypeReg && filepath.Base(hdr.Name) == "silo" {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// Replace replaces the executable exe with binary. The binary is written
+// next to exe and renamed over it, so exe is never partially written, and a
+// running silo keeps running the old one.
+func Replace(exe string, binary []byte) error {
+	exe, err := filepath.EvalSymlinks(exe)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(exe), ".silo-update-*")
+	if err != nil {
+		return fmt.Errorf("can't write to %s: %w", filepath.Dir(exe), err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(binary); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), exe); err != nil {
+		return fmt.Errorf("can't replace %s: %w", exe, err)
+	}
+	return nil
+}
+
+// Newer reports whether version a is newer than version b, both of the form
+// v1.2.3, with an optional pre-release suffix, which is older than the
+// release. Versions that can't be parsed are never newer.
+func Newer(a, b string) bool {
+	va, preA, okA := parse(a)
+	vb, preB, okB := parse(b)
+	if !okA || !okB {
+		return false
+	}
+	for i := range va {
+		if va[i] != vb[i] {
+			return va[i] > vb[i]
+		}
+	}
+	if preA == "" || preB == "" {
+		return preA == "" && preB != ""
+	}
+	return preA > preB
+}
+
+// parse parses the version v into its numbers and pre-release suffix.
+func parse(v string) (nums [3]int, pre string, ok bool) {
+	v, ok = strings.CutPrefix(v, "v")
+	if !ok {
+		return nums, "", false
+	}
+	v, _, _ = strings.Cut(v, "+")
+	v, pre, _ = strings.Cut(v, "-")
+	parts := strings.Split(v, ".")
+	if len(parts) != 3 {
+		return nums, "", false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return nums, "", false
+		}
+		nums[i] = n
+	}
+	return nums, pre, true
+}
+
+// get returns the body of url.
+func get(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
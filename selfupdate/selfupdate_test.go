@@ -0,0 +1,189 @@
+package selfupdate
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+func TestNewer(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"v1.2.0", "v1.1.9", true},
+		{"v1.10.0", "v1.9.0", true},
+		{"v1.2.0", "v1.2.0", false},
+		{"v1.2.0", "v1.2.0-rc.1", true},
+		{"v1.2.0-rc.1", "v1.2.0", false},
+		{"v1.2.0-rc.2", "v1.2.0-rc.1", true},
+		{"v1.2.0", "dev", false},
+		{"v2", "v1.0.0", false},
+	}
+	for _, tt := range tests {
+		if got := Newer(tt.a, tt.b); got != tt.want {
+			t.Errorf("Newer(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+// archive returns a gzipped tar holding files.
+func archive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0o755, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(content))
+	}
+	tw.Close()
+	gz.Close()
+	return buf.Bytes()
+}
+
+// minisignKey returns a new key pair, the public key as the base64 line of
+// minisign.pub.
+func minisignKey(t *testing.T) (string, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyID := []byte("silotest")
+	return base64.StdEncoding.EncodeToString(slices.Concat([]byte("Ed"), keyID, pub)), priv
+}
+
+// minisign returns the minisign signature file of data signed by priv,
+// prehashed as minisign does by default.
+func minisign(priv ed25519.PrivateKey, data []byte) []byte {
+	sum := blake2b.Sum512(data)
+	sig := ed25519.Sign(priv, sum[:])
+	trusted := "timestamp:1700000000\tfile:checksums.txt\thashed"
+	global := ed25519.Sign(priv, slices.Concat(sig, []byte(trusted)))
+	return fmt.Appendf(nil, "untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(slices.Concat([]byte("ED"), []byte("silotest"), sig)), trusted, base64.StdEncoding.EncodeToString(global))
+}
+
+func TestVerify(t *testing.T) {
+	pub, priv := minisignKey(t)
+	otherPub, _ := minisignKey(t)
+	data := []byte("checksums")
+	sig := minisign(priv, data)
+	if err := verify(pub, data, sig); err != nil {
+		t.Errorf("verify() = %v, want a valid signature", err)
+	}
+	if err := verify(pub, []byte("tampered"), sig); err == nil {
+		t.Error("verify() of other data = nil, want an error")
+	}
+	if err := verify(otherPub, data, sig); err == nil {
+		t.Error("verify() with another key = nil, want an error")
+	}
+	tamperedComment := bytes.Replace(sig, []byte("hashed"), []byte("hashex"), 1)
+	if err := verify(pub, data, tamperedComment); err == nil {
+		t.Error("verify() with a changed trusted comment = nil, want an error")
+	}
+}
+
+func TestLatestAndDownload(t *testing.T) {
+	pub, priv := minisignKey(t)
+	defer func(k string) { PublicKey = k }(PublicKey)
+	PublicKey = pub
+
+	name := AssetName("v1.2.0", "linux", "amd64")
+	data := archive(t, map[string]string{"README.md": "readme", "silo": "new binary"})
+	sum := sha256.Sum256(data)
+	sums := fmt.Sprintf("%x  %s\n%x  other.tar.gz\n", sum, name, sha256.Sum256(nil))
+	sig := minisign(priv, []byte(sums))
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/" + Repo + "/releases/latest":
+			fmt.Fprintf(w, `{"tag_name":"v1.2.0","html_url":"https://example.com/v1.2.0","assets":[{"name":%q,"browser_download_url":%q},{"name":"checksums.txt","browser_download_url":%q},{"name":"checksums.txt.minisig","browser_download_url":%q}]}`,
+				name, srv.URL+"/"+name, srv.URL+"/checksums.txt", srv.URL+"/checksums.txt.minisig")
+		case "/" + name:
+			w.Write(data)
+		case "/checksums.txt":
+			w.Write([]byte(sums))
+		case "/checksums.txt.minisig":
+			w.Write(sig)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(u string) { APIURL = u }(APIURL)
+	APIURL = srv.URL
+
+	rel, err := Latest(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rel.Version != "v1.2.0" || len(rel.Assets) != 3 {
+		t.Fatalf("Latest() = %+v, want v1.2.0 with 3 assets", rel)
+	}
+	bin, err := Download(context.Background(), rel, "linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bin) != "new binary" {
+		t.Errorf("Download() = %q, want the silo binary", bin)
+	}
+
+	if _, err := Download(context.Background(), rel, "windows", "amd64"); err == nil || !strings.Contains(err.Error(), "no build") {
+		t.Errorf("Download() error = %v, want no build for the platform", err)
+	}
+
+	data = archive(t, map[string]string{"silo": "tampered"})
+	if _, err := Download(context.Background(), rel, "linux", "amd64"); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("Download() error = %v, want a checksum mismatch", err)
+	}
+
+	// Replacing the checksums along with the archive doesn't help
+	sum = sha256.Sum256(data)
+	sums = fmt.Sprintf("%x  %s\n", sum, name)
+	if _, err := Download(context.Background(), rel, "linux", "amd64"); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("Download() error = %v, want a signature mismatch", err)
+	}
+
+	PublicKey = ""
+	if _, err := Download(context.Background(), rel, "linux", "amd64"); err == nil || !strings.Contains(err.Error(), "no release key") {
+		t.Errorf("Download() error = %v, want no release key", err)
+	}
+}
+
+func TestReplace(t *testing.T) {
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "silo")
+	os.WriteFile(exe, []byte("old"), 0o755)
+	link := filepath.Join(dir, "link")
+	os.Symlink(exe, link)
+
+	if err := Replace(link, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(exe); string(got) != "new" {
+		t.Errorf("executable = %q, want it replaced through the symlink", got)
+	}
+	if fi, _ := os.Lstat(link); fi.Mode()&os.ModeSymlink == 0 {
+		t.Error("expected the symlink kept")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("expected no temporary files left, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,78 @@
+package selfupdate
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// PublicKey is the minisign public key, the base64 line of minisign.pub,
+// that checksums.txt of releases is signed with. It is set when a release
+// is built, with -ldflags "-X .../selfupdate.PublicKey=...", so builds from
+// source have none and can't verify an update.
+var PublicKey = ""
+
+// SignatureName is the release asset holding the minisign signature of
+// ChecksumsName.
+const SignatureName = ChecksumsName + ".minisig"
+
+// verify reports whether sig, the contents of a minisign signature file, is
+// a valid signature of data by the key publicKey, the base64 line of a
+// minisign public key file. Both signatures of data itself and of its
+// BLAKE2b-512 hash, minisign's default, are accepted.
+func verify(publicKey string, data, sig []byte) error {
+	pk, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(pk) != 2+8+ed25519.PublicKeySize || string(pk[:2]) != "Ed" {
+		return errors.New("invalid public key")
+	}
+	keyID, key := pk[2:10], ed25519.PublicKey(pk[10:])
+
+	lines := strings.Split(strings.TrimRight(string(sig), "\n"), "\n")
+	if len(lines) != 4 {
+		return errors.New("invalid signature")
+	}
+	s, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(s) != 2+8+ed25519.SignatureSize {
+		return errors.New("invalid signature")
+	}
+	alg, sigKeyID, signature := string(s[:2]), s[2:10], s[10:]
+	if !bytes.Equal(sigKeyID, keyID) {
+		return fmt.Errorf("signed with key %X, not silo's release key %X", reverse(sigKeyID), reverse(keyID))
+	}
+	switch alg {
+	case "Ed":
+	case "ED":
+		sum := blake2b.Sum512(data)
+		data = sum[:]
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", alg)
+	}
+	if !ed25519.Verify(key, data, signature) {
+		return errors.New("signature doesn't match")
+	}
+
+	// The trusted comment is signed too, along with the signature
+	trusted, ok := strings.CutPrefix(lines[2], "trusted comment: ")
+	if !ok {
+		return errors.New("invalid signature: no trusted comment")
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || !ed25519.Verify(key, append(bytes.Clone(signature), trusted...), global) {
+		return errors.New("trusted comment signature doesn't match")
+	}
+	return nil
+}
+
+// reverse returns b reversed, as minisign shows key IDs little-endian.
+func reverse(b []byte) []byte {
+	r := bytes.Clone(b)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return r
+}
@@ -0,0 +1,46 @@
+// Package statedir locates the directory silo writes its state to: the run
+// journal, copies of ephemeral mounts, and staged file mounts. It is silo's
+// directory under XDG_STATE_HOME unless state_dir is configured, e.g. to
+// keep everything a workspace's sessions write in the workspace. Lock files
+// are always under XDG_STATE_HOME.
+package statedir
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/adrg/xdg"
+)
+
+var dir string
+
+// Set makes Dir return path, usually state_dir from the config. A path
+// starting with ~/ is resolved against HOME. An empty path restores the
+// default.
+func Set(path string) {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		path = filepath.Join(os.Getenv("HOME"), rest)
+	}
+	dir = path
+}
+
+// Dir returns the state directory.
+func Dir() string {
+	if dir != "" {
+		return dir
+	}
+	return Default()
+}
+
+// Default returns silo's directory under XDG_STATE_HOME, the state
+// directory unless state_dir is configured. State every silo process must
+// agree on, whatever its config, such as locks, is kept here.
+func Default() string {
+	return filepath.Join(xdg.StateHome, "silo")
+}
+
+// Path returns elem joined to the state directory.
+func Path(elem ...string) string {
+	return filepath.Join(append([]string{Dir()}, elem...)...)
+}
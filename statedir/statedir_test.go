@@ -0,0 +1,30 @@
+package statedir
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/adrg/xdg"
+)
+
+func TestDir(t *testing.T) {
+	t.Cleanup(func() { Set("") })
+
+	if got, want := Path("locks"), filepath.Join(xdg.StateHome, "silo", "locks"); got != want {
+		t.Errorf("default Path = %q, want %q", got, want)
+	}
+
+	Set("/work/.silo/state")
+	if got := Path("ephemeral", "silo-1"); got != "/work/.silo/state/ephemeral/silo-1" {
+		t.Errorf("Path = %q, want it under the set directory", got)
+	}
+	if got, want := Default(), filepath.Join(xdg.StateHome, "silo"); got != want {
+		t.Errorf("Default = %q, want %q whatever is set", got, want)
+	}
+
+	t.Setenv("HOME", "/home/me")
+	Set("~/state")
+	if got := Dir(); got != "/home/me/state" {
+		t.Errorf("Dir = %q, want ~ resolved against HOME", got)
+	}
+}
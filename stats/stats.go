@@ -0,0 +1,180 @@
+// Package stats summarizes the run journal: per-tool usage, and metrics for
+// monitoring. Everything is computed locally; nothing is sent anywhere.
+package stats
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/leighmcculloch/silo/journal"
+)
+
+// toolStats aggregates journal entries for a single tool/backend pair.
+type toolStats struct {
+	tool           string
+	backend        string
+	sessions       int
+	builds         int
+	buildSeconds   float64
+	sessionSeconds float64
+	lastSession    int64
+}
+
+// aggregate groups journal entries by tool and backend, sorted by tool then
+// backend so output is stable between runs.
+func aggregate(entries []journal.Entry) []*toolStats {
+	byKey := make(map[string]*toolStats)
+	var keys []string
+	for _, e := range entries {
+		key := e.Tool + "\x00" + e.Backend
+		s, ok := byKey[key]
+		if !ok {
+			s = &toolStats{tool: e.Tool, backend: e.Backend}
+			byKey[key] = s
+			keys = append(keys, key)
+		}
+		s.sessions++
+		if e.Built {
+			s.builds++
+			s.buildSeconds += e.BuildSeconds
+		}
+		s.sessionSeconds += e.SessionSeconds
+		if ts := e.Time.Unix(); ts > s.lastSession {
+			s.lastSession = ts
+		}
+	}
+	slices.Sort(keys)
+	result := make([]*toolStats, len(keys))
+	for i, k := range keys {
+		result[i] = byKey[k]
+	}
+	return result
+}
+
+// WritePrometheus writes a metrics snapshot of the journal entries in the
+// Prometheus text exposition format, suitable for node_exporter's textfile
+// collector.
+func WritePrometheus(w io.Writer, entries []journal.Entry) error {
+	all := aggregate(entries)
+
+	var b strings.Builder
+	metric := func(name, typ, help string, value func(s *toolStats) string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, typ)
+		for _, s := range all {
+			fmt.Fprintf(&b, "%s{tool=%q,backend=%q} %s\n", name, s.tool, s.backend, value(s))
+		}
+	}
+	summary := func(name, help string, sum func(s *toolStats) float64, count func(s *toolStats) int) {
+		fmt.Fprintf(&b, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(&b, "# TYPE %s summary\n", name)
+		for _, s := range all {
+			fmt.Fprintf(&b, "%s_sum{tool=%q,backend=%q} %g\n", name, s.tool, s.backend, sum(s))
+			fmt.Fprintf(&b, "%s_count{tool=%q,backend=%q} %d\n", name, s.tool, s.backend, count(s))
+		}
+	}
+
+	metric("silo_sessions_total", "counter", "Total number of silo sessions started.",
+		func(s *toolStats) string { return fmt.Sprint(s.sessions) })
+	summary("silo_build_seconds", "Time spent building images.",
+		func(s *toolStats) float64 { return s.buildSeconds },
+		func(s *toolStats) int { return s.builds })
+	summary("silo_session_seconds", "Time spent running tool sessions.",
+		func(s *toolStats) float64 { return s.sessionSeconds },
+		func(s *toolStats) int { return s.sessions })
+	metric("silo_last_session_timestamp_seconds", "gauge", "Unix time of the most recent session.",
+		func(s *toolStats) string { return fmt.Sprint(s.lastSession) })
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Periods usage can be summarized by.
+const (
+	PeriodDay   = "day"
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+)
+
+// Summary is the usage of a tool, over all time or a period.
+type Summary struct {
+	Period         string    `json:"period,omitempty"` // start of the period, e.g. 2026-10 for a month
+	Tool           string    `json:"tool"`
+	Sessions       int       `json:"sessions"`
+	SessionSeconds float64   `json:"session_seconds"`
+	Builds         int       `json:"builds"`
+	BuildSeconds   float64   `json:"build_seconds"`
+	CacheHitRate   *float64  `json:"cache_hit_rate"` // share of sessions whose image was already built, nil if none used an image
+	LastSession    time.Time `json:"last_session"`
+}
+
+// Summarize summarizes the journal entries by tool and, unless period is
+// "", by the period they started in, in local time. Summaries are sorted
+// by period, most recent first, then by session time, most used first.
+func Summarize(entries []journal.Entry, period string) ([]Summary, error) {
+	type key struct{ period, tool string }
+	byKey := make(map[key]*Summary)
+	images := make(map[key]int) // sessions that used an image, unlike the sandbox backend
+	for _, e := range entries {
+		p, err := periodStart(e.Time, period)
+		if err != nil {
+			return nil, err
+		}
+		k := key{p, e.Tool}
+		s, ok := byKey[k]
+		if !ok {
+			s = &Summary{Period: p, Tool: e.Tool}
+			byKey[k] = s
+		}
+		s.Sessions++
+		s.SessionSeconds += e.SessionSeconds
+		if e.Built {
+			s.Builds++
+			s.BuildSeconds += e.BuildSeconds
+		}
+		if e.ImageTag != "" {
+			images[k]++
+		}
+		if e.Time.After(s.LastSession) {
+			s.LastSession = e.Time
+		}
+	}
+	result := make([]Summary, 0, len(byKey))
+	for k, s := range byKey {
+		if n := images[k]; n > 0 {
+			rate := float64(n-s.Builds) / float64(n)
+			s.CacheHitRate = &rate
+		}
+		result = append(result, *s)
+	}
+	slices.SortFunc(result, func(a, b Summary) int {
+		return cmp.Or(
+			strings.Compare(b.Period, a.Period),
+			cmp.Compare(b.SessionSeconds, a.SessionSeconds),
+			strings.Compare(a.Tool, b.Tool),
+		)
+	})
+	return result, nil
+}
+
+// periodStart returns the start of the period t is in: the day, the Monday
+// of the week, or the month. It's "" if period is "".
+func periodStart(t time.Time, period string) (string, error) {
+	t = t.Local()
+	switch period {
+	case "":
+		return "", nil
+	case PeriodDay:
+		return t.Format("2006-01-02"), nil
+	case PeriodWeek:
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		return t.AddDate(0, 0, -daysSinceMonday).Format("2006-01-02"), nil
+	case PeriodMonth:
+		return t.Format("2006-01"), nil
+	}
+	return "", fmt.Errorf("unknown period: %s (valid: day, week, month)", period)
+}
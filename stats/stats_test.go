@@ -0,0 +1,100 @@
+package stats
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/leighmcculloch/silo/journal"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	entries := []journal.Entry{
+		{Time: ts, Tool: "claude", Backend: "docker", Built: true, BuildSeconds: 120, SessionSeconds: 600},
+		{Time: ts.Add(time.Hour), Tool: "claude", Backend: "docker", SessionSeconds: 300},
+		{Time: ts, Tool: "opencode", Backend: "container", SessionSeconds: 60},
+	}
+
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf, entries); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	out := buf.String()
+
+	want := []string{
+		"# TYPE silo_sessions_total counter",
+		`silo_sessions_total{tool="claude",backend="docker"} 2`,
+		`silo_sessions_total{tool="opencode",backend="container"} 1`,
+		`silo_build_seconds_sum{tool="claude",backend="docker"} 120`,
+		`silo_build_seconds_count{tool="claude",backend="docker"} 1`,
+		`silo_build_seconds_count{tool="opencode",backend="container"} 0`,
+		`silo_session_seconds_sum{tool="claude",backend="docker"} 900`,
+		`silo_last_session_timestamp_seconds{tool="claude",backend="docker"} 1700003600`,
+	}
+	for _, w := range want {
+		if !strings.Contains(out, w) {
+			t.Errorf("expected output to contain %q, got:\n%s", w, out)
+		}
+	}
+}
+
+func TestWritePrometheusEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf, nil); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	if !strings.Contains(buf.String(), "# TYPE silo_sessions_total counter") {
+		t.Errorf("expected metric metadata even without entries, got:\n%s", buf.String())
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	monday := time.Date(2026, 10, 12, 10, 0, 0, 0, time.Local)
+	entries := []journal.Entry{
+		{Time: monday, Tool: "claude", ImageTag: "img", Built: true, BuildSeconds: 60, SessionSeconds: 600},
+		{Time: monday.AddDate(0, 0, 2), Tool: "claude", ImageTag: "img", SessionSeconds: 300},
+		{Time: monday.AddDate(0, 0, 7), Tool: "claude", ImageTag: "img", SessionSeconds: 100},
+		{Time: monday.AddDate(0, 0, 8), Tool: "opencode", ImageTag: "img", SessionSeconds: 200},
+		{Time: monday.AddDate(0, 0, 8), Tool: "copilot", SessionSeconds: 50},
+	}
+
+	all, err := Summarize(entries, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].Tool != "claude" || all[1].Tool != "opencode" {
+		t.Fatalf("Summarize() = %+v, want claude, opencode, copilot by session time", all)
+	}
+	claude := all[0]
+	if claude.Sessions != 3 || claude.SessionSeconds != 1000 || claude.Builds != 1 || claude.BuildSeconds != 60 {
+		t.Errorf("claude = %+v", claude)
+	}
+	if claude.CacheHitRate == nil || *claude.CacheHitRate < 0.66 || *claude.CacheHitRate > 0.67 {
+		t.Errorf("claude cache hit rate = %v, want 2/3", claude.CacheHitRate)
+	}
+	if !claude.LastSession.Equal(monday.AddDate(0, 0, 7)) {
+		t.Errorf("claude last session = %v", claude.LastSession)
+	}
+	if all[2].CacheHitRate != nil {
+		t.Errorf("expected no cache hit rate for sessions without an image, got %v", *all[2].CacheHitRate)
+	}
+
+	weekly, err := Summarize(entries, PeriodWeek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, s := range weekly {
+		got = append(got, s.Period+" "+s.Tool)
+	}
+	want := []string{"2026-10-19 opencode", "2026-10-19 claude", "2026-10-19 copilot", "2026-10-12 claude"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("weekly = %v, want %v", got, want)
+	}
+
+	if _, err := Summarize(entries, "year"); err == nil {
+		t.Error("expected an error for an unknown period")
+	}
+}
@@ -0,0 +1,119 @@
+// Package termstate keeps a registry of the changes silo makes to the
+// terminal, such as raw mode, a hidden cursor, or the alternate screen, so
+// they are undone however silo exits: returning normally, panicking, or
+// being hung up on or quit with a signal. Without it a crash in the middle
+// of a progress view or an attached session leaves the terminal unusable.
+package termstate
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+
+	"github.com/moby/term"
+)
+
+// Escape sequences for the terminal modes that aren't part of the termios
+// state.
+const (
+	ShowCursor     = "\x1b[?25h"
+	EnterAltScreen = "\x1b[?1049h"
+	LeaveAltScreen = "\x1b[?1049l"
+	DisableMouse   = "\x1b[?1000l\x1b[?1002l\x1b[?1003l\x1b[?1006l" // click, button, and all tracking, and SGR mode
+)
+
+type change struct {
+	id      int
+	restore func()
+}
+
+var (
+	mu      sync.Mutex
+	changes []change
+	nextID  int
+)
+
+// Register records a change made to the terminal with the function that
+// undoes it, and returns a function that undoes it and removes it from the
+// registry. The returned function can be called more than once, and is
+// meant to be deferred where the change is made.
+func Register(restore func()) func() {
+	mu.Lock()
+	nextID++
+	id := nextID
+	changes = append(changes, change{id: id, restore: restore})
+	mu.Unlock()
+
+	return func() {
+		mu.Lock()
+		i := slices.IndexFunc(changes, func(c change) bool { return c.id == id })
+		if i < 0 {
+			mu.Unlock()
+			return
+		}
+		changes = slices.Delete(changes, i, i+1)
+		mu.Unlock()
+		restore()
+	}
+}
+
+// RestoreAll undoes every change still registered, latest first.
+func RestoreAll() {
+	mu.Lock()
+	pending := changes
+	changes = nil
+	mu.Unlock()
+	for i := len(pending) - 1; i >= 0; i-- {
+		pending[i].restore()
+	}
+}
+
+// Guard restores the terminal if the function deferring it panics, then
+// panics again with the same value. It must be called directly by defer.
+func Guard() {
+	if r := recover(); r != nil {
+		RestoreAll()
+		panic(r)
+	}
+}
+
+// MakeRaw puts the terminal fd into raw mode and returns the function
+// restoring its previous state.
+func MakeRaw(fd uintptr) (func(), error) {
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		return nil, err
+	}
+	return Register(func() { _ = term.RestoreTerminal(fd, state) }), nil
+}
+
+// AltScreen switches w to the alternate screen and returns the function
+// switching back.
+func AltScreen(w io.Writer) func() {
+	_, _ = io.WriteString(w, EnterAltScreen)
+	return Register(func() { _, _ = io.WriteString(w, LeaveAltScreen) })
+}
+
+// fatalSignals are the signals that end silo without it cleaning up, as
+// nothing else handles them: the terminal hanging up, and quit.
+var fatalSignals = []os.Signal{syscall.SIGHUP, syscall.SIGQUIT}
+
+// HandleSignals restores the terminal when silo is ended by a signal it
+// doesn't otherwise handle, then lets the signal end it as it would have.
+// Interrupts and SIGTERM are handled where sessions run, which return
+// normally and so undo their changes.
+func HandleSignals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, fatalSignals...)
+	go func() {
+		sig := <-ch
+		RestoreAll()
+		signal.Reset(fatalSignals...)
+		if p, err := os.FindProcess(os.Getpid()); err == nil {
+			_ = p.Signal(sig)
+		}
+	}()
+}
@@ -0,0 +1,53 @@
+package termstate
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	var undone []string
+	restoreA := Register(func() { undone = append(undone, "a") })
+	Register(func() { undone = append(undone, "b") })
+	Register(func() { undone = append(undone, "c") })
+
+	restoreA()
+	restoreA()
+	if !slices.Equal(undone, []string{"a"}) {
+		t.Fatalf("undone = %v, want a undone once", undone)
+	}
+
+	RestoreAll()
+	if want := []string{"a", "c", "b"}; !slices.Equal(undone, want) {
+		t.Errorf("undone = %v, want the rest undone latest first %v", undone, want)
+	}
+	RestoreAll()
+	if len(undone) != 3 {
+		t.Errorf("undone = %v, want nothing undone twice", undone)
+	}
+}
+
+func TestGuard(t *testing.T) {
+	var buf bytes.Buffer
+	leave := AltScreen(&buf)
+	defer leave()
+
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("recovered %v, want the panic to continue", r)
+			}
+		}()
+		defer Guard()
+		panic("boom")
+	}()
+
+	if got, want := buf.String(), EnterAltScreen+LeaveAltScreen; got != want {
+		t.Errorf("terminal got %q, want %q", got, want)
+	}
+	leave()
+	if got := buf.String(); got != EnterAltScreen+LeaveAltScreen {
+		t.Errorf("terminal got %q, want the alternate screen left once", got)
+	}
+}
@@ -0,0 +1,123 @@
+// Package tmpl renders the templated strings of silo's config, such as
+// container_name, so every templated field has the same variables and
+// functions.
+package tmpl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+	"unicode"
+)
+
+// Vars are the variables available to templates, e.g. {{.Repo}}.
+type Vars struct {
+	Dir    string    // base name of the directory silo runs in
+	Path   string    // full path of the directory silo runs in
+	Repo   string    // name of the repository of the first git remote
+	Branch string    // git branch checked out, empty if detached
+	Tool   string    // name of the tool being run
+	User   string    // name of the host user
+	Time   time.Time // time the run started
+}
+
+// Doc describes a variable or function of templates.
+type Doc struct {
+	Name        string
+	Description string
+}
+
+// VarDocs describes each field of Vars.
+var VarDocs = []Doc{
+	{".Dir", "base name of the directory silo runs in"},
+	{".Path", "full path of the directory silo runs in"},
+	{".Repo", "name of the repository of the first git remote, e.g. silo"},
+	{".Branch", "git branch checked out, empty if detached"},
+	{".Tool", "name of the tool being run"},
+	{".User", "name of the host user"},
+	{".Time", "time the run started, for use with date"},
+}
+
+// FuncDocs describes each function of templates.
+var FuncDocs = []Doc{
+	{"lower", "lowercase a string: {{lower .Branch}}"},
+	{"upper", "uppercase a string: {{upper .Tool}}"},
+	{"slug", "lowercase a string and replace runs of other characters than letters and digits with -: {{slug .Branch}}"},
+	{"shortHash", "first 8 hex characters of the SHA-256 of a string: {{shortHash .Path}}"},
+	{"date", "format a time with a Go layout: {{date \"2006-01-02\" .Time}}"},
+}
+
+var funcs = template.FuncMap{
+	"lower":     strings.ToLower,
+	"upper":     strings.ToUpper,
+	"slug":      Slug,
+	"shortHash": shortHash,
+	"date":      func(layout string, t time.Time) string { return t.Format(layout) },
+}
+
+// parse parses text as a template. name identifies the template in errors,
+// e.g. the config field.
+func parse(name, text string) (*template.Template, error) {
+	t, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return t, nil
+}
+
+// Render renders text as a template with vars.
+func Render(name, text string, vars Vars) (string, error) {
+	t, err := parse(name, text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, vars); err != nil {
+		return "", fmt.Errorf("invalid %s: %w", name, err)
+	}
+	return b.String(), nil
+}
+
+// Check reports syntax errors, unknown functions, and unknown variables in
+// text as a template.
+func Check(name, text string) error {
+	_, err := Render(name, text, Vars{})
+	return err
+}
+
+// Slug lowercases s and replaces each run of characters other than letters
+// and digits with a hyphen, trimming hyphens from the ends.
+func Slug(s string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+	return b.String()
+}
+
+func shortHash(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])[:8]
+}
+
+// RepoName returns the name of the repository at a git remote URL, e.g.
+// "silo" for git@github.com:leighmcculloch/silo.git.
+func RepoName(url string) string {
+	url = strings.TrimSuffix(strings.TrimRight(url, "/"), ".git")
+	if i := strings.LastIndexAny(url, ":/"); i >= 0 {
+		url = url[i+1:]
+	}
+	return url
+}
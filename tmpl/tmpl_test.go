@@ -0,0 +1,76 @@
+package tmpl
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	vars := Vars{
+		Dir:    "silo",
+		Path:   "/src/silo",
+		Repo:   "silo",
+		Branch: "feature/Add-Thing",
+		Tool:   "claude",
+		User:   "leigh",
+		Time:   time.Date(2026, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+	for _, tt := range []struct {
+		text, want string
+	}{
+		{"{{.Repo}}-{{slug .Branch}}", "silo-feature-add-thing"},
+		{"{{upper .Tool}}-{{lower .Branch}}", "CLAUDE-feature/add-thing"},
+		{"{{.User}}-{{date \"20060102\" .Time}}", "leigh-20260304"},
+		{"{{.Dir}}-{{shortHash .Path}}", "silo-" + shortHash("/src/silo")},
+	} {
+		got, err := Render("container_name", tt.text, vars)
+		if err != nil {
+			t.Errorf("Render(%q): %v", tt.text, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Render(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+	if len(shortHash("x")) != 8 {
+		t.Errorf("shortHash = %q, want 8 characters", shortHash("x"))
+	}
+}
+
+func TestCheck(t *testing.T) {
+	if err := Check("container_name", "{{.Repo}}-{{slug .Branch}}"); err != nil {
+		t.Errorf("Check: %v", err)
+	}
+	for _, text := range []string{"{{.Repo", "{{.Nope}}", "{{nope .Repo}}"} {
+		err := Check("container_name", text)
+		if err == nil || !strings.Contains(err.Error(), "container_name") {
+			t.Errorf("Check(%q) = %v, want an error naming the field", text, err)
+		}
+	}
+}
+
+func TestSlug(t *testing.T) {
+	for in, want := range map[string]string{
+		"feature/Add-Thing": "feature-add-thing",
+		"--a__b--":          "a-b",
+		"":                  "",
+	} {
+		if got := Slug(in); got != want {
+			t.Errorf("Slug(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestRepoName(t *testing.T) {
+	for _, url := range []string{
+		"git@github.com:leighmcculloch/silo.git",
+		"https://github.com/leighmcculloch/silo",
+		"https://github.com/leighmcculloch/silo.git/",
+		"silo",
+	} {
+		if got := RepoName(url); got != "silo" {
+			t.Errorf("RepoName(%q) = %q, want silo", url, got)
+		}
+	}
+}
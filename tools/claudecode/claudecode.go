@@ -2,6 +2,9 @@ package claudecode
 
 import (
 	_ "embed"
+	"fmt"
+	"os"
+	"strings"
 
 	"github.com/leighmcculloch/silo/config"
 	"github.com/leighmcculloch/silo/tools"
@@ -18,13 +21,52 @@ var Tool = tools.Tool{
 	Command: func(home string) []string {
 		return []string{"claude", "--mcp-config=" + home + "/.claude/mcp.json", "--dangerously-skip-permissions"}
 	},
+	PermissionFlags: []string{"--dangerously-skip-permissions"},
+	Headless: func(prompt string) []string {
+		return []string{"-p", prompt}
+	},
 	DefaultConfig: func() config.ToolConfig {
 		return config.ToolConfig{
 			MountsRW: []string{
 				"~/.claude.json",
 				"~/.claude",
 			},
+			NetworkAllow: []string{
+				"api.anthropic.com",
+				"statsig.anthropic.com",
+				"sentry.io",
+			},
+		}
+	},
+	Auth: func() tools.Auth {
+		return tools.Auth{
+			Env:   []string{"ANTHROPIC_API_KEY", "CLAUDE_CODE_OAUTH_TOKEN"},
+			Files: []string{"~/.claude/.credentials.json"},
+			Hint:  "Claude Code will ask you to log in when it starts, and the login is kept in ~/.claude for later sessions. To use an API key instead, add ANTHROPIC_API_KEY to env.",
 		}
 	},
+	Instructions: tools.Instructions{
+		Reads:   []string{"CLAUDE.md"},
+		Include: includeInstructions,
+	},
+	MCP:           mcpConfig,
+	Usage:         usage,
 	LatestVersion: tools.FetchURLVersion("https://storage.googleapis.com/claude-code-dist-86c565f3-f756-42ad-8dfa-d59b1c096819/claude-code-releases/latest"),
 }
+
+// includeInstructions appends the contents of files to Claude Code's system
+// prompt, as it only reads CLAUDE.md on its own.
+func includeInstructions(files []string) (env, args []string) {
+	var b strings.Builder
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "Project instructions from %s:\n\n%s\n\n", f, strings.TrimSpace(string(data)))
+	}
+	if b.Len() == 0 {
+		return nil, nil
+	}
+	return nil, []string{"--append-system-prompt", strings.TrimSpace(b.String())}
+}
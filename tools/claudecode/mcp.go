@@ -0,0 +1,37 @@
+package claudecode
+
+import (
+	"encoding/json"
+
+	"github.com/leighmcculloch/silo/config"
+)
+
+// mcpServer is a server in Claude Code's MCP config.
+type mcpServer struct {
+	Type    string            `json:"type"`
+	Command string            `json:"command,omitempty"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// mcpConfig gives servers to Claude Code as an MCP config at path, which it
+// loads along with ~/.claude/mcp.json.
+func mcpConfig(servers map[string]config.MCPServer, path string) (data []byte, env, args []string) {
+	cfg := make(map[string]mcpServer, len(servers))
+	for name, s := range servers {
+		if s.URL != "" {
+			cfg[name] = mcpServer{Type: "http", URL: s.URL, Headers: s.Headers}
+		} else {
+			cfg[name] = mcpServer{Type: "stdio", Command: s.Command, Args: s.Args, Env: s.Env}
+		}
+	}
+	data, err := json.Marshal(map[string]any{"mcpServers": cfg})
+	if err != nil {
+		return nil, nil, nil
+	}
+	// The flag takes several configs, so the value is attached with = to
+	// keep it from taking the arguments that follow
+	return data, nil, []string{"--mcp-config=" + path}
+}
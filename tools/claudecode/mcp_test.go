@@ -0,0 +1,23 @@
+package claudecode
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/leighmcculloch/silo/config"
+)
+
+func TestMCPConfig(t *testing.T) {
+	data, env, args := mcpConfig(map[string]config.MCPServer{
+		"db":     {Command: "/home/u/bin/db-mcp", Args: []string{"--ro"}, Env: map[string]string{"DB": "x"}},
+		"remote": {URL: "https://example.com/mcp", Headers: map[string]string{"Authorization": "Bearer t"}},
+	}, "/state/mcp.json")
+	want := `{"mcpServers":{"db":{"type":"stdio","command":"/home/u/bin/db-mcp","args":["--ro"],"env":{"DB":"x"}},"remote":{"type":"http","url":"https://example.com/mcp","headers":{"Authorization":"Bearer t"}}}}`
+	if string(data) != want {
+		t.Errorf("mcpConfig() = %s, want %s", data, want)
+	}
+	// The token is only in the file, not on the command line
+	if env != nil || !slices.Equal(args, []string{"--mcp-config=/state/mcp.json"}) {
+		t.Errorf("mcpConfig() = %v, %v", env, args)
+	}
+}
@@ -0,0 +1,99 @@
+package claudecode
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/leighmcculloch/silo/tools"
+)
+
+// usage reads the token usage of the sessions in dir since a time from the
+// transcripts Claude Code writes to ~/.claude/projects/<dir>/<session>.jsonl.
+func usage(resolve func(string) string, dir string, since time.Time) (tools.Usage, bool) {
+	projectDir := resolve(filepath.Join(os.Getenv("HOME"), ".claude", "projects", projectName(dir)))
+	files, _ := filepath.Glob(filepath.Join(projectDir, "*.jsonl"))
+
+	var total tools.Usage
+	found := false
+	for _, f := range files {
+		if info, err := os.Stat(f); err != nil || info.ModTime().Before(since) {
+			continue
+		}
+		if u, ok := transcriptUsage(f, since); ok {
+			total = total.Add(u)
+			found = true
+		}
+	}
+	return total, found
+}
+
+// projectName returns the name of the directory Claude Code keeps the
+// transcripts of dir in: dir with each character other than letters and
+// digits replaced with -.
+func projectName(dir string) string {
+	return strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return r
+		}
+		return '-'
+	}, dir)
+}
+
+// transcriptLine is the part of a line of a transcript holding usage.
+type transcriptLine struct {
+	Timestamp time.Time `json:"timestamp"`
+	RequestID string    `json:"requestId"`
+	CostUSD   float64   `json:"costUSD"`
+	Message   struct {
+		ID    string `json:"id"`
+		Usage *struct {
+			InputTokens              int64 `json:"input_tokens"`
+			OutputTokens             int64 `json:"output_tokens"`
+			CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+			CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+		} `json:"usage"`
+	} `json:"message"`
+}
+
+// transcriptUsage returns the usage of the responses in a transcript since
+// a time. A response split over several lines is counted once.
+func transcriptUsage(path string, since time.Time) (tools.Usage, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return tools.Usage{}, false
+	}
+	defer f.Close()
+
+	var total tools.Usage
+	found := false
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for scanner.Scan() {
+		var line transcriptLine
+		if json.Unmarshal(scanner.Bytes(), &line) != nil || line.Message.Usage == nil || line.Timestamp.Before(since) {
+			continue
+		}
+		if key := line.Message.ID + line.RequestID; key != "" {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		u := line.Message.Usage
+		total = total.Add(tools.Usage{
+			InputTokens:      u.InputTokens,
+			OutputTokens:     u.OutputTokens,
+			CacheReadTokens:  u.CacheReadInputTokens,
+			CacheWriteTokens: u.CacheCreationInputTokens,
+			Cost:             line.CostUSD,
+		})
+		found = true
+	}
+	return total, found
+}
@@ -0,0 +1,49 @@
+package claudecode
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProjectName(t *testing.T) {
+	if got := projectName("/Users/me/code/my.repo"); got != "-Users-me-code-my-repo" {
+		t.Errorf("projectName() = %q", got)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	since := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	dir := filepath.Join(home, ".claude", "projects", projectName("/work/silo"))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	transcript := strings.Join([]string{
+		`{"type":"user","timestamp":"2026-10-01T12:00:01Z","message":{"role":"user","content":"hi"}}`,
+		`{"type":"assistant","timestamp":"2026-10-01T11:59:00Z","requestId":"req_0","message":{"id":"msg_0","usage":{"input_tokens":999,"output_tokens":999}}}`,
+		`{"type":"assistant","timestamp":"2026-10-01T12:00:02Z","requestId":"req_1","message":{"id":"msg_1","usage":{"input_tokens":10,"output_tokens":5,"cache_read_input_tokens":100,"cache_creation_input_tokens":20}}}`,
+		`{"type":"assistant","timestamp":"2026-10-01T12:00:02Z","requestId":"req_1","message":{"id":"msg_1","usage":{"input_tokens":10,"output_tokens":5,"cache_read_input_tokens":100,"cache_creation_input_tokens":20}}}`,
+		`{"type":"assistant","timestamp":"2026-10-01T12:00:03Z","requestId":"req_2","costUSD":0.5,"message":{"id":"msg_2","usage":{"input_tokens":1,"output_tokens":2}}}`,
+		`not json`,
+	}, "\n")
+	if err := os.WriteFile(filepath.Join(dir, "session.jsonl"), []byte(transcript), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	u, ok := usage(func(p string) string { return p }, "/work/silo", since)
+	if !ok {
+		t.Fatal("expected usage to be found")
+	}
+	if u.InputTokens != 11 || u.OutputTokens != 7 || u.CacheReadTokens != 100 || u.CacheWriteTokens != 20 || u.Cost != 0.5 {
+		t.Errorf("usage = %+v, want each response since the start counted once", u)
+	}
+
+	if _, ok := usage(func(p string) string { return p }, "/work/other", since); ok {
+		t.Error("expected no usage for a directory without transcripts")
+	}
+}
@@ -24,6 +24,10 @@ var Tool = tools.Tool{
 	Command: func(home string) []string {
 		return []string{"copilot", "--allow-all", "--disable-builtin-mcps"}
 	},
+	PermissionFlags: []string{"--allow-all"},
+	Headless: func(prompt string) []string {
+		return []string{"-p", prompt}
+	},
 	DefaultConfig: func() config.ToolConfig {
 		return config.ToolConfig{
 			MountsRW: []string{
@@ -35,8 +39,24 @@ var Tool = tools.Tool{
 			Env: []string{
 				"COPILOT_GITHUB_TOKEN",
 			},
+			NetworkAllow: []string{
+				"github.com",
+				"api.github.com",
+				"*.githubcopilot.com",
+			},
 		}
 	},
+	Auth: func() tools.Auth {
+		return tools.Auth{
+			Env:   []string{"COPILOT_GITHUB_TOKEN", "GH_TOKEN", "GITHUB_TOKEN"},
+			Files: []string{filepath.Join(config.XDGConfigHomeDir(), ".copilot", "config.json")},
+			Hint:  "Set COPILOT_GITHUB_TOKEN on the host to a token with the Copilot Requests permission, or run /login once Copilot starts.",
+		}
+	},
+	Instructions: tools.Instructions{
+		Reads: []string{"AGENTS.md", "CLAUDE.md", ".github/copilot-instructions.md"},
+	},
+	MCP:           mcpConfig,
 	LatestVersion: fetchLatestRelease,
 }
 
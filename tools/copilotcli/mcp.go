@@ -0,0 +1,36 @@
+package copilotcli
+
+import (
+	"encoding/json"
+
+	"github.com/leighmcculloch/silo/config"
+)
+
+// mcpConfig gives servers to Copilot CLI as an additional MCP config at
+// path, with all of their tools enabled.
+func mcpConfig(servers map[string]config.MCPServer, path string) (data []byte, env, args []string) {
+	cfg := make(map[string]map[string]any, len(servers))
+	for name, s := range servers {
+		srv := map[string]any{"tools": []string{"*"}}
+		if s.URL != "" {
+			srv["type"], srv["url"] = "http", s.URL
+			if len(s.Headers) > 0 {
+				srv["headers"] = s.Headers
+			}
+		} else {
+			// Local servers must have args, if empty
+			srv["type"], srv["command"], srv["args"] = "local", s.Command, append([]string{}, s.Args...)
+			if len(s.Env) > 0 {
+				srv["env"] = s.Env
+			}
+		}
+		cfg[name] = srv
+	}
+	data, err := json.Marshal(map[string]any{"mcpServers": cfg})
+	if err != nil {
+		return nil, nil, nil
+	}
+	// A value starting with @ is the path of the config rather than the
+	// config itself
+	return data, nil, []string{"--additional-mcp-config=@" + path}
+}
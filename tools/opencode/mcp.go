@@ -0,0 +1,35 @@
+package opencode
+
+import (
+	"encoding/json"
+
+	"github.com/leighmcculloch/silo/config"
+)
+
+// mcpServer is a server in OpenCode's config.
+type mcpServer struct {
+	Type        string            `json:"type"`
+	Command     []string          `json:"command,omitempty"`
+	Environment map[string]string `json:"environment,omitempty"`
+	URL         string            `json:"url,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// mcpConfig adds servers to the mcp section of OpenCode's config, in an
+// extra config at path, which OpenCode loads after the user's global config
+// and before the project's, so the user's config files are left as they are.
+func mcpConfig(servers map[string]config.MCPServer, path string) (data []byte, env, args []string) {
+	cfg := make(map[string]mcpServer, len(servers))
+	for name, s := range servers {
+		if s.URL != "" {
+			cfg[name] = mcpServer{Type: "remote", URL: s.URL, Headers: s.Headers}
+		} else {
+			cfg[name] = mcpServer{Type: "local", Command: append([]string{s.Command}, s.Args...), Environment: s.Env}
+		}
+	}
+	data, err := json.Marshal(map[string]any{"mcp": cfg})
+	if err != nil {
+		return nil, nil, nil
+	}
+	return data, []string{"OPENCODE_CONFIG=" + path}, nil
+}
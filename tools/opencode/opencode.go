@@ -2,6 +2,7 @@ package opencode
 
 import (
 	_ "embed"
+	"encoding/json"
 	"path/filepath"
 
 	"github.com/leighmcculloch/silo/config"
@@ -20,6 +21,9 @@ var Tool = tools.Tool{
 	Command: func(home string) []string {
 		return []string{"opencode"}
 	},
+	Headless: func(prompt string) []string {
+		return []string{"run", prompt}
+	},
 	DefaultConfig: func() config.ToolConfig {
 		return config.ToolConfig{
 			MountsRW: []string{
@@ -33,6 +37,34 @@ var Tool = tools.Tool{
 			Env: []string{
 				"OPENCODE_DISABLE_DEFAULT_PLUGINS=1",
 			},
+			NetworkAllow: []string{
+				"opencode.ai",
+				"models.dev",
+			},
+		}
+	},
+	Auth: func() tools.Auth {
+		return tools.Auth{
+			Env:          []string{"ANTHROPIC_API_KEY", "OPENAI_API_KEY", "OPENROUTER_API_KEY", "GEMINI_API_KEY"},
+			Files:        []string{filepath.Join(config.XDGDataHomeDir(), "opencode", "auth.json")},
+			LoginCommand: []string{"opencode", "auth", "login"},
+			Hint:         "Log in with opencode auth login; the login is kept for later sessions. To use an API key instead, add a provider key such as ANTHROPIC_API_KEY to env.",
 		}
 	},
+	Instructions: tools.Instructions{
+		Reads:   []string{"AGENTS.md", "CLAUDE.md"},
+		Include: includeInstructions,
+	},
+	MCP:   mcpConfig,
+	Usage: usage,
+}
+
+// includeInstructions adds files to the instructions of OpenCode's config,
+// given inline so the user's config files are left as they are.
+func includeInstructions(files []string) (env, args []string) {
+	content, err := json.Marshal(map[string]any{"instructions": files})
+	if err != nil {
+		return nil, nil
+	}
+	return []string{"OPENCODE_CONFIG_CONTENT=" + string(content)}, nil
 }
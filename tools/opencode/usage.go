@@ -0,0 +1,73 @@
+package opencode
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/leighmcculloch/silo/config"
+	"github.com/leighmcculloch/silo/tools"
+)
+
+// usage reads the token usage of the sessions in dir since a time from the
+// messages OpenCode stores in its data directory, one file per message at
+// storage/message/<session>/<message>.json.
+func usage(resolve func(string) string, dir string, since time.Time) (tools.Usage, bool) {
+	storage := resolve(filepath.Join(config.XDGDataHomeDir(), "opencode", "storage"))
+	files, _ := filepath.Glob(filepath.Join(storage, "message", "*", "*.json"))
+
+	var total tools.Usage
+	found := false
+	for _, f := range files {
+		if info, err := os.Stat(f); err != nil || info.ModTime().Before(since) {
+			continue
+		}
+		if u, ok := messageUsage(f, dir, since); ok {
+			total = total.Add(u)
+			found = true
+		}
+	}
+	return total, found
+}
+
+// message is the part of a stored message holding usage.
+type message struct {
+	Role string `json:"role"`
+	Time struct {
+		Created int64 `json:"created"` // Unix milliseconds
+	} `json:"time"`
+	Path struct {
+		Cwd string `json:"cwd"`
+	} `json:"path"`
+	Cost   float64 `json:"cost"`
+	Tokens *struct {
+		Input     int64 `json:"input"`
+		Output    int64 `json:"output"`
+		Reasoning int64 `json:"reasoning"`
+		Cache     struct {
+			Read  int64 `json:"read"`
+			Write int64 `json:"write"`
+		} `json:"cache"`
+	} `json:"tokens"`
+}
+
+// messageUsage returns the usage of a stored message if it is a response
+// in dir created since a time.
+func messageUsage(path, dir string, since time.Time) (tools.Usage, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return tools.Usage{}, false
+	}
+	var m message
+	if json.Unmarshal(data, &m) != nil || m.Role != "assistant" || m.Tokens == nil || m.Path.Cwd != dir || time.UnixMilli(m.Time.Created).Before(since) {
+		return tools.Usage{}, false
+	}
+	return tools.Usage{
+		InputTokens:      m.Tokens.Input,
+		OutputTokens:     m.Tokens.Output + m.Tokens.Reasoning,
+		CacheReadTokens:  m.Tokens.Cache.Read,
+		CacheWriteTokens: m.Tokens.Cache.Write,
+		Cost:             m.Cost,
+	}, true
+}
@@ -6,11 +6,13 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/adrg/xdg"
 	"github.com/leighmcculloch/silo/config"
+	"github.com/leighmcculloch/silo/fileutil"
 )
 
 // Tool defines a self-contained tool that can be run inside a silo container.
@@ -19,8 +21,110 @@ type Tool struct {
 	Description     string                           // human-readable (e.g. "Claude Code - Anthropic's CLI")
 	DockerfileStage string                           // Dockerfile fragment (FROM base AS <name> ...)
 	Command         func(home string) []string       // container entrypoint + args
+	PermissionFlags []string                         // flags of Command that skip the tool's permission prompts
 	DefaultConfig   func() config.ToolConfig         // default mounts/env/hooks
 	LatestVersion   func(ctx context.Context) string // optional: returns latest version string for cache-busting
+	Auth            func() Auth                      // optional: where the tool finds its credentials
+	Usage           UsageFunc                        // optional: reads token usage from the tool's local logs
+	Instructions    Instructions                     // which agent instruction files the tool reads
+	MCP             MCPFunc                          // optional: makes the tool use configured MCP servers
+	Headless        func(prompt string) []string     // optional: args running the tool once on prompt, without interaction
+}
+
+// MCPFunc returns servers in a tool's own MCP config format, which silo
+// writes to path, a private file the tool can read, and the environment
+// variables (KEY=VALUE) and arguments that make the tool use them in
+// addition to those it is configured with. Paths in servers are already
+// expanded. Since servers can hold tokens, they go in the file rather than
+// the environment or arguments, which can be seen in the container's config.
+type MCPFunc func(servers map[string]config.MCPServer, path string) (config []byte, env, args []string)
+
+// InstructionFiles are the agent instruction files a project can have,
+// relative to its directory, which silo makes every tool see.
+var InstructionFiles = []string{
+	"AGENTS.md",
+	"CLAUDE.md",
+	".github/copilot-instructions.md",
+	".cursorrules",
+}
+
+// Instructions describes how a tool reads a project's agent instruction
+// files.
+type Instructions struct {
+	// Reads are the InstructionFiles the tool reads on its own
+	Reads []string
+
+	// Include returns the environment variables (KEY=VALUE) and arguments
+	// that make the tool read files, the paths of instruction files it
+	// doesn't read on its own. Optional.
+	Include func(files []string) (env, args []string)
+}
+
+// UsageFunc returns the token usage of the tool's sessions in dir since a
+// time, read from the logs the tool keeps in its config directories, and
+// whether any were found. resolve returns where a host path is now, which
+// differs from the path for copies of ephemeral mounts.
+type UsageFunc func(resolve func(path string) string, dir string, since time.Time) (Usage, bool)
+
+// Usage is the token usage of tool sessions.
+type Usage struct {
+	InputTokens      int64
+	OutputTokens     int64
+	CacheReadTokens  int64
+	CacheWriteTokens int64
+
+	// Cost is in USD, zero if the tool doesn't record it
+	Cost float64
+}
+
+// Add returns the sum of u and o.
+func (u Usage) Add(o Usage) Usage {
+	return Usage{
+		InputTokens:      u.InputTokens + o.InputTokens,
+		OutputTokens:     u.OutputTokens + o.OutputTokens,
+		CacheReadTokens:  u.CacheReadTokens + o.CacheReadTokens,
+		CacheWriteTokens: u.CacheWriteTokens + o.CacheWriteTokens,
+		Cost:             u.Cost + o.Cost,
+	}
+}
+
+// Auth describes where a tool finds its credentials, so that a missing login
+// can be reported before the container starts rather than mid-session.
+type Auth struct {
+	// Env are environment variables that each provide credentials on their own.
+	Env []string
+
+	// Files are host paths whose existence indicates a completed login. A
+	// leading "~/" is expanded to the user's home directory.
+	Files []string
+
+	// LoginCommand optionally runs the tool's interactive login flow inside
+	// the container before the tool itself starts.
+	LoginCommand []string
+
+	// Hint tells the user how to log in.
+	Hint string
+}
+
+// LoggedIn reports whether credentials are available, either from one of the
+// container environment variables (KEY=VALUE) in env or from a file on the
+// host.
+func (a Auth) LoggedIn(env []string) bool {
+	for _, e := range env {
+		key, val, _ := strings.Cut(e, "=")
+		if val != "" && slices.Contains(a.Env, key) {
+			return true
+		}
+	}
+	for _, f := range a.Files {
+		if rest, ok := strings.CutPrefix(f, "~/"); ok {
+			f = filepath.Join(os.Getenv("HOME"), rest)
+		}
+		if _, err := os.Stat(f); err == nil {
+			return true
+		}
+	}
+	return false
 }
 
 // FetchVersion fetches the latest version and writes it to the cache. Intended
@@ -43,7 +147,7 @@ func (t Tool) FetchVersion(ctx context.Context) {
 	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
 		return
 	}
-	_ = os.WriteFile(p, []byte(version), 0o644)
+	_ = fileutil.WriteFile(p, []byte(version), 0o644)
 }
 
 // CachedVersion reads the cached version for this tool. Returns "" if no cache
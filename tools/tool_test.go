@@ -79,3 +79,36 @@ func TestFetchVersionNilFunc(t *testing.T) {
 		t.Errorf("CachedVersion = %q, want empty string for tool with no LatestVersion", got)
 	}
 }
+
+func TestAuthLoggedIn(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	auth := Auth{
+		Env:   []string{"API_KEY"},
+		Files: []string{"~/.tool/credentials.json"},
+	}
+
+	if auth.LoggedIn(nil) {
+		t.Error("LoggedIn = true with no credentials")
+	}
+	if auth.LoggedIn([]string{"API_KEY="}) {
+		t.Error("LoggedIn = true with empty env var")
+	}
+	if auth.LoggedIn([]string{"OTHER=x"}) {
+		t.Error("LoggedIn = true with unrelated env var")
+	}
+	if !auth.LoggedIn([]string{"API_KEY=x"}) {
+		t.Error("LoggedIn = false with env var set")
+	}
+
+	if err := os.MkdirAll(filepath.Join(home, ".tool"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".tool", "credentials.json"), []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if !auth.LoggedIn(nil) {
+		t.Error("LoggedIn = false with credentials file")
+	}
+}